@@ -0,0 +1,139 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command echovault-check-aof validates an AOF log file and repairs it offline.
+//
+// Usage:
+//
+//	echovault-check-aof [-fix] [-policy truncate|skip] <data-dir>/aof/incr.<seq>.aof
+//
+// The AOF directory holds a base file and one or more incremental files, which are listed in manifest.bin.
+// Only the incremental files hold framed records and can be checked with this tool.
+//
+// Without -fix, the exit status is 1 if the file is corrupted. With -fix, the file is rewritten with the
+// records that the policy keeps: "truncate" keeps the records before the first corruption and "skip"
+// keeps every valid record. Files written before records were framed are converted to the framed format.
+// Encrypted files are checked and repaired without the encryption key, as the checksums cover the sealed records.
+package main
+
+import (
+	"flag"
+	"fmt"
+	logstore "github.com/echovault/echovault/internal/aof/log"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "Rewrite the file with the records kept by the policy.")
+	policy := flag.String("policy", logstore.PolicyTruncate,
+		"The records to keep when fixing a corrupted file. The options are 'truncate' and 'skip'.")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !strings.EqualFold(*policy, logstore.PolicyTruncate) && !strings.EqualFold(*policy, logstore.PolicySkip) {
+		log.Fatalf("unknown policy %s", *policy)
+	}
+
+	file := flag.Arg(0)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	result := logstore.Check(data)
+	report(file, int64(len(data)), result)
+
+	if !*fix {
+		if len(result.Corruptions) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(result.Corruptions) == 0 && !result.Legacy {
+		fmt.Println("nothing to fix")
+		return
+	}
+
+	kept := result.Kept(*policy)
+	if err = writeFile(file, result.Rewrite(kept)); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("rewrote %s with %d of %d records\n", file, len(kept), len(result.Records))
+}
+
+func report(file string, size int64, result logstore.CheckResult) {
+	format := "framed"
+	if result.Legacy {
+		format = "legacy (no checksums)"
+	}
+	if result.Encrypted() {
+		format = "encrypted framed"
+	}
+	fmt.Printf("%s: %d bytes, %s format, %d records\n", file, size, format, len(result.Records))
+
+	var first, last int64
+	for _, record := range result.Records {
+		if record.Timestamp == 0 {
+			continue
+		}
+		if first == 0 {
+			first = record.Timestamp
+		}
+		last = record.Timestamp
+	}
+	if first != 0 {
+		fmt.Printf("records appended between %s and %s\n",
+			time.UnixMilli(first).UTC().Format(time.RFC3339Nano), time.UnixMilli(last).UTC().Format(time.RFC3339Nano))
+	}
+
+	for _, corruption := range result.Corruptions {
+		fmt.Println(corruption.Error())
+	}
+	if len(result.Corruptions) == 0 {
+		fmt.Println("no corruption found")
+	} else {
+		fmt.Printf("valid up to offset %d\n", result.ValidSize(size))
+	}
+}
+
+// writeFile replaces the file through a temporary file so that an interrupted fix leaves the original in place.
+func writeFile(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command rdbconvert converts Redis RDB files to EchoVault snapshot files and back.
+//
+// Usage:
+//
+//	rdbconvert -from rdb -in dump.rdb -out state.bin [-compression gzip]
+//	rdbconvert -from snapshot -in state.bin -out dump.rdb
+//
+// Encrypted snapshots are read and written with the keys passed with -encryption-key-file or -encryption-key-env,
+// which take the same keys as the server. RDB files are never encrypted.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	_ "github.com/echovault/echovault/internal/bloom"
+	_ "github.com/echovault/echovault/internal/cms"
+	_ "github.com/echovault/echovault/internal/cuckoo"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/rdb"
+	"github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/internal/snapshot"
+	_ "github.com/echovault/echovault/internal/timeseries"
+	_ "github.com/echovault/echovault/internal/topk"
+	"log"
+	"os"
+	"time"
+)
+
+func main() {
+	from := flag.String("from", "rdb", "The format of the input file. The options are 'rdb' and 'snapshot'.")
+	in := flag.String("in", "", "Path to the input file.")
+	out := flag.String("out", "", "Path to the output file.")
+	compression := flag.String("compression", snapshot.CompressionNone,
+		"The compression of the snapshot file written when converting from rdb. The options are 'none' and 'gzip'.")
+	encryptionKeyFile := flag.String("encryption-key-file", "", "Path to a file with the keys of encrypted snapshots.")
+	encryptionKeyEnv := flag.String("encryption-key-env", "", "The name of an environment variable with the keys of encrypted snapshots.")
+	flag.Parse()
+
+	if *in == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	keyring, err := encryption.LoadKeyring(*encryptionKeyFile, *encryptionKeyEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch *from {
+	case "rdb":
+		err = rdbToSnapshot(*in, *out, *compression, keyring)
+	case "snapshot":
+		err = snapshotToRDB(*in, *out, keyring)
+	default:
+		err = fmt.Errorf("unknown input format %s", *from)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func rdbToSnapshot(in, out, compression string, keyring *encryption.Keyring) error {
+	src, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	now := time.Now()
+	state := make(map[string]internal.KeyData)
+	if err = rdb.Load(src, now, func(key string, data internal.KeyData) {
+		state[key] = data
+	}); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	w, err := keyring.NewWriter(dst)
+	var encoder *snapshot.Encoder
+	if err == nil {
+		encoder, err = snapshot.NewEncoder(w, compression)
+	}
+	if err == nil {
+		err = encoder.WriteMeta(now.UnixMilli())
+	}
+	if err == nil {
+		err = encoder.WriteState(state)
+	}
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("converted %d keys\n", len(state))
+	return nil
+}
+
+func snapshotToRDB(in, out string, keyring *encryption.Keyring) error {
+	src, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	r, err := keyring.NewReader(src)
+	if err != nil {
+		return err
+	}
+
+	state := make(map[string]internal.KeyData)
+	if _, err = snapshot.Load(r, func(schemas []search.Schema) {}, func(key string, data internal.KeyData) {
+		state[key] = data
+	}); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	skipped, err := rdb.Save(dst, time.Now(), state)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(skipped) > 0 {
+		log.Printf("skipped keys with no RDB representation: %v\n", skipped)
+	}
+	log.Printf("converted %d keys\n", len(state)-len(skipped))
+	return nil
+}
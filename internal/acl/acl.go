@@ -440,6 +440,46 @@ func (acl *ACL) AuthorizeConnection(conn *net.Conn, cmd []string, command types.
 	return nil
 }
 
+// AuthorizeKeys checks keys that a command resolves while it runs, such as the documents returned by an index
+// query, against the key patterns of the connection's user. Unlike the keys of AuthorizeConnection, every key
+// must be allowed.
+func (acl *ACL) AuthorizeKeys(conn *net.Conn, readKeys []string, writeKeys []string) error {
+	acl.RLockUsers()
+	defer acl.RUnlockUsers()
+
+	if !acl.Config.RequirePass || len(readKeys)+len(writeKeys) == 0 {
+		return nil
+	}
+
+	connection := acl.Connections[conn]
+	if !connection.Authenticated {
+		return errors.New("user must be authenticated")
+	}
+	if connection.User.NoKeys {
+		return errors.New("not authorised to access any keys")
+	}
+
+	var notAllowed []string
+	for _, key := range readKeys {
+		if !slices.ContainsFunc(connection.User.IncludedReadKeys, func(readKeyGlob string) bool {
+			return acl.GlobPatterns[readKeyGlob].Match(key)
+		}) {
+			notAllowed = append(notAllowed, fmt.Sprintf("%s~%s", "%R", key))
+		}
+	}
+	for _, key := range writeKeys {
+		if !slices.ContainsFunc(connection.User.IncludedWriteKeys, func(writeKeyGlob string) bool {
+			return acl.GlobPatterns[writeKeyGlob].Match(key)
+		}) {
+			notAllowed = append(notAllowed, fmt.Sprintf("%s~%s", "%W", key))
+		}
+	}
+	if len(notAllowed) > 0 {
+		return fmt.Errorf("not authorised to access the following keys %+v", notAllowed)
+	}
+	return nil
+}
+
 func (acl *ACL) CompileGlobs() {
 	// Extract all the relevant globs from all the users
 	var allGlobs []string
@@ -20,8 +20,16 @@ import (
 	logstore "github.com/echovault/echovault/internal/aof/log"
 	"github.com/echovault/echovault/internal/aof/preamble"
 	"github.com/echovault/echovault/internal/clock"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/pkg/types"
 	"log"
+	"os"
+	"path"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // This package handles AOF logging in standalone mode only.
@@ -30,21 +38,38 @@ import (
 type Engine struct {
 	clock        clock.Clock
 	syncStrategy string
+	policy       string
 	directory    string
-	preambleRW   preamble.PreambleReadWriter
-	appendRW     logstore.AppendReadWriter
+	keyring      *encryption.Keyring // Encrypts new files when set.
 
-	mut           sync.Mutex
-	logChan       chan []byte
-	logCount      uint64
-	preambleStore *preamble.PreambleStore
-	appendStore   *logstore.AppendStore
+	mut         sync.Mutex // Held for the duration of a rewrite. Guards the manifest.
+	manifest    Manifest
+	logChan     chan logRequest
+	appendStore *logstore.AppendStore // The store for the last incremental file. Only used by the log goroutine after startup.
+
+	rewritePercent uint64       // Growth over the base size that triggers a rewrite. 0 disables automatic rewrites.
+	rewriteMinSize uint64       // Size below which the AOF is not rewritten automatically.
+	rewriting      atomic.Bool  // True from the moment a rewrite is triggered until it finishes.
+	size           atomic.Int64 // Size of the files listed in the manifest.
+	baseSize       atomic.Int64 // Size of the files after the last rewrite, or on startup.
+	statusMut      sync.Mutex
+	status         types.AOFStatus
 
 	startRewriteFunc  func()
 	finishRewriteFunc func()
-	getStateFunc      func() map[string]internal.KeyData
+	getStateFunc      func(cutover func()) map[string]internal.KeyData
 	setKeyDataFunc    func(key string, data internal.KeyData)
 	handleCommand     func(command []byte)
+
+	getIndexesFunc     func() []search.Schema
+	restoreIndexesFunc func(schemas []search.Schema)
+}
+
+// logRequest is processed by the log goroutine in the order that it was queued.
+type logRequest struct {
+	command []byte                // The command to append to the current incremental file.
+	store   *logstore.AppendStore // When set, the current incremental file is closed and later commands are appended to this store.
+	done    chan error            // When set, receives the result once the current incremental file is synced.
 }
 
 func WithClock(clock clock.Clock) func(engine *Engine) {
@@ -59,6 +84,29 @@ func WithStrategy(strategy string) func(engine *Engine) {
 	}
 }
 
+// WithCorruptionPolicy sets how corrupted AOF records are handled on restore.
+// The options are "truncate", "skip" and "fail".
+func WithCorruptionPolicy(policy string) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.policy = policy
+	}
+}
+
+// WithAutoRewrite rewrites the AOF automatically once it has grown by percent of its size after the last rewrite,
+// and is at least minSize bytes. A percent of 0 disables automatic rewrites.
+func WithAutoRewrite(percent uint64, minSize uint64) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.rewritePercent = percent
+		engine.rewriteMinSize = minSize
+	}
+}
+
+func WithKeyring(keyring *encryption.Keyring) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.keyring = keyring
+	}
+}
+
 func WithDirectory(directory string) func(engine *Engine) {
 	return func(engine *Engine) {
 		engine.directory = directory
@@ -77,7 +125,10 @@ func WithFinishRewriteFunc(f func()) func(engine *Engine) {
 	}
 }
 
-func WithGetStateFunc(f func() map[string]internal.KeyData) func(engine *Engine) {
+// WithGetStateFunc sets the function that copies the state for a new base file.
+// The function must call cutover exactly once while no write command can run, so that every command
+// that is not in the copy is appended to the new incremental file.
+func WithGetStateFunc(f func(cutover func()) map[string]internal.KeyData) func(engine *Engine) {
 	return func(engine *Engine) {
 		engine.getStateFunc = f
 	}
@@ -95,15 +146,15 @@ func WithHandleCommandFunc(f func(command []byte)) func(engine *Engine) {
 	}
 }
 
-func WithPreambleReadWriter(rw preamble.PreambleReadWriter) func(engine *Engine) {
+func WithGetIndexesFunc(f func() []search.Schema) func(engine *Engine) {
 	return func(engine *Engine) {
-		engine.preambleRW = rw
+		engine.getIndexesFunc = f
 	}
 }
 
-func WithAppendReadWriter(rw logstore.AppendReadWriter) func(engine *Engine) {
+func WithRestoreIndexesFunc(f func(schemas []search.Schema)) func(engine *Engine) {
 	return func(engine *Engine) {
-		engine.appendRW = rw
+		engine.restoreIndexesFunc = f
 	}
 }
 
@@ -111,85 +162,435 @@ func NewAOFEngine(options ...func(engine *Engine)) *Engine {
 	engine := &Engine{
 		clock:             clock.NewClock(),
 		syncStrategy:      "everysec",
+		policy:            logstore.PolicyTruncate,
 		directory:         "",
 		mut:               sync.Mutex{},
-		logChan:           make(chan []byte, 4096),
-		logCount:          0,
+		logChan:           make(chan logRequest, 4096),
 		startRewriteFunc:  func() {},
 		finishRewriteFunc: func() {},
-		getStateFunc:      func() map[string]internal.KeyData { return nil },
-		setKeyDataFunc:    func(key string, data internal.KeyData) {},
-		handleCommand:     func(command []byte) {},
+		getStateFunc: func(cutover func()) map[string]internal.KeyData {
+			cutover()
+			return nil
+		},
+		setKeyDataFunc: func(key string, data internal.KeyData) {},
+		handleCommand:  func(command []byte) {},
+
+		getIndexesFunc:     func() []search.Schema { return nil },
+		restoreIndexesFunc: func(schemas []search.Schema) {},
 	}
 
-	// Setup AOFEngine options first as these options are used
-	// when setting up the PreambleStore and AppendStore
 	for _, option := range options {
 		option(engine)
 	}
 
-	// Setup Preamble engine
-	engine.preambleStore = preamble.NewPreambleStore(
-		preamble.WithClock(engine.clock),
-		preamble.WithDirectory(engine.directory),
-		preamble.WithReadWriter(engine.preambleRW),
-		preamble.WithGetStateFunc(engine.getStateFunc),
-		preamble.WithSetKeyDataFunc(engine.setKeyDataFunc),
-	)
+	// Open the last incremental file listed in the manifest.
+	// Without a directory, the engine has no files and commands are discarded.
+	if engine.directory != "" {
+		if err := engine.open(); err != nil {
+			log.Println(fmt.Errorf("new aof engine -> open error: %+v", err))
+		}
+	}
+	if engine.appendStore == nil {
+		engine.appendStore = logstore.NewAppendStore(logstore.WithClock(engine.clock))
+	}
+
+	// Start the goroutine that writes queued commands to the current incremental file.
+	// The requests that are queued while a batch is written are gathered into the next batch,
+	// so that they share a single write and fsync.
+	go func() {
+		for request := range engine.logChan {
+			batch := []logRequest{request}
+		gather:
+			for {
+				select {
+				case request = <-engine.logChan:
+					batch = append(batch, request)
+				default:
+					break gather
+				}
+			}
+			engine.writeBatch(batch)
+		}
+	}()
+
+	return engine
+}
+
+func (engine *Engine) aofDirectory() string {
+	return path.Join(engine.directory, "aof")
+}
 
-	// Setup AOF log store engine
-	engine.appendStore = logstore.NewAppendStore(
+// open reads the manifest and opens the last incremental file, creating the first one if there is none.
+func (engine *Engine) open() error {
+	dir := engine.aofDirectory()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	if len(manifest.Incrementals) == 0 {
+		manifest.Seq += 1
+		manifest.Incrementals = []string{incrementalFileName(manifest.Seq)}
+	}
+
+	store, err := engine.openAppendStore(manifest.Incrementals[len(manifest.Incrementals)-1], engine.policy)
+	if err != nil {
+		return err
+	}
+	// New commands are appended to a new incremental file if the encryption key has been rotated
+	// or encryption has been enabled or disabled since the last incremental file was created.
+	if !store.UsesActiveKey() {
+		// The last incremental file can no longer be truncated on restore, so repair it now.
+		if err = store.Repair(); err != nil {
+			log.Println(fmt.Errorf("aof open -> repair incremental file error: %+v", err))
+		}
+		if err = store.Close(); err != nil {
+			return err
+		}
+		manifest.Seq += 1
+		manifest.Incrementals = append(manifest.Incrementals, incrementalFileName(manifest.Seq))
+		if store, err = engine.openAppendStore(incrementalFileName(manifest.Seq), engine.policy); err != nil {
+			return err
+		}
+	}
+	if err = writeManifest(dir, manifest); err != nil {
+		_ = store.Close()
+		return err
+	}
+
+	engine.manifest = manifest
+	engine.appendStore = store
+
+	var size int64
+	for _, file := range manifest.files() {
+		size += engine.fileSize(file)
+	}
+	engine.size.Store(size)
+	engine.baseSize.Store(size)
+	return nil
+}
+
+func (engine *Engine) fileSize(name string) int64 {
+	info, err := os.Stat(path.Join(engine.aofDirectory(), name))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (engine *Engine) openAppendStore(name string, policy string) (*logstore.AppendStore, error) {
+	f, err := os.OpenFile(path.Join(engine.aofDirectory(), name), os.O_RDWR|os.O_CREATE|os.O_APPEND, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return logstore.NewAppendStore(
 		logstore.WithClock(engine.clock),
-		logstore.WithDirectory(engine.directory),
 		logstore.WithStrategy(engine.syncStrategy),
-		logstore.WithReadWriter(engine.appendRW),
+		logstore.WithCorruptionPolicy(policy),
+		logstore.WithReadWriter(f),
 		logstore.WithHandleCommandFunc(engine.handleCommand),
-	)
+		logstore.WithKeyring(engine.keyring),
+	), nil
+}
 
-	// 3. Start the goroutine to pick up queued commands in order to write them to the file.
-	// LogCommand will get the open file handler from the struct top perform the AOF operation.
-	go func() {
-		for {
-			c := <-engine.logChan
-			if err := engine.appendStore.Write(c); err != nil {
-				log.Println(fmt.Errorf("new aof engine error: %+v", err))
+// writeBatch writes the commands in the batch to the current incremental file and notifies the waiting requests
+// once the file is synced. The file is only synced once for all the requests between two store switches.
+func (engine *Engine) writeBatch(batch []logRequest) {
+	var commands [][]byte
+	var waiting []chan error
+
+	commit := func() {
+		n, err := engine.appendStore.Write(commands...)
+		engine.size.Add(int64(n))
+		if err == nil && len(waiting) > 0 && !strings.EqualFold(engine.syncStrategy, "always") {
+			// Write only syncs with the "always" strategy.
+			err = engine.appendStore.Sync()
+		}
+		if err != nil {
+			log.Println(fmt.Errorf("aof write error: %+v", err))
+		}
+		for _, done := range waiting {
+			done <- err
+		}
+		commands, waiting = nil, nil
+	}
+
+	for _, request := range batch {
+		if request.store != nil {
+			commit()
+			if err := engine.appendStore.Close(); err != nil {
+				log.Println(fmt.Errorf("aof write -> close incremental file error: %+v", err))
 			}
+			engine.appendStore = request.store
 		}
-	}()
+		if request.command != nil {
+			commands = append(commands, request.command)
+		}
+		if request.done != nil {
+			waiting = append(waiting, request.done)
+		}
+	}
+	commit()
 
-	return engine
+	if engine.shouldRewrite() && engine.rewriting.CompareAndSwap(false, true) {
+		go func() {
+			if err := engine.rewriteLog(true); err != nil {
+				log.Println(fmt.Errorf("auto rewrite log error: %+v", err))
+			}
+		}()
+	}
+}
+
+// shouldRewrite returns true if the AOF has grown enough since the last rewrite to be rewritten automatically.
+func (engine *Engine) shouldRewrite() bool {
+	if engine.rewritePercent == 0 || engine.directory == "" {
+		return false
+	}
+	size, base := engine.size.Load(), engine.baseSize.Load()
+	if size < int64(engine.rewriteMinSize) {
+		return false
+	}
+	return base == 0 || (size-base)*100 >= base*int64(engine.rewritePercent)
 }
 
-func (engine *Engine) QueueCommand(command []byte) {
-	engine.logChan <- command
+// QueueCommand queues the command to be appended to the current incremental file. Commands are written in the
+// order that they are queued. The returned function waits until the command is durable: with the "always"
+// strategy, it blocks until the fsync that covers the command completes. With the other strategies, it returns
+// immediately.
+func (engine *Engine) QueueCommand(command []byte) func() error {
+	if !strings.EqualFold(engine.syncStrategy, "always") {
+		engine.logChan <- logRequest{command: command}
+		return func() error { return nil }
+	}
+	done := make(chan error, 1)
+	engine.logChan <- logRequest{command: command, done: done}
+	return func() error {
+		return <-done
+	}
+}
+
+// Flush waits until the queued commands are written and synced to the current incremental file.
+func (engine *Engine) Flush() error {
+	done := make(chan error, 1)
+	engine.logChan <- logRequest{done: done}
+	return <-done
 }
 
+// RewriteLog compacts the AOF without blocking writes. New commands are appended to a new incremental
+// file while a base file holding the current state is written. The old base and incremental files are
+// removed once the manifest lists the new files.
 func (engine *Engine) RewriteLog() error {
+	return engine.rewriteLog(false)
+}
+
+func (engine *Engine) rewriteLog(auto bool) error {
 	engine.mut.Lock()
 	defer engine.mut.Unlock()
 
 	engine.startRewriteFunc()
 	defer engine.finishRewriteFunc()
 
-	// Create AOF preamble
-	if err := engine.preambleStore.CreatePreamble(); err != nil {
-		log.Println(fmt.Errorf("rewrite log -> create preamble error: %+v", err))
+	engine.rewriting.Store(true)
+	defer engine.rewriting.Store(false)
+
+	if engine.directory == "" {
+		return nil
+	}
+
+	start := engine.clock.Now()
+	engine.statusMut.Lock()
+	engine.status.RewriteInProgress = true
+	engine.status.RewriteStartTime = start.UnixMilli()
+	engine.statusMut.Unlock()
+
+	err := engine.rewrite()
+
+	end := engine.clock.Now()
+	engine.statusMut.Lock()
+	engine.status.RewriteInProgress = false
+	engine.status.RewriteStartTime = 0
+	engine.status.LastRewriteTime = end.UnixMilli()
+	engine.status.LastRewriteDuration = end.Sub(start).Milliseconds()
+	engine.status.LastRewriteError = ""
+	if err != nil {
+		engine.status.FailedRewrites += 1
+		engine.status.LastRewriteError = err.Error()
+	} else {
+		engine.status.Rewrites += 1
+		if auto {
+			engine.status.AutoRewrites += 1
+		}
+	}
+	engine.statusMut.Unlock()
+
+	// Measure the growth from the current size, so that a failed rewrite is only retried
+	// automatically once the AOF has grown again.
+	engine.baseSize.Store(engine.size.Load())
+
+	return err
+}
+
+func (engine *Engine) rewrite() error {
+
+	dir := engine.aofDirectory()
+	previous := engine.manifest
+	seq := previous.Seq + 1
+
+	// List the new incremental file in the manifest before any command is appended to it,
+	// so that the AOF can still be restored if the rewrite does not complete.
+	store, err := engine.openAppendStore(incrementalFileName(seq), engine.policy)
+	if err != nil {
+		return fmt.Errorf("rewrite log -> open incremental file error: %+v", err)
+	}
+	engine.size.Add(engine.fileSize(incrementalFileName(seq)))
+	manifest := Manifest{
+		Base:         previous.Base,
+		Incrementals: append(slices.Clone(previous.Incrementals), incrementalFileName(seq)),
+		Seq:          seq,
+	}
+	if err = writeManifest(dir, manifest); err != nil {
+		_ = store.Close()
+		_ = os.Remove(path.Join(dir, incrementalFileName(seq)))
+		return fmt.Errorf("rewrite log -> write manifest error: %+v", err)
+	}
+	engine.manifest = manifest
+
+	// Switch to the new incremental file while the state is copied.
+	switched := make(chan error, 1)
+	state := engine.getStateFunc(func() {
+		engine.logChan <- logRequest{store: store, done: switched}
+	})
+	if err = <-switched; err != nil {
+		log.Println(fmt.Errorf("rewrite log -> sync incremental file error: %+v", err))
+	}
+
+	// Write the base file.
+	f, err := os.OpenFile(path.Join(dir, baseFileName(seq)), os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("rewrite log -> open base file error: %+v", err)
+	}
+	preambleStore := preamble.NewPreambleStore(
+		preamble.WithClock(engine.clock),
+		preamble.WithReadWriter(f),
+		preamble.WithGetIndexesFunc(engine.getIndexesFunc),
+		preamble.WithKeyring(engine.keyring),
+	)
+	err = preambleStore.CreatePreamble(state)
+	if closeErr := preambleStore.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path.Join(dir, baseFileName(seq)))
+		return fmt.Errorf("rewrite log -> create base file error: %+v", err)
+	}
+
+	// Complete the rewrite by replacing the manifest.
+	manifest = Manifest{
+		Base:         baseFileName(seq),
+		Incrementals: []string{incrementalFileName(seq)},
+		Seq:          seq,
+	}
+	if err = writeManifest(dir, manifest); err != nil {
+		return fmt.Errorf("rewrite log -> write manifest error: %+v", err)
 	}
+	engine.manifest = manifest
 
-	// Truncate the AOF file.
-	if err := engine.appendStore.Truncate(); err != nil {
-		log.Println(fmt.Errorf("rewrite log -> create aof error: %+v", err))
+	engine.size.Add(engine.fileSize(baseFileName(seq)))
+	for _, file := range engine.unreferencedFiles(previous) {
+		size := engine.fileSize(file)
+		if err = os.Remove(path.Join(dir, file)); err != nil {
+			log.Println(fmt.Errorf("rewrite log -> remove file error: %+v", err))
+		}
+		engine.size.Add(-size)
 	}
 
 	return nil
 }
 
+// unreferencedFiles returns the files in the previous manifest that are not in the current one.
+func (engine *Engine) unreferencedFiles(previous Manifest) []string {
+	current := engine.manifest.files()
+	return slices.DeleteFunc(previous.files(), func(file string) bool {
+		return slices.Contains(current, file)
+	})
+}
+
+// Restore loads the base file and replays the incremental files.
+// Errors are returned so that a corrupted AOF can stop the server from starting.
 func (engine *Engine) Restore() error {
-	if err := engine.preambleStore.Restore(); err != nil {
-		log.Println(fmt.Errorf("restore aof -> restore preamble error: %+v", err))
+	engine.mut.Lock()
+	defer engine.mut.Unlock()
+
+	if engine.directory == "" {
+		return nil
+	}
+
+	dir := engine.aofDirectory()
+	manifest := engine.manifest
+
+	if manifest.Base != "" {
+		f, err := os.Open(path.Join(dir, manifest.Base))
+		if err != nil {
+			return fmt.Errorf("restore aof -> open base file error: %+v", err)
+		}
+		store := preamble.NewPreambleStore(
+			preamble.WithClock(engine.clock),
+			preamble.WithReadWriter(f),
+			preamble.WithSetKeyDataFunc(engine.setKeyDataFunc),
+			preamble.WithRestoreIndexesFunc(engine.restoreIndexesFunc),
+			preamble.WithKeyring(engine.keyring),
+		)
+		err = store.Restore()
+		_ = store.Close()
+		if err != nil {
+			return fmt.Errorf("restore aof -> restore base file error: %+v", err)
+		}
 	}
-	if err := engine.appendStore.Restore(); err != nil {
-		log.Println(fmt.Errorf("restore aof -> restore aof error: %+v", err))
+
+	for i, name := range manifest.Incrementals {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			return fmt.Errorf("restore aof -> incremental file error: %+v", err)
+		}
+
+		if i == len(manifest.Incrementals)-1 {
+			if err := engine.appendStore.Restore(); err != nil {
+				return fmt.Errorf("restore aof -> restore %s error: %+v", name, err)
+			}
+			continue
+		}
+
+		// Only the last incremental file can be truncated. Truncating an earlier file would drop
+		// commands that the files after it depend on.
+		policy := logstore.PolicyFail
+		if strings.EqualFold(engine.policy, logstore.PolicySkip) {
+			policy = logstore.PolicySkip
+		}
+		store, err := engine.openAppendStore(name, policy)
+		if err != nil {
+			return fmt.Errorf("restore aof -> open %s error: %+v", name, err)
+		}
+		err = store.Restore()
+		_ = store.Close()
+		if err != nil {
+			return fmt.Errorf("restore aof -> restore %s error: %+v", name, err)
+		}
 	}
+
 	return nil
 }
+
+// Status returns the size of the AOF and the progress and statistics of its rewrites.
+func (engine *Engine) Status() types.AOFStatus {
+	engine.statusMut.Lock()
+	status := engine.status
+	engine.statusMut.Unlock()
+
+	status.Size = engine.size.Load()
+	status.BaseSize = engine.baseSize.Load()
+	status.AutoRewritePercentage = engine.rewritePercent
+	status.AutoRewriteMinSize = engine.rewriteMinSize
+	return status
+}
@@ -0,0 +1,252 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal/encryption"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+)
+
+// The framed AOF file is laid out as follows:
+//
+//	header:  magic "EVAOF" | version (1 byte) | encryption header (version 2 only)
+//	record:  marker (2 bytes) | length (4 bytes) | timestamp (8 bytes) | CRC32-C (4 bytes) | command
+//
+// The length is the length of the command and the timestamp is the unix time in milliseconds when the
+// command was appended. The checksum covers the length, the timestamp and the command.
+// All integers are big endian. The marker allows the reader to find the next record after a corrupted one.
+//
+// In version 2, the commands are sealed with the data key in the encryption header. The checksums cover
+// the sealed commands, so an encrypted file can be checked and repaired without the key.
+
+const (
+	FileHeader          = "EVAOF\x01"
+	EncryptedFileHeader = "EVAOF\x02"
+	recordHeaderSize    = 18
+)
+
+var recordMarker = []byte{0xA0, 0xF5}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+const (
+	// PolicyTruncate replays the records before the first corruption and truncates the file there.
+	PolicyTruncate = "truncate"
+	// PolicySkip replays every valid record and skips the corrupted ones.
+	PolicySkip = "skip"
+	// PolicyFail refuses to replay a corrupted file.
+	PolicyFail = "fail"
+)
+
+// Record is a command read from a framed AOF file.
+type Record struct {
+	Offset    int64 // Offset of the record in the file
+	Timestamp int64 // Unix time in milliseconds when the command was appended
+	Command   []byte
+}
+
+// Corruption is a range of the file that does not hold valid records.
+type Corruption struct {
+	Offset int64
+	Length int64
+	Err    error
+}
+
+func (c Corruption) Error() string {
+	return fmt.Sprintf("corrupted AOF data at offset %d (%d bytes): %v", c.Offset, c.Length, c.Err)
+}
+
+// CheckResult describes the contents of an AOF file.
+type CheckResult struct {
+	Legacy      bool   // The file predates framed records. Legacy files have no checksums.
+	Header      []byte // The file header, including the encryption header of an encrypted file. Empty for legacy files.
+	Records     []Record
+	Corruptions []Corruption
+}
+
+// Encrypted reports whether the commands of the records are sealed.
+func (result CheckResult) Encrypted() bool {
+	return bytes.HasPrefix(result.Header, []byte(EncryptedFileHeader))
+}
+
+// ValidSize returns the size of the file up to the first corruption.
+func (result CheckResult) ValidSize(size int64) int64 {
+	if len(result.Corruptions) == 0 {
+		return size
+	}
+	return result.Corruptions[0].Offset
+}
+
+// Kept returns the records that are kept under the given policy. Under the skip policy, every valid record is kept.
+// Under the other policies, only the records before the first corruption are kept.
+func (result CheckResult) Kept(policy string) []Record {
+	if len(result.Corruptions) == 0 || strings.EqualFold(policy, PolicySkip) {
+		return result.Records
+	}
+	var kept []Record
+	for _, record := range result.Records {
+		if record.Offset > result.Corruptions[0].Offset {
+			break
+		}
+		kept = append(kept, record)
+	}
+	return kept
+}
+
+// Commands returns the commands to replay under the given policy.
+// Under the fail policy, the first corruption is returned as an error.
+func (result CheckResult) Commands(policy string) ([][]byte, error) {
+	if len(result.Corruptions) > 0 && strings.EqualFold(policy, PolicyFail) {
+		return nil, result.Corruptions[0]
+	}
+	var commands [][]byte
+	for _, record := range result.Kept(policy) {
+		commands = append(commands, record.Command)
+	}
+	return commands, nil
+}
+
+// AppendRecord appends a framed record holding the command to buf.
+func AppendRecord(buf []byte, timestamp int64, command []byte) []byte {
+	header := make([]byte, recordHeaderSize)
+	copy(header, recordMarker)
+	binary.BigEndian.PutUint32(header[2:6], uint32(len(command)))
+	binary.BigEndian.PutUint64(header[6:14], uint64(timestamp))
+	crc := crc32.Update(crc32.Checksum(header[2:14], crcTable), crcTable, command)
+	binary.BigEndian.PutUint32(header[14:18], crc)
+	return append(append(buf, header...), command...)
+}
+
+// Check reads the contents of an AOF file. Corrupted ranges are reported instead of returned as errors,
+// so that the caller can decide how to handle them.
+func Check(data []byte) CheckResult {
+	if len(data) == 0 {
+		return CheckResult{}
+	}
+	torn := CheckResult{Corruptions: []Corruption{{Offset: 0, Length: int64(len(data)), Err: io.ErrUnexpectedEOF}}}
+	if bytes.HasPrefix([]byte(FileHeader), data) || bytes.HasPrefix([]byte(EncryptedFileHeader), data) {
+		// The header itself was torn.
+		return torn
+	}
+
+	result := CheckResult{}
+	switch {
+	case bytes.HasPrefix(data, []byte(FileHeader)):
+		result.Header = data[:len(FileHeader)]
+	case bytes.HasPrefix(data, []byte(EncryptedFileHeader)):
+		n, err := encryption.HeaderLength(data[len(EncryptedFileHeader):])
+		if err != nil {
+			return torn
+		}
+		result.Header = data[:len(EncryptedFileHeader)+n]
+	default:
+		return CheckResult{Legacy: true, Records: readLegacy(data)}
+	}
+
+	pos := len(result.Header)
+	for pos < len(data) {
+		record, n, err := readRecord(data, pos)
+		if err == nil {
+			result.Records = append(result.Records, record)
+			pos += n
+			continue
+		}
+		// Find the next record that passes the checksum.
+		next := pos + 1
+		for ; next < len(data); next++ {
+			if _, _, err := readRecord(data, next); err == nil {
+				break
+			}
+		}
+		result.Corruptions = append(result.Corruptions, Corruption{
+			Offset: int64(pos), Length: int64(next - pos), Err: err,
+		})
+		pos = next
+	}
+	return result
+}
+
+func readRecord(data []byte, pos int) (Record, int, error) {
+	if len(data)-pos < recordHeaderSize {
+		return Record{}, 0, io.ErrUnexpectedEOF
+	}
+	header := data[pos : pos+recordHeaderSize]
+	if !bytes.Equal(header[:2], recordMarker) {
+		return Record{}, 0, errors.New("missing record marker")
+	}
+	length := int(binary.BigEndian.Uint32(header[2:6]))
+	if length > len(data)-pos-recordHeaderSize {
+		return Record{}, 0, io.ErrUnexpectedEOF
+	}
+	command := data[pos+recordHeaderSize : pos+recordHeaderSize+length]
+	crc := crc32.Update(crc32.Checksum(header[2:14], crcTable), crcTable, command)
+	if crc != binary.BigEndian.Uint32(header[14:18]) {
+		return Record{}, 0, errors.New("checksum mismatch")
+	}
+	return Record{
+		Offset:    int64(pos),
+		Timestamp: int64(binary.BigEndian.Uint64(header[6:14])),
+		Command:   command,
+	}, recordHeaderSize + length, nil
+}
+
+// readLegacy splits an AOF file written before records were framed. Commands are separated by blank lines.
+func readLegacy(data []byte) []Record {
+	buf := bufio.NewReader(bytes.NewReader(data))
+
+	var records []Record
+	var line []byte
+
+	for {
+		b, _, err := buf.ReadLine()
+		if err != nil {
+			break
+		}
+		if len(b) <= 0 {
+			line = append(line, []byte("\r\n\r\n")...)
+			records = append(records, Record{Command: line})
+			line = []byte{}
+			continue
+		}
+		if len(line) > 0 {
+			line = append(line, append([]byte("\r\n"), bytes.TrimLeft(b, "\x00")...)...)
+			continue
+		}
+		line = append(line, bytes.TrimLeft(b, "\x00")...)
+	}
+
+	return records
+}
+
+// Rewrite returns the framed form of the records with the header of the checked file.
+// Legacy records are given the timestamp 0.
+func (result CheckResult) Rewrite(records []Record) []byte {
+	out := []byte(FileHeader)
+	if !result.Legacy {
+		out = slices.Clone(result.Header)
+	}
+	for _, record := range records {
+		out = AppendRecord(out, record.Timestamp, record.Command)
+	}
+	return out
+}
@@ -15,15 +15,12 @@
 package log
 
 import (
-	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
 	"github.com/echovault/echovault/internal/clock"
+	"github.com/echovault/echovault/internal/encryption"
 	"io"
 	"log"
-	"os"
-	"path"
 	"strings"
 	"sync"
 	"time"
@@ -41,8 +38,13 @@ type AppendStore struct {
 	strategy      string               // Append file sync strategy. Can only be "always", "everysec", or "no
 	mut           sync.Mutex           // Store mutex
 	rw            AppendReadWriter     // The ReadWriter used to persist and load the log
-	directory     string               // The directory for the AOF file if we must create one
 	handleCommand func(command []byte) // Function to handle command read from AOF log after restore
+	policy        string               // How to handle corrupted records on restore. Can only be "truncate", "skip" or "fail"
+	done          chan struct{}        // Closed when the store is closed to stop the sync goroutine
+	keyring       *encryption.Keyring  // The keyring used to encrypt new files. Nil if encryption is disabled.
+	header        []byte               // The header of the file
+	dataKey       *encryption.DataKey  // The data key of an encrypted file
+	keyErr        error                // The reason the data key of an encrypted file could not be read
 }
 
 func WithClock(clock clock.Clock) func(store *AppendStore) {
@@ -63,44 +65,43 @@ func WithReadWriter(rw AppendReadWriter) func(store *AppendStore) {
 	}
 }
 
-func WithDirectory(directory string) func(store *AppendStore) {
+func WithHandleCommandFunc(f func(command []byte)) func(store *AppendStore) {
 	return func(store *AppendStore) {
-		store.directory = directory
+		store.handleCommand = f
 	}
 }
 
-func WithHandleCommandFunc(f func(command []byte)) func(store *AppendStore) {
+func WithCorruptionPolicy(policy string) func(store *AppendStore) {
 	return func(store *AppendStore) {
-		store.handleCommand = f
+		store.policy = policy
+	}
+}
+
+func WithKeyring(keyring *encryption.Keyring) func(store *AppendStore) {
+	return func(store *AppendStore) {
+		store.keyring = keyring
 	}
 }
 
 func NewAppendStore(options ...func(store *AppendStore)) *AppendStore {
 	store := &AppendStore{
 		clock:         clock.NewClock(),
-		directory:     "",
 		strategy:      "everysec",
 		rw:            nil,
 		mut:           sync.Mutex{},
 		handleCommand: func(command []byte) {},
+		policy:        PolicyTruncate,
+		done:          make(chan struct{}),
 	}
 
 	for _, option := range options {
 		option(store)
 	}
 
-	// If rw is nil, use a default file at the provided directory
-	if store.rw == nil && store.directory != "" {
-		// Create the directory if it does not exist
-		err := os.MkdirAll(path.Join(store.directory, "aof"), os.ModePerm)
-		if err != nil {
-			log.Println(fmt.Errorf("new append store -> mkdir error: %+v", err))
-		}
-		f, err := os.OpenFile(path.Join(store.directory, "aof", "log.aof"), os.O_RDWR|os.O_CREATE|os.O_APPEND, os.ModePerm)
-		if err != nil {
-			log.Println(fmt.Errorf("new append store -> open file error: %+v", err))
+	if store.rw != nil {
+		if err := store.prepare(); err != nil {
+			log.Println(fmt.Errorf("new append store -> prepare error: %+v", err))
 		}
-		store.rw = f
 	}
 
 	// Start another goroutine that takes handles syncing the content to the file system.
@@ -112,31 +113,51 @@ func NewAppendStore(options ...func(store *AppendStore)) *AppendStore {
 					log.Println(fmt.Errorf("new append store error: %+v", err))
 					break
 				}
-				<-store.clock.After(1 * time.Second)
+				select {
+				case <-store.done:
+					return
+				case <-store.clock.After(1 * time.Second):
+				}
 			}
 		}()
 	}
 	return store
 }
 
-func (store *AppendStore) Write(command []byte) error {
+// Write appends the commands to the log in a single write and returns the number of bytes written.
+// With the "always" strategy, the log is synced once after all the commands are written.
+func (store *AppendStore) Write(commands ...[]byte) (int, error) {
 	store.mut.Lock()
 	defer store.mut.Unlock()
 	// Skip operation if ReadWriter is not defined
-	if store.rw == nil {
-		return nil
+	if store.rw == nil || len(commands) == 0 {
+		return 0, nil
 	}
-	// Add new line before writing to AOF file.
-	out := append(command, []byte("\r\n")...)
-	if _, err := store.rw.Write(out); err != nil {
-		return err
+	if store.encrypted() && store.dataKey == nil {
+		return 0, store.keyErr
+	}
+	timestamp := store.clock.Now().UnixMilli()
+	var out []byte
+	for _, command := range commands {
+		if store.dataKey != nil {
+			sealed, err := store.dataKey.Seal(nil, command, nil)
+			if err != nil {
+				return 0, err
+			}
+			command = sealed
+		}
+		out = AppendRecord(out, timestamp, command)
+	}
+	n, err := store.rw.Write(out)
+	if err != nil {
+		return n, err
 	}
 	if strings.EqualFold(store.strategy, "always") {
-		if err := store.Sync(); err != nil {
-			return err
+		if err = store.rw.Sync(); err != nil {
+			return n, err
 		}
 	}
-	return nil
+	return n, nil
 }
 
 func (store *AppendStore) Sync() error {
@@ -152,53 +173,182 @@ func (store *AppendStore) Restore() error {
 	store.mut.Lock()
 	defer store.mut.Unlock()
 
-	buf := bufio.NewReader(store.rw)
-
-	var commands [][]byte
-	var line []byte
+	if store.rw == nil {
+		return nil
+	}
 
-	for {
-		b, _, err := buf.ReadLine()
-		if err != nil && errors.Is(err, io.EOF) {
-			break
-		} else if err != nil {
-			return err
-		}
-		if len(b) <= 0 {
-			line = append(line, []byte("\r\n\r\n")...)
-			commands = append(commands, line)
-			line = []byte{}
-			continue
-		}
-		if len(line) > 0 {
-			line = append(line, append([]byte("\r\n"), bytes.TrimLeft(b, "\x00")...)...)
-			continue
-		}
-		line = append(line, bytes.TrimLeft(b, "\x00")...)
+	commands, err := store.repair()
+	if err != nil {
+		return err
+	}
+	if store.encrypted() && store.dataKey == nil {
+		return store.keyErr
 	}
 
 	for _, c := range commands {
+		if store.dataKey != nil {
+			if c, err = store.dataKey.Open(c, nil); err != nil {
+				return err
+			}
+		}
 		store.handleCommand(c)
 	}
 
 	return nil
 }
 
-func (store *AppendStore) Truncate() error {
+// Repair handles the corrupted records like Restore does, without replaying the commands.
+func (store *AppendStore) Repair() error {
+	store.mut.Lock()
+	defer store.mut.Unlock()
+
+	if store.rw == nil {
+		return nil
+	}
+
+	_, err := store.repair()
+	return err
+}
+
+// repair reads the file and cuts it at the first corruption, unless the policy is "skip".
+// It returns the commands that are kept by the policy, which are still sealed if the file is encrypted.
+func (store *AppendStore) repair() ([][]byte, error) {
+	data, err := store.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	result := Check(data)
+	for _, corruption := range result.Corruptions {
+		log.Println(corruption)
+	}
+
+	commands, err := result.Commands(store.policy)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cut the file at the first corruption so that new records are appended after the last valid record.
+	if len(result.Corruptions) > 0 && !strings.EqualFold(store.policy, PolicySkip) {
+		size := result.ValidSize(int64(len(data)))
+		if size < int64(len(result.Header)) || len(result.Header) == 0 {
+			size = 0
+		}
+		if err = store.rw.Truncate(size); err != nil {
+			return nil, err
+		}
+		if _, err = store.rw.Seek(size, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			if err = store.writeHeader(); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("truncated AOF file to %d bytes\n", size)
+	}
+
+	return commands, nil
+}
+
+// prepare writes the file header to an empty file, reads the data key of an encrypted file and converts
+// a file written before records were framed.
+func (store *AppendStore) prepare() error {
 	store.mut.Lock()
 	defer store.mut.Unlock()
-	if err := store.rw.Truncate(0); err != nil {
+
+	data, err := store.readAll()
+	if err != nil {
 		return err
 	}
-	// Seek to the beginning of the file after truncating
-	if _, err := store.rw.Seek(0, 0); err != nil {
+	result := Check(data)
+	if len(data) > 0 && !result.Legacy {
+		store.header = result.Header
+		if result.Encrypted() {
+			store.dataKey, _, store.keyErr = store.keyring.ReadDataKey(result.Header[len(EncryptedFileHeader):])
+		}
+		return store.keyErr
+	}
+	if err = store.rw.Truncate(0); err != nil {
+		return err
+	}
+	if _, err = store.rw.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err = store.writeHeader(); err != nil {
 		return err
 	}
+	if len(result.Records) > 0 {
+		timestamp := int64(0)
+		var out []byte
+		for _, record := range result.Records {
+			command := record.Command
+			if store.dataKey != nil {
+				if command, err = store.dataKey.Seal(nil, command, nil); err != nil {
+					return err
+				}
+			}
+			out = AppendRecord(out, timestamp, command)
+		}
+		if _, err = store.rw.Write(out); err != nil {
+			return err
+		}
+	}
+	return store.rw.Sync()
+}
+
+// writeHeader writes the header of a new file. If the store has a keyring, the file is encrypted with a new
+// data key.
+func (store *AppendStore) writeHeader() error {
+	header := []byte(FileHeader)
+	store.dataKey = nil
+	if store.keyring != nil {
+		dataKey, encryptionHeader, err := store.keyring.NewDataKey()
+		if err != nil {
+			return err
+		}
+		header = append([]byte(EncryptedFileHeader), encryptionHeader...)
+		store.dataKey = dataKey
+	}
+	if _, err := store.rw.Write(header); err != nil {
+		return err
+	}
+	store.header = header
 	return nil
 }
 
+func (store *AppendStore) encrypted() bool {
+	return bytes.HasPrefix(store.header, []byte(EncryptedFileHeader))
+}
+
+// UsesActiveKey reports whether new commands are encrypted with the active key of the keyring,
+// or are not encrypted if the store has no keyring.
+func (store *AppendStore) UsesActiveKey() bool {
+	store.mut.Lock()
+	defer store.mut.Unlock()
+	if !store.encrypted() {
+		return store.keyring == nil
+	}
+	id, ok := encryption.KeyIDOf(store.header[len(EncryptedFileHeader):])
+	return ok && store.keyring != nil && id == store.keyring.ActiveKeyID()
+}
+
+func (store *AppendStore) readAll() ([]byte, error) {
+	if _, err := store.rw.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(store.rw)
+}
+
 func (store *AppendStore) Close() error {
 	store.mut.Lock()
 	defer store.mut.Unlock()
+	close(store.done)
+	if store.rw == nil {
+		return nil
+	}
+	if err := store.rw.Sync(); err != nil {
+		return err
+	}
 	return store.rw.Close()
 }
@@ -0,0 +1,120 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aof
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+// The AOF is split into a base file and incremental files, which are listed in the manifest.
+// The base file holds the state at the time of the last rewrite and the incremental files hold the
+// commands that were logged since then, oldest first. Commands are always appended to the last
+// incremental file. A rewrite starts a new incremental file and then writes a new base file in the
+// background. The rewrite is completed by replacing the manifest.
+
+const (
+	manifestFile = "manifest.bin"
+	// Files written before the AOF was split into multiple parts.
+	legacyPreambleFile = "preamble.bin"
+	legacyLogFile      = "log.aof"
+)
+
+type Manifest struct {
+	Base         string   // The base file. Empty if the AOF has never been rewritten.
+	Incrementals []string // The incremental files, oldest first.
+	Seq          uint64   // The sequence number of the latest incremental file.
+}
+
+func baseFileName(seq uint64) string {
+	return fmt.Sprintf("base.%d.bin", seq)
+}
+
+func incrementalFileName(seq uint64) string {
+	return fmt.Sprintf("incr.%d.aof", seq)
+}
+
+// files returns every file referenced by the manifest.
+func (manifest Manifest) files() []string {
+	var files []string
+	if manifest.Base != "" {
+		files = append(files, manifest.Base)
+	}
+	return append(files, manifest.Incrementals...)
+}
+
+// readManifest reads the manifest in dir. If there is no manifest, the files written before the AOF
+// was split into multiple parts are adopted.
+func readManifest(dir string) (Manifest, error) {
+	manifest := Manifest{}
+	b, err := os.ReadFile(path.Join(dir, manifestFile))
+	if err == nil {
+		if err = json.Unmarshal(b, &manifest); err != nil {
+			return manifest, fmt.Errorf("read aof manifest: %w", err)
+		}
+		return manifest, nil
+	}
+	if !os.IsNotExist(err) {
+		return manifest, err
+	}
+
+	if info, err := os.Stat(path.Join(dir, legacyPreambleFile)); err == nil && info.Size() > 0 {
+		manifest.Base = legacyPreambleFile
+	}
+	if _, err := os.Stat(path.Join(dir, legacyLogFile)); err == nil {
+		manifest.Incrementals = []string{legacyLogFile}
+	}
+	return manifest, nil
+}
+
+// writeManifest atomically replaces the manifest in dir.
+func writeManifest(dir string, manifest Manifest) error {
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, manifestFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path.Join(dir, manifestFile)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	// Sync the directory so that the rename survives a crash.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+	return d.Sync()
+}
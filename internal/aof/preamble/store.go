@@ -16,14 +16,13 @@ package preamble
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/echovault/echovault/internal"
 	"github.com/echovault/echovault/internal/clock"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/search"
 	"io"
-	"log"
-	"os"
-	"path"
 	"sync"
+	"time"
 )
 
 type PreambleReadWriter interface {
@@ -37,9 +36,11 @@ type PreambleStore struct {
 	clock          clock.Clock
 	rw             PreambleReadWriter
 	mut            sync.Mutex
-	directory      string
-	getStateFunc   func() map[string]internal.KeyData
 	setKeyDataFunc func(key string, data internal.KeyData)
+	keyring        *encryption.Keyring // Encrypts the preamble when set.
+
+	getIndexesFunc     func() []search.Schema
+	restoreIndexesFunc func(schemas []search.Schema)
 }
 
 func WithClock(clock clock.Clock) func(store *PreambleStore) {
@@ -54,64 +55,57 @@ func WithReadWriter(rw PreambleReadWriter) func(store *PreambleStore) {
 	}
 }
 
-func WithGetStateFunc(f func() map[string]internal.KeyData) func(store *PreambleStore) {
+func WithSetKeyDataFunc(f func(key string, data internal.KeyData)) func(store *PreambleStore) {
 	return func(store *PreambleStore) {
-		store.getStateFunc = f
+		store.setKeyDataFunc = f
 	}
 }
 
-func WithSetKeyDataFunc(f func(key string, data internal.KeyData)) func(store *PreambleStore) {
+func WithGetIndexesFunc(f func() []search.Schema) func(store *PreambleStore) {
 	return func(store *PreambleStore) {
-		store.setKeyDataFunc = f
+		store.getIndexesFunc = f
+	}
+}
+
+func WithRestoreIndexesFunc(f func(schemas []search.Schema)) func(store *PreambleStore) {
+	return func(store *PreambleStore) {
+		store.restoreIndexesFunc = f
 	}
 }
 
-func WithDirectory(directory string) func(store *PreambleStore) {
+func WithKeyring(keyring *encryption.Keyring) func(store *PreambleStore) {
 	return func(store *PreambleStore) {
-		store.directory = directory
+		store.keyring = keyring
 	}
 }
 
 func NewPreambleStore(options ...func(store *PreambleStore)) *PreambleStore {
 	store := &PreambleStore{
-		clock:     clock.NewClock(),
-		rw:        nil,
-		mut:       sync.Mutex{},
-		directory: "",
-		getStateFunc: func() map[string]internal.KeyData {
-			// No-Op by default
-			return nil
-		},
-		setKeyDataFunc: func(key string, data internal.KeyData) {},
+		clock:              clock.NewClock(),
+		rw:                 nil,
+		mut:                sync.Mutex{},
+		setKeyDataFunc:     func(key string, data internal.KeyData) {},
+		getIndexesFunc:     func() []search.Schema { return nil },
+		restoreIndexesFunc: func(schemas []search.Schema) {},
 	}
 
 	for _, option := range options {
 		option(store)
 	}
 
-	// If rw is nil, create the default
-	if store.rw == nil && store.directory != "" {
-		err := os.MkdirAll(path.Join(store.directory, "aof"), os.ModePerm)
-		if err != nil {
-			log.Println(fmt.Errorf("new preamble store -> mkdir error: %+v", err))
-		}
-		f, err := os.OpenFile(path.Join(store.directory, "aof", "preamble.bin"), os.O_RDWR|os.O_CREATE, os.ModePerm)
-		if err != nil {
-			log.Println(fmt.Errorf("new preamble store -> open file error: %+v", err))
-		}
-		store.rw = f
-	}
-
 	return store
 }
 
-func (store *PreambleStore) CreatePreamble() error {
+// CreatePreamble replaces the contents of the preamble with the given state.
+func (store *PreambleStore) CreatePreamble(state map[string]internal.KeyData) error {
 	store.mut.Lock()
-	store.mut.Unlock()
+	defer store.mut.Unlock()
 
-	// Get current state.
-	state := store.filterExpiredKeys(store.getStateFunc())
-	o, err := json.Marshal(state)
+	preamble := internal.SnapshotObject{
+		State:   store.filterExpiredKeys(state),
+		Indexes: store.getIndexesFunc(),
+	}
+	o, err := json.Marshal(preamble)
 	if err != nil {
 		return err
 	}
@@ -125,7 +119,14 @@ func (store *PreambleStore) CreatePreamble() error {
 		return err
 	}
 
-	if _, err = store.rw.Write(o); err != nil {
+	w, err := store.keyring.NewWriter(store.rw)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(o); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
 		return err
 	}
 
@@ -142,7 +143,11 @@ func (store *PreambleStore) Restore() error {
 		return nil
 	}
 
-	b, err := io.ReadAll(store.rw)
+	r, err := store.keyring.NewReader(store.rw)
+	if err != nil {
+		return err
+	}
+	b, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
@@ -151,13 +156,22 @@ func (store *PreambleStore) Restore() error {
 		return nil
 	}
 
-	state := make(map[string]internal.KeyData)
-
-	if err = json.Unmarshal(b, &state); err != nil {
+	preamble := internal.SnapshotObject{}
+	if err = json.Unmarshal(b, &preamble); err != nil {
 		return err
 	}
 
-	for key, data := range store.filterExpiredKeys(state) {
+	// Preambles written before index definitions were persisted only contain the state map.
+	if preamble.State == nil {
+		preamble.State = make(map[string]internal.KeyData)
+		if err = json.Unmarshal(b, &preamble.State); err != nil {
+			return err
+		}
+	}
+
+	store.restoreIndexesFunc(preamble.Indexes)
+
+	for key, data := range store.filterExpiredKeys(preamble.State) {
 		store.setKeyDataFunc(key, data)
 	}
 
@@ -174,7 +188,8 @@ func (store *PreambleStore) Close() error {
 func (store *PreambleStore) filterExpiredKeys(state map[string]internal.KeyData) map[string]internal.KeyData {
 	var keysToDelete []string
 	for k, v := range state {
-		if v.ExpireAt.Before(store.clock.Now()) {
+		// Keys without an expiry have a zero ExpireAt.
+		if v.ExpireAt != (time.Time{}) && v.ExpireAt.Before(store.clock.Now()) {
 			keysToDelete = append(keysToDelete, k)
 		}
 	}
@@ -0,0 +1,598 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/clock"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/internal/snapshot"
+	"github.com/echovault/echovault/pkg/types"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This package contains the backup engine. A full backup holds the whole state. An incremental backup holds
+// the keys that changed since the previous backup, and a differential backup holds the keys that changed since
+// the previous full backup. The backup that a delta is relative to is its parent.
+//
+// The changed keys are tracked in memory from the first full backup taken since startup, so the first backup
+// after startup is always a full backup. The backup directory holds:
+//
+//	manifest.json     the backups, oldest first, with their type, parent and the SHA-256 checksum of their file
+//	backup-<id>.bin   a backup in the snapshot format, encrypted when a keyring is set. The changed keys that
+//	                  were deleted are written as delete records.
+
+const (
+	TypeFull         = "full"
+	TypeIncremental  = "incremental"
+	TypeDifferential = "differential"
+)
+
+type Manifest struct {
+	Backups []types.BackupInfo
+}
+
+type Engine struct {
+	clock              clock.Clock
+	directory          string
+	compression        string
+	keyring            *encryption.Keyring // Encrypts new backups when set.
+	getStateFunc       func(cutover func() (keys []string, all bool)) map[string]internal.KeyData
+	getIndexesFunc     func() []search.Schema
+	restoreIndexesFunc func(schemas []search.Schema)
+	setKeyDataFunc     func(key string, data internal.KeyData)
+	deleteKeyFunc      func(key string)
+	inProgress         atomic.Bool // True while a backup is taken or restored.
+
+	// The keys that changed since the latest backups taken since startup.
+	changes struct {
+		tracking  atomic.Bool // Checked before locking the mutex, as nothing is tracked until the first full backup.
+		mutex     sync.Mutex
+		sinceLast map[string]struct{}
+		sinceFull map[string]struct{}
+		last      int64 // The ID of the latest backup. 0 if no full backup was taken since startup.
+		full      int64 // The ID of the latest full backup. 0 if no full backup was taken since startup.
+	}
+}
+
+func WithClock(clock clock.Clock) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.clock = clock
+	}
+}
+
+func WithDirectory(directory string) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.directory = directory
+	}
+}
+
+// WithCompression sets the compression of the backup files. The options are the snapshot compressions.
+func WithCompression(compression string) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.compression = compression
+	}
+}
+
+func WithKeyring(keyring *encryption.Keyring) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.keyring = keyring
+	}
+}
+
+// WithGetStateFunc sets the function that copies the state for a backup. It calls cutover between write
+// commands, which returns the keys to copy, or all as true to copy every key. Keys that do not exist are left out.
+func WithGetStateFunc(f func(cutover func() (keys []string, all bool)) map[string]internal.KeyData) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.getStateFunc = f
+	}
+}
+
+func WithGetIndexesFunc(f func() []search.Schema) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.getIndexesFunc = f
+	}
+}
+
+func WithRestoreIndexesFunc(f func(schemas []search.Schema)) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.restoreIndexesFunc = f
+	}
+}
+
+func WithSetKeyDataFunc(f func(key string, data internal.KeyData)) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.setKeyDataFunc = f
+	}
+}
+
+func WithDeleteKeyFunc(f func(key string)) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.deleteKeyFunc = f
+	}
+}
+
+func NewBackupEngine(options ...func(engine *Engine)) *Engine {
+	engine := &Engine{
+		clock:       clock.NewClock(),
+		directory:   "",
+		compression: snapshot.CompressionNone,
+		getStateFunc: func(cutover func() ([]string, bool)) map[string]internal.KeyData {
+			cutover()
+			return map[string]internal.KeyData{}
+		},
+		getIndexesFunc:     func() []search.Schema { return nil },
+		restoreIndexesFunc: func(schemas []search.Schema) {},
+		setKeyDataFunc:     func(key string, data internal.KeyData) {},
+		deleteKeyFunc:      func(key string) {},
+	}
+
+	for _, option := range options {
+		option(engine)
+	}
+
+	return engine
+}
+
+// KeyChanged records that the key was locked for writing, so it may have been set, changed or deleted.
+func (engine *Engine) KeyChanged(key string) {
+	if !engine.changes.tracking.Load() {
+		return
+	}
+	engine.changes.mutex.Lock()
+	defer engine.changes.mutex.Unlock()
+	engine.changes.sinceLast[key] = struct{}{}
+	engine.changes.sinceFull[key] = struct{}{}
+}
+
+// Create takes a backup of the given type and returns it. An incremental or differential backup is taken as
+// a full backup if no full backup was taken since startup.
+func (engine *Engine) Create(backupType string) (types.BackupInfo, error) {
+	if !slices.Contains([]string{TypeFull, TypeIncremental, TypeDifferential}, backupType) {
+		return types.BackupInfo{}, fmt.Errorf("unknown backup type %s", backupType)
+	}
+	if !engine.inProgress.CompareAndSwap(false, true) {
+		return types.BackupInfo{}, errors.New("backup already in progress")
+	}
+	defer engine.inProgress.Store(false)
+
+	if err := os.MkdirAll(engine.directory, os.ModePerm); err != nil {
+		return types.BackupInfo{}, err
+	}
+	manifest, err := engine.readManifest()
+	if err != nil {
+		return types.BackupInfo{}, err
+	}
+
+	info := types.BackupInfo{ID: 1, Type: backupType, Time: engine.clock.Now().UnixMilli()}
+	if n := len(manifest.Backups); n > 0 {
+		info.ID = manifest.Backups[n-1].ID + 1
+	}
+
+	var keys []string
+	var discard func()
+	state := engine.getStateFunc(func() ([]string, bool) {
+		var all bool
+		keys, all, discard = engine.cutover(&info)
+		return keys, all
+	})
+	if info.Type == TypeFull {
+		keys = make([]string, 0, len(state))
+		for key := range state {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	if err = engine.writeBackup(&info, keys, state); err != nil {
+		discard()
+		return types.BackupInfo{}, err
+	}
+
+	manifest.Backups = append(manifest.Backups, info)
+	if err = engine.writeManifest(manifest); err != nil {
+		discard()
+		return types.BackupInfo{}, err
+	}
+
+	log.Printf("created %s backup %d\n", info.Type, info.ID)
+
+	return info, nil
+}
+
+// cutover starts tracking the changes for the backups after the given one, and sets its type and parent.
+// It returns the changed keys that the backup holds, or all as true for a full backup, and a function that
+// puts the changes back if the backup fails.
+func (engine *Engine) cutover(info *types.BackupInfo) ([]string, bool, func()) {
+	changes := &engine.changes
+	changes.mutex.Lock()
+	defer changes.mutex.Unlock()
+
+	sinceLast, sinceFull, last, full := changes.sinceLast, changes.sinceFull, changes.last, changes.full
+	discard := func() {
+		changes.mutex.Lock()
+		defer changes.mutex.Unlock()
+		if full == 0 {
+			changes.tracking.Store(false)
+			changes.sinceLast, changes.sinceFull, changes.last, changes.full = nil, nil, 0, 0
+			return
+		}
+		for key := range sinceLast {
+			changes.sinceLast[key] = struct{}{}
+		}
+		for key := range sinceFull {
+			changes.sinceFull[key] = struct{}{}
+		}
+		changes.last, changes.full = last, full
+	}
+
+	if full == 0 {
+		info.Type = TypeFull
+	}
+
+	changes.sinceLast = make(map[string]struct{})
+	changes.last = info.ID
+	switch info.Type {
+	case TypeFull:
+		changes.sinceFull = make(map[string]struct{})
+		changes.full = info.ID
+		changes.tracking.Store(true)
+		return nil, true, discard
+	case TypeIncremental:
+		info.Parent = last
+		return setKeys(sinceLast), false, discard
+	default:
+		info.Parent = full
+		return setKeys(sinceFull), false, discard
+	}
+}
+
+func setKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// writeBackup writes the keys of the backup in the given order and moves the file into the backup directory.
+// Keys that are not in the state, or that have expired, are written as deleted in a delta.
+func (engine *Engine) writeBackup(info *types.BackupInfo, keys []string, state map[string]internal.KeyData) error {
+	f, err := os.CreateTemp(engine.directory, "backup-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// The temporary file no longer exists once it has been moved into place.
+		_ = os.Remove(f.Name())
+	}()
+
+	digest := sha256.New()
+	err = engine.encode(io.MultiWriter(f, digest), info, keys, state)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(f.Name())
+	if err != nil {
+		return err
+	}
+	info.Size = stat.Size()
+	info.Checksum = hex.EncodeToString(digest.Sum(nil))
+
+	return os.Rename(f.Name(), engine.backupPath(info.ID))
+}
+
+func (engine *Engine) encode(w io.Writer, info *types.BackupInfo, keys []string, state map[string]internal.KeyData) error {
+	ew, err := engine.keyring.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	encoder, err := snapshot.NewEncoder(ew, engine.compression)
+	if err != nil {
+		return err
+	}
+	if err = encoder.WriteMeta(info.Time); err != nil {
+		return err
+	}
+	if err = encoder.WriteIndexes(engine.getIndexesFunc()); err != nil {
+		return err
+	}
+	now := engine.clock.Now()
+	for _, key := range keys {
+		data, ok := state[key]
+		if ok && (data.ExpireAt == (time.Time{}) || data.ExpireAt.After(now)) {
+			if err = encoder.WriteKey(key, data); err != nil {
+				return err
+			}
+			info.Keys += 1
+			continue
+		}
+		if info.Type == TypeFull {
+			continue
+		}
+		if err = encoder.WriteDelete(key); err != nil {
+			return err
+		}
+		info.DeletedKeys += 1
+	}
+	if err = encoder.Close(); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+// List returns the backups in the backup directory, oldest first.
+func (engine *Engine) List() ([]types.BackupInfo, error) {
+	manifest, err := engine.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Backups, nil
+}
+
+// Verify checks the backup with the given ID and the backups it depends on, or every backup if the ID is 0.
+// A backup is intact if its file matches the checksum in the manifest and can be read completely,
+// and its parent is in the manifest.
+func (engine *Engine) Verify(id int64) ([]types.BackupCheck, error) {
+	manifest, err := engine.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	backups := manifest.Backups
+	if id != 0 {
+		if backups, err = manifest.chain(id); err != nil {
+			return nil, err
+		}
+	}
+
+	checks := make([]types.BackupCheck, len(backups))
+	for i, backup := range backups {
+		checks[i].ID = backup.ID
+		if backup.Type != TypeFull && !slices.ContainsFunc(manifest.Backups, func(b types.BackupInfo) bool {
+			return b.ID == backup.Parent
+		}) {
+			checks[i].Error = fmt.Sprintf("parent backup %d not found", backup.Parent)
+			continue
+		}
+		if err = engine.verifyBackup(backup); err != nil {
+			checks[i].Error = err.Error()
+		}
+	}
+	return checks, nil
+}
+
+// Restore loads the backup with the given ID, or the latest backup if the ID is 0, by loading the full backup
+// that it depends on and then each delta up to it. All the backups are verified first, and reset is called
+// before anything is loaded. The index definitions of the restored backup are restored before the keys.
+func (engine *Engine) Restore(id int64, reset func()) error {
+	if !engine.inProgress.CompareAndSwap(false, true) {
+		return errors.New("backup already in progress")
+	}
+	defer engine.inProgress.Store(false)
+
+	manifest, err := engine.readManifest()
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		if len(manifest.Backups) == 0 {
+			return errors.New("no backup to restore")
+		}
+		id = manifest.Backups[len(manifest.Backups)-1].ID
+	}
+	backups, err := manifest.chain(id)
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups {
+		if err = engine.verifyBackup(backup); err != nil {
+			return err
+		}
+	}
+
+	reset()
+
+	schemas, err := engine.readIndexes(backups[len(backups)-1])
+	if err != nil {
+		return err
+	}
+	engine.restoreIndexesFunc(schemas)
+
+	now := engine.clock.Now()
+	for _, backup := range backups {
+		err = engine.readBackup(backup, func(record snapshot.Record) bool {
+			switch record.Type {
+			case snapshot.RecordKey:
+				if record.Data.ExpireAt != (time.Time{}) && !record.Data.ExpireAt.After(now) {
+					// The key may hold an older value from an earlier backup.
+					engine.deleteKeyFunc(record.Key)
+					break
+				}
+				engine.setKeyDataFunc(record.Key, record.Data)
+			case snapshot.RecordDelete:
+				engine.deleteKeyFunc(record.Key)
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("backup %d: %w", backup.ID, err)
+		}
+	}
+
+	log.Printf("restored backup %d from %d backups\n", id, len(backups))
+
+	return nil
+}
+
+// verifyBackup checks the checksum of the backup file and reads all of its records.
+func (engine *Engine) verifyBackup(backup types.BackupInfo) error {
+	f, err := os.Open(engine.backupPath(backup.ID))
+	if err != nil {
+		return err
+	}
+	digest := sha256.New()
+	_, err = io.Copy(digest, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(digest.Sum(nil)) != backup.Checksum {
+		return fmt.Errorf("backup %d: checksum mismatch", backup.ID)
+	}
+	if err = engine.readBackup(backup, func(record snapshot.Record) bool { return true }); err != nil {
+		return fmt.Errorf("backup %d: %w", backup.ID, err)
+	}
+	return nil
+}
+
+// readIndexes returns the index definitions stored in the backup.
+func (engine *Engine) readIndexes(backup types.BackupInfo) ([]search.Schema, error) {
+	var schemas []search.Schema
+	err := engine.readBackup(backup, func(record snapshot.Record) bool {
+		if record.Type == snapshot.RecordIndexes {
+			schemas = record.Indexes
+		}
+		// The indexes record comes before the key records.
+		return record.Type == snapshot.RecordMeta
+	})
+	return schemas, err
+}
+
+// readBackup passes the records of the backup to f until f returns false or the backup ends.
+func (engine *Engine) readBackup(backup types.BackupInfo, f func(record snapshot.Record) bool) error {
+	file, err := os.Open(engine.backupPath(backup.ID))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	r, err := engine.keyring.NewReader(file)
+	if err != nil {
+		return err
+	}
+	decoder, err := snapshot.NewDecoder(r)
+	if err != nil {
+		return err
+	}
+	for {
+		record, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !f(record) {
+			return nil
+		}
+	}
+}
+
+func (engine *Engine) backupPath(id int64) string {
+	return path.Join(engine.directory, fmt.Sprintf("backup-%d.bin", id))
+}
+
+func (engine *Engine) readManifest() (*Manifest, error) {
+	manifest := new(Manifest)
+	b, err := os.ReadFile(path.Join(engine.directory, "manifest.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, manifest); err != nil {
+		return nil, fmt.Errorf("backup manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// writeManifest replaces the manifest file, so that the manifest is never partially written.
+func (engine *Engine) writeManifest(manifest *Manifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(engine.directory, "manifest-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path.Join(engine.directory, "manifest.json"))
+}
+
+// chain returns the backups that restoring the backup with the given ID loads, starting with the full backup.
+func (manifest *Manifest) chain(id int64) ([]types.BackupInfo, error) {
+	var backups []types.BackupInfo
+	for {
+		i := slices.IndexFunc(manifest.Backups, func(backup types.BackupInfo) bool {
+			return backup.ID == id
+		})
+		if i == -1 && len(backups) == 0 {
+			return nil, fmt.Errorf("backup %d not found", id)
+		}
+		if i == -1 {
+			return nil, fmt.Errorf("backup %d: parent backup %d not found", backups[0].ID, id)
+		}
+		backups = append([]types.BackupInfo{manifest.Backups[i]}, backups...)
+		if manifest.Backups[i].Type == TypeFull {
+			return backups, nil
+		}
+		if manifest.Backups[i].Parent >= id {
+			return nil, fmt.Errorf("backup %d: invalid parent backup %d", id, manifest.Backups[i].Parent)
+		}
+		id = manifest.Backups[i].Parent
+	}
+}
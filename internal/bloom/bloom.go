@@ -0,0 +1,274 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"hash/fnv"
+	"math"
+	"unsafe"
+)
+
+const TypeName = "bloom"
+
+const (
+	DefaultErrorRate = 0.01
+	DefaultCapacity  = 100
+	DefaultExpansion = 2
+	// Each new sub-filter has a tighter error rate so that the compound error rate stays below the target.
+	tighteningRatio = 0.5
+	// MaxCapacity is the largest capacity that a filter can be reserved with.
+	MaxCapacity = 1 << 30
+	// MaxSize is the largest number of bits of the first sub-filter of a reserved filter.
+	MaxSize = 1 << 32
+	// maxHashes bounds the number of hash functions of a decoded sub-filter. Even the smallest error rate
+	// needs about 1075, and each expansion adds one.
+	maxHashes = 2048
+	// filterHeaderSize is the encoded size of a sub-filter without its bits.
+	filterHeaderSize = 28
+)
+
+var (
+	ErrFull            = errors.New("non scaling filter is full")
+	errInvalidEncoding = errors.New("invalid bloom filter encoding")
+)
+
+func init() {
+	internal.RegisterValueType(TypeName, func(data []byte) (interface{}, error) {
+		filter := &BloomFilter{}
+		if err := filter.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		return filter, nil
+	})
+}
+
+type filter struct {
+	bits     []uint64
+	size     uint64 // Number of bits
+	hashes   uint32
+	capacity uint64
+	count    uint64
+}
+
+// filterSize returns the number of bits of a sub-filter with the capacity and error rate.
+func filterSize(capacity uint64, errorRate float64) uint64 {
+	size := math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	if size >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return max(uint64(size), 64)
+}
+
+// CheckReserve returns an error if a filter with the error rate and capacity would be too large.
+func CheckReserve(errorRate float64, capacity uint64) error {
+	if capacity > MaxCapacity {
+		return fmt.Errorf("capacity must be at most %d", MaxCapacity)
+	}
+	if filterSize(capacity, errorRate*tighteningRatio) > MaxSize {
+		return fmt.Errorf("capacity is too large for the error rate, the filter would take more than %d bits", MaxSize)
+	}
+	return nil
+}
+
+func newFilter(capacity uint64, errorRate float64) *filter {
+	size := filterSize(capacity, errorRate)
+	return &filter{
+		bits:     make([]uint64, (size+63)/64),
+		size:     size,
+		hashes:   uint32(max(math.Ceil(-math.Log2(errorRate)), 1)),
+		capacity: capacity,
+	}
+}
+
+func (f *filter) add(h1, h2 uint64) {
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.size
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+func (f *filter) exists(h1, h2 uint64) bool {
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.size
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// BloomFilter is a scalable bloom filter. When the newest sub-filter reaches its capacity,
+// a new sub-filter with a larger capacity is stacked on top of it, unless the filter is non-scaling.
+type BloomFilter struct {
+	errorRate  float64
+	expansion  uint32
+	nonScaling bool
+	filters    []*filter
+}
+
+func NewBloomFilter(errorRate float64, capacity uint64, expansion uint32, nonScaling bool) *BloomFilter {
+	return &BloomFilter{
+		errorRate:  errorRate,
+		expansion:  expansion,
+		nonScaling: nonScaling,
+		filters:    []*filter{newFilter(capacity, errorRate*tighteningRatio)},
+	}
+}
+
+func hash(item string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(item))
+	h1 := h.Sum64()
+	h = fnv.New64()
+	_, _ = h.Write([]byte(item))
+	// The second hash must be odd so that it does not share factors with power of two sizes.
+	return h1, h.Sum64() | 1
+}
+
+// Add adds the item to the filter. It returns false if the item may already have been added.
+func (bf *BloomFilter) Add(item string) (bool, error) {
+	h1, h2 := hash(item)
+	for _, f := range bf.filters {
+		if f.exists(h1, h2) {
+			return false, nil
+		}
+	}
+	current := bf.filters[len(bf.filters)-1]
+	if current.count >= current.capacity {
+		if bf.nonScaling {
+			return false, ErrFull
+		}
+		rate := bf.errorRate * math.Pow(tighteningRatio, float64(len(bf.filters)+1))
+		current = newFilter(current.capacity*uint64(bf.expansion), rate)
+		bf.filters = append(bf.filters, current)
+	}
+	current.add(h1, h2)
+	return true, nil
+}
+
+// Exists returns false if the item was definitely not added to the filter, and true if it may have been added.
+func (bf *BloomFilter) Exists(item string) bool {
+	h1, h2 := hash(item)
+	for _, f := range bf.filters {
+		if f.exists(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Capacity returns the number of items that can be added before the filter scales.
+func (bf *BloomFilter) Capacity() uint64 {
+	var capacity uint64
+	for _, f := range bf.filters {
+		capacity += f.capacity
+	}
+	return capacity
+}
+
+// Count returns the number of items added to the filter.
+func (bf *BloomFilter) Count() uint64 {
+	var count uint64
+	for _, f := range bf.filters {
+		count += f.count
+	}
+	return count
+}
+
+func (bf *BloomFilter) Filters() int {
+	return len(bf.filters)
+}
+
+func (bf *BloomFilter) Expansion() uint32 {
+	if bf.nonScaling {
+		return 0
+	}
+	return bf.expansion
+}
+
+// Size returns the approximate memory used by the filter in bytes.
+func (bf *BloomFilter) Size() int {
+	size := int(unsafe.Sizeof(*bf))
+	for _, f := range bf.filters {
+		size += int(unsafe.Sizeof(*f)) + len(f.bits)*8
+	}
+	return size
+}
+
+func (bf *BloomFilter) TypeName() string {
+	return TypeName
+}
+
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.LittleEndian, bf.errorRate)
+	_ = binary.Write(buf, binary.LittleEndian, bf.expansion)
+	_ = binary.Write(buf, binary.LittleEndian, bf.nonScaling)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(bf.filters)))
+	for _, f := range bf.filters {
+		_ = binary.Write(buf, binary.LittleEndian, f.size)
+		_ = binary.Write(buf, binary.LittleEndian, f.hashes)
+		_ = binary.Write(buf, binary.LittleEndian, f.capacity)
+		_ = binary.Write(buf, binary.LittleEndian, f.count)
+		_ = binary.Write(buf, binary.LittleEndian, f.bits)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a filter encoded by MarshalBinary. The data may come from a client with RESTORE, so
+// the filter is checked before it is used, and nothing larger than the data is allocated.
+func (bf *BloomFilter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var count uint32
+	for _, v := range []interface{}{&bf.errorRate, &bf.expansion, &bf.nonScaling, &count} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if !(bf.errorRate > 0 && bf.errorRate < 1) || (bf.expansion == 0 && !bf.nonScaling) ||
+		count == 0 || uint64(count) > uint64(r.Len())/filterHeaderSize {
+		return errInvalidEncoding
+	}
+	bf.filters = make([]*filter, count)
+	for i := range bf.filters {
+		f := &filter{}
+		for _, v := range []interface{}{&f.size, &f.hashes, &f.capacity, &f.count} {
+			if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+				return err
+			}
+		}
+		if f.size == 0 || f.hashes == 0 || f.hashes > maxHashes || f.size > uint64(r.Len())*8 {
+			return errInvalidEncoding
+		}
+		if words := (f.size + 63) / 64; words*8 > uint64(r.Len()) {
+			return errInvalidEncoding
+		}
+		f.bits = make([]uint64, (f.size+63)/64)
+		if err := binary.Read(r, binary.LittleEndian, f.bits); err != nil {
+			return err
+		}
+		bf.filters[i] = f
+	}
+	if r.Len() != 0 {
+		return errInvalidEncoding
+	}
+	return nil
+}
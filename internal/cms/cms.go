@@ -0,0 +1,166 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/echovault/echovault/internal"
+	"hash/fnv"
+	"math"
+	"unsafe"
+)
+
+const TypeName = "cms"
+
+var errInvalidEncoding = errors.New("invalid count-min sketch encoding")
+
+func init() {
+	internal.RegisterValueType(TypeName, func(data []byte) (interface{}, error) {
+		sketch := &CountMinSketch{}
+		if err := sketch.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		return sketch, nil
+	})
+}
+
+// CountMinSketch estimates the frequency of items in a stream.
+// Estimates are never lower than the true count.
+type CountMinSketch struct {
+	width    uint32
+	depth    uint32
+	count    uint64
+	counters []uint64 // depth rows of width counters
+}
+
+func NewCountMinSketch(width uint32, depth uint32) *CountMinSketch {
+	return &CountMinSketch{
+		width:    width,
+		depth:    depth,
+		counters: make([]uint64, uint64(width)*uint64(depth)),
+	}
+}
+
+// DimensionsForProbability returns the width and depth of a sketch that overestimates counts
+// by at most errorRate of the total count, with the given probability of exceeding that error.
+func DimensionsForProbability(errorRate float64, probability float64) (uint32, uint32) {
+	width := uint32(math.Ceil(2 / errorRate))
+	depth := uint32(math.Ceil(math.Log10(probability) / math.Log10(0.5)))
+	return width, max(depth, 1)
+}
+
+func (sketch *CountMinSketch) positions(item string) []uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(item))
+	h1 := h.Sum64()
+	h = fnv.New64()
+	_, _ = h.Write([]byte(item))
+	h2 := h.Sum64() | 1
+	positions := make([]uint64, sketch.depth)
+	for i := range positions {
+		positions[i] = uint64(i)*uint64(sketch.width) + (h1+uint64(i)*h2)%uint64(sketch.width)
+	}
+	return positions
+}
+
+// IncrBy increments the count of the item and returns its new estimated count.
+func (sketch *CountMinSketch) IncrBy(item string, increment uint64) uint64 {
+	estimate := uint64(math.MaxUint64)
+	for _, pos := range sketch.positions(item) {
+		sketch.counters[pos] += increment
+		estimate = min(estimate, sketch.counters[pos])
+	}
+	sketch.count += increment
+	return estimate
+}
+
+// Query returns the estimated count of the item.
+func (sketch *CountMinSketch) Query(item string) uint64 {
+	estimate := uint64(math.MaxUint64)
+	for _, pos := range sketch.positions(item) {
+		estimate = min(estimate, sketch.counters[pos])
+	}
+	return estimate
+}
+
+// Merge replaces the contents of the sketch with the weighted sum of the sources.
+// All the sources must have the same dimensions as the sketch.
+func (sketch *CountMinSketch) Merge(sources []*CountMinSketch, weights []uint64) error {
+	if len(sources) != len(weights) {
+		return errors.New("the number of weights must match the number of sources")
+	}
+	counters := make([]uint64, len(sketch.counters))
+	var count uint64
+	for i, source := range sources {
+		if source.width != sketch.width || source.depth != sketch.depth {
+			return errors.New("width/depth of the sketches must be the same")
+		}
+		for j, c := range source.counters {
+			counters[j] += c * weights[i]
+		}
+		count += source.count * weights[i]
+	}
+	sketch.counters = counters
+	sketch.count = count
+	return nil
+}
+
+func (sketch *CountMinSketch) Width() uint32 {
+	return sketch.width
+}
+
+func (sketch *CountMinSketch) Depth() uint32 {
+	return sketch.depth
+}
+
+// Count returns the sum of all the increments.
+func (sketch *CountMinSketch) Count() uint64 {
+	return sketch.count
+}
+
+// Size returns the approximate memory used by the sketch in bytes.
+func (sketch *CountMinSketch) Size() int {
+	return int(unsafe.Sizeof(*sketch)) + len(sketch.counters)*8
+}
+
+func (sketch *CountMinSketch) TypeName() string {
+	return TypeName
+}
+
+func (sketch *CountMinSketch) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, v := range []interface{}{sketch.width, sketch.depth, sketch.count, sketch.counters} {
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary. The data may come from a client with RESTORE, so
+// the dimensions are checked against the counters that the data holds.
+func (sketch *CountMinSketch) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	for _, v := range []interface{}{&sketch.width, &sketch.depth, &sketch.count} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if sketch.width == 0 || sketch.depth == 0 || uint64(sketch.width)*uint64(sketch.depth)*8 != uint64(r.Len()) {
+		return errInvalidEncoding
+	}
+	sketch.counters = make([]uint64, uint64(sketch.width)*uint64(sketch.depth))
+	return binary.Read(r, binary.LittleEndian, sketch.counters)
+}
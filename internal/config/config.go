@@ -32,37 +32,64 @@ import (
 )
 
 type Config struct {
-	TLS                bool          `json:"TLS" yaml:"TLS"`
-	MTLS               bool          `json:"MTLS" yaml:"MTLS"`
-	CertKeyPairs       [][]string    `json:"CertKeyPairs" yaml:"CertKeyPairs"`
-	ClientCAs          []string      `json:"ClientCAs" yaml:"ClientCAs"`
-	Port               uint16        `json:"Port" yaml:"Port"`
-	ServerID           string        `json:"ServerId" yaml:"ServerId"`
-	JoinAddr           string        `json:"JoinAddr" yaml:"JoinAddr"`
-	BindAddr           string        `json:"BindAddr" yaml:"BindAddr"`
-	RaftBindPort       uint16        `json:"RaftPort" yaml:"RaftPort"`
-	MemberListBindPort uint16        `json:"MlPort" yaml:"MlPort"`
-	InMemory           bool          `json:"InMemory" yaml:"InMemory"`
-	DataDir            string        `json:"DataDir" yaml:"DataDir"`
-	BootstrapCluster   bool          `json:"BootstrapCluster" yaml:"BootstrapCluster"`
-	AclConfig          string        `json:"AclConfig" yaml:"AclConfig"`
-	ForwardCommand     bool          `json:"ForwardCommand" yaml:"ForwardCommand"`
-	RequirePass        bool          `json:"RequirePass" yaml:"RequirePass"`
-	Password           string        `json:"Password" yaml:"Password"`
-	SnapShotThreshold  uint64        `json:"SnapshotThreshold" yaml:"SnapshotThreshold"`
-	SnapshotInterval   time.Duration `json:"SnapshotInterval" yaml:"SnapshotInterval"`
-	RestoreSnapshot    bool          `json:"RestoreSnapshot" yaml:"RestoreSnapshot"`
-	RestoreAOF         bool          `json:"RestoreAOF" yaml:"RestoreAOF"`
-	AOFSyncStrategy    string        `json:"AOFSyncStrategy" yaml:"AOFSyncStrategy"`
-	MaxMemory          uint64        `json:"MaxMemory" yaml:"MaxMemory"`
-	EvictionPolicy     string        `json:"EvictionPolicy" yaml:"EvictionPolicy"`
-	EvictionSample     uint          `json:"EvictionSample" yaml:"EvictionSample"`
-	EvictionInterval   time.Duration `json:"EvictionInterval" yaml:"EvictionInterval"`
+	TLS                 bool          `json:"TLS" yaml:"TLS"`
+	MTLS                bool          `json:"MTLS" yaml:"MTLS"`
+	CertKeyPairs        [][]string    `json:"CertKeyPairs" yaml:"CertKeyPairs"`
+	ClientCAs           []string      `json:"ClientCAs" yaml:"ClientCAs"`
+	Port                uint16        `json:"Port" yaml:"Port"`
+	ServerID            string        `json:"ServerId" yaml:"ServerId"`
+	JoinAddr            string        `json:"JoinAddr" yaml:"JoinAddr"`
+	DiscoverySRV        string        `json:"DiscoverySRV" yaml:"DiscoverySRV"`
+	DiscoveryDNS        string        `json:"DiscoveryDNS" yaml:"DiscoveryDNS"`
+	DiscoveryResolver   string        `json:"DiscoveryResolver" yaml:"DiscoveryResolver"`
+	BindAddr            string        `json:"BindAddr" yaml:"BindAddr"`
+	RaftBindPort        uint16        `json:"RaftPort" yaml:"RaftPort"`
+	MemberListBindPort  uint16        `json:"MlPort" yaml:"MlPort"`
+	InMemory            bool          `json:"InMemory" yaml:"InMemory"`
+	DataDir             string        `json:"DataDir" yaml:"DataDir"`
+	BootstrapCluster    bool          `json:"BootstrapCluster" yaml:"BootstrapCluster"`
+	Learner             bool          `json:"Learner" yaml:"Learner"`
+	AclConfig           string        `json:"AclConfig" yaml:"AclConfig"`
+	ForwardCommand      bool          `json:"ForwardCommand" yaml:"ForwardCommand"`
+	ClusterPersistence  string        `json:"ClusterPersistence" yaml:"ClusterPersistence"`
+	ReplicationTargets  []string      `json:"ReplicationTargets" yaml:"ReplicationTargets"`
+	ReplicationUsername string        `json:"ReplicationUsername" yaml:"ReplicationUsername"`
+	ReplicationPassword string        `json:"ReplicationPassword" yaml:"ReplicationPassword"`
+	Replica             bool          `json:"Replica" yaml:"Replica"`
+	RequirePass         bool          `json:"RequirePass" yaml:"RequirePass"`
+	Password            string        `json:"Password" yaml:"Password"`
+	SnapShotThreshold   uint64        `json:"SnapshotThreshold" yaml:"SnapshotThreshold"`
+	SnapshotInterval    time.Duration `json:"SnapshotInterval" yaml:"SnapshotInterval"`
+	SnapshotSave        string        `json:"SnapshotSave" yaml:"SnapshotSave"`
+	SnapshotCompression string        `json:"SnapshotCompression" yaml:"SnapshotCompression"`
+	SnapshotRetainCount uint64        `json:"SnapshotRetainCount" yaml:"SnapshotRetainCount"`
+	SnapshotRetainAge   time.Duration `json:"SnapshotRetainAge" yaml:"SnapshotRetainAge"`
+	BackupDir           string        `json:"BackupDir" yaml:"BackupDir"`
+	RestoreSnapshot     bool          `json:"RestoreSnapshot" yaml:"RestoreSnapshot"`
+	RestoreSnapshotID   int64         `json:"RestoreSnapshotID" yaml:"RestoreSnapshotID"`
+	RestoreAOF          bool          `json:"RestoreAOF" yaml:"RestoreAOF"`
+	RestoreRDB          string        `json:"RestoreRDB" yaml:"RestoreRDB"`
+	RestoreClusterData  bool          `json:"RestoreClusterData" yaml:"RestoreClusterData"`
+	AOFSyncStrategy     string        `json:"AOFSyncStrategy" yaml:"AOFSyncStrategy"`
+	AOFCorruptionPolicy string        `json:"AOFCorruptionPolicy" yaml:"AOFCorruptionPolicy"`
+	AOFRewritePercent   uint64        `json:"AOFRewritePercent" yaml:"AOFRewritePercent"`
+	AOFRewriteMinSize   uint64        `json:"AOFRewriteMinSize" yaml:"AOFRewriteMinSize"`
+	EncryptionKeyFile   string        `json:"EncryptionKeyFile" yaml:"EncryptionKeyFile"`
+	EncryptionKeyEnv    string        `json:"EncryptionKeyEnv" yaml:"EncryptionKeyEnv"`
+	RaftTLS             bool          `json:"RaftTLS" yaml:"RaftTLS"`
+	GossipKeyFile       string        `json:"GossipKeyFile" yaml:"GossipKeyFile"`
+	GossipKeyEnv        string        `json:"GossipKeyEnv" yaml:"GossipKeyEnv"`
+	RequireClusterTLS   bool          `json:"RequireClusterTLS" yaml:"RequireClusterTLS"`
+	MaxMemory           uint64        `json:"MaxMemory" yaml:"MaxMemory"`
+	EvictionPolicy      string        `json:"EvictionPolicy" yaml:"EvictionPolicy"`
+	EvictionSample      uint          `json:"EvictionSample" yaml:"EvictionSample"`
+	EvictionInterval    time.Duration `json:"EvictionInterval" yaml:"EvictionInterval"`
 }
 
 func GetConfig() (Config, error) {
 	var certKeyPairs [][]string
 	var clientCAs []string
+	var replicationTargets []string
 
 	flag.Func("cert-key-pair",
 		"A pair of file paths representing the signed certificate and it's corresponding key separated by a comma.",
@@ -83,9 +110,17 @@ func GetConfig() (Config, error) {
 		return nil
 	})
 
+	flag.Func("replication-target", `The address of a node of a replica cluster that the leader of this cluster ships its raft log to.
+Repeat the flag to list more nodes of the replica cluster. The entries are shipped to the node that is the leader.`,
+		func(s string) error {
+			replicationTargets = append(replicationTargets, strings.TrimSpace(s))
+			return nil
+		})
+
 	aofSyncStrategy := "everysec"
 	flag.Func("aof-sync-strategy", `How often to flush the file contents written to append only file.
-The options are 'always' for syncing on each command, 'everysec' to sync every second, and 'no' to leave it up to the os.`,
+The options are 'always' for syncing on each command, 'everysec' to sync every second, and 'no' to leave it up to the os.
+With 'always', a write command is only acknowledged once it is synced, and concurrent commands share a single sync.`,
 		func(option string) error {
 			if !slices.ContainsFunc([]string{"always", "everysec", "no"}, func(s string) bool {
 				return strings.EqualFold(s, option)
@@ -96,6 +131,69 @@ The options are 'always' for syncing on each command, 'everysec' to sync every s
 			return nil
 		})
 
+	aofCorruptionPolicy := "truncate"
+	flag.Func("aof-corruption-policy", `How to handle corrupted AOF records on restore. The options are 'truncate', 'skip' and 'fail'.
+'truncate' replays the records before the first corruption and truncates the file there.
+'skip' replays every valid record. 'fail' stops the server from starting.`,
+		func(option string) error {
+			if !slices.ContainsFunc([]string{"truncate", "skip", "fail"}, func(s string) bool {
+				return strings.EqualFold(s, option)
+			}) {
+				return errors.New("aofCorruptionPolicy must be 'truncate', 'skip' or 'fail'")
+			}
+			aofCorruptionPolicy = strings.ToLower(option)
+			return nil
+		})
+
+	var aofRewriteMinSize uint64 = 64 * 1024 * 1024
+	flag.Func("auto-aof-rewrite-min-size", `The minimum size of the AOF before it is rewritten automatically.
+Supported units (kb, mb, gb, tb, pb). The default is 64mb.`, func(memory string) error {
+		b, err := internal.ParseMemory(memory)
+		if err != nil {
+			return err
+		}
+		aofRewriteMinSize = b
+		return nil
+	})
+
+	clusterPersistence := "none"
+	flag.Func("cluster-persistence", `The local persistence of a cluster node in addition to the raft log and snapshots.
+The options are 'none', 'aof' to keep an AOF of the applied commands, and 'snapshot' to export snapshots like in standalone mode.
+Either way, the data directory of the node can be restored into a standalone instance.`,
+		func(option string) error {
+			if !slices.ContainsFunc([]string{"none", "aof", "snapshot"}, func(s string) bool {
+				return strings.EqualFold(s, option)
+			}) {
+				return errors.New("clusterPersistence must be 'none', 'aof' or 'snapshot'")
+			}
+			clusterPersistence = strings.ToLower(option)
+			return nil
+		})
+
+	snapshotSave := "900 1 300 10 60 10000"
+	flag.Func("snapshot-save", `The save points that trigger a snapshot, as pairs of seconds and changes separated by spaces.
+A snapshot is taken once any pair is reached: at least 'changes' keys were set or deleted and 'seconds' have passed
+since the last snapshot. An empty string disables automatic snapshots. The default is "900 1 300 10 60 10000".`,
+		func(option string) error {
+			if _, err := internal.ParseSavePoints(option); err != nil {
+				return err
+			}
+			snapshotSave = option
+			return nil
+		})
+
+	snapshotCompression := "none"
+	flag.Func("snapshot-compression", `The compression used for snapshots. The options are 'none' and 'gzip'.`,
+		func(option string) error {
+			if !slices.ContainsFunc([]string{"none", "gzip"}, func(s string) bool {
+				return strings.EqualFold(s, option)
+			}) {
+				return errors.New("snapshotCompression must be 'none' or 'gzip'")
+			}
+			snapshotCompression = strings.ToLower(option)
+			return nil
+		})
+
 	var maxMemory uint64 = 0
 	flag.Func("max-memory", `Upper memory limit before triggering eviction. 
 Supported units (kb, mb, gb, tb, pb). When 0 is passed, there will be no memory limit.
@@ -135,7 +233,13 @@ There is no limit by default.`, func(memory string) error {
 	mtls := flag.Bool("mtls", false, "Use mTLS to verify the client.")
 	port := flag.Int("port", 7480, "Port to use. Default is 7480")
 	serverId := flag.String("server-id", "1", "EchoVault ID in raft cluster. Leave empty for client.")
-	joinAddr := flag.String("join-addr", "", "Address of cluster member in a cluster to you want to join.")
+	joinAddr := flag.String("join-addr", "", `Comma separated memberlist addresses of members of the cluster you want to join.
+The addresses are tried in order until one of them answers.`)
+	discoverySRV := flag.String("discovery-srv", "", `The name of DNS SRV records that list the memberlist addresses of the cluster members to join,
+such as _echovault._tcp.example.com. The records are looked up after the join-addr seeds.`)
+	discoveryDNS := flag.String("discovery-dns", "", `A host name whose A and AAAA records are the addresses of the cluster members to join.
+Append :<port> if the members do not use the memberlist port of this node. The records are looked up after the SRV records.`)
+	discoveryResolver := flag.String("discovery-resolver", "", "The <host>:<port> address of the DNS server to query for discovery instead of the system resolver.")
 	bindAddr := flag.String("bind-addr", "", "Address to bind the echovault to.")
 	raftBindPort := flag.Uint("raft-port", 7481, "Port to use for intra-cluster communication. Leave on the client.")
 	mlBindPort := flag.Uint("memberlist-port", 7946, "Port to use for memberlist communication.")
@@ -143,16 +247,60 @@ There is no limit by default.`, func(memory string) error {
 	dataDir := flag.String("data-dir", "/var/lib/echovault", "Directory to store snapshots and logs.")
 	bootstrapCluster := flag.Bool("bootstrap-cluster", false, "Whether this instance should bootstrap a new cluster.")
 	aclConfig := flag.String("acl-config", "", "ACL config file path.")
-	snapshotThreshold := flag.Uint64("snapshot-threshold", 1000, "The number of entries that trigger a snapshot. Default is 1000.")
-	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "The time interval between snapshots (in seconds). Default is 5 minutes.")
+	snapshotThreshold := flag.Uint64("snapshot-threshold", 1000, `The number of raft log entries that trigger a raft snapshot on a cluster node. Default is 1000.
+Deprecated for other snapshots: when snapshot-save is not set, it is used as the changes of a save point.`)
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, `The time interval between checks of snapshot-threshold on a cluster node. Default is 5 minutes.
+Deprecated for other snapshots: when snapshot-save is not set, it is used as the seconds of a save point.`)
+	snapshotRetainCount := flag.Uint64("snapshot-retain-count", 0, "The number of most recent snapshots to keep. Older snapshots are deleted. Default is 0, which keeps all snapshots.")
+	snapshotRetainAge := flag.Duration("snapshot-retain-age", 0, "The maximum age of the snapshots to keep. Older snapshots are deleted. Default is 0, which keeps snapshots regardless of age.")
+	backupDir := flag.String("backup-dir", "", "Directory to store the backups taken with BACKUP CREATE. Default is the backups directory in data-dir.")
 	restoreSnapshot := flag.Bool("restore-snapshot", false, "This flag prompts the echovault to restore state from snapshot when set to true. Only works in standalone mode. Higher priority than restoreAOF.")
+	restoreSnapshotID := flag.Int64("restore-snapshot-id", 0, "The ID of the snapshot to restore on startup instead of the latest one. Implies restore-snapshot. Only works in standalone mode.")
+	aofRewritePercent := flag.Uint64("auto-aof-rewrite-percentage", 100, `The growth of the AOF, as a percentage of its size after the last rewrite, that triggers a rewrite.
+Only works in standalone mode. Default is 100. When 0 is passed, the AOF is only rewritten with REWRITEAOF.`)
 	restoreAOF := flag.Bool("restore-aof", false, "This flag prompts the echovault to restore state from append-only logs. Only works in standalone mode. Lower priority than restoreSnapshot.")
+	restoreRDB := flag.String("restore-rdb", "", "Path to a Redis RDB file to restore state from on startup. Only works in standalone mode. Replaces restoreSnapshot and has lower priority than restoreAOF.")
+	restoreClusterData := flag.Bool("restore-cluster-data", false, `Restore state from the raft snapshot and raft log that a cluster node left in the data directory.
+Only works in standalone mode. Higher priority than restoreAOF and restoreSnapshot. The node must be stopped.`)
+	encryptionKeyFile := flag.String("encryption-key-file", "", `Path to a file with the keys that encrypt snapshots, the AOF and the raft storage at rest.
+The file holds one hex or base64 encoded 32 byte key per line. The last key encrypts new data, and the other keys decrypt older data.`)
+	encryptionKeyEnv := flag.String("encryption-key-env", "", `The name of an environment variable holding comma separated encryption keys.
+The keys come after the keys from encryption-key-file.`)
+	raftTLS := flag.Bool("raft-tls", false, `Use mTLS for the raft transport. Nodes present the first cert-key-pair and verify each other with client-ca.
+Every node of the cluster must set this flag.`)
+	gossipKeyFile := flag.String("gossip-key-file", "", `Path to a file with the keys that encrypt the memberlist gossip, which includes forwarded commands.
+The file holds one hex or base64 encoded 32 byte key per line. The last key encrypts gossip, and every key decrypts it.`)
+	gossipKeyEnv := flag.String("gossip-key-env", "", `The name of an environment variable holding comma separated gossip keys.
+The keys come after the keys from gossip-key-file.`)
+	requireClusterTLS := flag.Bool("require-cluster-tls", false, "Refuse to start a cluster node unless raft-tls is set and gossip keys are configured.")
 	evictionSample := flag.Uint("eviction-sample", 20, "An integer specifying the number of keys to sample when checking for expired keys.")
 	evictionInterval := flag.Duration("eviction-interval", 100*time.Millisecond, "The interval between each sampling of keys to evict.")
 	forwardCommand := flag.Bool(
 		"forward-commands",
 		false,
 		"If the node is a follower, this flag forwards mutation command to the leader when set to true")
+	learner := flag.Bool(
+		"learner",
+		false,
+		`Join the cluster as a non-voting learner. A learner receives the raft log and serves reads, but does not vote
+in elections or count towards the commit quorum. Promote it to a voter with CLUSTER PROMOTE.`,
+	)
+	replicationUsername := flag.String(
+		"replication-username",
+		"",
+		`The user that the leader authenticates as on the replica cluster. Leave empty to authenticate the default user.`,
+	)
+	replicationPassword := flag.String(
+		"replication-password",
+		"",
+		`The password that the leader authenticates with on the replica cluster. Leave empty if the replica does not
+require authentication.`,
+	)
+	replica := flag.Bool(
+		"replica",
+		false,
+		"Start the cluster as a replica cluster, which rejects client writes until it is promoted with REPLICATION PROMOTE.",
+	)
 	requirePass := flag.Bool(
 		"require-pass",
 		false,
@@ -173,33 +321,73 @@ It is a plain text value by default but you can provide a SHA256 hash by adding
 
 	flag.Parse()
 
+	// snapshot-threshold and snapshot-interval used to trigger the snapshots of standalone nodes too. Deployments
+	// that still set them without snapshot-save keep their snapshot frequency through an equivalent save point.
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	if !setFlags["snapshot-save"] && (setFlags["snapshot-threshold"] || setFlags["snapshot-interval"]) {
+		snapshotSave = internal.FormatSavePoints([]internal.SavePoint{{
+			Seconds: uint64(*snapshotInterval / time.Second),
+			Changes: *snapshotThreshold,
+		}})
+		log.Printf("snapshot-threshold and snapshot-interval are deprecated for snapshots, use snapshot-save instead. Using the save point \"%s\".\n", snapshotSave)
+	}
+
 	conf := Config{
-		CertKeyPairs:       certKeyPairs,
-		ClientCAs:          clientCAs,
-		TLS:                *tls,
-		MTLS:               *mtls,
-		Port:               uint16(*port),
-		ServerID:           *serverId,
-		JoinAddr:           *joinAddr,
-		BindAddr:           *bindAddr,
-		RaftBindPort:       uint16(*raftBindPort),
-		MemberListBindPort: uint16(*mlBindPort),
-		InMemory:           *inMemory,
-		DataDir:            *dataDir,
-		BootstrapCluster:   *bootstrapCluster,
-		AclConfig:          *aclConfig,
-		ForwardCommand:     *forwardCommand,
-		RequirePass:        *requirePass,
-		Password:           *password,
-		SnapShotThreshold:  *snapshotThreshold,
-		SnapshotInterval:   *snapshotInterval,
-		RestoreSnapshot:    *restoreSnapshot,
-		RestoreAOF:         *restoreAOF,
-		AOFSyncStrategy:    aofSyncStrategy,
-		MaxMemory:          maxMemory,
-		EvictionPolicy:     evictionPolicy,
-		EvictionSample:     *evictionSample,
-		EvictionInterval:   *evictionInterval,
+		CertKeyPairs:        certKeyPairs,
+		ClientCAs:           clientCAs,
+		TLS:                 *tls,
+		MTLS:                *mtls,
+		Port:                uint16(*port),
+		ServerID:            *serverId,
+		JoinAddr:            *joinAddr,
+		DiscoverySRV:        *discoverySRV,
+		DiscoveryDNS:        *discoveryDNS,
+		DiscoveryResolver:   *discoveryResolver,
+		BindAddr:            *bindAddr,
+		RaftBindPort:        uint16(*raftBindPort),
+		MemberListBindPort:  uint16(*mlBindPort),
+		InMemory:            *inMemory,
+		DataDir:             *dataDir,
+		BootstrapCluster:    *bootstrapCluster,
+		Learner:             *learner,
+		AclConfig:           *aclConfig,
+		ForwardCommand:      *forwardCommand,
+		ClusterPersistence:  clusterPersistence,
+		ReplicationTargets:  replicationTargets,
+		ReplicationUsername: *replicationUsername,
+		ReplicationPassword: *replicationPassword,
+		Replica:             *replica,
+		RequirePass:         *requirePass,
+		Password:            *password,
+		SnapShotThreshold:   *snapshotThreshold,
+		SnapshotInterval:    *snapshotInterval,
+		SnapshotSave:        snapshotSave,
+		SnapshotCompression: snapshotCompression,
+		SnapshotRetainCount: *snapshotRetainCount,
+		SnapshotRetainAge:   *snapshotRetainAge,
+		BackupDir:           *backupDir,
+		RestoreSnapshot:     *restoreSnapshot,
+		RestoreSnapshotID:   *restoreSnapshotID,
+		RestoreAOF:          *restoreAOF,
+		RestoreRDB:          *restoreRDB,
+		RestoreClusterData:  *restoreClusterData,
+		AOFSyncStrategy:     aofSyncStrategy,
+		AOFCorruptionPolicy: aofCorruptionPolicy,
+		AOFRewritePercent:   *aofRewritePercent,
+		AOFRewriteMinSize:   aofRewriteMinSize,
+		EncryptionKeyFile:   *encryptionKeyFile,
+		EncryptionKeyEnv:    *encryptionKeyEnv,
+		RaftTLS:             *raftTLS,
+		GossipKeyFile:       *gossipKeyFile,
+		GossipKeyEnv:        *gossipKeyEnv,
+		RequireClusterTLS:   *requireClusterTLS,
+		MaxMemory:           maxMemory,
+		EvictionPolicy:      evictionPolicy,
+		EvictionSample:      *evictionSample,
+		EvictionInterval:    *evictionInterval,
 	}
 
 	if len(*config) > 0 {
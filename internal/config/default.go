@@ -7,31 +7,56 @@ import (
 
 func DefaultConfig() Config {
 	return Config{
-		TLS:                false,
-		MTLS:               false,
-		CertKeyPairs:       make([][]string, 0),
-		ClientCAs:          make([]string, 0),
-		Port:               7480,
-		ServerID:           "",
-		JoinAddr:           "",
-		BindAddr:           "localhost",
-		RaftBindPort:       7481,
-		MemberListBindPort: 7946,
-		InMemory:           false,
-		DataDir:            ".",
-		BootstrapCluster:   false,
-		AclConfig:          "",
-		ForwardCommand:     false,
-		RequirePass:        false,
-		Password:           "",
-		SnapShotThreshold:  1000,
-		SnapshotInterval:   5 * time.Minute,
-		RestoreAOF:         false,
-		RestoreSnapshot:    false,
-		AOFSyncStrategy:    "everysec",
-		MaxMemory:          0,
-		EvictionPolicy:     constants.NoEviction,
-		EvictionSample:     20,
-		EvictionInterval:   100 * time.Millisecond,
+		TLS:                 false,
+		MTLS:                false,
+		CertKeyPairs:        make([][]string, 0),
+		ClientCAs:           make([]string, 0),
+		Port:                7480,
+		ServerID:            "",
+		JoinAddr:            "",
+		DiscoverySRV:        "",
+		DiscoveryDNS:        "",
+		DiscoveryResolver:   "",
+		BindAddr:            "localhost",
+		RaftBindPort:        7481,
+		MemberListBindPort:  7946,
+		InMemory:            false,
+		DataDir:             ".",
+		BootstrapCluster:    false,
+		Learner:             false,
+		AclConfig:           "",
+		ForwardCommand:      false,
+		ClusterPersistence:  "none",
+		ReplicationTargets:  make([]string, 0),
+		ReplicationUsername: "",
+		ReplicationPassword: "",
+		Replica:             false,
+		RequirePass:         false,
+		Password:            "",
+		SnapShotThreshold:   1000,
+		SnapshotInterval:    5 * time.Minute,
+		SnapshotSave:        "900 1 300 10 60 10000",
+		SnapshotCompression: "none",
+		SnapshotRetainCount: 0,
+		SnapshotRetainAge:   0,
+		BackupDir:           "",
+		RestoreAOF:          false,
+		RestoreSnapshot:     false,
+		RestoreSnapshotID:   0,
+		RestoreClusterData:  false,
+		AOFSyncStrategy:     "everysec",
+		AOFCorruptionPolicy: "truncate",
+		AOFRewritePercent:   100,
+		AOFRewriteMinSize:   64 * 1024 * 1024,
+		EncryptionKeyFile:   "",
+		EncryptionKeyEnv:    "",
+		RaftTLS:             false,
+		GossipKeyFile:       "",
+		GossipKeyEnv:        "",
+		RequireClusterTLS:   false,
+		MaxMemory:           0,
+		EvictionPolicy:      constants.NoEviction,
+		EvictionSample:      20,
+		EvictionInterval:    100 * time.Millisecond,
 	}
 }
@@ -0,0 +1,368 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cuckoo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/echovault/echovault/internal"
+	"hash/fnv"
+	"math/bits"
+	"unsafe"
+)
+
+const TypeName = "cuckoo"
+
+const (
+	DefaultCapacity      = 1024
+	DefaultBucketSize    = 2
+	DefaultMaxIterations = 20
+	DefaultExpansion     = 1
+	// MaxCapacity is the largest capacity that a filter can be reserved with.
+	MaxCapacity = 1 << 30
+)
+
+var (
+	ErrFull            = errors.New("filter is full")
+	errInvalidEncoding = errors.New("invalid cuckoo filter encoding")
+)
+
+func init() {
+	internal.RegisterValueType(TypeName, func(data []byte) (interface{}, error) {
+		filter := &CuckooFilter{}
+		if err := filter.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		return filter, nil
+	})
+}
+
+// filter is a single cuckoo table with 8 bit fingerprints. A fingerprint of 0 marks an empty slot.
+type filter struct {
+	buckets    uint64 // Always a power of two so that the alternate index can be computed with XOR.
+	bucketSize uint16
+	slots      []uint8
+}
+
+func newFilter(capacity uint64, bucketSize uint16) *filter {
+	buckets := max(capacity/uint64(bucketSize), 1)
+	// Round up to the next power of two.
+	buckets = 1 << (64 - bits.LeadingZeros64(buckets-1))
+	return &filter{
+		buckets:    buckets,
+		bucketSize: bucketSize,
+		slots:      make([]uint8, buckets*uint64(bucketSize)),
+	}
+}
+
+func (f *filter) bucket(i uint64) []uint8 {
+	start := i * uint64(f.bucketSize)
+	return f.slots[start : start+uint64(f.bucketSize)]
+}
+
+func (f *filter) indexes(h uint64, fp uint8) (uint64, uint64) {
+	i1 := h & (f.buckets - 1)
+	return i1, f.altIndex(i1, fp)
+}
+
+func (f *filter) altIndex(i uint64, fp uint8) uint64 {
+	// The fingerprint hash is multiplied by a large odd constant so that similar fingerprints are spread out.
+	return (i ^ (uint64(fp) * 0x5bd1e995)) & (f.buckets - 1)
+}
+
+func (f *filter) insert(i uint64, fp uint8) bool {
+	bucket := f.bucket(i)
+	for j := range bucket {
+		if bucket[j] == 0 {
+			bucket[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+func (f *filter) count(h uint64, fp uint8) int {
+	i1, i2 := f.indexes(h, fp)
+	count := 0
+	for _, i := range []uint64{i1, i2} {
+		for _, slot := range f.bucket(i) {
+			if slot == fp {
+				count++
+			}
+		}
+		if i1 == i2 {
+			break
+		}
+	}
+	return count
+}
+
+func (f *filter) delete(h uint64, fp uint8) bool {
+	i1, i2 := f.indexes(h, fp)
+	for _, i := range []uint64{i1, i2} {
+		bucket := f.bucket(i)
+		for j := range bucket {
+			if bucket[j] == fp {
+				bucket[j] = 0
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CuckooFilter is a scalable cuckoo filter that supports deletion.
+// When an item cannot be placed in the newest sub-filter, a new sub-filter is added,
+// unless the expansion is 0.
+type CuckooFilter struct {
+	capacity      uint64
+	bucketSize    uint16
+	maxIterations uint16
+	expansion     uint16
+	filters       []*filter
+	inserted      uint64
+	deleted       uint64
+	// State of the xorshift generator used to choose the fingerprint to evict.
+	// It is persisted so that replicas that apply the same commands end up with identical filters.
+	rand uint64
+}
+
+func NewCuckooFilter(capacity uint64, bucketSize uint16, maxIterations uint16, expansion uint16) *CuckooFilter {
+	return &CuckooFilter{
+		capacity:      capacity,
+		bucketSize:    bucketSize,
+		maxIterations: maxIterations,
+		expansion:     expansion,
+		filters:       []*filter{newFilter(capacity, bucketSize)},
+		rand:          0x9e3779b97f4a7c15,
+	}
+}
+
+func hash(item string) (uint64, uint8) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(item))
+	sum := h.Sum64()
+	fp := uint8(sum >> 56)
+	if fp == 0 {
+		fp = 1
+	}
+	return sum, fp
+}
+
+func (cf *CuckooFilter) next() uint64 {
+	cf.rand ^= cf.rand << 13
+	cf.rand ^= cf.rand >> 7
+	cf.rand ^= cf.rand << 17
+	return cf.rand
+}
+
+// Add adds the item to the filter. Items can be added more than once.
+func (cf *CuckooFilter) Add(item string) error {
+	h, fp := hash(item)
+	current := cf.filters[len(cf.filters)-1]
+	if cf.place(current, h, fp) {
+		cf.inserted++
+		return nil
+	}
+	if cf.expansion == 0 {
+		return ErrFull
+	}
+	capacity := uint64(len(current.slots)) * uint64(cf.expansion)
+	current = newFilter(capacity, cf.bucketSize)
+	cf.filters = append(cf.filters, current)
+	if !cf.place(current, h, fp) {
+		return ErrFull
+	}
+	cf.inserted++
+	return nil
+}
+
+// AddNX adds the item only if it does not exist in the filter. It returns false if the item exists.
+func (cf *CuckooFilter) AddNX(item string) (bool, error) {
+	if cf.Exists(item) {
+		return false, nil
+	}
+	return true, cf.Add(item)
+}
+
+// place inserts the fingerprint in the filter, relocating existing fingerprints if both buckets are full.
+// When no free slot is found within the maximum number of iterations, the relocations are undone.
+func (cf *CuckooFilter) place(f *filter, h uint64, fp uint8) bool {
+	i1, i2 := f.indexes(h, fp)
+	if f.insert(i1, fp) || f.insert(i2, fp) {
+		return true
+	}
+
+	type swap struct {
+		index uint64
+		slot  int
+		fp    uint8
+	}
+	var swaps []swap
+
+	i := i1
+	if cf.next()%2 == 0 {
+		i = i2
+	}
+	for n := uint16(0); n < cf.maxIterations; n++ {
+		bucket := f.bucket(i)
+		slot := int(cf.next() % uint64(len(bucket)))
+		swaps = append(swaps, swap{index: i, slot: slot, fp: bucket[slot]})
+		fp, bucket[slot] = bucket[slot], fp
+		i = f.altIndex(i, fp)
+		if f.insert(i, fp) {
+			return true
+		}
+	}
+
+	for j := len(swaps) - 1; j >= 0; j-- {
+		f.bucket(swaps[j].index)[swaps[j].slot] = swaps[j].fp
+	}
+	return false
+}
+
+// Exists returns false if the item is definitely not in the filter, and true if it may be.
+func (cf *CuckooFilter) Exists(item string) bool {
+	h, fp := hash(item)
+	for _, f := range cf.filters {
+		if f.count(h, fp) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes one occurrence of the item. It returns false if the item was not found.
+func (cf *CuckooFilter) Delete(item string) bool {
+	h, fp := hash(item)
+	for i := len(cf.filters) - 1; i >= 0; i-- {
+		if cf.filters[i].delete(h, fp) {
+			cf.inserted--
+			cf.deleted++
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns an estimate of the number of times the item was added.
+func (cf *CuckooFilter) Count(item string) int {
+	h, fp := hash(item)
+	count := 0
+	for _, f := range cf.filters {
+		count += f.count(h, fp)
+	}
+	return count
+}
+
+func (cf *CuckooFilter) Inserted() uint64 {
+	return cf.inserted
+}
+
+func (cf *CuckooFilter) Deleted() uint64 {
+	return cf.deleted
+}
+
+func (cf *CuckooFilter) Filters() int {
+	return len(cf.filters)
+}
+
+// Buckets returns the total number of buckets across all the sub-filters.
+func (cf *CuckooFilter) Buckets() uint64 {
+	var buckets uint64
+	for _, f := range cf.filters {
+		buckets += f.buckets
+	}
+	return buckets
+}
+
+func (cf *CuckooFilter) BucketSize() uint16 {
+	return cf.bucketSize
+}
+
+func (cf *CuckooFilter) MaxIterations() uint16 {
+	return cf.maxIterations
+}
+
+func (cf *CuckooFilter) Expansion() uint16 {
+	return cf.expansion
+}
+
+// Size returns the approximate memory used by the filter in bytes.
+func (cf *CuckooFilter) Size() int {
+	size := int(unsafe.Sizeof(*cf))
+	for _, f := range cf.filters {
+		size += int(unsafe.Sizeof(*f)) + len(f.slots)
+	}
+	return size
+}
+
+func (cf *CuckooFilter) TypeName() string {
+	return TypeName
+}
+
+func (cf *CuckooFilter) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, v := range []interface{}{
+		cf.capacity, cf.bucketSize, cf.maxIterations, cf.expansion,
+		cf.inserted, cf.deleted, cf.rand, uint32(len(cf.filters)),
+	} {
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+	for _, f := range cf.filters {
+		_ = binary.Write(buf, binary.LittleEndian, f.buckets)
+		_ = binary.Write(buf, binary.LittleEndian, f.slots)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a filter encoded by MarshalBinary. The data may come from a client with RESTORE, so
+// the filter is checked before it is used, and nothing larger than the data is allocated.
+func (cf *CuckooFilter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var count uint32
+	for _, v := range []interface{}{
+		&cf.capacity, &cf.bucketSize, &cf.maxIterations, &cf.expansion,
+		&cf.inserted, &cf.deleted, &cf.rand, &count,
+	} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if cf.bucketSize == 0 || count == 0 || uint64(count) > uint64(r.Len())/8 {
+		return errInvalidEncoding
+	}
+	cf.filters = make([]*filter, count)
+	for i := range cf.filters {
+		f := &filter{bucketSize: cf.bucketSize}
+		if err := binary.Read(r, binary.LittleEndian, &f.buckets); err != nil {
+			return err
+		}
+		// The number of buckets must be a power of two, see filter.
+		if f.buckets == 0 || f.buckets&(f.buckets-1) != 0 || f.buckets > uint64(r.Len())/uint64(f.bucketSize) {
+			return errInvalidEncoding
+		}
+		f.slots = make([]uint8, f.buckets*uint64(f.bucketSize))
+		if err := binary.Read(r, binary.LittleEndian, f.slots); err != nil {
+			return err
+		}
+		cf.filters[i] = f
+	}
+	if r.Len() != 0 {
+		return errInvalidEncoding
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lookupTimeout = 5 * time.Second
+
+// Discovery finds the memberlist addresses of the nodes of a cluster to join. The static seeds come first,
+// followed by the targets of the SRV records and the A and AAAA records of the configured names. The names
+// are resolved on every call, so that nodes that are added to DNS later are found.
+type Discovery struct {
+	seeds       []string
+	srvName     string
+	dnsName     string
+	defaultPort uint16
+	resolver    *net.Resolver
+}
+
+// WithSeeds sets the static memberlist addresses to join, in the order they are tried.
+func WithSeeds(seeds []string) func(discovery *Discovery) {
+	return func(discovery *Discovery) {
+		discovery.seeds = seeds
+	}
+}
+
+// WithSRVName sets the name of the SRV records that list the nodes, such as _echovault._tcp.example.com.
+func WithSRVName(name string) func(discovery *Discovery) {
+	return func(discovery *Discovery) {
+		discovery.srvName = name
+	}
+}
+
+// WithDNSName sets the host name whose A and AAAA records are the addresses of the nodes. The name may end with
+// a port, and the default port is used otherwise.
+func WithDNSName(name string) func(discovery *Discovery) {
+	return func(discovery *Discovery) {
+		discovery.dnsName = name
+	}
+}
+
+// WithDefaultPort sets the memberlist port of the addresses of the DNS name when it has no port.
+func WithDefaultPort(port uint16) func(discovery *Discovery) {
+	return func(discovery *Discovery) {
+		discovery.defaultPort = port
+	}
+}
+
+// WithResolverAddr sets the address of the DNS server to query instead of the system resolver.
+func WithResolverAddr(addr string) func(discovery *Discovery) {
+	return func(discovery *Discovery) {
+		if addr == "" {
+			return
+		}
+		discovery.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}
+	}
+}
+
+func NewDiscovery(options ...func(discovery *Discovery)) *Discovery {
+	discovery := &Discovery{
+		seeds:       make([]string, 0),
+		defaultPort: 7946,
+		resolver:    net.DefaultResolver,
+	}
+
+	for _, option := range options {
+		option(discovery)
+	}
+
+	return discovery
+}
+
+// Enabled reports whether there are any seeds or names to discover the nodes from.
+func (discovery *Discovery) Enabled() bool {
+	return len(discovery.seeds) > 0 || discovery.srvName != "" || discovery.dnsName != ""
+}
+
+// Addresses returns the addresses of the nodes without duplicates. If a lookup fails, the addresses found by the
+// other sources are returned along with the error.
+func (discovery *Discovery) Addresses(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	addresses := slices.Clone(discovery.seeds)
+	var errs []error
+
+	if discovery.srvName != "" {
+		_, records, err := discovery.resolver.LookupSRV(ctx, "", "", discovery.srvName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("lookup SRV records of %s: %w", discovery.srvName, err))
+		}
+		for _, record := range records {
+			hosts, err := discovery.lookupHost(ctx, strings.TrimSuffix(record.Target, "."), record.Port)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			addresses = append(addresses, hosts...)
+		}
+	}
+
+	if discovery.dnsName != "" {
+		host, port := discovery.dnsName, discovery.defaultPort
+		if h, p, err := net.SplitHostPort(discovery.dnsName); err == nil {
+			n, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in %s", discovery.dnsName)
+			}
+			host, port = h, uint16(n)
+		}
+		hosts, err := discovery.lookupHost(ctx, host, port)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		addresses = append(addresses, hosts...)
+	}
+
+	unique := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if !slices.Contains(unique, address) {
+			unique = append(unique, address)
+		}
+	}
+	return unique, errors.Join(errs...)
+}
+
+// lookupHost resolves the host with the resolver of the discovery, rather than leaving it to the memberlist,
+// which only uses the system resolver.
+func (discovery *Discovery) lookupHost(ctx context.Context, host string, port uint16) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{net.JoinHostPort(host, strconv.Itoa(int(port)))}, nil
+	}
+	ips, err := discovery.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s: %w", host, err)
+	}
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = net.JoinHostPort(ip, strconv.Itoa(int(port)))
+	}
+	return addresses, nil
+}
@@ -0,0 +1,362 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Persisted data is encrypted with envelope encryption. Each file, or each process for the raft log, gets a
+// random data key. The data is encrypted with the data key using AES-256-GCM, and the data key is encrypted
+// with a master key from the keyring. The encrypted data key is stored in a header in front of the data:
+//
+//	header:  magic "EVENC" | version (1 byte) | master key ID (8 bytes) | wrapped key length (2 bytes) | wrapped key
+//
+// The wrapped key is a nonce followed by the data key sealed with the master key. The master key ID is the
+// first 8 bytes of the SHA-256 digest of the master key. All integers are big endian.
+
+const (
+	headerMagic   = "EVENC"
+	headerVersion = 1
+	keySize       = 32
+	keyIDSize     = 8
+)
+
+var (
+	// ErrNoKeyring is returned when encrypted data is read without a keyring.
+	ErrNoKeyring = errors.New("data is encrypted but no encryption key is configured")
+	// ErrUnknownKey is returned when encrypted data was written with a master key that is not in the keyring.
+	ErrUnknownKey = errors.New("data is encrypted with a key that is not in the keyring")
+)
+
+type KeyID [keyIDSize]byte
+
+// NewKeyID returns the ID of a key, which is the first 8 bytes of the SHA-256 digest of the key.
+func NewKeyID(key []byte) KeyID {
+	var id KeyID
+	digest := sha256.Sum256(key)
+	copy(id[:], digest[:keyIDSize])
+	return id
+}
+
+func (id KeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Keyring holds the master keys. The last key is the active key, which encrypts new data.
+// The other keys are kept so that data written before the active key was rotated in stays readable.
+// The methods of a nil Keyring read and write plain data.
+type Keyring struct {
+	keys   map[KeyID]cipher.AEAD
+	active KeyID
+
+	mut       sync.Mutex
+	session   *DataKey            // The data key used by Seal. Created on first use.
+	header    []byte              // The header holding the session data key.
+	unwrapped map[string]*DataKey // The data keys read by Open, keyed by their header.
+}
+
+// LoadKeyring reads the master keys from the key file and from the environment variable with the given name.
+// If neither is set, a nil Keyring is returned and persisted data is not encrypted.
+func LoadKeyring(file string, env string) (*Keyring, error) {
+	keys, err := LoadKeys(file, env)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return NewKeyring(keys...)
+}
+
+// LoadKeys reads keys from the key file and from the environment variable with the given name.
+// Keys are separated by new lines in the file and by commas in the environment variable, and are hex or
+// base64 encoded 32 byte keys. Lines starting with '#' are ignored. The keys from the environment variable
+// come after the keys from the file, so the last key of the environment variable is the active key.
+// If neither is set, no keys are returned.
+func LoadKeys(file string, env string) ([][]byte, error) {
+	var encoded []string
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read encryption key file: %w", err)
+		}
+		encoded = append(encoded, strings.Split(string(b), "\n")...)
+	}
+	if env != "" {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("encryption key environment variable %s is not set", env)
+		}
+		encoded = append(encoded, strings.Split(value, ",")...)
+	}
+
+	var keys [][]byte
+	for _, s := range encoded {
+		s = strings.TrimSpace(s)
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		key, err := decodeKey(s)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 && (file != "" || env != "") {
+		return nil, errors.New("no encryption keys found")
+	}
+	return keys, nil
+}
+
+// NewKeyring returns a keyring with the given 32 byte master keys. The last key is the active key.
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys found")
+	}
+	keyring := &Keyring{
+		keys:      make(map[KeyID]cipher.AEAD),
+		unwrapped: make(map[string]*DataKey),
+	}
+	for _, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		keyring.active = NewKeyID(key)
+		keyring.keys[keyring.active] = aead
+	}
+	return keyring, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	if key, err := hex.DecodeString(s); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("encryption keys must be hex or base64 encoded %d byte keys", keySize)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("encryption keys must be %d bytes", keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ActiveKeyID returns the ID of the key that encrypts new data.
+func (keyring *Keyring) ActiveKeyID() KeyID {
+	return keyring.active
+}
+
+// NewDataKey returns a random data key and the header that stores it wrapped with the active master key.
+func (keyring *Keyring) NewDataKey() (*DataKey, []byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	dataKey, err := newDataKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	master := keyring.keys[keyring.active]
+	nonce := make([]byte, master.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	wrapped := master.Seal(nonce, nonce, key, keyring.active[:])
+
+	header := make([]byte, 0, len(headerMagic)+1+keyIDSize+2+len(wrapped))
+	header = append(header, headerMagic...)
+	header = append(header, headerVersion)
+	header = append(header, keyring.active[:]...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	return dataKey, header, nil
+}
+
+// ReadDataKey reads the header at the start of b and returns the data key it holds and the length of the header.
+func (keyring *Keyring) ReadDataKey(b []byte) (*DataKey, int, error) {
+	n, err := HeaderLength(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	if keyring == nil {
+		return nil, 0, ErrNoKeyring
+	}
+	var id KeyID
+	copy(id[:], b[len(headerMagic)+1:])
+	master, ok := keyring.keys[id]
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	wrapped := b[len(headerMagic)+1+keyIDSize+2 : n]
+	if len(wrapped) < master.NonceSize() {
+		return nil, 0, errors.New("invalid encryption header")
+	}
+	key, err := master.Open(nil, wrapped[:master.NonceSize()], wrapped[master.NonceSize():], id[:])
+	if err != nil {
+		return nil, 0, fmt.Errorf("unwrap data key: %w", err)
+	}
+	dataKey, err := newDataKey(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return dataKey, n, nil
+}
+
+// KeyIDOf returns the ID of the master key that encrypted b, and false if b is not encrypted.
+func KeyIDOf(b []byte) (KeyID, bool) {
+	var id KeyID
+	if _, err := HeaderLength(b); err != nil {
+		return id, false
+	}
+	copy(id[:], b[len(headerMagic)+1:])
+	return id, true
+}
+
+// IsEncrypted reports whether b starts with an encryption header.
+func IsEncrypted(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(headerMagic))
+}
+
+// HeaderLength returns the length of the encryption header at the start of b. The key is not needed.
+func HeaderLength(b []byte) (int, error) {
+	fixed := len(headerMagic) + 1 + keyIDSize + 2
+	if !IsEncrypted(b) {
+		return 0, errors.New("missing encryption header")
+	}
+	if len(b) < fixed {
+		return 0, fmt.Errorf("encryption header: %w", io.ErrUnexpectedEOF)
+	}
+	if b[len(headerMagic)] != headerVersion {
+		return 0, fmt.Errorf("unsupported encryption version %d", b[len(headerMagic)])
+	}
+	n := fixed + int(binary.BigEndian.Uint16(b[fixed-2:fixed]))
+	if len(b) < n {
+		return 0, fmt.Errorf("encryption header: %w", io.ErrUnexpectedEOF)
+	}
+	return n, nil
+}
+
+// Seal encrypts a record that is stored on its own, such as a raft log entry. The record holds the header of
+// a data key that is shared by all the records sealed by the keyring. A nil Keyring returns b as it is.
+func (keyring *Keyring) Seal(b []byte) ([]byte, error) {
+	if keyring == nil {
+		return b, nil
+	}
+	keyring.mut.Lock()
+	if keyring.session == nil {
+		dataKey, header, err := keyring.NewDataKey()
+		if err != nil {
+			keyring.mut.Unlock()
+			return nil, err
+		}
+		keyring.session, keyring.header = dataKey, header
+	}
+	dataKey, header := keyring.session, keyring.header
+	keyring.mut.Unlock()
+
+	return dataKey.Seal(append([]byte{}, header...), b, nil)
+}
+
+// Open decrypts a record sealed with Seal. Records that are not encrypted are returned as they are,
+// so that records written before encryption was enabled stay readable.
+func (keyring *Keyring) Open(b []byte) ([]byte, error) {
+	if !IsEncrypted(b) {
+		return b, nil
+	}
+	n, err := HeaderLength(b)
+	if err != nil {
+		return nil, err
+	}
+	if keyring == nil {
+		return nil, ErrNoKeyring
+	}
+
+	keyring.mut.Lock()
+	dataKey, ok := keyring.unwrapped[string(b[:n])]
+	keyring.mut.Unlock()
+	if !ok {
+		if dataKey, _, err = keyring.ReadDataKey(b); err != nil {
+			return nil, err
+		}
+		keyring.mut.Lock()
+		keyring.unwrapped[string(b[:n])] = dataKey
+		keyring.mut.Unlock()
+	}
+
+	return dataKey.Open(b[n:], nil)
+}
+
+// DataKey encrypts the data that follows an encryption header.
+type DataKey struct {
+	aead cipher.AEAD
+}
+
+func newDataKey(key []byte) (*DataKey, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{aead: aead}, nil
+}
+
+// Overhead is the number of bytes that Seal adds to the plaintext.
+func (dataKey *DataKey) Overhead() int {
+	return dataKey.aead.NonceSize() + dataKey.aead.Overhead()
+}
+
+// Seal appends a random nonce and the sealed plaintext to dst.
+// The additional data is authenticated but not encrypted.
+func (dataKey *DataKey) Seal(dst []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, dataKey.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return dataKey.aead.Seal(append(dst, nonce...), nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts the output of Seal.
+func (dataKey *DataKey) Open(ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < dataKey.Overhead() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	nonceSize := dataKey.aead.NonceSize()
+	plaintext, err := dataKey.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An encrypted stream, such as a snapshot file, is laid out as follows:
+//
+//	stream:  header | chunk*
+//	chunk:   length (4 bytes) | sealed chunk
+//
+// Each chunk holds up to 64 KiB of the stream. The high bit of the length marks the final chunk. The index of
+// the chunk and the final bit are authenticated with the chunk, so chunks cannot be reordered, dropped or
+// truncated without the reader noticing.
+
+const (
+	chunkSize  = 64 * 1024
+	finalChunk = 1 << 31
+)
+
+type writer struct {
+	w       io.Writer
+	dataKey *DataKey
+	buf     []byte
+	index   uint64
+	closed  bool
+}
+
+// NewWriter writes an encryption header to w and returns a writer that encrypts the stream with a new data key.
+// Close must be called to write the final chunk. It does not close w. A nil Keyring returns a writer that
+// writes to w as it is.
+func (keyring *Keyring) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if keyring == nil {
+		return nopCloser{w}, nil
+	}
+	dataKey, header, err := keyring.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, dataKey: dataKey, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encryption writer")
+	}
+	n := len(p)
+	for len(p) > 0 {
+		m := min(len(p), chunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+		if len(w.buf) == chunkSize {
+			if err := w.writeChunk(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.writeChunk(true)
+}
+
+func (w *writer) writeChunk(final bool) error {
+	sealed, err := w.dataKey.Seal(make([]byte, 4), w.buf, chunkData(w.index, final))
+	if err != nil {
+		return err
+	}
+	length := uint32(len(sealed) - 4)
+	if final {
+		length |= finalChunk
+	}
+	binary.BigEndian.PutUint32(sealed, length)
+	if _, err = w.w.Write(sealed); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.index++
+	return nil
+}
+
+// chunkData returns the additional data that is authenticated with a chunk.
+func chunkData(index uint64, final bool) []byte {
+	b := binary.BigEndian.AppendUint64(nil, index)
+	if final {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+type reader struct {
+	r       *bufio.Reader
+	dataKey *DataKey
+	buf     []byte
+	index   uint64
+	done    bool
+}
+
+// NewReader returns a reader that decrypts the stream in r. Streams that are not encrypted are read as they
+// are, so that files written before encryption was enabled stay readable.
+func (keyring *Keyring) NewReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(headerMagic)); err != nil || !IsEncrypted(magic) {
+		return br, nil
+	}
+
+	fixed, err := br.Peek(len(headerMagic) + 1 + keyIDSize + 2)
+	if err != nil {
+		return nil, fmt.Errorf("encryption header: %w", io.ErrUnexpectedEOF)
+	}
+	n := len(fixed) + int(binary.BigEndian.Uint16(fixed[len(fixed)-2:]))
+	header := make([]byte, n)
+	if _, err = io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("encryption header: %w", io.ErrUnexpectedEOF)
+	}
+	dataKey, _, err := keyring.ReadDataKey(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{r: br, dataKey: dataKey}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) readChunk() error {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+		// The stream ended before the final chunk.
+		return fmt.Errorf("encrypted stream: %w", io.ErrUnexpectedEOF)
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	final := length&finalChunk != 0
+	length &^= finalChunk
+	if length > chunkSize+uint32(r.dataKey.Overhead()) {
+		return errors.New("encrypted stream: invalid chunk length")
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(r.r, sealed); err != nil {
+		return fmt.Errorf("encrypted stream: %w", io.ErrUnexpectedEOF)
+	}
+	chunk, err := r.dataKey.Open(sealed, chunkData(r.index, final))
+	if err != nil {
+		return fmt.Errorf("encrypted stream chunk %d: %w", r.index, err)
+	}
+	r.buf = chunk
+	r.index++
+	r.done = final
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
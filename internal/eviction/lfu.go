@@ -97,6 +97,19 @@ func (cache *CacheLFU) Update(key string) {
 	heap.Fix(cache, entryIdx)
 }
 
+// SetCount sets the access count of the key, adding the key if it's not in the cache.
+func (cache *CacheLFU) SetCount(key string, count int) {
+	if !cache.contains(key) {
+		heap.Push(cache, key)
+	}
+	entryIdx := slices.IndexFunc(cache.entries, func(e *EntryLFU) bool {
+		return e.key == key
+	})
+	entry := cache.entries[entryIdx]
+	entry.count = count
+	heap.Fix(cache, entryIdx)
+}
+
 func (cache *CacheLFU) Delete(key string) {
 	entryIdx := slices.IndexFunc(cache.entries, func(entry *EntryLFU) bool {
 		return entry.key == key
@@ -61,6 +61,7 @@ func (cache *CacheLRU) Push(key any) {
 		unixTime: time.Now().Unix(),
 		index:    n,
 	})
+	cache.keys[key.(string)] = true
 }
 
 func (cache *CacheLRU) Pop() any {
@@ -88,6 +89,19 @@ func (cache *CacheLRU) Update(key string) {
 	heap.Fix(cache, entryIdx)
 }
 
+// SetIdleTime sets the last access time of the key to idleTime ago, adding the key if it's not in the cache.
+func (cache *CacheLRU) SetIdleTime(key string, idleTime time.Duration) {
+	if !cache.contains(key) {
+		heap.Push(cache, key)
+	}
+	entryIdx := slices.IndexFunc(cache.entries, func(e *EntryLRU) bool {
+		return e.key == key
+	})
+	entry := cache.entries[entryIdx]
+	entry.unixTime = time.Now().Add(-idleTime).Unix()
+	heap.Fix(cache, entryIdx)
+}
+
 func (cache *CacheLRU) Delete(key string) {
 	entryIdx := slices.IndexFunc(cache.entries, func(entry *EntryLRU) bool {
 		return entry.key == key
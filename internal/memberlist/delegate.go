@@ -23,7 +23,6 @@ import (
 	"github.com/hashicorp/memberlist"
 	"github.com/hashicorp/raft"
 	"log"
-	"time"
 )
 
 type Delegate struct {
@@ -33,7 +32,7 @@ type Delegate struct {
 type DelegateOpts struct {
 	config         config.Config
 	broadcastQueue *memberlist.TransmitLimitedQueue
-	addVoter       func(id raft.ServerID, address raft.ServerAddress, prevIndex uint64, timeout time.Duration) error
+	addRaftServer  func(meta NodeMeta) error
 	isRaftLeader   func() bool
 	applyMutate    func(ctx context.Context, cmd []string) ([]byte, error)
 	applyDeleteKey func(ctx context.Context, key string) error
@@ -52,6 +51,7 @@ func (delegate *Delegate) NodeMeta(limit int) []byte {
 		RaftAddr: raft.ServerAddress(
 			fmt.Sprintf("%s:%d", delegate.options.config.BindAddr, delegate.options.config.RaftBindPort)),
 		MemberlistAddr: fmt.Sprintf("%s:%d", delegate.options.config.BindAddr, delegate.options.config.MemberListBindPort),
+		Learner:        delegate.options.config.Learner,
 	}
 
 	b, err := json.Marshal(&meta)
@@ -79,7 +79,7 @@ func (delegate *Delegate) NotifyMsg(msgBytes []byte) {
 			delegate.options.broadcastQueue.QueueBroadcast(&msg)
 			return
 		}
-		err := delegate.options.addVoter(msg.NodeMeta.ServerID, msg.NodeMeta.RaftAddr, 0, 0)
+		err := delegate.options.addRaftServer(msg.NodeMeta)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/raft"
 )
 
 type EventDelegate struct {
@@ -28,6 +29,11 @@ type EventDelegateOpts struct {
 	incrementNodes   func()
 	decrementNodes   func()
 	removeRaftServer func(meta NodeMeta) error
+	addRaftServer    func(meta NodeMeta) error
+	isRaftLeader     func() bool
+	serverID         raft.ServerID
+	nodeFound        func(name string)
+	nodeLost         func(name string, address string)
 }
 
 func NewEventDelegate(opts EventDelegateOpts) *EventDelegate {
@@ -39,12 +45,34 @@ func NewEventDelegate(opts EventDelegateOpts) *EventDelegate {
 // NotifyJoin implements EventDelegate interface
 func (eventDelegate *EventDelegate) NotifyJoin(node *memberlist.Node) {
 	eventDelegate.options.incrementNodes()
+	eventDelegate.options.nodeFound(node.Name)
+
+	// The leader adds nodes that rejoin after a network partition, as it removed them from the raft configuration
+	// when they failed. New nodes also ask the leader to add them with a RaftJoin broadcast.
+	var meta NodeMeta
+	if err := json.Unmarshal(node.Meta, &meta); err != nil || meta.ServerID == eventDelegate.options.serverID {
+		return
+	}
+	if !eventDelegate.options.isRaftLeader() {
+		return
+	}
+	go func() {
+		if err := eventDelegate.options.addRaftServer(meta); err != nil {
+			fmt.Println(err)
+		}
+	}()
 }
 
 // NotifyLeave implements EventDelegate interface
 func (eventDelegate *EventDelegate) NotifyLeave(node *memberlist.Node) {
 	eventDelegate.options.decrementNodes()
 
+	if node.State == memberlist.StateDead {
+		eventDelegate.options.nodeLost(node.Name, node.Address())
+	} else {
+		eventDelegate.options.nodeFound(node.Name)
+	}
+
 	var meta NodeMeta
 
 	err := json.Unmarshal(node.Meta, &meta)
@@ -15,12 +15,22 @@
 package memberlist
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/echovault/echovault/internal"
 	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/discovery"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/pkg/types"
 	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/memberlist"
@@ -32,41 +42,86 @@ type NodeMeta struct {
 	ServerID       raft.ServerID      `json:"ServerID"`
 	MemberlistAddr string             `json:"MemberlistAddr"`
 	RaftAddr       raft.ServerAddress `json:"RaftAddr"`
+	Learner        bool               `json:"Learner,omitempty"` // True if the node joins the raft cluster as a non-voter
+}
+
+// NodeState describes a node that the memberlist considers to be alive or suspects to have failed.
+type NodeState struct {
+	Meta    NodeMeta
+	Address string // Memberlist address of the node
+	Health  string // alive or suspect
 }
 
 type Opts struct {
 	Config           config.Config
 	HasJoinedCluster func() bool
 	AddVoter         func(id raft.ServerID, address raft.ServerAddress, prevIndex uint64, timeout time.Duration) error
+	AddNonvoter      func(id raft.ServerID, address raft.ServerAddress, prevIndex uint64, timeout time.Duration) error
 	RemoveRaftServer func(meta NodeMeta) error
 	IsRaftLeader     func() bool
 	ApplyMutate      func(ctx context.Context, cmd []string) ([]byte, error)
 	ApplyDeleteKey   func(ctx context.Context, key string) error
+	GossipKeys       [][]byte // Encrypt the gossip when set. The last key is the primary key, which encrypts the gossip.
 }
 
+const (
+	rejoinInterval   = 10 * time.Second
+	reconnectTimeout = 24 * time.Hour // How long to try to rejoin a node that failed before giving up on it.
+)
+
 type MemberList struct {
 	options        Opts
 	broadcastQueue *memberlist.TransmitLimitedQueue
 	numOfNodes     int
 	memberList     *memberlist.Memberlist
+	discovery      *discovery.Discovery
+	keyring        *memberlist.Keyring // Nil if the gossip is not encrypted.
+
+	lostMutex sync.Mutex
+	lost      map[string]lostNode // The nodes that failed without leaving, by name.
+	left      atomic.Bool         // True once the node left the cluster. It does not rejoin afterwards.
+}
+
+type lostNode struct {
+	address string
+	since   time.Time
 }
 
 func NewMemberList(opts Opts) *MemberList {
+	var seeds []string
+	for _, seed := range strings.Split(opts.Config.JoinAddr, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			seeds = append(seeds, seed)
+		}
+	}
 	return &MemberList{
 		options:        opts,
 		broadcastQueue: new(memberlist.TransmitLimitedQueue),
 		numOfNodes:     0,
+		discovery: discovery.NewDiscovery(
+			discovery.WithSeeds(seeds),
+			discovery.WithSRVName(opts.Config.DiscoverySRV),
+			discovery.WithDNSName(opts.Config.DiscoveryDNS),
+			discovery.WithDefaultPort(opts.Config.MemberListBindPort),
+			discovery.WithResolverAddr(opts.Config.DiscoveryResolver),
+		),
+		lost: make(map[string]lostNode),
 	}
 }
 
 func (m *MemberList) MemberListInit(ctx context.Context) {
 	cfg := memberlist.DefaultLocalConfig()
+	// Name the node after its server ID, which is unique in the cluster, rather than the host name,
+	// so that several nodes can run on the same host.
+	if m.options.Config.ServerID != "" {
+		cfg.Name = m.options.Config.ServerID
+	}
 	cfg.BindAddr = m.options.Config.BindAddr
 	cfg.BindPort = int(m.options.Config.MemberListBindPort)
 	cfg.Delegate = NewDelegate(DelegateOpts{
 		config:         m.options.Config,
 		broadcastQueue: m.broadcastQueue,
-		addVoter:       m.options.AddVoter,
+		addRaftServer:  m.addRaftServer,
 		isRaftLeader:   m.options.IsRaftLeader,
 		applyMutate:    m.options.ApplyMutate,
 		applyDeleteKey: m.options.ApplyDeleteKey,
@@ -75,8 +130,24 @@ func (m *MemberList) MemberListInit(ctx context.Context) {
 		incrementNodes:   func() { m.numOfNodes += 1 },
 		decrementNodes:   func() { m.numOfNodes -= 1 },
 		removeRaftServer: m.options.RemoveRaftServer,
+		addRaftServer:    m.addRaftServer,
+		isRaftLeader:     m.options.IsRaftLeader,
+		serverID:         raft.ServerID(m.options.Config.ServerID),
+		nodeFound:        m.nodeFound,
+		nodeLost:         m.nodeLost,
 	})
 
+	// Every node must have the primary key of the other nodes in its keyring, and gossip that is not encrypted
+	// is rejected.
+	if keys := m.options.GossipKeys; len(keys) > 0 {
+		keyring, err := memberlist.NewKeyring(keys[:len(keys)-1], keys[len(keys)-1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Keyring = keyring
+		m.keyring = keyring
+	}
+
 	m.broadcastQueue.RetransmitMult = 1
 	m.broadcastQueue.NumNodes = func() int {
 		return m.numOfNodes
@@ -89,25 +160,122 @@ func (m *MemberList) MemberListInit(ctx context.Context) {
 		log.Fatal(err)
 	}
 
-	if m.options.Config.JoinAddr != "" {
+	if m.discovery.Enabled() {
 		backoffPolicy := internal.RetryBackoff(retry.NewFibonacci(1*time.Second), 5, 200*time.Millisecond, 0, 0)
 
 		err = retry.Do(ctx, backoffPolicy, func(ctx context.Context) error {
-			_, err = list.Join([]string{m.options.Config.JoinAddr})
-			if err != nil {
+			if err := m.join(ctx); err != nil {
 				return retry.RetryableError(err)
 			}
 			return nil
 		})
 
+		// Keep the node running if the cluster cannot be reached yet. It keeps trying to join in the background.
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("could not join the cluster, retrying every %s: %v\n", rejoinInterval, err)
+		} else {
+			m.broadcastRaftAddress()
 		}
+	}
 
+	go m.rejoin(ctx)
+}
+
+// join tries the addresses of the failed nodes and the discovered addresses in order, and stops at the first one
+// that answers. Joining one node is enough, as the memberlist then exchanges its full state with that node.
+func (m *MemberList) join(ctx context.Context) error {
+	addresses := m.lostAddresses()
+	discovered, err := m.discovery.Addresses(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+	addresses = append(addresses, discovered...)
+
+	local := m.memberList.LocalNode().Address()
+	bind := fmt.Sprintf("%s:%d", m.options.Config.BindAddr, m.options.Config.MemberListBindPort)
+	var errs []error
+	for _, address := range addresses {
+		if address == local || address == bind {
+			continue
+		}
+		if _, err = m.memberList.Join([]string{address}); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return nil
+	}
+	if len(errs) == 0 {
+		return errors.New("no cluster members to join")
+	}
+	return errors.Join(errs...)
+}
+
+// rejoin periodically joins the cluster again while the node is alone, for example because no seed could be
+// reached on startup, or while nodes that failed without leaving are missing, for example after a network
+// partition. Once the partition heals, the leader adds the rejoined nodes back to the raft configuration.
+func (m *MemberList) rejoin(ctx context.Context) {
+	ticker := time.NewTicker(rejoinInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if m.left.Load() {
+			return
+		}
+		alone := m.discovery.Enabled() && m.memberList.NumMembers() <= 1
+		if !alone && len(m.lostAddresses()) == 0 {
+			continue
+		}
+		if err := m.join(ctx); err != nil {
+			log.Printf("could not rejoin the cluster: %v\n", err)
+			continue
+		}
 		m.broadcastRaftAddress()
 	}
 }
 
+// nodeLost records a node that failed without leaving, so that it is rejoined once it can be reached again.
+func (m *MemberList) nodeLost(name string, address string) {
+	m.lostMutex.Lock()
+	defer m.lostMutex.Unlock()
+	m.lost[name] = lostNode{address: address, since: time.Now()}
+}
+
+// nodeFound forgets a failed node once it has rejoined or left.
+func (m *MemberList) nodeFound(name string) {
+	m.lostMutex.Lock()
+	defer m.lostMutex.Unlock()
+	delete(m.lost, name)
+}
+
+// lostAddresses returns the addresses of the failed nodes that have not been given up on yet.
+func (m *MemberList) lostAddresses() []string {
+	m.lostMutex.Lock()
+	defer m.lostMutex.Unlock()
+	var addresses []string
+	for name, node := range m.lost {
+		if time.Since(node.since) > reconnectTimeout {
+			delete(m.lost, name)
+			continue
+		}
+		addresses = append(addresses, node.address)
+	}
+	slices.Sort(addresses)
+	return addresses
+}
+
+// addRaftServer adds a node to the raft configuration. A learner joins as a non-voter, which receives the log
+// but does not count towards the quorum.
+func (m *MemberList) addRaftServer(meta NodeMeta) error {
+	if meta.Learner {
+		return m.options.AddNonvoter(meta.ServerID, meta.RaftAddr, 0, 0)
+	}
+	return m.options.AddVoter(meta.ServerID, meta.RaftAddr, 0, 0)
+}
+
 func (m *MemberList) broadcastRaftAddress() {
 	msg := BroadcastMessage{
 		Action: "RaftJoin",
@@ -115,6 +283,7 @@ func (m *MemberList) broadcastRaftAddress() {
 			ServerID: raft.ServerID(m.options.Config.ServerID),
 			RaftAddr: raft.ServerAddress(fmt.Sprintf("%s:%d",
 				m.options.Config.BindAddr, m.options.Config.RaftBindPort)),
+			Learner: m.options.Config.Learner,
 		},
 	}
 	m.broadcastQueue.QueueBroadcast(&msg)
@@ -154,8 +323,78 @@ func (m *MemberList) ForwardDataMutation(ctx context.Context, cmd []byte) {
 	})
 }
 
+// Nodes returns the nodes that are alive or suspected to have failed, including the local node.
+// Nodes that failed or left are not returned.
+func (m *MemberList) Nodes() []NodeState {
+	var nodes []NodeState
+	for _, node := range m.memberList.Members() {
+		var meta NodeMeta
+		if err := json.Unmarshal(node.Meta, &meta); err != nil {
+			meta.ServerID = raft.ServerID(node.Name)
+		}
+		health := "alive"
+		if node.State == memberlist.StateSuspect {
+			health = "suspect"
+		}
+		nodes = append(nodes, NodeState{Meta: meta, Address: node.Address(), Health: health})
+	}
+	return nodes
+}
+
+// GossipKeys returns the IDs of the keys in the gossip keyring, and whether they are the primary key.
+func (m *MemberList) GossipKeys() []types.GossipKey {
+	if m.keyring == nil {
+		return []types.GossipKey{}
+	}
+	primary := m.keyring.GetPrimaryKey()
+	keys := make([]types.GossipKey, 0)
+	for _, key := range m.keyring.GetKeys() {
+		keys = append(keys, types.GossipKey{
+			ID:      encryption.NewKeyID(key).String(),
+			Primary: bytes.Equal(key, primary),
+		})
+	}
+	return keys
+}
+
+// SetGossipKeys replaces the keys of the gossip keyring. The last key becomes the primary key.
+// To rotate the primary key without interrupting the gossip, first add the new key before the current primary
+// key on every node, then move it to the end on every node, and finally remove the old key on every node.
+func (m *MemberList) SetGossipKeys(keys [][]byte) error {
+	if m.keyring == nil {
+		return errors.New("gossip encryption is not enabled")
+	}
+	if len(keys) == 0 {
+		return errors.New("cannot remove every gossip key")
+	}
+	for _, key := range keys {
+		if err := m.keyring.AddKey(key); err != nil {
+			return err
+		}
+	}
+	if err := m.keyring.UseKey(keys[len(keys)-1]); err != nil {
+		return err
+	}
+	for _, key := range m.keyring.GetKeys() {
+		if !slices.ContainsFunc(keys, func(k []byte) bool { return bytes.Equal(k, key) }) {
+			if err := m.keyring.RemoveKey(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Leave gracefully leaves the memberlist cluster without shutting the memberlist down. The other nodes are
+// notified, and the leader removes the node from the raft configuration.
+func (m *MemberList) Leave() error {
+	m.left.Store(true)
+	return m.memberList.Leave(500 * time.Millisecond)
+}
+
 func (m *MemberList) MemberListShutdown() {
 	// Gracefully leave memberlist cluster
+	m.left.Store(true)
 	err := m.memberList.Leave(500 * time.Millisecond)
 	if err != nil {
 		log.Fatal("Could not gracefully leave memberlist cluster")
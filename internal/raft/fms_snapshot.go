@@ -15,24 +15,32 @@
 package raft
 
 import (
-	"encoding/json"
 	"github.com/echovault/echovault/internal"
 	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/internal/snapshot"
 	"github.com/hashicorp/raft"
+	"io"
 	"strconv"
 	"strings"
 )
 
 type SnapshotOpts struct {
 	config                config.Config
-	data                  map[string]internal.KeyData
+	keyring               *encryption.Keyring
+	state                 internal.StateCapture
+	indexes               []search.Schema
 	startSnapshot         func()
-	finishSnapshot        func()
+	finishSnapshot        func(size int64, err error)
 	setLatestSnapshotTime func(msec int64)
 }
 
 type Snapshot struct {
-	options SnapshotOpts
+	options   SnapshotOpts
+	persisted bool  // True once Persist was called.
+	size      int64 // The number of bytes written by Persist.
+	err       error // The error returned by Persist.
 }
 
 func NewFSMSnapshot(opts SnapshotOpts) *Snapshot {
@@ -44,36 +52,67 @@ func NewFSMSnapshot(opts SnapshotOpts) *Snapshot {
 // Persist implements FSMSnapshot interface
 func (s *Snapshot) Persist(sink raft.SnapshotSink) error {
 	s.options.startSnapshot()
+	s.persisted = true
 
 	msec, err := strconv.Atoi(strings.Split(sink.ID(), "-")[2])
 	if err != nil {
 		_ = sink.Cancel()
+		s.err = err
 		return err
 	}
 
-	snapshotObject := internal.SnapshotObject{
-		State:                      internal.FilterExpiredKeys(s.options.data),
-		LatestSnapshotMilliseconds: int64(msec),
+	// Stream the state to the sink record by record instead of marshalling the whole state at once.
+	if err = s.write(&countingWriter{w: sink, n: &s.size}, int64(msec)); err != nil {
+		_ = sink.Cancel()
+		s.err = err
+		return err
 	}
 
-	o, err := json.Marshal(snapshotObject)
+	s.options.setLatestSnapshotTime(int64(msec))
 
+	return nil
+}
+
+func (s *Snapshot) write(sink io.Writer, msec int64) error {
+	w, err := s.options.keyring.NewWriter(sink)
 	if err != nil {
-		_ = sink.Cancel()
 		return err
 	}
-
-	if _, err = sink.Write(o); err != nil {
-		_ = sink.Cancel()
+	encoder, err := snapshot.NewEncoder(w, s.options.config.SnapshotCompression)
+	if err != nil {
 		return err
 	}
-
-	s.options.setLatestSnapshotTime(int64(msec))
-
-	return nil
+	if err = encoder.WriteMeta(msec); err != nil {
+		return err
+	}
+	if err = encoder.WriteIndexes(s.options.indexes); err != nil {
+		return err
+	}
+	if err = encoder.WriteCapture(s.options.state); err != nil {
+		return err
+	}
+	if err = encoder.Close(); err != nil {
+		return err
+	}
+	return w.Close()
 }
 
 // Release implements FSMSnapshot interface
 func (s *Snapshot) Release() {
-	s.options.finishSnapshot()
+	s.options.state.Release()
+	if s.persisted {
+		s.options.finishSnapshot(s.size, s.err)
+	}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	*w.n += int64(n)
+	return n, err
 }
@@ -20,6 +20,9 @@ import (
 	"fmt"
 	"github.com/echovault/echovault/internal"
 	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/search"
+	internal_snapshot "github.com/echovault/echovault/internal/snapshot"
 	"github.com/echovault/echovault/pkg/types"
 	"github.com/hashicorp/raft"
 	"io"
@@ -29,13 +32,20 @@ import (
 
 type FSMOpts struct {
 	Config                config.Config
+	Keyring               *encryption.Keyring
 	EchoVault             types.EchoVault
-	GetState              func() map[string]internal.KeyData
+	GetState              func() internal.StateCapture
 	GetCommand            func(command string) (types.Command, error)
 	DeleteKey             func(ctx context.Context, key string) error
+	ApplyWrite            func(cmd []string, apply func() ([]byte, error)) ([]byte, error)
 	StartSnapshot         func()
-	FinishSnapshot        func()
+	FinishSnapshot        func(size int64, err error)
 	SetLatestSnapshotTime func(msec int64)
+	GetIndexes            func() []search.Schema
+	RestoreIndexes        func(schemas []search.Schema)
+	// Replicate applies a REPLICATION command that the replica cluster received from the primary cluster.
+	// The entries of the primary are passed to apply.
+	Replicate func(cmd []string, apply func(request internal.ApplyRequest) ([]byte, error)) ([]byte, error)
 }
 
 type FSM struct {
@@ -63,106 +73,136 @@ func (fsm *FSM) Apply(log *raft.Log) interface{} {
 			}
 		}
 
-		ctx := context.WithValue(context.Background(), internal.ContextServerID("ServerID"), request.ServerID)
-		ctx = context.WithValue(ctx, internal.ContextConnID("ConnectionID"), request.ConnectionID)
+		if strings.EqualFold(request.Type, "replication") {
+			// The entries shipped by the primary cluster are applied like the entries of this cluster.
+			res, err := fsm.options.Replicate(request.CMD, func(request internal.ApplyRequest) ([]byte, error) {
+				response := fsm.apply(request)
+				return response.Response, response.Error
+			})
+			return internal.ApplyResponse{
+				Error:    err,
+				Response: res,
+			}
+		}
+
+		return fsm.apply(request)
+	}
 
-		switch strings.ToLower(request.Type) {
-		default:
+	return nil
+}
+
+func (fsm *FSM) apply(request internal.ApplyRequest) internal.ApplyResponse {
+	ctx := context.WithValue(context.Background(), internal.ContextServerID("ServerID"), request.ServerID)
+	ctx = context.WithValue(ctx, internal.ContextConnID("ConnectionID"), request.ConnectionID)
+
+	switch strings.ToLower(request.Type) {
+	default:
+		return internal.ApplyResponse{
+			Error:    fmt.Errorf("unsupported raft command type %s", request.Type),
+			Response: nil,
+		}
+
+	case "delete-key":
+		// The deletion is recorded as a DEL command in the local persistence of the node.
+		_, err := fsm.options.ApplyWrite([]string{"DEL", request.Key}, func() ([]byte, error) {
+			return nil, fsm.options.DeleteKey(ctx, request.Key)
+		})
+		if err != nil {
 			return internal.ApplyResponse{
-				Error:    fmt.Errorf("unsupported raft command type %s", request.Type),
+				Error:    err,
 				Response: nil,
 			}
+		}
+		return internal.ApplyResponse{
+			Error:    nil,
+			Response: []byte("OK"),
+		}
 
-		case "delete-key":
-			if err := fsm.options.DeleteKey(ctx, request.Key); err != nil {
-				return internal.ApplyResponse{
-					Error:    err,
-					Response: nil,
-				}
-			}
+	case "command":
+		// Handle command
+		command, err := fsm.options.GetCommand(request.CMD[0])
+		if err != nil {
 			return internal.ApplyResponse{
-				Error:    nil,
-				Response: []byte("OK"),
+				Error:    err,
+				Response: nil,
 			}
+		}
 
-		case "command":
-			// Handle command
-			command, err := fsm.options.GetCommand(request.CMD[0])
-			if err != nil {
-				return internal.ApplyResponse{
-					Error:    err,
-					Response: nil,
-				}
-			}
+		handler := command.HandlerFunc
 
-			handler := command.HandlerFunc
+		subCommand, ok := internal.GetSubCommand(command, request.CMD).(types.SubCommand)
+		if ok {
+			handler = subCommand.HandlerFunc
+		}
 
-			subCommand, ok := internal.GetSubCommand(command, request.CMD).(types.SubCommand)
-			if ok {
-				handler = subCommand.HandlerFunc
-			}
+		apply := func() ([]byte, error) {
+			return handler(ctx, request.CMD, fsm.options.EchoVault, nil)
+		}
 
-			if res, err := handler(ctx, request.CMD, fsm.options.EchoVault, nil); err != nil {
-				return internal.ApplyResponse{
-					Error:    err,
-					Response: nil,
-				}
-			} else {
-				return internal.ApplyResponse{
-					Error:    nil,
-					Response: res,
-				}
+		var res []byte
+		if internal.IsWriteCommand(command, subCommand) {
+			res, err = fsm.options.ApplyWrite(request.CMD, apply)
+		} else {
+			res, err = apply()
+		}
+		if err != nil {
+			return internal.ApplyResponse{
+				Error:    err,
+				Response: nil,
 			}
 		}
+		return internal.ApplyResponse{
+			Error:    nil,
+			Response: res,
+		}
 	}
-
-	return nil
 }
 
-// Snapshot implements raft.FSM interface
+// Snapshot implements raft.FSM interface. It runs on the FSM goroutine, so it only starts a capture of the state,
+// and the values are copied as Persist writes them.
 func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
 	return NewFSMSnapshot(SnapshotOpts{
 		config:                fsm.options.Config,
+		keyring:               fsm.options.Keyring,
 		startSnapshot:         fsm.options.StartSnapshot,
 		finishSnapshot:        fsm.options.FinishSnapshot,
 		setLatestSnapshotTime: fsm.options.SetLatestSnapshotTime,
-		data:                  fsm.options.GetState(),
+		state:                 fsm.options.GetState(),
+		indexes:               fsm.options.GetIndexes(),
 	}), nil
 }
 
 // Restore implements raft.FSM interface
 func (fsm *FSM) Restore(snapshot io.ReadCloser) error {
-	b, err := io.ReadAll(snapshot)
+	defer func() {
+		_ = snapshot.Close()
+	}()
 
+	r, err := fsm.options.Keyring.NewReader(snapshot)
 	if err != nil {
 		log.Fatal(err)
 		return err
 	}
 
-	data := internal.SnapshotObject{
-		State:                      make(map[string]internal.KeyData),
-		LatestSnapshotMilliseconds: 0,
-	}
-
-	if err = json.Unmarshal(b, &data); err != nil {
-		log.Fatal(err)
-		return err
-	}
-
-	// Set state
+	// Index definitions are restored before the keys so that the indexes are rebuilt as the keys are loaded.
 	ctx := context.Background()
-	for k, v := range internal.FilterExpiredKeys(data.State) {
-		if _, err = fsm.options.EchoVault.CreateKeyAndLock(ctx, k); err != nil {
+	msec, err := internal_snapshot.Load(r, fsm.options.RestoreIndexes, func(k string, v internal.KeyData) {
+		if _, err := fsm.options.EchoVault.CreateKeyAndLock(ctx, k); err != nil {
 			log.Fatal(err)
 		}
-		if err = fsm.options.EchoVault.SetValue(ctx, k, v.Value); err != nil {
+		if err := fsm.options.EchoVault.SetValue(ctx, k, v.Value); err != nil {
 			log.Fatal(err)
 		}
 		fsm.options.EchoVault.SetExpiry(ctx, k, v.ExpireAt, false)
 		fsm.options.EchoVault.KeyUnlock(ctx, k)
+	})
+	if err != nil {
+		log.Fatal(err)
+		return err
 	}
+
 	// Set latest snapshot milliseconds
-	fsm.options.SetLatestSnapshotTime(data.LatestSnapshotMilliseconds)
+	fsm.options.SetLatestSnapshotTime(msec)
 
 	return nil
 }
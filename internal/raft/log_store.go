@@ -0,0 +1,68 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"fmt"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/hashicorp/raft"
+)
+
+// encryptedLogStore encrypts the data of the log entries before they are stored, and decrypts it when the
+// entries are read. Entries that were stored before encryption was enabled are read as they are.
+type encryptedLogStore struct {
+	raft.LogStore
+	keyring *encryption.Keyring
+}
+
+func newEncryptedLogStore(store raft.LogStore, keyring *encryption.Keyring) raft.LogStore {
+	if keyring == nil {
+		return store
+	}
+	return &encryptedLogStore{LogStore: store, keyring: keyring}
+}
+
+func (store *encryptedLogStore) GetLog(index uint64, log *raft.Log) error {
+	if err := store.LogStore.GetLog(index, log); err != nil {
+		return err
+	}
+	data, err := store.keyring.Open(log.Data)
+	if err != nil {
+		return fmt.Errorf("decrypt raft log index %d: %w", index, err)
+	}
+	log.Data = data
+	return nil
+}
+
+func (store *encryptedLogStore) StoreLog(log *raft.Log) error {
+	return store.StoreLogs([]*raft.Log{log})
+}
+
+func (store *encryptedLogStore) StoreLogs(logs []*raft.Log) error {
+	// The entries are copied, as the caller may keep them in memory, such as in the log cache.
+	encrypted := make([]*raft.Log, len(logs))
+	for i, log := range logs {
+		entry := *log
+		if len(entry.Data) > 0 {
+			data, err := store.keyring.Seal(entry.Data)
+			if err != nil {
+				return err
+			}
+			entry.Data = data
+		}
+		encrypted[i] = &entry
+	}
+	return store.LogStore.StoreLogs(encrypted)
+}
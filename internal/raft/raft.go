@@ -20,11 +20,16 @@ import (
 	"fmt"
 	"github.com/echovault/echovault/internal"
 	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/encryption"
 	"github.com/echovault/echovault/internal/memberlist"
+	"github.com/echovault/echovault/internal/search"
+	"io"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/echovault/echovault/pkg/types"
@@ -34,18 +39,26 @@ import (
 
 type Opts struct {
 	Config                config.Config
+	Keyring               *encryption.Keyring // Encrypts the raft log and snapshots when set.
 	EchoVault             types.EchoVault
-	GetState              func() map[string]internal.KeyData
+	GetState              func() internal.StateCapture
 	GetCommand            func(command string) (types.Command, error)
 	DeleteKey             func(ctx context.Context, key string) error
+	ApplyWrite            func(cmd []string, apply func() ([]byte, error)) ([]byte, error)
 	StartSnapshot         func()
-	FinishSnapshot        func()
+	FinishSnapshot        func(size int64, err error)
 	SetLatestSnapshotTime func(msec int64)
+	GetIndexes            func() []search.Schema
+	RestoreIndexes        func(schemas []search.Schema)
+	Replicate             func(cmd []string, apply func(request internal.ApplyRequest) ([]byte, error)) ([]byte, error)
 }
 
 type Raft struct {
-	options Opts
-	raft    *raft.Raft
+	options       Opts
+	raft          *raft.Raft
+	logStore      raft.LogStore
+	snapshotStore raft.SnapshotStore
+	transport     *contactTransport
 }
 
 func NewRaft(opts Opts) *Raft {
@@ -76,7 +89,7 @@ func (r *Raft) RaftInit(ctx context.Context) {
 			log.Fatal(err)
 		}
 
-		logStore, err = raft.NewLogCache(512, boltdb)
+		logStore, err = raft.NewLogCache(512, newEncryptedLogStore(boltdb, r.options.Keyring))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -96,35 +109,46 @@ func (r *Raft) RaftInit(ctx context.Context) {
 		log.Fatal(err)
 	}
 
-	raftTransport, err := raft.NewTCPTransport(
-		addr,
-		advertiseAddr,
-		10,
-		500*time.Millisecond,
-		os.Stdout,
-	)
+	var raftTransport *raft.NetworkTransport
+	if conf.RaftTLS {
+		raftTransport, err = newTLSTransport(addr, advertiseAddr, conf)
+	} else {
+		raftTransport, err = raft.NewTCPTransport(
+			addr,
+			advertiseAddr,
+			10,
+			500*time.Millisecond,
+			os.Stdout,
+		)
+	}
 
 	if err != nil {
 		log.Fatal(err)
 	}
+	transport := newContactTransport(raftTransport)
 
 	// Start raft echovault
 	raftServer, err := raft.NewRaft(
 		raftConfig,
 		NewFSM(FSMOpts{
 			Config:                r.options.Config,
+			Keyring:               r.options.Keyring,
 			EchoVault:             r.options.EchoVault,
 			GetState:              r.options.GetState,
 			GetCommand:            r.options.GetCommand,
 			DeleteKey:             r.options.DeleteKey,
+			ApplyWrite:            r.options.ApplyWrite,
 			StartSnapshot:         r.options.StartSnapshot,
 			FinishSnapshot:        r.options.FinishSnapshot,
 			SetLatestSnapshotTime: r.options.SetLatestSnapshotTime,
+			GetIndexes:            r.options.GetIndexes,
+			RestoreIndexes:        r.options.RestoreIndexes,
+			Replicate:             r.options.Replicate,
 		}),
 		logStore,
 		stableStore,
 		snapshotStore,
-		raftTransport,
+		transport,
 	)
 
 	if err != nil {
@@ -145,6 +169,9 @@ func (r *Raft) RaftInit(ctx context.Context) {
 	}
 
 	r.raft = raftServer
+	r.logStore = logStore
+	r.snapshotStore = snapshotStore
+	r.transport = transport
 }
 
 func (r *Raft) Apply(cmd []byte, timeout time.Duration) raft.ApplyFuture {
@@ -174,6 +201,24 @@ func (r *Raft) AddVoter(
 	prevIndex uint64,
 	timeout time.Duration,
 ) error {
+	return r.addServer(id, address, func() raft.IndexFuture {
+		return r.raft.AddVoter(id, address, prevIndex, timeout)
+	})
+}
+
+// AddNonvoter adds a learner, which receives the log but does not vote or count towards the commit quorum.
+func (r *Raft) AddNonvoter(
+	id raft.ServerID,
+	address raft.ServerAddress,
+	prevIndex uint64,
+	timeout time.Duration,
+) error {
+	return r.addServer(id, address, func() raft.IndexFuture {
+		return r.raft.AddNonvoter(id, address, prevIndex, timeout)
+	})
+}
+
+func (r *Raft) addServer(id raft.ServerID, address raft.ServerAddress, add func() raft.IndexFuture) error {
 	if r.IsRaftLeader() {
 		raftConfig := r.raft.GetConfiguration()
 		if err := raftConfig.Error(); err != nil {
@@ -187,7 +232,7 @@ func (r *Raft) AddVoter(
 			}
 		}
 
-		err := r.raft.AddVoter(id, address, prevIndex, timeout).Error()
+		err := add().Error()
 		if err != nil {
 			return err
 		}
@@ -196,6 +241,177 @@ func (r *Raft) AddVoter(
 	return nil
 }
 
+// Members returns the servers in the raft configuration. The last contact is only known for the servers that
+// the node exchanges messages with: the leader knows the last contact with every member, and the other members
+// only know the last contact with the leader.
+func (r *Raft) Members() ([]types.ClusterMember, error) {
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, errors.New("could not retrieve raft config")
+	}
+	_, leaderID := r.raft.LeaderWithID()
+	localID := raft.ServerID(r.options.Config.ServerID)
+
+	servers := future.Configuration().Servers
+	members := make([]types.ClusterMember, len(servers))
+	for i, s := range servers {
+		var lastContact time.Time
+		switch {
+		case s.ID == localID:
+			lastContact = time.Now()
+		case r.IsRaftLeader():
+			lastContact, _ = r.transport.lastContact(s.ID)
+		case s.ID == leaderID:
+			lastContact = r.raft.LastContact()
+		}
+		members[i] = types.ClusterMember{
+			ID:       string(s.ID),
+			Address:  string(s.Address),
+			Suffrage: suffrage(s.Suffrage),
+			Leader:   s.ID == leaderID,
+		}
+		if !lastContact.IsZero() {
+			members[i].LastContact = lastContact.UnixMilli()
+		}
+	}
+	return members, nil
+}
+
+// PromoteMember makes the learner with the given ID a voter. Only the leader can change the membership.
+func (r *Raft) PromoteMember(id string) error {
+	member, err := r.member(id)
+	if err != nil {
+		return err
+	}
+	if member.Suffrage != raft.Nonvoter {
+		return fmt.Errorf("member %s is already a voter", id)
+	}
+	return r.raft.AddVoter(member.ID, member.Address, 0, 0).Error()
+}
+
+// DemoteMember makes the voter with the given ID a learner. The leader cannot be demoted, so leadership must
+// be transferred first. Only the leader can change the membership.
+func (r *Raft) DemoteMember(id string) error {
+	member, err := r.member(id)
+	if err != nil {
+		return err
+	}
+	if member.Suffrage == raft.Nonvoter {
+		return fmt.Errorf("member %s is already a learner", id)
+	}
+	if member.ID == raft.ServerID(r.options.Config.ServerID) {
+		return errors.New("cannot demote the leader")
+	}
+	return r.raft.DemoteVoter(member.ID, 0, 0).Error()
+}
+
+// Info returns the raft state of the node. The time of the last snapshot is left to the caller.
+func (r *Raft) Info() (types.ClusterInfo, error) {
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return types.ClusterInfo{}, errors.New("could not retrieve raft config")
+	}
+	stats := r.raft.Stats()
+	stat := func(name string) uint64 {
+		n, _ := strconv.ParseUint(stats[name], 10, 64)
+		return n
+	}
+	leaderAddress, leaderID := r.raft.LeaderWithID()
+
+	info := types.ClusterInfo{
+		State:             strings.ToLower(r.raft.State().String()),
+		Term:              stat("term"),
+		LeaderID:          string(leaderID),
+		LeaderAddress:     string(leaderAddress),
+		CommitIndex:       stat("commit_index"),
+		AppliedIndex:      r.raft.AppliedIndex(),
+		LastLogIndex:      r.raft.LastIndex(),
+		LastSnapshotIndex: stat("last_snapshot_index"),
+		LastSnapshotTerm:  stat("last_snapshot_term"),
+	}
+	for _, s := range future.Configuration().Servers {
+		if s.Suffrage == raft.Nonvoter {
+			info.Learners++
+		} else {
+			info.Voters++
+		}
+	}
+	return info, nil
+}
+
+// TransferLeadership makes the voter with the given ID the leader, or the most up to date voter if the ID is empty.
+// It must be called on the leader.
+func (r *Raft) TransferLeadership(id string) error {
+	if id == "" {
+		if !r.IsRaftLeader() {
+			return errors.New("not cluster leader, cannot carry out command")
+		}
+		return r.raft.LeadershipTransfer().Error()
+	}
+	member, err := r.member(id)
+	if err != nil {
+		return err
+	}
+	if member.ID == raft.ServerID(r.options.Config.ServerID) {
+		return fmt.Errorf("member %s is already the leader", id)
+	}
+	if member.Suffrage != raft.Voter {
+		return fmt.Errorf("member %s is not a voter", id)
+	}
+	return r.raft.LeadershipTransferToServer(member.ID, member.Address).Error()
+}
+
+// ForgetMember removes the server with the given ID from the raft configuration. It must be called on the leader.
+func (r *Raft) ForgetMember(id string) error {
+	member, err := r.member(id)
+	if err != nil {
+		return err
+	}
+	if member.ID == raft.ServerID(r.options.Config.ServerID) {
+		return errors.New("cannot forget the leader")
+	}
+	return r.raft.RemoveServer(member.ID, 0, 0).Error()
+}
+
+// Leave stops the raft node once it has left the cluster. The node must not be the leader.
+func (r *Raft) Leave() error {
+	if r.raft.State() == raft.Shutdown {
+		return errors.New("node has already left the cluster")
+	}
+	if r.IsRaftLeader() {
+		return errors.New("the leader cannot leave the cluster")
+	}
+	return r.raft.Shutdown().Error()
+}
+
+// member returns the server with the given ID in the raft configuration of the leader.
+func (r *Raft) member(id string) (raft.Server, error) {
+	if !r.IsRaftLeader() {
+		return raft.Server{}, errors.New("not cluster leader, cannot carry out command")
+	}
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return raft.Server{}, errors.New("could not retrieve raft config")
+	}
+	for _, s := range future.Configuration().Servers {
+		if s.ID == raft.ServerID(id) {
+			return s, nil
+		}
+	}
+	return raft.Server{}, fmt.Errorf("no cluster member with id %s", id)
+}
+
+func suffrage(s raft.ServerSuffrage) string {
+	switch s {
+	case raft.Voter:
+		return "voter"
+	case raft.Nonvoter:
+		return "learner"
+	default:
+		return "staging"
+	}
+}
+
 func (r *Raft) RemoveServer(meta memberlist.NodeMeta) error {
 	if !r.IsRaftLeader() {
 		return errors.New("not leader, could not remove echovault")
@@ -208,8 +424,56 @@ func (r *Raft) RemoveServer(meta memberlist.NodeMeta) error {
 	return nil
 }
 
+// TakeSnapshot takes a raft snapshot and returns once it is persisted.
+// It is not an error if there are no new log entries to snapshot.
 func (r *Raft) TakeSnapshot() error {
-	return r.raft.Snapshot().Error()
+	if err := r.raft.Snapshot().Error(); err != nil && !errors.Is(err, raft.ErrNothingNewToSnapshot) {
+		return err
+	}
+	return nil
+}
+
+// AppliedIndex returns the index of the last log entry applied by the node.
+func (r *Raft) AppliedIndex() uint64 {
+	return r.raft.AppliedIndex()
+}
+
+// GetLog reads the log entry at index. It returns raft.ErrLogNotFound if the entry was removed from the log
+// after a snapshot.
+func (r *Raft) GetLog(index uint64) (*raft.Log, error) {
+	entry := new(raft.Log)
+	if err := r.logStore.GetLog(index, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// OpenSnapshot takes a raft snapshot and opens the latest snapshot. It returns the index of the last log entry
+// included in the snapshot and the decrypted snapshot, which must be closed.
+func (r *Raft) OpenSnapshot() (uint64, io.ReadCloser, error) {
+	if err := r.TakeSnapshot(); err != nil {
+		return 0, nil, err
+	}
+	snapshots, err := r.snapshotStore.List()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(snapshots) == 0 {
+		return 0, nil, errors.New("no raft snapshot found")
+	}
+	meta, rc, err := r.snapshotStore.Open(snapshots[0].ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	reader, err := r.options.Keyring.NewReader(rc)
+	if err != nil {
+		_ = rc.Close()
+		return 0, nil, err
+	}
+	return meta.Index, struct {
+		io.Reader
+		io.Closer
+	}{reader, rc}, nil
 }
 
 func (r *Raft) RaftShutdown() {
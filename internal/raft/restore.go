@@ -0,0 +1,130 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RestoreDataDir reads the state that a cluster node left in its data directory without joining a cluster.
+// The latest raft snapshot is passed to restoreSnapshot, and the commands in the raft log after the snapshot
+// are passed to apply, oldest first. The keyring decrypts the snapshot and the log if they are encrypted.
+//
+// The node must be stopped, as the raft log can only be opened by one process. Commands that were appended
+// to the log of the node but never committed by the cluster are included.
+func RestoreDataDir(
+	dataDir string,
+	keyring *encryption.Keyring,
+	restoreSnapshot func(snapshot io.Reader) error,
+	apply func(request internal.ApplyRequest) error,
+) error {
+	snapshotStore, err := raft.NewFileSnapshotStore(dataDir, 1, io.Discard)
+	if err != nil {
+		return err
+	}
+	snapshots, err := snapshotStore.List()
+	if err != nil {
+		return err
+	}
+
+	var index uint64
+	if len(snapshots) > 0 {
+		meta, rc, err := snapshotStore.Open(snapshots[0].ID)
+		if err != nil {
+			return err
+		}
+		r, err := keyring.NewReader(rc)
+		if err == nil {
+			err = restoreSnapshot(r)
+		}
+		_ = rc.Close()
+		if err != nil {
+			return fmt.Errorf("restore raft snapshot %s: %w", meta.ID, err)
+		}
+		index = meta.Index
+		log.Printf("restored raft snapshot %s\n", meta.ID)
+	}
+
+	logPath := filepath.Join(dataDir, "logs.db")
+	if _, err = os.Stat(logPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) && len(snapshots) > 0 {
+			return nil
+		}
+		return fmt.Errorf("no raft data in %s: %w", dataDir, err)
+	}
+
+	boltdb, err := raftboltdb.New(raftboltdb.Options{
+		Path:        logPath,
+		BoltOptions: &bolt.Options{ReadOnly: true, Timeout: time.Second},
+	})
+	if err != nil {
+		return fmt.Errorf("open raft log: %w", err)
+	}
+	defer func() {
+		_ = boltdb.Close()
+	}()
+	store := newEncryptedLogStore(boltdb, keyring)
+
+	first, err := store.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		return err
+	}
+
+	// The log may still hold entries that are already included in the snapshot.
+	first = max(first, index+1)
+	if first > last {
+		return nil
+	}
+	if index != 0 && first != index+1 {
+		return fmt.Errorf("raft log starts at index %d, after snapshot index %d", first, index)
+	}
+
+	for i := first; i <= last; i++ {
+		entry := new(raft.Log)
+		if err = store.GetLog(i, entry); err != nil {
+			return fmt.Errorf("read raft log index %d: %w", i, err)
+		}
+		if entry.Type != raft.LogCommand {
+			continue
+		}
+		var request internal.ApplyRequest
+		if err = json.Unmarshal(entry.Data, &request); err != nil {
+			return fmt.Errorf("decode raft log index %d: %w", i, err)
+		}
+		if err = apply(request); err != nil {
+			log.Printf("raft log index %d: %v\n", i, err)
+		}
+	}
+
+	log.Printf("replayed raft log up to index %d\n", last)
+
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/config"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// contactTransport records when each server last answered an AppendEntries request. The leader sends one to
+// every voter and learner on each heartbeat, so on the leader the times are the last contact with each member.
+type contactTransport struct {
+	*raft.NetworkTransport
+	mutex    sync.Mutex
+	contacts map[raft.ServerID]time.Time
+}
+
+func newContactTransport(transport *raft.NetworkTransport) *contactTransport {
+	return &contactTransport{
+		NetworkTransport: transport,
+		contacts:         make(map[raft.ServerID]time.Time),
+	}
+}
+
+func (transport *contactTransport) AppendEntries(
+	id raft.ServerID,
+	target raft.ServerAddress,
+	args *raft.AppendEntriesRequest,
+	resp *raft.AppendEntriesResponse,
+) error {
+	if err := transport.NetworkTransport.AppendEntries(id, target, args, resp); err != nil {
+		return err
+	}
+	transport.mutex.Lock()
+	transport.contacts[id] = time.Now()
+	transport.mutex.Unlock()
+	return nil
+}
+
+// lastContact returns when the server last answered an AppendEntries request, and false if it never did.
+func (transport *contactTransport) lastContact(id raft.ServerID) (time.Time, bool) {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	t, ok := transport.contacts[id]
+	return t, ok
+}
+
+// newTLSTransport returns a raft transport that uses mutual TLS. Each node presents its certificates from
+// CertKeyPairs, and verifies the certificates of the other nodes, and their host names, with ClientCAs.
+func newTLSTransport(bindAddr string, advertise net.Addr, conf config.Config) (*raft.NetworkTransport, error) {
+	if len(conf.CertKeyPairs) == 0 || len(conf.ClientCAs) == 0 {
+		return nil, errors.New("raft TLS requires a certificate and key pair, and a client CA to verify the other nodes")
+	}
+	certificates, err := internal.LoadCertificates(conf.CertKeyPairs)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := internal.LoadCertPool(conf.ClientCAs)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: certificates,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	listener, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	stream := &tlsStreamLayer{
+		Listener:  tls.NewListener(listener, tlsConfig),
+		advertise: advertise,
+		config:    tlsConfig,
+	}
+	return raft.NewNetworkTransport(stream, 10, 500*time.Millisecond, os.Stdout), nil
+}
+
+// tlsStreamLayer accepts and dials the TLS connections of the raft transport.
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	config    *tls.Config
+}
+
+func (stream *tlsStreamLayer) Addr() net.Addr {
+	return stream.advertise
+}
+
+func (stream *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", string(address), stream.config)
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit implements the generic cell rate algorithm (GCRA).
+//
+// GCRA is equivalent to a token bucket, but the whole state of a limiter is a single
+// timestamp: the theoretical arrival time (TAT) of the next request if requests arrived
+// exactly at the permitted rate.
+package ratelimit
+
+import "time"
+
+type Limit struct {
+	MaxBurst int64         // Number of requests allowed in excess of the rate at any instant.
+	Count    int64         // Number of requests allowed per period.
+	Period   time.Duration // Length of the period.
+}
+
+type Result struct {
+	Limited    bool
+	Limit      int64         // Total number of requests allowed at once, i.e. the max burst + 1.
+	Remaining  int64         // Number of requests that would be allowed right now.
+	RetryAfter time.Duration // Time until the request would be allowed. -1 if the request was allowed or can never be allowed.
+	ResetAfter time.Duration // Time until the limiter is back to its initial state.
+	TAT        int64         // Theoretical arrival time to store, in unix nanoseconds.
+}
+
+// Throttle decides whether quantity requests are allowed at time now, given the stored theoretical
+// arrival time tat. Both times are in unix nanoseconds; a tat of 0 means there is no stored state.
+func Throttle(limit Limit, tat int64, now int64, quantity int64) Result {
+	emissionInterval := int64(limit.Period) / limit.Count
+	delayVariationTolerance := emissionInterval * (limit.MaxBurst + 1)
+	increment := emissionInterval * quantity
+
+	if tat == 0 {
+		tat = now
+	}
+
+	newTAT := max(tat, now) + increment
+	allowAt := newTAT - delayVariationTolerance
+	diff := now - allowAt
+
+	result := Result{Limit: limit.MaxBurst + 1, RetryAfter: -1}
+	var ttl int64
+	if diff < 0 {
+		result.Limited = true
+		if increment <= delayVariationTolerance {
+			result.RetryAfter = time.Duration(-diff)
+		}
+		ttl = tat - now
+		result.TAT = tat
+	} else {
+		ttl = newTAT - now
+		result.TAT = newTAT
+	}
+	ttl = max(ttl, 0)
+
+	if next := delayVariationTolerance - ttl; next > -emissionInterval {
+		result.Remaining = max(next/emissionInterval, 0)
+	}
+	result.ResetAfter = time.Duration(ttl)
+	return result
+}
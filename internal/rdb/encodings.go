@@ -0,0 +1,384 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errMalformed = errors.New("malformed RDB encoding")
+
+// blob is a bounds checked cursor over an encoded string.
+type blob struct {
+	b   []byte
+	pos int
+}
+
+func (b *blob) take(n int) ([]byte, error) {
+	if n < 0 || b.pos+n > len(b.b) {
+		return nil, errMalformed
+	}
+	p := b.b[b.pos : b.pos+n]
+	b.pos += n
+	return p, nil
+}
+
+func (b *blob) peek() (byte, error) {
+	if b.pos >= len(b.b) {
+		return 0, errMalformed
+	}
+	return b.b[b.pos], nil
+}
+
+// int24 sign extends a little endian 24-bit integer.
+func int24(p []byte) int64 {
+	return int64(int32(uint32(p[0])<<8|uint32(p[1])<<16|uint32(p[2])<<24) >> 8)
+}
+
+// parseZiplist returns the entries of a ziplist. Integer entries are returned in their decimal form.
+func parseZiplist(p []byte) ([]string, error) {
+	b := &blob{b: p, pos: ziplistHeaderLen}
+	var entries []string
+	for {
+		prevLen, err := b.peek()
+		if err != nil {
+			return nil, err
+		}
+		if prevLen == ziplistEnd {
+			return entries, nil
+		}
+		if prevLen < 254 {
+			_, err = b.take(1)
+		} else {
+			_, err = b.take(5)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		header, err := b.take(1)
+		if err != nil {
+			return nil, err
+		}
+		enc := header[0]
+
+		var entry string
+		switch {
+		case enc>>6 == 0:
+			s, err := b.take(int(enc & 0x3F))
+			if err != nil {
+				return nil, err
+			}
+			entry = string(s)
+		case enc>>6 == 1:
+			l, err := b.take(1)
+			if err != nil {
+				return nil, err
+			}
+			s, err := b.take(int(enc&0x3F)<<8 | int(l[0]))
+			if err != nil {
+				return nil, err
+			}
+			entry = string(s)
+		case enc>>6 == 2:
+			l, err := b.take(4)
+			if err != nil {
+				return nil, err
+			}
+			s, err := b.take(int(binary.BigEndian.Uint32(l)))
+			if err != nil {
+				return nil, err
+			}
+			entry = string(s)
+		case enc == 0xC0:
+			n, err := b.take(2)
+			if err != nil {
+				return nil, err
+			}
+			entry = strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(n))), 10)
+		case enc == 0xD0:
+			n, err := b.take(4)
+			if err != nil {
+				return nil, err
+			}
+			entry = strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(n))), 10)
+		case enc == 0xE0:
+			n, err := b.take(8)
+			if err != nil {
+				return nil, err
+			}
+			entry = strconv.FormatInt(int64(binary.LittleEndian.Uint64(n)), 10)
+		case enc == 0xF0:
+			n, err := b.take(3)
+			if err != nil {
+				return nil, err
+			}
+			entry = strconv.FormatInt(int24(n), 10)
+		case enc == 0xFE:
+			n, err := b.take(1)
+			if err != nil {
+				return nil, err
+			}
+			entry = strconv.FormatInt(int64(int8(n[0])), 10)
+		case enc >= 0xF1 && enc <= 0xFD:
+			// 4-bit immediate integer between 0 and 12.
+			entry = strconv.Itoa(int(enc&0x0F) - 1)
+		default:
+			return nil, fmt.Errorf("unknown ziplist entry encoding %#x", enc)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// listpackBacklenSize returns the number of bytes used to store the length of a listpack entry
+// after the entry.
+func listpackBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// parseListpack returns the entries of a listpack. Integer entries are returned in their decimal form.
+func parseListpack(p []byte) ([]string, error) {
+	b := &blob{b: p, pos: listpackHeadLen}
+	var entries []string
+	for {
+		enc, err := b.peek()
+		if err != nil {
+			return nil, err
+		}
+		if enc == listpackEnd {
+			return entries, nil
+		}
+		start := b.pos
+
+		var entry string
+		switch {
+		case enc&0x80 == 0:
+			_, _ = b.take(1)
+			entry = strconv.Itoa(int(enc & 0x7F))
+		case enc&0xC0 == 0x80:
+			_, _ = b.take(1)
+			s, err := b.take(int(enc & 0x3F))
+			if err != nil {
+				return nil, err
+			}
+			entry = string(s)
+		case enc&0xE0 == 0xC0:
+			n, err := b.take(2)
+			if err != nil {
+				return nil, err
+			}
+			v := int(n[0]&0x1F)<<8 | int(n[1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			entry = strconv.Itoa(v)
+		case enc&0xF0 == 0xE0:
+			n, err := b.take(2)
+			if err != nil {
+				return nil, err
+			}
+			s, err := b.take(int(n[0]&0x0F)<<8 | int(n[1]))
+			if err != nil {
+				return nil, err
+			}
+			entry = string(s)
+		case enc == 0xF0:
+			n, err := b.take(5)
+			if err != nil {
+				return nil, err
+			}
+			s, err := b.take(int(binary.LittleEndian.Uint32(n[1:])))
+			if err != nil {
+				return nil, err
+			}
+			entry = string(s)
+		case enc == 0xF1:
+			n, err := b.take(3)
+			if err != nil {
+				return nil, err
+			}
+			entry = strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(n[1:]))), 10)
+		case enc == 0xF2:
+			n, err := b.take(4)
+			if err != nil {
+				return nil, err
+			}
+			entry = strconv.FormatInt(int24(n[1:]), 10)
+		case enc == 0xF3:
+			n, err := b.take(5)
+			if err != nil {
+				return nil, err
+			}
+			entry = strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(n[1:]))), 10)
+		case enc == 0xF4:
+			n, err := b.take(9)
+			if err != nil {
+				return nil, err
+			}
+			entry = strconv.FormatInt(int64(binary.LittleEndian.Uint64(n[1:])), 10)
+		default:
+			return nil, fmt.Errorf("unknown listpack entry encoding %#x", enc)
+		}
+
+		if _, err = b.take(listpackBacklenSize(b.pos - start)); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// parseIntset returns the members of an intset in their decimal form.
+func parseIntset(p []byte) ([]string, error) {
+	b := &blob{b: p}
+	header, err := b.take(8)
+	if err != nil {
+		return nil, err
+	}
+	width := int(binary.LittleEndian.Uint32(header[:4]))
+	length := int(binary.LittleEndian.Uint32(header[4:]))
+	if width != 2 && width != 4 && width != 8 {
+		return nil, fmt.Errorf("unknown intset encoding %d", width)
+	}
+	if length > (len(p)-8)/width {
+		return nil, errMalformed
+	}
+	members := make([]string, length)
+	for i := range members {
+		n, _ := b.take(width)
+		switch width {
+		case 2:
+			members[i] = strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(n))), 10)
+		case 4:
+			members[i] = strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(n))), 10)
+		case 8:
+			members[i] = strconv.FormatInt(int64(binary.LittleEndian.Uint64(n)), 10)
+		}
+	}
+	return members, nil
+}
+
+// parseZipmap returns the fields and values of a zipmap encoded hash as alternating entries.
+func parseZipmap(p []byte) ([]string, error) {
+	b := &blob{b: p, pos: 1}
+	readLen := func() (int, bool, error) {
+		l, err := b.take(1)
+		if err != nil {
+			return 0, false, err
+		}
+		switch l[0] {
+		case zipmapEnd:
+			return 0, true, nil
+		case zipmapBigLength:
+			n, err := b.take(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return int(binary.LittleEndian.Uint32(n)), false, nil
+		default:
+			return int(l[0]), false, nil
+		}
+	}
+
+	var entries []string
+	for {
+		keyLen, end, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			return entries, nil
+		}
+		key, err := b.take(keyLen)
+		if err != nil {
+			return nil, err
+		}
+		valueLen, end, err := readLen()
+		if err != nil || end {
+			return nil, errMalformed
+		}
+		free, err := b.take(1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := b.take(valueLen)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = b.take(int(free[0])); err != nil {
+			return nil, err
+		}
+		entries = append(entries, string(key), string(value))
+	}
+}
+
+// lzfDecompress decompresses LZF compressed data into a buffer of the given length.
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, min(length, 1<<20))
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// Literal run of ctrl + 1 bytes.
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errMalformed
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		// Back reference.
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errMalformed
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errMalformed
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errMalformed
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+		if len(out) > length {
+			return nil, errMalformed
+		}
+	}
+	if len(out) != length {
+		return nil, errMalformed
+	}
+	return out, nil
+}
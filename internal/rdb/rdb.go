@@ -0,0 +1,89 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rdb reads and writes Redis RDB files so that datasets can be moved between Redis and EchoVault.
+// Strings, lists, sets, sorted sets, hashes and expiry times are supported. Streams and module types are not.
+package rdb
+
+import (
+	"hash/crc64"
+)
+
+const (
+	magic = "REDIS"
+	// writeVersion is the RDB version written by Save. It is understood by Redis 5.0 and later.
+	writeVersion = 9
+	// maxReadVersion is the latest RDB version that Load understands.
+	maxReadVersion = 12
+)
+
+const (
+	opSlotInfo      = 0xF4
+	opFunction2     = 0xF5
+	opFunctionPreGA = 0xF6
+	opModuleAux     = 0xF7
+	opIdle          = 0xF8
+	opFreq          = 0xF9
+	opAux           = 0xFA
+	opResizeDB      = 0xFB
+	opExpireTimeMS  = 0xFC
+	opExpireTime    = 0xFD
+	opSelectDB      = 0xFE
+	opEOF           = 0xFF
+)
+
+const (
+	encodingInt8     = 0
+	encodingInt16    = 1
+	encodingInt32    = 2
+	encodingLZF      = 3
+	quicklistPlain   = 1
+	quicklistPacked  = 2
+	zsetScoreNaN     = 253
+	zsetScorePosInf  = 254
+	zsetScoreNegInf  = 255
+	zipmapBigLength  = 254
+	zipmapEnd        = 255
+	ziplistEnd       = 0xFF
+	listpackEnd      = 0xFF
+	ziplistHeaderLen = 10
+	listpackHeadLen  = 6
+)
+
+const (
+	typeString         = 0
+	typeList           = 1
+	typeSet            = 2
+	typeZSet           = 3
+	typeHash           = 4
+	typeZSet2          = 5
+	typeHashZipmap     = 9
+	typeListZiplist    = 10
+	typeSetIntset      = 11
+	typeZSetZiplist    = 12
+	typeHashZiplist    = 13
+	typeListQuicklist  = 14
+	typeHashListpack   = 16
+	typeZSetListpack   = 17
+	typeListQuicklist2 = 18
+	typeSetListpack    = 20
+)
+
+// Redis checksums RDB files with the Jones CRC-64 polynomial, without the initial and final inversion
+// that the hash/crc64 package applies.
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+func crcUpdate(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
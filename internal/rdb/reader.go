@@ -0,0 +1,502 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/set"
+	"github.com/echovault/echovault/internal/sorted_set"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+type reader struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func (rd *reader) Read(p []byte) (int, error) {
+	n, err := rd.r.Read(p)
+	rd.crc = crcUpdate(rd.crc, p[:n])
+	return n, err
+}
+
+func (rd *reader) readByte() (byte, error) {
+	b, err := rd.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	rd.crc = crcUpdate(rd.crc, []byte{b})
+	return b, nil
+}
+
+// readBytes reads n bytes. Large reads grow the buffer as the data arrives
+// so that a corrupted length cannot cause a huge allocation.
+func (rd *reader) readBytes(n uint64) ([]byte, error) {
+	if n <= 1<<16 {
+		p := make([]byte, n)
+		_, err := io.ReadFull(rd, p)
+		return p, err
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, rd, int64(n)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readLength reads a length. When the encoded flag is true, the length is the special encoding of a string.
+func (rd *reader) readLength() (uint64, bool, error) {
+	b, err := rd.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := rd.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			p, err := rd.readBytes(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(p)), false, nil
+		case 0x81:
+			p, err := rd.readBytes(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(p), false, nil
+		default:
+			return 0, false, fmt.Errorf("unknown length encoding %#x", b)
+		}
+	default:
+		return uint64(b & 0x3F), true, nil
+	}
+}
+
+func (rd *reader) readPlainLength() (uint64, error) {
+	n, encoded, err := rd.readLength()
+	if err == nil && encoded {
+		err = errMalformed
+	}
+	return n, err
+}
+
+func (rd *reader) readString() ([]byte, error) {
+	n, encoded, err := rd.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return rd.readBytes(n)
+	}
+	switch n {
+	case encodingInt8:
+		b, err := rd.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(b)))), nil
+	case encodingInt16:
+		p, err := rd.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(p))))), nil
+	case encodingInt32:
+		p, err := rd.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(p))))), nil
+	case encodingLZF:
+		compressedLen, err := rd.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		length, err := rd.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := rd.readBytes(compressedLen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(length))
+	default:
+		return nil, fmt.Errorf("unknown string encoding %d", n)
+	}
+}
+
+// readStrings reads a length followed by that many strings.
+func (rd *reader) readStrings(multiple uint64) ([]string, error) {
+	n, err := rd.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	n *= multiple
+	entries := make([]string, 0, min(n, 1<<16))
+	for i := uint64(0); i < n; i++ {
+		s, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, string(s))
+	}
+	return entries, nil
+}
+
+// readScore reads a sorted set score stored as a length prefixed string.
+func (rd *reader) readScore() (float64, error) {
+	n, err := rd.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case zsetScoreNaN:
+		return math.NaN(), nil
+	case zsetScorePosInf:
+		return math.Inf(1), nil
+	case zsetScoreNegInf:
+		return math.Inf(-1), nil
+	}
+	p, err := rd.readBytes(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(p), 64)
+}
+
+func (rd *reader) readEncoded(parse func([]byte) ([]string, error)) ([]string, error) {
+	p, err := rd.readString()
+	if err != nil {
+		return nil, err
+	}
+	return parse(p)
+}
+
+func newList(entries []string) []interface{} {
+	list := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		list[i] = internal.AdaptType(entry)
+	}
+	return list
+}
+
+func newHash(entries []string) (map[string]interface{}, error) {
+	if len(entries)%2 != 0 {
+		return nil, errMalformed
+	}
+	hash := make(map[string]interface{}, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		hash[entries[i]] = internal.AdaptType(entries[i+1])
+	}
+	return hash, nil
+}
+
+func newSortedSet(entries []string) (*sorted_set.SortedSet, error) {
+	if len(entries)%2 != 0 {
+		return nil, errMalformed
+	}
+	members := make([]sorted_set.MemberParam, 0, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		score, err := strconv.ParseFloat(entries[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, sorted_set.MemberParam{
+			Value: sorted_set.Value(entries[i]),
+			Score: sorted_set.Score(score),
+		})
+	}
+	return sorted_set.NewSortedSet(members), nil
+}
+
+func (rd *reader) readValue(valueType byte) (interface{}, error) {
+	switch valueType {
+	default:
+		return nil, fmt.Errorf("unsupported RDB value type %d", valueType)
+
+	case typeString:
+		p, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		return internal.AdaptType(string(p)), nil
+
+	case typeList:
+		entries, err := rd.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return newList(entries), nil
+
+	case typeListZiplist:
+		entries, err := rd.readEncoded(parseZiplist)
+		if err != nil {
+			return nil, err
+		}
+		return newList(entries), nil
+
+	case typeListQuicklist, typeListQuicklist2:
+		nodes, err := rd.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		var entries []string
+		for i := uint64(0); i < nodes; i++ {
+			container := uint64(quicklistPacked)
+			if valueType == typeListQuicklist2 {
+				if container, err = rd.readPlainLength(); err != nil {
+					return nil, err
+				}
+			}
+			p, err := rd.readString()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case container == quicklistPlain:
+				entries = append(entries, string(p))
+				continue
+			case valueType == typeListQuicklist2:
+				p, err := parseListpack(p)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, p...)
+			default:
+				p, err := parseZiplist(p)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, p...)
+			}
+		}
+		return newList(entries), nil
+
+	case typeSet:
+		members, err := rd.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return set.NewSet(members), nil
+
+	case typeSetIntset:
+		members, err := rd.readEncoded(parseIntset)
+		if err != nil {
+			return nil, err
+		}
+		return set.NewSet(members), nil
+
+	case typeSetListpack:
+		members, err := rd.readEncoded(parseListpack)
+		if err != nil {
+			return nil, err
+		}
+		return set.NewSet(members), nil
+
+	case typeZSet, typeZSet2:
+		n, err := rd.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		members := make([]sorted_set.MemberParam, 0, min(n, 1<<16))
+		for i := uint64(0); i < n; i++ {
+			member, err := rd.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if valueType == typeZSet {
+				score, err = rd.readScore()
+			} else {
+				var p []byte
+				if p, err = rd.readBytes(8); err == nil {
+					score = math.Float64frombits(binary.LittleEndian.Uint64(p))
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, sorted_set.MemberParam{
+				Value: sorted_set.Value(member),
+				Score: sorted_set.Score(score),
+			})
+		}
+		return sorted_set.NewSortedSet(members), nil
+
+	case typeZSetZiplist:
+		entries, err := rd.readEncoded(parseZiplist)
+		if err != nil {
+			return nil, err
+		}
+		return newSortedSet(entries)
+
+	case typeZSetListpack:
+		entries, err := rd.readEncoded(parseListpack)
+		if err != nil {
+			return nil, err
+		}
+		return newSortedSet(entries)
+
+	case typeHash:
+		entries, err := rd.readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		return newHash(entries)
+
+	case typeHashZipmap:
+		entries, err := rd.readEncoded(parseZipmap)
+		if err != nil {
+			return nil, err
+		}
+		return newHash(entries)
+
+	case typeHashZiplist:
+		entries, err := rd.readEncoded(parseZiplist)
+		if err != nil {
+			return nil, err
+		}
+		return newHash(entries)
+
+	case typeHashListpack:
+		entries, err := rd.readEncoded(parseListpack)
+		if err != nil {
+			return nil, err
+		}
+		return newHash(entries)
+	}
+}
+
+// Load reads an RDB file from r and passes each key to setKeyData as it is read.
+// Keys from every database are loaded into the single EchoVault keyspace.
+// Keys that have expired before now are skipped.
+func Load(r io.Reader, now time.Time, setKeyData func(key string, data internal.KeyData)) error {
+	rd := &reader{r: bufio.NewReader(r)}
+
+	header, err := rd.readBytes(uint64(len(magic) + 4))
+	if err != nil {
+		return fmt.Errorf("could not read RDB header: %v", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return errors.New("not an RDB file")
+	}
+	version, err := strconv.Atoi(string(header[len(magic):]))
+	if err != nil || version < 1 || version > maxReadVersion {
+		return fmt.Errorf("unsupported RDB version %s", header[len(magic):])
+	}
+
+	var expireAt time.Time
+	for {
+		op, err := rd.readByte()
+		if err != nil {
+			return fmt.Errorf("unexpected end of RDB file: %v", err)
+		}
+
+		switch op {
+		case opEOF:
+			if version < 5 {
+				return nil
+			}
+			crc := rd.crc
+			p := make([]byte, 8)
+			if _, err = io.ReadFull(rd.r, p); err != nil {
+				return fmt.Errorf("could not read RDB checksum: %v", err)
+			}
+			// A checksum of 0 means that checksums were disabled when the file was written.
+			if checksum := binary.LittleEndian.Uint64(p); checksum != 0 && checksum != crc {
+				return errors.New("RDB checksum mismatch")
+			}
+			return nil
+
+		case opAux:
+			if _, err = rd.readString(); err == nil {
+				_, err = rd.readString()
+			}
+
+		case opSelectDB:
+			_, err = rd.readPlainLength()
+
+		case opResizeDB:
+			if _, err = rd.readPlainLength(); err == nil {
+				_, err = rd.readPlainLength()
+			}
+
+		case opSlotInfo:
+			for i := 0; i < 3 && err == nil; i++ {
+				_, err = rd.readPlainLength()
+			}
+
+		case opFunction2:
+			_, err = rd.readString()
+
+		case opFreq:
+			_, err = rd.readByte()
+
+		case opIdle:
+			_, err = rd.readPlainLength()
+
+		case opExpireTimeMS:
+			var p []byte
+			if p, err = rd.readBytes(8); err == nil {
+				expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(p)))
+			}
+
+		case opExpireTime:
+			var p []byte
+			if p, err = rd.readBytes(4); err == nil {
+				expireAt = time.Unix(int64(binary.LittleEndian.Uint32(p)), 0)
+			}
+
+		case opModuleAux, opFunctionPreGA:
+			return fmt.Errorf("unsupported RDB opcode %#x", op)
+
+		default:
+			key, err := rd.readString()
+			if err != nil {
+				return err
+			}
+			value, err := rd.readValue(op)
+			if err != nil {
+				return fmt.Errorf("key %s: %v", key, err)
+			}
+			if expireAt == (time.Time{}) || expireAt.After(now) {
+				setKeyData(string(key), internal.KeyData{Value: value, ExpireAt: expireAt})
+			}
+			expireAt = time.Time{}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
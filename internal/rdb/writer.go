@@ -0,0 +1,206 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/set"
+	"github.com/echovault/echovault/internal/sorted_set"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type writer struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+}
+
+func (wr *writer) write(p []byte) {
+	if wr.err != nil {
+		return
+	}
+	wr.crc = crcUpdate(wr.crc, p)
+	_, wr.err = wr.w.Write(p)
+}
+
+func (wr *writer) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		wr.write([]byte{byte(n)})
+	case n < 1<<14:
+		wr.write([]byte{byte(n>>8) | 0x40, byte(n)})
+	case n <= math.MaxUint32:
+		wr.write(binary.BigEndian.AppendUint32([]byte{0x80}, uint32(n)))
+	default:
+		wr.write(binary.BigEndian.AppendUint64([]byte{0x81}, n))
+	}
+}
+
+func (wr *writer) writeString(s string) {
+	wr.writeLength(uint64(len(s)))
+	wr.write([]byte(s))
+}
+
+func formatScalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// valueType returns the RDB type used to write the value, or false if the value has no RDB representation.
+func valueType(value interface{}) (byte, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			if _, ok := formatScalar(elem); !ok {
+				return 0, false
+			}
+		}
+		return typeList, true
+	case map[string]interface{}:
+		for _, elem := range v {
+			if _, ok := formatScalar(elem); !ok {
+				return 0, false
+			}
+		}
+		return typeHash, true
+	case *set.Set:
+		return typeSet, true
+	case *sorted_set.SortedSet:
+		return typeZSet2, true
+	}
+	if _, ok := formatScalar(value); ok {
+		return typeString, true
+	}
+	return 0, false
+}
+
+func (wr *writer) writeValue(value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		wr.writeLength(uint64(len(v)))
+		for _, elem := range v {
+			s, _ := formatScalar(elem)
+			wr.writeString(s)
+		}
+	case map[string]interface{}:
+		fields := make([]string, 0, len(v))
+		for field := range v {
+			fields = append(fields, field)
+		}
+		slices.Sort(fields)
+		wr.writeLength(uint64(len(fields)))
+		for _, field := range fields {
+			s, _ := formatScalar(v[field])
+			wr.writeString(field)
+			wr.writeString(s)
+		}
+	case *set.Set:
+		members := v.GetAll()
+		slices.Sort(members)
+		wr.writeLength(uint64(len(members)))
+		for _, member := range members {
+			wr.writeString(member)
+		}
+	case *sorted_set.SortedSet:
+		members := v.GetAll()
+		slices.SortFunc(members, func(a, b sorted_set.MemberParam) int {
+			return strings.Compare(string(a.Value), string(b.Value))
+		})
+		wr.writeLength(uint64(len(members)))
+		for _, member := range members {
+			wr.writeString(string(member.Value))
+			wr.write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(float64(member.Score))))
+		}
+	default:
+		s, _ := formatScalar(value)
+		wr.writeString(s)
+	}
+}
+
+// Save writes the state to w as an RDB file that Redis can load.
+// Keys that have expired before now are left out. Keys holding values that have no RDB representation,
+// such as probabilistic data structures and time series, are left out and returned.
+func Save(w io.Writer, now time.Time, state map[string]internal.KeyData) ([]string, error) {
+	wr := &writer{w: bufio.NewWriter(w)}
+
+	keys := make([]string, 0, len(state))
+	var skipped []string
+	var expires uint64
+	for key, data := range state {
+		if data.ExpireAt != (time.Time{}) && !data.ExpireAt.After(now) {
+			continue
+		}
+		if _, ok := valueType(data.Value); !ok {
+			skipped = append(skipped, key)
+			continue
+		}
+		if data.ExpireAt != (time.Time{}) {
+			expires += 1
+		}
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	slices.Sort(skipped)
+
+	wr.write([]byte(fmt.Sprintf("%s%04d", magic, writeVersion)))
+	wr.write([]byte{opAux})
+	wr.writeString("redis-bits")
+	wr.writeString("64")
+	wr.write([]byte{opAux})
+	wr.writeString("ctime")
+	wr.writeString(strconv.FormatInt(now.Unix(), 10))
+	wr.write([]byte{opSelectDB})
+	wr.writeLength(0)
+	wr.write([]byte{opResizeDB})
+	wr.writeLength(uint64(len(keys)))
+	wr.writeLength(expires)
+
+	for _, key := range keys {
+		data := state[key]
+		if data.ExpireAt != (time.Time{}) {
+			wr.write(binary.LittleEndian.AppendUint64([]byte{opExpireTimeMS}, uint64(data.ExpireAt.UnixMilli())))
+		}
+		t, _ := valueType(data.Value)
+		wr.write([]byte{t})
+		wr.writeString(key)
+		wr.writeValue(data.Value)
+	}
+
+	wr.write([]byte{opEOF})
+	if wr.err != nil {
+		return nil, wr.err
+	}
+	if _, err := wr.w.Write(binary.LittleEndian.AppendUint64(nil, wr.crc)); err != nil {
+		return nil, err
+	}
+	return skipped, wr.w.Flush()
+}
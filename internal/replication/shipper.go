@@ -0,0 +1,459 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/clock"
+	"github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/internal/snapshot"
+	"github.com/echovault/echovault/pkg/types"
+	"github.com/hashicorp/raft"
+	"github.com/tidwall/resp"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This package ships the raft log of a primary cluster to the leader of a replica cluster. The leader of the
+// primary connects to one of the replication targets, authenticates with AUTH when it has credentials, and sends
+// REPLICATION commands over the client protocol:
+//
+//	REPLICATION HANDSHAKE                               returns the checkpoint, the index of the last entry
+//	                                                    of the primary that the replica applied
+//	REPLICATION APPLY <from> <through> <primary-index> [entry ...]
+//	                                                    applies the command entries between from and through,
+//	                                                    and moves the checkpoint to through
+//	REPLICATION PING <primary-index>                    keeps the connection alive while there are no entries
+//	REPLICATION SYNC-START <index> <indexes>            starts a full sync from a raft snapshot at index by
+//	                                                    deleting every key of the replica
+//	REPLICATION SYNC-LOAD [key expire-at payload ...]   restores keys of the snapshot from their DUMP payload,
+//	                                                    base64 encoded, and their expiry time in unix milliseconds
+//	REPLICATION SYNC-END <index>                        moves the checkpoint to the index of the snapshot
+//
+// The entries are the JSON encoded apply requests of the raft log. Shipping resumes from the checkpoint of the
+// replica. A full sync is taken when the replica has no checkpoint, or when the entries after the checkpoint
+// were removed from the log after a snapshot.
+//
+// The commands overwrite the keys of the replica, so they are in the replication ACL category, which the replica
+// must only grant to the user that the shipper authenticates as.
+
+const (
+	batchEntries = 256         // The maximum number of entries sent in one command.
+	batchBytes   = 1024 * 1024 // The size of the entries after which a batch is sent.
+	pollInterval = 100 * time.Millisecond
+	pingInterval = time.Second
+	timeout      = 10 * time.Second
+)
+
+type Shipper struct {
+	clock            clock.Clock
+	targets          []string
+	username         string // The user the shipper authenticates as on the replica. Empty for the default user.
+	password         string // The password of the user. Empty if the shipper does not authenticate.
+	isLeaderFunc     func() bool
+	appliedIndexFunc func() uint64
+	getLogFunc       func(index uint64) (*raft.Log, error)
+	openSnapshotFunc func() (uint64, io.ReadCloser, error)
+
+	mutex       sync.Mutex
+	target      string // The address of the replica the entries are shipped to. Empty if there is no connection.
+	checkpoint  uint64
+	lastContact time.Time
+	fullSyncs   int64
+	syncing     bool
+	lastError   string
+}
+
+func WithClock(clock clock.Clock) func(shipper *Shipper) {
+	return func(shipper *Shipper) {
+		shipper.clock = clock
+	}
+}
+
+// WithTargets sets the addresses of the nodes of the replica cluster. The entries are shipped to the leader.
+func WithTargets(targets []string) func(shipper *Shipper) {
+	return func(shipper *Shipper) {
+		shipper.targets = targets
+	}
+}
+
+// WithCredentials sets the user and password that the shipper authenticates with on the replica. An empty username
+// authenticates the default user.
+func WithCredentials(username, password string) func(shipper *Shipper) {
+	return func(shipper *Shipper) {
+		shipper.username = username
+		shipper.password = password
+	}
+}
+
+// WithIsLeaderFunc sets the function that reports whether the node ships the log.
+func WithIsLeaderFunc(f func() bool) func(shipper *Shipper) {
+	return func(shipper *Shipper) {
+		shipper.isLeaderFunc = f
+	}
+}
+
+func WithAppliedIndexFunc(f func() uint64) func(shipper *Shipper) {
+	return func(shipper *Shipper) {
+		shipper.appliedIndexFunc = f
+	}
+}
+
+// WithGetLogFunc sets the function that reads a raft log entry. It returns raft.ErrLogNotFound for an entry
+// that was removed from the log.
+func WithGetLogFunc(f func(index uint64) (*raft.Log, error)) func(shipper *Shipper) {
+	return func(shipper *Shipper) {
+		shipper.getLogFunc = f
+	}
+}
+
+// WithOpenSnapshotFunc sets the function that opens a raft snapshot for a full sync. It returns the index of
+// the last entry included in the snapshot.
+func WithOpenSnapshotFunc(f func() (uint64, io.ReadCloser, error)) func(shipper *Shipper) {
+	return func(shipper *Shipper) {
+		shipper.openSnapshotFunc = f
+	}
+}
+
+func NewShipper(options ...func(shipper *Shipper)) *Shipper {
+	shipper := &Shipper{
+		clock:            clock.NewClock(),
+		targets:          make([]string, 0),
+		isLeaderFunc:     func() bool { return false },
+		appliedIndexFunc: func() uint64 { return 0 },
+		getLogFunc: func(index uint64) (*raft.Log, error) {
+			return nil, raft.ErrLogNotFound
+		},
+		openSnapshotFunc: func() (uint64, io.ReadCloser, error) {
+			return 0, nil, errors.New("no raft snapshot found")
+		},
+	}
+
+	for _, option := range options {
+		option(shipper)
+	}
+
+	return shipper
+}
+
+// Start ships the log in the background until ctx is done. The node only ships while it is the leader.
+func (shipper *Shipper) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-shipper.clock.After(time.Second):
+			}
+			if !shipper.isLeaderFunc() {
+				continue
+			}
+			for _, target := range shipper.targets {
+				err := shipper.ship(ctx, target)
+				shipper.mutex.Lock()
+				shipper.target = ""
+				shipper.syncing = false
+				if err != nil {
+					shipper.lastError = err.Error()
+				}
+				shipper.mutex.Unlock()
+				if err != nil {
+					log.Printf("replication to %s: %v\n", target, err)
+				}
+				if ctx.Err() != nil || !shipper.isLeaderFunc() {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// Status returns the status of the replication to the replica cluster.
+func (shipper *Shipper) Status() types.ReplicationStatus {
+	shipper.mutex.Lock()
+	defer shipper.mutex.Unlock()
+
+	status := types.ReplicationStatus{
+		Role:         "primary",
+		Connected:    shipper.target != "",
+		Target:       shipper.target,
+		Checkpoint:   shipper.checkpoint,
+		PrimaryIndex: shipper.appliedIndexFunc(),
+		FullSyncs:    shipper.fullSyncs,
+		Syncing:      shipper.syncing,
+		LastError:    shipper.lastError,
+	}
+	if !shipper.lastContact.IsZero() {
+		status.LastContact = shipper.lastContact.UnixMilli()
+	}
+	if status.PrimaryIndex > status.Checkpoint {
+		status.LagEntries = status.PrimaryIndex - status.Checkpoint
+		// The replica is behind since the first entry it has not applied was appended.
+		if entry, err := shipper.getLogFunc(status.Checkpoint + 1); err == nil && !entry.AppendedAt.IsZero() {
+			status.Lag = max(shipper.clock.Now().Sub(entry.AppendedAt).Milliseconds(), 0)
+		}
+	}
+	return status
+}
+
+// ship sends the log to target until an error occurs, or until the node is no longer the leader.
+func (shipper *Shipper) ship(ctx context.Context, target string) error {
+	conn, err := net.DialTimeout("tcp", target, timeout)
+	if err != nil {
+		return fmt.Errorf("could not connect to replica: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	reader := resp.NewReader(conn)
+	send := func(command []string) (resp.Value, error) {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return resp.Value{}, err
+		}
+		if _, err := conn.Write(internal.EncodeCommand(command)); err != nil {
+			return resp.Value{}, err
+		}
+		reply, _, err := reader.ReadValue()
+		if err != nil {
+			return resp.Value{}, err
+		}
+		if reply.Type() == resp.Error {
+			return resp.Value{}, fmt.Errorf("%s: %s", strings.ToLower(command[1]), reply.String())
+		}
+		shipper.mutex.Lock()
+		shipper.lastContact = shipper.clock.Now()
+		shipper.lastError = ""
+		shipper.mutex.Unlock()
+		return reply, nil
+	}
+
+	if shipper.password != "" {
+		if err = shipper.auth(conn, reader); err != nil {
+			return err
+		}
+	}
+
+	reply, err := send([]string{"REPLICATION", "HANDSHAKE"})
+	if err != nil {
+		return err
+	}
+	checkpoint := uint64(reply.Integer())
+	shipper.setCheckpoint(target, checkpoint)
+
+	ping := shipper.clock.After(pingInterval)
+	for ctx.Err() == nil && shipper.isLeaderFunc() {
+		applied := shipper.appliedIndexFunc()
+
+		// The replica is ahead of the primary if the primary was restored from older data.
+		if checkpoint == 0 || checkpoint > applied {
+			if checkpoint, err = shipper.fullSync(send); err != nil {
+				return err
+			}
+			shipper.setCheckpoint(target, checkpoint)
+			continue
+		}
+
+		if checkpoint == applied {
+			select {
+			case <-ping:
+				if _, err = send([]string{"REPLICATION", "PING", strconv.FormatUint(applied, 10)}); err != nil {
+					return err
+				}
+				ping = shipper.clock.After(pingInterval)
+			case <-shipper.clock.After(pollInterval):
+			}
+			continue
+		}
+
+		command, through, err := shipper.batch(checkpoint, applied)
+		if errors.Is(err, raft.ErrLogNotFound) {
+			// The entries after the checkpoint were compacted into a snapshot.
+			checkpoint = 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = send(command); err != nil {
+			return err
+		}
+		checkpoint = through
+		shipper.setCheckpoint(target, checkpoint)
+		ping = shipper.clock.After(pingInterval)
+	}
+
+	return nil
+}
+
+// batch returns the APPLY command for the entries after checkpoint, up to applied, and the index of the last
+// entry it covers.
+func (shipper *Shipper) batch(checkpoint uint64, applied uint64) ([]string, uint64, error) {
+	from := checkpoint + 1
+	var entries []string
+	size := 0
+	through := checkpoint
+	for through < applied && len(entries) < batchEntries && size < batchBytes {
+		entry, err := shipper.getLogFunc(through + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		through++
+		if entry.Type != raft.LogCommand || !isShipped(entry.Data) {
+			continue
+		}
+		entries = append(entries, string(entry.Data))
+		size += len(entry.Data)
+	}
+	command := []string{
+		"REPLICATION", "APPLY",
+		strconv.FormatUint(from, 10), strconv.FormatUint(through, 10), strconv.FormatUint(applied, 10),
+	}
+	return append(command, entries...), through, nil
+}
+
+// auth authenticates the connection with the credentials of the shipper. The error does not include the password.
+func (shipper *Shipper) auth(conn net.Conn, reader *resp.Reader) error {
+	command := []string{"AUTH", shipper.password}
+	if shipper.username != "" {
+		command = []string{"AUTH", shipper.username, shipper.password}
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(internal.EncodeCommand(command)); err != nil {
+		return err
+	}
+	reply, _, err := reader.ReadValue()
+	if err != nil {
+		return err
+	}
+	if reply.Type() == resp.Error {
+		return fmt.Errorf("auth: %s", reply.String())
+	}
+	return nil
+}
+
+// isShipped reports whether the entry changes the keys. The entries that the cluster received as a replica
+// before it was promoted are not shipped.
+func isShipped(data []byte) bool {
+	var request internal.ApplyRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return false
+	}
+	return request.Type == "command" || request.Type == "delete-key"
+}
+
+// fullSync replaces the state of the replica with a raft snapshot, and returns the index of the snapshot.
+func (shipper *Shipper) fullSync(send func(command []string) (resp.Value, error)) (uint64, error) {
+	shipper.mutex.Lock()
+	shipper.syncing = true
+	shipper.mutex.Unlock()
+	defer func() {
+		shipper.mutex.Lock()
+		shipper.syncing = false
+		shipper.mutex.Unlock()
+	}()
+
+	index, rc, err := shipper.openSnapshotFunc()
+	if err != nil {
+		return 0, fmt.Errorf("could not open raft snapshot: %w", err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	// The callbacks of Load cannot return an error, so the keys after the first error are skipped.
+	var sendErr error
+	load := []string{"REPLICATION", "SYNC-LOAD"}
+	size := 0
+	flush := func() {
+		if sendErr == nil && len(load) > 2 {
+			_, sendErr = send(load)
+		}
+		load = load[:2]
+		size = 0
+	}
+
+	_, err = snapshot.Load(rc,
+		func(schemas []search.Schema) {
+			indexes, err := json.Marshal(schemas)
+			if err != nil {
+				sendErr = err
+				return
+			}
+			_, sendErr = send([]string{
+				"REPLICATION", "SYNC-START", strconv.FormatUint(index, 10), string(indexes),
+			})
+		},
+		func(key string, data internal.KeyData) {
+			if sendErr != nil {
+				return
+			}
+			payload, err := snapshot.EncodeDump(data.Value, 0)
+			if err != nil {
+				log.Printf("could not dump key %s for replication: %v\n", key, err)
+				return
+			}
+			// The absolute expiry time is sent, so that the key expires at the same time on the replica.
+			// The payload is base64 encoded, as the replica stores the command in its raft log as JSON.
+			var expireAt int64
+			if data.ExpireAt != (time.Time{}) {
+				expireAt = data.ExpireAt.UnixMilli()
+			}
+			encoded := base64.StdEncoding.EncodeToString(payload)
+			load = append(load, key, strconv.FormatInt(expireAt, 10), encoded)
+			size += len(key) + len(encoded)
+			if (len(load)-2)/3 >= batchEntries || size >= batchBytes {
+				flush()
+			}
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("could not read raft snapshot: %w", err)
+	}
+	flush()
+	if sendErr != nil {
+		return 0, sendErr
+	}
+
+	reply, err := send([]string{"REPLICATION", "SYNC-END", strconv.FormatUint(index, 10)})
+	if err != nil {
+		return 0, err
+	}
+
+	shipper.mutex.Lock()
+	shipper.fullSyncs++
+	shipper.mutex.Unlock()
+
+	return uint64(reply.Integer()), nil
+}
+
+func (shipper *Shipper) setCheckpoint(target string, checkpoint uint64) {
+	shipper.mutex.Lock()
+	defer shipper.mutex.Unlock()
+	shipper.target = target
+	shipper.checkpoint = checkpoint
+}
@@ -0,0 +1,255 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+)
+
+type hnswNode struct {
+	key        string
+	vector     []float32
+	neighbours [][]string // layer -> neighbour keys
+}
+
+// hnsw is a hierarchical navigable small world graph.
+// Vectors are also kept in a flat index, which is used to answer filtered queries
+// that the graph cannot satisfy.
+type hnsw struct {
+	*flat
+	m              int
+	efConstruction int
+	levelMult      float64
+	rand           *rand.Rand
+	nodes          map[string]*hnswNode
+	entry          string
+	maxLevel       int
+}
+
+func newHNSW(options VectorOptions, distance func(a, b []float32) float32) *hnsw {
+	return &hnsw{
+		flat:           newFlat(distance),
+		m:              options.M,
+		efConstruction: options.EFConstruction,
+		levelMult:      1 / math.Log(float64(max(options.M, 2))),
+		// A fixed seed keeps the graph of every node in the cluster the same shape for the same insertion order.
+		rand:  rand.New(rand.NewSource(1)),
+		nodes: make(map[string]*hnswNode),
+	}
+}
+
+func (h *hnsw) maxNeighbours(layer int) int {
+	if layer == 0 {
+		return h.m * 2
+	}
+	return h.m
+}
+
+func (h *hnsw) add(key string, vector []float32) {
+	if _, ok := h.nodes[key]; ok {
+		h.remove(key)
+	}
+	h.flat.add(key, vector)
+
+	level := int(math.Floor(-math.Log(1-h.rand.Float64()) * h.levelMult))
+	node := &hnswNode{key: key, vector: vector, neighbours: make([][]string, level+1)}
+	h.nodes[key] = node
+
+	if len(h.nodes) == 1 {
+		h.entry = key
+		h.maxLevel = level
+		return
+	}
+
+	entry := h.entry
+	for layer := h.maxLevel; layer > level; layer-- {
+		entry = h.searchLayer(vector, []string{entry}, 1, layer)[0].Key
+	}
+
+	entries := []string{entry}
+	for layer := min(level, h.maxLevel); layer >= 0; layer-- {
+		candidates := h.searchLayer(vector, entries, h.efConstruction, layer)
+		node.neighbours[layer] = h.closest(candidates, h.m)
+		for _, neighbour := range node.neighbours[layer] {
+			n := h.nodes[neighbour]
+			n.neighbours[layer] = append(n.neighbours[layer], key)
+			if len(n.neighbours[layer]) > h.maxNeighbours(layer) {
+				n.neighbours[layer] = h.prune(n.vector, n.neighbours[layer], h.maxNeighbours(layer))
+			}
+		}
+		entries = make([]string, len(candidates))
+		for i, candidate := range candidates {
+			entries[i] = candidate.Key
+		}
+	}
+
+	if level > h.maxLevel {
+		h.entry = key
+		h.maxLevel = level
+	}
+}
+
+func (h *hnsw) remove(key string) {
+	node, ok := h.nodes[key]
+	if !ok {
+		return
+	}
+	h.flat.remove(key)
+	delete(h.nodes, key)
+
+	// Reconnect the neighbours of the removed node to each other so the graph stays navigable.
+	// Links to the removed node from nodes it does not link back to are skipped during traversal.
+	for layer, neighbours := range node.neighbours {
+		for _, neighbour := range neighbours {
+			n, ok := h.nodes[neighbour]
+			if !ok || layer >= len(n.neighbours) {
+				continue
+			}
+			candidates := slices.DeleteFunc(slices.Clone(n.neighbours[layer]), func(k string) bool {
+				return k == key
+			})
+			for _, k := range neighbours {
+				if k != neighbour && !slices.Contains(candidates, k) {
+					candidates = append(candidates, k)
+				}
+			}
+			n.neighbours[layer] = h.prune(n.vector, candidates, h.maxNeighbours(layer))
+		}
+	}
+
+	if h.entry != key {
+		return
+	}
+	h.entry, h.maxLevel = "", 0
+	for k, n := range h.nodes {
+		if h.entry == "" || len(n.neighbours)-1 > h.maxLevel {
+			h.entry, h.maxLevel = k, len(n.neighbours)-1
+		}
+	}
+}
+
+func (h *hnsw) search(query []float32, k int, ef int, allowed map[string]struct{}) []Neighbour {
+	if len(h.nodes) == 0 || k <= 0 {
+		return []Neighbour{}
+	}
+	// Small candidate sets are cheaper and exact to scan directly.
+	if allowed != nil && len(allowed) <= max(k, ef)*10 {
+		return h.flat.search(query, k, ef, allowed)
+	}
+
+	entry := h.entry
+	for layer := h.maxLevel; layer > 0; layer-- {
+		entry = h.searchLayer(query, []string{entry}, 1, layer)[0].Key
+	}
+	candidates := h.searchLayer(query, []string{entry}, max(ef, k), 0)
+
+	neighbours := make([]Neighbour, 0, k)
+	for _, candidate := range candidates {
+		if _, ok := allowed[candidate.Key]; allowed != nil && !ok {
+			continue
+		}
+		neighbours = append(neighbours, candidate)
+		if len(neighbours) == k {
+			return neighbours
+		}
+	}
+	if allowed != nil && len(neighbours) < min(k, len(allowed)) {
+		// The filter rejected too many of the graph's candidates.
+		return h.flat.search(query, k, ef, allowed)
+	}
+	return neighbours
+}
+
+// searchLayer returns up to ef nodes on the layer closest to the query, ordered by distance.
+func (h *hnsw) searchLayer(query []float32, entries []string, ef int, layer int) []Neighbour {
+	visited := make(map[string]struct{})
+	var candidates, results []Neighbour
+
+	insert := func(list []Neighbour, n Neighbour) []Neighbour {
+		i, _ := slices.BinarySearchFunc(list, n, compareNeighbours)
+		return slices.Insert(list, i, n)
+	}
+
+	for _, entry := range entries {
+		node, ok := h.nodes[entry]
+		if !ok {
+			continue
+		}
+		visited[entry] = struct{}{}
+		n := Neighbour{Key: entry, Distance: h.distance(query, node.vector)}
+		candidates = insert(candidates, n)
+		results = insert(results, n)
+	}
+	if len(results) > ef {
+		results = results[:ef]
+	}
+
+	for len(candidates) > 0 {
+		current := candidates[0]
+		candidates = candidates[1:]
+		if len(results) >= ef && current.Distance > results[len(results)-1].Distance {
+			break
+		}
+		node := h.nodes[current.Key]
+		if layer >= len(node.neighbours) {
+			continue
+		}
+		for _, key := range node.neighbours[layer] {
+			if _, ok := visited[key]; ok {
+				continue
+			}
+			visited[key] = struct{}{}
+			neighbour, ok := h.nodes[key]
+			if !ok {
+				continue
+			}
+			n := Neighbour{Key: key, Distance: h.distance(query, neighbour.vector)}
+			if len(results) < ef || n.Distance < results[len(results)-1].Distance {
+				candidates = insert(candidates, n)
+				results = insert(results, n)
+				if len(results) > ef {
+					results = results[:ef]
+				}
+			}
+		}
+	}
+
+	return results
+}
+
+func (h *hnsw) closest(candidates []Neighbour, n int) []string {
+	keys := make([]string, 0, n)
+	for _, candidate := range candidates {
+		if len(keys) == n {
+			break
+		}
+		keys = append(keys, candidate.Key)
+	}
+	return keys
+}
+
+// prune keeps the n keys closest to the vector, dropping keys of removed nodes.
+func (h *hnsw) prune(vector []float32, keys []string, n int) []string {
+	candidates := make([]Neighbour, 0, len(keys))
+	for _, key := range keys {
+		if node, ok := h.nodes[key]; ok {
+			candidates = append(candidates, Neighbour{Key: key, Distance: h.distance(vector, node.vector)})
+		}
+	}
+	sortNeighbours(candidates)
+	return h.closest(candidates, n)
+}
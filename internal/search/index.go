@@ -0,0 +1,352 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// document holds the indexed representation of a single hash.
+type document struct {
+	text    map[string][]string // TEXT field -> tokens
+	tags    map[string][]string // TAG field -> tags
+	numeric map[string]float64  // NUMERIC field -> value
+	vectors map[string]struct{} // VECTOR fields that hold a valid vector
+	values  map[string]string   // Raw string value of every indexed field, used for sorting and aggregation.
+}
+
+type Index struct {
+	schema Schema
+	mut    sync.RWMutex
+	docs   map[string]*document
+	// Inverted indexes: field -> term/tag -> set of document keys
+	terms map[string]map[string]map[string]struct{}
+	tags  map[string]map[string]map[string]struct{}
+	// Vector indexes: field -> index
+	vectors map[string]vectorIndex
+}
+
+func NewIndex(schema Schema) *Index {
+	index := &Index{
+		schema:  schema,
+		mut:     sync.RWMutex{},
+		docs:    make(map[string]*document),
+		terms:   make(map[string]map[string]map[string]struct{}),
+		tags:    make(map[string]map[string]map[string]struct{}),
+		vectors: make(map[string]vectorIndex),
+	}
+	for _, field := range schema.Fields {
+		switch field.Type {
+		case TextField:
+			index.terms[field.Name] = make(map[string]map[string]struct{})
+		case TagField:
+			index.tags[field.Name] = make(map[string]map[string]struct{})
+		case VectorField:
+			index.vectors[field.Name] = newVectorIndex(*field.Vector)
+		}
+	}
+	return index
+}
+
+func (index *Index) Schema() Schema {
+	return index.schema
+}
+
+// Len returns the number of documents currently held in the index.
+func (index *Index) Len() int {
+	index.mut.RLock()
+	defer index.mut.RUnlock()
+	return len(index.docs)
+}
+
+// Update (re)indexes the hash stored at key.
+// If the value is not a hash, the key is removed from the index.
+func (index *Index) Update(key string, value interface{}) {
+	hash, ok := value.(map[string]interface{})
+
+	index.mut.Lock()
+	defer index.mut.Unlock()
+
+	index.remove(key)
+
+	if !ok {
+		return
+	}
+
+	doc := &document{
+		text:    make(map[string][]string),
+		tags:    make(map[string][]string),
+		numeric: make(map[string]float64),
+		vectors: make(map[string]struct{}),
+		values:  make(map[string]string),
+	}
+	indexed := false
+
+	for _, field := range index.schema.Fields {
+		raw, ok := hash[field.Name]
+		if !ok || raw == nil {
+			continue
+		}
+		s := stringify(raw)
+		switch field.Type {
+		case TextField:
+			doc.text[field.Name] = Tokenize(s)
+		case TagField:
+			doc.tags[field.Name] = splitTags(s, field.Separator)
+		case NumericField:
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				// Values that are not numeric are not indexed on numeric fields.
+				continue
+			}
+			doc.numeric[field.Name] = f
+		case VectorField:
+			vector, err := ParseVector(s, field.Vector.Dim)
+			if err != nil {
+				// Vectors of the wrong dimension are not indexed.
+				continue
+			}
+			index.vectors[field.Name].add(key, vector)
+			doc.vectors[field.Name] = struct{}{}
+			// Vector blobs are not useful for sorting or aggregation.
+			indexed = true
+			continue
+		}
+		doc.values[field.Name] = s
+		indexed = true
+	}
+
+	if !indexed {
+		return
+	}
+
+	index.docs[key] = doc
+	for field, tokens := range doc.text {
+		for _, token := range tokens {
+			addPosting(index.terms[field], token, key)
+		}
+	}
+	for field, tags := range doc.tags {
+		for _, tag := range tags {
+			addPosting(index.tags[field], tag, key)
+		}
+	}
+}
+
+// Remove deletes the key from the index.
+func (index *Index) Remove(key string) {
+	index.mut.Lock()
+	defer index.mut.Unlock()
+	index.remove(key)
+}
+
+func (index *Index) remove(key string) {
+	doc, ok := index.docs[key]
+	if !ok {
+		return
+	}
+	for field, tokens := range doc.text {
+		for _, token := range tokens {
+			removePosting(index.terms[field], token, key)
+		}
+	}
+	for field, tags := range doc.tags {
+		for _, tag := range tags {
+			removePosting(index.tags[field], tag, key)
+		}
+	}
+	for field := range doc.vectors {
+		index.vectors[field].remove(key)
+	}
+	delete(index.docs, key)
+}
+
+// Value returns the raw string value of an indexed field for the document at key.
+func (index *Index) Value(key string, field string) (string, bool) {
+	index.mut.RLock()
+	defer index.mut.RUnlock()
+	doc, ok := index.docs[key]
+	if !ok {
+		return "", false
+	}
+	v, ok := doc.values[field]
+	return v, ok
+}
+
+// Numeric returns the numeric value of a NUMERIC field for the document at key.
+func (index *Index) Numeric(key string, field string) (float64, bool) {
+	index.mut.RLock()
+	defer index.mut.RUnlock()
+	doc, ok := index.docs[key]
+	if !ok {
+		return 0, false
+	}
+	v, ok := doc.numeric[field]
+	return v, ok
+}
+
+// Query parses and evaluates the query string against the index, returning the
+// keys of all the matching documents in no particular order.
+func (index *Index) Query(query string) ([]string, error) {
+	node, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	index.mut.RLock()
+	defer index.mut.RUnlock()
+
+	matches, err := node.eval(index)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(matches))
+	for key := range matches {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// KNN returns the k documents whose vectors are nearest to the query vector.
+// When filter is not empty or "*", only the documents matching the filter query are considered.
+func (index *Index) KNN(filter string, knn KNNQuery) ([]Neighbour, error) {
+	field, ok := index.schema.GetField(knn.Field)
+	if !ok || field.Type != VectorField {
+		return nil, fmt.Errorf("field %s is not a %s field", knn.Field, VectorField)
+	}
+	vector, err := ParseVector(knn.Vector, field.Vector.Dim)
+	if err != nil {
+		return nil, err
+	}
+
+	var node queryNode
+	if filter = strings.TrimSpace(filter); filter != "" && filter != "*" {
+		if node, err = ParseQuery(filter); err != nil {
+			return nil, err
+		}
+	}
+
+	index.mut.RLock()
+	defer index.mut.RUnlock()
+
+	var allowed map[string]struct{}
+	if node != nil {
+		if allowed, err = node.eval(index); err != nil {
+			return nil, err
+		}
+	}
+
+	ef := knn.EF
+	if ef <= 0 {
+		ef = field.Vector.EFRuntime
+	}
+	return index.vectors[knn.Field].search(vector, knn.K, ef, allowed), nil
+}
+
+// all returns the set of all document keys. The index must be read-locked.
+func (index *Index) all() map[string]struct{} {
+	res := make(map[string]struct{}, len(index.docs))
+	for key := range index.docs {
+		res[key] = struct{}{}
+	}
+	return res
+}
+
+func (index *Index) fieldsOfType(fieldType string) []string {
+	var fields []string
+	for _, field := range index.schema.Fields {
+		if field.Type == fieldType {
+			fields = append(fields, field.Name)
+		}
+	}
+	return fields
+}
+
+func (index *Index) checkField(name string, fieldType string) error {
+	field, ok := index.schema.GetField(name)
+	if !ok {
+		return fmt.Errorf("unknown field %s", name)
+	}
+	if field.Type != fieldType {
+		return fmt.Errorf("field %s is not a %s field", name, fieldType)
+	}
+	return nil
+}
+
+// Tokenize splits text into lowercase terms on any character that is not a letter or digit.
+func Tokenize(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	seen := make(map[string]struct{}, len(fields))
+	tokens := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if _, ok := seen[f]; ok {
+			continue
+		}
+		seen[f] = struct{}{}
+		tokens = append(tokens, f)
+	}
+	return tokens
+}
+
+func splitTags(s string, separator string) []string {
+	if separator == "" {
+		separator = ","
+	}
+	var tags []string
+	for _, tag := range strings.Split(s, separator) {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func addPosting(postings map[string]map[string]struct{}, term string, key string) {
+	if postings[term] == nil {
+		postings[term] = make(map[string]struct{})
+	}
+	postings[term][key] = struct{}{}
+}
+
+func removePosting(postings map[string]map[string]struct{}, term string, key string) {
+	if postings[term] == nil {
+		return
+	}
+	delete(postings[term], key)
+	if len(postings[term]) == 0 {
+		delete(postings, term)
+	}
+}
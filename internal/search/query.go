@@ -0,0 +1,531 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The query language is a subset of the RediSearch query syntax:
+//
+//	*                       matches every document in the index
+//	hello                   matches documents where any TEXT field contains the term
+//	hel*                    prefix match on TEXT fields
+//	@title:hello            term match on a specific TEXT field
+//	@title:(hello world)    all terms must be present in the field
+//	@tags:{red | blue}      TAG match on any of the listed tags
+//	@price:[10 (100]        NUMERIC range, "(" marks an exclusive bound, -inf and +inf are allowed
+//	-expr                   negation
+//	expr expr               intersection
+//	expr | expr             union
+//	( expr )                grouping
+//
+// A query can be followed by a KNN clause that returns the nearest neighbours among the documents
+// matching the query (the pre-filter). Use "*" as the pre-filter to search the whole index:
+//
+//	filter=>[KNN k @field $param [EF_RUNTIME ef] [AS alias]]
+//
+// where $param names the query vector in the PARAMS of the search. k can also be a $param.
+
+type queryNode interface {
+	eval(index *Index) (map[string]struct{}, error)
+}
+
+type allNode struct{}
+
+func (allNode) eval(index *Index) (map[string]struct{}, error) {
+	return index.all(), nil
+}
+
+type termNode struct {
+	field  string // Empty when the term applies to all TEXT fields
+	term   string
+	prefix bool
+}
+
+func (node termNode) eval(index *Index) (map[string]struct{}, error) {
+	fields := []string{node.field}
+	if node.field == "" {
+		fields = index.fieldsOfType(TextField)
+	} else if err := index.checkField(node.field, TextField); err != nil {
+		return nil, err
+	}
+	res := make(map[string]struct{})
+	for _, field := range fields {
+		postings := index.terms[field]
+		if !node.prefix {
+			for key := range postings[node.term] {
+				res[key] = struct{}{}
+			}
+			continue
+		}
+		for term, keys := range postings {
+			if strings.HasPrefix(term, node.term) {
+				for key := range keys {
+					res[key] = struct{}{}
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+type tagNode struct {
+	field string
+	tags  []string
+}
+
+func (node tagNode) eval(index *Index) (map[string]struct{}, error) {
+	if err := index.checkField(node.field, TagField); err != nil {
+		return nil, err
+	}
+	res := make(map[string]struct{})
+	for _, tag := range node.tags {
+		for key := range index.tags[node.field][tag] {
+			res[key] = struct{}{}
+		}
+	}
+	return res, nil
+}
+
+type numericNode struct {
+	field        string
+	min, max     float64
+	minExclusive bool
+	maxExclusive bool
+}
+
+func (node numericNode) eval(index *Index) (map[string]struct{}, error) {
+	if err := index.checkField(node.field, NumericField); err != nil {
+		return nil, err
+	}
+	res := make(map[string]struct{})
+	for key, doc := range index.docs {
+		v, ok := doc.numeric[node.field]
+		if !ok {
+			continue
+		}
+		if v < node.min || (node.minExclusive && v == node.min) {
+			continue
+		}
+		if v > node.max || (node.maxExclusive && v == node.max) {
+			continue
+		}
+		res[key] = struct{}{}
+	}
+	return res, nil
+}
+
+type notNode struct {
+	child queryNode
+}
+
+func (node notNode) eval(index *Index) (map[string]struct{}, error) {
+	excluded, err := node.child.eval(index)
+	if err != nil {
+		return nil, err
+	}
+	res := index.all()
+	for key := range excluded {
+		delete(res, key)
+	}
+	return res, nil
+}
+
+type andNode struct {
+	children []queryNode
+}
+
+func (node andNode) eval(index *Index) (map[string]struct{}, error) {
+	var res map[string]struct{}
+	for i, child := range node.children {
+		matches, err := child.eval(index)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			res = matches
+			continue
+		}
+		for key := range res {
+			if _, ok := matches[key]; !ok {
+				delete(res, key)
+			}
+		}
+	}
+	if res == nil {
+		res = make(map[string]struct{})
+	}
+	return res, nil
+}
+
+type orNode struct {
+	children []queryNode
+}
+
+func (node orNode) eval(index *Index) (map[string]struct{}, error) {
+	res := make(map[string]struct{})
+	for _, child := range node.children {
+		matches, err := child.eval(index)
+		if err != nil {
+			return nil, err
+		}
+		for key := range matches {
+			res[key] = struct{}{}
+		}
+	}
+	return res, nil
+}
+
+// KNNQuery is the parsed KNN clause of a query.
+type KNNQuery struct {
+	K          int
+	Field      string
+	Vector     string // The query vector, either a float32 blob or a comma separated list.
+	EF         int    // Candidate list size for HNSW indexes. The field's EF_RUNTIME is used when 0.
+	ScoreField string // The name under which the distance is returned. Defaults to __<field>_score.
+}
+
+// ParseKNN splits a query into its pre-filter and KNN clause, resolving $ references from params.
+// The returned KNNQuery is nil when the query does not have a KNN clause.
+func ParseKNN(query string, params map[string]string) (string, *KNNQuery, error) {
+	idx := strings.Index(query, "=>")
+	if idx == -1 {
+		return query, nil, nil
+	}
+	filter := strings.TrimSpace(query[:idx])
+	clause := strings.TrimSpace(query[idx+2:])
+	if !strings.HasPrefix(clause, "[") || !strings.HasSuffix(clause, "]") {
+		return "", nil, errors.New("syntax error: KNN clause must be enclosed in []")
+	}
+	args := strings.Fields(strings.TrimSpace(clause[1 : len(clause)-1]))
+
+	resolve := func(arg string) (string, error) {
+		if !strings.HasPrefix(arg, "$") {
+			return arg, nil
+		}
+		value, ok := params[arg[1:]]
+		if !ok {
+			return "", fmt.Errorf("no such parameter %s", arg[1:])
+		}
+		return value, nil
+	}
+
+	if len(args) < 4 || !strings.EqualFold(args[0], "knn") || !strings.HasPrefix(args[2], "@") {
+		return "", nil, errors.New("syntax error: expected KNN k @field $vector")
+	}
+	k, err := resolve(args[1])
+	if err != nil {
+		return "", nil, err
+	}
+	knn := &KNNQuery{Field: args[2][1:]}
+	if knn.K, err = strconv.Atoi(k); err != nil || knn.K < 0 {
+		return "", nil, errors.New("KNN k must be a non-negative integer")
+	}
+	if knn.Vector, err = resolve(args[3]); err != nil {
+		return "", nil, err
+	}
+	knn.ScoreField = fmt.Sprintf("__%s_score", knn.Field)
+
+	for i := 4; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return "", nil, fmt.Errorf("value required after %s", strings.ToUpper(args[i]))
+		}
+		value, err := resolve(args[i+1])
+		if err != nil {
+			return "", nil, err
+		}
+		switch strings.ToLower(args[i]) {
+		case "ef_runtime":
+			if knn.EF, err = strconv.Atoi(value); err != nil || knn.EF <= 0 {
+				return "", nil, errors.New("EF_RUNTIME must be a positive integer")
+			}
+		case "as":
+			knn.ScoreField = value
+		default:
+			return "", nil, fmt.Errorf("unknown KNN argument %s", strings.ToUpper(args[i]))
+		}
+	}
+
+	if filter == "" {
+		filter = "*"
+	}
+	return filter, knn, nil
+}
+
+type queryParser struct {
+	input []rune
+	pos   int
+}
+
+// ParseQuery parses a query string into an evaluable query tree.
+func ParseQuery(query string) (queryNode, error) {
+	p := &queryParser{input: []rune(strings.TrimSpace(query))}
+	if len(p.input) == 0 {
+		return nil, errors.New("empty query")
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("syntax error at offset %d near '%s'", p.pos, string(p.input[p.pos:]))
+	}
+	return node, nil
+}
+
+func (p *queryParser) peek() rune {
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *queryParser) expect(r rune) error {
+	p.skipSpace()
+	if p.peek() != r {
+		return fmt.Errorf("syntax error at offset %d: expected '%c'", p.pos, r)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	var children []queryNode
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+		p.skipSpace()
+		if p.peek() != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return orNode{children: children}, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var children []queryNode
+	for {
+		p.skipSpace()
+		if r := p.peek(); r == 0 || r == '|' || r == ')' {
+			break
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	switch len(children) {
+	case 0:
+		return nil, fmt.Errorf("syntax error at offset %d: expected expression", p.pos)
+	case 1:
+		return children[0], nil
+	default:
+		return andNode{children: children}, nil
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	p.skipSpace()
+	switch p.peek() {
+	case '-':
+		p.pos++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child: child}, nil
+	case '(':
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(')'); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case '*':
+		p.pos++
+		return allNode{}, nil
+	case '@':
+		p.pos++
+		return p.parseFieldExpr()
+	default:
+		return p.parseTerms("")
+	}
+}
+
+func (p *queryParser) parseFieldExpr() (queryNode, error) {
+	field := p.readWord(func(r rune) bool { return r != ':' && !unicode.IsSpace(r) })
+	if field == "" {
+		return nil, fmt.Errorf("syntax error at offset %d: expected field name", p.pos)
+	}
+	if err := p.expect(':'); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	switch p.peek() {
+	case '{':
+		p.pos++
+		end := strings.IndexRune(string(p.input[p.pos:]), '}')
+		if end == -1 {
+			return nil, errors.New("syntax error: unterminated tag list")
+		}
+		body := string(p.input[p.pos : p.pos+end])
+		p.pos += end + 1
+		var tags []string
+		for _, tag := range strings.Split(body, "|") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		return tagNode{field: field, tags: tags}, nil
+	case '[':
+		p.pos++
+		end := strings.IndexRune(string(p.input[p.pos:]), ']')
+		if end == -1 {
+			return nil, errors.New("syntax error: unterminated numeric range")
+		}
+		bounds := strings.Fields(string(p.input[p.pos : p.pos+end]))
+		p.pos += end + 1
+		if len(bounds) != 2 {
+			return nil, errors.New("numeric range must have a min and a max")
+		}
+		node := numericNode{field: field}
+		var err error
+		if node.min, node.minExclusive, err = parseBound(bounds[0]); err != nil {
+			return nil, err
+		}
+		if node.max, node.maxExclusive, err = parseBound(bounds[1]); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case '(':
+		p.pos++
+		node, err := p.parseTerms(field)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(')'); err != nil {
+			return nil, err
+		}
+		return node, nil
+	default:
+		return p.parseTerm(field)
+	}
+}
+
+// parseTerms parses one or more consecutive terms that all apply to the same field.
+func (p *queryParser) parseTerms(field string) (queryNode, error) {
+	if field == "" {
+		return p.parseTerm(field)
+	}
+	var children []queryNode
+	for {
+		p.skipSpace()
+		if r := p.peek(); r == 0 || r == ')' {
+			break
+		}
+		node, err := p.parseTerm(field)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 0 {
+		return nil, fmt.Errorf("syntax error at offset %d: expected term", p.pos)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return andNode{children: children}, nil
+}
+
+func (p *queryParser) parseTerm(field string) (queryNode, error) {
+	p.skipSpace()
+	if p.peek() == '"' {
+		// Quoted terms must all be present.
+		p.pos++
+		end := strings.IndexRune(string(p.input[p.pos:]), '"')
+		if end == -1 {
+			return nil, errors.New("syntax error: unterminated quote")
+		}
+		tokens := Tokenize(string(p.input[p.pos : p.pos+end]))
+		p.pos += end + 1
+		children := make([]queryNode, len(tokens))
+		for i, token := range tokens {
+			children[i] = termNode{field: field, term: token}
+		}
+		return andNode{children: children}, nil
+	}
+	word := p.readWord(func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '*'
+	})
+	if word == "" {
+		return nil, fmt.Errorf("syntax error at offset %d", p.pos)
+	}
+	prefix := strings.HasSuffix(word, "*")
+	return termNode{
+		field:  field,
+		term:   strings.ToLower(strings.TrimSuffix(word, "*")),
+		prefix: prefix,
+	}, nil
+}
+
+func (p *queryParser) readWord(accept func(r rune) bool) string {
+	start := p.pos
+	for p.pos < len(p.input) && accept(p.input[p.pos]) {
+		p.pos++
+	}
+	return string(p.input[start:p.pos])
+}
+
+func parseBound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	switch strings.ToLower(s) {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid numeric bound %s", s)
+	}
+	return f, exclusive, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Registry holds all the secondary indexes on the server.
+// The keyspace notifies the registry whenever a key is written or deleted so that
+// the indexes are kept in sync with the hashes they cover.
+type Registry struct {
+	mut          sync.RWMutex
+	indexes      map[string]*Index
+	getStateFunc func() map[string]interface{} // Returns a copy of the keyspace, used to backfill new indexes.
+}
+
+func WithGetStateFunc(f func() map[string]interface{}) func(registry *Registry) {
+	return func(registry *Registry) {
+		registry.getStateFunc = f
+	}
+}
+
+func NewRegistry(options ...func(registry *Registry)) *Registry {
+	registry := &Registry{
+		mut:     sync.RWMutex{},
+		indexes: make(map[string]*Index),
+		getStateFunc: func() map[string]interface{} {
+			return map[string]interface{}{}
+		},
+	}
+	for _, option := range options {
+		option(registry)
+	}
+	return registry
+}
+
+// Create registers a new index and backfills it with the existing keys that match its prefixes.
+func (registry *Registry) Create(schema Schema) error {
+	registry.mut.Lock()
+	if _, ok := registry.indexes[strings.ToLower(schema.Name)]; ok {
+		registry.mut.Unlock()
+		return fmt.Errorf("index %s already exists", schema.Name)
+	}
+	index := NewIndex(schema)
+	registry.indexes[strings.ToLower(schema.Name)] = index
+	registry.mut.Unlock()
+
+	for key, value := range registry.getStateFunc() {
+		if schema.Matches(key) {
+			index.Update(key, value)
+		}
+	}
+	return nil
+}
+
+// Drop removes the index and returns the keys of the documents it held.
+func (registry *Registry) Drop(name string) ([]string, error) {
+	registry.mut.Lock()
+	defer registry.mut.Unlock()
+	index, ok := registry.indexes[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown index %s", name)
+	}
+	delete(registry.indexes, strings.ToLower(name))
+	index.mut.RLock()
+	defer index.mut.RUnlock()
+	keys := make([]string, 0, len(index.docs))
+	for key := range index.docs {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (registry *Registry) Get(name string) (*Index, error) {
+	registry.mut.RLock()
+	defer registry.mut.RUnlock()
+	index, ok := registry.indexes[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown index %s", name)
+	}
+	return index, nil
+}
+
+// List returns the names of all the indexes in lexicographical order.
+func (registry *Registry) List() []string {
+	registry.mut.RLock()
+	defer registry.mut.RUnlock()
+	names := make([]string, 0, len(registry.indexes))
+	for _, index := range registry.indexes {
+		names = append(names, index.schema.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Schemas returns the definitions of all the indexes. This is what is persisted in
+// snapshots and AOF preambles.
+func (registry *Registry) Schemas() []Schema {
+	registry.mut.RLock()
+	defer registry.mut.RUnlock()
+	schemas := make([]Schema, 0, len(registry.indexes))
+	for _, index := range registry.indexes {
+		schemas = append(schemas, index.schema)
+	}
+	slices.SortFunc(schemas, func(a, b Schema) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return schemas
+}
+
+// Restore re-registers the indexes in the list of schemas. Indexes that already exist are skipped.
+// This should be called before the keyspace is restored so that the indexes are populated as
+// the keys are loaded.
+func (registry *Registry) Restore(schemas []Schema) {
+	registry.mut.Lock()
+	defer registry.mut.Unlock()
+	for _, schema := range schemas {
+		if _, ok := registry.indexes[strings.ToLower(schema.Name)]; ok {
+			continue
+		}
+		registry.indexes[strings.ToLower(schema.Name)] = NewIndex(schema)
+	}
+}
+
+// OnSet is called by the keyspace after the value at key has been written.
+func (registry *Registry) OnSet(key string, value interface{}) {
+	registry.mut.RLock()
+	defer registry.mut.RUnlock()
+	for _, index := range registry.indexes {
+		if index.schema.Matches(key) {
+			index.Update(key, value)
+		}
+	}
+}
+
+// OnDelete is called by the keyspace after the key has been deleted.
+func (registry *Registry) OnDelete(key string) {
+	registry.mut.RLock()
+	defer registry.mut.RUnlock()
+	for _, index := range registry.indexes {
+		index.Remove(key)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	TextField    = "TEXT"
+	TagField     = "TAG"
+	NumericField = "NUMERIC"
+	VectorField  = "VECTOR"
+)
+
+// Field describes a single hash field that is indexed by an index.
+type Field struct {
+	Name      string         `json:"Name"`
+	Type      string         `json:"Type"`
+	Sortable  bool           `json:"Sortable"`
+	Separator string         `json:"Separator"`        // Only used by TAG fields
+	Vector    *VectorOptions `json:"Vector,omitempty"` // Only used by VECTOR fields
+}
+
+// Schema is the definition of an index. It is the only part of the index that is persisted,
+// the index contents are rebuilt from the keyspace on restore.
+type Schema struct {
+	Name     string   `json:"Name"`
+	Prefixes []string `json:"Prefixes"`
+	Fields   []Field  `json:"Fields"`
+}
+
+// ParseSchema parses the arguments of an FT.CREATE command (excluding the command name)
+// into a Schema.
+// The expected format is: index [ON HASH] [PREFIX count prefix [prefix ...]] SCHEMA field type [options] ...
+func ParseSchema(args []string) (Schema, error) {
+	if len(args) < 4 {
+		return Schema{}, errors.New("wrong number of arguments")
+	}
+
+	schema := Schema{
+		Name:     args[0],
+		Prefixes: make([]string, 0),
+		Fields:   make([]Field, 0),
+	}
+
+	i := 1
+	for i < len(args) && !strings.EqualFold(args[i], "schema") {
+		switch strings.ToLower(args[i]) {
+		case "on":
+			if i+1 >= len(args) || !strings.EqualFold(args[i+1], "hash") {
+				return Schema{}, errors.New("only ON HASH indexes are supported")
+			}
+			i += 2
+		case "prefix":
+			if i+1 >= len(args) {
+				return Schema{}, errors.New("prefix count required after PREFIX")
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count < 0 || i+2+count > len(args) {
+				return Schema{}, errors.New("invalid PREFIX count")
+			}
+			schema.Prefixes = append(schema.Prefixes, args[i+2:i+2+count]...)
+			i += 2 + count
+		default:
+			return Schema{}, fmt.Errorf("unknown argument %s", strings.ToUpper(args[i]))
+		}
+	}
+
+	if i >= len(args) {
+		return Schema{}, errors.New("SCHEMA is required")
+	}
+	i += 1
+
+	for i < len(args) {
+		if i+1 >= len(args) {
+			return Schema{}, fmt.Errorf("type required for field %s", args[i])
+		}
+		field := Field{Name: args[i], Type: strings.ToUpper(args[i+1])}
+		if !slices.Contains([]string{TextField, TagField, NumericField, VectorField}, field.Type) {
+			return Schema{}, fmt.Errorf("unsupported field type %s", field.Type)
+		}
+		if field.Type == TagField {
+			field.Separator = ","
+		}
+		i += 2
+		if field.Type == VectorField {
+			options, n, err := parseVectorOptions(args[i:])
+			if err != nil {
+				return Schema{}, err
+			}
+			field.Vector = &options
+			i += n
+		}
+		// Parse field options
+	options:
+		for i < len(args) {
+			switch strings.ToLower(args[i]) {
+			case "sortable":
+				if field.Type == VectorField {
+					return Schema{}, errors.New("VECTOR fields cannot be SORTABLE")
+				}
+				field.Sortable = true
+				i += 1
+			case "separator":
+				if field.Type != TagField {
+					return Schema{}, errors.New("SEPARATOR is only valid for TAG fields")
+				}
+				if i+1 >= len(args) || len(args[i+1]) != 1 {
+					return Schema{}, errors.New("SEPARATOR must be a single character")
+				}
+				field.Separator = args[i+1]
+				i += 2
+			default:
+				break options
+			}
+		}
+		if slices.ContainsFunc(schema.Fields, func(f Field) bool { return f.Name == field.Name }) {
+			return Schema{}, fmt.Errorf("duplicate field %s", field.Name)
+		}
+		schema.Fields = append(schema.Fields, field)
+	}
+
+	if len(schema.Fields) == 0 {
+		return Schema{}, errors.New("schema must contain at least one field")
+	}
+
+	return schema, nil
+}
+
+// Matches returns true if the key falls under one of the schema's prefixes.
+// A schema with no prefixes matches every key.
+func (schema Schema) Matches(key string) bool {
+	if len(schema.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range schema.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetField returns the field with the given name and whether it exists in the schema.
+func (schema Schema) GetField(name string) (Field, bool) {
+	idx := slices.IndexFunc(schema.Fields, func(f Field) bool {
+		return f.Name == name
+	})
+	if idx == -1 {
+		return Field{}, false
+	}
+	return schema.Fields[idx], true
+}
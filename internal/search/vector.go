@@ -0,0 +1,281 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	FlatAlgorithm = "FLAT"
+	HNSWAlgorithm = "HNSW"
+
+	CosineMetric       = "COSINE"
+	L2Metric           = "L2"
+	InnerProductMetric = "IP"
+)
+
+// VectorOptions holds the parameters of a VECTOR field.
+type VectorOptions struct {
+	Algorithm      string `json:"Algorithm"`
+	Dim            int    `json:"Dim"`
+	Metric         string `json:"Metric"`
+	M              int    `json:"M,omitempty"`              // HNSW only: max number of neighbours per node per layer.
+	EFConstruction int    `json:"EFConstruction,omitempty"` // HNSW only: candidate list size while inserting.
+	EFRuntime      int    `json:"EFRuntime,omitempty"`      // HNSW only: default candidate list size while searching.
+}
+
+// Neighbour is a single KNN result.
+type Neighbour struct {
+	Key      string
+	Distance float32
+}
+
+// vectorIndex is implemented by the FLAT and HNSW indexes.
+type vectorIndex interface {
+	add(key string, vector []float32)
+	remove(key string)
+	// search returns up to k of the closest vectors whose keys are in the allowed set, ordered by distance.
+	// A nil allowed set accepts every key.
+	search(query []float32, k int, ef int, allowed map[string]struct{}) []Neighbour
+	get(key string) ([]float32, bool)
+	len() int
+}
+
+// parseVectorOptions parses the attributes of a VECTOR field in FT.CREATE:
+// VECTOR FLAT|HNSW count TYPE FLOAT32 DIM n DISTANCE_METRIC COSINE|L2|IP [M m] [EF_CONSTRUCTION n] [EF_RUNTIME n]
+// It returns the options and the number of arguments consumed.
+func parseVectorOptions(args []string) (VectorOptions, int, error) {
+	if len(args) < 2 {
+		return VectorOptions{}, 0, errors.New("VECTOR requires an algorithm and an attribute count")
+	}
+	options := VectorOptions{
+		Algorithm:      strings.ToUpper(args[0]),
+		M:              16,
+		EFConstruction: 200,
+		EFRuntime:      10,
+	}
+	if options.Algorithm != FlatAlgorithm && options.Algorithm != HNSWAlgorithm {
+		return VectorOptions{}, 0, fmt.Errorf("unsupported vector algorithm %s", options.Algorithm)
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 0 || count%2 != 0 || 2+count > len(args) {
+		return VectorOptions{}, 0, errors.New("invalid VECTOR attribute count")
+	}
+	attributes := args[2 : 2+count]
+	for i := 0; i < len(attributes); i += 2 {
+		name, value := strings.ToUpper(attributes[i]), attributes[i+1]
+		switch name {
+		case "TYPE":
+			if !strings.EqualFold(value, "float32") {
+				return VectorOptions{}, 0, fmt.Errorf("unsupported vector type %s", value)
+			}
+		case "DIM":
+			if options.Dim, err = strconv.Atoi(value); err != nil || options.Dim <= 0 {
+				return VectorOptions{}, 0, errors.New("DIM must be a positive integer")
+			}
+		case "DISTANCE_METRIC":
+			options.Metric = strings.ToUpper(value)
+			if !slices.Contains([]string{CosineMetric, L2Metric, InnerProductMetric}, options.Metric) {
+				return VectorOptions{}, 0, fmt.Errorf("unsupported distance metric %s", value)
+			}
+		case "M", "EF_CONSTRUCTION", "EF_RUNTIME":
+			if options.Algorithm != HNSWAlgorithm {
+				return VectorOptions{}, 0, fmt.Errorf("%s is only valid for HNSW", name)
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return VectorOptions{}, 0, fmt.Errorf("%s must be a positive integer", name)
+			}
+			switch name {
+			case "M":
+				options.M = n
+			case "EF_CONSTRUCTION":
+				options.EFConstruction = n
+			case "EF_RUNTIME":
+				options.EFRuntime = n
+			}
+		default:
+			return VectorOptions{}, 0, fmt.Errorf("unknown vector attribute %s", name)
+		}
+	}
+	if options.Dim == 0 {
+		return VectorOptions{}, 0, errors.New("DIM is required for VECTOR fields")
+	}
+	if options.Metric == "" {
+		return VectorOptions{}, 0, errors.New("DISTANCE_METRIC is required for VECTOR fields")
+	}
+	return options, 2 + count, nil
+}
+
+func newVectorIndex(options VectorOptions) vectorIndex {
+	distance := distanceFunc(options.Metric)
+	if options.Algorithm == HNSWAlgorithm {
+		return newHNSW(options, distance)
+	}
+	return newFlat(distance)
+}
+
+// ParseVector decodes a vector of the given dimension.
+// The value can either be a comma separated list of numbers optionally wrapped in square brackets,
+// or a little-endian float32 blob. The value is parsed as a list first, so that a list that happens to be
+// dim*4 bytes long, such as "0.50" for a vector of dimension 1, is not read as a blob.
+func ParseVector(value string, dim int) ([]float32, error) {
+	if vector, ok := parseVectorList(value, dim); ok {
+		return vector, nil
+	}
+	if len(value) != dim*4 {
+		return nil, fmt.Errorf("expected vector of dimension %d", dim)
+	}
+	vector := make([]float32, dim)
+	for i := 0; i < dim; i++ {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32([]byte(value[i*4 : (i+1)*4])))
+	}
+	return vector, nil
+}
+
+// parseVectorList parses a comma separated list of dim numbers, or returns false if the value is not one.
+func parseVectorList(value string, dim int) ([]float32, bool) {
+	parts := strings.Split(strings.Trim(strings.TrimSpace(value), "[]"), ",")
+	if len(parts) != dim {
+		return nil, false
+	}
+	vector := make([]float32, dim)
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+		if err != nil {
+			return nil, false
+		}
+		vector[i] = float32(f)
+	}
+	return vector, true
+}
+
+// EncodeVector encodes the vector as a little-endian float32 blob.
+func EncodeVector(vector []float32) string {
+	b := make([]byte, len(vector)*4)
+	for i, f := range vector {
+		binary.LittleEndian.PutUint32(b[i*4:], math.Float32bits(f))
+	}
+	return string(b)
+}
+
+func distanceFunc(metric string) func(a, b []float32) float32 {
+	switch metric {
+	case L2Metric:
+		// Squared euclidean distance.
+		return func(a, b []float32) float32 {
+			var sum float32
+			for i := range a {
+				d := a[i] - b[i]
+				sum += d * d
+			}
+			return sum
+		}
+	case InnerProductMetric:
+		return func(a, b []float32) float32 {
+			var dot float32
+			for i := range a {
+				dot += a[i] * b[i]
+			}
+			return 1 - dot
+		}
+	default:
+		return func(a, b []float32) float32 {
+			var dot, normA, normB float32
+			for i := range a {
+				dot += a[i] * b[i]
+				normA += a[i] * a[i]
+				normB += b[i] * b[i]
+			}
+			if normA == 0 || normB == 0 {
+				return 1
+			}
+			return 1 - dot/float32(math.Sqrt(float64(normA))*math.Sqrt(float64(normB)))
+		}
+	}
+}
+
+// compareNeighbours orders neighbours by distance, breaking ties by key.
+func compareNeighbours(a, b Neighbour) int {
+	switch {
+	case a.Distance < b.Distance:
+		return -1
+	case a.Distance > b.Distance:
+		return 1
+	default:
+		return strings.Compare(a.Key, b.Key)
+	}
+}
+
+func sortNeighbours(neighbours []Neighbour) {
+	slices.SortFunc(neighbours, compareNeighbours)
+}
+
+// flat is a brute force vector index.
+type flat struct {
+	distance func(a, b []float32) float32
+	vectors  map[string][]float32
+}
+
+func newFlat(distance func(a, b []float32) float32) *flat {
+	return &flat{distance: distance, vectors: make(map[string][]float32)}
+}
+
+func (f *flat) add(key string, vector []float32) {
+	f.vectors[key] = vector
+}
+
+func (f *flat) remove(key string) {
+	delete(f.vectors, key)
+}
+
+func (f *flat) get(key string) ([]float32, bool) {
+	v, ok := f.vectors[key]
+	return v, ok
+}
+
+func (f *flat) len() int {
+	return len(f.vectors)
+}
+
+func (f *flat) search(query []float32, k int, _ int, allowed map[string]struct{}) []Neighbour {
+	neighbours := make([]Neighbour, 0)
+	if allowed != nil && len(allowed) < len(f.vectors) {
+		for key := range allowed {
+			if vector, ok := f.vectors[key]; ok {
+				neighbours = append(neighbours, Neighbour{Key: key, Distance: f.distance(query, vector)})
+			}
+		}
+	} else {
+		for key, vector := range f.vectors {
+			if _, ok := allowed[key]; allowed != nil && !ok {
+				continue
+			}
+			neighbours = append(neighbours, Neighbour{Key: key, Distance: f.distance(query, vector)})
+		}
+	}
+	sortNeighbours(neighbours)
+	if len(neighbours) > k {
+		neighbours = neighbours[:k]
+	}
+	return neighbours
+}
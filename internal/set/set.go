@@ -16,6 +16,7 @@ package set
 
 import (
 	"github.com/echovault/echovault/internal"
+	"maps"
 	"math/rand"
 	"slices"
 )
@@ -34,6 +35,14 @@ func NewSet(elems []string) *Set {
 	return set
 }
 
+// Clone returns a copy of the set that is not affected by changes to the set.
+func (set *Set) Clone() interface{} {
+	return &Set{
+		members: maps.Clone(set.members),
+		length:  set.length,
+	}
+}
+
 func (set *Set) Add(elems []string) int {
 	count := 0
 	for _, e := range elems {
@@ -0,0 +1,77 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"time"
+)
+
+// The payload returned by DUMP and accepted by RESTORE is laid out as follows:
+//
+//	value | remaining TTL in milliseconds (varint, 0 when the key does not expire) | version (2 bytes) | CRC-64 (8 bytes)
+//
+// The value uses the same encoding as the value of a snapshot key record. The version and checksum are little endian
+// and the checksum covers everything before it.
+
+// DumpVersion is the version of the DUMP payload format.
+const DumpVersion = 1
+
+var dumpTable = crc64.MakeTable(crc64.ECMA)
+
+var ErrDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// EncodeDump serializes the value with its remaining time to live. A ttl of 0 means the key does not expire.
+func EncodeDump(value interface{}, ttl time.Duration) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := encodeValue(buf, value); err != nil {
+		return nil, err
+	}
+	writeVarint(buf, ttl.Milliseconds())
+	_ = binary.Write(buf, binary.LittleEndian, uint16(DumpVersion))
+	_ = binary.Write(buf, binary.LittleEndian, crc64.Checksum(buf.Bytes(), dumpTable))
+	return buf.Bytes(), nil
+}
+
+// DecodeDump verifies a payload created by EncodeDump and returns the value and its remaining time to live.
+// The payload comes from a client, so a value that breaks the invariants of its type is rejected, and no more
+// memory is allocated than the payload takes.
+func DecodeDump(payload []byte) (interface{}, time.Duration, error) {
+	if len(payload) < 10 {
+		return nil, 0, ErrDumpPayload
+	}
+	body := payload[:len(payload)-8]
+	if crc64.Checksum(body, dumpTable) != binary.LittleEndian.Uint64(payload[len(payload)-8:]) {
+		return nil, 0, ErrDumpPayload
+	}
+	if version := binary.LittleEndian.Uint16(body[len(body)-2:]); version == 0 || version > DumpVersion {
+		return nil, 0, ErrDumpPayload
+	}
+
+	r := bytes.NewReader(body[:len(body)-2])
+	value, err := decodeValue(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed DUMP payload: %w", err)
+	}
+	ttl, err := binary.ReadVarint(r)
+	if err != nil || ttl < 0 || r.Len() != 0 {
+		return nil, 0, errors.New("malformed DUMP payload")
+	}
+	return value, time.Duration(ttl) * time.Millisecond, nil
+}
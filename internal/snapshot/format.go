@@ -0,0 +1,701 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/internal/set"
+	"github.com/echovault/echovault/internal/sorted_set"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The binary snapshot format is laid out as follows:
+//
+//	header:  magic "EVSNAP" | version (1 byte) | compression (1 byte)
+//	body:    record*  (compressed with the compression in the header)
+//	record:  type (1 byte) | payload length (uvarint) | payload | CRC32-C of type and payload (4 bytes)
+//
+// The body starts with a meta record holding the snapshot time, followed by an optional indexes record,
+// one record per key and an end record holding the number of key records.
+// Each key record holds the key, the expiry time in unix milliseconds (0 when the key does not expire)
+// and the value, which is a type tag followed by the encoding for that type.
+// Backups that only hold the changes since an earlier backup also have delete records, which hold a key
+// that was deleted. Delete records are not counted by the end record.
+
+const (
+	formatMagic = "EVSNAP"
+	// FormatVersion is the version of the snapshot format written by the Encoder.
+	FormatVersion = 1
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// RecordType identifies the kind of a snapshot record.
+type RecordType byte
+
+const (
+	RecordMeta    RecordType = 1
+	RecordIndexes RecordType = 2
+	RecordKey     RecordType = 3
+	RecordDelete  RecordType = 4
+	recordEnd     RecordType = 255
+)
+
+const (
+	valueString    byte = 1
+	valueInt       byte = 2
+	valueFloat     byte = 3
+	valueList      byte = 4
+	valueHash      byte = 5
+	valueSet       byte = 6
+	valueSortedSet byte = 7
+	valueEncoded   byte = 8
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrChecksum is returned when a snapshot record does not match its checksum.
+	ErrChecksum = errors.New("snapshot record checksum mismatch")
+)
+
+var compressionCodes = map[string]byte{
+	CompressionNone: 0,
+	CompressionGzip: 1,
+}
+
+// Encoder streams a snapshot in the binary snapshot format to an io.Writer.
+// Only the record being written is held in memory.
+type Encoder struct {
+	out        *bufio.Writer
+	compressor io.WriteCloser
+	w          io.Writer
+	record     bytes.Buffer
+	digest     hash.Hash
+	keys       uint64
+}
+
+// NewEncoder writes the snapshot header to w and returns an Encoder for the snapshot body.
+// Compression must be one of CompressionNone or CompressionGzip. An empty compression means no compression.
+func NewEncoder(w io.Writer, compression string) (*Encoder, error) {
+	if compression == "" {
+		compression = CompressionNone
+	}
+	code, ok := compressionCodes[strings.ToLower(compression)]
+	if !ok {
+		return nil, fmt.Errorf("unknown snapshot compression %s", compression)
+	}
+
+	encoder := &Encoder{
+		out:    bufio.NewWriter(w),
+		digest: md5.New(),
+	}
+	if _, err := encoder.out.Write(append([]byte(formatMagic), FormatVersion, code)); err != nil {
+		return nil, err
+	}
+
+	encoder.w = encoder.out
+	if code == compressionCodes[CompressionGzip] {
+		encoder.compressor = gzip.NewWriter(encoder.out)
+		encoder.w = encoder.compressor
+	}
+
+	return encoder, nil
+}
+
+// WriteMeta writes the time of the snapshot in unix milliseconds.
+func (encoder *Encoder) WriteMeta(latestSnapshotMilliseconds int64) error {
+	encoder.record.Reset()
+	writeVarint(&encoder.record, latestSnapshotMilliseconds)
+	return encoder.writeRecord(RecordMeta, false)
+}
+
+// WriteIndexes writes the secondary index definitions.
+func (encoder *Encoder) WriteIndexes(schemas []search.Schema) error {
+	if len(schemas) == 0 {
+		return nil
+	}
+	encoder.record.Reset()
+	if err := json.NewEncoder(&encoder.record).Encode(schemas); err != nil {
+		return err
+	}
+	return encoder.writeRecord(RecordIndexes, true)
+}
+
+// WriteKey writes a key with its value and expiry time.
+func (encoder *Encoder) WriteKey(key string, data internal.KeyData) error {
+	encoder.record.Reset()
+	writeString(&encoder.record, key)
+	var expireAt int64
+	if data.ExpireAt != (time.Time{}) {
+		expireAt = data.ExpireAt.UnixMilli()
+	}
+	writeVarint(&encoder.record, expireAt)
+	if err := encodeValue(&encoder.record, data.Value); err != nil {
+		return fmt.Errorf("key %s: %v", key, err)
+	}
+	encoder.keys += 1
+	return encoder.writeRecord(RecordKey, true)
+}
+
+// WriteDelete writes a key that was deleted.
+func (encoder *Encoder) WriteDelete(key string) error {
+	encoder.record.Reset()
+	writeString(&encoder.record, key)
+	return encoder.writeRecord(RecordDelete, true)
+}
+
+// WriteState writes the keys of the state in key order, skipping the keys that have already expired.
+func (encoder *Encoder) WriteState(state map[string]internal.KeyData) error {
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	return encoder.writeKeys(keys, func(key string) (internal.KeyData, bool) {
+		data, ok := state[key]
+		return data, ok
+	})
+}
+
+// WriteCapture writes the keys of the capture like WriteState. The value of each key is copied from the capture
+// just before it's written, so the whole state is never copied at once.
+func (encoder *Encoder) WriteCapture(capture internal.StateCapture) error {
+	return encoder.writeKeys(slices.Clone(capture.Keys()), capture.Get)
+}
+
+func (encoder *Encoder) writeKeys(keys []string, get func(key string) (internal.KeyData, bool)) error {
+	slices.Sort(keys)
+	now := time.Now()
+	for _, key := range keys {
+		data, ok := get(key)
+		if !ok || data.ExpireAt != (time.Time{}) && data.ExpireAt.Before(now) {
+			continue
+		}
+		if err := encoder.WriteKey(key, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Digest returns the MD5 digest of the index and key records written so far.
+// The snapshot time is not part of the digest, so it can be used to detect whether the state has changed
+// since the previous snapshot.
+func (encoder *Encoder) Digest() [16]byte {
+	var digest [16]byte
+	copy(digest[:], encoder.digest.Sum(nil))
+	return digest
+}
+
+// Close writes the end record and flushes the snapshot. It does not close the underlying writer.
+func (encoder *Encoder) Close() error {
+	encoder.record.Reset()
+	writeUvarint(&encoder.record, encoder.keys)
+	if err := encoder.writeRecord(recordEnd, false); err != nil {
+		return err
+	}
+	if encoder.compressor != nil {
+		if err := encoder.compressor.Close(); err != nil {
+			return err
+		}
+	}
+	return encoder.out.Flush()
+}
+
+func (encoder *Encoder) writeRecord(recordType RecordType, digest bool) error {
+	payload := encoder.record.Bytes()
+
+	header := binary.AppendUvarint([]byte{byte(recordType)}, uint64(len(payload)))
+	crc := crc32.Update(crc32.Update(0, crcTable, header[:1]), crcTable, payload)
+
+	if digest {
+		encoder.digest.Write(header[:1])
+		encoder.digest.Write(payload)
+	}
+
+	if _, err := encoder.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := encoder.w.Write(payload); err != nil {
+		return err
+	}
+	return binary.Write(encoder.w, binary.BigEndian, crc)
+}
+
+// Decoder reads a snapshot in the binary snapshot format.
+type Decoder struct {
+	r       *bufio.Reader
+	payload []byte
+	keys    uint64
+	done    bool
+}
+
+// Record is a single record read from a snapshot. Only the fields of the record type are set.
+type Record struct {
+	Type                       RecordType
+	LatestSnapshotMilliseconds int64
+	Indexes                    []search.Schema
+	Key                        string
+	Data                       internal.KeyData
+}
+
+// NewDecoder reads and validates the snapshot header from r.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	header := make([]byte, len(formatMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("could not read snapshot header: %v", err)
+	}
+	if string(header[:len(formatMagic)]) != formatMagic {
+		return nil, errors.New("not a binary snapshot")
+	}
+	if version := header[len(formatMagic)]; version == 0 || version > FormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot format version %d", version)
+	}
+
+	decoder := &Decoder{}
+	switch header[len(formatMagic)+1] {
+	case compressionCodes[CompressionNone]:
+		decoder.r = bufio.NewReader(r)
+	case compressionCodes[CompressionGzip]:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		decoder.r = bufio.NewReader(gz)
+	default:
+		return nil, fmt.Errorf("unknown snapshot compression code %d", header[len(formatMagic)+1])
+	}
+
+	return decoder, nil
+}
+
+// Next returns the next meta, indexes, key or delete record of the snapshot.
+// It returns io.EOF after the end record has been read and the number of keys has been verified.
+func (decoder *Decoder) Next() (Record, error) {
+	if decoder.done {
+		return Record{}, io.EOF
+	}
+
+	b, err := decoder.r.ReadByte()
+	recordType := RecordType(b)
+	if err != nil {
+		return Record{}, fmt.Errorf("unexpected end of snapshot: %v", err)
+	}
+	length, err := binary.ReadUvarint(decoder.r)
+	if err != nil {
+		return Record{}, fmt.Errorf("unexpected end of snapshot: %v", err)
+	}
+	if cap(decoder.payload) < int(length) {
+		decoder.payload = make([]byte, length)
+	}
+	payload := decoder.payload[:length]
+	if _, err = io.ReadFull(decoder.r, payload); err != nil {
+		return Record{}, fmt.Errorf("unexpected end of snapshot: %v", err)
+	}
+	var crc uint32
+	if err = binary.Read(decoder.r, binary.BigEndian, &crc); err != nil {
+		return Record{}, fmt.Errorf("unexpected end of snapshot: %v", err)
+	}
+	if crc != crc32.Update(crc32.Update(0, crcTable, []byte{b}), crcTable, payload) {
+		return Record{}, ErrChecksum
+	}
+
+	r := bytes.NewReader(payload)
+	switch recordType {
+	default:
+		return Record{}, fmt.Errorf("unknown snapshot record type %d", recordType)
+
+	case RecordMeta:
+		msec, err := binary.ReadVarint(r)
+		if err != nil {
+			return Record{}, err
+		}
+		return Record{Type: RecordMeta, LatestSnapshotMilliseconds: msec}, nil
+
+	case RecordIndexes:
+		var schemas []search.Schema
+		if err = json.Unmarshal(payload, &schemas); err != nil {
+			return Record{}, err
+		}
+		return Record{Type: RecordIndexes, Indexes: schemas}, nil
+
+	case RecordKey:
+		key, err := readString(r)
+		if err != nil {
+			return Record{}, err
+		}
+		expireAt, err := binary.ReadVarint(r)
+		if err != nil {
+			return Record{}, err
+		}
+		value, err := decodeValue(r)
+		if err != nil {
+			return Record{}, fmt.Errorf("key %s: %v", key, err)
+		}
+		data := internal.KeyData{Value: value}
+		if expireAt != 0 {
+			data.ExpireAt = time.UnixMilli(expireAt)
+		}
+		decoder.keys += 1
+		return Record{Type: RecordKey, Key: key, Data: data}, nil
+
+	case RecordDelete:
+		key, err := readString(r)
+		if err != nil {
+			return Record{}, err
+		}
+		return Record{Type: RecordDelete, Key: key}, nil
+
+	case recordEnd:
+		keys, err := binary.ReadUvarint(r)
+		if err != nil {
+			return Record{}, err
+		}
+		if keys != decoder.keys {
+			return Record{}, fmt.Errorf("snapshot is incomplete, expected %d keys, got %d", keys, decoder.keys)
+		}
+		decoder.done = true
+		return Record{}, io.EOF
+	}
+}
+
+// Load reads a snapshot from r and passes its contents to the given functions as it is read.
+// The index definitions are restored before any key is set. Keys that have already expired are skipped.
+// Snapshots written in the legacy JSON format are also accepted.
+// It returns the time of the snapshot in unix milliseconds.
+func Load(
+	r io.Reader,
+	restoreIndexes func(schemas []search.Schema),
+	setKeyData func(key string, data internal.KeyData),
+) (int64, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(formatMagic)); err != nil || string(magic) != formatMagic {
+		if encryption.IsEncrypted(magic) {
+			return 0, encryption.ErrNoKeyring
+		}
+		return loadJSON(br, restoreIndexes, setKeyData)
+	}
+
+	decoder, err := NewDecoder(br)
+	if err != nil {
+		return 0, err
+	}
+
+	var msec int64
+	indexesRestored := false
+	now := time.Now()
+	for {
+		record, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch record.Type {
+		case RecordKey:
+			if !indexesRestored {
+				restoreIndexes(nil)
+				indexesRestored = true
+			}
+			if record.Data.ExpireAt != (time.Time{}) && record.Data.ExpireAt.Before(now) {
+				continue
+			}
+			setKeyData(record.Key, record.Data)
+		case RecordIndexes:
+			restoreIndexes(record.Indexes)
+			indexesRestored = true
+		case RecordMeta:
+			msec = record.LatestSnapshotMilliseconds
+		}
+	}
+	if !indexesRestored {
+		restoreIndexes(nil)
+	}
+
+	return msec, nil
+}
+
+func loadJSON(
+	r io.Reader,
+	restoreIndexes func(schemas []search.Schema),
+	setKeyData func(key string, data internal.KeyData),
+) (int64, error) {
+	snapshotObject := internal.SnapshotObject{
+		State: make(map[string]internal.KeyData),
+	}
+	if err := json.NewDecoder(r).Decode(&snapshotObject); err != nil {
+		return 0, err
+	}
+	restoreIndexes(snapshotObject.Indexes)
+	for key, data := range internal.FilterExpiredKeys(snapshotObject.State) {
+		setKeyData(key, data)
+	}
+	return snapshotObject.LatestSnapshotMilliseconds, nil
+}
+
+func encodeValue(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	default:
+		typeName, encoded, ok, err := internal.EncodeValue(value)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("cannot encode value of type %T", value)
+		}
+		buf.WriteByte(valueEncoded)
+		writeString(buf, typeName)
+		writeString(buf, string(encoded))
+
+	case string:
+		buf.WriteByte(valueString)
+		writeString(buf, v)
+
+	case int:
+		buf.WriteByte(valueInt)
+		writeVarint(buf, int64(v))
+
+	case int64:
+		buf.WriteByte(valueInt)
+		writeVarint(buf, v)
+
+	case float64:
+		buf.WriteByte(valueFloat)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+
+	case []interface{}:
+		buf.WriteByte(valueList)
+		writeUvarint(buf, uint64(len(v)))
+		for _, elem := range v {
+			if err := encodeValue(buf, elem); err != nil {
+				return err
+			}
+		}
+
+	case map[string]interface{}:
+		buf.WriteByte(valueHash)
+		fields := make([]string, 0, len(v))
+		for field := range v {
+			fields = append(fields, field)
+		}
+		slices.Sort(fields)
+		writeUvarint(buf, uint64(len(fields)))
+		for _, field := range fields {
+			writeString(buf, field)
+			if err := encodeValue(buf, v[field]); err != nil {
+				return err
+			}
+		}
+
+	case *set.Set:
+		buf.WriteByte(valueSet)
+		members := v.GetAll()
+		slices.Sort(members)
+		writeUvarint(buf, uint64(len(members)))
+		for _, member := range members {
+			writeString(buf, member)
+		}
+
+	case *sorted_set.SortedSet:
+		buf.WriteByte(valueSortedSet)
+		members := v.GetAll()
+		slices.SortFunc(members, func(a, b sorted_set.MemberParam) int {
+			return strings.Compare(string(a.Value), string(b.Value))
+		})
+		writeUvarint(buf, uint64(len(members)))
+		for _, member := range members {
+			writeString(buf, string(member.Value))
+			_ = binary.Write(buf, binary.BigEndian, math.Float64bits(float64(member.Score)))
+		}
+	}
+	return nil
+}
+
+func decodeValue(r *bytes.Reader) (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	default:
+		return nil, fmt.Errorf("unknown value type %d", tag)
+
+	case valueString:
+		return readString(r)
+
+	case valueInt:
+		n, err := binary.ReadVarint(r)
+		return int(n), err
+
+	case valueFloat:
+		return readFloat(r)
+
+	case valueList:
+		length, err := readLength(r)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, length)
+		for i := range list {
+			if list[i], err = decodeElement(r); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+
+	case valueHash:
+		length, err := readLength(r)
+		if err != nil {
+			return nil, err
+		}
+		hash := make(map[string]interface{}, length)
+		for i := 0; i < length; i++ {
+			field, err := readString(r)
+			if err != nil {
+				return nil, err
+			}
+			if hash[field], err = decodeElement(r); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+
+	case valueSet:
+		length, err := readLength(r)
+		if err != nil {
+			return nil, err
+		}
+		members := make([]string, length)
+		for i := range members {
+			if members[i], err = readString(r); err != nil {
+				return nil, err
+			}
+		}
+		return set.NewSet(members), nil
+
+	case valueSortedSet:
+		length, err := readLength(r)
+		if err != nil {
+			return nil, err
+		}
+		members := make([]sorted_set.MemberParam, length)
+		for i := range members {
+			value, err := readString(r)
+			if err != nil {
+				return nil, err
+			}
+			score, err := readFloat(r)
+			if err != nil {
+				return nil, err
+			}
+			if math.IsNaN(score) {
+				return nil, errors.New("sorted set score is not a number")
+			}
+			members[i] = sorted_set.MemberParam{Value: sorted_set.Value(value), Score: sorted_set.Score(score)}
+		}
+		return sorted_set.NewSortedSet(members), nil
+
+	case valueEncoded:
+		typeName, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		return internal.DecodeValue(typeName, []byte(encoded))
+	}
+}
+
+// decodeElement decodes an element of a list or a value of a hash, which is always a string or a number.
+// Rejecting nested collections also bounds the recursion on corrupted data, or on a RESTORE payload.
+func decodeElement(r *bytes.Reader) (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains([]byte{valueString, valueInt, valueFloat}, tag) {
+		return nil, fmt.Errorf("unexpected value type %d in a collection", tag)
+	}
+	_ = r.UnreadByte()
+	return decodeValue(r)
+}
+
+func writeUvarint(buf *bytes.Buffer, n uint64) {
+	buf.Write(binary.AppendUvarint(nil, n))
+}
+
+func writeVarint(buf *bytes.Buffer, n int64) {
+	buf.Write(binary.AppendVarint(nil, n))
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+// readLength reads a collection or string length and checks it against the remaining bytes,
+// so that a corrupted length cannot cause a huge allocation.
+func readLength(r *bytes.Reader) (int, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if length > uint64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(length), nil
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	length, err := readLength(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, length)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readFloat(r *bytes.Reader) (float64, error) {
+	var bits uint64
+	if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(bits), nil
+}
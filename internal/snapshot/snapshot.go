@@ -15,40 +15,65 @@
 package snapshot
 
 import (
-	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/echovault/echovault/internal"
 	"github.com/echovault/echovault/internal/clock"
-	"io"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/rdb"
+	"github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/pkg/types"
 	"io/fs"
 	"log"
 	"os"
 	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// This package contains the snapshot engine for standalone mode.
-// Snapshots in cluster mode will be handled using the raft package in the raft layer.
+// This package contains the snapshot engine for standalone mode. Cluster nodes also use it to export snapshots
+// when their cluster persistence is 'snapshot'. Raft snapshots are handled using the raft package in the raft layer.
+
+// ErrNothingNew is returned by TakeSnapshot when the state has not changed since the latest snapshot.
+var ErrNothingNew = errors.New("nothing new to snapshot")
+
+// retryDelay is the time to wait after a failed snapshot before a save point triggers another one.
+const retryDelay = 5 * time.Second
 
 type Manifest struct {
 	LatestSnapshotMilliseconds int64
 	LatestSnapshotHash         [16]byte
+	SnapshotHashes             map[int64][16]byte // The digest of each retained snapshot, keyed by snapshot ID
 }
 
 type Engine struct {
 	clock                     clock.Clock
-	changeCount               uint64
+	changeCount               atomic.Uint64 // The number of keys locked for writing since the last snapshot.
 	directory                 string
-	snapshotInterval          time.Duration
-	snapshotThreshold         uint64
+	savePoints                []internal.SavePoint
+	savePointsMutex           sync.RWMutex
+	inProgress                atomic.Bool
+	lastSave                  atomic.Int64 // The time of the last successful snapshot, or of startup, in unix milliseconds.
+	lastFailure               atomic.Int64 // The time of the last failed snapshot in unix milliseconds.
 	startSnapshotFunc         func()
-	finishSnapshotFunc        func()
-	getStateFunc              func() map[string]internal.KeyData
+	finishSnapshotFunc        func(size int64, err error)
+	getStateFunc              func() internal.StateCapture
 	setLatestSnapshotTimeFunc func(msec int64)
 	getLatestSnapshotTimeFunc func() int64
 	setKeyDataFunc            func(key string, data internal.KeyData)
+	getIndexesFunc            func() []search.Schema
+	restoreIndexesFunc        func(schemas []search.Schema)
+	compression               string
+	rdbFile                   string
+	retainCount               uint64
+	retainAge                 time.Duration
+	snapshotID                int64
+	keyring                   *encryption.Keyring // Encrypts new snapshots when set.
 }
 
 func WithClock(clock clock.Clock) func(engine *Engine) {
@@ -63,15 +88,11 @@ func WithDirectory(directory string) func(engine *Engine) {
 	}
 }
 
-func WithInterval(interval time.Duration) func(engine *Engine) {
+// WithSavePoints sets the save points that trigger snapshots. A snapshot is taken as soon as any save point is reached.
+// No save points disables automatic snapshots.
+func WithSavePoints(points []internal.SavePoint) func(engine *Engine) {
 	return func(engine *Engine) {
-		engine.snapshotInterval = interval
-	}
-}
-
-func WithThreshold(threshold uint64) func(engine *Engine) {
-	return func(engine *Engine) {
-		engine.snapshotThreshold = threshold
+		engine.savePoints = points
 	}
 }
 
@@ -81,13 +102,16 @@ func WithStartSnapshotFunc(f func()) func(engine *Engine) {
 	}
 }
 
-func WithFinishSnapshotFunc(f func()) func(engine *Engine) {
+// WithFinishSnapshotFunc sets the function called when a snapshot finishes, with the number of bytes written
+// and the error of the snapshot. The error is ErrNothingNew if the state did not change since the latest snapshot.
+func WithFinishSnapshotFunc(f func(size int64, err error)) func(engine *Engine) {
 	return func(engine *Engine) {
 		engine.finishSnapshotFunc = f
 	}
 }
 
-func WithGetStateFunc(f func() map[string]internal.KeyData) func(engine *Engine) {
+// WithGetStateFunc sets the function that starts a capture of the state for a snapshot.
+func WithGetStateFunc(f func() internal.StateCapture) func(engine *Engine) {
 	return func(engine *Engine) {
 		engine.getStateFunc = f
 	}
@@ -111,63 +135,174 @@ func WithSetKeyDataFunc(f func(key string, data internal.KeyData)) func(engine *
 	}
 }
 
+func WithGetIndexesFunc(f func() []search.Schema) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.getIndexesFunc = f
+	}
+}
+
+func WithRestoreIndexesFunc(f func(schemas []search.Schema)) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.restoreIndexesFunc = f
+	}
+}
+
+// WithCompression sets the compression of the snapshot files. The options are "none" and "gzip".
+func WithCompression(compression string) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.compression = compression
+	}
+}
+
+// WithRDBFile makes Restore load the given Redis RDB file instead of the latest snapshot.
+func WithRDBFile(path string) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.rdbFile = path
+	}
+}
+
+// WithRetainCount sets the number of most recent snapshots to keep. 0 keeps all snapshots.
+func WithRetainCount(count uint64) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.retainCount = count
+	}
+}
+
+// WithRetainAge sets the maximum age of the snapshots to keep. 0 keeps snapshots regardless of age.
+func WithRetainAge(age time.Duration) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.retainAge = age
+	}
+}
+
+// WithSnapshotID makes Restore load the snapshot with the given ID instead of the latest snapshot.
+func WithSnapshotID(id int64) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.snapshotID = id
+	}
+}
+
+func WithKeyring(keyring *encryption.Keyring) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.keyring = keyring
+	}
+}
+
+// emptyState is the state of an engine without a GetStateFunc.
+type emptyState struct{}
+
+func (emptyState) Keys() []string                      { return nil }
+func (emptyState) Get(string) (internal.KeyData, bool) { return internal.KeyData{}, false }
+func (emptyState) Release()                            {}
+
 func NewSnapshotEngine(options ...func(engine *Engine)) *Engine {
 	engine := &Engine{
 		clock:              clock.NewClock(),
-		changeCount:        0,
 		directory:          "",
-		snapshotInterval:   5 * time.Minute,
-		snapshotThreshold:  1000,
 		startSnapshotFunc:  func() {},
-		finishSnapshotFunc: func() {},
-		getStateFunc: func() map[string]internal.KeyData {
-			return map[string]internal.KeyData{}
+		finishSnapshotFunc: func(size int64, err error) {},
+		getStateFunc: func() internal.StateCapture {
+			return emptyState{}
 		},
 		setLatestSnapshotTimeFunc: func(msec int64) {},
 		getLatestSnapshotTimeFunc: func() int64 {
 			return 0
 		},
-		setKeyDataFunc: func(key string, data internal.KeyData) {},
+		setKeyDataFunc:     func(key string, data internal.KeyData) {},
+		getIndexesFunc:     func() []search.Schema { return nil },
+		restoreIndexesFunc: func(schemas []search.Schema) {},
+		compression:        CompressionNone,
 	}
 
 	for _, option := range options {
 		option(engine)
 	}
 
-	if engine.snapshotInterval != 0 {
-		go func() {
-			for {
-				<-engine.clock.After(engine.snapshotInterval)
-				if engine.changeCount == engine.snapshotThreshold {
-					if err := engine.TakeSnapshot(); err != nil {
-						log.Println(err)
-					}
-				}
+	engine.lastSave.Store(engine.clock.Now().UnixMilli())
+
+	// Check the save points every second, so that they can be changed while the engine runs.
+	go func() {
+		for {
+			<-engine.clock.After(time.Second)
+			if !engine.savePointReached() {
+				continue
 			}
-		}()
-	}
+			if err := engine.TakeSnapshot(); err != nil && !errors.Is(err, ErrNothingNew) {
+				log.Println(err)
+			}
+		}
+	}()
 
 	return engine
 }
 
-func (engine *Engine) TakeSnapshot() error {
-	engine.startSnapshotFunc()
-	defer engine.finishSnapshotFunc()
+// SetSavePoints replaces the save points that trigger snapshots.
+func (engine *Engine) SetSavePoints(points []internal.SavePoint) {
+	engine.savePointsMutex.Lock()
+	defer engine.savePointsMutex.Unlock()
+	engine.savePoints = points
+}
+
+// SavePoints returns the save points that trigger snapshots.
+func (engine *Engine) SavePoints() []internal.SavePoint {
+	engine.savePointsMutex.RLock()
+	defer engine.savePointsMutex.RUnlock()
+	return slices.Clone(engine.savePoints)
+}
+
+// savePointReached reports whether a save point has been reached since the last snapshot.
+// After a failed snapshot, no save point is reached until retryDelay has passed.
+func (engine *Engine) savePointReached() bool {
+	if engine.inProgress.Load() {
+		return false
+	}
+	now := engine.clock.Now().UnixMilli()
+	if now-engine.lastFailure.Load() < retryDelay.Milliseconds() {
+		return false
+	}
+	changes := engine.changeCount.Load()
+	elapsed := now - engine.lastSave.Load()
+	for _, point := range engine.SavePoints() {
+		if changes >= point.Changes && elapsed >= int64(point.Seconds)*1000 {
+			return true
+		}
+	}
+	return false
+}
+
+func (engine *Engine) TakeSnapshot() (err error) {
+	if !engine.inProgress.CompareAndSwap(false, true) {
+		return errors.New("snapshot already in progress")
+	}
+	defer engine.inProgress.Store(false)
 
 	// Extract current time
 	now := engine.clock.Now()
 	msec := now.UnixNano() / int64(time.Millisecond)
 
+	var size int64
+	changes := engine.changeCount.Load()
+	engine.startSnapshotFunc()
+	defer func() {
+		if err != nil && !errors.Is(err, ErrNothingNew) {
+			engine.lastFailure.Store(msec)
+		} else {
+			// Only the changes counted before the snapshot started are in the snapshot.
+			// The changes made while it was taken count towards the next one.
+			engine.changeCount.Add(^(changes - 1))
+			engine.lastSave.Store(msec)
+		}
+		engine.finishSnapshotFunc(size, err)
+	}()
+
 	// Update manifest file to indicate the latest snapshot.
 	// If manifest file does not exist, create it.
 	// Manifest object will contain the following information:
-	// 	1. Hash of the snapshot contents.
+	// 	1. Digest of the snapshot contents.
 	// 	2. Unix time of the latest snapshot taken.
 	// The information above will be used to determine whether a snapshot should be taken.
-	// If the hash of the current state equals the hash in the manifest file, skip the snapshot.
-	// Otherwise, take the snapshot and update the latest snapshot timestamp and hash in the manifest file.
-
-	var firstSnapshot bool // Tracks whether the snapshot being attempted is the first one
+	// If the digest of the current state equals the digest in the manifest file, skip the snapshot.
+	// Otherwise, keep the snapshot and update the latest snapshot timestamp and digest in the manifest file.
 
 	dirname := path.Join(engine.directory, "snapshots")
 	if err := os.MkdirAll(dirname, os.ModePerm); err != nil {
@@ -175,85 +310,87 @@ func (engine *Engine) TakeSnapshot() error {
 		return err
 	}
 
-	// Open manifest file
-	var mf *os.File
-	mf, err := os.Open(path.Join(dirname, "manifest.bin"))
-	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			// Create file if it does not exist
-			mf, err = os.Create(path.Join(dirname, "manifest.bin"))
-			if err != nil {
-				log.Println(err)
-				return err
-			}
-			firstSnapshot = true
-		} else {
+	manifest := new(Manifest)
+	md, err := os.ReadFile(path.Join(dirname, "manifest.bin"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Println(err)
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(md, manifest); err != nil {
 			log.Println(err)
 			return err
 		}
 	}
 
-	md, err := io.ReadAll(mf)
+	// Stream the current state into a temporary file so that the serialized state is never held in memory.
+	f, err := os.CreateTemp(dirname, "state-*.tmp")
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	if err := mf.Close(); err != nil {
-		log.Println(err)
-		return err
-	}
-
-	manifest := new(Manifest)
+	defer func() {
+		// The temporary file no longer exists once it has been moved into the snapshot directory.
+		_ = os.Remove(f.Name())
+	}()
 
-	if !firstSnapshot {
-		if err = json.Unmarshal(md, manifest); err != nil {
-			log.Println(err)
-			return err
-		}
+	digest, err := engine.writeSnapshot(f, msec)
+	if info, statErr := f.Stat(); statErr == nil {
+		size = info.Size()
 	}
-
-	// Get current state
-	snapshotObject := internal.SnapshotObject{
-		State:                      internal.FilterExpiredKeys(engine.getStateFunc()),
-		LatestSnapshotMilliseconds: engine.getLatestSnapshotTimeFunc(),
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
 	}
-	out, err := json.Marshal(snapshotObject)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	snapshotHash := md5.Sum(out)
-	if snapshotHash == manifest.LatestSnapshotHash {
-		return errors.New("nothing new to snapshot")
+	if digest == manifest.LatestSnapshotHash {
+		return ErrNothingNew
 	}
 
-	// Update the snapshotObject
-	snapshotObject.LatestSnapshotMilliseconds = msec
-	// Marshal the updated snapshotObject
-	out, err = json.Marshal(snapshotObject)
-	if err != nil {
+	// Move the snapshot file into the snapshot directory
+	snapshotDir := path.Join(dirname, fmt.Sprintf("%d", msec))
+	if err = os.MkdirAll(snapshotDir, os.ModePerm); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path.Join(snapshotDir, "state.bin")); err != nil {
 		log.Println(err)
 		return err
 	}
 
-	// os.Create will replace the old manifest file
-	mf, err = os.Create(path.Join(dirname, "manifest.bin"))
+	ids, err := engine.snapshotIDs()
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	expired := engine.expiredSnapshots(ids, msec)
 
 	// Write the latest manifest data
+	hashes := make(map[int64][16]byte)
+	for id, hash := range manifest.SnapshotHashes {
+		if slices.Contains(ids, id) && !slices.Contains(expired, id) {
+			hashes[id] = hash
+		}
+	}
+	hashes[msec] = digest
 	manifest = &Manifest{
-		LatestSnapshotHash:         md5.Sum(out),
+		LatestSnapshotHash:         digest,
 		LatestSnapshotMilliseconds: msec,
+		SnapshotHashes:             hashes,
 	}
 	mo, err := json.Marshal(manifest)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	// os.Create will replace the old manifest file
+	mf, err := os.Create(path.Join(dirname, "manifest.bin"))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	if _, err = mf.Write(mo); err != nil {
 		log.Println(err)
 		return err
@@ -266,103 +403,241 @@ func (engine *Engine) TakeSnapshot() error {
 		return err
 	}
 
-	// Create snapshot directory
-	dirname = path.Join(engine.directory, "snapshots", fmt.Sprintf("%d", msec))
-	if err := os.MkdirAll(dirname, os.ModePerm); err != nil {
-		return err
+	// Delete the snapshots that fall outside the retention policy only after the manifest no longer references them.
+	for _, id := range expired {
+		if err = os.RemoveAll(path.Join(dirname, strconv.FormatInt(id, 10))); err != nil {
+			log.Println(err)
+		}
 	}
 
-	// Create snapshot file
-	f, err := os.OpenFile(path.Join(dirname, "state.bin"), os.O_WRONLY|os.O_CREATE, os.ModePerm)
+	// Set the latest snapshot in unix milliseconds
+	engine.setLatestSnapshotTimeFunc(msec)
+
+	return nil
+}
+
+// writeSnapshot writes the current state to f in the binary snapshot format and
+// returns the digest of the state.
+func (engine *Engine) writeSnapshot(f *os.File, msec int64) ([16]byte, error) {
+	w, err := engine.keyring.NewWriter(f)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	encoder, err := NewEncoder(w, engine.compression)
 	if err != nil {
+		return [16]byte{}, err
+	}
+	if err = encoder.WriteMeta(msec); err != nil {
+		return [16]byte{}, err
+	}
+	if err = encoder.WriteIndexes(engine.getIndexesFunc()); err != nil {
+		return [16]byte{}, err
+	}
+	state := engine.getStateFunc()
+	defer state.Release()
+	if err = encoder.WriteCapture(state); err != nil {
+		return [16]byte{}, err
+	}
+	if err = encoder.Close(); err != nil {
+		return [16]byte{}, err
+	}
+	if err = w.Close(); err != nil {
+		return [16]byte{}, err
+	}
+	if err = f.Sync(); err != nil {
 		log.Println(err)
+	}
+	return encoder.Digest(), nil
+}
+
+func (engine *Engine) Restore() error {
+	if engine.rdbFile != "" {
+		return engine.restoreRDB()
+	}
+
+	if engine.snapshotID != 0 {
+		return engine.RestoreSnapshot(engine.snapshotID)
+	}
+
+	manifest, err := engine.readManifest()
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return errors.New("no snapshot manifest, skipping snapshot restore")
+	}
+	if err != nil {
+		return err
+	}
+
+	if manifest.LatestSnapshotMilliseconds == 0 {
+		return errors.New("no snapshot to restore")
+	}
+
+	return engine.RestoreSnapshot(manifest.LatestSnapshotMilliseconds)
+}
+
+// RestoreSnapshot loads the snapshot with the given ID. The keys in the snapshot are added to the current state.
+func (engine *Engine) RestoreSnapshot(id int64) error {
+	sf, err := engine.openSnapshot(id)
+	if err != nil {
 		return err
 	}
 	defer func() {
-		if err := f.Close(); err != nil {
+		if err := sf.Close(); err != nil {
 			log.Println(err)
 		}
 	}()
 
-	// Write state to file
-	if _, err = f.Write(out); err != nil {
+	r, err := engine.keyring.NewReader(sf)
+	if err != nil {
 		return err
 	}
-	if err = f.Sync(); err != nil {
-		log.Println(err)
+
+	// Index definitions are restored before the keys so that the indexes are rebuilt as the keys are loaded.
+	msec, err := Load(r, engine.restoreIndexesFunc, engine.setKeyDataFunc)
+	if err != nil {
+		return err
 	}
 
-	// Set the latest snapshot in unix milliseconds
 	engine.setLatestSnapshotTimeFunc(msec)
 
-	// Reset the change count
-	engine.resetChangeCount()
+	log.Printf("successfully restored snapshot %d\n", id)
 
 	return nil
 }
 
-func (engine *Engine) Restore() error {
-	mf, err := os.Open(path.Join(engine.directory, "snapshots", "manifest.bin"))
-	if err != nil && errors.Is(err, fs.ErrNotExist) {
-		return errors.New("no snapshot manifest, skipping snapshot restore")
+// VerifySnapshot reads the snapshot with the given ID without loading it, and returns an error if
+// the snapshot does not exist or is corrupted.
+func (engine *Engine) VerifySnapshot(id int64) error {
+	sf, err := engine.openSnapshot(id)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		if err := sf.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+	r, err := engine.keyring.NewReader(sf)
 	if err != nil {
 		return err
 	}
+	_, err = Load(r, func(schemas []search.Schema) {}, func(key string, data internal.KeyData) {})
+	return err
+}
 
-	manifest := new(Manifest)
-
-	md, err := io.ReadAll(mf)
+// ListSnapshots returns the snapshots in the snapshot directory, oldest first.
+func (engine *Engine) ListSnapshots() ([]types.SnapshotInfo, error) {
+	manifest, err := engine.readManifest()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ids, err := engine.snapshotIDs()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	snapshots := make([]types.SnapshotInfo, 0, len(ids))
+	for _, id := range ids {
+		stat, err := os.Stat(path.Join(engine.directory, "snapshots", strconv.FormatInt(id, 10), "state.bin"))
+		if err != nil {
+			return nil, err
+		}
+		info := types.SnapshotInfo{ID: id, Size: stat.Size()}
+		if hash, ok := manifest.SnapshotHashes[id]; ok {
+			info.Hash = hex.EncodeToString(hash[:])
+		} else if id == manifest.LatestSnapshotMilliseconds {
+			info.Hash = hex.EncodeToString(manifest.LatestSnapshotHash[:])
+		}
+		snapshots = append(snapshots, info)
+	}
+	return snapshots, nil
+}
+
+func (engine *Engine) readManifest() (*Manifest, error) {
+	manifest := new(Manifest)
+	md, err := os.ReadFile(path.Join(engine.directory, "snapshots", "manifest.bin"))
+	if err != nil {
+		return manifest, err
+	}
 	if err = json.Unmarshal(md, manifest); err != nil {
-		return err
+		return manifest, err
 	}
+	return manifest, nil
+}
 
-	if manifest.LatestSnapshotMilliseconds == 0 {
-		return errors.New("no snapshot to restore")
+func (engine *Engine) openSnapshot(id int64) (*os.File, error) {
+	sf, err := os.Open(path.Join(engine.directory, "snapshots", strconv.FormatInt(id, 10), "state.bin"))
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("snapshot file %d/state.bin not found, skipping snapshot", id)
 	}
+	return sf, err
+}
 
-	sf, err := os.Open(path.Join(
-		engine.directory,
-		"snapshots",
-		fmt.Sprintf("%d", manifest.LatestSnapshotMilliseconds),
-		"state.bin"))
+// snapshotIDs returns the IDs of the snapshots in the snapshot directory in ascending order.
+func (engine *Engine) snapshotIDs() ([]int64, error) {
+	entries, err := os.ReadDir(path.Join(engine.directory, "snapshots"))
 	if err != nil && errors.Is(err, fs.ErrNotExist) {
-		return fmt.Errorf("snapshot file %d/state.bin not found, skipping snapshot", manifest.LatestSnapshotMilliseconds)
+		return nil, nil
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	sd, err := io.ReadAll(sf)
-	if err != nil {
-		return nil
+	var ids []int64
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		id, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, err = os.Stat(path.Join(engine.directory, "snapshots", entry.Name(), "state.bin")); err != nil {
+			continue
+		}
+		ids = append(ids, id)
 	}
+	slices.Sort(ids)
+	return ids, nil
+}
 
-	snapshotObject := new(internal.SnapshotObject)
+// expiredSnapshots returns the snapshots that fall outside the retention policy once the snapshot
+// with the latest ID has been taken. The latest snapshot is always retained.
+func (engine *Engine) expiredSnapshots(ids []int64, latest int64) []int64 {
+	var expired []int64
+	for i, id := range ids {
+		if id == latest {
+			continue
+		}
+		if engine.retainCount > 0 && uint64(len(ids)-i) > engine.retainCount {
+			expired = append(expired, id)
+			continue
+		}
+		if engine.retainAge > 0 && time.UnixMilli(latest).Sub(time.UnixMilli(id)) > engine.retainAge {
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
 
-	if err = json.Unmarshal(sd, snapshotObject); err != nil {
+func (engine *Engine) restoreRDB() error {
+	f, err := os.Open(engine.rdbFile)
+	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
 
-	engine.setLatestSnapshotTimeFunc(snapshotObject.LatestSnapshotMilliseconds)
-
-	for key, data := range internal.FilterExpiredKeys(snapshotObject.State) {
-		engine.setKeyDataFunc(key, data)
+	if err = rdb.Load(f, engine.clock.Now(), engine.setKeyDataFunc); err != nil {
+		return err
 	}
 
-	log.Println("successfully restored latest snapshot")
+	log.Printf("successfully restored RDB file %s\n", engine.rdbFile)
 
 	return nil
 }
 
 func (engine *Engine) IncrementChangeCount() {
-	engine.changeCount += 1
-}
-
-func (engine *Engine) resetChangeCount() {
-	engine.changeCount = 0
+	engine.changeCount.Add(1)
 }
@@ -18,6 +18,7 @@ import (
 	"cmp"
 	"errors"
 	"github.com/echovault/echovault/internal"
+	"maps"
 	"math"
 	"math/rand"
 	"slices"
@@ -59,6 +60,13 @@ func NewSortedSet(members []MemberParam) *SortedSet {
 	return s
 }
 
+// Clone returns a copy of the sorted set that is not affected by changes to the sorted set.
+func (set *SortedSet) Clone() interface{} {
+	return &SortedSet{
+		members: maps.Clone(set.members),
+	}
+}
+
 func (set *SortedSet) Contains(m Value) bool {
 	return set.members[m].Exists
 }
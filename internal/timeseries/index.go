@@ -0,0 +1,109 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timeseries
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Index tracks the labels of every time series in the keyspace so that series can be selected by label.
+// The keyspace notifies the index whenever a key is written or deleted.
+type Index struct {
+	mut    sync.RWMutex
+	labels map[string]map[string]string
+}
+
+func NewIndex() *Index {
+	return &Index{
+		mut:    sync.RWMutex{},
+		labels: make(map[string]map[string]string),
+	}
+}
+
+// OnSet is called by the keyspace after the value at key has been written.
+func (index *Index) OnSet(key string, value interface{}) {
+	index.mut.Lock()
+	defer index.mut.Unlock()
+	if series, ok := value.(*TimeSeries); ok {
+		index.labels[key] = series.Labels()
+		return
+	}
+	delete(index.labels, key)
+}
+
+// OnDelete is called by the keyspace after the key has been deleted.
+func (index *Index) OnDelete(key string) {
+	index.mut.Lock()
+	defer index.mut.Unlock()
+	delete(index.labels, key)
+}
+
+// Filter is a label matcher in one of the forms:
+// label=value, label!=value, label= (label is absent), label!= (label is present),
+// label=(value1,value2,...) and label!=(value1,value2,...).
+type Filter struct {
+	Label  string
+	Values []string
+	Negate bool
+}
+
+func ParseFilter(filter string) (Filter, error) {
+	var f Filter
+	var values string
+	if i := strings.Index(filter, "!="); i > 0 {
+		f.Label, values, f.Negate = filter[:i], filter[i+2:], true
+	} else if i = strings.Index(filter, "="); i > 0 {
+		f.Label, values = filter[:i], filter[i+1:]
+	} else {
+		return Filter{}, fmt.Errorf("invalid filter %s", filter)
+	}
+	if strings.HasPrefix(values, "(") && strings.HasSuffix(values, ")") {
+		f.Values = strings.Split(values[1:len(values)-1], ",")
+	} else if values != "" {
+		f.Values = []string{values}
+	}
+	return f, nil
+}
+
+func (f Filter) matches(labels map[string]string) bool {
+	value, ok := labels[f.Label]
+	if len(f.Values) == 0 {
+		// label= matches series without the label and label!= matches series with it.
+		return ok == f.Negate
+	}
+	return ok && slices.Contains(f.Values, value) != f.Negate
+}
+
+// Match returns the keys of the series whose labels match all the filters, in lexicographical order.
+// At least one of the filters must be of the form label=value or label=(value1,value2,...).
+func (index *Index) Match(filters []Filter) ([]string, error) {
+	if !slices.ContainsFunc(filters, func(f Filter) bool { return !f.Negate && len(f.Values) > 0 }) {
+		return nil, errors.New("at least one filter of the form label=value is required")
+	}
+	index.mut.RLock()
+	defer index.mut.RUnlock()
+	var keys []string
+	for key, labels := range index.labels {
+		if !slices.ContainsFunc(filters, func(f Filter) bool { return !f.matches(labels) }) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
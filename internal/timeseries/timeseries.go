@@ -0,0 +1,443 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timeseries
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+const TypeName = "timeseries"
+
+// Duplicate policies decide what happens when a sample is added at a timestamp that already has a sample.
+const (
+	DuplicateBlock = "BLOCK" // Reject the new sample.
+	DuplicateFirst = "FIRST" // Keep the existing sample.
+	DuplicateLast  = "LAST"  // Replace the existing sample.
+	DuplicateMin   = "MIN"   // Keep the lower of the two values.
+	DuplicateMax   = "MAX"   // Keep the higher of the two values.
+	DuplicateSum   = "SUM"   // Add the new value to the existing one.
+)
+
+const (
+	AggregationAvg   = "AVG"
+	AggregationMin   = "MIN"
+	AggregationMax   = "MAX"
+	AggregationSum   = "SUM"
+	AggregationCount = "COUNT"
+)
+
+var (
+	ErrDuplicate = errors.New("a sample with the same timestamp already exists and the duplicate policy is BLOCK")
+	ErrTooOld    = errors.New("timestamp is older than the retention period")
+)
+
+func init() {
+	internal.RegisterValueType(TypeName, func(data []byte) (interface{}, error) {
+		series := &TimeSeries{}
+		if err := series.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		return series, nil
+	})
+}
+
+// ParseDuplicatePolicy returns the normalised duplicate policy or an error if the policy is unknown.
+func ParseDuplicatePolicy(policy string) (string, error) {
+	policy = strings.ToUpper(policy)
+	if !slices.Contains([]string{
+		DuplicateBlock, DuplicateFirst, DuplicateLast, DuplicateMin, DuplicateMax, DuplicateSum,
+	}, policy) {
+		return "", fmt.Errorf("unknown duplicate policy %s", policy)
+	}
+	return policy, nil
+}
+
+// ParseAggregation returns the normalised aggregation type or an error if the type is unknown.
+func ParseAggregation(aggregation string) (string, error) {
+	aggregation = strings.ToUpper(aggregation)
+	if !slices.Contains([]string{
+		AggregationAvg, AggregationMin, AggregationMax, AggregationSum, AggregationCount,
+	}, aggregation) {
+		return "", fmt.Errorf("unknown aggregation type %s", aggregation)
+	}
+	return aggregation, nil
+}
+
+type Sample struct {
+	Timestamp int64
+	Value     float64
+}
+
+// Rule is a compaction rule that downsamples the series into the series at DestKey.
+type Rule struct {
+	DestKey        string
+	Aggregation    string
+	BucketDuration int64
+	// The start of the bucket that is currently being filled. The bucket is compacted into the
+	// destination once a sample for a later bucket arrives.
+	CurrentBucket int64
+	HasBucket     bool
+}
+
+// Compaction is a sample that must be written to the destination series of a compaction rule.
+type Compaction struct {
+	DestKey string
+	Sample  Sample
+}
+
+type TimeSeries struct {
+	samples         []Sample // Ordered by timestamp.
+	retention       int64    // Maximum age of samples in milliseconds relative to the latest sample. 0 means no limit.
+	labels          map[string]string
+	duplicatePolicy string
+	rules           []Rule
+	sourceKey       string // Key of the series that compacts into this series, if any.
+}
+
+func NewTimeSeries(retention int64, labels map[string]string, duplicatePolicy string) *TimeSeries {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	if duplicatePolicy == "" {
+		duplicatePolicy = DuplicateBlock
+	}
+	return &TimeSeries{
+		retention:       retention,
+		labels:          labels,
+		duplicatePolicy: duplicatePolicy,
+	}
+}
+
+// bucketStart returns the start of the bucket that the timestamp falls into.
+// Buckets are aligned to the unix epoch.
+func bucketStart(timestamp int64, duration int64) int64 {
+	start := timestamp - timestamp%duration
+	if timestamp < 0 && timestamp%duration != 0 {
+		start -= duration
+	}
+	return start
+}
+
+// Add adds a sample to the series. The policy overrides the duplicate policy of the series when it's not empty.
+// It returns the samples that must be written to the destinations of the compaction rules as a result.
+func (ts *TimeSeries) Add(timestamp int64, value float64, policy string) ([]Compaction, error) {
+	if ts.retention > 0 && len(ts.samples) > 0 && timestamp < ts.samples[len(ts.samples)-1].Timestamp-ts.retention {
+		return nil, ErrTooOld
+	}
+	if policy == "" {
+		policy = ts.duplicatePolicy
+	}
+
+	i, found := slices.BinarySearchFunc(ts.samples, timestamp, func(sample Sample, timestamp int64) int {
+		return compare(sample.Timestamp, timestamp)
+	})
+	if found {
+		existing := ts.samples[i].Value
+		switch policy {
+		case DuplicateBlock:
+			return nil, ErrDuplicate
+		case DuplicateFirst:
+			value = existing
+		case DuplicateMin:
+			value = math.Min(existing, value)
+		case DuplicateMax:
+			value = math.Max(existing, value)
+		case DuplicateSum:
+			value = existing + value
+		}
+		ts.samples[i].Value = value
+	} else {
+		ts.samples = slices.Insert(ts.samples, i, Sample{Timestamp: timestamp, Value: value})
+	}
+
+	compactions := ts.compact(timestamp)
+	ts.trim()
+	return compactions, nil
+}
+
+// compact advances the compaction rules after a sample has been written at timestamp.
+func (ts *TimeSeries) compact(timestamp int64) []Compaction {
+	var compactions []Compaction
+	for i := range ts.rules {
+		rule := &ts.rules[i]
+		bucket := bucketStart(timestamp, rule.BucketDuration)
+		switch {
+		case !rule.HasBucket:
+			rule.CurrentBucket, rule.HasBucket = bucket, true
+		case bucket > rule.CurrentBucket:
+			// The current bucket is complete.
+			if sample, ok := ts.aggregate(rule.CurrentBucket, rule.BucketDuration, rule.Aggregation); ok {
+				compactions = append(compactions, Compaction{DestKey: rule.DestKey, Sample: sample})
+			}
+			rule.CurrentBucket = bucket
+		case bucket < rule.CurrentBucket:
+			// A late sample changed a bucket that was already compacted, so compact it again.
+			if sample, ok := ts.aggregate(bucket, rule.BucketDuration, rule.Aggregation); ok {
+				compactions = append(compactions, Compaction{DestKey: rule.DestKey, Sample: sample})
+			}
+		}
+	}
+	return compactions
+}
+
+// aggregate returns the aggregated sample of the bucket that starts at start.
+func (ts *TimeSeries) aggregate(start int64, duration int64, aggregation string) (Sample, bool) {
+	samples := ts.Range(start, start+duration-1, "", 0, 0)
+	if len(samples) == 0 {
+		return Sample{}, false
+	}
+	return Sample{Timestamp: start, Value: aggregate(samples, aggregation)}, true
+}
+
+// trim removes the samples that are older than the retention period.
+func (ts *TimeSeries) trim() {
+	if ts.retention <= 0 || len(ts.samples) == 0 {
+		return
+	}
+	oldest := ts.samples[len(ts.samples)-1].Timestamp - ts.retention
+	i := sort.Search(len(ts.samples), func(i int) bool {
+		return ts.samples[i].Timestamp >= oldest
+	})
+	ts.samples = slices.Delete(ts.samples, 0, i)
+}
+
+// Range returns the samples with timestamps between from and to (both inclusive).
+// If aggregation is not empty, the samples are grouped into buckets of bucketDuration milliseconds
+// and each bucket is reduced to a single sample timestamped with the start of the bucket.
+// If count is greater than 0, at most count samples are returned.
+func (ts *TimeSeries) Range(from, to int64, aggregation string, bucketDuration int64, count int) []Sample {
+	start := sort.Search(len(ts.samples), func(i int) bool {
+		return ts.samples[i].Timestamp >= from
+	})
+	end := sort.Search(len(ts.samples), func(i int) bool {
+		return ts.samples[i].Timestamp > to
+	})
+	if start >= end {
+		return []Sample{}
+	}
+	samples := ts.samples[start:end]
+
+	var res []Sample
+	if aggregation == "" {
+		res = slices.Clone(samples)
+	} else {
+		for len(samples) > 0 {
+			bucket := bucketStart(samples[0].Timestamp, bucketDuration)
+			n := sort.Search(len(samples), func(i int) bool {
+				return samples[i].Timestamp >= bucket+bucketDuration
+			})
+			res = append(res, Sample{Timestamp: bucket, Value: aggregate(samples[:n], aggregation)})
+			samples = samples[n:]
+		}
+	}
+
+	if count > 0 && len(res) > count {
+		res = res[:count]
+	}
+	return res
+}
+
+func aggregate(samples []Sample, aggregation string) float64 {
+	switch aggregation {
+	case AggregationMin:
+		return slices.MinFunc(samples, func(a, b Sample) int { return compare(a.Value, b.Value) }).Value
+	case AggregationMax:
+		return slices.MaxFunc(samples, func(a, b Sample) int { return compare(a.Value, b.Value) }).Value
+	case AggregationCount:
+		return float64(len(samples))
+	}
+	sum := 0.0
+	for _, sample := range samples {
+		sum += sample.Value
+	}
+	if aggregation == AggregationAvg {
+		return sum / float64(len(samples))
+	}
+	return sum
+}
+
+func compare[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Latest returns the sample with the highest timestamp.
+func (ts *TimeSeries) Latest() (Sample, bool) {
+	if len(ts.samples) == 0 {
+		return Sample{}, false
+	}
+	return ts.samples[len(ts.samples)-1], true
+}
+
+// First returns the sample with the lowest timestamp.
+func (ts *TimeSeries) First() (Sample, bool) {
+	if len(ts.samples) == 0 {
+		return Sample{}, false
+	}
+	return ts.samples[0], true
+}
+
+func (ts *TimeSeries) Len() int {
+	return len(ts.samples)
+}
+
+func (ts *TimeSeries) Retention() int64 {
+	return ts.retention
+}
+
+func (ts *TimeSeries) DuplicatePolicy() string {
+	return ts.duplicatePolicy
+}
+
+// Labels returns a copy of the labels of the series.
+func (ts *TimeSeries) Labels() map[string]string {
+	return maps.Clone(ts.labels)
+}
+
+// SetLabels replaces the labels of the series.
+func (ts *TimeSeries) SetLabels(labels map[string]string) {
+	ts.labels = maps.Clone(labels)
+}
+
+// Rules returns a copy of the compaction rules of the series.
+func (ts *TimeSeries) Rules() []Rule {
+	return slices.Clone(ts.rules)
+}
+
+// AddRule adds a compaction rule that downsamples this series into the series at destKey.
+func (ts *TimeSeries) AddRule(destKey string, aggregation string, bucketDuration int64) error {
+	if slices.ContainsFunc(ts.rules, func(rule Rule) bool { return rule.DestKey == destKey }) {
+		return fmt.Errorf("a compaction rule into %s already exists", destKey)
+	}
+	ts.rules = append(ts.rules, Rule{DestKey: destKey, Aggregation: aggregation, BucketDuration: bucketDuration})
+	return nil
+}
+
+// DeleteRule removes the compaction rule into destKey. It returns false if there is no such rule.
+func (ts *TimeSeries) DeleteRule(destKey string) bool {
+	n := len(ts.rules)
+	ts.rules = slices.DeleteFunc(ts.rules, func(rule Rule) bool { return rule.DestKey == destKey })
+	return len(ts.rules) < n
+}
+
+func (ts *TimeSeries) SourceKey() string {
+	return ts.sourceKey
+}
+
+func (ts *TimeSeries) SetSourceKey(key string) {
+	ts.sourceKey = key
+}
+
+// Size returns the approximate memory used by the series in bytes.
+func (ts *TimeSeries) Size() int {
+	size := int(unsafe.Sizeof(*ts)) + cap(ts.samples)*int(unsafe.Sizeof(Sample{}))
+	for k, v := range ts.labels {
+		size += len(k) + len(v)
+	}
+	for _, rule := range ts.rules {
+		size += int(unsafe.Sizeof(rule)) + len(rule.DestKey)
+	}
+	return size + len(ts.sourceKey)
+}
+
+func (ts *TimeSeries) TypeName() string {
+	return TypeName
+}
+
+type encodedTimeSeries struct {
+	Timestamps      []int64
+	Values          []float64
+	Retention       int64
+	Labels          map[string]string
+	DuplicatePolicy string
+	Rules           []Rule
+	SourceKey       string
+}
+
+// check returns an error if the series breaks an invariant that the commands rely on.
+func (encoded encodedTimeSeries) check() error {
+	errCorrupt := errors.New("corrupt time series encoding")
+	if len(encoded.Timestamps) != len(encoded.Values) || encoded.Retention < 0 {
+		return errCorrupt
+	}
+	for i := 1; i < len(encoded.Timestamps); i++ {
+		if encoded.Timestamps[i] <= encoded.Timestamps[i-1] {
+			return errCorrupt
+		}
+	}
+	if encoded.DuplicatePolicy != "" {
+		if policy, err := ParseDuplicatePolicy(encoded.DuplicatePolicy); err != nil || policy != encoded.DuplicatePolicy {
+			return errCorrupt
+		}
+	}
+	for _, rule := range encoded.Rules {
+		if aggregation, err := ParseAggregation(rule.Aggregation); err != nil || aggregation != rule.Aggregation ||
+			rule.DestKey == "" || rule.BucketDuration <= 0 {
+			return errCorrupt
+		}
+	}
+	return nil
+}
+
+func (ts *TimeSeries) MarshalBinary() ([]byte, error) {
+	encoded := encodedTimeSeries{
+		Timestamps:      make([]int64, len(ts.samples)),
+		Values:          make([]float64, len(ts.samples)),
+		Retention:       ts.retention,
+		Labels:          ts.labels,
+		DuplicatePolicy: ts.duplicatePolicy,
+		Rules:           ts.rules,
+		SourceKey:       ts.sourceKey,
+	}
+	for i, sample := range ts.samples {
+		encoded.Timestamps[i] = sample.Timestamp
+		encoded.Values[i] = sample.Value
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalBinary decodes a series encoded by MarshalBinary. The data may come from a client with RESTORE, so
+// the series is checked before it is used.
+func (ts *TimeSeries) UnmarshalBinary(data []byte) error {
+	var encoded encodedTimeSeries
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	if err := encoded.check(); err != nil {
+		return err
+	}
+	*ts = *NewTimeSeries(encoded.Retention, encoded.Labels, encoded.DuplicatePolicy)
+	ts.rules = encoded.Rules
+	ts.sourceKey = encoded.SourceKey
+	ts.samples = make([]Sample, len(encoded.Timestamps))
+	for i := range encoded.Timestamps {
+		ts.samples[i] = Sample{Timestamp: encoded.Timestamps[i], Value: encoded.Values[i]}
+	}
+	return nil
+}
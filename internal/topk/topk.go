@@ -0,0 +1,270 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/echovault/echovault/internal"
+	"hash/fnv"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"unsafe"
+)
+
+const TypeName = "topk"
+
+const (
+	DefaultWidth = 8
+	DefaultDepth = 7
+	DefaultDecay = 0.9
+)
+
+var errInvalidEncoding = errors.New("invalid top-k encoding")
+
+func init() {
+	internal.RegisterValueType(TypeName, func(data []byte) (interface{}, error) {
+		topK := &TopK{}
+		if err := topK.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		return topK, nil
+	})
+}
+
+type bucket struct {
+	Fingerprint uint32
+	Count       uint32
+}
+
+// Item is an item tracked in the top-k list with its estimated count.
+type Item struct {
+	Item  string
+	Count uint32
+}
+
+// TopK tracks the k most frequent items of a stream using the HeavyKeeper algorithm.
+type TopK struct {
+	k       uint32
+	width   uint32
+	depth   uint32
+	decay   float64
+	buckets []bucket // depth rows of width buckets
+	// The top-k items ordered by descending count.
+	items []Item
+	// State of the xorshift generator used for decay decisions.
+	// It is persisted so that replicas that apply the same commands end up with identical structures.
+	rand uint64
+}
+
+func NewTopK(k uint32, width uint32, depth uint32, decay float64) *TopK {
+	return &TopK{
+		k:       k,
+		width:   width,
+		depth:   depth,
+		decay:   decay,
+		buckets: make([]bucket, uint64(width)*uint64(depth)),
+		items:   make([]Item, 0, k),
+		rand:    0x9e3779b97f4a7c15,
+	}
+}
+
+func (topK *TopK) next() float64 {
+	topK.rand ^= topK.rand << 13
+	topK.rand ^= topK.rand >> 7
+	topK.rand ^= topK.rand << 17
+	return float64(topK.rand>>11) / (1 << 53)
+}
+
+func (topK *TopK) hash(item string) (uint32, uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(item))
+	h1 := h.Sum64()
+	h = fnv.New64()
+	_, _ = h.Write([]byte(item))
+	return uint32(h1 >> 32), h1, h.Sum64() | 1
+}
+
+// IncrBy increases the score of the item. If the item enters the top-k list and another item is
+// expelled as a result, the expelled item is returned with true.
+func (topK *TopK) IncrBy(item string, increment uint32) (string, bool) {
+	fp, h1, h2 := topK.hash(item)
+	var maxCount uint32
+
+	for i := uint64(0); i < uint64(topK.depth); i++ {
+		b := &topK.buckets[i*uint64(topK.width)+(h1+i*h2)%uint64(topK.width)]
+		switch {
+		case b.Count == 0:
+			b.Fingerprint = fp
+			b.Count = increment
+		case b.Fingerprint == fp:
+			b.Count += increment
+		default:
+			// Decay the count of the colliding item, and take over the bucket once it reaches 0.
+			for j := uint32(0); j < increment; j++ {
+				if topK.next() < math.Pow(topK.decay, float64(b.Count)) {
+					b.Count--
+					if b.Count == 0 {
+						b.Fingerprint = fp
+						b.Count = increment - j
+						break
+					}
+				}
+			}
+		}
+		if b.Fingerprint == fp {
+			maxCount = max(maxCount, b.Count)
+		}
+	}
+
+	if idx := slices.IndexFunc(topK.items, func(i Item) bool { return i.Item == item }); idx != -1 {
+		topK.items[idx].Count = max(topK.items[idx].Count, maxCount)
+		topK.sort()
+		return "", false
+	}
+	if uint32(len(topK.items)) < topK.k {
+		if maxCount > 0 {
+			topK.items = append(topK.items, Item{Item: item, Count: maxCount})
+			topK.sort()
+		}
+		return "", false
+	}
+	last := len(topK.items) - 1
+	if maxCount > topK.items[last].Count {
+		expelled := topK.items[last].Item
+		topK.items[last] = Item{Item: item, Count: maxCount}
+		topK.sort()
+		return expelled, true
+	}
+	return "", false
+}
+
+func (topK *TopK) sort() {
+	slices.SortStableFunc(topK.items, func(a, b Item) int {
+		if a.Count != b.Count {
+			if a.Count > b.Count {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Item, b.Item)
+	})
+}
+
+// Query returns true if the item is currently in the top-k list.
+func (topK *TopK) Query(item string) bool {
+	return slices.ContainsFunc(topK.items, func(i Item) bool { return i.Item == item })
+}
+
+// List returns the items in the top-k list ordered by descending count.
+func (topK *TopK) List() []Item {
+	return slices.Clone(topK.items)
+}
+
+func (topK *TopK) K() uint32 {
+	return topK.k
+}
+
+func (topK *TopK) Width() uint32 {
+	return topK.width
+}
+
+func (topK *TopK) Depth() uint32 {
+	return topK.depth
+}
+
+func (topK *TopK) Decay() float64 {
+	return topK.decay
+}
+
+// Size returns the approximate memory used by the structure in bytes.
+func (topK *TopK) Size() int {
+	size := int(unsafe.Sizeof(*topK)) + len(topK.buckets)*int(unsafe.Sizeof(bucket{}))
+	for _, item := range topK.items {
+		size += int(unsafe.Sizeof(item)) + len(item.Item)
+	}
+	return size
+}
+
+func (topK *TopK) TypeName() string {
+	return TypeName
+}
+
+func (topK *TopK) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, v := range []interface{}{
+		topK.k, topK.width, topK.depth, topK.decay, topK.rand, topK.buckets, uint32(len(topK.items)),
+	} {
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+	for _, item := range topK.items {
+		_ = binary.Write(buf, binary.LittleEndian, uint32(len(item.Item)))
+		buf.WriteString(item.Item)
+		_ = binary.Write(buf, binary.LittleEndian, item.Count)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a structure encoded by MarshalBinary. The data may come from a client with RESTORE, so
+// the structure is checked before it is used, and nothing larger than the data is allocated.
+func (topK *TopK) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	for _, v := range []interface{}{&topK.k, &topK.width, &topK.depth, &topK.decay, &topK.rand} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if topK.k == 0 || topK.width == 0 || topK.depth == 0 || !(topK.decay > 0 && topK.decay <= 1) ||
+		uint64(topK.width)*uint64(topK.depth) > uint64(r.Len())/uint64(unsafe.Sizeof(bucket{})) {
+		return errInvalidEncoding
+	}
+	topK.buckets = make([]bucket, uint64(topK.width)*uint64(topK.depth))
+	if err := binary.Read(r, binary.LittleEndian, topK.buckets); err != nil {
+		return err
+	}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return err
+	}
+	// Each item takes at least 8 bytes.
+	if count > topK.k || uint64(count) > uint64(r.Len())/8 {
+		return errInvalidEncoding
+	}
+	topK.items = make([]Item, count)
+	for i := range topK.items {
+		var length uint32
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return err
+		}
+		if uint64(length) > uint64(r.Len()) {
+			return errInvalidEncoding
+		}
+		b := make([]byte, length)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		topK.items[i].Item = string(b)
+		if err := binary.Read(r, binary.LittleEndian, &topK.items[i].Count); err != nil {
+			return err
+		}
+	}
+	if r.Len() != 0 {
+		return errInvalidEncoding
+	}
+	return nil
+}
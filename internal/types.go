@@ -14,18 +14,36 @@
 
 package internal
 
-import "time"
+import (
+	"github.com/echovault/echovault/internal/search"
+	"time"
+)
 
 type KeyData struct {
 	Value    interface{}
 	ExpireAt time.Time
 }
 
+// StateCapture is a point-in-time view of the keyspace. Each value is copied when it's read, or before a write
+// command changes it, so write commands continue while the capture is read. It must be released once it's read.
+type StateCapture interface {
+	Keys() []string                 // The keys when the capture started.
+	Get(key string) (KeyData, bool) // The entry of the key when the capture started, or false if the key did not exist.
+	Release()
+}
+
+// SavePoint triggers a snapshot once at least Changes keys have been set or deleted
+// and Seconds have passed since the last snapshot.
+type SavePoint struct {
+	Seconds uint64
+	Changes uint64
+}
+
 type ContextServerID string
 type ContextConnID string
 
 type ApplyRequest struct {
-	Type         string   `json:"Type"` // command | delete-key
+	Type         string   `json:"Type"` // command | delete-key | replication
 	ServerID     string   `json:"ServerID"`
 	ConnectionID string   `json:"ConnectionID"`
 	CMD          []string `json:"CMD"`
@@ -40,4 +58,5 @@ type ApplyResponse struct {
 type SnapshotObject struct {
 	State                      map[string]KeyData
 	LatestSnapshotMilliseconds int64
+	Indexes                    []search.Schema `json:",omitempty"` // Secondary index definitions
 }
@@ -180,6 +180,37 @@ func ParseMemory(memory string) (uint64, error) {
 	return uint64(bytesInt), nil
 }
 
+// ParseSavePoints parses save points written as "seconds changes" pairs separated by spaces, such as "900 1 300 10".
+// An empty string returns no save points, which disables automatic snapshots.
+func ParseSavePoints(s string) ([]SavePoint, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("save points must be pairs of seconds and changes, got %q", s)
+	}
+	points := make([]SavePoint, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid save point seconds %q", fields[i])
+		}
+		changes, err := strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil || changes == 0 {
+			return nil, fmt.Errorf("invalid save point changes %q, must be a positive integer", fields[i+1])
+		}
+		points = append(points, SavePoint{Seconds: seconds, Changes: changes})
+	}
+	return points, nil
+}
+
+// FormatSavePoints returns the save points in the format read by ParseSavePoints.
+func FormatSavePoints(points []SavePoint) string {
+	fields := make([]string, 0, len(points)*2)
+	for _, point := range points {
+		fields = append(fields, strconv.FormatUint(point.Seconds, 10), strconv.FormatUint(point.Changes, 10))
+	}
+	return strings.Join(fields, " ")
+}
+
 // IsMaxMemoryExceeded checks whether we have exceeded the current maximum memory limit.
 func IsMaxMemoryExceeded(maxMemory uint64) bool {
 	if maxMemory == 0 {
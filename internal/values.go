@@ -0,0 +1,150 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EncodableValue is implemented by value types that cannot be represented as plain JSON.
+// These values are persisted as binary blobs tagged with the type name, and decoded with the
+// decoder registered for that name with RegisterValueType.
+type EncodableValue interface {
+	encoding.BinaryMarshaler
+	TypeName() string
+}
+
+var valueDecoders = struct {
+	mut      sync.RWMutex
+	decoders map[string]func(data []byte) (interface{}, error)
+}{
+	decoders: make(map[string]func(data []byte) (interface{}, error)),
+}
+
+// RegisterValueType registers the decoder of an EncodableValue type.
+// It is usually called from the init function of the package that defines the type.
+func RegisterValueType(name string, decode func(data []byte) (interface{}, error)) {
+	valueDecoders.mut.Lock()
+	defer valueDecoders.mut.Unlock()
+	valueDecoders.decoders[name] = decode
+}
+
+// EncodeValue returns the type name and binary encoding of the value if it is an EncodableValue.
+func EncodeValue(value interface{}) (string, []byte, bool, error) {
+	v, ok := value.(EncodableValue)
+	if !ok {
+		return "", nil, false, nil
+	}
+	data, err := v.MarshalBinary()
+	if err != nil {
+		return "", nil, true, err
+	}
+	return v.TypeName(), data, true, nil
+}
+
+// DecodeValue decodes a value encoded by EncodeValue.
+func DecodeValue(typeName string, data []byte) (interface{}, error) {
+	valueDecoders.mut.RLock()
+	decode, ok := valueDecoders.decoders[typeName]
+	valueDecoders.mut.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown value type %s", typeName)
+	}
+	return decode(data)
+}
+
+// CloneableValue is implemented by value types that can copy themselves.
+type CloneableValue interface {
+	Clone() interface{}
+}
+
+// CloneValue returns a deep copy of a value from the store, which is not affected by the commands that mutate
+// the value in place. EncodableValue types that do not implement CloneableValue are copied by encoding and
+// decoding them. Strings and numbers are returned as they are.
+func CloneValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	default:
+		typeName, encoded, ok, err := EncodeValue(value)
+		if err != nil || !ok {
+			return value, err
+		}
+		return DecodeValue(typeName, encoded)
+
+	case CloneableValue:
+		return v.Clone(), nil
+
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			clone, err := CloneValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = clone
+		}
+		return list, nil
+
+	case map[string]interface{}:
+		hash := make(map[string]interface{}, len(v))
+		for field, elem := range v {
+			clone, err := CloneValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			hash[field] = clone
+		}
+		return hash, nil
+	}
+}
+
+type keyDataJSON struct {
+	Value    interface{}
+	Type     string `json:",omitempty"`
+	Encoded  []byte `json:",omitempty"`
+	ExpireAt time.Time
+}
+
+func (data KeyData) MarshalJSON() ([]byte, error) {
+	typeName, encoded, ok, err := EncodeValue(data.Value)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return json.Marshal(keyDataJSON{Value: data.Value, ExpireAt: data.ExpireAt})
+	}
+	return json.Marshal(keyDataJSON{Type: typeName, Encoded: encoded, ExpireAt: data.ExpireAt})
+}
+
+func (data *KeyData) UnmarshalJSON(b []byte) error {
+	var v keyDataJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	data.ExpireAt = v.ExpireAt
+	if v.Type == "" {
+		data.Value = v.Value
+		return nil
+	}
+	value, err := DecodeValue(v.Type, v.Encoded)
+	if err != nil {
+		return err
+	}
+	data.Value = value
+	return nil
+}
@@ -3,14 +3,21 @@ package commands
 import (
 	"github.com/echovault/echovault/pkg/modules/acl"
 	"github.com/echovault/echovault/pkg/modules/admin"
+	"github.com/echovault/echovault/pkg/modules/bloom"
+	"github.com/echovault/echovault/pkg/modules/cms"
 	"github.com/echovault/echovault/pkg/modules/connection"
+	"github.com/echovault/echovault/pkg/modules/cuckoo"
 	"github.com/echovault/echovault/pkg/modules/generic"
 	"github.com/echovault/echovault/pkg/modules/hash"
 	"github.com/echovault/echovault/pkg/modules/list"
 	"github.com/echovault/echovault/pkg/modules/pubsub"
+	"github.com/echovault/echovault/pkg/modules/ratelimit"
+	"github.com/echovault/echovault/pkg/modules/search"
 	"github.com/echovault/echovault/pkg/modules/set"
 	"github.com/echovault/echovault/pkg/modules/sorted_set"
 	str "github.com/echovault/echovault/pkg/modules/string"
+	"github.com/echovault/echovault/pkg/modules/timeseries"
+	"github.com/echovault/echovault/pkg/modules/topk"
 	"github.com/echovault/echovault/pkg/types"
 )
 
@@ -19,13 +26,20 @@ func All() []types.Command {
 	var commands []types.Command
 	commands = append(commands, acl.Commands()...)
 	commands = append(commands, admin.Commands()...)
+	commands = append(commands, bloom.Commands()...)
+	commands = append(commands, cms.Commands()...)
+	commands = append(commands, cuckoo.Commands()...)
 	commands = append(commands, generic.Commands()...)
 	commands = append(commands, hash.Commands()...)
 	commands = append(commands, list.Commands()...)
 	commands = append(commands, connection.Commands()...)
 	commands = append(commands, pubsub.Commands()...)
+	commands = append(commands, ratelimit.Commands()...)
+	commands = append(commands, search.Commands()...)
 	commands = append(commands, set.Commands()...)
 	commands = append(commands, sorted_set.Commands()...)
 	commands = append(commands, str.Commands()...)
+	commands = append(commands, timeseries.Commands()...)
+	commands = append(commands, topk.Commands()...)
 	return commands
 }
@@ -17,21 +17,31 @@ package constants
 const (
 	ACLModule        = "acl"
 	AdminModule      = "admin"
+	BloomModule      = "bloom"
+	CMSModule        = "cms"
 	ConnectionModule = "connection"
+	CuckooModule     = "cuckoo"
 	GenericModule    = "generic"
 	HashModule       = "hash"
 	ListModule       = "list"
 	PubSubModule     = "pubsub"
+	RateLimitModule  = "ratelimit"
+	SearchModule     = "search"
 	SetModule        = "set"
 	SortedSetModule  = "sortedset"
 	StringModule     = "string"
+	TimeSeriesModule = "timeseries"
+	TopKModule       = "topk"
 )
 
 const (
 	AdminCategory       = "admin"
 	BitmapCategory      = "bitmap"
 	BlockingCategory    = "blocking"
+	BloomCategory       = "bloom"
+	CMSCategory         = "cms"
 	ConnectionCategory  = "connection"
+	CuckooCategory      = "cuckoo"
 	DangerousCategory   = "dangerous"
 	GeoCategory         = "geo"
 	HashCategory        = "hash"
@@ -40,13 +50,18 @@ const (
 	KeyspaceCategory    = "keyspace"
 	ListCategory        = "list"
 	PubSubCategory      = "pubsub"
+	RateLimitCategory   = "ratelimit"
 	ReadCategory        = "read"
+	ReplicationCategory = "replication"
 	ScriptingCategory   = "scripting"
+	SearchCategory      = "search"
 	SetCategory         = "set"
 	SortedSetCategory   = "sortedset"
 	SlowCategory        = "slow"
 	StreamCategory      = "stream"
 	StringCategory      = "string"
+	TimeSeriesCategory  = "timeseries"
+	TopKCategory        = "topk"
 	TransactionCategory = "transaction"
 	WriteCategory       = "write"
 )
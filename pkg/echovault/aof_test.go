@@ -0,0 +1,466 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	logstore "github.com/echovault/echovault/internal/aof/log"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/pkg/commands"
+	"github.com/echovault/echovault/pkg/constants"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEchoVault_AOFCorruptionPolicy(t *testing.T) {
+	set := func(key, value string) []byte {
+		return internal.EncodeCommand([]string{"SET", key, value})
+	}
+
+	// Build an AOF file with a corrupted record in the middle and a torn record at the end.
+	data := []byte(logstore.FileHeader)
+	data = logstore.AppendRecord(data, 0, set("key1", "value1"))
+	data = logstore.AppendRecord(data, 0, set("key2", "value2"))
+	corruptAt := len(data)
+	data = logstore.AppendRecord(data, 0, set("key3", "value3"))
+	data[len(data)-3] ^= 0xFF
+	data = logstore.AppendRecord(data, 0, set("key4", "value4"))
+	torn := logstore.AppendRecord(nil, 0, set("key5", "value5"))
+	data = append(data, torn[:len(torn)-4]...)
+
+	tests := []struct {
+		name     string
+		policy   string
+		wantErr  bool
+		wantKeys map[string]string
+		wantSize int
+	}{
+		{
+			name:     "1. Truncate replays the records before the first corruption and truncates the file",
+			policy:   logstore.PolicyTruncate,
+			wantKeys: map[string]string{"key1": "value1", "key2": "value2", "key3": "", "key4": "", "key5": ""},
+			wantSize: corruptAt,
+		},
+		{
+			name:     "2. Skip replays every valid record and leaves the file as it is",
+			policy:   logstore.PolicySkip,
+			wantKeys: map[string]string{"key1": "value1", "key2": "value2", "key3": "", "key4": "value4", "key5": ""},
+			wantSize: len(data),
+		},
+		{
+			name:    "3. Fail refuses to start",
+			policy:  logstore.PolicyFail,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+			file := path.Join(dataDir, "aof", "log.aof")
+			if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(file, data, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			server, err := NewEchoVault(
+				WithCommands(commands.All()),
+				WithConfig(config.Config{
+					DataDir:             dataDir,
+					EvictionPolicy:      constants.NoEviction,
+					RestoreAOF:          true,
+					AOFSyncStrategy:     "always",
+					AOFCorruptionPolicy: tt.policy,
+				}),
+			)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error when restoring a corrupted AOF file")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.wantKeys {
+				if got, _ := server.GET(key); got != want {
+					t.Errorf("GET(%s) got = %v, want %v", key, got, want)
+				}
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(tt.wantSize) {
+				t.Errorf("expected AOF file size %d, got %d", tt.wantSize, info.Size())
+			}
+		})
+	}
+}
+
+func TestEchoVault_AOFLegacyFormat(t *testing.T) {
+	dataDir := t.TempDir()
+	file := path.Join(dataDir, "aof", "log.aof")
+	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files written before records were framed hold commands separated by blank lines.
+	var data []byte
+	for _, command := range [][]string{{"SET", "key1", "value1"}, {"SET", "key2", "value2"}} {
+		data = append(data, append(internal.EncodeCommand(command), []byte("\r\n")...)...)
+	}
+	if err := os.WriteFile(file, data, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:         dataDir,
+			EvictionPolicy:  constants.NoEviction,
+			RestoreAOF:      true,
+			AOFSyncStrategy: "always",
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"key1": "value1", "key2": "value2"} {
+		if got, _ := server.GET(key); got != want {
+			t.Errorf("GET(%s) got = %v, want %v", key, got, want)
+		}
+	}
+
+	// The file is converted to the framed format.
+	converted, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := logstore.Check(converted)
+	if result.Legacy || len(result.Corruptions) > 0 || len(result.Records) != 2 {
+		t.Errorf("expected 2 framed records, got legacy = %v, records = %d, corruptions = %d",
+			result.Legacy, len(result.Records), len(result.Corruptions))
+	}
+}
+
+func TestEchoVault_RewriteAOF(t *testing.T) {
+	dataDir := t.TempDir()
+	conf := config.Config{
+		DataDir:         dataDir,
+		EvictionPolicy:  constants.NoEviction,
+		RestoreAOF:      true,
+		AOFSyncStrategy: "always",
+	}
+
+	server, err := NewEchoVault(WithCommands(commands.All()), WithConfig(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.SET("key1", "value1", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep appending to a list while the AOF is rewritten.
+	// A command that is lost or replayed twice changes the restored list.
+	const count = 500
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < count; i++ {
+			if _, err := server.RPUSH("list", strconv.Itoa(i)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		if err = server.aofEngine.RewriteLog(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if _, err = server.SET("key2", "value2", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	server.ShutDown()
+
+	entries, err := os.ReadDir(path.Join(dataDir, "aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	if want := []string{"base.3.bin", "incr.3.aof", "manifest.bin"}; !slices.Equal(files, want) {
+		t.Errorf("expected aof files %v, got %v", want, files)
+	}
+
+	restored, err := NewEchoVault(WithCommands(commands.All()), WithConfig(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"key1": "value1", "key2": "value2"} {
+		if got, _ := restored.GET(key); got != want {
+			t.Errorf("GET(%s) got = %v, want %v", key, got, want)
+		}
+	}
+	list, err := restored.LRANGE("list", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != count {
+		t.Fatalf("expected list length %d, got %d", count, len(list))
+	}
+	for i, value := range list {
+		if value != strconv.Itoa(i) {
+			t.Fatalf("expected element %d to be %d, got %s", i, i, value)
+		}
+	}
+}
+
+func TestEchoVault_AOFIncrementalFiles(t *testing.T) {
+	set := func(key, value string) []byte {
+		return internal.EncodeCommand([]string{"SET", key, value})
+	}
+
+	// An interrupted rewrite leaves the manifest listing the incremental files of both the old and the new AOF.
+	writeAOF := func(t *testing.T, corruptFirst bool) string {
+		dataDir := t.TempDir()
+		dir := path.Join(dataDir, "aof")
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		first := logstore.AppendRecord([]byte(logstore.FileHeader), 0, set("key1", "value1"))
+		first = logstore.AppendRecord(first, 0, set("key2", "value2"))
+		if corruptFirst {
+			first[len(first)-3] ^= 0xFF
+		}
+		second := logstore.AppendRecord([]byte(logstore.FileHeader), 0, set("key2", "value3"))
+		files := map[string][]byte{
+			"incr.1.aof":   first,
+			"incr.2.aof":   second,
+			"manifest.bin": []byte(`{"Incrementals":["incr.1.aof","incr.2.aof"],"Seq":2}`),
+		}
+		for name, data := range files {
+			if err := os.WriteFile(path.Join(dir, name), data, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dataDir
+	}
+
+	tests := []struct {
+		name     string
+		corrupt  bool
+		policy   string
+		wantErr  bool
+		wantKeys map[string]string
+	}{
+		{
+			name:     "1. Replay the incremental files in order",
+			wantKeys: map[string]string{"key1": "value1", "key2": "value3"},
+		},
+		{
+			name:    "2. A corruption in an earlier incremental file cannot be truncated",
+			corrupt: true,
+			policy:  logstore.PolicyTruncate,
+			wantErr: true,
+		},
+		{
+			name:     "3. Skip the corrupted record in an earlier incremental file",
+			corrupt:  true,
+			policy:   logstore.PolicySkip,
+			wantKeys: map[string]string{"key1": "value1", "key2": "value3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewEchoVault(
+				WithCommands(commands.All()),
+				WithConfig(config.Config{
+					DataDir:             writeAOF(t, tt.corrupt),
+					EvictionPolicy:      constants.NoEviction,
+					RestoreAOF:          true,
+					AOFSyncStrategy:     "always",
+					AOFCorruptionPolicy: tt.policy,
+				}),
+			)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error when restoring a corrupted AOF file")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.wantKeys {
+				if got, _ := server.GET(key); got != want {
+					t.Errorf("GET(%s) got = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEchoVault_AOFSyncAlways(t *testing.T) {
+	dataDir := t.TempDir()
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:         dataDir,
+			EvictionPolicy:  constants.NoEviction,
+			AOFSyncStrategy: "always",
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every write must be in the AOF by the time its reply is returned.
+	const count = 50
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := server.SET(fmt.Sprintf("key%d", i), "value", SETOptions{}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path.Join(dataDir, "aof", "incr.1.aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := logstore.Check(data)
+	if len(result.Corruptions) > 0 || len(result.Records) != count {
+		t.Errorf("expected %d records, got records = %d, corruptions = %d",
+			count, len(result.Records), len(result.Corruptions))
+	}
+}
+
+func BenchmarkEchoVault_AOFSyncStrategy(b *testing.B) {
+	for _, strategy := range []string{"always", "everysec", "no"} {
+		b.Run(strategy, func(b *testing.B) {
+			server, err := NewEchoVault(
+				WithCommands(commands.All()),
+				WithConfig(config.Config{
+					DataDir:         b.TempDir(),
+					EvictionPolicy:  constants.NoEviction,
+					AOFSyncStrategy: strategy,
+				}),
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var i atomic.Int64
+			b.ResetTimer()
+			// Concurrent writers share the fsyncs of the "always" strategy.
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := server.SET(fmt.Sprintf("key%d", i.Add(1)), "value", SETOptions{}); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+			b.StopTimer()
+			if err = server.aofEngine.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestEchoVault_AutoRewriteAOF(t *testing.T) {
+	dataDir := t.TempDir()
+	conf := config.Config{
+		DataDir:           dataDir,
+		EvictionPolicy:    constants.NoEviction,
+		RestoreAOF:        true,
+		AOFSyncStrategy:   "always",
+		AOFRewritePercent: 100,
+		AOFRewriteMinSize: 4096,
+	}
+	server, err := NewEchoVault(WithCommands(commands.All()), WithConfig(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := server.AOF_STATUS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Size != int64(len(logstore.FileHeader)) || status.BaseSize != status.Size {
+		t.Errorf("expected size and base size %d, got size = %d, base size = %d",
+			len(logstore.FileHeader), status.Size, status.BaseSize)
+	}
+	if status.AutoRewritePercentage != 100 || status.AutoRewriteMinSize != 4096 {
+		t.Errorf("expected auto rewrite settings 100 and 4096, got %d and %d",
+			status.AutoRewritePercentage, status.AutoRewriteMinSize)
+	}
+
+	// Overwrite the same key until the AOF is larger than the minimum size.
+	for i := 0; status.Size < 4096 && status.AutoRewrites == 0; i++ {
+		if _, err = server.SET("key", strconv.Itoa(i), SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if status, err = server.AOF_STATUS(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for status.AutoRewrites == 0 || status.RewriteInProgress {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected an automatic rewrite, got status %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if status, err = server.AOF_STATUS(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status.Rewrites != 1 || status.FailedRewrites != 0 || status.LastRewriteError != "" {
+		t.Errorf("expected 1 successful rewrite, got %+v", status)
+	}
+	// The base file only holds the last value of the key.
+	if status.Size >= 4096 || status.BaseSize != status.Size {
+		t.Errorf("expected the rewritten AOF to be smaller than 4096 bytes and equal to the base size, got %+v", status)
+	}
+
+	want, _ := server.GET("key")
+	server.ShutDown()
+	restored, err := NewEchoVault(WithCommands(commands.All()), WithConfig(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restored.GET("key"); got != want {
+		t.Errorf("GET(key) got = %v, want %v", got, want)
+	}
+}
@@ -14,7 +14,13 @@
 
 package echovault
 
-import "github.com/echovault/echovault/internal"
+import (
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/pkg/types"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // CommandListOptions modifies the result from the COMMAND_LIST command.
 //
@@ -67,7 +73,8 @@ func (server *EchoVault) COMMAND_COUNT() (int, error) {
 	return internal.ParseIntegerResponse(b)
 }
 
-// SAVE triggers a new snapshot.
+// SAVE takes a snapshot of this node and returns once it is complete.
+// In a replication cluster, a raft snapshot is taken.
 func (server *EchoVault) SAVE() (string, error) {
 	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"SAVE"}), nil, false, true)
 	if err != nil {
@@ -76,6 +83,334 @@ func (server *EchoVault) SAVE() (string, error) {
 	return internal.ParseStringResponse(b)
 }
 
+// BGSAVEOptions modifies the behaviour of the BGSAVE command.
+//
+// SCHEDULE - If the AOF is being rewritten, start the snapshot once the rewrite finishes instead of returning an error.
+type BGSAVEOptions struct {
+	SCHEDULE bool
+}
+
+// BGSAVE starts a snapshot of this node in the background.
+// Use INFO_PERSISTENCE to find out when the snapshot is complete and whether it succeeded.
+//
+// Parameters:
+//
+// `options` - BGSAVEOptions.
+//
+// Returns: "Background saving started", or "Background saving scheduled" if the snapshot waits for the AOF rewrite
+// in progress.
+func (server *EchoVault) BGSAVE(options BGSAVEOptions) (string, error) {
+	cmd := []string{"BGSAVE"}
+	if options.SCHEDULE {
+		cmd = append(cmd, "SCHEDULE")
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// SAVE_RDB writes the current state to the given path as a Redis RDB file.
+// Keys holding values that have no RDB representation, such as bloom filters and time series, are left out.
+func (server *EchoVault) SAVE_RDB(path string) (string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"SAVE", "RDB", path}), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// RESTORE_RDB loads the keys of the Redis RDB file at the given path.
+// Existing keys with the same name are replaced.
+func (server *EchoVault) RESTORE_RDB(path string) (string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"RESTORE-RDB", path}), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// SNAPSHOT_LIST returns the snapshots that are kept on disk, oldest first.
+// Only works in standalone mode, or on a cluster node with the 'snapshot' cluster persistence.
+func (server *EchoVault) SNAPSHOT_LIST() ([]types.SnapshotInfo, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"SNAPSHOT", "LIST"}), nil, false, true)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := internal.ParseNestedStringArrayResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]types.SnapshotInfo, len(entries))
+	for i, entry := range entries {
+		for j := 0; j+1 < len(entry); j += 2 {
+			switch entry[j] {
+			case "id":
+				snapshots[i].ID, _ = strconv.ParseInt(entry[j+1], 10, 64)
+			case "size":
+				snapshots[i].Size, _ = strconv.ParseInt(entry[j+1], 10, 64)
+			case "hash":
+				snapshots[i].Hash = entry[j+1]
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+// SNAPSHOT_RESTORE replaces the current state with the state in the snapshot with the given id.
+// Only works in standalone mode.
+func (server *EchoVault) SNAPSHOT_RESTORE(id int64) (string, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"SNAPSHOT", "RESTORE", strconv.FormatInt(id, 10)}),
+		nil, false, true,
+	)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// BackupCreateOptions modifies the type of the backup taken by BACKUP_CREATE.
+//
+// FULL takes a backup of every key. Has the highest priority.
+//
+// DIFFERENTIAL takes a backup of the keys that changed since the previous full backup.
+//
+// By default, an incremental backup of the keys that changed since the previous backup is taken.
+// The first backup after startup is always a full backup.
+type BackupCreateOptions struct {
+	FULL         bool
+	DIFFERENTIAL bool
+}
+
+// BACKUP_CREATE takes a backup in the backup directory and returns it.
+func (server *EchoVault) BACKUP_CREATE(options BackupCreateOptions) (types.BackupInfo, error) {
+	cmd := []string{"BACKUP", "CREATE"}
+	switch {
+	case options.FULL:
+		cmd = append(cmd, "FULL")
+	case options.DIFFERENTIAL:
+		cmd = append(cmd, "DIFFERENTIAL")
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return types.BackupInfo{}, err
+	}
+	fields, err := internal.ParseStringArrayResponse(b)
+	if err != nil {
+		return types.BackupInfo{}, err
+	}
+	return parseBackupInfo(fields), nil
+}
+
+// BACKUP_LIST returns the backups in the backup directory, oldest first.
+func (server *EchoVault) BACKUP_LIST() ([]types.BackupInfo, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"BACKUP", "LIST"}), nil, false, true)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := internal.ParseNestedStringArrayResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]types.BackupInfo, len(entries))
+	for i, entry := range entries {
+		backups[i] = parseBackupInfo(entry)
+	}
+	return backups, nil
+}
+
+// BACKUP_VERIFY checks the backup with the given id and the backups it depends on, or every backup if the id is 0.
+// The Error of an intact backup is empty.
+func (server *EchoVault) BACKUP_VERIFY(id int64) ([]types.BackupCheck, error) {
+	cmd := []string{"BACKUP", "VERIFY"}
+	if id != 0 {
+		cmd = append(cmd, strconv.FormatInt(id, 10))
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := internal.ParseNestedStringArrayResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	checks := make([]types.BackupCheck, len(entries))
+	for i, entry := range entries {
+		for j := 0; j+1 < len(entry); j += 2 {
+			switch entry[j] {
+			case "id":
+				checks[i].ID, _ = strconv.ParseInt(entry[j+1], 10, 64)
+			case "status":
+				if entry[j+1] != "ok" {
+					checks[i].Error = entry[j+1]
+				}
+			}
+		}
+	}
+	return checks, nil
+}
+
+// BACKUP_RESTORE replaces the current state with the state in the backup with the given id, or in the latest
+// backup if the id is 0. Only works in standalone mode.
+func (server *EchoVault) BACKUP_RESTORE(id int64) (string, error) {
+	cmd := []string{"BACKUP", "RESTORE"}
+	if id != 0 {
+		cmd = append(cmd, strconv.FormatInt(id, 10))
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+func parseBackupInfo(fields []string) types.BackupInfo {
+	var backup types.BackupInfo
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fields[i+1]
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch fields[i] {
+		case "id":
+			backup.ID = n
+		case "type":
+			backup.Type = value
+		case "parent":
+			backup.Parent = n
+		case "time":
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				backup.Time = t.UnixMilli()
+			}
+		case "size":
+			backup.Size = n
+		case "keys":
+			backup.Keys = n
+		case "deleted_keys":
+			backup.DeletedKeys = n
+		case "checksum":
+			backup.Checksum = value
+		}
+	}
+	return backup
+}
+
+// AOF_STATUS returns the size of the AOF and the progress and statistics of its rewrites.
+// Only works in standalone mode, or on a cluster node with the 'aof' cluster persistence.
+func (server *EchoVault) AOF_STATUS() (types.AOFStatus, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"AOF", "STATUS"}), nil, false, true)
+	if err != nil {
+		return types.AOFStatus{}, err
+	}
+	fields, err := internal.ParseStringArrayResponse(b)
+	if err != nil {
+		return types.AOFStatus{}, err
+	}
+	status := types.AOFStatus{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fields[i+1]
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch fields[i] {
+		case "rewrite_in_progress":
+			status.RewriteInProgress = n == 1
+		case "rewrite_start_time":
+			status.RewriteStartTime = n
+		case "rewrites":
+			status.Rewrites = n
+		case "auto_rewrites":
+			status.AutoRewrites = n
+		case "failed_rewrites":
+			status.FailedRewrites = n
+		case "last_rewrite_time":
+			status.LastRewriteTime = n
+		case "last_rewrite_duration_ms":
+			status.LastRewriteDuration = n
+		case "last_rewrite_error":
+			status.LastRewriteError = value
+		case "size":
+			status.Size = n
+		case "base_size":
+			status.BaseSize = n
+		case "auto_rewrite_percentage":
+			status.AutoRewritePercentage = uint64(n)
+		case "auto_rewrite_min_size":
+			status.AutoRewriteMinSize = uint64(n)
+		}
+	}
+	return status, nil
+}
+
+// INFO_PERSISTENCE returns the progress and the results of the snapshots and of the AOF rewrites,
+// as reported by the persistence section of INFO.
+func (server *EchoVault) INFO_PERSISTENCE() (types.PersistenceStatus, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"INFO", "PERSISTENCE"}), nil, false, true)
+	if err != nil {
+		return types.PersistenceStatus{}, err
+	}
+	info, err := internal.ParseStringResponse(b)
+	if err != nil {
+		return types.PersistenceStatus{}, err
+	}
+	status := types.PersistenceStatus{}
+	for _, line := range strings.Split(info, "\r\n") {
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch field {
+		case "rdb_changes_since_last_save":
+			status.Snapshot.ChangesSinceLastSave = uint64(n)
+		case "rdb_bgsave_in_progress":
+			status.Snapshot.InProgress = n == 1
+		case "rdb_bgsave_scheduled":
+			status.Snapshot.Scheduled = n == 1
+		case "rdb_current_bgsave_start_time_ms":
+			status.Snapshot.StartTime = n
+		case "rdb_last_save_time_ms":
+			status.Snapshot.LastSaveTime = n
+		case "rdb_last_bgsave_duration_ms":
+			status.Snapshot.LastDuration = n
+		case "rdb_last_bgsave_size":
+			status.Snapshot.LastSize = n
+		case "rdb_last_success_time_ms":
+			status.Snapshot.LastSuccessTime = n
+		case "rdb_last_failure_time_ms":
+			status.Snapshot.LastFailureTime = n
+		case "rdb_last_error":
+			status.Snapshot.LastError = value
+		case "rdb_saves":
+			status.Snapshot.Saves = n
+		case "rdb_failed_saves":
+			status.Snapshot.FailedSaves = n
+		case "aof_enabled":
+			status.AOFEnabled = n == 1
+		case "aof_rewrite_in_progress":
+			status.AOF.RewriteInProgress = n == 1
+		case "aof_current_rewrite_start_time_ms":
+			status.AOF.RewriteStartTime = n
+		case "aof_last_rewrite_time_ms":
+			status.AOF.LastRewriteTime = n
+		case "aof_last_rewrite_duration_ms":
+			status.AOF.LastRewriteDuration = n
+		case "aof_last_rewrite_error":
+			status.AOF.LastRewriteError = value
+		case "aof_rewrites":
+			status.AOF.Rewrites = n
+		case "aof_auto_rewrites":
+			status.AOF.AutoRewrites = n
+		case "aof_failed_rewrites":
+			status.AOF.FailedRewrites = n
+		case "aof_current_size":
+			status.AOF.Size = n
+		case "aof_base_size":
+			status.AOF.BaseSize = n
+		}
+	}
+	return status, nil
+}
+
 // LASTSAVE returns the unix epoch milliseconds timestamp of the last save.
 func (server *EchoVault) LASTSAVE() (int, error) {
 	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"LASTSAVE"}), nil, false, true)
@@ -93,3 +428,321 @@ func (server *EchoVault) REWRITEAOF() (string, error) {
 	}
 	return internal.ParseStringResponse(b)
 }
+
+// CONFIG_GET returns the configuration parameters whose names match any of the glob patterns, keyed by name.
+func (server *EchoVault) CONFIG_GET(patterns ...string) (map[string]string, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand(append([]string{"CONFIG", "GET"}, patterns...)),
+		nil, false, true,
+	)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := internal.ParseStringArrayResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	parameters := make(map[string]string)
+	for i := 0; i+1 < len(fields); i += 2 {
+		parameters[fields[i]] = fields[i+1]
+	}
+	return parameters, nil
+}
+
+// CONFIG_SET changes the given configuration parameters on this node until it restarts.
+// If any parameter or value is invalid, no parameter is changed.
+//
+// The "save" parameter holds the save points that trigger snapshots, such as "900 1 300 10".
+// An empty value disables automatic snapshots.
+func (server *EchoVault) CONFIG_SET(parameters map[string]string) (string, error) {
+	cmd := []string{"CONFIG", "SET"}
+	for name, value := range parameters {
+		cmd = append(cmd, name, value)
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// REPLICATION_STATUS describes the replication between the primary cluster and the replica cluster.
+// The indexes are indexes of the raft log of the primary cluster.
+func (server *EchoVault) REPLICATION_STATUS() (types.ReplicationStatus, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"REPLICATION", "STATUS"}),
+		nil, false, true,
+	)
+	if err != nil {
+		return types.ReplicationStatus{}, err
+	}
+	fields, err := internal.ParseStringArrayResponse(b)
+	if err != nil {
+		return types.ReplicationStatus{}, err
+	}
+	status := types.ReplicationStatus{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fields[i+1]
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch fields[i] {
+		case "role":
+			status.Role = value
+		case "link_status":
+			status.Connected = value == "up"
+		case "target":
+			status.Target = value
+		case "checkpoint":
+			status.Checkpoint = uint64(n)
+		case "primary_index":
+			status.PrimaryIndex = uint64(n)
+		case "lag_entries":
+			status.LagEntries = uint64(n)
+		case "lag_ms":
+			status.Lag = n
+		case "last_contact_time_ms":
+			status.LastContact = n
+		case "full_syncs":
+			status.FullSyncs = n
+		case "sync_in_progress":
+			status.Syncing = n == 1
+		case "last_error":
+			status.LastError = value
+		}
+	}
+	return status, nil
+}
+
+// REPLICATION_PROMOTE promotes the replica cluster, so that it accepts writes and stops accepting entries
+// from the primary cluster. On a cluster node, it must be called on the leader.
+func (server *EchoVault) REPLICATION_PROMOTE() (string, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"REPLICATION", "PROMOTE"}),
+		nil, false, true,
+	)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// CLUSTER_MEMBERS returns the voters and learners in the raft configuration of the cluster.
+// The leader knows the last contact with every member. Other nodes only know the last contact with the leader.
+func (server *EchoVault) CLUSTER_MEMBERS() ([]types.ClusterMember, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"CLUSTER", "MEMBERS"}),
+		nil, false, true,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := internal.ParseNestedStringArrayResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]types.ClusterMember, len(entries))
+	for i, entry := range entries {
+		for j := 0; j+1 < len(entry); j += 2 {
+			value := entry[j+1]
+			switch entry[j] {
+			case "id":
+				members[i].ID = value
+			case "address":
+				members[i].Address = value
+			case "suffrage":
+				members[i].Suffrage = value
+			case "leader":
+				members[i].Leader = value == "1"
+			case "last_contact_time_ms":
+				members[i].LastContact, _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+	}
+	return members, nil
+}
+
+// CLUSTER_PROMOTE promotes the learner with the given server ID to a voter. It must be called on the leader.
+func (server *EchoVault) CLUSTER_PROMOTE(id string) (string, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"CLUSTER", "PROMOTE", id}),
+		nil, false, true,
+	)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// CLUSTER_DEMOTE demotes the voter with the given server ID to a learner. It must be called on the leader.
+func (server *EchoVault) CLUSTER_DEMOTE(id string) (string, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"CLUSTER", "DEMOTE", id}),
+		nil, false, true,
+	)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// CLUSTER_INFO returns the raft state of the node.
+func (server *EchoVault) CLUSTER_INFO() (types.ClusterInfo, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"CLUSTER", "INFO"}),
+		nil, false, true,
+	)
+	if err != nil {
+		return types.ClusterInfo{}, err
+	}
+	fields, err := internal.ParseStringArrayResponse(b)
+	if err != nil {
+		return types.ClusterInfo{}, err
+	}
+	info := types.ClusterInfo{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fields[i+1]
+		n, _ := strconv.ParseUint(value, 10, 64)
+		switch fields[i] {
+		case "state":
+			info.State = value
+		case "term":
+			info.Term = n
+		case "leader_id":
+			info.LeaderID = value
+		case "leader_address":
+			info.LeaderAddress = value
+		case "commit_index":
+			info.CommitIndex = n
+		case "applied_index":
+			info.AppliedIndex = n
+		case "last_log_index":
+			info.LastLogIndex = n
+		case "last_snapshot_index":
+			info.LastSnapshotIndex = n
+		case "last_snapshot_term":
+			info.LastSnapshotTerm = n
+		case "last_snapshot_time_ms":
+			info.LastSnapshotTime = int64(n)
+		case "voters":
+			info.Voters = int(n)
+		case "learners":
+			info.Learners = int(n)
+		}
+	}
+	return info, nil
+}
+
+// CLUSTER_NODES returns the members of the raft configuration and the nodes of the memberlist with their health.
+func (server *EchoVault) CLUSTER_NODES() ([]types.ClusterNode, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"CLUSTER", "NODES"}),
+		nil, false, true,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := internal.ParseNestedStringArrayResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]types.ClusterNode, len(entries))
+	for i, entry := range entries {
+		for j := 0; j+1 < len(entry); j += 2 {
+			value := entry[j+1]
+			switch entry[j] {
+			case "id":
+				nodes[i].ID = value
+			case "raft_address":
+				nodes[i].RaftAddress = value
+			case "memberlist_address":
+				nodes[i].MemberlistAddress = value
+			case "suffrage":
+				nodes[i].Suffrage = value
+			case "leader":
+				nodes[i].Leader = value == "1"
+			case "health":
+				nodes[i].Health = value
+			case "last_contact_time_ms":
+				nodes[i].LastContact, _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// CLUSTER_FORGET removes the failed node with the given server ID from the raft configuration.
+// It must be called on the leader.
+func (server *EchoVault) CLUSTER_FORGET(id string) (string, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"CLUSTER", "FORGET", id}),
+		nil, false, true,
+	)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// CLUSTER_FAILOVER transfers the leadership to the voter with the given server ID, or to the most up to date voter
+// if the ID is empty. It must be called on the leader.
+func (server *EchoVault) CLUSTER_FAILOVER(id string) (string, error) {
+	cmd := []string{"CLUSTER", "FAILOVER"}
+	if id != "" {
+		cmd = append(cmd, id)
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// CLUSTER_LEAVE gracefully removes the node from the cluster. A leader transfers its leadership first.
+func (server *EchoVault) CLUSTER_LEAVE() (string, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"CLUSTER", "LEAVE"}),
+		nil, false, true,
+	)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// CLUSTER_KEYRING returns the keys that encrypt the memberlist gossip of the node. If reload is true, the keys
+// are first replaced with the keys of the gossip key file and environment variable.
+func (server *EchoVault) CLUSTER_KEYRING(reload bool) ([]types.GossipKey, error) {
+	cmd := []string{"CLUSTER", "KEYRING"}
+	if reload {
+		cmd = append(cmd, "RELOAD")
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := internal.ParseNestedStringArrayResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]types.GossipKey, len(entries))
+	for i, entry := range entries {
+		for j := 0; j+1 < len(entry); j += 2 {
+			switch entry[j] {
+			case "id":
+				keys[i].ID = entry[j+1]
+			case "primary":
+				keys[i].Primary = entry[j+1] == "1"
+			}
+		}
+	}
+	return keys, nil
+}
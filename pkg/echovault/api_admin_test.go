@@ -13,3 +13,368 @@
 // limitations under the License.
 
 package echovault
+
+import (
+	"encoding/base64"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/pkg/commands"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEchoVault_EncryptionAtRest(t *testing.T) {
+	dataDir := t.TempDir()
+	keyFile := path.Join(t.TempDir(), "keys")
+	oldKey := strings.Repeat("ab", 32)
+	newKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+
+	writeKeys := func(keys ...string) {
+		if err := os.WriteFile(keyFile, []byte("# master keys\n"+strings.Join(keys, "\n")+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newServer := func(conf config.Config) (*EchoVault, error) {
+		conf.DataDir = dataDir
+		conf.EvictionPolicy = constants.NoEviction
+		conf.AOFSyncStrategy = "always"
+		conf.EncryptionKeyFile = keyFile
+		return NewEchoVault(WithCommands(commands.All()), WithConfig(conf))
+	}
+	// assertEncrypted checks that none of the persisted files hold the values in plain text.
+	assertEncrypted := func(values ...string) {
+		err := filepath.WalkDir(dataDir, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || path.Base(name) == "manifest.bin" {
+				return err
+			}
+			b, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			for _, value := range values {
+				if strings.Contains(string(b), value) {
+					t.Errorf("expected %s to be encrypted, found %s", name, value)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertValues := func(server *EchoVault, want map[string]string) {
+		for key, value := range want {
+			if got, _ := server.GET(key); got != value {
+				t.Errorf("GET(%s) got = %v, want %v", key, got, value)
+			}
+		}
+	}
+
+	writeKeys(oldKey)
+	server, err := newServer(config.Config{RestoreAOF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.SET("key1", "secret-value-1", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.SAVE(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.SET("key2", "secret-value-2", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	server.ShutDown()
+	assertEncrypted("secret-value-1", "secret-value-2")
+
+	restored, err := newServer(config.Config{RestoreAOF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValues(restored, map[string]string{"key1": "secret-value-1", "key2": "secret-value-2"})
+	restored.ShutDown()
+
+	restored, err = newServer(config.Config{RestoreSnapshot: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValues(restored, map[string]string{"key1": "secret-value-1"})
+	restored.ShutDown()
+
+	// After the key is rotated, the AOF continues in a new incremental file encrypted with the new key,
+	// and the files written with the old key stay readable.
+	writeKeys(oldKey, newKey)
+	rotated, err := newServer(config.Config{RestoreAOF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValues(rotated, map[string]string{"key1": "secret-value-1", "key2": "secret-value-2"})
+	if _, err = rotated.SET("key3", "secret-value-3", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	rotated.ShutDown()
+	assertEncrypted("secret-value-1", "secret-value-2", "secret-value-3")
+
+	entries, err := os.ReadDir(path.Join(dataDir, "aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	if want := []string{"incr.1.aof", "incr.2.aof", "manifest.bin"}; !slices.Equal(files, want) {
+		t.Errorf("expected aof files %v, got %v", want, files)
+	}
+
+	restored, err = newServer(config.Config{RestoreAOF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValues(restored, map[string]string{
+		"key1": "secret-value-1",
+		"key2": "secret-value-2",
+		"key3": "secret-value-3",
+	})
+	restored.ShutDown()
+
+	// Without the old key, the AOF cannot be restored.
+	writeKeys(newKey)
+	if _, err = newServer(config.Config{RestoreAOF: true}); err == nil ||
+		!strings.Contains(err.Error(), encryption.ErrUnknownKey.Error()) {
+		t.Errorf("expected error %v without the old key, got %v", encryption.ErrUnknownKey, err)
+	}
+	// Without any key, encrypted files cannot be read.
+	if _, err = NewEchoVault(WithCommands(commands.All()), WithConfig(config.Config{
+		DataDir:        dataDir,
+		EvictionPolicy: constants.NoEviction,
+		RestoreAOF:     true,
+	})); err == nil || !strings.Contains(err.Error(), encryption.ErrNoKeyring.Error()) {
+		t.Errorf("expected error %v without a keyring, got %v", encryption.ErrNoKeyring, err)
+	}
+}
+
+func TestEchoVault_PersistenceStatus(t *testing.T) {
+	dataDir := t.TempDir()
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:         dataDir,
+			EvictionPolicy:  constants.NoEviction,
+			AOFSyncStrategy: "always",
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := server.clock.Now().UnixMilli()
+
+	status, err := server.INFO_PERSISTENCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.AOFEnabled || status.Snapshot.Saves != 0 || status.Snapshot.ChangesSinceLastSave != 0 {
+		t.Errorf("unexpected status on startup %+v", status)
+	}
+
+	for _, key := range []string{"key1", "key2"} {
+		if _, err = server.SET(key, "value", SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status, _ = server.INFO_PERSISTENCE(); status.Snapshot.ChangesSinceLastSave != 2 {
+		t.Errorf("expected 2 changes since the last save, got %d", status.Snapshot.ChangesSinceLastSave)
+	}
+
+	if _, err = server.SAVE(); err != nil {
+		t.Fatal(err)
+	}
+	status, err = server.INFO_PERSISTENCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := types.SnapshotStatus{
+		Saves:           1,
+		LastSaveTime:    now,
+		LastSuccessTime: now,
+		LastSize:        status.Snapshot.LastSize,
+	}
+	if status.Snapshot != want || status.Snapshot.LastSize == 0 {
+		t.Errorf("expected snapshot status %+v after SAVE, got %+v", want, status.Snapshot)
+	}
+
+	// SAVE returns the error of the snapshot, which is also reported by INFO.
+	if _, err = server.DEL("key1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.RemoveAll(path.Join(dataDir, "snapshots")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path.Join(dataDir, "snapshots"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.SAVE(); err == nil {
+		t.Fatal("expected SAVE to fail when the snapshot directory cannot be created")
+	}
+	if status, _ = server.INFO_PERSISTENCE(); status.Snapshot.FailedSaves != 1 || status.Snapshot.LastError == "" ||
+		status.Snapshot.LastFailureTime != now || status.Snapshot.ChangesSinceLastSave != 1 {
+		t.Errorf("expected the failed snapshot to be reported, got %+v", status.Snapshot)
+	}
+	if err = os.Remove(path.Join(dataDir, "snapshots")); err != nil {
+		t.Fatal(err)
+	}
+
+	// BGSAVE is only started during an AOF rewrite with SCHEDULE, once the rewrite finishes.
+	server.startRewriteAOF()
+	if _, err = server.BGSAVE(BGSAVEOptions{}); err == nil {
+		t.Error("expected BGSAVE to fail while the AOF is rewritten")
+	}
+	if res, err := server.BGSAVE(BGSAVEOptions{SCHEDULE: true}); err != nil || res != "Background saving scheduled" {
+		t.Fatalf("BGSAVE(SCHEDULE) got = %v, %v", res, err)
+	}
+	if status, _ = server.INFO_PERSISTENCE(); !status.Snapshot.Scheduled || status.Snapshot.Saves != 1 {
+		t.Errorf("expected the snapshot to be scheduled, got %+v", status.Snapshot)
+	}
+	server.finishRewriteAOF()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err = server.INFO_PERSISTENCE()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Snapshot.Saves == 2 && !status.Snapshot.InProgress {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the scheduled snapshot to complete, got %+v", status.Snapshot)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Snapshot.Scheduled || status.Snapshot.LastError != "" || status.Snapshot.ChangesSinceLastSave != 0 {
+		t.Errorf("unexpected status after the scheduled snapshot %+v", status.Snapshot)
+	}
+}
+
+func TestEchoVault_CONFIG(t *testing.T) {
+	if _, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{DataDir: t.TempDir(), EvictionPolicy: constants.NoEviction, SnapshotSave: "900"}),
+	); err == nil {
+		t.Error("expected invalid save points to be rejected on startup")
+	}
+
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{DataDir: t.TempDir(), EvictionPolicy: constants.NoEviction, SnapshotSave: "900  1 300 10"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		set        map[string]string
+		wantErr    bool
+		patterns   []string
+		wantConfig map[string]string
+	}{
+		{
+			name:       "1. Get the save points from the configuration",
+			patterns:   []string{"save"},
+			wantConfig: map[string]string{"save": "900 1 300 10"},
+		},
+		{
+			name:       "2. Get parameters matching a glob pattern",
+			patterns:   []string{"unknown", "S*"},
+			wantConfig: map[string]string{"save": "900 1 300 10"},
+		},
+		{
+			name:       "3. Set the save points",
+			set:        map[string]string{"SAVE": "60 10000 3600 1"},
+			patterns:   []string{"save"},
+			wantConfig: map[string]string{"save": "60 10000 3600 1"},
+		},
+		{
+			name:       "4. Reject save points that are not pairs",
+			set:        map[string]string{"save": "60"},
+			wantErr:    true,
+			patterns:   []string{"save"},
+			wantConfig: map[string]string{"save": "60 10000 3600 1"},
+		},
+		{
+			name:       "5. Reject save points without changes",
+			set:        map[string]string{"save": "60 0"},
+			wantErr:    true,
+			patterns:   []string{"save"},
+			wantConfig: map[string]string{"save": "60 10000 3600 1"},
+		},
+		{
+			name:       "6. Reject unknown parameters without changing the others",
+			set:        map[string]string{"save": "1 1", "unknown": "1"},
+			wantErr:    true,
+			patterns:   []string{"save"},
+			wantConfig: map[string]string{"save": "60 10000 3600 1"},
+		},
+		{
+			name:       "7. Disable automatic snapshots",
+			set:        map[string]string{"save": ""},
+			patterns:   []string{"*"},
+			wantConfig: map[string]string{"save": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set != nil {
+				res, err := server.CONFIG_SET(tt.set)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("CONFIG_SET() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil && res != "OK" {
+					t.Errorf("CONFIG_SET() got = %v, want OK", res)
+				}
+			}
+			got, err := server.CONFIG_GET(tt.patterns...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.wantConfig) {
+				t.Errorf("CONFIG_GET() got = %v, want %v", got, tt.wantConfig)
+			}
+		})
+	}
+}
+
+// freePort returns a TCP port that is free on localhost.
+func freePort(t *testing.T) uint16 {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// eventually waits for f to return true, and fails the test if it does not within 10 seconds.
+func eventually(t *testing.T, description string, f func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", description)
+}
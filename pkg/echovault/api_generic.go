@@ -67,6 +67,37 @@ type PEXPIREOptions EXPIREOptions
 type EXPIREATOptions EXPIREOptions
 type PEXPIREATOptions EXPIREOptions
 
+// RESTOREOptions modifies the behaviour of the RESTORE command.
+//
+// REPLACE - Replace the key if it already exists.
+//
+// ABSTTL - The ttl is an absolute unix time in milliseconds.
+//
+// IDLETIME - The number of seconds since the key was last accessed, used by the LRU eviction policies.
+//
+// FREQ - The access count of the key, from 0 to 255, used by the LFU eviction policies.
+// IDLETIME and FREQ cannot be used together.
+type RESTOREOptions struct {
+	REPLACE  bool
+	ABSTTL   bool
+	IDLETIME int
+	FREQ     int
+}
+
+// MIGRATEOptions modifies the behaviour of the MIGRATE command.
+//
+// COPY - Do not delete the keys from this node.
+//
+// REPLACE - Replace existing keys on the target.
+//
+// Username and Password - Authenticate with the target. Username is optional.
+type MIGRATEOptions struct {
+	COPY     bool
+	REPLACE  bool
+	Username string
+	Password string
+}
+
 // SET creates or modifies the value at the given key.
 //
 // Parameters:
@@ -402,3 +433,104 @@ func (server *EchoVault) PEXPIREAT(key string, unixMilliseconds int, options PEX
 
 	return internal.ParseIntegerResponse(b)
 }
+
+// DUMP serializes the value stored at key, including its remaining time to live.
+//
+// Parameters:
+//
+// `key` - string - the key to serialize.
+//
+// Returns: The serialized value, which can be passed to RESTORE. Returns an empty string if the key does not exist.
+func (server *EchoVault) DUMP(key string) (string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"DUMP", key}), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// RESTORE creates a key from a value serialized with DUMP.
+//
+// Parameters:
+//
+// `key` - string - the key to create.
+//
+// `ttl` - int - the time to live in milliseconds. When 0, the time to live stored in the serialized value is used.
+//
+// `value` - string - the serialized value.
+//
+// `options` - RESTOREOptions.
+//
+// Returns: "OK" if the key is restored.
+//
+// Errors:
+//
+// "BUSYKEY target key name already exists" - when the key exists and REPLACE is not set.
+//
+// "DUMP payload version or checksum are wrong" - when the serialized value is corrupted.
+func (server *EchoVault) RESTORE(key string, ttl int, value string, options RESTOREOptions) (string, error) {
+	cmd := []string{"RESTORE", key, strconv.Itoa(ttl), value}
+	if options.REPLACE {
+		cmd = append(cmd, "REPLACE")
+	}
+	if options.ABSTTL {
+		cmd = append(cmd, "ABSTTL")
+	}
+	if options.IDLETIME != 0 {
+		cmd = append(cmd, []string{"IDLETIME", strconv.Itoa(options.IDLETIME)}...)
+	}
+	if options.FREQ != 0 {
+		cmd = append(cmd, []string{"FREQ", strconv.Itoa(options.FREQ)}...)
+	}
+
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// MIGRATE moves keys to another EchoVault node. The keys are deleted from this node once the target has
+// acknowledged all of them. If the target rejects a key, the transfer stops and no key is deleted from this
+// node, although the target keeps the keys it restored before.
+//
+// Parameters:
+//
+// `host` - string - the host of the target node.
+//
+// `port` - int - the port of the target node.
+//
+// `timeout` - int - the timeout in milliseconds for connecting to the target and for each reply from it.
+//
+// `options` - MIGRATEOptions.
+//
+// `keys` - ...string - the keys to move.
+//
+// Returns: "OK" if the keys are moved, or "NOKEY" if none of the keys exist.
+//
+// Errors:
+//
+// "target could not restore key <key> after restoring keys <keys>: <reason>" - when the target rejects a key.
+func (server *EchoVault) MIGRATE(host string, port int, timeout int, options MIGRATEOptions, keys ...string) (string, error) {
+	cmd := []string{"MIGRATE", host, strconv.Itoa(port), "", "0", strconv.Itoa(timeout)}
+	if options.COPY {
+		cmd = append(cmd, "COPY")
+	}
+	if options.REPLACE {
+		cmd = append(cmd, "REPLACE")
+	}
+	switch {
+	case options.Username != "":
+		cmd = append(cmd, []string{"AUTH2", options.Username, options.Password}...)
+	case options.Password != "":
+		cmd = append(cmd, []string{"AUTH", options.Password}...)
+	}
+	cmd = append(cmd, "KEYS")
+	cmd = append(cmd, keys...)
+
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
@@ -15,9 +15,13 @@
 package echovault
 
 import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
 	"github.com/echovault/echovault/internal"
 	"github.com/echovault/echovault/internal/clock"
 	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/snapshot"
 	"github.com/echovault/echovault/pkg/commands"
 	"github.com/echovault/echovault/pkg/constants"
 	"reflect"
@@ -947,3 +951,268 @@ func TestEchoVault_TTL(t *testing.T) {
 		})
 	}
 }
+
+func TestEchoVault_DUMP_RESTORE(t *testing.T) {
+	mockClock := clock.NewClock()
+
+	server, _ := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+
+	presetKeyData(server, "key1", internal.KeyData{Value: "value1", ExpireAt: mockClock.Now().Add(20 * time.Second)})
+	presetValue(server, "key2", []interface{}{"value1", "value2"})
+
+	payload1, err := server.DUMP("key1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payload2, err := server.DUMP("key2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err = server.RESTORE("key3", 0, payload1, RESTOREOptions{}); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, _ := server.GET("key3"); got != "value1" {
+		t.Errorf("GET() got = %v, want value1", got)
+	}
+	if got, _ := server.TTL("key3"); got != 20 {
+		t.Errorf("TTL() got = %v, want 20", got)
+	}
+
+	if _, err = server.RESTORE("key2", 0, payload1, RESTOREOptions{}); err == nil {
+		t.Error("RESTORE() expected error when the key exists and REPLACE is not set")
+	}
+	if _, err = server.RESTORE("key1", 0, payload2, RESTOREOptions{REPLACE: true}); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, _ := server.LRANGE("key1", 0, -1); !reflect.DeepEqual(got, []string{"value1", "value2"}) {
+		t.Errorf("LRANGE() got = %v, want [value1 value2]", got)
+	}
+	if got, _ := server.TTL("key1"); got != -1 {
+		t.Errorf("TTL() got = %v, want -1", got)
+	}
+
+	if got, _ := server.DUMP("key4"); got != "" {
+		t.Errorf("DUMP() got = %v, want empty string for a missing key", got)
+	}
+}
+
+// encodedValue is a value with an arbitrary encoding, to build RESTORE payloads that EchoVault would not create.
+type encodedValue struct {
+	typeName string
+	data     []byte
+}
+
+func (v encodedValue) TypeName() string {
+	return v.typeName
+}
+
+func (v encodedValue) MarshalBinary() ([]byte, error) {
+	return v.data, nil
+}
+
+func TestEchoVault_RESTORE_HostilePayload(t *testing.T) {
+	server, _ := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+
+	// bloomFilter encodes a bloom filter with a single sub-filter of the given size, and no bits.
+	bloomFilter := func(filters uint32, size uint64) []byte {
+		buf := new(bytes.Buffer)
+		for _, v := range []interface{}{0.01, uint32(2), false, filters, size, uint32(7), uint64(100), uint64(0)} {
+			_ = binary.Write(buf, binary.LittleEndian, v)
+		}
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{name: "1. Bloom filter of size 0", value: encodedValue{typeName: "bloom", data: bloomFilter(1, 0)}},
+		{name: "2. Bloom filter with more sub-filters than the data holds", value: encodedValue{typeName: "bloom", data: bloomFilter(1<<31, 64)}},
+		{name: "3. Bloom filter with more bits than the data holds", value: encodedValue{typeName: "bloom", data: bloomFilter(1, 1<<40)}},
+		{name: "4. Count-min sketch of width 0", value: encodedValue{typeName: "cms", data: make([]byte, 16)}},
+		{name: "5. List that holds a list", value: []interface{}{[]interface{}{"value"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := snapshot.EncodeDump(tt.value, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = server.RESTORE("key", 0, string(payload), RESTOREOptions{REPLACE: true}); err == nil ||
+				!strings.HasPrefix(err.Error(), "malformed DUMP payload") {
+				t.Errorf("expected the payload to be rejected, got %v", err)
+			}
+			if server.KeyExists(server.context, "key") {
+				t.Error("expected the key not to be restored")
+			}
+		})
+	}
+
+	// The server keeps serving the key of a filter that was restored from a valid payload.
+	if _, err := server.handleCommand(server.context,
+		internal.EncodeCommand([]string{"BF.ADD", "bloom", "item"}), nil, false, true); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := server.DUMP("bloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.RESTORE("key", 0, payload, RESTOREOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := server.handleCommand(server.context,
+		internal.EncodeCommand([]string{"BF.EXISTS", "key", "item"}), nil, false, true)
+	if err != nil || string(res) != ":1\r\n" {
+		t.Errorf("BF.EXISTS got = %q, %v, want :1", res, err)
+	}
+}
+
+func TestEchoVault_RESTORE_IDLETIME_FREQ(t *testing.T) {
+	restore := func(t *testing.T, policy string, keys []string, options []RESTOREOptions) (*EchoVault, string) {
+		server, _ := NewEchoVault(
+			WithCommands(commands.All()),
+			WithConfig(config.Config{
+				EvictionPolicy: policy,
+				MaxMemory:      1 << 40, // Keys are tracked for eviction, but never evicted.
+			}),
+		)
+		presetValue(server, "source", "value")
+		payload, err := server.DUMP("source")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.DEL("source"); err != nil {
+			t.Fatal(err)
+		}
+		for i, key := range keys {
+			if _, err = server.RESTORE(key, 0, payload, options[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return server, payload
+	}
+
+	t.Run("FREQ sets the access count of the key", func(t *testing.T) {
+		server, _ := restore(t, constants.AllKeysLFU,
+			[]string{"warm", "hot"}, []RESTOREOptions{{}, {FREQ: 100}})
+		for i := 0; i < 5; i++ {
+			if _, err := server.GET("warm"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// The least frequently accessed key comes out first.
+		if got := heap.Pop(&server.lfuCache.cache); got != "warm" {
+			t.Errorf("expected warm to be the least frequently accessed key, got %v", got)
+		}
+	})
+
+	t.Run("IDLETIME sets the last access time of the key", func(t *testing.T) {
+		server, _ := restore(t, constants.AllKeysLRU,
+			[]string{"idle", "fresh"}, []RESTOREOptions{{IDLETIME: 1000}, {}})
+		// The keys come out in the order of their last access time, starting with the most recent.
+		if got := heap.Pop(&server.lruCache.cache); got != "fresh" {
+			t.Errorf("expected fresh to be the most recently accessed key, got %v", got)
+		}
+		if got := heap.Pop(&server.lruCache.cache); got != "idle" {
+			t.Errorf("expected idle to be the least recently accessed key, got %v", got)
+		}
+	})
+
+	t.Run("IDLETIME and FREQ cannot be used together", func(t *testing.T) {
+		server, payload := restore(t, constants.AllKeysLFU, nil, nil)
+		_, err := server.RESTORE("key", 0, payload, RESTOREOptions{IDLETIME: 10, FREQ: 10})
+		if err == nil || err.Error() != "IDLETIME and FREQ cannot be used together" {
+			t.Errorf("expected error when IDLETIME and FREQ are both set, got %v", err)
+		}
+	})
+}
+
+func TestEchoVault_MIGRATE(t *testing.T) {
+	var port uint16 = 7497
+
+	target, _ := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			BindAddr:       "localhost",
+			Port:           port,
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+	go target.Start()
+	defer target.ShutDown()
+
+	source, _ := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+	presetValue(source, "key1", "value1")
+	presetValue(source, "key2", "value2")
+
+	// Wait for the target to start listening.
+	var err error
+	for i := 0; i < 50; i++ {
+		if _, err = source.MIGRATE("localhost", int(port), 1000, MIGRATEOptions{COPY: true}, "key1"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, _ := target.GET("key1"); got != "value1" {
+		t.Errorf("GET() got = %v, want value1 on the target", got)
+	}
+	if got, _ := source.GET("key1"); got != "value1" {
+		t.Errorf("GET() got = %v, want value1 on the source after COPY", got)
+	}
+
+	got, err := source.MIGRATE("localhost", int(port), 1000, MIGRATEOptions{REPLACE: true}, "key1", "key2", "key3")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got != "OK" {
+		t.Errorf("MIGRATE() got = %v, want OK", got)
+	}
+	if got, _ := target.GET("key2"); got != "value2" {
+		t.Errorf("GET() got = %v, want value2 on the target", got)
+	}
+	for _, key := range []string{"key1", "key2"} {
+		if source.KeyExists(source.context, key) {
+			t.Errorf("expected %s to be deleted from the source after MIGRATE", key)
+		}
+	}
+
+	if got, _ = source.MIGRATE("localhost", int(port), 1000, MIGRATEOptions{}, "key1"); got != "NOKEY" {
+		t.Errorf("MIGRATE() got = %v, want NOKEY", got)
+	}
+
+	// When the target rejects a key, no key is deleted from the source.
+	presetValue(source, "key4", "value4")
+	presetValue(source, "key2", "value2")
+	if _, err = source.MIGRATE("localhost", int(port), 1000, MIGRATEOptions{}, "key4", "key2"); err == nil {
+		t.Error("MIGRATE() expected error when the target rejects a key")
+	}
+	for _, key := range []string{"key4", "key2"} {
+		if !source.KeyExists(source.context, key) {
+			t.Errorf("expected %s to be kept on the source after a failed MIGRATE", key)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"github.com/echovault/echovault/internal"
+	"strconv"
+)
+
+// ThrottleResult is the decision returned by CL_THROTTLE.
+//
+// Limited is true when the request was denied.
+//
+// Limit is the total number of requests allowed at once, i.e. maxBurst + 1.
+//
+// Remaining is the number of requests that would be allowed right now.
+//
+// RetryAfter is the number of seconds until the request would be allowed, or -1 if it was allowed.
+//
+// ResetAfter is the number of seconds until the limiter is back to its initial state.
+type ThrottleResult struct {
+	Limited    bool
+	Limit      int
+	Remaining  int
+	RetryAfter int
+	ResetAfter int
+}
+
+// CL_THROTTLE rate limits the key using the generic cell rate algorithm.
+// count requests are allowed every period seconds, with bursts of up to maxBurst requests in excess of the rate.
+// quantity is the cost of the request. The decision is made atomically at the current server time.
+//
+// Returns: A ThrottleResult describing the decision.
+//
+// Errors:
+//
+// - "value at key <key> is not a rate limiter" - when the key holds a value that was not set by CL_THROTTLE.
+func (server *EchoVault) CL_THROTTLE(key string, maxBurst, count, period, quantity uint) (ThrottleResult, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{
+		"CL.THROTTLE", key,
+		strconv.Itoa(int(maxBurst)), strconv.Itoa(int(count)), strconv.Itoa(int(period)), strconv.Itoa(int(quantity)),
+	}), nil, false, true)
+	if err != nil {
+		return ThrottleResult{}, err
+	}
+	res, err := internal.ParseIntegerArrayResponse(b)
+	if err != nil {
+		return ThrottleResult{}, err
+	}
+	return ThrottleResult{
+		Limited:    res[0] == 1,
+		Limit:      res[1],
+		Remaining:  res[2],
+		RetryAfter: res[3],
+		ResetAfter: res[4],
+	}, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"github.com/echovault/echovault/internal"
+	logstore "github.com/echovault/echovault/internal/aof/log"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/pkg/commands"
+	"github.com/echovault/echovault/pkg/constants"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEchoVault_CL_THROTTLE(t *testing.T) {
+	server, _ := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+
+	// The mock clock does not advance, so every call is made at the same instant.
+	tests := []struct {
+		name     string
+		key      string
+		quantity uint
+		want     ThrottleResult
+		wantErr  bool
+	}{
+		{
+			name:     "1. Allow the first request",
+			key:      "key1",
+			quantity: 1,
+			want:     ThrottleResult{Limited: false, Limit: 3, Remaining: 2, RetryAfter: -1, ResetAfter: 60},
+		},
+		{
+			name:     "2. Allow a burst of requests",
+			key:      "key1",
+			quantity: 2,
+			want:     ThrottleResult{Limited: false, Limit: 3, Remaining: 0, RetryAfter: -1, ResetAfter: 180},
+		},
+		{
+			name:     "3. Limit the request once the burst is used up",
+			key:      "key1",
+			quantity: 1,
+			want:     ThrottleResult{Limited: true, Limit: 3, Remaining: 0, RetryAfter: 60, ResetAfter: 180},
+		},
+		{
+			name:     "4. A request that is larger than the limit can never be allowed",
+			key:      "key2",
+			quantity: 4,
+			want:     ThrottleResult{Limited: true, Limit: 3, Remaining: 3, RetryAfter: -1, ResetAfter: 0},
+		},
+		{
+			name:     "5. Quantity 0 returns the state without using the limit",
+			key:      "key1",
+			quantity: 0,
+			want:     ThrottleResult{Limited: false, Limit: 3, Remaining: 0, RetryAfter: -1, ResetAfter: 180},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := server.CL_THROTTLE(tt.key, 2, 1, 60, tt.quantity)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CL_THROTTLE() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CL_THROTTLE() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEchoVault_CL_THROTTLELogsState(t *testing.T) {
+	dataDir := t.TempDir()
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:         dataDir,
+			EvictionPolicy:  constants.NoEviction,
+			AOFSyncStrategy: "always",
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent requests must not read the same state, so exactly max_burst + 1 of them are allowed.
+	const count = 20
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var allowedCount int
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := server.CL_THROTTLE("key", 2, 1, 60, 1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !res.Limited {
+				mutex.Lock()
+				allowedCount++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowedCount != 3 {
+		t.Errorf("expected 3 allowed requests, got %d", allowedCount)
+	}
+
+	// The AOF holds the resulting states, not the decisions, so that replaying it does not decide again.
+	data, err := os.ReadFile(path.Join(dataDir, "aof", "incr.1.aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := logstore.Check(data)
+	if len(result.Corruptions) > 0 {
+		t.Fatalf("expected no corruptions, got %d", len(result.Corruptions))
+	}
+	now := server.clock.Now()
+	var logged [][]string
+	for _, record := range result.Records {
+		cmd, err := internal.Decode(record.Command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logged = append(logged, cmd)
+	}
+	var expected [][]string
+	for i := 1; i <= 3; i++ {
+		tat := now.Add(time.Duration(i) * time.Minute)
+		expected = append(expected, []string{
+			"SET", "key", strconv.FormatInt(tat.UnixNano(), 10), "PXAT", strconv.FormatInt(tat.UnixMilli(), 10),
+		})
+	}
+	if !reflect.DeepEqual(logged, expected) {
+		t.Errorf("expected logged commands %v, got %v", expected, logged)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/search"
+	"github.com/tidwall/resp"
+	"strconv"
+)
+
+// FTField describes a hash field to be indexed by FT_CREATE.
+//
+// Type is one of "TEXT", "TAG", "NUMERIC" or "VECTOR".
+//
+// Sortable marks the field as sortable.
+//
+// Separator is the character used to split TAG values. Defaults to ",".
+//
+// Vector holds the parameters of VECTOR fields.
+type FTField struct {
+	Name      string
+	Type      string
+	Sortable  bool
+	Separator string
+	Vector    FTVectorOptions
+}
+
+// FTVectorOptions describes the index of a VECTOR field.
+// Vectors are stored in the hash as little-endian float32 blobs (see FTEncodeVector)
+// or as comma separated lists of numbers.
+//
+// Algorithm is either "FLAT" (exact brute force search) or "HNSW" (approximate graph search).
+//
+// Dim is the number of dimensions of the vectors.
+//
+// DistanceMetric is one of "COSINE", "L2" or "IP".
+//
+// M, EFConstruction and EFRuntime tune the HNSW graph. Zero values use the defaults of 16, 200 and 10.
+type FTVectorOptions struct {
+	Algorithm      string
+	Dim            uint
+	DistanceMetric string
+	M              uint
+	EFConstruction uint
+	EFRuntime      uint
+}
+
+// FTCreateOptions modifies the index created by FT_CREATE.
+//
+// Prefixes restricts the index to hashes whose keys start with one of the prefixes.
+// When empty, all hashes are indexed.
+type FTCreateOptions struct {
+	Prefixes []string
+}
+
+// FTSearchOptions modifies the result of FT_SEARCH.
+//
+// NoContent only returns the document keys.
+//
+// Return restricts the returned fields of each document.
+//
+// SortBy sorts the result by the given field. SortDesc reverses the order.
+//
+// Offset and Limit paginate the result. The default limit is 10.
+type FTSearchOptions struct {
+	NoContent bool
+	Return    []string
+	SortBy    string
+	SortDesc  bool
+	Offset    uint
+	Limit     uint
+}
+
+// FTDocument is a single document returned by FT_SEARCH.
+type FTDocument struct {
+	Key    string
+	Fields map[string]string
+}
+
+// FTSearchResult is the result of FT_SEARCH.
+// Total is the number of matching documents before pagination is applied.
+type FTSearchResult struct {
+	Total     int
+	Documents []FTDocument
+}
+
+// FT_CREATE creates a secondary index over hashes.
+//
+// Parameters:
+//
+// `index` - string - the name of the index.
+//
+// `fields` - []FTField - the schema of the index.
+//
+// `options` - FTCreateOptions.
+//
+// Returns: "OK" when the index is created.
+//
+// Errors:
+//
+// "index <index> already exists" - when an index with the same name exists.
+func (server *EchoVault) FT_CREATE(index string, fields []FTField, options FTCreateOptions) (string, error) {
+	cmd := []string{"FT.CREATE", index, "ON", "HASH"}
+	if len(options.Prefixes) > 0 {
+		cmd = append(cmd, "PREFIX", strconv.Itoa(len(options.Prefixes)))
+		cmd = append(cmd, options.Prefixes...)
+	}
+	cmd = append(cmd, "SCHEMA")
+	for _, field := range fields {
+		cmd = append(cmd, field.Name, field.Type)
+		if field.Type == search.VectorField {
+			attributes := []string{
+				"TYPE", "FLOAT32",
+				"DIM", strconv.Itoa(int(field.Vector.Dim)),
+				"DISTANCE_METRIC", field.Vector.DistanceMetric,
+			}
+			if field.Vector.M > 0 {
+				attributes = append(attributes, "M", strconv.Itoa(int(field.Vector.M)))
+			}
+			if field.Vector.EFConstruction > 0 {
+				attributes = append(attributes, "EF_CONSTRUCTION", strconv.Itoa(int(field.Vector.EFConstruction)))
+			}
+			if field.Vector.EFRuntime > 0 {
+				attributes = append(attributes, "EF_RUNTIME", strconv.Itoa(int(field.Vector.EFRuntime)))
+			}
+			cmd = append(cmd, field.Vector.Algorithm, strconv.Itoa(len(attributes)))
+			cmd = append(cmd, attributes...)
+		}
+		if field.Separator != "" {
+			cmd = append(cmd, "SEPARATOR", field.Separator)
+		}
+		if field.Sortable {
+			cmd = append(cmd, "SORTABLE")
+		}
+	}
+
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// FT_SEARCH queries the index.
+//
+// Parameters:
+//
+// `index` - string - the name of the index.
+//
+// `query` - string - the query, e.g. "@title:hello @price:[10 100] @tags:{red|blue}".
+//
+// `options` - FTSearchOptions.
+//
+// Returns: FTSearchResult with the total count and the matching documents.
+//
+// Errors:
+//
+// "unknown index <index>" - when the index does not exist.
+func (server *EchoVault) FT_SEARCH(index string, query string, options FTSearchOptions) (FTSearchResult, error) {
+	cmd := []string{"FT.SEARCH", index, query}
+	if options.NoContent {
+		cmd = append(cmd, "NOCONTENT")
+	}
+	if len(options.Return) > 0 {
+		cmd = append(cmd, "RETURN", strconv.Itoa(len(options.Return)))
+		cmd = append(cmd, options.Return...)
+	}
+	if options.SortBy != "" {
+		cmd = append(cmd, "SORTBY", options.SortBy)
+		if options.SortDesc {
+			cmd = append(cmd, "DESC")
+		}
+	}
+	if options.Limit > 0 {
+		cmd = append(cmd, "LIMIT", strconv.Itoa(int(options.Offset)), strconv.Itoa(int(options.Limit)))
+	}
+
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return FTSearchResult{}, err
+	}
+
+	r := resp.NewReader(bytes.NewReader(b))
+	v, _, err := r.ReadValue()
+	if err != nil {
+		return FTSearchResult{}, err
+	}
+
+	arr := v.Array()
+	result := FTSearchResult{Total: arr[0].Integer(), Documents: make([]FTDocument, 0)}
+	for i := 1; i < len(arr); i++ {
+		doc := FTDocument{Key: arr[i].String(), Fields: make(map[string]string)}
+		if !options.NoContent {
+			i++
+			fields := arr[i].Array()
+			for j := 0; j+1 < len(fields); j += 2 {
+				doc.Fields[fields[j].String()] = fields[j+1].String()
+			}
+		}
+		result.Documents = append(result.Documents, doc)
+	}
+	return result, nil
+}
+
+// FT_DROPINDEX deletes the index.
+//
+// Parameters:
+//
+// `index` - string - the name of the index.
+//
+// `deleteDocs` - bool - also delete the hashes held by the index.
+//
+// Returns: "OK" when the index is deleted.
+func (server *EchoVault) FT_DROPINDEX(index string, deleteDocs bool) (string, error) {
+	cmd := []string{"FT.DROPINDEX", index}
+	if deleteDocs {
+		cmd = append(cmd, "DD")
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// FTKNNOptions modifies the result of FT_KNN.
+//
+// Filter is a query that restricts the search to the matching documents,
+// e.g. "@genre:{drama} @year:[2000 +inf]". All the documents are searched when empty.
+//
+// EFRuntime overrides the candidate list size of HNSW indexes for this query.
+//
+// Return restricts the returned fields of each document. When empty, all the fields are returned.
+type FTKNNOptions struct {
+	Filter    string
+	EFRuntime uint
+	Return    []string
+}
+
+// FTNeighbour is a single document returned by FT_KNN.
+// Distance is the distance from the query vector using the metric of the field.
+type FTNeighbour struct {
+	Key      string
+	Distance float64
+	Fields   map[string]string
+}
+
+// FTEncodeVector encodes a vector as the little-endian float32 blob expected by VECTOR fields.
+func FTEncodeVector(vector []float32) string {
+	return search.EncodeVector(vector)
+}
+
+// FT_KNN returns the k documents whose vectors in the given VECTOR field are nearest to the query vector.
+//
+// Parameters:
+//
+// `index` - string - the name of the index.
+//
+// `field` - string - the VECTOR field to search.
+//
+// `vector` - []float32 - the query vector.
+//
+// `k` - uint - the number of neighbours to return.
+//
+// `options` - FTKNNOptions.
+//
+// Returns: the neighbours ordered by ascending distance.
+//
+// Errors:
+//
+// "unknown index <index>" - when the index does not exist.
+//
+// "field <field> is not a VECTOR field" - when the field is not a VECTOR field of the index.
+func (server *EchoVault) FT_KNN(index string, field string, vector []float32, k uint, options FTKNNOptions) ([]FTNeighbour, error) {
+	filter := "*"
+	if options.Filter != "" {
+		filter = fmt.Sprintf("(%s)", options.Filter)
+	}
+	const scoreField = "__knn_distance"
+	query := fmt.Sprintf("%s=>[KNN %d @%s $vector AS %s", filter, k, field, scoreField)
+	if options.EFRuntime > 0 {
+		query += fmt.Sprintf(" EF_RUNTIME %d", options.EFRuntime)
+	}
+	query += "]"
+
+	cmd := []string{"FT.SEARCH", index, query, "PARAMS", "2", "vector", search.EncodeVector(vector)}
+	if len(options.Return) > 0 {
+		cmd = append(cmd, "RETURN", strconv.Itoa(len(options.Return)+1))
+		cmd = append(cmd, options.Return...)
+		cmd = append(cmd, scoreField)
+	}
+	cmd = append(cmd, "LIMIT", "0", strconv.Itoa(int(k)))
+
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	r := resp.NewReader(bytes.NewReader(b))
+	v, _, err := r.ReadValue()
+	if err != nil {
+		return nil, err
+	}
+
+	arr := v.Array()
+	neighbours := make([]FTNeighbour, 0, len(arr)/2)
+	for i := 1; i+1 < len(arr); i += 2 {
+		neighbour := FTNeighbour{Key: arr[i].String(), Fields: make(map[string]string)}
+		fields := arr[i+1].Array()
+		for j := 0; j+1 < len(fields); j += 2 {
+			if fields[j].String() == scoreField {
+				neighbour.Distance = fields[j+1].Float()
+				continue
+			}
+			neighbour.Fields[fields[j].String()] = fields[j+1].String()
+		}
+		neighbours = append(neighbours, neighbour)
+	}
+	return neighbours, nil
+}
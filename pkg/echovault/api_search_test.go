@@ -0,0 +1,417 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/clock"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/pkg/commands"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/tidwall/resp"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEchoVault_FT_SEARCH(t *testing.T) {
+	server, _ := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+
+	if _, err := server.FT_CREATE("users", []FTField{
+		{Name: "name", Type: "TEXT"},
+		{Name: "role", Type: "TAG"},
+		{Name: "age", Type: "NUMERIC", Sortable: true},
+	}, FTCreateOptions{Prefixes: []string{"user:"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Hashes written through the hash commands are indexed.
+	for key, hash := range map[string]map[string]string{
+		"user:1": {"name": "Ada Lovelace", "role": "admin", "age": "36"},
+		"user:2": {"name": "Alan Turing", "role": "user", "age": "41"},
+		"user:3": {"name": "Grace Hopper", "role": "admin", "age": "85"},
+	} {
+		if _, err := server.HSET(key, hash); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	tests := []struct {
+		name    string
+		index   string
+		query   string
+		options FTSearchOptions
+		want    FTSearchResult
+		wantErr bool
+	}{
+		{
+			name:    "Return keys of admins sorted by age",
+			index:   "users",
+			query:   "@role:{admin}",
+			options: FTSearchOptions{NoContent: true, SortBy: "age", SortDesc: true},
+			want: FTSearchResult{Total: 2, Documents: []FTDocument{
+				{Key: "user:3", Fields: map[string]string{}},
+				{Key: "user:1", Fields: map[string]string{}},
+			}},
+			wantErr: false,
+		},
+		{
+			name:    "Return selected fields of matching documents",
+			index:   "users",
+			query:   "turing",
+			options: FTSearchOptions{Return: []string{"age"}},
+			want: FTSearchResult{Total: 1, Documents: []FTDocument{
+				{Key: "user:2", Fields: map[string]string{"age": "41"}},
+			}},
+			wantErr: false,
+		},
+		{
+			name:    "Return error on unknown index",
+			index:   "unknown",
+			query:   "*",
+			options: FTSearchOptions{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := server.FT_SEARCH(tt.index, tt.query, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FT_SEARCH() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FT_SEARCH() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := server.FT_DROPINDEX("users", false); err != nil {
+		t.Error(err)
+	}
+	if _, err := server.FT_SEARCH("users", "*", FTSearchOptions{}); err == nil {
+		t.Error("expected error after index was dropped")
+	}
+}
+
+func TestEchoVault_FT_CREATE_ConcurrentWrites(t *testing.T) {
+	server, _ := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+
+	// Write and delete documents while the index is created and backfilled.
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("doc:%d:%d", w, i)
+				if _, err := server.HSET(key, map[string]string{"kind": "doc"}); err != nil {
+					t.Error(err)
+					return
+				}
+				if i%2 == 1 {
+					if _, err := server.DEL(key); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	if _, err := server.FT_CREATE("docs", []FTField{{Name: "kind", Type: "TAG"}},
+		FTCreateOptions{Prefixes: []string{"doc:"}}); err != nil {
+		t.Error(err)
+	}
+	wg.Wait()
+
+	// Every document that was written before or after the index was created is indexed, and the
+	// deleted documents are not.
+	got, err := server.FT_SEARCH("docs", "@kind:{doc}", FTSearchOptions{NoContent: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got.Total != 400 {
+		t.Errorf("expected 400 indexed documents, got %d", got.Total)
+	}
+}
+
+func TestEchoVault_FT_KNN(t *testing.T) {
+	server, _ := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+
+	if _, err := server.FT_CREATE("movies", []FTField{
+		{Name: "genre", Type: "TAG"},
+		{Name: "embedding", Type: "VECTOR", Vector: FTVectorOptions{
+			Algorithm: "HNSW", Dim: 3, DistanceMetric: "COSINE", M: 8,
+		}},
+	}, FTCreateOptions{Prefixes: []string{"movie:"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for key, movie := range map[string]struct {
+		genre     string
+		embedding []float32
+	}{
+		"movie:1": {genre: "drama", embedding: []float32{1, 0, 0}},
+		"movie:2": {genre: "comedy", embedding: []float32{0, 1, 0}},
+		"movie:3": {genre: "drama", embedding: []float32{0, 0, 1}},
+	} {
+		if _, err := server.HSET(key, map[string]string{
+			"genre":     movie.genre,
+			"embedding": FTEncodeVector(movie.embedding),
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	tests := []struct {
+		name    string
+		index   string
+		field   string
+		vector  []float32
+		k       uint
+		options FTKNNOptions
+		want    []FTNeighbour
+		wantErr bool
+	}{
+		{
+			name:    "Return nearest neighbours ordered by distance",
+			index:   "movies",
+			field:   "embedding",
+			vector:  []float32{0, 1, 1},
+			k:       2,
+			options: FTKNNOptions{Return: []string{"genre"}},
+			want: []FTNeighbour{
+				{Key: "movie:2", Distance: 0.29289323, Fields: map[string]string{"genre": "comedy"}},
+				{Key: "movie:3", Distance: 0.29289323, Fields: map[string]string{"genre": "drama"}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Return nearest neighbours matching the filter",
+			index:   "movies",
+			field:   "embedding",
+			vector:  []float32{0, 1, 0},
+			k:       1,
+			options: FTKNNOptions{Filter: "@genre:{drama}", Return: []string{"genre"}},
+			want: []FTNeighbour{
+				{Key: "movie:1", Distance: 1, Fields: map[string]string{"genre": "drama"}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Return error when the field is not a vector field",
+			index:   "movies",
+			field:   "genre",
+			vector:  []float32{0, 1, 0},
+			k:       1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := server.FT_KNN(tt.index, tt.field, tt.vector, tt.k, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FT_KNN() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FT_KNN() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEchoVault_FT_DocumentACL(t *testing.T) {
+	// The restricted user may run every command, but only on the public keys.
+	aclConfig := path.Join(t.TempDir(), "acl.json")
+	users, err := json.Marshal([]map[string]any{
+		{
+			"Username":          "restricted",
+			"Enabled":           true,
+			"Passwords":         []map[string]string{{"PasswordType": "plaintext", "PasswordValue": "restricted-password"}},
+			"IncludedReadKeys":  []string{"public:*"},
+			"IncludedWriteKeys": []string{"public:*"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(aclConfig, users, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	port := freePort(t)
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			BindAddr:       "localhost",
+			Port:           port,
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+			RequirePass:    true,
+			Password:       "password",
+			AclConfig:      aclConfig,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Start()
+	defer server.ShutDown()
+
+	for name, prefix := range map[string]string{"public": "public:", "all": ""} {
+		if _, err = server.FT_CREATE(name, []FTField{{Name: "name", Type: "TEXT"}},
+			FTCreateOptions{Prefixes: []string{prefix}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"public:1", "secret:1"} {
+		if _, err = server.HSET(key, map[string]string{"name": key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var conn net.Conn
+	eventually(t, "the server to accept connections", func() bool {
+		conn, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		return err == nil
+	})
+	defer func() {
+		_ = conn.Close()
+	}()
+	client := resp.NewConn(conn)
+	send := func(command ...string) resp.Value {
+		args := make([]resp.Value, len(command))
+		for i, arg := range command {
+			args[i] = resp.StringValue(arg)
+		}
+		if err := client.WriteArray(args); err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := client.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	if res := send("AUTH", "restricted", "restricted-password"); res.String() != "OK" {
+		t.Fatalf("AUTH got %v, want OK", res)
+	}
+
+	tests := []struct {
+		name    string
+		command []string
+		wantErr bool
+	}{
+		{name: "1. Search the public documents", command: []string{"FT.SEARCH", "public", "*"}},
+		{name: "2. Search a secret document", command: []string{"FT.SEARCH", "all", "*"}, wantErr: true},
+		{name: "3. Aggregate a secret document", command: []string{"FT.AGGREGATE", "all", "*", "LOAD", "1", "name"}, wantErr: true},
+		{name: "4. Delete a secret document", command: []string{"FT.DROPINDEX", "all", "DD"}, wantErr: true},
+		{name: "5. Delete the public documents", command: []string{"FT.DROPINDEX", "public", "DD"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(tt.command...)
+			if tt.wantErr {
+				if res.Type() != resp.Error || !strings.Contains(res.String(), "secret:1") {
+					t.Errorf("%v got %v, want an error for secret:1", tt.command, res)
+				}
+			} else if res.Type() == resp.Error {
+				t.Errorf("%v got error %v", tt.command, res)
+			}
+		})
+	}
+
+	// The index with the secret document was kept, and only the public document was deleted.
+	if !server.KeyExists(server.context, "secret:1") {
+		t.Error("expected secret:1 to be kept")
+	}
+	if server.KeyExists(server.context, "public:1") {
+		t.Error("expected public:1 to be deleted")
+	}
+	if _, err = server.FT_SEARCH("all", "*", FTSearchOptions{}); err != nil {
+		t.Errorf("expected the index to be kept, got %v", err)
+	}
+}
+
+func TestEchoVault_FT_CREATEWithExpiredKeys(t *testing.T) {
+	mockClock := clock.NewClock()
+	server, _ := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+
+	// The expired keys are deleted by reads, which run outside write commands, while the index is backfilled.
+	// Run with -race to detect the backfill reading the store while a key is deleted.
+	for i := 0; i < 2000; i++ {
+		presetKeyData(server, fmt.Sprintf("doc:%d", i), internal.KeyData{
+			Value:    map[string]interface{}{"name": "value"},
+			ExpireAt: mockClock.Now().Add(-time.Second),
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			server.KeyExists(server.context, fmt.Sprintf("doc:%d", i))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if _, err := server.FT_CREATE(fmt.Sprintf("docs%d", i), []FTField{{Name: "name", Type: "TEXT"}},
+			FTCreateOptions{Prefixes: []string{"doc:"}}); err != nil {
+			t.Error(err)
+		}
+	}
+	<-done
+}
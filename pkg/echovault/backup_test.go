@@ -0,0 +1,207 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/pkg/commands"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEchoVault_BACKUP(t *testing.T) {
+	dataDir := t.TempDir()
+	newServer := func() *EchoVault {
+		server, err := NewEchoVault(
+			WithCommands(commands.All()),
+			WithConfig(config.Config{DataDir: dataDir, EvictionPolicy: constants.NoEviction, AOFSyncStrategy: "always"}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return server
+	}
+	server := newServer()
+
+	set := func(keys ...string) {
+		for _, key := range keys {
+			if _, err := server.SET(key, "value-"+key, SETOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	create := func(options BackupCreateOptions, want types.BackupInfo) {
+		t.Helper()
+		got, err := server.BACKUP_CREATE(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.Time = server.clock.Now().UnixMilli()
+		want.Size, want.Checksum = got.Size, got.Checksum
+		if got != want || got.Size == 0 || len(got.Checksum) != 64 {
+			t.Errorf("BACKUP_CREATE() got = %+v, want %+v", got, want)
+		}
+	}
+	assertKeys := func(want ...string) {
+		t.Helper()
+		var got []string
+		for key := range server.getState() {
+			got = append(got, key)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("expected keys %v, got %v", want, got)
+		}
+		for _, key := range want {
+			if value, err := server.GET(key); err != nil || value != "value-"+key {
+				t.Errorf("expected key %s to hold %q, got %q, %v", key, "value-"+key, value, err)
+			}
+		}
+	}
+
+	// The first backup is a full backup, even if an incremental backup is requested.
+	set("key1", "key2")
+	create(BackupCreateOptions{}, types.BackupInfo{ID: 1, Type: "full", Keys: 2})
+
+	set("key3")
+	if _, err := server.DEL("key1"); err != nil {
+		t.Fatal(err)
+	}
+	create(BackupCreateOptions{}, types.BackupInfo{ID: 2, Type: "incremental", Parent: 1, Keys: 1, DeletedKeys: 1})
+
+	set("key4")
+	create(BackupCreateOptions{DIFFERENTIAL: true}, types.BackupInfo{ID: 3, Type: "differential", Parent: 1, Keys: 2, DeletedKeys: 1})
+
+	set("key5")
+	create(BackupCreateOptions{}, types.BackupInfo{ID: 4, Type: "incremental", Parent: 3, Keys: 1})
+
+	backups, err := server.BACKUP_LIST()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := []int64{1, 2, 3, 4}; len(backups) != len(ids) || !slices.EqualFunc(backups, ids, func(b types.BackupInfo, id int64) bool {
+		return b.ID == id && b.Checksum != ""
+	}) {
+		t.Errorf("expected backups %v, got %+v", ids, backups)
+	}
+
+	checks, err := server.BACKUP_VERIFY(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []types.BackupCheck{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}; !slices.Equal(checks, want) {
+		t.Errorf("BACKUP_VERIFY() got = %+v, want %+v", checks, want)
+	}
+
+	// Restoring replays the full backup and the deltas up to the chosen backup.
+	if _, err = server.BACKUP_RESTORE(2); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys("key2", "key3")
+	if _, err = server.BACKUP_RESTORE(0); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys("key2", "key3", "key4", "key5")
+
+	// A corrupted backup fails the verification of the backups that depend on it, and is not restored.
+	b, err := os.ReadFile(path.Join(dataDir, "backups", "backup-3.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	if err = os.WriteFile(path.Join(dataDir, "backups", "backup-3.bin"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if checks, err = server.BACKUP_VERIFY(4); err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 3 || checks[0] != (types.BackupCheck{ID: 1}) || !strings.Contains(checks[1].Error, "checksum mismatch") ||
+		checks[2] != (types.BackupCheck{ID: 4}) {
+		t.Errorf("expected backup 3 to fail the verification, got %+v", checks)
+	}
+	if checks, err = server.BACKUP_VERIFY(2); err != nil || !slices.Equal(checks, []types.BackupCheck{{ID: 1}, {ID: 2}}) {
+		t.Errorf("expected the backups of backup 2 to be intact, got %+v, %v", checks, err)
+	}
+	if _, err = server.DEL("key5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.BACKUP_RESTORE(4); err == nil {
+		t.Error("expected the restore of a corrupted backup to fail")
+	}
+	assertKeys("key2", "key3", "key4")
+	if _, err = server.BACKUP_VERIFY(10); err == nil {
+		t.Error("expected the verification of an unknown backup to fail")
+	}
+
+	// The changes are not tracked across restarts, so the first backup after a restart is a full backup.
+	server = newServer()
+	set("key6")
+	create(BackupCreateOptions{}, types.BackupInfo{ID: 5, Type: "full", Keys: 1})
+}
+
+func TestEchoVault_BACKUPInPlaceWrites(t *testing.T) {
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{DataDir: t.TempDir(), EvictionPolicy: constants.NoEviction}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = server.SADD("set", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.LPUSH("list", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.BACKUP_CREATE(BackupCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The commands change the values of the existing keys in place.
+	if _, err = server.SADD("set", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.LPUSH("list", "b"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := server.BACKUP_CREATE(BackupCreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != "incremental" || info.Keys != 2 {
+		t.Errorf("expected an incremental backup of 2 keys, got %+v", info)
+	}
+
+	if _, err = server.BACKUP_RESTORE(0); err != nil {
+		t.Fatal(err)
+	}
+	members, err := server.SMEMBERS("set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(members)
+	if !slices.Equal(members, []string{"a", "b"}) {
+		t.Errorf("SMEMBERS() got = %v, want [a b]", members)
+	}
+	if elements, err := server.LRANGE("list", 0, -1); err != nil || !slices.Equal(elements, []string{"b", "a"}) {
+		t.Errorf("LRANGE() got = %v, %v, want [b a]", elements, err)
+	}
+}
@@ -17,13 +17,171 @@ package echovault
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/memberlist"
+	"github.com/echovault/echovault/pkg/types"
+	"slices"
 	"time"
 )
 
 func (server *EchoVault) isInCluster() bool {
-	return server.config.BootstrapCluster || server.config.JoinAddr != ""
+	return server.config.BootstrapCluster || server.joinsCluster()
+}
+
+// joinsCluster reports whether the node joins an existing cluster through seeds or DNS discovery.
+func (server *EchoVault) joinsCluster() bool {
+	return server.config.JoinAddr != "" || server.config.DiscoverySRV != "" || server.config.DiscoveryDNS != ""
+}
+
+// GetClusterMembers returns the voters and learners in the raft configuration of the cluster.
+func (server *EchoVault) GetClusterMembers() ([]types.ClusterMember, error) {
+	if !server.isInCluster() {
+		return nil, errors.New("cluster members are only available in cluster mode")
+	}
+	return server.raft.Members()
+}
+
+// PromoteClusterMember makes a learner a voter. It must be called on the leader.
+func (server *EchoVault) PromoteClusterMember(id string) error {
+	if !server.isInCluster() {
+		return errors.New("cluster members can only be promoted in cluster mode")
+	}
+	return server.raft.PromoteMember(id)
+}
+
+// DemoteClusterMember makes a voter a learner. It must be called on the leader.
+func (server *EchoVault) DemoteClusterMember(id string) error {
+	if !server.isInCluster() {
+		return errors.New("cluster members can only be demoted in cluster mode")
+	}
+	return server.raft.DemoteMember(id)
+}
+
+// GetClusterInfo returns the raft state of the node.
+func (server *EchoVault) GetClusterInfo() (types.ClusterInfo, error) {
+	if !server.isInCluster() {
+		return types.ClusterInfo{}, errors.New("cluster info is only available in cluster mode")
+	}
+	info, err := server.raft.Info()
+	if err != nil {
+		return types.ClusterInfo{}, err
+	}
+	info.LastSnapshotTime = server.GetLatestSnapshotTime()
+	return info, nil
+}
+
+// GetClusterNodes returns the members of the raft configuration followed by the nodes that are only in the
+// memberlist. A member that the memberlist lost is reported as failed.
+func (server *EchoVault) GetClusterNodes() ([]types.ClusterNode, error) {
+	if !server.isInCluster() {
+		return nil, errors.New("cluster nodes are only available in cluster mode")
+	}
+	members, err := server.raft.Members()
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]memberlist.NodeState)
+	for _, state := range server.memberList.Nodes() {
+		states[string(state.Meta.ServerID)] = state
+	}
+
+	nodes := make([]types.ClusterNode, 0, len(members)+len(states))
+	for _, member := range members {
+		node := types.ClusterNode{
+			ID:          member.ID,
+			RaftAddress: member.Address,
+			Suffrage:    member.Suffrage,
+			Leader:      member.Leader,
+			Health:      "failed",
+			LastContact: member.LastContact,
+		}
+		if state, ok := states[member.ID]; ok {
+			node.MemberlistAddress = state.Address
+			node.Health = state.Health
+			delete(states, member.ID)
+		}
+		nodes = append(nodes, node)
+	}
+	ids := make([]string, 0, len(states))
+	for id := range states {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		nodes = append(nodes, types.ClusterNode{
+			ID:                id,
+			RaftAddress:       string(states[id].Meta.RaftAddr),
+			MemberlistAddress: states[id].Address,
+			Suffrage:          "none",
+			Health:            states[id].Health,
+		})
+	}
+	return nodes, nil
+}
+
+// ForgetClusterMember removes a failed node from the raft configuration. It must be called on the leader.
+// A node that is still alive must leave the cluster with LeaveCluster instead.
+func (server *EchoVault) ForgetClusterMember(id string) error {
+	if !server.isInCluster() {
+		return errors.New("cluster members can only be forgotten in cluster mode")
+	}
+	for _, state := range server.memberList.Nodes() {
+		if string(state.Meta.ServerID) == id && state.Health == "alive" {
+			return fmt.Errorf("member %s is alive, use CLUSTER LEAVE on the member instead", id)
+		}
+	}
+	return server.raft.ForgetMember(id)
+}
+
+// FailoverCluster transfers the leadership to the voter with the given ID, or to the most up to date voter
+// if the ID is empty. It must be called on the leader.
+func (server *EchoVault) FailoverCluster(id string) error {
+	if !server.isInCluster() {
+		return errors.New("failover is only available in cluster mode")
+	}
+	return server.raft.TransferLeadership(id)
+}
+
+// LeaveCluster gracefully removes the node from the cluster. A leader transfers its leadership first.
+// The node leaves the memberlist, which makes the leader remove it from the raft configuration,
+// and stops its raft node. It keeps serving reads of its last state until it is shut down.
+func (server *EchoVault) LeaveCluster() error {
+	if !server.isInCluster() {
+		return errors.New("can only leave a cluster in cluster mode")
+	}
+	if server.raft.IsRaftLeader() {
+		if err := server.raft.TransferLeadership(""); err != nil {
+			return fmt.Errorf("transfer leadership: %w", err)
+		}
+	}
+	if err := server.memberList.Leave(); err != nil {
+		return err
+	}
+	return server.raft.Leave()
+}
+
+// GetGossipKeys returns the keys of the keyring that encrypts the memberlist gossip of the node.
+func (server *EchoVault) GetGossipKeys() ([]types.GossipKey, error) {
+	if !server.isInCluster() {
+		return nil, errors.New("gossip keys are only available in cluster mode")
+	}
+	return server.memberList.GossipKeys(), nil
+}
+
+// ReloadGossipKeys replaces the keys of the gossip keyring of the node with the keys of the gossip key file
+// and environment variable. The last key becomes the primary key.
+func (server *EchoVault) ReloadGossipKeys() error {
+	if !server.isInCluster() {
+		return errors.New("gossip keys are only available in cluster mode")
+	}
+	keys, err := encryption.LoadKeys(server.config.GossipKeyFile, server.config.GossipKeyEnv)
+	if err != nil {
+		return err
+	}
+	return server.memberList.SetGossipKeys(keys)
 }
 
 func (server *EchoVault) raftApplyDeleteKey(ctx context.Context, key string) error {
@@ -94,3 +252,35 @@ func (server *EchoVault) raftApplyCommand(ctx context.Context, cmd []string) ([]
 
 	return r.Response, nil
 }
+
+// raftApplyReplication applies a REPLICATION command from the primary cluster on every node of the replica cluster.
+func (server *EchoVault) raftApplyReplication(cmd []string) ([]byte, error) {
+	b, err := json.Marshal(internal.ApplyRequest{
+		Type:         "replication",
+		ServerID:     server.config.ServerID,
+		ConnectionID: "nil",
+		CMD:          cmd,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not parse replication request for command: %s", cmd[1])
+	}
+
+	// A batch of entries takes longer to apply than a single command.
+	applyFuture := server.raft.Apply(b, 10*time.Second)
+
+	if err = applyFuture.Error(); err != nil {
+		return nil, err
+	}
+
+	r, ok := applyFuture.Response().(internal.ApplyResponse)
+
+	if !ok {
+		return nil, fmt.Errorf("unprocessable entity %v", r)
+	}
+
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return r.Response, nil
+}
@@ -0,0 +1,817 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/encryption"
+	"github.com/echovault/echovault/internal/snapshot"
+	"github.com/echovault/echovault/pkg/commands"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/miekg/dns"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEchoVault_RestoreClusterData(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// Write a raft snapshot at index 3, as a cluster node would.
+	snapshotStore, err := raft.NewFileSnapshotStore(dataDir, 1, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := snapshotStore.Create(raft.SnapshotVersionMax, 3, 1, raft.Configuration{}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder, err := snapshot.NewEncoder(sink, snapshot.CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.WriteMeta(1000); err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.WriteState(map[string]internal.KeyData{
+		"key1": {Value: "value1"},
+		"key2": {Value: "value2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write the raft log. The entries up to index 3 are already included in the snapshot.
+	command := func(index uint64, request internal.ApplyRequest) *raft.Log {
+		b, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &raft.Log{Index: index, Term: 1, Type: raft.LogCommand, Data: b}
+	}
+	logStore, err := raftboltdb.NewBoltStore(path.Join(dataDir, "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = logStore.StoreLogs([]*raft.Log{
+		command(2, internal.ApplyRequest{Type: "command", CMD: []string{"SET", "key1", "stale"}}),
+		command(3, internal.ApplyRequest{Type: "command", CMD: []string{"SET", "key1", "value1"}}),
+		{Index: 4, Term: 1, Type: raft.LogNoop},
+		command(5, internal.ApplyRequest{Type: "command", CMD: []string{"SET", "key3", "value3"}}),
+		command(6, internal.ApplyRequest{Type: "delete-key", Key: "key2"}),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = logStore.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := config.Config{
+		DataDir:            dataDir,
+		EvictionPolicy:     constants.NoEviction,
+		RestoreClusterData: true,
+	}
+	server, err := NewEchoVault(WithCommands(commands.All()), WithConfig(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"key1": "value1", "key2": "", "key3": "value3"}
+	for key, value := range want {
+		if got, _ := server.GET(key); got != value {
+			t.Errorf("GET(%s) got = %v, want %v", key, got, value)
+		}
+	}
+	if msec, _ := server.LASTSAVE(); msec != 1000 {
+		t.Errorf("LASTSAVE() got = %d, want 1000", msec)
+	}
+
+	// The AOF is rewritten from the restored state.
+	server.ShutDown()
+	conf.RestoreClusterData = false
+	conf.RestoreAOF = true
+	restored, err := NewEchoVault(WithCommands(commands.All()), WithConfig(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range want {
+		if got, _ := restored.GET(key); got != value {
+			t.Errorf("GET(%s) from AOF got = %v, want %v", key, got, value)
+		}
+	}
+}
+
+func TestEchoVault_RestoreReplicaClusterData(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// Write the raft log of a node of a replica cluster, which holds the entries shipped by the primary.
+	entry := func(request internal.ApplyRequest) string {
+		b, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	replication := func(index uint64, cmd ...string) *raft.Log {
+		b, err := json.Marshal(internal.ApplyRequest{Type: "replication", CMD: cmd})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &raft.Log{Index: index, Term: 1, Type: raft.LogCommand, Data: b}
+	}
+	logStore, err := raftboltdb.NewBoltStore(path.Join(dataDir, "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = logStore.StoreLogs([]*raft.Log{
+		replication(1, "REPLICATION", "APPLY", "1", "2", "2",
+			entry(internal.ApplyRequest{Type: "command", CMD: []string{"SET", "key1", "value1"}}),
+			entry(internal.ApplyRequest{Type: "command", CMD: []string{"SET", "key2", "value2"}}),
+		),
+		replication(2, "REPLICATION", "APPLY", "3", "3", "3",
+			entry(internal.ApplyRequest{Type: "delete-key", Key: "key2"}),
+		),
+		replication(3, "REPLICATION", "PROMOTE"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = logStore.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:            dataDir,
+			EvictionPolicy:     constants.NoEviction,
+			RestoreClusterData: true,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.ShutDown()
+
+	want := map[string]string{"key1": "value1", "key2": ""}
+	for key, value := range want {
+		if got, _ := server.GET(key); got != value {
+			t.Errorf("GET(%s) got = %v, want %v", key, got, value)
+		}
+	}
+}
+
+func TestEchoVault_ClusterLearner(t *testing.T) {
+
+	standalone, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:        t.TempDir(),
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = standalone.CLUSTER_MEMBERS(); err == nil {
+		t.Error("expected CLUSTER_MEMBERS to fail in standalone mode")
+	}
+
+	if _, err = NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			ServerID:         "learner",
+			BindAddr:         "localhost",
+			InMemory:         true,
+			BootstrapCluster: true,
+			Learner:          true,
+			EvictionPolicy:   constants.NoEviction,
+		}),
+	); err == nil {
+		t.Error("expected a learner that bootstraps a cluster to be rejected")
+	}
+
+	// The leader is not shut down, as a single voter cannot transfer its leadership.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	leaderMemberListPort := freePort(t)
+	leader, err := NewEchoVault(
+		WithContext(ctx),
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			ServerID:           "leader",
+			BindAddr:           "localhost",
+			Port:               freePort(t),
+			RaftBindPort:       freePort(t),
+			MemberListBindPort: leaderMemberListPort,
+			InMemory:           true,
+			BootstrapCluster:   true,
+			DataDir:            t.TempDir(),
+			EvictionPolicy:     constants.NoEviction,
+			SnapShotThreshold:  1000,
+			SnapshotInterval:   5 * time.Minute,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the leader to accept writes", func() bool {
+		_, err := leader.SET("key1", "value1", SETOptions{})
+		return err == nil
+	})
+
+	learner, err := NewEchoVault(
+		WithContext(ctx),
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			ServerID:           "learner",
+			JoinAddr:           fmt.Sprintf("localhost:%d", leaderMemberListPort),
+			BindAddr:           "localhost",
+			Port:               freePort(t),
+			RaftBindPort:       freePort(t),
+			MemberListBindPort: freePort(t),
+			InMemory:           true,
+			Learner:            true,
+			DataDir:            t.TempDir(),
+			EvictionPolicy:     constants.NoEviction,
+			SnapShotThreshold:  1000,
+			SnapshotInterval:   5 * time.Minute,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suffrageOf := func(server *EchoVault, id string) string {
+		members, err := server.CLUSTER_MEMBERS()
+		if err != nil {
+			return ""
+		}
+		for _, member := range members {
+			if member.ID == id {
+				return member.Suffrage
+			}
+		}
+		return ""
+	}
+
+	eventually(t, "the learner to join as a non-voter", func() bool {
+		return suffrageOf(leader, "learner") == "learner"
+	})
+
+	// The learner receives the log and serves reads.
+	if _, err = leader.SET("key2", "value2", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the learner to serve the writes of the leader", func() bool {
+		value1, _ := learner.GET("key1")
+		value2, _ := learner.GET("key2")
+		return value1 == "value1" && value2 == "value2"
+	})
+
+	eventually(t, "the leader to be in contact with the learner", func() bool {
+		members, err := leader.CLUSTER_MEMBERS()
+		if err != nil || len(members) != 2 {
+			return false
+		}
+		for _, member := range members {
+			if member.LastContact == 0 || member.Leader != (member.ID == "leader") {
+				return false
+			}
+		}
+		return true
+	})
+	members, err := learner.CLUSTER_MEMBERS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range members {
+		if member.ID == "leader" && (!member.Leader || member.Suffrage != "voter" || member.LastContact == 0) {
+			t.Errorf("unexpected leader member %+v", member)
+		}
+	}
+
+	// Only the leader changes the membership.
+	if _, err = learner.CLUSTER_PROMOTE("learner"); err == nil {
+		t.Error("expected CLUSTER_PROMOTE to fail on a learner")
+	}
+	if _, err = leader.CLUSTER_PROMOTE("unknown"); err == nil {
+		t.Error("expected CLUSTER_PROMOTE to fail for an unknown member")
+	}
+	if _, err = leader.CLUSTER_DEMOTE("learner"); err == nil {
+		t.Error("expected CLUSTER_DEMOTE to fail for a learner")
+	}
+	if _, err = leader.CLUSTER_DEMOTE("leader"); err == nil {
+		t.Error("expected CLUSTER_DEMOTE to fail for the leader")
+	}
+
+	if got, err := leader.CLUSTER_PROMOTE("learner"); err != nil || got != "OK" {
+		t.Fatalf("CLUSTER_PROMOTE() got = %v, %v, want OK", got, err)
+	}
+	if suffrage := suffrageOf(leader, "learner"); suffrage != "voter" {
+		t.Errorf("expected the promoted learner to be a voter, got %s", suffrage)
+	}
+	if got, err := leader.CLUSTER_DEMOTE("learner"); err != nil || got != "OK" {
+		t.Fatalf("CLUSTER_DEMOTE() got = %v, %v, want OK", got, err)
+	}
+	if suffrage := suffrageOf(leader, "learner"); suffrage != "learner" {
+		t.Errorf("expected the demoted voter to be a learner, got %s", suffrage)
+	}
+}
+
+func TestEchoVault_ClusterAdministration(t *testing.T) {
+	standalone, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:        t.TempDir(),
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = standalone.CLUSTER_INFO(); err == nil {
+		t.Error("expected CLUSTER_INFO to fail in standalone mode")
+	}
+	if _, err = standalone.CLUSTER_LEAVE(); err == nil {
+		t.Error("expected CLUSTER_LEAVE to fail in standalone mode")
+	}
+
+	// The nodes are not shut down, as the last voter cannot transfer its leadership.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node := func(id string, joinAddr string, memberListPort uint16) *EchoVault {
+		server, err := NewEchoVault(
+			WithContext(ctx),
+			WithCommands(commands.All()),
+			WithConfig(config.Config{
+				ServerID:           id,
+				JoinAddr:           joinAddr,
+				BindAddr:           "localhost",
+				Port:               freePort(t),
+				RaftBindPort:       freePort(t),
+				MemberListBindPort: memberListPort,
+				InMemory:           true,
+				BootstrapCluster:   joinAddr == "",
+				DataDir:            t.TempDir(),
+				EvictionPolicy:     constants.NoEviction,
+				SnapShotThreshold:  1000,
+				SnapshotInterval:   5 * time.Minute,
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return server
+	}
+
+	memberListPort := freePort(t)
+	node1 := node("node1", "", memberListPort)
+	eventually(t, "node1 to accept writes", func() bool {
+		_, err := node1.SET("key1", "value1", SETOptions{})
+		return err == nil
+	})
+	node2 := node("node2", fmt.Sprintf("localhost:%d", memberListPort), freePort(t))
+	eventually(t, "node2 to join as a voter", func() bool {
+		info, err := node1.CLUSTER_INFO()
+		return err == nil && info.Voters == 2
+	})
+	eventually(t, "node2 to apply the log", func() bool {
+		value, _ := node2.GET("key1")
+		return value == "value1"
+	})
+
+	info, err := node1.CLUSTER_INFO()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != "leader" || info.LeaderID != "node1" || info.Term == 0 || info.CommitIndex == 0 ||
+		info.AppliedIndex == 0 || info.LastLogIndex < info.CommitIndex || info.Learners != 0 {
+		t.Errorf("unexpected leader info %+v", info)
+	}
+	info, err = node2.CLUSTER_INFO()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != "follower" || info.LeaderID != "node1" || info.Voters != 2 {
+		t.Errorf("unexpected follower info %+v", info)
+	}
+
+	// A member that the memberlist does not know is reported as failed, and can be forgotten.
+	if err = node1.raft.AddNonvoter("ghost", "127.0.0.1:1", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := node1.CLUSTER_NODES()
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := make(map[string]string)
+	for _, n := range nodes {
+		health[n.ID] = n.Health
+		if n.ID == "node2" && (n.Suffrage != "voter" || n.MemberlistAddress == "" || n.Leader) {
+			t.Errorf("unexpected node %+v", n)
+		}
+	}
+	if !reflect.DeepEqual(health, map[string]string{"node1": "alive", "node2": "alive", "ghost": "failed"}) {
+		t.Errorf("unexpected node health %v", health)
+	}
+	if _, err = node1.CLUSTER_FORGET("node2"); err == nil {
+		t.Error("expected CLUSTER_FORGET to fail for a member that is alive")
+	}
+	if _, err = node2.CLUSTER_FORGET("ghost"); err == nil {
+		t.Error("expected CLUSTER_FORGET to fail on a follower")
+	}
+	if got, err := node1.CLUSTER_FORGET("ghost"); err != nil || got != "OK" {
+		t.Fatalf("CLUSTER_FORGET() got = %v, %v, want OK", got, err)
+	}
+	if members, _ := node1.CLUSTER_MEMBERS(); len(members) != 2 {
+		t.Errorf("expected 2 members after forgetting the failed member, got %+v", members)
+	}
+
+	// The leadership is transferred to node2.
+	if _, err = node2.CLUSTER_FAILOVER("node1"); err == nil {
+		t.Error("expected CLUSTER_FAILOVER to fail on a follower")
+	}
+	if got, err := node1.CLUSTER_FAILOVER("node2"); err != nil || got != "OK" {
+		t.Fatalf("CLUSTER_FAILOVER() got = %v, %v, want OK", got, err)
+	}
+	eventually(t, "node2 to become the leader", func() bool {
+		info, err := node2.CLUSTER_INFO()
+		return err == nil && info.State == "leader"
+	})
+
+	// node1 leaves the cluster, and keeps serving reads of its last state.
+	if got, err := node1.CLUSTER_LEAVE(); err != nil || got != "OK" {
+		t.Fatalf("CLUSTER_LEAVE() got = %v, %v, want OK", got, err)
+	}
+	eventually(t, "node2 to remove node1", func() bool {
+		nodes, err := node2.CLUSTER_NODES()
+		return err == nil && len(nodes) == 1 && nodes[0].ID == "node2"
+	})
+	if value, _ := node1.GET("key1"); value != "value1" {
+		t.Errorf("expected node1 to serve reads after leaving, got %q", value)
+	}
+	if _, err = node1.CLUSTER_LEAVE(); err == nil {
+		t.Error("expected CLUSTER_LEAVE to fail once the node has left")
+	}
+}
+
+func TestEchoVault_ClusterDiscovery(t *testing.T) {
+	// The DNS records are served by a local resolver.
+	leaderMemberListPort := freePort(t)
+	records := map[uint16]map[string][]string{
+		dns.TypeSRV: {
+			"_echovault._udp.cluster.test.": {
+				fmt.Sprintf("_echovault._udp.cluster.test. 60 IN SRV 0 0 %d seed.cluster.test.", leaderMemberListPort),
+			},
+		},
+		dns.TypeA: {
+			"seed.cluster.test.":  {"seed.cluster.test. 60 IN A 127.0.0.1"},
+			"nodes.cluster.test.": {"nodes.cluster.test. 60 IN A 127.0.0.1"},
+		},
+	}
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &dns.Server{
+		PacketConn: packetConn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			for _, question := range r.Question {
+				for _, record := range records[question.Qtype][strings.ToLower(question.Name)] {
+					rr, err := dns.NewRR(record)
+					if err != nil {
+						t.Error(err)
+						continue
+					}
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+			_ = w.WriteMsg(m)
+		}),
+	}
+	go func() {
+		_ = resolver.ActivateAndServe()
+	}()
+	defer func() {
+		_ = resolver.Shutdown()
+	}()
+
+	// The nodes are not shut down, as the last voter cannot transfer its leadership.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node := func(conf config.Config) *EchoVault {
+		conf.BindAddr = "localhost"
+		conf.Port = freePort(t)
+		conf.RaftBindPort = freePort(t)
+		if conf.MemberListBindPort == 0 {
+			conf.MemberListBindPort = freePort(t)
+		}
+		conf.InMemory = true
+		conf.DataDir = t.TempDir()
+		conf.EvictionPolicy = constants.NoEviction
+		conf.SnapShotThreshold = 1000
+		conf.SnapshotInterval = 5 * time.Minute
+		conf.DiscoveryResolver = packetConn.LocalAddr().String()
+		server, err := NewEchoVault(WithContext(ctx), WithCommands(commands.All()), WithConfig(conf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return server
+	}
+
+	leader := node(config.Config{ServerID: "leader", BootstrapCluster: true, MemberListBindPort: leaderMemberListPort})
+	eventually(t, "the leader to accept writes", func() bool {
+		_, err := leader.SET("key1", "value1", SETOptions{})
+		return err == nil
+	})
+
+	// The seed is down, so the node falls through to the SRV records.
+	node(config.Config{
+		ServerID:     "srv",
+		JoinAddr:     fmt.Sprintf("localhost:%d", freePort(t)),
+		DiscoverySRV: "_echovault._udp.cluster.test",
+	})
+	eventually(t, "the node discovered with SRV records to join", func() bool {
+		info, err := leader.CLUSTER_INFO()
+		return err == nil && info.Voters == 2
+	})
+
+	// The A records have no port, so the port is given with the name.
+	node(config.Config{
+		ServerID:     "a",
+		DiscoveryDNS: fmt.Sprintf("nodes.cluster.test:%d", leaderMemberListPort),
+		Learner:      true,
+	})
+	eventually(t, "the node discovered with A records to join", func() bool {
+		info, err := leader.CLUSTER_INFO()
+		return err == nil && info.Learners == 1
+	})
+}
+
+func TestEchoVault_ClusterEncryption(t *testing.T) {
+	if _, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			ServerID:          "node1",
+			BindAddr:          "localhost",
+			InMemory:          true,
+			BootstrapCluster:  true,
+			RequireClusterTLS: true,
+			RaftTLS:           true,
+			EvictionPolicy:    constants.NoEviction,
+		}),
+	); err == nil {
+		t.Error("expected a node that requires cluster encryption without gossip keys to be rejected")
+	}
+
+	key1 := strings.Repeat("1", 64)
+	key2 := strings.Repeat("2", 64)
+	keyID := func(key string) string {
+		b, _ := hex.DecodeString(key)
+		return encryption.NewKeyID(b).String()
+	}
+	writeKeys := func(file string, keys ...string) {
+		if err := os.WriteFile(file, []byte(strings.Join(keys, "\n")), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The nodes are not shut down, as the last voter cannot transfer its leadership.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node := func(id string, cert string, joinAddr string, memberListPort uint16) (*EchoVault, string) {
+		keyFile := filepath.Join(t.TempDir(), "gossip.keys")
+		writeKeys(keyFile, key1)
+		server, err := NewEchoVault(
+			WithContext(ctx),
+			WithCommands(commands.All()),
+			WithConfig(config.Config{
+				ServerID:           id,
+				JoinAddr:           joinAddr,
+				BindAddr:           "localhost",
+				Port:               freePort(t),
+				RaftBindPort:       freePort(t),
+				MemberListBindPort: memberListPort,
+				InMemory:           true,
+				BootstrapCluster:   joinAddr == "",
+				ForwardCommand:     true,
+				DataDir:            t.TempDir(),
+				EvictionPolicy:     constants.NoEviction,
+				SnapShotThreshold:  1000,
+				SnapshotInterval:   5 * time.Minute,
+				RaftTLS:            true,
+				CertKeyPairs: [][]string{{
+					fmt.Sprintf("../../openssl/server/%s.crt", cert),
+					fmt.Sprintf("../../openssl/server/%s.key", cert),
+				}},
+				ClientCAs:         []string{"../../openssl/server/rootCA.crt"},
+				GossipKeyFile:     keyFile,
+				RequireClusterTLS: true,
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return server, keyFile
+	}
+
+	memberListPort := freePort(t)
+	node1, keyFile1 := node("node1", "server1", "", memberListPort)
+	eventually(t, "node1 to accept writes", func() bool {
+		_, err := node1.SET("key1", "value1", SETOptions{})
+		return err == nil
+	})
+	node2, keyFile2 := node("node2", "server2", fmt.Sprintf("localhost:%d", memberListPort), freePort(t))
+
+	// The raft log is replicated over mTLS, and node2 forwards writes to the leader over the encrypted gossip.
+	eventually(t, "node2 to replicate the log", func() bool {
+		value, _ := node2.GET("key1")
+		return value == "value1"
+	})
+	forward := func(key string) {
+		t.Helper()
+		if _, err := node2.SET(key, "value", SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, fmt.Sprintf("the write of %s to be forwarded", key), func() bool {
+			value, _ := node1.GET(key)
+			return value == "value"
+		})
+	}
+	forward("key2")
+
+	// The primary key is rotated on every node in three steps.
+	steps := []struct {
+		keys    []string
+		primary string
+	}{
+		{keys: []string{key2, key1}, primary: key1},
+		{keys: []string{key1, key2}, primary: key2},
+		{keys: []string{key2}, primary: key2},
+	}
+	for i, step := range steps {
+		for _, n := range []struct {
+			server  *EchoVault
+			keyFile string
+		}{{node1, keyFile1}, {node2, keyFile2}} {
+			writeKeys(n.keyFile, step.keys...)
+			keys, err := n.server.CLUSTER_KEYRING(true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != len(step.keys) {
+				t.Errorf("step %d: expected %d keys, got %+v", i, len(step.keys), keys)
+			}
+			for _, key := range keys {
+				if key.Primary != (key.ID == keyID(step.primary)) {
+					t.Errorf("step %d: expected the primary key to be %s, got %+v", i, keyID(step.primary), keys)
+				}
+			}
+		}
+		forward(fmt.Sprintf("key%d", i+3))
+	}
+
+	writeKeys(keyFile1)
+	if _, err := node1.CLUSTER_KEYRING(true); err == nil {
+		t.Error("expected the reload of an empty key file to fail")
+	}
+	if keys, _ := node1.CLUSTER_KEYRING(false); len(keys) != 1 || keys[0].ID != keyID(key2) || !keys[0].Primary {
+		t.Errorf("expected the keyring to be unchanged, got %+v", keys)
+	}
+}
+
+func TestEchoVault_ClusterMIGRATE(t *testing.T) {
+	target, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			BindAddr:       "localhost",
+			Port:           freePort(t),
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go target.Start()
+	defer target.ShutDown()
+
+	// The leader is not shut down, as a single voter cannot transfer its leadership.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	leader, err := NewEchoVault(
+		WithContext(ctx),
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			ServerID:           "leader",
+			BindAddr:           "localhost",
+			Port:               freePort(t),
+			RaftBindPort:       freePort(t),
+			MemberListBindPort: freePort(t),
+			InMemory:           true,
+			BootstrapCluster:   true,
+			DataDir:            t.TempDir(),
+			EvictionPolicy:     constants.NoEviction,
+			SnapShotThreshold:  1000,
+			SnapshotInterval:   5 * time.Minute,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the leader to accept writes", func() bool {
+		_, err := leader.MSET(map[string]string{"key1": "value1", "key2": "value2"})
+		return err == nil
+	})
+
+	// The keys stay locked while they are deleted through raft, and the deletion is applied on the leader too.
+	eventually(t, "the target to accept the keys", func() bool {
+		_, err := leader.MIGRATE("localhost", int(target.config.Port), 1000, MIGRATEOptions{}, "key1", "key2")
+		return err == nil
+	})
+	for _, key := range []string{"key1", "key2"} {
+		if leader.KeyExists(leader.context, key) {
+			t.Errorf("expected %s to be deleted from the leader after MIGRATE", key)
+		}
+		if got, _ := target.GET(key); got == "" {
+			t.Errorf("expected %s to be restored on the target", key)
+		}
+	}
+}
+
+func TestEchoVault_ClusterSnapshotReleasesCapture(t *testing.T) {
+	// The leader is not shut down, as a single voter cannot transfer its leadership.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	leader, err := NewEchoVault(
+		WithContext(ctx),
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			ServerID:           "leader",
+			BindAddr:           "localhost",
+			Port:               freePort(t),
+			RaftBindPort:       freePort(t),
+			MemberListBindPort: freePort(t),
+			InMemory:           true,
+			BootstrapCluster:   true,
+			DataDir:            t.TempDir(),
+			EvictionPolicy:     constants.NoEviction,
+			SnapShotThreshold:  1000,
+			SnapshotInterval:   5 * time.Minute,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the leader to accept writes", func() bool {
+		_, err := leader.MSET(map[string]string{"key1": "value1", "key2": "value2"})
+		return err == nil
+	})
+
+	// The raft snapshot captures the state on the FSM goroutine, and the values are copied as they are
+	// persisted. Once raft is done with the snapshot, writes no longer copy values for it.
+	if err = leader.TakeSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if count := leader.stateCaptures.count.Load(); count != 0 {
+		t.Errorf("expected no state capture after the snapshot, got %d", count)
+	}
+	if _, err = leader.SET("key1", "value3", SETOptions{}); err != nil {
+		t.Error(err)
+	}
+	if got, _ := leader.GET("key1"); got != "value3" {
+		t.Errorf("GET() got = %v, want value3", got)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/gobwas/glob"
+	"strings"
+)
+
+// configParameter is a configuration parameter that can be read with CONFIG GET and changed with CONFIG SET.
+type configParameter struct {
+	get   func(server *EchoVault) string
+	check func(server *EchoVault, value string) error // Validates a new value before any parameter is changed.
+	set   func(server *EchoVault, value string)
+}
+
+var configParameters = map[string]configParameter{
+	"save": {
+		get: func(server *EchoVault) string {
+			return server.config.SnapshotSave
+		},
+		check: func(server *EchoVault, value string) error {
+			if server.snapshotEngine == nil {
+				return errors.New("save points only apply to standalone nodes and to cluster nodes with the 'snapshot' cluster persistence")
+			}
+			_, err := internal.ParseSavePoints(value)
+			return err
+		},
+		set: func(server *EchoVault, value string) {
+			points, _ := internal.ParseSavePoints(value)
+			server.config.SnapshotSave = internal.FormatSavePoints(points)
+			server.snapshotEngine.SetSavePoints(points)
+		},
+	},
+}
+
+// GetConfigParameters returns the configuration parameters whose names match any of the glob patterns,
+// keyed by name.
+func (server *EchoVault) GetConfigParameters(patterns ...string) map[string]string {
+	server.configMutex.RLock()
+	defer server.configMutex.RUnlock()
+
+	parameters := make(map[string]string)
+	for _, pattern := range patterns {
+		g, err := glob.Compile(strings.ToLower(pattern))
+		if err != nil {
+			// A pattern that does not compile matches no parameter.
+			continue
+		}
+		for name, parameter := range configParameters {
+			if g.Match(name) {
+				parameters[name] = parameter.get(server)
+			}
+		}
+	}
+	return parameters
+}
+
+// SetConfigParameters changes the given configuration parameters on this node. If any name or value is invalid,
+// no parameter is changed.
+func (server *EchoVault) SetConfigParameters(parameters map[string]string) error {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+
+	for name, value := range parameters {
+		parameter, ok := configParameters[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("unknown config parameter '%s'", name)
+		}
+		if err := parameter.check(server, value); err != nil {
+			return fmt.Errorf("invalid value for config parameter '%s': %w", name, err)
+		}
+	}
+	for name, value := range parameters {
+		configParameters[strings.ToLower(name)].set(server, value)
+	}
+	return nil
+}
@@ -23,19 +23,27 @@ import (
 	"github.com/echovault/echovault/internal"
 	"github.com/echovault/echovault/internal/acl"
 	"github.com/echovault/echovault/internal/aof"
+	"github.com/echovault/echovault/internal/backup"
 	"github.com/echovault/echovault/internal/clock"
 	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/encryption"
 	"github.com/echovault/echovault/internal/eviction"
 	"github.com/echovault/echovault/internal/memberlist"
 	"github.com/echovault/echovault/internal/pubsub"
 	"github.com/echovault/echovault/internal/raft"
+	"github.com/echovault/echovault/internal/rdb"
+	"github.com/echovault/echovault/internal/replication"
+	"github.com/echovault/echovault/internal/search"
 	"github.com/echovault/echovault/internal/snapshot"
+	"github.com/echovault/echovault/internal/timeseries"
 	"github.com/echovault/echovault/pkg/constants"
 	"github.com/echovault/echovault/pkg/types"
 	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -47,6 +55,8 @@ type EchoVault struct {
 
 	// config holds the echovault configuration variables.
 	config config.Config
+	// configMutex guards the configuration parameters that can be changed with CONFIG SET.
+	configMutex sync.RWMutex
 
 	// The current index for the latest connection id.
 	// This number is incremented everytime there's a new connection and
@@ -56,6 +66,9 @@ type EchoVault struct {
 	store           map[string]internal.KeyData // Data store to hold the keys and their associated data, expiry time, etc.
 	keyLocks        map[string]*sync.RWMutex    // Map to hold all the individual key locks.
 	keyCreationLock *sync.Mutex                 // The mutex for creating a new key. Only one goroutine should be able to create a key at a time.
+	keyDeletionLock *sync.Mutex                 // The mutex for removing a key from the store. Held while the store is listed, as expired and evicted keys are deleted outside write commands.
+	keyLockOwners   sync.Map                    // Keys whose write locks are lent to a command by ApplyCommand, mapped to the command's connection ID.
+	keyLockLoans    atomic.Uint64               // The number of loans made by ApplyCommand, used to create unique connection IDs.
 
 	// Holds all the keys that are currently associated with an expiry.
 	keysWithExpiry struct {
@@ -73,6 +86,16 @@ type EchoVault struct {
 		cache eviction.CacheLRU // LRU cache represented by a max head.
 	}
 
+	// Write commands hold the read lock while they run. A state capture holds the write lock while it starts,
+	// so that it starts between commands. Exclusive write commands hold the write lock while they run.
+	stateLock sync.RWMutex
+	// The state captures in progress, which copy the values of keys before they are mutated.
+	stateCaptures struct {
+		mutex    sync.Mutex
+		captures []*stateCapture
+		count    atomic.Int32 // Checked before locking a key for writing, to skip the mutex when there's no capture.
+	}
+
 	// Holds the list of all commands supported by the echovault.
 	commands []types.Command
 
@@ -81,16 +104,44 @@ type EchoVault struct {
 
 	context context.Context
 
-	acl    *acl.ACL
-	pubSub *pubsub.PubSub
+	acl           *acl.ACL
+	pubSub        *pubsub.PubSub
+	searchIndexes *search.Registry  // Secondary indexes over hashes.
+	seriesIndex   *timeseries.Index // Label index over time series.
 
 	snapshotInProgress         atomic.Bool      // Atomic boolean that's true when actively taking a snapshot.
 	rewriteAOFInProgress       atomic.Bool      // Atomic boolean that's true when actively rewriting AOF file is in progress.
-	stateCopyInProgress        atomic.Bool      // Atomic boolean that's true when actively copying state for snapshotting or preamble generation.
-	stateMutationInProgress    atomic.Bool      // Atomic boolean that is set to true when state mutation is in progress.
+	snapshotScheduled          atomic.Bool      // Atomic boolean that's true when a background snapshot waits for the AOF rewrite to finish.
+	changeCount                atomic.Uint64    // Number of keys locked for writing since startup.
 	latestSnapshotMilliseconds atomic.Int64     // Unix epoch in milliseconds
-	snapshotEngine             *snapshot.Engine // Snapshot engine for standalone mode
-	aofEngine                  *aof.Engine      // AOF engine for standalone mode
+	snapshotEngine             *snapshot.Engine // Snapshot engine. Nil on cluster nodes unless the cluster persistence is 'snapshot'.
+	aofEngine                  *aof.Engine      // AOF engine. Nil on cluster nodes unless the cluster persistence is 'aof'.
+	backupEngine               *backup.Engine   // Backup engine.
+
+	replicationShipper *replication.Shipper // Ships the raft log to a replica cluster. Nil if there are no replication targets.
+	// The state of a replica cluster. The checkpoint, the full sync and the promotion are changed by the
+	// REPLICATION commands that every node applies. The other fields are kept by the node that talks to the primary.
+	replica struct {
+		applyMutex   sync.Mutex // Held by Replicate while it checks and applies a command from the primary.
+		mutex        sync.Mutex
+		promoted     atomic.Bool
+		checkpoint   uint64    // The index of the last entry of the primary that was applied.
+		syncIndex    uint64    // The index of the snapshot of the full sync in progress. 0 if there is none.
+		fullSyncs    int64     // The number of full syncs that finished since startup.
+		primaryIndex uint64    // The index of the last entry applied by the primary, as last reported by the primary.
+		lastContact  time.Time // The time of the last command from the primary.
+		behindSince  time.Time // The time the replica was first seen behind the primary. Zero if it is up to date.
+	}
+
+	// The progress and the results of the snapshots.
+	snapshotStatus struct {
+		mutex         sync.Mutex
+		status        types.SnapshotStatus
+		startChanges  uint64 // The change count when the snapshot in progress started.
+		latestChanges uint64 // The change count when the last successful snapshot started.
+	}
+
+	keyring *encryption.Keyring // Encrypts persisted data at rest. Nil when no encryption key is configured.
 }
 
 // WithContext is an options that for the NewEchoVault function that allows you to
@@ -131,6 +182,7 @@ func NewEchoVault(options ...func(echovault *EchoVault)) (*EchoVault, error) {
 		store:           make(map[string]internal.KeyData),
 		keyLocks:        make(map[string]*sync.RWMutex),
 		keyCreationLock: &sync.Mutex{},
+		keyDeletionLock: &sync.Mutex{},
 	}
 
 	for _, option := range options {
@@ -142,59 +194,134 @@ func NewEchoVault(options ...func(echovault *EchoVault)) (*EchoVault, error) {
 		internal.ContextServerID(echovault.config.ServerID),
 	)
 
+	// Load the encryption keys for persisted data
+	keyring, err := encryption.LoadKeyring(echovault.config.EncryptionKeyFile, echovault.config.EncryptionKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	echovault.keyring = keyring
+
+	// Parse the save points that trigger snapshots
+	savePoints, err := internal.ParseSavePoints(echovault.config.SnapshotSave)
+	if err != nil {
+		return nil, err
+	}
+	echovault.config.SnapshotSave = internal.FormatSavePoints(savePoints)
+
 	// Set up ACL module
 	echovault.acl = acl.NewACL(echovault.config)
 
 	// Set up Pub/Sub module
 	echovault.pubSub = pubsub.NewPubSub()
 
+	// Set up secondary index registry
+	echovault.searchIndexes = search.NewRegistry(
+		search.WithGetStateFunc(func() map[string]interface{} {
+			// FT.CREATE is an exclusive write command, so no other command mutates the store while it's read.
+			// getState would wait for FT.CREATE itself to complete. Expired and evicted keys are still deleted
+			// outside write commands.
+			echovault.keyDeletionLock.Lock()
+			defer echovault.keyDeletionLock.Unlock()
+			state := make(map[string]interface{}, len(echovault.store))
+			for k, v := range echovault.store {
+				state[k] = v.Value
+			}
+			return state
+		}),
+	)
+
+	// Set up time series label index
+	echovault.seriesIndex = timeseries.NewIndex()
+
+	if echovault.config.Learner && (echovault.config.BootstrapCluster || !echovault.joinsCluster()) {
+		return nil, errors.New("a learner must join an existing cluster")
+	}
+
+	gossipKeys, err := encryption.LoadKeys(echovault.config.GossipKeyFile, echovault.config.GossipKeyEnv)
+	if err != nil {
+		return nil, fmt.Errorf("gossip keys: %w", err)
+	}
+	if echovault.config.RequireClusterTLS && echovault.isInCluster() &&
+		(!echovault.config.RaftTLS || len(gossipKeys) == 0) {
+		return nil, errors.New("cluster encryption is required, but raft TLS or the gossip keys are not configured")
+	}
+
 	if echovault.isInCluster() {
 		echovault.raft = raft.NewRaft(raft.Opts{
-			Config:                echovault.config,
-			EchoVault:             echovault,
-			GetCommand:            echovault.getCommand,
-			DeleteKey:             echovault.DeleteKey,
+			Config:     echovault.config,
+			Keyring:    echovault.keyring,
+			EchoVault:  echovault,
+			GetCommand: echovault.getCommand,
+			DeleteKey:  echovault.DeleteKey,
+			ApplyWrite: func(cmd []string, apply func() ([]byte, error)) ([]byte, error) {
+				// The command is durable in the raft log, so there's no need to wait for the local AOF.
+				// Key deletions are applied as DEL, which may not be a loaded command.
+				command, _ := echovault.getCommand(cmd[0])
+				res, _, err := echovault.applyWrite(internal.EncodeCommand(cmd), true, command.Exclusive, apply)
+				return res, err
+			},
 			StartSnapshot:         echovault.startSnapshot,
 			FinishSnapshot:        echovault.finishSnapshot,
 			SetLatestSnapshotTime: echovault.setLatestSnapshot,
-			GetState: func() map[string]internal.KeyData {
-				state := make(map[string]internal.KeyData)
-				for k, v := range echovault.getState() {
-					if data, ok := v.(internal.KeyData); ok {
-						state[k] = data
-					}
-				}
-				return state
+			GetIndexes:            echovault.searchIndexes.Schemas,
+			RestoreIndexes:        echovault.searchIndexes.Restore,
+			GetState: func() internal.StateCapture {
+				return echovault.captureState(func() {})
 			},
+			Replicate: echovault.applyReplication,
 		})
 		echovault.memberList = memberlist.NewMemberList(memberlist.Opts{
 			Config:           echovault.config,
 			HasJoinedCluster: echovault.raft.HasJoinedCluster,
 			AddVoter:         echovault.raft.AddVoter,
+			AddNonvoter:      echovault.raft.AddNonvoter,
 			RemoveRaftServer: echovault.raft.RemoveServer,
 			IsRaftLeader:     echovault.raft.IsRaftLeader,
 			ApplyMutate:      echovault.raftApplyCommand,
 			ApplyDeleteKey:   echovault.raftApplyDeleteKey,
+			GossipKeys:       gossipKeys,
 		})
-	} else {
-		// Set up standalone snapshot engine
+	}
+
+	// Set up the replication to the replica cluster. Only the leader ships the raft log, and a replica only
+	// ships it once it is promoted.
+	if len(echovault.config.ReplicationTargets) > 0 {
+		if !echovault.isInCluster() {
+			return nil, errors.New("replication targets can only be set in cluster mode")
+		}
+		echovault.replicationShipper = replication.NewShipper(
+			replication.WithClock(echovault.clock),
+			replication.WithTargets(echovault.config.ReplicationTargets),
+			replication.WithCredentials(echovault.config.ReplicationUsername, echovault.config.ReplicationPassword),
+			replication.WithIsLeaderFunc(func() bool {
+				return echovault.raft.IsRaftLeader() && !echovault.isReplica()
+			}),
+			replication.WithAppliedIndexFunc(echovault.raft.AppliedIndex),
+			replication.WithGetLogFunc(echovault.raft.GetLog),
+			replication.WithOpenSnapshotFunc(echovault.raft.OpenSnapshot),
+		)
+	}
+
+	// Set up the snapshot engine. On a cluster node, it exports snapshots in addition to the raft snapshots.
+	if !echovault.isInCluster() || echovault.config.ClusterPersistence == "snapshot" {
 		echovault.snapshotEngine = snapshot.NewSnapshotEngine(
 			snapshot.WithClock(echovault.clock),
 			snapshot.WithDirectory(echovault.config.DataDir),
-			snapshot.WithThreshold(echovault.config.SnapShotThreshold),
-			snapshot.WithInterval(echovault.config.SnapshotInterval),
+			snapshot.WithSavePoints(savePoints),
+			snapshot.WithCompression(echovault.config.SnapshotCompression),
+			snapshot.WithRDBFile(echovault.config.RestoreRDB),
+			snapshot.WithRetainCount(echovault.config.SnapshotRetainCount),
+			snapshot.WithRetainAge(echovault.config.SnapshotRetainAge),
+			snapshot.WithKeyring(echovault.keyring),
+			snapshot.WithSnapshotID(echovault.config.RestoreSnapshotID),
 			snapshot.WithStartSnapshotFunc(echovault.startSnapshot),
 			snapshot.WithFinishSnapshotFunc(echovault.finishSnapshot),
 			snapshot.WithSetLatestSnapshotTimeFunc(echovault.setLatestSnapshot),
 			snapshot.WithGetLatestSnapshotTimeFunc(echovault.GetLatestSnapshotTime),
-			snapshot.WithGetStateFunc(func() map[string]internal.KeyData {
-				state := make(map[string]internal.KeyData)
-				for k, v := range echovault.getState() {
-					if data, ok := v.(internal.KeyData); ok {
-						state[k] = data
-					}
-				}
-				return state
+			snapshot.WithGetIndexesFunc(echovault.searchIndexes.Schemas),
+			snapshot.WithRestoreIndexesFunc(echovault.searchIndexes.Restore),
+			snapshot.WithGetStateFunc(func() internal.StateCapture {
+				return echovault.captureState(func() {})
 			}),
 			snapshot.WithSetKeyDataFunc(func(key string, data internal.KeyData) {
 				ctx := context.Background()
@@ -208,16 +335,24 @@ func NewEchoVault(options ...func(echovault *EchoVault)) (*EchoVault, error) {
 				echovault.KeyUnlock(ctx, key)
 			}),
 		)
-		// Set up standalone AOF engine
+	}
+
+	// Set up the AOF engine. On a cluster node, it logs the write commands that the node applies.
+	if !echovault.isInCluster() || echovault.config.ClusterPersistence == "aof" {
 		echovault.aofEngine = aof.NewAOFEngine(
 			aof.WithClock(echovault.clock),
 			aof.WithDirectory(echovault.config.DataDir),
 			aof.WithStrategy(echovault.config.AOFSyncStrategy),
+			aof.WithCorruptionPolicy(echovault.config.AOFCorruptionPolicy),
+			aof.WithAutoRewrite(echovault.config.AOFRewritePercent, echovault.config.AOFRewriteMinSize),
+			aof.WithKeyring(echovault.keyring),
 			aof.WithStartRewriteFunc(echovault.startRewriteAOF),
 			aof.WithFinishRewriteFunc(echovault.finishRewriteAOF),
-			aof.WithGetStateFunc(func() map[string]internal.KeyData {
+			aof.WithGetIndexesFunc(echovault.searchIndexes.Schemas),
+			aof.WithRestoreIndexesFunc(echovault.searchIndexes.Restore),
+			aof.WithGetStateFunc(func(cutover func()) map[string]internal.KeyData {
 				state := make(map[string]internal.KeyData)
-				for k, v := range echovault.getState() {
+				for k, v := range echovault.getStateAnd(cutover) {
 					if data, ok := v.(internal.KeyData); ok {
 						state[k] = data
 					}
@@ -244,6 +379,41 @@ func NewEchoVault(options ...func(echovault *EchoVault)) (*EchoVault, error) {
 		)
 	}
 
+	// Set up the backup engine.
+	backupDir := echovault.config.BackupDir
+	if backupDir == "" {
+		backupDir = filepath.Join(echovault.config.DataDir, "backups")
+	}
+	echovault.backupEngine = backup.NewBackupEngine(
+		backup.WithClock(echovault.clock),
+		backup.WithDirectory(backupDir),
+		backup.WithCompression(echovault.config.SnapshotCompression),
+		backup.WithKeyring(echovault.keyring),
+		backup.WithGetIndexesFunc(echovault.searchIndexes.Schemas),
+		backup.WithRestoreIndexesFunc(echovault.searchIndexes.Restore),
+		backup.WithGetStateFunc(echovault.getStateOf),
+		backup.WithSetKeyDataFunc(func(key string, data internal.KeyData) {
+			ctx := context.Background()
+			if _, err := echovault.CreateKeyAndLock(ctx, key); err != nil {
+				log.Println(err)
+			}
+			if err := echovault.SetValue(ctx, key, data.Value); err != nil {
+				log.Println(err)
+			}
+			echovault.SetExpiry(ctx, key, data.ExpireAt, false)
+			echovault.KeyUnlock(ctx, key)
+		}),
+		backup.WithDeleteKeyFunc(func(key string) {
+			ctx := context.Background()
+			if !echovault.KeyExists(ctx, key) {
+				return
+			}
+			if err := echovault.DeleteKey(ctx, key); err != nil {
+				log.Println(err)
+			}
+		}),
+	)
+
 	// If eviction policy is not noeviction, start a goroutine to evict keys every 100 milliseconds.
 	if echovault.config.EvictionPolicy != constants.NoEviction {
 		go func() {
@@ -264,23 +434,41 @@ func NewEchoVault(options ...func(echovault *EchoVault)) (*EchoVault, error) {
 		// Initialise raft and memberlist
 		echovault.raft.RaftInit(echovault.context)
 		echovault.memberList.MemberListInit(echovault.context)
+		if echovault.replicationShipper != nil {
+			echovault.replicationShipper.Start(echovault.context)
+		}
 		if echovault.raft.IsRaftLeader() {
 			echovault.initialiseCaches()
 		}
+		// Rewrite the local AOF so that it starts from the state restored from the raft snapshot.
+		// The commands that are applied from the raft log afterwards are logged as usual.
+		if echovault.aofEngine != nil {
+			if err := echovault.aofEngine.RewriteLog(); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if !echovault.isInCluster() {
 		echovault.initialiseCaches()
+		// Restore from the raft data of a cluster node if it's enabled.
+		if echovault.config.RestoreClusterData {
+			if err := echovault.restoreClusterData(); err != nil {
+				return nil, err
+			}
+			return echovault, nil
+		}
+
 		// Restore from AOF by default if it's enabled
 		if echovault.config.RestoreAOF {
-			err := echovault.aofEngine.Restore()
-			if err != nil {
-				log.Println(err)
+			if err := echovault.aofEngine.Restore(); err != nil {
+				return nil, err
 			}
 		}
 
-		// Restore from snapshot if snapshot restore is enabled and AOF restore is disabled
-		if echovault.config.RestoreSnapshot && !echovault.config.RestoreAOF {
+		// Restore from snapshot or RDB file if either restore is enabled and AOF restore is disabled
+		restoreSnapshot := echovault.config.RestoreSnapshot || echovault.config.RestoreSnapshotID != 0
+		if (restoreSnapshot || echovault.config.RestoreRDB != "") && !echovault.config.RestoreAOF {
 			err := echovault.snapshotEngine.Restore()
 			if err != nil {
 				log.Println(err)
@@ -317,13 +505,9 @@ func (server *EchoVault) startTCP() {
 			fmt.Printf("Starting TLS echovault at Address %s, Port %d...\n", conf.BindAddr, conf.Port)
 		}
 
-		var certificates []tls.Certificate
-		for _, certKeyPair := range conf.CertKeyPairs {
-			c, err := tls.LoadX509KeyPair(certKeyPair[0], certKeyPair[1])
-			if err != nil {
-				log.Fatal(err)
-			}
-			certificates = append(certificates, c)
+		certificates, err := internal.LoadCertificates(conf.CertKeyPairs)
+		if err != nil {
+			log.Fatal(err)
 		}
 
 		clientAuth := tls.NoClientCert
@@ -331,18 +515,8 @@ func (server *EchoVault) startTCP() {
 
 		if conf.MTLS {
 			clientAuth = tls.RequireAndVerifyClientCert
-			for _, c := range conf.ClientCAs {
-				ca, err := os.Open(c)
-				if err != nil {
-					log.Fatal(err)
-				}
-				certBytes, err := io.ReadAll(ca)
-				if err != nil {
-					log.Fatal(err)
-				}
-				if ok := clientCerts.AppendCertsFromPEM(certBytes); !ok {
-					log.Fatal(err)
-				}
+			if clientCerts, err = internal.LoadCertPool(conf.ClientCAs); err != nil {
+				log.Fatal(err)
 			}
 		}
 
@@ -449,27 +623,248 @@ func (server *EchoVault) Start() {
 	server.startTCP()
 }
 
-// TakeSnapshot triggers a snapshot when called.
+// TakeSnapshot saves the state of this node and returns once the snapshot is complete.
+// On a cluster node, a raft snapshot is taken, and a snapshot is also exported if the cluster persistence is 'snapshot'.
+// If nothing has changed since the latest snapshot, no new snapshot is taken.
 func (server *EchoVault) TakeSnapshot() error {
 	if server.snapshotInProgress.Load() {
 		return errors.New("snapshot already in progress")
 	}
 
-	go func() {
-		if server.isInCluster() {
-			// Handle snapshot in cluster mode
-			if err := server.raft.TakeSnapshot(); err != nil {
-				log.Println(err)
-			}
-			return
+	if server.isInCluster() {
+		if err := server.raft.TakeSnapshot(); err != nil {
+			return err
+		}
+	}
+
+	if server.snapshotEngine != nil {
+		if err := server.snapshotEngine.TakeSnapshot(); err != nil && !errors.Is(err, snapshot.ErrNothingNew) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BackgroundSnapshot starts a snapshot like TakeSnapshot and returns without waiting for it to complete.
+// The result of the snapshot is reported by GetPersistenceStatus.
+//
+// A snapshot is not started while the AOF is rewritten. If schedule is true, the snapshot is started once
+// the rewrite finishes and true is returned. Otherwise, an error is returned.
+func (server *EchoVault) BackgroundSnapshot(schedule bool) (bool, error) {
+	if server.snapshotInProgress.Load() {
+		return false, errors.New("snapshot already in progress")
+	}
+
+	if server.rewriteAOFInProgress.Load() {
+		if !schedule {
+			return false, errors.New("aof rewrite in progress, use BGSAVE SCHEDULE to save once it finishes")
+		}
+		server.snapshotScheduled.Store(true)
+		// Start the snapshot now if the rewrite finished before the snapshot was scheduled.
+		if server.rewriteAOFInProgress.Load() || !server.snapshotScheduled.CompareAndSwap(true, false) {
+			return true, nil
+		}
+	}
+
+	go server.backgroundSnapshot()
+
+	return false, nil
+}
+
+func (server *EchoVault) backgroundSnapshot() {
+	if err := server.TakeSnapshot(); err != nil {
+		log.Println(err)
+	}
+}
+
+// SaveRDB writes the current state to the given path as a Redis RDB file.
+// Keys holding values that have no RDB representation are left out.
+func (server *EchoVault) SaveRDB(path string) error {
+	state := make(map[string]internal.KeyData)
+	for k, v := range server.getState() {
+		if data, ok := v.(internal.KeyData); ok {
+			state[k] = data
 		}
-		// Handle snapshot in standalone mode
-		if err := server.snapshotEngine.TakeSnapshot(); err != nil {
+	}
+
+	// Write to a temporary file first so that an existing file at the path is only replaced by a complete RDB file.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	skipped, err := rdb.Save(f, server.clock.Now(), state)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		log.Printf("RDB file %s does not include keys with no RDB representation: %v\n", path, skipped)
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// RestoreRDB loads the keys of the Redis RDB file at the given path, replacing existing keys with the same name.
+func (server *EchoVault) RestoreRDB(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
 			log.Println(err)
 		}
 	}()
 
-	return nil
+	ctx := context.Background()
+	return rdb.Load(f, server.clock.Now(), func(key string, data internal.KeyData) {
+		if _, err := server.CreateKeyAndLock(ctx, key); err != nil {
+			log.Println(err)
+			return
+		}
+		if err := server.SetValue(ctx, key, data.Value); err != nil {
+			log.Println(err)
+		}
+		if data.ExpireAt == (time.Time{}) {
+			server.RemoveExpiry(key)
+		} else {
+			server.SetExpiry(ctx, key, data.ExpireAt, false)
+		}
+		server.KeyUnlock(ctx, key)
+	})
+}
+
+// ListSnapshots returns the snapshots taken by the snapshot engine, oldest first.
+// On a cluster node, these are the exported snapshots, which are only taken if the cluster persistence is 'snapshot'.
+func (server *EchoVault) ListSnapshots() ([]types.SnapshotInfo, error) {
+	if server.snapshotEngine == nil {
+		return nil, errors.New("snapshots can only be listed in standalone mode or with the 'snapshot' cluster persistence")
+	}
+	return server.snapshotEngine.ListSnapshots()
+}
+
+// RestoreSnapshot replaces the current state with the state in the snapshot with the given ID.
+// The AOF is rewritten before returning so that it starts from the restored state.
+func (server *EchoVault) RestoreSnapshot(id int64) error {
+	if server.isInCluster() {
+		return errors.New("snapshots can only be restored in standalone mode")
+	}
+
+	// Check the whole snapshot before the current state is cleared.
+	if err := server.snapshotEngine.VerifySnapshot(id); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for key := range server.getState() {
+		if err := server.DeleteKey(ctx, key); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if err := server.snapshotEngine.RestoreSnapshot(id); err != nil {
+		return err
+	}
+
+	return server.aofEngine.RewriteLog()
+}
+
+// CreateBackup takes a backup of the given type in the backup directory and returns it. The types are full,
+// incremental and differential. An incremental or differential backup is taken as a full backup if no full
+// backup was taken since startup.
+func (server *EchoVault) CreateBackup(backupType string) (types.BackupInfo, error) {
+	return server.backupEngine.Create(backupType)
+}
+
+// ListBackups returns the backups in the backup directory, oldest first.
+func (server *EchoVault) ListBackups() ([]types.BackupInfo, error) {
+	return server.backupEngine.List()
+}
+
+// VerifyBackups checks the backup with the given ID and the backups it depends on, or every backup if the ID is 0.
+func (server *EchoVault) VerifyBackups(id int64) ([]types.BackupCheck, error) {
+	return server.backupEngine.Verify(id)
+}
+
+// RestoreBackup replaces the current state with the state in the backup with the given ID, or in the latest
+// backup if the ID is 0. The backups it depends on are verified before the current state is cleared.
+// The AOF is rewritten before returning so that it starts from the restored state.
+func (server *EchoVault) RestoreBackup(id int64) error {
+	if server.isInCluster() {
+		return errors.New("backups can only be restored in standalone mode")
+	}
+
+	ctx := context.Background()
+	err := server.backupEngine.Restore(id, func() {
+		for key := range server.getState() {
+			if err := server.DeleteKey(ctx, key); err != nil {
+				log.Println(err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return server.aofEngine.RewriteLog()
+}
+
+// restoreClusterData loads the state that a cluster node left in the data directory, and rewrites the AOF
+// so that it starts from the restored state.
+func (server *EchoVault) restoreClusterData() error {
+	ctx := context.Background()
+	err := raft.RestoreDataDir(
+		server.config.DataDir,
+		server.keyring,
+		func(r io.Reader) error {
+			msec, err := snapshot.Load(r, server.searchIndexes.Restore, func(key string, data internal.KeyData) {
+				if _, err := server.CreateKeyAndLock(ctx, key); err != nil {
+					log.Println(err)
+					return
+				}
+				if err := server.SetValue(ctx, key, data.Value); err != nil {
+					log.Println(err)
+				}
+				server.SetExpiry(ctx, key, data.ExpireAt, false)
+				server.KeyUnlock(ctx, key)
+			})
+			if err != nil {
+				return err
+			}
+			server.setLatestSnapshot(msec)
+			return nil
+		},
+		func(request internal.ApplyRequest) error {
+			switch strings.ToLower(request.Type) {
+			case "delete-key":
+				return server.DeleteKey(ctx, request.Key)
+			case "command":
+				_, err := server.handleCommand(ctx, internal.EncodeCommand(request.CMD), nil, true, true)
+				return err
+			case "replication":
+				// The node was in a replica cluster, so the entries of the primary are applied locally.
+				_, err := server.applyReplication(request.CMD, server.applyLocal)
+				return err
+			default:
+				return fmt.Errorf("unsupported raft command type %s", request.Type)
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return server.aofEngine.RewriteLog()
 }
 
 // GetClock returns the server's clock implementation
@@ -479,10 +874,36 @@ func (server *EchoVault) GetClock() clock.Clock {
 
 func (server *EchoVault) startSnapshot() {
 	server.snapshotInProgress.Store(true)
+
+	server.snapshotStatus.mutex.Lock()
+	defer server.snapshotStatus.mutex.Unlock()
+	server.snapshotStatus.status.InProgress = true
+	server.snapshotStatus.status.StartTime = server.clock.Now().UnixMilli()
+	server.snapshotStatus.startChanges = server.changeCount.Load()
 }
 
-func (server *EchoVault) finishSnapshot() {
+func (server *EchoVault) finishSnapshot(size int64, err error) {
 	server.snapshotInProgress.Store(false)
+
+	server.snapshotStatus.mutex.Lock()
+	defer server.snapshotStatus.mutex.Unlock()
+	status := &server.snapshotStatus.status
+	end := server.clock.Now().UnixMilli()
+	status.LastDuration = end - status.StartTime
+	status.InProgress = false
+	status.StartTime = 0
+	status.LastSize = size
+	status.LastError = ""
+	// A snapshot of a state that did not change since the latest snapshot is not an error.
+	if err != nil && !errors.Is(err, snapshot.ErrNothingNew) {
+		status.FailedSaves += 1
+		status.LastFailureTime = end
+		status.LastError = err.Error()
+		return
+	}
+	status.Saves += 1
+	status.LastSuccessTime = end
+	server.snapshotStatus.latestChanges = server.snapshotStatus.startChanges
 }
 
 func (server *EchoVault) setLatestSnapshot(msec int64) {
@@ -500,10 +921,18 @@ func (server *EchoVault) startRewriteAOF() {
 
 func (server *EchoVault) finishRewriteAOF() {
 	server.rewriteAOFInProgress.Store(false)
+	// Start the background snapshot that waited for the rewrite to finish.
+	if server.snapshotScheduled.CompareAndSwap(true, false) {
+		go server.backgroundSnapshot()
+	}
 }
 
-// RewriteAOF triggers an AOF compaction when running in standalone mode.
+// RewriteAOF triggers an AOF compaction.
+// On a cluster node, this only works if the cluster persistence is 'aof'.
 func (server *EchoVault) RewriteAOF() error {
+	if server.aofEngine == nil {
+		return errors.New("aof is only enabled in standalone mode or with the 'aof' cluster persistence")
+	}
 	if server.rewriteAOFInProgress.Load() {
 		return errors.New("aof rewrite in progress")
 	}
@@ -515,13 +944,44 @@ func (server *EchoVault) RewriteAOF() error {
 	return nil
 }
 
+// GetAOFStatus returns the size of the AOF and the progress and statistics of its rewrites.
+// On a cluster node, this only works if the cluster persistence is 'aof'.
+func (server *EchoVault) GetAOFStatus() (types.AOFStatus, error) {
+	if server.aofEngine == nil {
+		return types.AOFStatus{}, errors.New("aof is only enabled in standalone mode or with the 'aof' cluster persistence")
+	}
+	return server.aofEngine.Status(), nil
+}
+
+// GetPersistenceStatus returns the progress and the results of the snapshots, and the status of the AOF
+// if it is enabled.
+func (server *EchoVault) GetPersistenceStatus() types.PersistenceStatus {
+	server.snapshotStatus.mutex.Lock()
+	status := types.PersistenceStatus{Snapshot: server.snapshotStatus.status}
+	status.Snapshot.ChangesSinceLastSave = server.changeCount.Load() - server.snapshotStatus.latestChanges
+	server.snapshotStatus.mutex.Unlock()
+
+	status.Snapshot.Scheduled = server.snapshotScheduled.Load()
+	status.Snapshot.LastSaveTime = server.GetLatestSnapshotTime()
+	if server.aofEngine != nil {
+		status.AOFEnabled = true
+		status.AOF = server.aofEngine.Status()
+	}
+	return status
+}
+
 // ShutDown gracefully shuts down the EchoVault instance.
-// This function shuts down the memberlist and raft layers.
+// This function shuts down the memberlist and raft layers, and flushes the AOF.
 func (server *EchoVault) ShutDown() {
 	if server.isInCluster() {
 		server.raft.RaftShutdown()
 		server.memberList.MemberListShutdown()
 	}
+	if server.aofEngine != nil {
+		if err := server.aofEngine.Flush(); err != nil {
+			log.Println(err)
+		}
+	}
 }
 
 func (server *EchoVault) initialiseCaches() {
@@ -35,6 +35,10 @@ import (
 // If this functions is called on a node in a replication cluster, the key is only locked
 // on that particular node.
 func (server *EchoVault) KeyLock(ctx context.Context, key string) (bool, error) {
+	// The caller of ApplyCommand holds the lock already and lends it to the command.
+	if server.isLentKeyLock(ctx, key) {
+		return true, nil
+	}
 	// If context did not set deadline, set the default deadline
 	var cancelFunc context.CancelFunc
 	if _, ok := ctx.Deadline(); !ok {
@@ -50,6 +54,9 @@ func (server *EchoVault) KeyLock(ctx context.Context, key string) (bool, error)
 			}
 			ok := server.keyLocks[key].TryLock()
 			if ok {
+				// The key may be mutated in place from now on, so the captures in progress copy it first.
+				server.preserveKey(key)
+				server.keyChanged(key)
 				return true, nil
 			}
 		case <-ctx.Done():
@@ -167,6 +174,7 @@ func (server *EchoVault) CreateKeyAndLock(ctx context.Context, key string) (bool
 			Value:    nil,
 			ExpireAt: time.Time{},
 		}
+		server.keyChanged(key)
 		return true, nil
 	}
 
@@ -183,8 +191,7 @@ func (server *EchoVault) GetValue(ctx context.Context, key string) interface{} {
 }
 
 // SetValue updates the value in the store at the specified key with the given value.
-// If we're in not in cluster (i.e. in standalone mode), then the change count is incremented in the snapshot engine.
-// This count triggers a snapshot when the threshold is reached.
+// The change is counted when the key is locked, see keyChanged.
 // The key must be locked prior to calling this function.
 func (server *EchoVault) SetValue(ctx context.Context, key string, value interface{}) error {
 	if internal.IsMaxMemoryExceeded(server.config.MaxMemory) && server.config.EvictionPolicy == constants.NoEviction {
@@ -201,9 +208,9 @@ func (server *EchoVault) SetValue(ctx context.Context, key string, value interfa
 		log.Printf("SetValue error: %+v\n", err)
 	}
 
-	if !server.isInCluster() {
-		server.snapshotEngine.IncrementChangeCount()
-	}
+	// Keep secondary indexes in sync with the new value.
+	server.searchIndexes.OnSet(key, value)
+	server.seriesIndex.OnSet(key, value)
 
 	return nil
 }
@@ -261,25 +268,160 @@ func (server *EchoVault) RemoveExpiry(key string) {
 	})
 }
 
-// GetState creates a deep copy of the store map.
+// GetState creates a deep copy of the store map as it was at a point in time between write commands.
 // It is used to retrieve the current state for persistence but can also be used for other
 // functions that require a deep copy of the state.
-// The copy only starts when there's no current copy in progress (represented by stateCopyInProgress atomic boolean)
-// and when there's no current state mutation in progress (represented by stateMutationInProgress atomic boolean)
+// Write commands are only paused while the keys are listed. The values are copied while write commands
+// continue, see stateCapture.
 func (server *EchoVault) getState() map[string]interface{} {
-	// Wait unit there's no state mutation or copy in progress before starting a new copy process.
-	for {
-		if !server.stateCopyInProgress.Load() && !server.stateMutationInProgress.Load() {
-			server.stateCopyInProgress.Store(true)
-			break
+	return server.getStateAnd(func() {})
+}
+
+// getStateAnd copies the store map like getState and calls f before write commands can resume.
+func (server *EchoVault) getStateAnd(f func()) map[string]interface{} {
+	capture := server.captureState(f)
+	defer server.releaseState(capture)
+
+	data := make(map[string]interface{}, len(capture.keys))
+	for _, key := range capture.keys {
+		data[key], _ = capture.get(key)
+	}
+	return data
+}
+
+// getStateOf copies the entries of some keys like getState. f is called before write commands can resume,
+// and returns the keys to copy, or all as true to copy every key. Keys that are not in the store are left out.
+func (server *EchoVault) getStateOf(f func() (keys []string, all bool)) map[string]internal.KeyData {
+	var keys []string
+	var all bool
+	capture := server.captureState(func() {
+		keys, all = f()
+	})
+	defer server.releaseState(capture)
+
+	if all {
+		keys = capture.keys
+	}
+	data := make(map[string]internal.KeyData, len(keys))
+	for _, key := range keys {
+		if entry, ok := capture.get(key); ok {
+			data[key] = entry
 		}
 	}
-	data := make(map[string]interface{})
+	return data
+}
+
+// stateCapture is a point-in-time view of the store. Command handlers mutate values in place, so the view
+// starts out sharing the values with the store, and each value is copied either by the first write command that
+// locks its key after the capture started, or when the capture reads it, whichever comes first.
+// Writers only wait for the copy of the key they lock.
+type stateCapture struct {
+	server *EchoVault
+	mutex  sync.Mutex
+	keys   []string                    // The keys in the store when the capture started.
+	state  map[string]internal.KeyData // The entries of the keys. Values of the keys not in copied are shared with the store.
+	copied map[string]bool
+}
+
+// get returns the entry of the key in the capture, copying its value if it's still shared with the store.
+// It returns false if the key was not in the store when the capture started.
+func (capture *stateCapture) get(key string) (internal.KeyData, bool) {
+	capture.mutex.Lock()
+	defer capture.mutex.Unlock()
+	data, ok := capture.state[key]
+	if !ok || capture.copied[key] {
+		return data, ok
+	}
+	value, err := internal.CloneValue(data.Value)
+	if err != nil {
+		log.Printf("copy key %s: %+v\n", key, err)
+	} else {
+		data.Value = value
+	}
+	capture.state[key] = data
+	capture.copied[key] = true
+	return data, true
+}
+
+// Keys implements internal.StateCapture.
+func (capture *stateCapture) Keys() []string {
+	return capture.keys
+}
+
+// Get implements internal.StateCapture.
+func (capture *stateCapture) Get(key string) (internal.KeyData, bool) {
+	return capture.get(key)
+}
+
+// Release implements internal.StateCapture.
+func (capture *stateCapture) Release() {
+	capture.server.releaseState(capture)
+}
+
+// captureState starts a state capture between write commands, and calls f before write commands can resume.
+// The capture must be released with releaseState.
+func (server *EchoVault) captureState(f func()) *stateCapture {
+	server.stateLock.Lock()
+	defer server.stateLock.Unlock()
+
+	// Expired and evicted keys are deleted outside write commands.
+	server.keyDeletionLock.Lock()
+	capture := &stateCapture{
+		server: server,
+		keys:   make([]string, 0, len(server.store)),
+		state:  make(map[string]internal.KeyData, len(server.store)),
+		copied: make(map[string]bool),
+	}
 	for k, v := range server.store {
-		data[k] = v
+		capture.keys = append(capture.keys, k)
+		capture.state[k] = v
 	}
-	server.stateCopyInProgress.Store(false)
-	return data
+	server.keyDeletionLock.Unlock()
+
+	server.stateCaptures.mutex.Lock()
+	server.stateCaptures.captures = append(server.stateCaptures.captures, capture)
+	server.stateCaptures.count.Add(1)
+	server.stateCaptures.mutex.Unlock()
+
+	f()
+	return capture
+}
+
+// releaseState stops a capture started by captureState from copying the values that are mutated.
+// Releasing a capture again has no effect.
+func (server *EchoVault) releaseState(capture *stateCapture) {
+	server.stateCaptures.mutex.Lock()
+	defer server.stateCaptures.mutex.Unlock()
+	count := len(server.stateCaptures.captures)
+	server.stateCaptures.captures = slices.DeleteFunc(server.stateCaptures.captures, func(c *stateCapture) bool {
+		return c == capture
+	})
+	server.stateCaptures.count.Add(int32(len(server.stateCaptures.captures) - count))
+}
+
+// preserveKey copies the value of the key into the captures in progress before the key is mutated.
+// The key must be locked for writing.
+func (server *EchoVault) preserveKey(key string) {
+	if server.stateCaptures.count.Load() == 0 {
+		return
+	}
+	server.stateCaptures.mutex.Lock()
+	captures := slices.Clone(server.stateCaptures.captures)
+	server.stateCaptures.mutex.Unlock()
+	for _, capture := range captures {
+		capture.get(key)
+	}
+}
+
+// keyChanged counts the key as changed for the snapshot save points and records it for the next backups.
+// It is called when the key is locked for writing, as commands change most values in place, without SetValue.
+// The key must be locked for writing.
+func (server *EchoVault) keyChanged(key string) {
+	server.changeCount.Add(1)
+	if server.snapshotEngine != nil {
+		server.snapshotEngine.IncrementChangeCount()
+	}
+	server.backupEngine.KeyChanged(key)
 }
 
 // DeleteKey removes the key from store, keyLocks and keyExpiry maps.
@@ -291,12 +433,17 @@ func (server *EchoVault) DeleteKey(ctx context.Context, key string) error {
 		return fmt.Errorf("deleteKey error: %+v", err)
 	}
 
+	server.keyDeletionLock.Lock()
 	// Remove key expiry.
 	server.RemoveExpiry(key)
-
 	// Delete the key from keyLocks and store.
 	delete(server.keyLocks, key)
 	delete(server.store, key)
+	server.keyDeletionLock.Unlock()
+
+	// Remove the key from secondary indexes.
+	server.searchIndexes.OnDelete(key)
+	server.seriesIndex.OnDelete(key)
 
 	// Remove the key from the cache.
 	switch {
@@ -311,6 +458,36 @@ func (server *EchoVault) DeleteKey(ctx context.Context, key string) error {
 	return nil
 }
 
+// ApplyCommand runs a write command through the command pipeline, so that it is written to the AOF and replicated
+// to the rest of the cluster. lockedKeys are the keys that the caller holds the write locks of. The locks are lent
+// to the command on this node and stay held until the caller releases them.
+func (server *EchoVault) ApplyCommand(ctx context.Context, command []string, lockedKeys []string) ([]byte, error) {
+	connId := fmt.Sprintf("%s-apply-command-%d",
+		server.context.Value(internal.ContextServerID("ServerID")), server.keyLockLoans.Add(1))
+	for _, key := range lockedKeys {
+		server.keyLockOwners.Store(key, connId)
+	}
+	defer func() {
+		for _, key := range lockedKeys {
+			server.keyLockOwners.CompareAndDelete(key, connId)
+		}
+	}()
+
+	ctx = context.WithValue(ctx, internal.ContextConnID("ConnectionID"), connId)
+	return server.handleCommand(ctx, internal.EncodeCommand(command), nil, false, true)
+}
+
+// isLentKeyLock reports whether the write lock of the key was lent by ApplyCommand to the command
+// that runs with the context.
+func (server *EchoVault) isLentKeyLock(ctx context.Context, key string) bool {
+	owner, ok := server.keyLockOwners.Load(key)
+	if !ok {
+		return false
+	}
+	connId, _ := ctx.Value(internal.ContextConnID("ConnectionID")).(string)
+	return connId == owner
+}
+
 // updateKeyInCache updates either the key access count or the most recent access time in the cache
 // depending on whether an LFU or LRU strategy was used.
 func (server *EchoVault) updateKeyInCache(ctx context.Context, key string) error {
@@ -350,6 +527,44 @@ func (server *EchoVault) updateKeyInCache(ctx context.Context, key string) error
 	return nil
 }
 
+// SetKeyIdleTime sets the time since the key was last accessed in the LRU cache.
+// Like updateKeyInCache, it has no effect unless the key is tracked by an LRU eviction policy.
+func (server *EchoVault) SetKeyIdleTime(key string, idleTime time.Duration) {
+	if server.isInCluster() || server.config.MaxMemory == 0 {
+		return
+	}
+	switch strings.ToLower(server.config.EvictionPolicy) {
+	case constants.VolatileLRU:
+		if server.store[key].ExpireAt == (time.Time{}) {
+			return
+		}
+		fallthrough
+	case constants.AllKeysLRU:
+		server.lruCache.mutex.Lock()
+		defer server.lruCache.mutex.Unlock()
+		server.lruCache.cache.SetIdleTime(key, idleTime)
+	}
+}
+
+// SetKeyFrequency sets the access count of the key in the LFU cache.
+// Like updateKeyInCache, it has no effect unless the key is tracked by an LFU eviction policy.
+func (server *EchoVault) SetKeyFrequency(key string, frequency int) {
+	if server.isInCluster() || server.config.MaxMemory == 0 {
+		return
+	}
+	switch strings.ToLower(server.config.EvictionPolicy) {
+	case constants.VolatileLFU:
+		if server.store[key].ExpireAt == (time.Time{}) {
+			return
+		}
+		fallthrough
+	case constants.AllKeysLFU:
+		server.lfuCache.mutex.Lock()
+		defer server.lfuCache.mutex.Unlock()
+		server.lfuCache.cache.SetCount(key, frequency)
+	}
+}
+
 // adjustMemoryUsage should only be called from standalone echovault or from raft cluster leader.
 func (server *EchoVault) adjustMemoryUsage(ctx context.Context) error {
 	// If max memory is 0, there's no need to adjust memory usage.
@@ -37,6 +37,14 @@ func (server *EchoVault) GetPubSub() interface{} {
 	return server.pubSub
 }
 
+func (server *EchoVault) GetSearchIndexes() interface{} {
+	return server.searchIndexes
+}
+
+func (server *EchoVault) GetTimeSeriesIndex() interface{} {
+	return server.seriesIndex
+}
+
 func (server *EchoVault) getCommand(cmd string) (types.Command, error) {
 	for _, command := range server.commands {
 		if strings.EqualFold(command.Command, cmd) {
@@ -57,14 +65,7 @@ func (server *EchoVault) handleCommand(ctx context.Context, message []byte, conn
 		return nil, err
 	}
 
-	synchronize := command.Sync
-	handler := command.HandlerFunc
-
-	subCommand, ok := internal.GetSubCommand(command, cmd).(types.SubCommand)
-	if ok {
-		synchronize = subCommand.Sync
-		handler = subCommand.HandlerFunc
-	}
+	subCommand, isSubCommand := internal.GetSubCommand(command, cmd).(types.SubCommand)
 
 	if conn != nil && server.acl != nil && !embedded {
 		// Authorize connection if it's provided and if ACL module is present
@@ -74,29 +75,55 @@ func (server *EchoVault) handleCommand(ctx context.Context, message []byte, conn
 		}
 	}
 
-	// If the command is a write command, wait for state copy to finish.
-	if internal.IsWriteCommand(command, subCommand) {
-		for {
-			if !server.stateCopyInProgress.Load() {
-				server.stateMutationInProgress.Store(true)
-				break
-			}
+	// Rewrite the command before it is executed so that the AOF and the replicas
+	// receive the same command that is executed on this node. Replayed commands were
+	// rewritten before they were logged.
+	rewrite := command.RewriteFunc
+	if isSubCommand {
+		rewrite = subCommand.RewriteFunc
+	}
+	if rewrite != nil && !replay {
+		if cmd, err = rewrite(cmd, server); err != nil {
+			return nil, err
+		}
+		message = internal.EncodeCommand(cmd)
+		// The command may have been rewritten into a different command.
+		if command, err = server.getCommand(cmd[0]); err != nil {
+			return nil, err
 		}
+		subCommand, isSubCommand = internal.GetSubCommand(command, cmd).(types.SubCommand)
+	}
+
+	// A replica cluster only changes with the entries of the primary cluster until it is promoted.
+	if !replay && internal.IsWriteCommand(command, subCommand) && server.isReplica() {
+		return nil, errors.New("READONLY you can't write against a replica until it is promoted")
+	}
+
+	synchronize := command.Sync
+	handler := command.HandlerFunc
+	if isSubCommand {
+		synchronize = subCommand.Sync
+		handler = subCommand.HandlerFunc
 	}
 
 	if !server.isInCluster() || !synchronize {
-		res, err := handler(ctx, cmd, server, conn)
+		if !internal.IsWriteCommand(command, subCommand) {
+			return handler(ctx, cmd, server, conn)
+		}
+
+		res, waitAOF, err := server.applyWrite(message, !replay, command.Exclusive, func() ([]byte, error) {
+			return handler(ctx, cmd, server, conn)
+		})
 		if err != nil {
 			return nil, err
 		}
 
-		if internal.IsWriteCommand(command, subCommand) && !replay {
-			go server.aofEngine.QueueCommand(message)
+		// Only reply once the command is durable according to the AOF sync strategy.
+		if err = waitAOF(); err != nil {
+			return nil, err
 		}
 
-		server.stateMutationInProgress.Store(false)
-
-		return res, err
+		return res, nil
 	}
 
 	// Handle other commands that need to be synced across the cluster
@@ -117,3 +144,35 @@ func (server *EchoVault) handleCommand(ctx context.Context, message []byte, conn
 
 	return nil, errors.New("not cluster leader, cannot carry out command")
 }
+
+// applyWrite runs apply, which mutates the state, while holding the read lock of the state, so that state
+// captures start between write commands. If exclusive is true, it holds the write lock instead, so that no
+// other write command runs at the same time. If logCommand is true and the AOF is enabled, the command is queued
+// before the lock is released, so that an AOF rewrite that captures the state afterwards also finds the command
+// in the queue. The returned function waits until the command is durable according to the AOF sync strategy.
+func (server *EchoVault) applyWrite(
+	message []byte,
+	logCommand bool,
+	exclusive bool,
+	apply func() ([]byte, error),
+) ([]byte, func() error, error) {
+	if exclusive {
+		server.stateLock.Lock()
+		defer server.stateLock.Unlock()
+	} else {
+		server.stateLock.RLock()
+		defer server.stateLock.RUnlock()
+	}
+
+	res, err := apply()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wait := func() error { return nil }
+	if logCommand && server.aofEngine != nil {
+		wait = server.aofEngine.QueueCommand(message)
+	}
+
+	return res, wait, nil
+}
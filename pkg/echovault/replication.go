@@ -0,0 +1,364 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replicaTimeout is the time without contact from the primary after which a replica reports that it is
+// disconnected. The primary pings the replica every second while there are no entries to ship.
+const replicaTimeout = 5 * time.Second
+
+// isReplica reports whether the node belongs to a replica cluster that was not promoted yet.
+func (server *EchoVault) isReplica() bool {
+	return server.config.Replica && !server.replica.promoted.Load()
+}
+
+// Replicate handles a REPLICATION command sent by the leader of the primary cluster. The commands that change
+// the state are checked against the checkpoint on this node, and then applied through raft on a cluster node,
+// so that every node of the replica cluster applies them.
+func (server *EchoVault) Replicate(cmd []string) ([]byte, error) {
+	if len(cmd) < 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if !server.isReplica() {
+		return nil, errors.New("not a replica")
+	}
+	if server.isInCluster() && !server.raft.IsRaftLeader() {
+		return nil, errors.New("not cluster leader, cannot carry out command")
+	}
+
+	// The commands are checked and applied one at a time, so that no entry is applied twice, even if a new
+	// leader of the primary connects while the previous one is still shipping.
+	server.replica.applyMutex.Lock()
+	defer server.replica.applyMutex.Unlock()
+
+	checkpoint, syncIndex := server.replicaCheckpoint()
+	var primaryIndex uint64
+	switch strings.ToLower(cmd[1]) {
+	default:
+		return nil, fmt.Errorf("unknown replication command %s", strings.ToUpper(cmd[1]))
+
+	case "handshake":
+		if len(cmd) != 2 {
+			return nil, errors.New(constants.WrongArgsResponse)
+		}
+		server.replicaContact(0)
+		return []byte(fmt.Sprintf(":%d\r\n", checkpoint)), nil
+
+	case "ping":
+		if len(cmd) != 3 {
+			return nil, errors.New(constants.WrongArgsResponse)
+		}
+		index, err := parseIndex(cmd[2])
+		if err != nil {
+			return nil, err
+		}
+		server.replicaContact(index)
+		return []byte(fmt.Sprintf(":%d\r\n", checkpoint)), nil
+
+	case "sync-start":
+		if len(cmd) != 4 {
+			return nil, errors.New(constants.WrongArgsResponse)
+		}
+		if index, err := parseIndex(cmd[2]); err != nil || index == 0 {
+			return nil, errors.New("index must be a positive integer")
+		}
+
+	case "sync-load":
+		if len(cmd)%3 != 2 {
+			return nil, errors.New(constants.WrongArgsResponse)
+		}
+		if syncIndex == 0 {
+			return nil, errors.New("no full sync in progress")
+		}
+
+	case "sync-end":
+		if len(cmd) != 3 {
+			return nil, errors.New(constants.WrongArgsResponse)
+		}
+		index, err := parseIndex(cmd[2])
+		if err != nil {
+			return nil, err
+		}
+		if syncIndex == 0 || syncIndex != index {
+			return nil, fmt.Errorf("no full sync in progress for index %d", index)
+		}
+
+	case "apply":
+		if len(cmd) < 5 {
+			return nil, errors.New(constants.WrongArgsResponse)
+		}
+		var indexes [3]uint64
+		for i := range indexes {
+			index, err := parseIndex(cmd[i+2])
+			if err != nil {
+				return nil, err
+			}
+			indexes[i] = index
+		}
+		from, through := indexes[0], indexes[1]
+		if checkpoint == 0 || from != checkpoint+1 || through < from {
+			return nil, fmt.Errorf("entries %d to %d do not follow the checkpoint %d", from, through, checkpoint)
+		}
+		primaryIndex = indexes[2]
+	}
+
+	var res []byte
+	var err error
+	if server.isInCluster() {
+		res, err = server.raftApplyReplication(cmd)
+	} else {
+		res, err = server.applyReplication(cmd, server.applyLocal)
+	}
+	server.replicaContact(primaryIndex)
+	return res, err
+}
+
+// PromoteReplica turns the replica cluster into a cluster that accepts client writes, and stops it from
+// accepting entries from the primary cluster.
+func (server *EchoVault) PromoteReplica() error {
+	if !server.isReplica() {
+		return errors.New("not a replica")
+	}
+	cmd := []string{"REPLICATION", "PROMOTE"}
+	if server.isInCluster() {
+		if !server.raft.IsRaftLeader() {
+			return errors.New("not cluster leader, cannot carry out command")
+		}
+		_, err := server.raftApplyReplication(cmd)
+		return err
+	}
+	_, err := server.applyReplication(cmd, server.applyLocal)
+	return err
+}
+
+// applyReplication applies a REPLICATION command that changes the state of the replica. It runs on every node
+// of a replica cluster, including when a node replays its raft log, so the command was already checked by
+// Replicate and is applied as it is. The entries of the primary are passed to apply.
+func (server *EchoVault) applyReplication(
+	cmd []string,
+	apply func(request internal.ApplyRequest) ([]byte, error),
+) ([]byte, error) {
+	server.replica.mutex.Lock()
+	defer server.replica.mutex.Unlock()
+
+	switch strings.ToLower(cmd[1]) {
+	default:
+		return nil, fmt.Errorf("unknown replication command %s", strings.ToUpper(cmd[1]))
+
+	case "promote":
+		server.replica.promoted.Store(true)
+		return []byte(constants.OkResponse), nil
+
+	case "sync-start":
+		index, err := parseIndex(cmd[2])
+		if err != nil {
+			return nil, err
+		}
+		var schemas []search.Schema
+		if err = json.Unmarshal([]byte(cmd[3]), &schemas); err != nil {
+			return nil, fmt.Errorf("could not decode indexes: %w", err)
+		}
+		// The checkpoint is cleared first, so that a full sync that does not finish is started over.
+		server.replica.checkpoint = 0
+		server.replica.syncIndex = index
+		for key := range server.getState() {
+			if _, err = apply(internal.ApplyRequest{Type: "delete-key", Key: key}); err != nil {
+				log.Println(err)
+			}
+		}
+		for _, name := range server.searchIndexes.List() {
+			_, _ = server.searchIndexes.Drop(name)
+		}
+		server.searchIndexes.Restore(schemas)
+		return []byte(constants.OkResponse), nil
+
+	case "sync-load":
+		// The keys are decoded before any is restored.
+		requests := make([]internal.ApplyRequest, 0, (len(cmd)-2)/3)
+		for i := 2; i+2 < len(cmd); i += 3 {
+			payload, err := base64.StdEncoding.DecodeString(cmd[i+2])
+			if err != nil {
+				return nil, fmt.Errorf("could not decode payload of key %s: %w", cmd[i], err)
+			}
+			requests = append(requests, internal.ApplyRequest{
+				Type: "command",
+				CMD:  []string{"RESTORE", cmd[i], cmd[i+1], string(payload), "REPLACE", "ABSTTL"},
+			})
+		}
+		for _, request := range requests {
+			if _, err := apply(request); err != nil {
+				log.Printf("could not restore replicated key %s: %v\n", request.CMD[1], err)
+			}
+		}
+		return []byte(constants.OkResponse), nil
+
+	case "sync-end":
+		index, err := parseIndex(cmd[2])
+		if err != nil {
+			return nil, err
+		}
+		server.replica.checkpoint = index
+		server.replica.syncIndex = 0
+		server.replica.fullSyncs++
+		return []byte(fmt.Sprintf(":%d\r\n", server.replica.checkpoint)), nil
+
+	case "apply":
+		through, err := parseIndex(cmd[3])
+		if err != nil {
+			return nil, err
+		}
+		if err = applyEntries(cmd[5:], apply); err != nil {
+			return nil, err
+		}
+		server.replica.checkpoint = through
+		return []byte(fmt.Sprintf(":%d\r\n", server.replica.checkpoint)), nil
+	}
+}
+
+func parseIndex(s string) (uint64, error) {
+	index, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errors.New("index must be an integer")
+	}
+	return index, nil
+}
+
+// applyEntries applies the JSON encoded apply requests of the primary. The requests are decoded before any is
+// applied. A command that fails is logged and skipped, as it failed the same way on the primary.
+func applyEntries(entries []string, apply func(request internal.ApplyRequest) ([]byte, error)) error {
+	requests := make([]internal.ApplyRequest, len(entries))
+	for i, entry := range entries {
+		if err := json.Unmarshal([]byte(entry), &requests[i]); err != nil {
+			return fmt.Errorf("could not decode entry: %w", err)
+		}
+		if requests[i].Type == "command" && len(requests[i].CMD) == 0 {
+			return errors.New("could not decode entry: empty command")
+		}
+	}
+	for _, request := range requests {
+		if _, err := apply(request); err != nil {
+			log.Printf("could not apply replicated entry %+v: %v\n", request, err)
+		}
+	}
+	return nil
+}
+
+// applyLocal applies an entry of the primary on a standalone replica, in the same way as the raft FSM does
+// on a cluster node.
+func (server *EchoVault) applyLocal(request internal.ApplyRequest) ([]byte, error) {
+	ctx := context.WithValue(server.context, internal.ContextServerID("ServerID"), request.ServerID)
+	ctx = context.WithValue(ctx, internal.ContextConnID("ConnectionID"), request.ConnectionID)
+
+	switch request.Type {
+	default:
+		return nil, fmt.Errorf("unsupported raft command type %s", request.Type)
+
+	case "delete-key":
+		res, wait, err := server.applyWrite(internal.EncodeCommand([]string{"DEL", request.Key}), true, false, func() ([]byte, error) {
+			return []byte(constants.OkResponse), server.DeleteKey(ctx, request.Key)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return res, wait()
+
+	case "command":
+		command, err := server.getCommand(request.CMD[0])
+		if err != nil {
+			return nil, err
+		}
+		handler := command.HandlerFunc
+		subCommand, ok := internal.GetSubCommand(command, request.CMD).(types.SubCommand)
+		if ok {
+			handler = subCommand.HandlerFunc
+		}
+		apply := func() ([]byte, error) {
+			return handler(ctx, request.CMD, server, nil)
+		}
+		if !internal.IsWriteCommand(command, subCommand) {
+			return apply()
+		}
+		res, wait, err := server.applyWrite(internal.EncodeCommand(request.CMD), true, command.Exclusive, apply)
+		if err != nil {
+			return nil, err
+		}
+		return res, wait()
+	}
+}
+
+// replicaCheckpoint returns the checkpoint and the index of the full sync in progress.
+func (server *EchoVault) replicaCheckpoint() (uint64, uint64) {
+	server.replica.mutex.Lock()
+	defer server.replica.mutex.Unlock()
+	return server.replica.checkpoint, server.replica.syncIndex
+}
+
+// replicaContact records an exchange with the primary. A primary index of 0 leaves the known index unchanged.
+func (server *EchoVault) replicaContact(primaryIndex uint64) {
+	server.replica.mutex.Lock()
+	defer server.replica.mutex.Unlock()
+	now := server.clock.Now()
+	server.replica.lastContact = now
+	server.replica.primaryIndex = max(server.replica.primaryIndex, primaryIndex)
+	if server.replica.primaryIndex <= server.replica.checkpoint {
+		server.replica.behindSince = time.Time{}
+	} else if server.replica.behindSince.IsZero() {
+		server.replica.behindSince = now
+	}
+}
+
+// GetReplicationStatus returns the status of the replication between the primary and the replica cluster.
+func (server *EchoVault) GetReplicationStatus() types.ReplicationStatus {
+	if server.isReplica() {
+		server.replica.mutex.Lock()
+		defer server.replica.mutex.Unlock()
+		now := server.clock.Now()
+		status := types.ReplicationStatus{
+			Role:         "replica",
+			Checkpoint:   server.replica.checkpoint,
+			PrimaryIndex: max(server.replica.primaryIndex, server.replica.checkpoint),
+			FullSyncs:    server.replica.fullSyncs,
+			Syncing:      server.replica.syncIndex != 0,
+		}
+		if !server.replica.lastContact.IsZero() {
+			status.Connected = now.Sub(server.replica.lastContact) < replicaTimeout
+			status.LastContact = server.replica.lastContact.UnixMilli()
+		}
+		status.LagEntries = status.PrimaryIndex - status.Checkpoint
+		if status.LagEntries > 0 && !server.replica.behindSince.IsZero() {
+			status.Lag = max(now.Sub(server.replica.behindSince).Milliseconds(), 0)
+		}
+		return status
+	}
+	if server.replicationShipper != nil {
+		return server.replicationShipper.Status()
+	}
+	return types.ReplicationStatus{Role: "none"}
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/pkg/commands"
+	"github.com/echovault/echovault/pkg/constants"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEchoVault_Replication(t *testing.T) {
+	replicaPort := freePort(t)
+
+	replica, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			BindAddr:       "localhost",
+			Port:           replicaPort,
+			DataDir:        t.TempDir(),
+			EvictionPolicy: constants.NoEviction,
+			Replica:        true,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go replica.Start()
+	defer replica.ShutDown()
+
+	// The replica rejects client writes until it is promoted.
+	if _, err = replica.SET("key1", "value1", SETOptions{}); err == nil || !strings.HasPrefix(err.Error(), "READONLY") {
+		t.Errorf("expected the write to the replica to be rejected, got %v", err)
+	}
+	// Entries that do not follow the checkpoint are rejected.
+	if _, err = replica.Replicate([]string{"REPLICATION", "APPLY", "5", "6", "6"}); err == nil {
+		t.Error("expected entries without a checkpoint to be rejected")
+	}
+
+	// The primary is a single node cluster. It is not shut down, as a single node cannot transfer its leadership,
+	// but cancelling its context stops the shipping.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	primary, err := NewEchoVault(
+		WithContext(ctx),
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			ServerID:           "primary",
+			BindAddr:           "localhost",
+			Port:               freePort(t),
+			RaftBindPort:       freePort(t),
+			MemberListBindPort: freePort(t),
+			InMemory:           true,
+			BootstrapCluster:   true,
+			DataDir:            t.TempDir(),
+			EvictionPolicy:     constants.NoEviction,
+			SnapShotThreshold:  1000,
+			SnapshotInterval:   5 * time.Minute,
+			ReplicationTargets: []string{fmt.Sprintf("localhost:%d", replicaPort)},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the primary to become the leader.
+	eventually(t, "the primary to accept writes", func() bool {
+		_, err := primary.SET("key1", "value1", SETOptions{})
+		return err == nil
+	})
+	if _, err = primary.SET("key2", "value2", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first sync is a full sync.
+	eventually(t, "the full sync", func() bool {
+		value1, _ := replica.GET("key1")
+		value2, _ := replica.GET("key2")
+		return value1 == "value1" && value2 == "value2"
+	})
+
+	// The entries after the checkpoint are shipped.
+	if _, err = primary.SET("key3", "value3", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = primary.DEL("key1"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the entries to be applied", func() bool {
+		value1, _ := replica.GET("key1")
+		value3, _ := replica.GET("key3")
+		return value1 == "" && value3 == "value3"
+	})
+
+	eventually(t, "the primary to report the replica up to date", func() bool {
+		status, err := primary.REPLICATION_STATUS()
+		return err == nil && status.Connected && status.LagEntries == 0
+	})
+	primaryStatus, err := primary.REPLICATION_STATUS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if primaryStatus.Role != "primary" || primaryStatus.Target != fmt.Sprintf("localhost:%d", replicaPort) ||
+		primaryStatus.FullSyncs != 1 || primaryStatus.Checkpoint != primaryStatus.PrimaryIndex {
+		t.Errorf("unexpected primary status %+v", primaryStatus)
+	}
+	replicaStatus, err := replica.REPLICATION_STATUS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicaStatus.Role != "replica" || !replicaStatus.Connected || replicaStatus.FullSyncs != 1 ||
+		replicaStatus.Checkpoint == 0 || replicaStatus.Checkpoint > primaryStatus.Checkpoint {
+		t.Errorf("unexpected replica status %+v", replicaStatus)
+	}
+
+	// Once promoted, the replica accepts writes and no longer accepts entries from the primary.
+	if _, err = primary.REPLICATION_PROMOTE(); err == nil {
+		t.Error("expected the promotion of a primary to fail")
+	}
+	if got, err := replica.REPLICATION_PROMOTE(); err != nil || got != "OK" {
+		t.Fatalf("REPLICATION_PROMOTE() got = %v, %v, want OK", got, err)
+	}
+	if _, err = replica.SET("key4", "value4", SETOptions{}); err != nil {
+		t.Errorf("expected the promoted replica to accept writes, got %v", err)
+	}
+	if status, _ := replica.REPLICATION_STATUS(); status.Role != "none" {
+		t.Errorf("expected the promoted replica to have no role, got %s", status.Role)
+	}
+	eventually(t, "the primary to be disconnected", func() bool {
+		status, err := primary.REPLICATION_STATUS()
+		return err == nil && !status.Connected && strings.Contains(status.LastError, "not a replica")
+	})
+}
+
+func TestEchoVault_ReplicationAuth(t *testing.T) {
+	replicaPort := freePort(t)
+
+	// Only the shipper user may run the replication subcommands on the replica.
+	aclConfig := path.Join(t.TempDir(), "acl.json")
+	users, err := json.Marshal([]map[string]any{
+		{
+			"Username":           "default",
+			"Enabled":            true,
+			"Passwords":          []map[string]string{{"PasswordType": "plaintext", "PasswordValue": "client-password"}},
+			"ExcludedCategories": []string{constants.ReplicationCategory},
+		},
+		{
+			"Username":           "shipper",
+			"Enabled":            true,
+			"Passwords":          []map[string]string{{"PasswordType": "plaintext", "PasswordValue": "shipper-password"}},
+			"IncludedCategories": []string{constants.ReplicationCategory},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(aclConfig, users, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	replica, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			BindAddr:       "localhost",
+			Port:           replicaPort,
+			DataDir:        t.TempDir(),
+			EvictionPolicy: constants.NoEviction,
+			Replica:        true,
+			RequirePass:    true,
+			Password:       "client-password",
+			AclConfig:      aclConfig,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go replica.Start()
+	defer replica.ShutDown()
+
+	// newPrimary starts a single node primary cluster that ships to the replica with the given credentials.
+	newPrimary := func(ctx context.Context, username, password string) *EchoVault {
+		primary, err := NewEchoVault(
+			WithContext(ctx),
+			WithCommands(commands.All()),
+			WithConfig(config.Config{
+				ServerID:            "primary",
+				BindAddr:            "localhost",
+				Port:                freePort(t),
+				RaftBindPort:        freePort(t),
+				MemberListBindPort:  freePort(t),
+				InMemory:            true,
+				BootstrapCluster:    true,
+				DataDir:             t.TempDir(),
+				EvictionPolicy:      constants.NoEviction,
+				SnapShotThreshold:   1000,
+				SnapshotInterval:    5 * time.Minute,
+				ReplicationTargets:  []string{fmt.Sprintf("localhost:%d", replicaPort)},
+				ReplicationUsername: username,
+				ReplicationPassword: password,
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, "the primary to accept writes", func() bool {
+			_, err := primary.SET("key1", "value1", SETOptions{})
+			return err == nil
+		})
+		return primary
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  string
+	}{
+		{name: "1. Without credentials", wantErr: "user must be authenticated"},
+		{name: "2. As a client user", password: "client-password", wantErr: "@replication"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			primary := newPrimary(ctx, tt.username, tt.password)
+			eventually(t, "the primary to be rejected", func() bool {
+				status, err := primary.REPLICATION_STATUS()
+				return err == nil && !status.Connected && strings.Contains(status.LastError, tt.wantErr)
+			})
+		})
+	}
+	if value, _ := replica.GET("key1"); value != "" {
+		t.Errorf("expected the rejected primaries not to ship, got key1 = %s", value)
+	}
+
+	// The shipper user ships the entries.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	primary := newPrimary(ctx, "shipper", "shipper-password")
+	eventually(t, "the full sync", func() bool {
+		value, _ := replica.GET("key1")
+		return value == "value1"
+	})
+	if status, err := primary.REPLICATION_STATUS(); err != nil || !status.Connected || status.LastError != "" {
+		t.Errorf("unexpected primary status %+v, %v", status, err)
+	}
+}
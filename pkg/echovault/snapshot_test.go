@@ -0,0 +1,898 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package echovault
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/bloom"
+	"github.com/echovault/echovault/internal/clock"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/set"
+	"github.com/echovault/echovault/internal/snapshot"
+	"github.com/echovault/echovault/internal/sorted_set"
+	"github.com/echovault/echovault/pkg/commands"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"hash/crc64"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func createSnapshotServer(t *testing.T, dataDir string, compression string, restore bool) *EchoVault {
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:             dataDir,
+			EvictionPolicy:      constants.NoEviction,
+			SnapshotCompression: compression,
+			RestoreSnapshot:     restore,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestEchoVault_SnapshotRestore(t *testing.T) {
+	expireAt := time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, compression := range []string{snapshot.CompressionNone, snapshot.CompressionGzip} {
+		t.Run(compression, func(t *testing.T) {
+			dataDir := t.TempDir()
+			server := createSnapshotServer(t, dataDir, compression, false)
+
+			if _, err := server.SET("string", "value", SETOptions{PXAT: int(expireAt.UnixMilli())}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.SET("int", "42", SETOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.SET("float", "3.14", SETOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.HSET("hash", map[string]string{"f1": "v1", "f2": "2"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.RPUSH("list", "a", "b", "c"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.SADD("set", "m1", "m2", "m3"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.ZADD("zset", map[string]float64{"a": 1.5, "b": -2}, ZADDOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.handleCommand(server.context,
+				internal.EncodeCommand([]string{"BF.ADD", "bloom", "item"}), nil, false, true); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := server.snapshotEngine.TakeSnapshot(); err != nil {
+				t.Fatal(err)
+			}
+			if err := server.snapshotEngine.TakeSnapshot(); err == nil || err.Error() != "nothing new to snapshot" {
+				t.Errorf("expected error \"nothing new to snapshot\", got %v", err)
+			}
+
+			restored := createSnapshotServer(t, dataDir, compression, true)
+			ctx := context.Background()
+
+			for key, expected := range map[string]interface{}{
+				"string": "value",
+				"int":    42,
+				"float":  3.14,
+				"hash":   map[string]interface{}{"f1": "v1", "f2": 2},
+				"list":   []interface{}{"a", "b", "c"},
+			} {
+				if _, err := restored.KeyRLock(ctx, key); err != nil {
+					t.Fatal(err)
+				}
+				if value := restored.GetValue(ctx, key); !reflect.DeepEqual(value, expected) {
+					t.Errorf("expected value at key %s to be %+v, got %+v", key, expected, value)
+				}
+				restored.KeyRUnlock(ctx, key)
+			}
+
+			if _, err := restored.KeyRLock(ctx, "string"); err != nil {
+				t.Fatal(err)
+			}
+			if expiry := restored.GetExpiry(ctx, "string"); !expiry.Equal(expireAt) {
+				t.Errorf("expected expiry %v, got %v", expireAt, expiry)
+			}
+			restored.KeyRUnlock(ctx, "string")
+
+			if _, err := restored.KeyRLock(ctx, "set"); err != nil {
+				t.Fatal(err)
+			}
+			members := restored.GetValue(ctx, "set").(*set.Set).GetAll()
+			restored.KeyRUnlock(ctx, "set")
+			slices.Sort(members)
+			if !reflect.DeepEqual(members, []string{"m1", "m2", "m3"}) {
+				t.Errorf("expected set members [m1 m2 m3], got %v", members)
+			}
+
+			if _, err := restored.KeyRLock(ctx, "zset"); err != nil {
+				t.Fatal(err)
+			}
+			zset := restored.GetValue(ctx, "zset").(*sorted_set.SortedSet)
+			restored.KeyRUnlock(ctx, "zset")
+			if !zset.Equals(sorted_set.NewSortedSet([]sorted_set.MemberParam{
+				{Value: "a", Score: 1.5}, {Value: "b", Score: -2},
+			})) {
+				t.Errorf("unexpected sorted set members %+v", zset.GetAll())
+			}
+
+			if _, err := restored.KeyRLock(ctx, "bloom"); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := restored.GetValue(ctx, "bloom").(*bloom.BloomFilter); !ok {
+				t.Errorf("expected bloom filter at key bloom")
+			}
+			restored.KeyRUnlock(ctx, "bloom")
+		})
+	}
+}
+
+func TestEchoVault_SnapshotRestoreLegacyJSON(t *testing.T) {
+	dataDir := t.TempDir()
+	msec := int64(1000)
+
+	state, err := json.Marshal(internal.SnapshotObject{
+		State: map[string]internal.KeyData{
+			"key1": {Value: "value1"},
+			"key2": {Value: "value2", ExpireAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		LatestSnapshotMilliseconds: msec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal(snapshot.Manifest{LatestSnapshotMilliseconds: msec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(path.Join(dataDir, "snapshots", "1000"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path.Join(dataDir, "snapshots", "manifest.bin"), manifest, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path.Join(dataDir, "snapshots", "1000", "state.bin"), state, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	server := createSnapshotServer(t, dataDir, "", true)
+
+	if got, err := server.GET("key1"); err != nil || got != "value1" {
+		t.Errorf("expected value1 at key1, got %s (%v)", got, err)
+	}
+	if server.KeyExists(context.Background(), "key2") {
+		t.Errorf("expected expired key key2 to be skipped")
+	}
+	if got := server.GetLatestSnapshotTime(); got != msec {
+		t.Errorf("expected latest snapshot time %d, got %d", msec, got)
+	}
+}
+
+// writeTestSnapshot writes a snapshot with the given id and state into the snapshot directory.
+func writeTestSnapshot(t *testing.T, dataDir string, id int64, state map[string]internal.KeyData) {
+	dir := path.Join(dataDir, "snapshots", strconv.FormatInt(id, 10))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path.Join(dir, "state.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	encoder, err := snapshot.NewEncoder(f, snapshot.CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.WriteMeta(id); err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.WriteState(state); err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEchoVault_SnapshotRetention(t *testing.T) {
+	now := clock.NewClock().Now()
+
+	tests := []struct {
+		name        string
+		retainCount uint64
+		retainAge   time.Duration
+		want        []int64
+	}{
+		{
+			name: "1. Keep all snapshots by default",
+			want: []int64{
+				now.Add(-3 * time.Hour).UnixMilli(),
+				now.Add(-2 * time.Hour).UnixMilli(),
+				now.Add(-1 * time.Hour).UnixMilli(),
+				now.UnixMilli(),
+			},
+		},
+		{
+			name:        "2. Keep the most recent snapshots",
+			retainCount: 2,
+			want:        []int64{now.Add(-1 * time.Hour).UnixMilli(), now.UnixMilli()},
+		},
+		{
+			name:      "3. Keep the snapshots younger than the maximum age",
+			retainAge: 150 * time.Minute,
+			want: []int64{
+				now.Add(-2 * time.Hour).UnixMilli(),
+				now.Add(-1 * time.Hour).UnixMilli(),
+				now.UnixMilli(),
+			},
+		},
+		{
+			name:        "4. Always keep the latest snapshot",
+			retainCount: 1,
+			retainAge:   time.Millisecond,
+			want:        []int64{now.UnixMilli()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+			for i := 3; i > 0; i-- {
+				writeTestSnapshot(t, dataDir, now.Add(time.Duration(-i)*time.Hour).UnixMilli(), map[string]internal.KeyData{
+					"key": {Value: i},
+				})
+			}
+
+			server, err := NewEchoVault(
+				WithCommands(commands.All()),
+				WithConfig(config.Config{
+					DataDir:             dataDir,
+					EvictionPolicy:      constants.NoEviction,
+					SnapshotRetainCount: tt.retainCount,
+					SnapshotRetainAge:   tt.retainAge,
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = server.SET("key", "value", SETOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if err = server.snapshotEngine.TakeSnapshot(); err != nil {
+				t.Fatal(err)
+			}
+
+			snapshots, err := server.SNAPSHOT_LIST()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for _, info := range snapshots {
+				ids = append(ids, info.ID)
+				if info.Size == 0 {
+					t.Errorf("expected snapshot %d to have a size", info.ID)
+				}
+				// Only the digest of snapshots taken by the engine is recorded.
+				if (info.Hash != "") != (info.ID == now.UnixMilli()) {
+					t.Errorf("unexpected hash %q for snapshot %d", info.Hash, info.ID)
+				}
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("expected snapshots %v, got %v", tt.want, ids)
+			}
+		})
+	}
+}
+
+func TestEchoVault_SNAPSHOT_RESTORE(t *testing.T) {
+	now := clock.NewClock().Now()
+	older := now.Add(-time.Hour).UnixMilli()
+
+	dataDir := t.TempDir()
+	writeTestSnapshot(t, dataDir, older, map[string]internal.KeyData{
+		"key1": {Value: "old"},
+		"key2": {Value: 2},
+	})
+
+	server := createSnapshotServer(t, dataDir, snapshot.CompressionNone, false)
+	if _, err := server.SET("key1", "new", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.SET("key3", "new", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.snapshotEngine.TakeSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.SNAPSHOT_RESTORE(older + 1); err == nil {
+		t.Error("expected error when restoring a snapshot that does not exist")
+	}
+
+	if _, err := server.SNAPSHOT_RESTORE(older); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"key1": "old", "key2": "2", "key3": ""} {
+		if got, _ := server.GET(key); got != want {
+			t.Errorf("GET(%s) got = %v, want %v", key, got, want)
+		}
+	}
+	if msec := server.GetLatestSnapshotTime(); msec != older {
+		t.Errorf("expected latest snapshot time %d, got %d", older, msec)
+	}
+
+	// Restore the chosen snapshot on startup.
+	restored, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			DataDir:           dataDir,
+			EvictionPolicy:    constants.NoEviction,
+			RestoreSnapshotID: now.UnixMilli(),
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"key1": "new", "key2": "", "key3": "new"} {
+		if got, _ := restored.GET(key); got != want {
+			t.Errorf("GET(%s) got = %v, want %v after startup restore", key, got, want)
+		}
+	}
+}
+
+func TestEchoVault_SAVE_RDB(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	server := createSnapshotServer(t, "", "", false)
+
+	if _, err := server.SET("string", "value", SETOptions{PXAT: int(time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli())}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.SET("float", "-1.25", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.HSET("hash", map[string]string{"f1": "v1", "f2": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.RPUSH("list", "a", "1", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.SADD("set", "m1", "m2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.ZADD("zset", map[string]float64{"a": 1.5, "b": -2}, ZADDOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.handleCommand(server.context,
+		internal.EncodeCommand([]string{"BF.ADD", "bloom", "item"}), nil, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.SAVE_RDB(path.Join(dir, "dump.rdb")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.SAVE_RDB(path.Join(dir, "missing", "dump.rdb")); err == nil {
+		t.Error("expected error when saving to a directory that does not exist")
+	}
+
+	restored := createSnapshotServer(t, "", "", false)
+	if _, err := restored.RESTORE_RDB(path.Join(dir, "dump.rdb")); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]interface{}{
+		"string": "value",
+		"float":  -1.25,
+		"hash":   map[string]interface{}{"f1": "v1", "f2": 2},
+		"list":   []interface{}{"a", 1, "c"},
+	} {
+		if _, err := restored.KeyRLock(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if value := restored.GetValue(ctx, key); !reflect.DeepEqual(value, expected) {
+			t.Errorf("expected value at key %s to be %+v, got %+v", key, expected, value)
+		}
+		restored.KeyRUnlock(ctx, key)
+	}
+
+	if ttl, err := restored.PTTL("string"); err != nil || ttl <= 0 {
+		t.Errorf("expected key string to keep its expiry, got ttl %d (%v)", ttl, err)
+	}
+	if members, err := restored.SMEMBERS("set"); err != nil || len(members) != 2 {
+		t.Errorf("expected 2 set members, got %v (%v)", members, err)
+	}
+	if score, err := restored.ZSCORE("zset", "b"); err != nil || score != float64(-2) {
+		t.Errorf("expected score -2, got %v (%v)", score, err)
+	}
+	if restored.KeyExists(ctx, "bloom") {
+		t.Error("expected bloom filter to be left out of the RDB file")
+	}
+}
+
+// rdbLength encodes a length in the RDB length encoding.
+func rdbLength(n int) []byte {
+	switch {
+	case n < 1<<6:
+		return []byte{byte(n)}
+	case n < 1<<14:
+		return []byte{byte(n>>8) | 0x40, byte(n)}
+	default:
+		return binary.BigEndian.AppendUint32([]byte{0x80}, uint32(n))
+	}
+}
+
+func rdbString(s []byte) []byte {
+	return append(rdbLength(len(s)), s...)
+}
+
+// rdbFile builds an RDB file with the given body and a valid checksum.
+func rdbFile(body ...[]byte) []byte {
+	b := []byte("REDIS0011")
+	for _, p := range body {
+		b = append(b, p...)
+	}
+	b = append(b, 0xFF)
+	table := crc64.MakeTable(0x95AC9329AC4BC9B5)
+	return binary.LittleEndian.AppendUint64(b, ^crc64.Update(^uint64(0), table, b))
+}
+
+func TestEchoVault_RESTORE_RDB(t *testing.T) {
+	ctx := context.Background()
+
+	ziplist := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 4, 0, // header
+		0, 0x01, 'a', // 6-bit string
+		3, 0xF6, // 4-bit immediate integer 5
+		2, 0xC0, 0x2C, 0x01, // 16-bit integer 300
+		4, 0x05, 'h', 'e', 'l', 'l', 'o',
+		0xFF,
+	}
+	intset := []byte{2, 0, 0, 0, 3, 0, 0, 0, 1, 0, 2, 0, 0xFD, 0xFF}
+	hashListpack := []byte{
+		0, 0, 0, 0, 4, 0, // header
+		0x82, 'f', '1', 3, // 6-bit string
+		0x82, 'v', '1', 3,
+		0x82, 'f', '2', 3,
+		0x2A, 1, // 7-bit integer 42
+		0xFF,
+	}
+	zsetListpack := []byte{
+		0, 0, 0, 0, 4, 0,
+		0x82, 'm', '1', 3,
+		0x83, '1', '.', '5', 4,
+		0x82, 'm', '2', 3,
+		0xDF, 0xFE, 2, // 13-bit integer -2
+		0xFF,
+	}
+	quicklistNode := []byte{0, 0, 0, 0, 2, 0, 0x81, 'x', 2, 0x81, 'y', 2, 0xFF}
+	zipmap := []byte{1, 2, 'k', '1', 2, 0, 'v', '1', 0xFF}
+
+	tests := []struct {
+		name          string
+		rdb           []byte
+		expected      map[string]interface{}
+		expectedSets  map[string][]string
+		expectedZSets map[string]map[string]float64
+		missing       []string
+		expiry        map[string]time.Time
+		expectedError string
+	}{
+		{
+			name: "1. Load strings with special encodings and expiry times",
+			rdb: rdbFile(
+				[]byte{0xFA}, rdbString([]byte("redis-ver")), rdbString([]byte("7.2.0")),
+				[]byte{0xFE, 0, 0xFB, 4, 2},
+				[]byte{0}, rdbString([]byte("plain")), rdbString([]byte("value")),
+				[]byte{0}, rdbString([]byte("int8")), []byte{0xC0, 0xFB},
+				[]byte{0}, rdbString([]byte("lzf")), []byte{0xC3, 5, 10, 0x00, 'a', 0xE0, 0x00, 0x00},
+				binary.LittleEndian.AppendUint64([]byte{0xFC}, uint64(time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli())),
+				[]byte{0}, rdbString([]byte("volatile")), rdbString([]byte("v")),
+				binary.LittleEndian.AppendUint64([]byte{0xFC}, uint64(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli())),
+				[]byte{0}, rdbString([]byte("expired")), rdbString([]byte("v")),
+			),
+			expected: map[string]interface{}{
+				"plain":    "value",
+				"int8":     -5,
+				"lzf":      "aaaaaaaaaa",
+				"volatile": "v",
+			},
+			missing: []string{"expired"},
+			expiry:  map[string]time.Time{"volatile": time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "2. Load compact list, set, hash and sorted set encodings",
+			rdb: rdbFile(
+				[]byte{10}, rdbString([]byte("ziplist")), rdbString(ziplist),
+				[]byte{18}, rdbString([]byte("quicklist")), []byte{2, 2}, rdbString(quicklistNode), []byte{1}, rdbString([]byte("big")),
+				[]byte{11}, rdbString([]byte("intset")), rdbString(intset),
+				[]byte{16}, rdbString([]byte("hash")), rdbString(hashListpack),
+				[]byte{9}, rdbString([]byte("zipmap")), rdbString(zipmap),
+				[]byte{17}, rdbString([]byte("zset")), rdbString(zsetListpack),
+				[]byte{3}, rdbString([]byte("zset-inf")), []byte{1}, rdbString([]byte("inf")), []byte{254},
+			),
+			expected: map[string]interface{}{
+				"ziplist":   []interface{}{"a", 5, 300, "hello"},
+				"quicklist": []interface{}{"x", "y", "big"},
+				"hash":      map[string]interface{}{"f1": "v1", "f2": 42},
+				"zipmap":    map[string]interface{}{"k1": "v1"},
+			},
+			expectedSets: map[string][]string{"intset": {"-3", "1", "2"}},
+			expectedZSets: map[string]map[string]float64{
+				"zset":     {"m1": 1.5, "m2": -2},
+				"zset-inf": {"inf": math.Inf(1)},
+			},
+		},
+		{
+			name:          "3. Return error on checksum mismatch",
+			rdb:           append(rdbFile([]byte{0}, rdbString([]byte("key")), rdbString([]byte("value")))[:21], 1, 2, 3, 4, 5, 6, 7, 8),
+			expectedError: "RDB checksum mismatch",
+		},
+		{
+			name:          "4. Return error on unsupported value type",
+			rdb:           rdbFile([]byte{15}, rdbString([]byte("stream")), rdbString([]byte("data"))),
+			expectedError: "key stream: unsupported RDB value type 15",
+		},
+		{
+			name:          "5. Return error when the file is not an RDB file",
+			rdb:           []byte("not an rdb file"),
+			expectedError: "not an RDB file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := path.Join(t.TempDir(), "dump.rdb")
+			if err := os.WriteFile(file, test.rdb, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			server := createSnapshotServer(t, "", "", false)
+			_, err := server.RESTORE_RDB(file)
+			if test.expectedError != "" {
+				if err == nil || err.Error() != test.expectedError {
+					t.Errorf("expected error \"%s\", got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for key, expected := range test.expected {
+				if _, err = server.KeyRLock(ctx, key); err != nil {
+					t.Fatal(err)
+				}
+				if value := server.GetValue(ctx, key); !reflect.DeepEqual(value, expected) {
+					t.Errorf("expected value at key %s to be %+v, got %+v", key, expected, value)
+				}
+				if expected, ok := test.expiry[key]; ok {
+					if expiry := server.GetExpiry(ctx, key); !expiry.Equal(expected) {
+						t.Errorf("expected expiry %v at key %s, got %v", expected, key, expiry)
+					}
+				}
+				server.KeyRUnlock(ctx, key)
+			}
+			for key, expected := range test.expectedSets {
+				members, err := server.SMEMBERS(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				slices.Sort(members)
+				if !reflect.DeepEqual(members, expected) {
+					t.Errorf("expected members %v at key %s, got %v", expected, key, members)
+				}
+			}
+			for key, expected := range test.expectedZSets {
+				for member, score := range expected {
+					got, err := server.ZSCORE(key, member)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != score {
+						t.Errorf("expected score %v for member %s at key %s, got %v", score, member, key, got)
+					}
+				}
+			}
+			for _, key := range test.missing {
+				if server.KeyExists(ctx, key) {
+					t.Errorf("expected key %s to be skipped", key)
+				}
+			}
+		})
+	}
+}
+
+func TestEchoVault_SAVE(t *testing.T) {
+	server := createSnapshotServer(t, t.TempDir(), snapshot.CompressionNone, false)
+
+	if _, err := server.SET("key1", "value1", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := server.SAVE(); err != nil || res != "OK" {
+		t.Fatalf("SAVE() got = %v, %v", res, err)
+	}
+	// SAVE waits for the snapshot to complete.
+	msec, err := server.LASTSAVE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int(server.clock.Now().UnixMilli()); msec != want {
+		t.Errorf("LASTSAVE() got = %d, want %d", msec, want)
+	}
+	snapshots, err := server.SNAPSHOT_LIST()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(snapshots))
+	}
+
+	// Saving an unchanged state is not an error.
+	if res, err := server.SAVE(); err != nil || res != "OK" {
+		t.Errorf("SAVE() of an unchanged state got = %v, %v", res, err)
+	}
+
+	if _, err = server.SET("key2", "value2", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := server.BGSAVE(BGSAVEOptions{}); err != nil || res != "Background saving started" {
+		t.Fatalf("BGSAVE() got = %v, %v", res, err)
+	}
+	// The mock clock does not move, so the new snapshot replaces the previous one with the same ID.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		latest, err := server.SNAPSHOT_LIST()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(latest) == 1 && latest[0].Hash != snapshots[0].Hash && !server.snapshotInProgress.Load() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the background snapshot to replace %+v, got %+v", snapshots, latest)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	restored := createSnapshotServer(t, server.config.DataDir, snapshot.CompressionNone, true)
+	for _, key := range []string{"key1", "key2"} {
+		if !restored.KeyExists(context.Background(), key) {
+			t.Errorf("expected key %s to be restored", key)
+		}
+	}
+}
+
+func TestEchoVault_StateCapture(t *testing.T) {
+	server := createSnapshotServer(t, t.TempDir(), snapshot.CompressionNone, false)
+
+	populate := func() {
+		if _, err := server.HSET("hash", map[string]string{"field1": "value1"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.SADD("set", "member1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.RPUSH("list", "elem1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.SET("string", "value1", SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mutate := func() {
+		if _, err := server.HSET("hash", map[string]string{"field2": "value2"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.SADD("set", "member2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.RPUSH("list", "elem2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.DEL("string"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.SET("new", "value", SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertCaptured := func(get func(key string) (internal.KeyData, bool)) {
+		t.Helper()
+		if data, _ := get("hash"); !reflect.DeepEqual(data.Value, map[string]interface{}{"field1": "value1"}) {
+			t.Errorf("expected captured hash to only hold field1, got %v", data.Value)
+		}
+		if data, _ := get("set"); data.Value.(*set.Set).Cardinality() != 1 {
+			t.Errorf("expected captured set to only hold member1, got %v", data.Value.(*set.Set).GetAll())
+		}
+		if data, _ := get("list"); !reflect.DeepEqual(data.Value, []interface{}{"elem1"}) {
+			t.Errorf("expected captured list to only hold elem1, got %v", data.Value)
+		}
+		if data, ok := get("string"); !ok || data.Value != "value1" {
+			t.Errorf("expected captured string to be value1, got %v", data.Value)
+		}
+		if _, ok := get("new"); ok {
+			t.Error("expected key created after the capture to be missing")
+		}
+	}
+
+	// Values that are mutated in place after the capture are copied by the writer before it mutates them.
+	populate()
+	capture := server.captureState(func() {})
+	mutate()
+	assertCaptured(capture.get)
+	server.releaseState(capture)
+	if count := server.stateCaptures.count.Load(); count != 0 {
+		t.Errorf("expected no capture in progress, got %d", count)
+	}
+
+	// Values read by the capture before they are mutated are copied by the capture.
+	if _, err := server.DEL("hash", "set", "list", "new"); err != nil {
+		t.Fatal(err)
+	}
+	populate()
+	state := server.getState()
+	mutate()
+	assertCaptured(func(key string) (internal.KeyData, bool) {
+		data, ok := state[key].(internal.KeyData)
+		return data, ok
+	})
+
+	// Captures start between write commands, so the keys set by a single command are captured together.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			value := strconv.Itoa(i)
+			if _, err := server.MSET(map[string]string{"a": value, "b": value}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		state := server.getState()
+		a, _ := state["a"].(internal.KeyData)
+		b, _ := state["b"].(internal.KeyData)
+		if a.Value != b.Value {
+			t.Fatalf("expected keys set by MSET to be captured together, got a = %v, b = %v", a.Value, b.Value)
+		}
+	}
+}
+
+func TestEchoVault_SavePoints(t *testing.T) {
+	server, err := NewEchoVault(
+		WithCommands(commands.All()),
+		WithConfig(config.Config{DataDir: t.TempDir(), EvictionPolicy: constants.NoEviction}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The mock clock does not move, so a save point without seconds is reached by its changes alone.
+	if _, err = server.CONFIG_SET(map[string]string{"save": "3600 1 0 2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForSaves := func(saves int64) types.SnapshotStatus {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			status := server.GetPersistenceStatus().Snapshot
+			if status.Saves == saves && !status.InProgress {
+				return status
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d snapshots, got %+v", saves, status)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	for i, key := range []string{"key1", "key2"} {
+		if _, err = server.SET(key, "value", SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// One change does not reach any save point.
+			time.Sleep(1500 * time.Millisecond)
+			if saves := server.GetPersistenceStatus().Snapshot.Saves; saves != 0 {
+				t.Fatalf("expected no snapshot after one change, got %d", saves)
+			}
+		}
+	}
+	if status := waitForSaves(1); status.ChangesSinceLastSave != 0 {
+		t.Errorf("expected no changes since the snapshot, got %d", status.ChangesSinceLastSave)
+	}
+
+	// Deleting keys counts as changes too.
+	for _, key := range []string{"key1", "key2"} {
+		if _, err = server.DEL(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForSaves(2)
+
+	// So do the commands that change a value in place.
+	if _, err = server.SADD("set", "member1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.SADD("set", "member2"); err != nil {
+		t.Fatal(err)
+	}
+	waitForSaves(3)
+
+	// No snapshot is taken once the save points are removed.
+	if _, err = server.CONFIG_SET(map[string]string{"save": ""}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"key3", "key4", "key5"} {
+		if _, err = server.SET(key, "value", SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if status := server.GetPersistenceStatus().Snapshot; status.Saves != 3 || status.ChangesSinceLastSave != 3 {
+		t.Errorf("expected no snapshot once automatic snapshots are disabled, got %+v", status)
+	}
+
+	// A cluster node without the snapshot cluster persistence takes no snapshots that save points apply to.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node, err := NewEchoVault(
+		WithContext(ctx),
+		WithCommands(commands.All()),
+		WithConfig(config.Config{
+			ServerID:           "node",
+			BindAddr:           "localhost",
+			Port:               freePort(t),
+			RaftBindPort:       freePort(t),
+			MemberListBindPort: freePort(t),
+			InMemory:           true,
+			BootstrapCluster:   true,
+			DataDir:            t.TempDir(),
+			EvictionPolicy:     constants.NoEviction,
+			SnapShotThreshold:  1000,
+			SnapshotInterval:   5 * time.Minute,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = node.CONFIG_SET(map[string]string{"save": "900 1"}); err == nil {
+		t.Error("expected CONFIG_SET save to fail on a cluster node without the snapshot cluster persistence")
+	}
+}
@@ -23,7 +23,9 @@ import (
 	"github.com/gobwas/glob"
 	"net"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func handleGetAllCommands(_ context.Context, _ []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
@@ -191,6 +193,546 @@ func handleCommandDocs(_ context.Context, _ []string, _ types.EchoVault, _ *net.
 	return []byte("*0\r\n"), nil
 }
 
+func handleSave(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	switch {
+	case len(cmd) == 1:
+		if err := server.TakeSnapshot(); err != nil {
+			return nil, err
+		}
+	case len(cmd) == 3 && strings.EqualFold(cmd[1], "rdb"):
+		if err := server.SaveRDB(cmd[2]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleBGSave(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	schedule := false
+	switch {
+	case len(cmd) == 1:
+	case len(cmd) == 2 && strings.EqualFold(cmd[1], "schedule"):
+		schedule = true
+	default:
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	scheduled, err := server.BackgroundSnapshot(schedule)
+	if err != nil {
+		return nil, err
+	}
+	if scheduled {
+		return []byte("+Background saving scheduled\r\n"), nil
+	}
+	return []byte("+Background saving started\r\n"), nil
+}
+
+func handleRestoreRDB(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if err := server.RestoreRDB(cmd[1]); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleSnapshotList(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	snapshots, err := server.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	res := fmt.Sprintf("*%d\r\n", len(snapshots))
+	for _, snapshot := range snapshots {
+		t := time.UnixMilli(snapshot.ID).UTC().Format(time.RFC3339Nano)
+		res += fmt.Sprintf("*8\r\n$2\r\nid\r\n:%d\r\n$4\r\ntime\r\n$%d\r\n%s\r\n$4\r\nsize\r\n:%d\r\n$4\r\nhash\r\n$%d\r\n%s\r\n",
+			snapshot.ID, len(t), t, snapshot.Size, len(snapshot.Hash), snapshot.Hash)
+	}
+	return []byte(res), nil
+}
+
+func handleSnapshotRestore(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	id, err := strconv.ParseInt(cmd[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("snapshot id must be an integer")
+	}
+	if err = server.RestoreSnapshot(id); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+// encodeBackup returns the description of a backup as an array of field/value pairs.
+func encodeBackup(backup types.BackupInfo) string {
+	t := time.UnixMilli(backup.Time).UTC().Format(time.RFC3339Nano)
+	return fmt.Sprintf("*16\r\n$2\r\nid\r\n:%d\r\n$4\r\ntype\r\n$%d\r\n%s\r\n$6\r\nparent\r\n:%d\r\n"+
+		"$4\r\ntime\r\n$%d\r\n%s\r\n$4\r\nsize\r\n:%d\r\n$4\r\nkeys\r\n:%d\r\n"+
+		"$12\r\ndeleted_keys\r\n:%d\r\n$8\r\nchecksum\r\n$%d\r\n%s\r\n",
+		backup.ID, len(backup.Type), backup.Type, backup.Parent, len(t), t, backup.Size, backup.Keys,
+		backup.DeletedKeys, len(backup.Checksum), backup.Checksum)
+}
+
+func handleBackupCreate(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) > 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	backupType := "incremental"
+	if len(cmd) == 3 {
+		backupType = strings.ToLower(cmd[2])
+	}
+	backup, err := server.CreateBackup(backupType)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(encodeBackup(backup)), nil
+}
+
+func handleBackupList(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	backups, err := server.ListBackups()
+	if err != nil {
+		return nil, err
+	}
+	res := fmt.Sprintf("*%d\r\n", len(backups))
+	for _, backup := range backups {
+		res += encodeBackup(backup)
+	}
+	return []byte(res), nil
+}
+
+func handleBackupVerify(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) > 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	var id int64
+	if len(cmd) == 3 {
+		var err error
+		if id, err = strconv.ParseInt(cmd[2], 10, 64); err != nil {
+			return nil, errors.New("backup id must be an integer")
+		}
+	}
+	checks, err := server.VerifyBackups(id)
+	if err != nil {
+		return nil, err
+	}
+	res := fmt.Sprintf("*%d\r\n", len(checks))
+	for _, check := range checks {
+		status := "ok"
+		if check.Error != "" {
+			status = check.Error
+		}
+		res += fmt.Sprintf("*4\r\n$2\r\nid\r\n:%d\r\n$6\r\nstatus\r\n$%d\r\n%s\r\n", check.ID, len(status), status)
+	}
+	return []byte(res), nil
+}
+
+func handleBackupRestore(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) > 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	var id int64
+	if len(cmd) == 3 {
+		var err error
+		if id, err = strconv.ParseInt(cmd[2], 10, 64); err != nil {
+			return nil, errors.New("backup id must be an integer")
+		}
+	}
+	if err := server.RestoreBackup(id); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleReplicationStatus(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	return []byte(encodeInfoFields(replicationInfo(server))), nil
+}
+
+// encodeInfoFields encodes the fields as an array of name/value pairs. Strings are bulk strings and the other
+// values are integers.
+func encodeInfoFields(fields []infoField) string {
+	res := fmt.Sprintf("*%d\r\n", len(fields)*2)
+	for _, field := range fields {
+		res += fmt.Sprintf("$%d\r\n%s\r\n", len(field.name), field.name)
+		switch value := field.value.(type) {
+		case string:
+			res += fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+		default:
+			res += fmt.Sprintf(":%d\r\n", value)
+		}
+	}
+	return res
+}
+
+func handleReplicationPromote(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if err := server.PromoteReplica(); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+// handleReplicate handles the commands that the leader of the primary cluster sends to the replica cluster.
+func handleReplicate(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	return server.Replicate(cmd)
+}
+
+func handleClusterMembers(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	members, err := server.GetClusterMembers()
+	if err != nil {
+		return nil, err
+	}
+	res := fmt.Sprintf("*%d\r\n", len(members))
+	for _, member := range members {
+		leader := 0
+		if member.Leader {
+			leader = 1
+		}
+		res += fmt.Sprintf("*10\r\n$2\r\nid\r\n$%d\r\n%s\r\n$7\r\naddress\r\n$%d\r\n%s\r\n"+
+			"$8\r\nsuffrage\r\n$%d\r\n%s\r\n$6\r\nleader\r\n:%d\r\n$20\r\nlast_contact_time_ms\r\n:%d\r\n",
+			len(member.ID), member.ID, len(member.Address), member.Address, len(member.Suffrage), member.Suffrage,
+			leader, member.LastContact)
+	}
+	return []byte(res), nil
+}
+
+func handleClusterInfo(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	info, err := server.GetClusterInfo()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(encodeInfoFields([]infoField{
+		{"state", info.State},
+		{"term", info.Term},
+		{"leader_id", info.LeaderID},
+		{"leader_address", info.LeaderAddress},
+		{"commit_index", info.CommitIndex},
+		{"applied_index", info.AppliedIndex},
+		{"last_log_index", info.LastLogIndex},
+		{"last_snapshot_index", info.LastSnapshotIndex},
+		{"last_snapshot_term", info.LastSnapshotTerm},
+		{"last_snapshot_time_ms", info.LastSnapshotTime},
+		{"voters", info.Voters},
+		{"learners", info.Learners},
+	})), nil
+}
+
+func handleClusterNodes(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	nodes, err := server.GetClusterNodes()
+	if err != nil {
+		return nil, err
+	}
+	res := fmt.Sprintf("*%d\r\n", len(nodes))
+	for _, node := range nodes {
+		leader := 0
+		if node.Leader {
+			leader = 1
+		}
+		res += encodeInfoFields([]infoField{
+			{"id", node.ID},
+			{"raft_address", node.RaftAddress},
+			{"memberlist_address", node.MemberlistAddress},
+			{"suffrage", node.Suffrage},
+			{"leader", leader},
+			{"health", node.Health},
+			{"last_contact_time_ms", node.LastContact},
+		})
+	}
+	return []byte(res), nil
+}
+
+func handleClusterForget(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if err := server.ForgetClusterMember(cmd[2]); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterFailover(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) > 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	id := ""
+	if len(cmd) == 3 {
+		id = cmd[2]
+	}
+	if err := server.FailoverCluster(id); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterLeave(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if err := server.LeaveCluster(); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterKeyring(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) > 3 || (len(cmd) == 3 && !strings.EqualFold(cmd[2], "reload")) {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if len(cmd) == 3 {
+		if err := server.ReloadGossipKeys(); err != nil {
+			return nil, err
+		}
+	}
+	keys, err := server.GetGossipKeys()
+	if err != nil {
+		return nil, err
+	}
+	res := fmt.Sprintf("*%d\r\n", len(keys))
+	for _, key := range keys {
+		primary := 0
+		if key.Primary {
+			primary = 1
+		}
+		res += encodeInfoFields([]infoField{{"id", key.ID}, {"primary", primary}})
+	}
+	return []byte(res), nil
+}
+
+func handleClusterPromote(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if err := server.PromoteClusterMember(cmd[2]); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterDemote(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if err := server.DemoteClusterMember(cmd[2]); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleAOFStatus(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	status, err := server.GetAOFStatus()
+	if err != nil {
+		return nil, err
+	}
+	inProgress := 0
+	if status.RewriteInProgress {
+		inProgress = 1
+	}
+	fields := []struct {
+		name  string
+		value interface{}
+	}{
+		{"rewrite_in_progress", inProgress},
+		{"rewrite_start_time", status.RewriteStartTime},
+		{"rewrites", status.Rewrites},
+		{"auto_rewrites", status.AutoRewrites},
+		{"failed_rewrites", status.FailedRewrites},
+		{"last_rewrite_time", status.LastRewriteTime},
+		{"last_rewrite_duration_ms", status.LastRewriteDuration},
+		{"last_rewrite_error", status.LastRewriteError},
+		{"size", status.Size},
+		{"base_size", status.BaseSize},
+		{"auto_rewrite_percentage", status.AutoRewritePercentage},
+		{"auto_rewrite_min_size", status.AutoRewriteMinSize},
+	}
+	res := fmt.Sprintf("*%d\r\n", len(fields)*2)
+	for _, field := range fields {
+		res += fmt.Sprintf("$%d\r\n%s\r\n", len(field.name), field.name)
+		if value, ok := field.value.(string); ok {
+			res += fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+			continue
+		}
+		res += fmt.Sprintf(":%d\r\n", field.value)
+	}
+	return []byte(res), nil
+}
+
+type infoField struct {
+	name  string
+	value interface{}
+}
+
+// infoSections are the sections returned by INFO, in order.
+var infoSections = []struct {
+	name   string
+	title  string
+	fields func(server types.EchoVault) []infoField
+}{
+	{name: "persistence", title: "Persistence", fields: persistenceInfo},
+	{name: "replication", title: "Replication", fields: replicationInfo},
+}
+
+func handleInfo(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	all := len(cmd) == 1 || slices.ContainsFunc(cmd[1:], func(section string) bool {
+		return slices.ContainsFunc([]string{"all", "default", "everything"}, func(s string) bool {
+			return strings.EqualFold(s, section)
+		})
+	})
+	var res strings.Builder
+	for _, section := range infoSections {
+		if !all && !slices.ContainsFunc(cmd[1:], func(s string) bool { return strings.EqualFold(s, section.name) }) {
+			continue
+		}
+		if res.Len() > 0 {
+			res.WriteString("\r\n")
+		}
+		res.WriteString(fmt.Sprintf("# %s\r\n", section.title))
+		for _, field := range section.fields(server) {
+			res.WriteString(fmt.Sprintf("%s:%v\r\n", field.name, field.value))
+		}
+	}
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", res.Len(), res.String())), nil
+}
+
+// persistenceInfo returns the fields of the persistence section. The fields shared with Redis keep their
+// names and units, and the other times are in unix milliseconds.
+func persistenceInfo(server types.EchoVault) []infoField {
+	status := server.GetPersistenceStatus()
+	flag := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	result := func(err string) string {
+		if err != "" {
+			return "err"
+		}
+		return "ok"
+	}
+
+	fields := []infoField{
+		{"rdb_changes_since_last_save", status.Snapshot.ChangesSinceLastSave},
+		{"rdb_bgsave_in_progress", flag(status.Snapshot.InProgress)},
+		{"rdb_bgsave_scheduled", flag(status.Snapshot.Scheduled)},
+		{"rdb_current_bgsave_start_time_ms", status.Snapshot.StartTime},
+		{"rdb_last_save_time", status.Snapshot.LastSaveTime / 1000},
+		{"rdb_last_save_time_ms", status.Snapshot.LastSaveTime},
+		{"rdb_last_bgsave_status", result(status.Snapshot.LastError)},
+		{"rdb_last_bgsave_duration_ms", status.Snapshot.LastDuration},
+		{"rdb_last_bgsave_size", status.Snapshot.LastSize},
+		{"rdb_last_success_time_ms", status.Snapshot.LastSuccessTime},
+		{"rdb_last_failure_time_ms", status.Snapshot.LastFailureTime},
+		{"rdb_last_error", status.Snapshot.LastError},
+		{"rdb_saves", status.Snapshot.Saves},
+		{"rdb_failed_saves", status.Snapshot.FailedSaves},
+		{"aof_enabled", flag(status.AOFEnabled)},
+	}
+	if !status.AOFEnabled {
+		return fields
+	}
+	return append(fields, []infoField{
+		{"aof_rewrite_in_progress", flag(status.AOF.RewriteInProgress)},
+		{"aof_current_rewrite_start_time_ms", status.AOF.RewriteStartTime},
+		{"aof_last_bgrewrite_status", result(status.AOF.LastRewriteError)},
+		{"aof_last_rewrite_time_ms", status.AOF.LastRewriteTime},
+		{"aof_last_rewrite_duration_ms", status.AOF.LastRewriteDuration},
+		{"aof_last_rewrite_error", status.AOF.LastRewriteError},
+		{"aof_rewrites", status.AOF.Rewrites},
+		{"aof_auto_rewrites", status.AOF.AutoRewrites},
+		{"aof_failed_rewrites", status.AOF.FailedRewrites},
+		{"aof_current_size", status.AOF.Size},
+		{"aof_base_size", status.AOF.BaseSize},
+	}...)
+}
+
+// replicationInfo returns the fields of the replication section. The indexes are indexes of the raft log of the
+// primary cluster, and the times are in unix milliseconds.
+func replicationInfo(server types.EchoVault) []infoField {
+	status := server.GetReplicationStatus()
+	linkStatus := "down"
+	if status.Connected {
+		linkStatus = "up"
+	}
+	syncing := 0
+	if status.Syncing {
+		syncing = 1
+	}
+	return []infoField{
+		{"role", status.Role},
+		{"link_status", linkStatus},
+		{"target", status.Target},
+		{"checkpoint", status.Checkpoint},
+		{"primary_index", status.PrimaryIndex},
+		{"lag_entries", status.LagEntries},
+		{"lag_ms", status.Lag},
+		{"last_contact_time_ms", status.LastContact},
+		{"full_syncs", status.FullSyncs},
+		{"sync_in_progress", syncing},
+		{"last_error", status.LastError},
+	}
+}
+
+func handleConfigGet(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) < 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	parameters := server.GetConfigParameters(cmd[2:]...)
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	res := fmt.Sprintf("*%d\r\n", len(names)*2)
+	for _, name := range names {
+		res += fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(name), name, len(parameters[name]), parameters[name])
+	}
+	return []byte(res), nil
+}
+
+func handleConfigSet(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if len(cmd) < 4 || len(cmd)%2 != 0 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	parameters := make(map[string]string)
+	for i := 2; i < len(cmd); i += 2 {
+		name := strings.ToLower(cmd[i])
+		if _, ok := parameters[name]; ok {
+			return nil, fmt.Errorf("duplicate config parameter '%s'", name)
+		}
+		parameters[name] = cmd[i+1]
+	}
+	if err := server.SetConfigParameters(parameters); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
 func Commands() []types.Command {
 	return []types.Command{
 		{
@@ -271,11 +813,62 @@ Allows for filtering by ACL category or glob pattern.`,
 			},
 		},
 		{
-			Command:     "save",
+			Command:    "save",
+			Module:     constants.AdminModule,
+			Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+			Description: `(SAVE [RDB path]) Save a snapshot of this node and wait for it to complete.
+In a replication cluster, a raft snapshot is taken. When RDB is provided, the current state is written to
+the given path as a Redis RDB file instead.`,
+			Sync: false,
+			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+				return types.AccessKeys{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleSave,
+		},
+		{
+			Command:    "bgsave",
+			Module:     constants.AdminModule,
+			Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+			Description: `(BGSAVE [SCHEDULE]) Save a snapshot of this node in the background.
+The snapshot is not started while the AOF is rewritten. With SCHEDULE, it is started once the rewrite finishes.
+The result of the snapshot is reported by INFO persistence.`,
+			Sync: false,
+			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+				return types.AccessKeys{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleBGSave,
+		},
+		{
+			Command:    "info",
+			Module:     constants.AdminModule,
+			Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+			Description: `(INFO [section [section ...]]) Get information about the node as "field:value" lines, grouped in sections.
+The only section is persistence, which reports the progress and the results of the snapshots and of the AOF rewrites.
+With no section, or with all, default or everything, all the sections are returned.`,
+			Sync: false,
+			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+				return types.AccessKeys{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleInfo,
+		},
+		{
+			Command:     "config",
 			Module:      constants.AdminModule,
-			Categories:  []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
-			Description: "(SAVE) Trigger a snapshot save",
-			Sync:        true,
+			Categories:  []string{},
+			Description: "Commands pertaining to the configuration of the node",
+			Sync:        false,
 			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
 				return types.AccessKeys{
 					Channels:  make([]string, 0),
@@ -283,18 +876,112 @@ Allows for filtering by ACL category or glob pattern.`,
 					WriteKeys: make([]string, 0),
 				}, nil
 			},
-			HandlerFunc: func(ctx context.Context, cmd []string, server types.EchoVault, conn *net.Conn) ([]byte, error) {
-				if err := server.TakeSnapshot(); err != nil {
-					return nil, err
-				}
-				return []byte(constants.OkResponse), nil
+			SubCommands: []types.SubCommand{
+				{
+					Command:    "get",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CONFIG GET parameter [parameter ...]) Get the configuration parameters that match any of the glob patterns
+as name/value pairs. The only parameter is save.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleConfigGet,
+				},
+				{
+					Command:    "set",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CONFIG SET parameter value [parameter value ...]) Change configuration parameters on this node
+until it restarts. If any parameter or value is invalid, no parameter is changed. The only parameter is save,
+which holds the save points that trigger snapshots as "seconds changes" pairs. An empty value disables automatic snapshots.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleConfigSet,
+				},
 			},
 		},
 		{
-			Command:     "lastsave",
+			Command:    "restore-rdb",
+			Module:     constants.AdminModule,
+			Categories: []string{constants.AdminCategory, constants.WriteCategory, constants.SlowCategory, constants.DangerousCategory},
+			Description: `(RESTORE-RDB path) Load the keys of the Redis RDB file at the given path.
+Existing keys with the same name are replaced. In a replication cluster, the file must exist on every node.`,
+			Sync: true,
+			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+				return types.AccessKeys{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleRestoreRDB,
+		},
+		{
+			Command:     "snapshot",
 			Module:      constants.AdminModule,
-			Categories:  []string{constants.AdminCategory, constants.FastCategory, constants.DangerousCategory},
-			Description: "(LASTSAVE) Get unix timestamp for the latest snapshot in milliseconds.",
+			Categories:  []string{},
+			Description: "Commands pertaining to standalone snapshots",
+			Sync:        false,
+			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+				return types.AccessKeys{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			SubCommands: []types.SubCommand{
+				{
+					Command:    "list",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory},
+					Description: `(SNAPSHOT LIST) List the snapshots that are kept on disk, oldest first.
+Each snapshot is described by its id, the time it was taken, the size of the file in bytes and the digest of its contents.
+Only works in standalone mode.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleSnapshotList,
+				},
+				{
+					Command:    "restore",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(SNAPSHOT RESTORE id) Replace the current state with the state in the snapshot with the given id.
+The AOF is rewritten from the restored state. Only works in standalone mode.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleSnapshotRestore,
+				},
+			},
+		},
+		{
+			Command:     "lastsave",
+			Module:      constants.AdminModule,
+			Categories:  []string{constants.AdminCategory, constants.FastCategory, constants.DangerousCategory},
+			Description: "(LASTSAVE) Get unix timestamp for the latest snapshot in milliseconds.",
 			Sync:        false,
 			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
 				return types.AccessKeys{
@@ -311,6 +998,438 @@ Allows for filtering by ACL category or glob pattern.`,
 				return []byte(fmt.Sprintf(":%d\r\n", msec)), nil
 			},
 		},
+		{
+			Command:     "backup",
+			Module:      constants.AdminModule,
+			Categories:  []string{},
+			Description: "Commands pertaining to backups",
+			Sync:        false,
+			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+				return types.AccessKeys{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			SubCommands: []types.SubCommand{
+				{
+					Command:    "create",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(BACKUP CREATE [FULL | INCREMENTAL | DIFFERENTIAL]) Take a backup in the backup directory and describe it.
+A full backup holds every key. An incremental backup holds the keys that changed since the previous backup, and a
+differential backup holds the keys that changed since the previous full backup. The default is INCREMENTAL.
+The first backup after startup is always a full backup.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleBackupCreate,
+				},
+				{
+					Command:    "list",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory},
+					Description: `(BACKUP LIST) List the backups in the backup directory, oldest first. Each backup is described by its id, type,
+the id of the backup it is relative to, the time it was taken, the size of the file in bytes, the number of keys
+and deleted keys it holds, and the SHA-256 checksum of the file.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleBackupList,
+				},
+				{
+					Command:    "verify",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory},
+					Description: `(BACKUP VERIFY [id]) Check the backup with the given id and the backups it depends on, or every backup.
+Returns the status of each backup, which is ok if the file matches its checksum and can be read completely.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleBackupVerify,
+				},
+				{
+					Command:    "restore",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(BACKUP RESTORE [id]) Replace the current state with the state in the backup with the given id, or in the latest
+backup. The full backup it depends on is loaded, followed by each backup up to it. All of them are verified first.
+The AOF is rewritten from the restored state. Only works in standalone mode.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleBackupRestore,
+				},
+			},
+		},
+		{
+			Command:    "replication",
+			Module:     constants.AdminModule,
+			Categories: []string{},
+			Description: `Commands pertaining to the replication between a primary cluster and a replica cluster. The subcommands that
+the primary cluster sends are in the replication category, which must only be granted to the user of the primary.`,
+			Sync: false,
+			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+				return types.AccessKeys{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			SubCommands: []types.SubCommand{
+				{
+					Command:    "status",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory},
+					Description: `(REPLICATION STATUS) Describe the replication between the primary cluster and the replica cluster.
+Returns the role of the node, whether the primary and the replica are connected, the replica the primary ships to,
+the checkpoint of the replica, the last index of the primary, the lag in entries and milliseconds, the time of the
+last contact, the number of full syncs, whether a full sync is in progress, and the last error.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleReplicationStatus,
+				},
+				{
+					Command:    "promote",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(REPLICATION PROMOTE) Promote the replica cluster, so that it accepts client writes and stops accepting
+entries from the primary cluster. Must be sent to the leader of the replica cluster.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleReplicationPromote,
+				},
+				{
+					Command:    "handshake",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory, constants.ReplicationCategory},
+					Description: `(REPLICATION HANDSHAKE) Sent by the primary cluster. Returns the index of the last entry of the primary that the
+replica applied.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleReplicate,
+				},
+				{
+					Command:    "ping",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory, constants.ReplicationCategory},
+					Description: `(REPLICATION PING primary-index) Sent by the primary cluster while there are no entries to ship.
+Returns the checkpoint of the replica.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleReplicate,
+				},
+				{
+					Command:    "apply",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory, constants.ReplicationCategory},
+					Description: `(REPLICATION APPLY from through primary-index [entry ...]) Sent by the primary cluster. Applies the entries
+of the primary between from and through, and returns the new checkpoint of the replica.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleReplicate,
+				},
+				{
+					Command:    "sync-start",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory, constants.ReplicationCategory},
+					Description: `(REPLICATION SYNC-START index indexes) Sent by the primary cluster. Starts a full sync from the snapshot
+of the primary at index, deleting every key of the replica.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleReplicate,
+				},
+				{
+					Command:    "sync-load",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory, constants.ReplicationCategory},
+					Description: `(REPLICATION SYNC-LOAD [key expire-at payload ...]) Sent by the primary cluster. Restores keys of the snapshot
+of a full sync from their base64 encoded DUMP payload and their expiry time in unix milliseconds.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleReplicate,
+				},
+				{
+					Command:    "sync-end",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory, constants.ReplicationCategory},
+					Description: `(REPLICATION SYNC-END index) Sent by the primary cluster. Finishes the full sync, and returns the new
+checkpoint of the replica.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleReplicate,
+				},
+			},
+		},
+		{
+			Command:     "cluster",
+			Module:      constants.AdminModule,
+			Categories:  []string{},
+			Description: "Commands pertaining to the raft cluster",
+			Sync:        false,
+			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+				return types.AccessKeys{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			SubCommands: []types.SubCommand{
+				{
+					Command:    "info",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory},
+					Description: `(CLUSTER INFO) Get the raft state of the node as field/value pairs: its state, the current term, the id and raft
+address of the leader, the commit index, the applied index, the last log index, the index and term of the latest raft
+snapshot, the unix time in milliseconds of the latest snapshot, and the number of voters and learners.
+Only works in cluster mode.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterInfo,
+				},
+				{
+					Command:    "nodes",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory},
+					Description: `(CLUSTER NODES) List the members of the raft configuration and the nodes of the memberlist with their id, raft
+address, memberlist address, suffrage, whether they are the leader, their health, and the unix time in milliseconds
+of the last raft contact with them. The health is alive or suspect according to the memberlist, or failed if the
+memberlist lost the node. The suffrage of a node that is only in the memberlist is none. Only works in cluster mode.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterNodes,
+				},
+				{
+					Command:    "members",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory},
+					Description: `(CLUSTER MEMBERS) List the members of the raft configuration with their id, raft address, suffrage (voter,
+learner or staging), whether they are the leader, and the unix time in milliseconds of the last contact with them.
+The leader knows the last contact with every member. Other nodes only know the last contact with the leader,
+and return 0 for the rest. Only works in cluster mode.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterMembers,
+				},
+				{
+					Command:    "promote",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER PROMOTE server-id) Promote a learner to a voter, so that it votes in elections and counts
+towards the commit quorum. Must be sent to the leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterPromote,
+				},
+				{
+					Command:    "demote",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER DEMOTE server-id) Demote a voter to a learner, which keeps receiving the log and serving reads.
+The leader cannot be demoted. Must be sent to the leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterDemote,
+				},
+				{
+					Command:    "forget",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER FORGET server-id) Remove a failed node from the raft configuration. A node that is still alive must
+leave with CLUSTER LEAVE instead. Must be sent to the leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterForget,
+				},
+				{
+					Command:    "failover",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER FAILOVER [server-id]) Transfer the leadership to the voter with the given id, or to the most up to date
+voter if no id is given. Must be sent to the leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterFailover,
+				},
+				{
+					Command:    "leave",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER LEAVE) Gracefully remove the node from the cluster. A leader transfers its leadership first. The node
+leaves the memberlist, the leader removes it from the raft configuration, and the node stops replicating.
+It keeps serving reads of its last state until it is shut down.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterLeave,
+				},
+				{
+					Command:    "keyring",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER KEYRING [RELOAD]) List the IDs of the keys that encrypt the memberlist gossip of the node, and
+whether they are the primary key. With RELOAD, the keys are first replaced with the keys of the gossip key file and
+environment variable, and the last key becomes the primary key. Only affects the node that receives the command.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterKeyring,
+				},
+			},
+		},
+		{
+			Command:     "aof",
+			Module:      constants.AdminModule,
+			Categories:  []string{},
+			Description: "Commands pertaining to the standalone AOF",
+			Sync:        false,
+			KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+				return types.AccessKeys{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			SubCommands: []types.SubCommand{
+				{
+					Command:    "status",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.AdminCategory, constants.FastCategory},
+					Description: `(AOF STATUS) Get the size of the AOF and the progress and statistics of its rewrites as field/value pairs.
+Rewrites are triggered with REWRITEAOF, or automatically once the AOF has grown by auto-aof-rewrite-percentage
+of its size after the last rewrite and is at least auto-aof-rewrite-min-size bytes. Only works in standalone mode.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (types.AccessKeys, error) {
+						return types.AccessKeys{
+							Channels:  make([]string, 0),
+							ReadKeys:  make([]string, 0),
+							WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleAOFStatus,
+				},
+			},
+		},
 		{
 			Command:     "rewriteaof",
 			Module:      constants.AdminModule,
@@ -0,0 +1,319 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	internal_bloom "github.com/echovault/echovault/internal/bloom"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// lockFilter write-locks the key and returns the bloom filter stored at it.
+// If the key does not exist, a filter with the default parameters is created.
+// The caller must unlock the key when the returned error is nil.
+func lockFilter(ctx context.Context, server types.EchoVault, key string) (*internal_bloom.BloomFilter, error) {
+	if _, err := server.CreateKeyAndLock(ctx, key); err != nil {
+		return nil, err
+	}
+	value := server.GetValue(ctx, key)
+	if value == nil {
+		filter := internal_bloom.NewBloomFilter(
+			internal_bloom.DefaultErrorRate, internal_bloom.DefaultCapacity, internal_bloom.DefaultExpansion, false)
+		if err := server.SetValue(ctx, key, filter); err != nil {
+			server.KeyUnlock(ctx, key)
+			return nil, err
+		}
+		return filter, nil
+	}
+	filter, ok := value.(*internal_bloom.BloomFilter)
+	if !ok {
+		server.KeyUnlock(ctx, key)
+		return nil, fmt.Errorf("value at key %s is not a bloom filter", key)
+	}
+	return filter, nil
+}
+
+// rLockFilter read-locks the key and returns the bloom filter stored at it.
+// It returns nil without locking the key if the key does not exist.
+func rLockFilter(ctx context.Context, server types.EchoVault, key string) (*internal_bloom.BloomFilter, error) {
+	if !server.KeyExists(ctx, key) {
+		return nil, nil
+	}
+	if _, err := server.KeyRLock(ctx, key); err != nil {
+		return nil, err
+	}
+	filter, ok := server.GetValue(ctx, key).(*internal_bloom.BloomFilter)
+	if !ok {
+		server.KeyRUnlock(ctx, key)
+		return nil, fmt.Errorf("value at key %s is not a bloom filter", key)
+	}
+	return filter, nil
+}
+
+func handleBFReserve(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := bfReserveKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	errorRate, err := strconv.ParseFloat(cmd[2], 64)
+	if err != nil || errorRate <= 0 || errorRate >= 1 {
+		return nil, errors.New("error rate must be a number between 0 and 1")
+	}
+	capacity, err := strconv.ParseUint(cmd[3], 10, 64)
+	if err != nil || capacity == 0 {
+		return nil, errors.New("capacity must be a positive integer")
+	}
+	if err = internal_bloom.CheckReserve(errorRate, capacity); err != nil {
+		return nil, err
+	}
+	expansion := uint64(internal_bloom.DefaultExpansion)
+	nonScaling := false
+	for i := 4; i < len(cmd); i++ {
+		switch strings.ToLower(cmd[i]) {
+		case "expansion":
+			if i+1 >= len(cmd) {
+				return nil, errors.New("expansion value required after EXPANSION")
+			}
+			if expansion, err = strconv.ParseUint(cmd[i+1], 10, 32); err != nil || expansion == 0 {
+				return nil, errors.New("expansion must be a positive integer")
+			}
+			i += 1
+		case "nonscaling":
+			nonScaling = true
+		default:
+			return nil, fmt.Errorf("unknown option %s", strings.ToUpper(cmd[i]))
+		}
+	}
+
+	if server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s already exists", key)
+	}
+	if _, err = server.CreateKeyAndLock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+	filter := internal_bloom.NewBloomFilter(errorRate, capacity, uint32(expansion), nonScaling)
+	if err = server.SetValue(ctx, key, filter); err != nil {
+		return nil, err
+	}
+
+	return []byte(constants.OkResponse), nil
+}
+
+func handleBFAdd(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := bfAddKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	filter, err := lockFilter(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+
+	added, err := filter.Add(cmd[2])
+	if err != nil {
+		return nil, err
+	}
+	if added {
+		return []byte(":1\r\n"), nil
+	}
+	return []byte(":0\r\n"), nil
+}
+
+func handleBFMAdd(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := bfMAddKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	filter, err := lockFilter(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+
+	res := fmt.Sprintf("*%d\r\n", len(cmd[2:]))
+	for _, item := range cmd[2:] {
+		added, err := filter.Add(item)
+		switch {
+		case err != nil:
+			res += fmt.Sprintf("-%s\r\n", err.Error())
+		case added:
+			res += ":1\r\n"
+		default:
+			res += ":0\r\n"
+		}
+	}
+	return []byte(res), nil
+}
+
+func handleBFExists(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := bfExistsKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	filter, err := rLockFilter(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return []byte(":0\r\n"), nil
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	if filter.Exists(cmd[2]) {
+		return []byte(":1\r\n"), nil
+	}
+	return []byte(":0\r\n"), nil
+}
+
+func handleBFMExists(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := bfMExistsKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	filter, err := rLockFilter(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		defer server.KeyRUnlock(ctx, key)
+	}
+
+	res := fmt.Sprintf("*%d\r\n", len(cmd[2:]))
+	for _, item := range cmd[2:] {
+		if filter != nil && filter.Exists(item) {
+			res += ":1\r\n"
+		} else {
+			res += ":0\r\n"
+		}
+	}
+	return []byte(res), nil
+}
+
+func handleBFInfo(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := bfInfoKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	filter, err := rLockFilter(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	info := []struct {
+		name  string
+		value uint64
+	}{
+		{name: "Capacity", value: filter.Capacity()},
+		{name: "Size", value: uint64(filter.Size())},
+		{name: "Number of filters", value: uint64(filter.Filters())},
+		{name: "Number of items inserted", value: filter.Count()},
+		{name: "Expansion rate", value: uint64(filter.Expansion())},
+	}
+	res := fmt.Sprintf("*%d\r\n", len(info)*2)
+	for _, entry := range info {
+		res += fmt.Sprintf("$%d\r\n%s\r\n:%d\r\n", len(entry.name), entry.name, entry.value)
+	}
+	return []byte(res), nil
+}
+
+func Commands() []types.Command {
+	return []types.Command{
+		{
+			Command:    "bf.reserve",
+			Module:     constants.BloomModule,
+			Categories: []string{constants.BloomCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING])
+Creates an empty scalable bloom filter. When the filter reaches its capacity, a new sub-filter
+with expansion times the capacity is added, unless NONSCALING is provided. The capacity is at most 1073741824,
+and the first sub-filter at most 2^32 bits.`,
+			Sync:              true,
+			KeyExtractionFunc: bfReserveKeyFunc,
+			HandlerFunc:       handleBFReserve,
+		},
+		{
+			Command:    "bf.add",
+			Module:     constants.BloomModule,
+			Categories: []string{constants.BloomCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(BF.ADD key item) Adds the item to the bloom filter, creating the filter if it does not exist.
+Returns 1 if the item was added and 0 if it may have been added before.`,
+			Sync:              true,
+			KeyExtractionFunc: bfAddKeyFunc,
+			HandlerFunc:       handleBFAdd,
+		},
+		{
+			Command:    "bf.madd",
+			Module:     constants.BloomModule,
+			Categories: []string{constants.BloomCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(BF.MADD key item [item ...]) Adds one or more items to the bloom filter,
+creating the filter if it does not exist.`,
+			Sync:              true,
+			KeyExtractionFunc: bfMAddKeyFunc,
+			HandlerFunc:       handleBFMAdd,
+		},
+		{
+			Command:    "bf.exists",
+			Module:     constants.BloomModule,
+			Categories: []string{constants.BloomCategory, constants.ReadCategory, constants.FastCategory},
+			Description: `(BF.EXISTS key item) Returns 1 if the item may have been added to the bloom filter,
+and 0 if it definitely was not.`,
+			Sync:              false,
+			KeyExtractionFunc: bfExistsKeyFunc,
+			HandlerFunc:       handleBFExists,
+		},
+		{
+			Command:           "bf.mexists",
+			Module:            constants.BloomModule,
+			Categories:        []string{constants.BloomCategory, constants.ReadCategory, constants.FastCategory},
+			Description:       `(BF.MEXISTS key item [item ...]) Checks whether each of the items may exist in the bloom filter.`,
+			Sync:              false,
+			KeyExtractionFunc: bfMExistsKeyFunc,
+			HandlerFunc:       handleBFMExists,
+		},
+		{
+			Command:    "bf.info",
+			Module:     constants.BloomModule,
+			Categories: []string{constants.BloomCategory, constants.ReadCategory, constants.FastCategory},
+			Description: `(BF.INFO key) Returns the capacity, memory size in bytes, number of sub-filters,
+number of items inserted and expansion rate of the bloom filter.`,
+			Sync:              false,
+			KeyExtractionFunc: bfInfoKeyFunc,
+			HandlerFunc:       handleBFInfo,
+		},
+	}
+}
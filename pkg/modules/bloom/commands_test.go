@@ -0,0 +1,328 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	internal_bloom "github.com/echovault/echovault/internal/bloom"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/echovault"
+	"github.com/tidwall/resp"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+var mockServer *echovault.EchoVault
+
+func init() {
+	mockServer, _ = echovault.NewEchoVault(
+		echovault.WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+}
+
+func readIntegers(t *testing.T, res []byte) []int {
+	rd := resp.NewReader(bytes.NewBuffer(res))
+	rv, _, err := rd.ReadValue()
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	if rv.Type() != resp.Array {
+		return []int{rv.Integer()}
+	}
+	var integers []int
+	for _, v := range rv.Array() {
+		integers = append(integers, v.Integer())
+	}
+	return integers
+}
+
+func Test_HandleBFReserve(t *testing.T) {
+	tests := []struct {
+		name          string
+		preset        bool
+		presetValue   interface{}
+		key           string
+		command       []string
+		expectedError error
+	}{
+		{
+			name:    "1. Reserve a scalable filter",
+			key:     "BfReserveKey1",
+			command: []string{"BF.RESERVE", "BfReserveKey1", "0.01", "1000", "EXPANSION", "4"},
+		},
+		{
+			name:    "2. Reserve a non-scaling filter",
+			key:     "BfReserveKey2",
+			command: []string{"BF.RESERVE", "BfReserveKey2", "0.001", "10", "NONSCALING"},
+		},
+		{
+			name:          "3. Return error when the key already exists",
+			preset:        true,
+			presetValue:   "value",
+			key:           "BfReserveKey3",
+			command:       []string{"BF.RESERVE", "BfReserveKey3", "0.01", "100"},
+			expectedError: errors.New("key BfReserveKey3 already exists"),
+		},
+		{
+			name:          "4. Return error on invalid error rate",
+			key:           "BfReserveKey4",
+			command:       []string{"BF.RESERVE", "BfReserveKey4", "1.5", "100"},
+			expectedError: errors.New("error rate must be a number between 0 and 1"),
+		},
+		{
+			name:          "5. Command too short",
+			key:           "BfReserveKey5",
+			command:       []string{"BF.RESERVE", "BfReserveKey5", "0.01"},
+			expectedError: errors.New(constants.WrongArgsResponse),
+		},
+		{
+			name:          "6. Return error when the capacity is above the limit",
+			key:           "BfReserveKey6",
+			command:       []string{"BF.RESERVE", "BfReserveKey6", "0.01", "2000000000"},
+			expectedError: errors.New("capacity must be at most 1073741824"),
+		},
+		{
+			name:    "7. Return error when the filter would be too large for the error rate",
+			key:     "BfReserveKey7",
+			command: []string{"BF.RESERVE", "BfReserveKey7", "0.000000001", "1000000000"},
+			expectedError: errors.New(
+				"capacity is too large for the error rate, the filter would take more than 4294967296 bits",
+			),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), "test_name", fmt.Sprintf("BF.RESERVE, %d", i))
+			if test.preset {
+				if _, err := mockServer.CreateKeyAndLock(ctx, test.key); err != nil {
+					t.Error(err)
+				}
+				if err := mockServer.SetValue(ctx, test.key, test.presetValue); err != nil {
+					t.Error(err)
+				}
+				mockServer.KeyUnlock(ctx, test.key)
+			}
+			_, err := handleBFReserve(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err = mockServer.KeyRLock(ctx, test.key); err != nil {
+				t.Error(err)
+			}
+			defer mockServer.KeyRUnlock(ctx, test.key)
+			if _, ok := mockServer.GetValue(ctx, test.key).(*internal_bloom.BloomFilter); !ok {
+				t.Errorf("expected bloom filter at key %s", test.key)
+			}
+		})
+	}
+}
+
+func Test_HandleBFAddAndExists(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name             string
+		command          []string
+		expectedResponse []int
+		expectedError    error
+	}{
+		{
+			name:             "1. BF.ADD creates the filter on a non-existent key",
+			command:          []string{"BF.ADD", "BfAddKey1", "apple"},
+			expectedResponse: []int{1},
+		},
+		{
+			name:             "2. BF.ADD returns 0 when the item was already added",
+			command:          []string{"BF.ADD", "BfAddKey1", "apple"},
+			expectedResponse: []int{0},
+		},
+		{
+			name:             "3. BF.MADD adds multiple items",
+			command:          []string{"BF.MADD", "BfAddKey1", "banana", "apple", "cherry"},
+			expectedResponse: []int{1, 0, 1},
+		},
+		{
+			name:             "4. BF.EXISTS returns 1 for an added item",
+			command:          []string{"BF.EXISTS", "BfAddKey1", "banana"},
+			expectedResponse: []int{1},
+		},
+		{
+			name:             "5. BF.EXISTS returns 0 on a non-existent key",
+			command:          []string{"BF.EXISTS", "BfAddKey2", "banana"},
+			expectedResponse: []int{0},
+		},
+		{
+			name:             "6. BF.MEXISTS checks multiple items",
+			command:          []string{"BF.MEXISTS", "BfAddKey1", "apple", "durian", "cherry"},
+			expectedResponse: []int{1, 0, 1},
+		},
+		{
+			name:          "7. Return error when the key does not hold a bloom filter",
+			command:       []string{"BF.ADD", "BfAddKey3", "apple"},
+			expectedError: errors.New("value at key BfAddKey3 is not a bloom filter"),
+		},
+	}
+
+	if _, err := mockServer.CreateKeyAndLock(ctx, "BfAddKey3"); err != nil {
+		t.Error(err)
+	}
+	if err := mockServer.SetValue(ctx, "BfAddKey3", "value"); err != nil {
+		t.Error(err)
+	}
+	mockServer.KeyUnlock(ctx, "BfAddKey3")
+
+	handlers := map[string]func(context.Context, []string, *echovault.EchoVault) ([]byte, error){
+		"BF.ADD": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleBFAdd(ctx, cmd, server, nil)
+		},
+		"BF.MADD": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleBFMAdd(ctx, cmd, server, nil)
+		},
+		"BF.EXISTS": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleBFExists(ctx, cmd, server, nil)
+		},
+		"BF.MEXISTS": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleBFMExists(ctx, cmd, server, nil)
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := handlers[test.command[0]](ctx, test.command, mockServer)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := readIntegers(t, res); !reflect.DeepEqual(got, test.expectedResponse) {
+				t.Errorf("expected response %v, got %v", test.expectedResponse, got)
+			}
+		})
+	}
+}
+
+func Test_BloomFilterScalingAndEncoding(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := handleBFReserve(ctx, []string{"BF.RESERVE", "BfScaleKey1", "0.01", "50", "EXPANSION", "2"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 500; i++ {
+		if _, err := handleBFAdd(ctx, []string{"BF.ADD", "BfScaleKey1", fmt.Sprintf("item-%d", i)}, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if _, err := mockServer.KeyRLock(ctx, "BfScaleKey1"); err != nil {
+		t.Error(err)
+		return
+	}
+	filter := mockServer.GetValue(ctx, "BfScaleKey1").(*internal_bloom.BloomFilter)
+	mockServer.KeyRUnlock(ctx, "BfScaleKey1")
+	if filter.Filters() < 2 {
+		t.Errorf("expected the filter to scale, got %d sub-filters", filter.Filters())
+	}
+	if filter.Capacity() < 500 {
+		t.Errorf("expected capacity of at least 500, got %d", filter.Capacity())
+	}
+
+	// The filter must survive the JSON encoding used by snapshots and the AOF preamble.
+	b, err := json.Marshal(internal.KeyData{Value: filter})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var data internal.KeyData
+	if err = json.Unmarshal(b, &data); err != nil {
+		t.Error(err)
+		return
+	}
+	restored, ok := data.Value.(*internal_bloom.BloomFilter)
+	if !ok {
+		t.Errorf("expected restored value to be a bloom filter, got %T", data.Value)
+		return
+	}
+	// An encoding that is truncated, or holds a sub-filter without bits, is rejected.
+	encoded, err := filter.MarshalBinary()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = (&internal_bloom.BloomFilter{}).UnmarshalBinary(encoded[:len(encoded)-1]); err == nil {
+		t.Error("expected a truncated encoding to be rejected")
+	}
+	zeroSize := slices.Clone(encoded)
+	binary.LittleEndian.PutUint64(zeroSize[17:], 0)
+	if err = (&internal_bloom.BloomFilter{}).UnmarshalBinary(zeroSize); err == nil {
+		t.Error("expected a sub-filter without bits to be rejected")
+	}
+
+	for i := 0; i < 500; i++ {
+		if !restored.Exists(fmt.Sprintf("item-%d", i)) {
+			t.Errorf("expected restored filter to contain item-%d", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if restored.Exists(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 20 {
+		t.Errorf("expected a false positive rate close to 1%%, got %d in 1000", falsePositives)
+	}
+
+	res, err := handleBFInfo(ctx, []string{"BF.INFO", "BfScaleKey1"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rv, _, _ := resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+	info := rv.Array()
+	// Items that are false positives when added are not counted.
+	if info[6].String() != "Number of items inserted" || info[7].Integer() < 490 || info[7].Integer() > 500 {
+		t.Errorf("expected close to 500 items inserted, got %s %d", info[6].String(), info[7].Integer())
+	}
+	if info[2].String() != "Size" || info[3].Integer() <= 0 {
+		t.Errorf("expected positive size, got %d", info[3].Integer())
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	internal_cms "github.com/echovault/echovault/internal/cms"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"net"
+	"strconv"
+	"strings"
+)
+
+func createSketch(ctx context.Context, server types.EchoVault, key string, width uint32, depth uint32) error {
+	if server.KeyExists(ctx, key) {
+		return fmt.Errorf("key %s already exists", key)
+	}
+	if _, err := server.CreateKeyAndLock(ctx, key); err != nil {
+		return err
+	}
+	defer server.KeyUnlock(ctx, key)
+	return server.SetValue(ctx, key, internal_cms.NewCountMinSketch(width, depth))
+}
+
+func handleCMSInitByDim(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cmsInitByDimKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	width, err := strconv.ParseUint(cmd[2], 10, 32)
+	if err != nil || width == 0 {
+		return nil, errors.New("width must be a positive integer")
+	}
+	depth, err := strconv.ParseUint(cmd[3], 10, 32)
+	if err != nil || depth == 0 {
+		return nil, errors.New("depth must be a positive integer")
+	}
+	if err = createSketch(ctx, server, keys.WriteKeys[0], uint32(width), uint32(depth)); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleCMSInitByProb(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cmsInitByProbKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	errorRate, err := strconv.ParseFloat(cmd[2], 64)
+	if err != nil || errorRate <= 0 || errorRate >= 1 {
+		return nil, errors.New("error must be a number between 0 and 1")
+	}
+	probability, err := strconv.ParseFloat(cmd[3], 64)
+	if err != nil || probability <= 0 || probability >= 1 {
+		return nil, errors.New("probability must be a number between 0 and 1")
+	}
+	width, depth := internal_cms.DimensionsForProbability(errorRate, probability)
+	if err = createSketch(ctx, server, keys.WriteKeys[0], width, depth); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleCMSIncrBy(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cmsIncrByKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	increments := make([]uint64, 0, len(cmd[2:])/2)
+	for i := 3; i < len(cmd); i += 2 {
+		increment, err := strconv.ParseUint(cmd[i], 10, 64)
+		if err != nil {
+			return nil, errors.New("increment must be a non-negative integer")
+		}
+		increments = append(increments, increment)
+	}
+
+	if !server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	if _, err = server.KeyLock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+	sketch, ok := server.GetValue(ctx, key).(*internal_cms.CountMinSketch)
+	if !ok {
+		return nil, fmt.Errorf("value at key %s is not a count-min sketch", key)
+	}
+
+	res := fmt.Sprintf("*%d\r\n", len(increments))
+	for i, increment := range increments {
+		res += fmt.Sprintf(":%d\r\n", sketch.IncrBy(cmd[2+i*2], increment))
+	}
+	return []byte(res), nil
+}
+
+func handleCMSQuery(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cmsQueryKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	if !server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	if _, err = server.KeyRLock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer server.KeyRUnlock(ctx, key)
+	sketch, ok := server.GetValue(ctx, key).(*internal_cms.CountMinSketch)
+	if !ok {
+		return nil, fmt.Errorf("value at key %s is not a count-min sketch", key)
+	}
+
+	res := fmt.Sprintf("*%d\r\n", len(cmd[2:]))
+	for _, item := range cmd[2:] {
+		res += fmt.Sprintf(":%d\r\n", sketch.Query(item))
+	}
+	return []byte(res), nil
+}
+
+func handleCMSMerge(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cmsMergeKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	destination := keys.WriteKeys[0]
+
+	weights := make([]uint64, len(keys.ReadKeys))
+	for i := range weights {
+		weights[i] = 1
+	}
+	rest := cmd[3+len(keys.ReadKeys):]
+	if len(rest) > 0 {
+		if !strings.EqualFold(rest[0], "weights") || len(rest)-1 != len(keys.ReadKeys) {
+			return nil, errors.New("WEIGHTS must be followed by one weight per source key")
+		}
+		for i, w := range rest[1:] {
+			if weights[i], err = strconv.ParseUint(w, 10, 64); err != nil {
+				return nil, errors.New("weight must be a non-negative integer")
+			}
+		}
+	}
+
+	if !server.KeyExists(ctx, destination) {
+		return nil, fmt.Errorf("key %s does not exist", destination)
+	}
+	if _, err = server.KeyLock(ctx, destination); err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, destination)
+	sketch, ok := server.GetValue(ctx, destination).(*internal_cms.CountMinSketch)
+	if !ok {
+		return nil, fmt.Errorf("value at key %s is not a count-min sketch", destination)
+	}
+
+	locks := make(map[string]bool)
+	defer func() {
+		for key, locked := range locks {
+			if locked {
+				server.KeyRUnlock(ctx, key)
+			}
+		}
+	}()
+
+	sources := make([]*internal_cms.CountMinSketch, len(keys.ReadKeys))
+	for i, key := range keys.ReadKeys {
+		if key == destination {
+			// The destination is already write-locked.
+			sources[i] = sketch
+			continue
+		}
+		if !server.KeyExists(ctx, key) {
+			return nil, fmt.Errorf("key %s does not exist", key)
+		}
+		if !locks[key] {
+			if locks[key], err = server.KeyRLock(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+		source, ok := server.GetValue(ctx, key).(*internal_cms.CountMinSketch)
+		if !ok {
+			return nil, fmt.Errorf("value at key %s is not a count-min sketch", key)
+		}
+		sources[i] = source
+	}
+
+	if err = sketch.Merge(sources, weights); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleCMSInfo(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cmsInfoKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	if !server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	if _, err = server.KeyRLock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer server.KeyRUnlock(ctx, key)
+	sketch, ok := server.GetValue(ctx, key).(*internal_cms.CountMinSketch)
+	if !ok {
+		return nil, fmt.Errorf("value at key %s is not a count-min sketch", key)
+	}
+
+	info := []struct {
+		name  string
+		value uint64
+	}{
+		{name: "width", value: uint64(sketch.Width())},
+		{name: "depth", value: uint64(sketch.Depth())},
+		{name: "count", value: sketch.Count()},
+		{name: "size", value: uint64(sketch.Size())},
+	}
+	res := fmt.Sprintf("*%d\r\n", len(info)*2)
+	for _, entry := range info {
+		res += fmt.Sprintf("$%d\r\n%s\r\n:%d\r\n", len(entry.name), entry.name, entry.value)
+	}
+	return []byte(res), nil
+}
+
+func Commands() []types.Command {
+	return []types.Command{
+		{
+			Command:           "cms.initbydim",
+			Module:            constants.CMSModule,
+			Categories:        []string{constants.CMSCategory, constants.WriteCategory, constants.FastCategory},
+			Description:       `(CMS.INITBYDIM key width depth) Creates a count-min sketch with the given dimensions.`,
+			Sync:              true,
+			KeyExtractionFunc: cmsInitByDimKeyFunc,
+			HandlerFunc:       handleCMSInitByDim,
+		},
+		{
+			Command:    "cms.initbyprob",
+			Module:     constants.CMSModule,
+			Categories: []string{constants.CMSCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(CMS.INITBYPROB key error probability) Creates a count-min sketch that overestimates counts
+by at most error (a fraction of the total count), with the given probability of exceeding that error.`,
+			Sync:              true,
+			KeyExtractionFunc: cmsInitByProbKeyFunc,
+			HandlerFunc:       handleCMSInitByProb,
+		},
+		{
+			Command:    "cms.incrby",
+			Module:     constants.CMSModule,
+			Categories: []string{constants.CMSCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(CMS.INCRBY key item increment [item increment ...]) Increases the count of each item
+by its increment. Returns the estimated count of each item after the increment.`,
+			Sync:              true,
+			KeyExtractionFunc: cmsIncrByKeyFunc,
+			HandlerFunc:       handleCMSIncrBy,
+		},
+		{
+			Command:           "cms.query",
+			Module:            constants.CMSModule,
+			Categories:        []string{constants.CMSCategory, constants.ReadCategory, constants.FastCategory},
+			Description:       `(CMS.QUERY key item [item ...]) Returns the estimated count of each item.`,
+			Sync:              false,
+			KeyExtractionFunc: cmsQueryKeyFunc,
+			HandlerFunc:       handleCMSQuery,
+		},
+		{
+			Command:    "cms.merge",
+			Module:     constants.CMSModule,
+			Categories: []string{constants.CMSCategory, constants.WriteCategory, constants.SlowCategory},
+			Description: `(CMS.MERGE destination numKeys source [source ...] [WEIGHTS weight [weight ...]])
+Replaces the destination sketch with the weighted sum of the source sketches.
+All the sketches must have the same width and depth.`,
+			Sync:              true,
+			KeyExtractionFunc: cmsMergeKeyFunc,
+			HandlerFunc:       handleCMSMerge,
+		},
+		{
+			Command:           "cms.info",
+			Module:            constants.CMSModule,
+			Categories:        []string{constants.CMSCategory, constants.ReadCategory, constants.FastCategory},
+			Description:       `(CMS.INFO key) Returns the width, depth, total count and memory size in bytes of the count-min sketch.`,
+			Sync:              false,
+			KeyExtractionFunc: cmsInfoKeyFunc,
+			HandlerFunc:       handleCMSInfo,
+		},
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/echovault/echovault/internal"
+	internal_cms "github.com/echovault/echovault/internal/cms"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/echovault"
+	"github.com/tidwall/resp"
+	"reflect"
+	"testing"
+)
+
+var mockServer *echovault.EchoVault
+
+func init() {
+	mockServer, _ = echovault.NewEchoVault(
+		echovault.WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+}
+
+func readIntegers(t *testing.T, res []byte) []int {
+	rd := resp.NewReader(bytes.NewBuffer(res))
+	rv, _, err := rd.ReadValue()
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	if rv.Type() != resp.Array {
+		return []int{rv.Integer()}
+	}
+	var integers []int
+	for _, v := range rv.Array() {
+		integers = append(integers, v.Integer())
+	}
+	return integers
+}
+
+func Test_HandleCMSCommands(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name             string
+		command          []string
+		expectedResponse []int
+		expectedError    error
+	}{
+		{
+			name:    "1. CMS.INITBYDIM creates a sketch",
+			command: []string{"CMS.INITBYDIM", "CmsKey1", "1000", "5"},
+		},
+		{
+			name:    "2. CMS.INITBYPROB creates a sketch",
+			command: []string{"CMS.INITBYPROB", "CmsKey2", "0.001", "0.01"},
+		},
+		{
+			name:          "3. CMS.INITBYDIM returns error when the key already exists",
+			command:       []string{"CMS.INITBYDIM", "CmsKey1", "1000", "5"},
+			expectedError: errors.New("key CmsKey1 already exists"),
+		},
+		{
+			name:          "4. CMS.INITBYPROB returns error on invalid error rate",
+			command:       []string{"CMS.INITBYPROB", "CmsKey3", "2", "0.01"},
+			expectedError: errors.New("error must be a number between 0 and 1"),
+		},
+		{
+			name:             "5. CMS.INCRBY returns the estimated count of each item",
+			command:          []string{"CMS.INCRBY", "CmsKey1", "apple", "3", "banana", "5", "apple", "2"},
+			expectedResponse: []int{3, 5, 5},
+		},
+		{
+			name:             "6. CMS.QUERY returns the estimated count of each item",
+			command:          []string{"CMS.QUERY", "CmsKey1", "apple", "banana", "cherry"},
+			expectedResponse: []int{5, 5, 0},
+		},
+		{
+			name:          "7. CMS.INCRBY returns error on a non-existent key",
+			command:       []string{"CMS.INCRBY", "CmsKey4", "apple", "1"},
+			expectedError: errors.New("key CmsKey4 does not exist"),
+		},
+		{
+			name:          "8. CMS.INCRBY returns error on invalid increment",
+			command:       []string{"CMS.INCRBY", "CmsKey1", "apple", "-1"},
+			expectedError: errors.New("increment must be a non-negative integer"),
+		},
+		{
+			name:          "9. CMS.INCRBY command with an odd number of arguments",
+			command:       []string{"CMS.INCRBY", "CmsKey1", "apple"},
+			expectedError: errors.New(constants.WrongArgsResponse),
+		},
+		{
+			name:          "10. Return error when the key does not hold a count-min sketch",
+			command:       []string{"CMS.QUERY", "CmsKey5", "apple"},
+			expectedError: errors.New("value at key CmsKey5 is not a count-min sketch"),
+		},
+	}
+
+	if _, err := mockServer.CreateKeyAndLock(ctx, "CmsKey5"); err != nil {
+		t.Error(err)
+	}
+	if err := mockServer.SetValue(ctx, "CmsKey5", "value"); err != nil {
+		t.Error(err)
+	}
+	mockServer.KeyUnlock(ctx, "CmsKey5")
+
+	handlers := map[string]func(context.Context, []string, *echovault.EchoVault) ([]byte, error){
+		"CMS.INITBYDIM": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCMSInitByDim(ctx, cmd, server, nil)
+		},
+		"CMS.INITBYPROB": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCMSInitByProb(ctx, cmd, server, nil)
+		},
+		"CMS.INCRBY": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCMSIncrBy(ctx, cmd, server, nil)
+		},
+		"CMS.QUERY": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCMSQuery(ctx, cmd, server, nil)
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := handlers[test.command[0]](ctx, test.command, mockServer)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if test.expectedResponse == nil {
+				if string(res) != constants.OkResponse {
+					t.Errorf("expected OK response, got %s", string(res))
+				}
+				return
+			}
+			if got := readIntegers(t, res); !reflect.DeepEqual(got, test.expectedResponse) {
+				t.Errorf("expected response %v, got %v", test.expectedResponse, got)
+			}
+		})
+	}
+}
+
+func Test_HandleCMSMerge(t *testing.T) {
+	ctx := context.Background()
+
+	for _, cmd := range [][]string{
+		{"CMS.INITBYDIM", "CmsMergeKey1", "100", "4"},
+		{"CMS.INITBYDIM", "CmsMergeKey2", "100", "4"},
+		{"CMS.INITBYDIM", "CmsMergeKey3", "100", "4"},
+		{"CMS.INITBYDIM", "CmsMergeKey4", "50", "4"},
+	} {
+		if _, err := handleCMSInitByDim(ctx, cmd, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if _, err := handleCMSIncrBy(ctx, []string{"CMS.INCRBY", "CmsMergeKey1", "apple", "2", "banana", "1"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := handleCMSIncrBy(ctx, []string{"CMS.INCRBY", "CmsMergeKey2", "apple", "3"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		name             string
+		command          []string
+		query            []string
+		expectedResponse []int
+		expectedError    error
+	}{
+		{
+			name:             "1. Merge two sketches into a destination",
+			command:          []string{"CMS.MERGE", "CmsMergeKey3", "2", "CmsMergeKey1", "CmsMergeKey2"},
+			query:            []string{"CMS.QUERY", "CmsMergeKey3", "apple", "banana"},
+			expectedResponse: []int{5, 1},
+		},
+		{
+			name: "2. Merge with weights, including the destination as a source",
+			command: []string{
+				"CMS.MERGE", "CmsMergeKey3", "2", "CmsMergeKey3", "CmsMergeKey2", "WEIGHTS", "2", "1",
+			},
+			query:            []string{"CMS.QUERY", "CmsMergeKey3", "apple", "banana"},
+			expectedResponse: []int{13, 2},
+		},
+		{
+			name:          "3. Return error when the dimensions are different",
+			command:       []string{"CMS.MERGE", "CmsMergeKey3", "1", "CmsMergeKey4"},
+			expectedError: errors.New("width/depth of the sketches must be the same"),
+		},
+		{
+			name:          "4. Return error when a source does not exist",
+			command:       []string{"CMS.MERGE", "CmsMergeKey3", "1", "CmsMergeKey5"},
+			expectedError: errors.New("key CmsMergeKey5 does not exist"),
+		},
+		{
+			name:          "5. Return error when the number of weights does not match",
+			command:       []string{"CMS.MERGE", "CmsMergeKey3", "2", "CmsMergeKey1", "CmsMergeKey2", "WEIGHTS", "1"},
+			expectedError: errors.New("WEIGHTS must be followed by one weight per source key"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := handleCMSMerge(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res, err := handleCMSQuery(ctx, test.query, mockServer, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := readIntegers(t, res); !reflect.DeepEqual(got, test.expectedResponse) {
+				t.Errorf("expected response %v, got %v", test.expectedResponse, got)
+			}
+		})
+	}
+}
+
+func Test_CountMinSketchInfoAndEncoding(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := handleCMSInitByProb(ctx, []string{"CMS.INITBYPROB", "CmsInfoKey1", "0.01", "0.01"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := handleCMSIncrBy(ctx, []string{"CMS.INCRBY", "CmsInfoKey1", "apple", "7", "banana", "3"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := handleCMSInfo(ctx, []string{"CMS.INFO", "CmsInfoKey1"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rv, _, _ := resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+	info := rv.Array()
+	expected := map[string]int{"width": 200, "depth": 7, "count": 10}
+	for i := 0; i < len(info); i += 2 {
+		if want, ok := expected[info[i].String()]; ok && info[i+1].Integer() != want {
+			t.Errorf("expected %s to be %d, got %d", info[i].String(), want, info[i+1].Integer())
+		}
+	}
+
+	if _, err = mockServer.KeyRLock(ctx, "CmsInfoKey1"); err != nil {
+		t.Error(err)
+		return
+	}
+	sketch := mockServer.GetValue(ctx, "CmsInfoKey1").(*internal_cms.CountMinSketch)
+	mockServer.KeyRUnlock(ctx, "CmsInfoKey1")
+
+	// The sketch must survive the JSON encoding used by snapshots and the AOF preamble.
+	b, err := json.Marshal(internal.KeyData{Value: sketch})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var data internal.KeyData
+	if err = json.Unmarshal(b, &data); err != nil {
+		t.Error(err)
+		return
+	}
+	restored, ok := data.Value.(*internal_cms.CountMinSketch)
+	if !ok {
+		t.Errorf("expected restored value to be a count-min sketch, got %T", data.Value)
+		return
+	}
+	if restored.Query("apple") != 7 || restored.Query("banana") != 3 || restored.Count() != 10 {
+		t.Errorf("expected restored counts 7, 3 and 10, got %d, %d and %d",
+			restored.Query("apple"), restored.Query("banana"), restored.Count())
+	}
+}
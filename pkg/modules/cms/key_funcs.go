@@ -0,0 +1,92 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cms
+
+import (
+	"errors"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"strconv"
+)
+
+func cmsInitByDimKeyFunc(cmd []string) (types.AccessKeys, error) {
+	if len(cmd) != 4 {
+		return types.AccessKeys{}, errors.New(constants.WrongArgsResponse)
+	}
+	return types.AccessKeys{
+		Channels:  make([]string, 0),
+		ReadKeys:  make([]string, 0),
+		WriteKeys: cmd[1:2],
+	}, nil
+}
+
+func cmsInitByProbKeyFunc(cmd []string) (types.AccessKeys, error) {
+	if len(cmd) != 4 {
+		return types.AccessKeys{}, errors.New(constants.WrongArgsResponse)
+	}
+	return types.AccessKeys{
+		Channels:  make([]string, 0),
+		ReadKeys:  make([]string, 0),
+		WriteKeys: cmd[1:2],
+	}, nil
+}
+
+func cmsIncrByKeyFunc(cmd []string) (types.AccessKeys, error) {
+	if len(cmd) < 4 || len(cmd)%2 != 0 {
+		return types.AccessKeys{}, errors.New(constants.WrongArgsResponse)
+	}
+	return types.AccessKeys{
+		Channels:  make([]string, 0),
+		ReadKeys:  make([]string, 0),
+		WriteKeys: cmd[1:2],
+	}, nil
+}
+
+func cmsQueryKeyFunc(cmd []string) (types.AccessKeys, error) {
+	if len(cmd) < 3 {
+		return types.AccessKeys{}, errors.New(constants.WrongArgsResponse)
+	}
+	return types.AccessKeys{
+		Channels:  make([]string, 0),
+		ReadKeys:  cmd[1:2],
+		WriteKeys: make([]string, 0),
+	}, nil
+}
+
+func cmsMergeKeyFunc(cmd []string) (types.AccessKeys, error) {
+	if len(cmd) < 4 {
+		return types.AccessKeys{}, errors.New(constants.WrongArgsResponse)
+	}
+	numKeys, err := strconv.Atoi(cmd[2])
+	if err != nil || numKeys < 1 || 3+numKeys > len(cmd) {
+		return types.AccessKeys{}, errors.New(constants.WrongArgsResponse)
+	}
+	return types.AccessKeys{
+		Channels:  make([]string, 0),
+		ReadKeys:  cmd[3 : 3+numKeys],
+		WriteKeys: cmd[1:2],
+	}, nil
+}
+
+func cmsInfoKeyFunc(cmd []string) (types.AccessKeys, error) {
+	if len(cmd) != 2 {
+		return types.AccessKeys{}, errors.New(constants.WrongArgsResponse)
+	}
+	return types.AccessKeys{
+		Channels:  make([]string, 0),
+		ReadKeys:  cmd[1:2],
+		WriteKeys: make([]string, 0),
+	}, nil
+}
@@ -0,0 +1,381 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cuckoo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	internal_cuckoo "github.com/echovault/echovault/internal/cuckoo"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// lockFilter write-locks the key and returns the cuckoo filter stored at it.
+// If the key does not exist and create is true, a filter with the default parameters is created.
+// It returns nil without locking the key if the key does not exist and create is false.
+func lockFilter(ctx context.Context, server types.EchoVault, key string, create bool) (*internal_cuckoo.CuckooFilter, error) {
+	if !create && !server.KeyExists(ctx, key) {
+		return nil, nil
+	}
+	if _, err := server.CreateKeyAndLock(ctx, key); err != nil {
+		return nil, err
+	}
+	value := server.GetValue(ctx, key)
+	if value == nil {
+		filter := internal_cuckoo.NewCuckooFilter(
+			internal_cuckoo.DefaultCapacity,
+			internal_cuckoo.DefaultBucketSize,
+			internal_cuckoo.DefaultMaxIterations,
+			internal_cuckoo.DefaultExpansion,
+		)
+		if err := server.SetValue(ctx, key, filter); err != nil {
+			server.KeyUnlock(ctx, key)
+			return nil, err
+		}
+		return filter, nil
+	}
+	filter, ok := value.(*internal_cuckoo.CuckooFilter)
+	if !ok {
+		server.KeyUnlock(ctx, key)
+		return nil, fmt.Errorf("value at key %s is not a cuckoo filter", key)
+	}
+	return filter, nil
+}
+
+// rLockFilter read-locks the key and returns the cuckoo filter stored at it.
+// It returns nil without locking the key if the key does not exist.
+func rLockFilter(ctx context.Context, server types.EchoVault, key string) (*internal_cuckoo.CuckooFilter, error) {
+	if !server.KeyExists(ctx, key) {
+		return nil, nil
+	}
+	if _, err := server.KeyRLock(ctx, key); err != nil {
+		return nil, err
+	}
+	filter, ok := server.GetValue(ctx, key).(*internal_cuckoo.CuckooFilter)
+	if !ok {
+		server.KeyRUnlock(ctx, key)
+		return nil, fmt.Errorf("value at key %s is not a cuckoo filter", key)
+	}
+	return filter, nil
+}
+
+func handleCFReserve(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cfReserveKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	capacity, err := strconv.ParseUint(cmd[2], 10, 64)
+	if err != nil || capacity == 0 {
+		return nil, errors.New("capacity must be a positive integer")
+	}
+	if capacity > internal_cuckoo.MaxCapacity {
+		return nil, fmt.Errorf("capacity must be at most %d", internal_cuckoo.MaxCapacity)
+	}
+	options := map[string]uint64{
+		"bucketsize":    internal_cuckoo.DefaultBucketSize,
+		"maxiterations": internal_cuckoo.DefaultMaxIterations,
+		"expansion":     internal_cuckoo.DefaultExpansion,
+	}
+	for i := 3; i < len(cmd); i += 2 {
+		option := strings.ToLower(cmd[i])
+		if _, ok := options[option]; !ok {
+			return nil, fmt.Errorf("unknown option %s", strings.ToUpper(cmd[i]))
+		}
+		if i+1 >= len(cmd) {
+			return nil, fmt.Errorf("value required after %s", strings.ToUpper(cmd[i]))
+		}
+		value, err := strconv.ParseUint(cmd[i+1], 10, 16)
+		if err != nil || (value == 0 && option != "expansion") || (option == "bucketsize" && value > 255) {
+			return nil, fmt.Errorf("invalid value for %s", strings.ToUpper(cmd[i]))
+		}
+		options[option] = value
+	}
+
+	if server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s already exists", key)
+	}
+	if _, err = server.CreateKeyAndLock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+	filter := internal_cuckoo.NewCuckooFilter(
+		capacity,
+		uint16(options["bucketsize"]),
+		uint16(options["maxiterations"]),
+		uint16(options["expansion"]),
+	)
+	if err = server.SetValue(ctx, key, filter); err != nil {
+		return nil, err
+	}
+
+	return []byte(constants.OkResponse), nil
+}
+
+func handleCFAdd(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cfAddKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	filter, err := lockFilter(ctx, server, key, true)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+
+	if err = filter.Add(cmd[2]); err != nil {
+		return nil, err
+	}
+	return []byte(":1\r\n"), nil
+}
+
+func handleCFAddNX(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cfAddNXKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	filter, err := lockFilter(ctx, server, key, true)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+
+	added, err := filter.AddNX(cmd[2])
+	if err != nil {
+		return nil, err
+	}
+	if added {
+		return []byte(":1\r\n"), nil
+	}
+	return []byte(":0\r\n"), nil
+}
+
+func handleCFExists(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cfExistsKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	filter, err := rLockFilter(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return []byte(":0\r\n"), nil
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	if filter.Exists(cmd[2]) {
+		return []byte(":1\r\n"), nil
+	}
+	return []byte(":0\r\n"), nil
+}
+
+func handleCFMExists(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cfMExistsKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	filter, err := rLockFilter(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		defer server.KeyRUnlock(ctx, key)
+	}
+
+	res := fmt.Sprintf("*%d\r\n", len(cmd[2:]))
+	for _, item := range cmd[2:] {
+		if filter != nil && filter.Exists(item) {
+			res += ":1\r\n"
+		} else {
+			res += ":0\r\n"
+		}
+	}
+	return []byte(res), nil
+}
+
+func handleCFDel(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cfDelKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	filter, err := lockFilter(ctx, server, key, false)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	defer server.KeyUnlock(ctx, key)
+
+	if filter.Delete(cmd[2]) {
+		return []byte(":1\r\n"), nil
+	}
+	return []byte(":0\r\n"), nil
+}
+
+func handleCFCount(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cfCountKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	filter, err := rLockFilter(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return []byte(":0\r\n"), nil
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	return []byte(fmt.Sprintf(":%d\r\n", filter.Count(cmd[2]))), nil
+}
+
+func handleCFInfo(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := cfInfoKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	filter, err := rLockFilter(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	info := []struct {
+		name  string
+		value uint64
+	}{
+		{name: "Size", value: uint64(filter.Size())},
+		{name: "Number of buckets", value: filter.Buckets()},
+		{name: "Number of filters", value: uint64(filter.Filters())},
+		{name: "Number of items inserted", value: filter.Inserted()},
+		{name: "Number of items deleted", value: filter.Deleted()},
+		{name: "Bucket size", value: uint64(filter.BucketSize())},
+		{name: "Expansion rate", value: uint64(filter.Expansion())},
+		{name: "Max iterations", value: uint64(filter.MaxIterations())},
+	}
+	res := fmt.Sprintf("*%d\r\n", len(info)*2)
+	for _, entry := range info {
+		res += fmt.Sprintf("$%d\r\n%s\r\n:%d\r\n", len(entry.name), entry.name, entry.value)
+	}
+	return []byte(res), nil
+}
+
+func Commands() []types.Command {
+	return []types.Command{
+		{
+			Command:    "cf.reserve",
+			Module:     constants.CuckooModule,
+			Categories: []string{constants.CuckooCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(CF.RESERVE key capacity [BUCKETSIZE bucketsize] [MAXITERATIONS maxiterations] [EXPANSION expansion])
+Creates an empty cuckoo filter. When an item cannot be placed in the filter, a new sub-filter with expansion times
+the size of the last one is added. An expansion of 0 disables scaling. The capacity is at most 1073741824.`,
+			Sync:              true,
+			KeyExtractionFunc: cfReserveKeyFunc,
+			HandlerFunc:       handleCFReserve,
+		},
+		{
+			Command:    "cf.add",
+			Module:     constants.CuckooModule,
+			Categories: []string{constants.CuckooCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(CF.ADD key item) Adds the item to the cuckoo filter, creating the filter if it does not exist.
+An item can be added more than once.`,
+			Sync:              true,
+			KeyExtractionFunc: cfAddKeyFunc,
+			HandlerFunc:       handleCFAdd,
+		},
+		{
+			Command:    "cf.addnx",
+			Module:     constants.CuckooModule,
+			Categories: []string{constants.CuckooCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(CF.ADDNX key item) Adds the item to the cuckoo filter only if it does not exist in the filter.
+Returns 1 if the item was added and 0 if it may already exist.`,
+			Sync:              true,
+			KeyExtractionFunc: cfAddNXKeyFunc,
+			HandlerFunc:       handleCFAddNX,
+		},
+		{
+			Command:    "cf.exists",
+			Module:     constants.CuckooModule,
+			Categories: []string{constants.CuckooCategory, constants.ReadCategory, constants.FastCategory},
+			Description: `(CF.EXISTS key item) Returns 1 if the item may exist in the cuckoo filter,
+and 0 if it definitely does not.`,
+			Sync:              false,
+			KeyExtractionFunc: cfExistsKeyFunc,
+			HandlerFunc:       handleCFExists,
+		},
+		{
+			Command:           "cf.mexists",
+			Module:            constants.CuckooModule,
+			Categories:        []string{constants.CuckooCategory, constants.ReadCategory, constants.FastCategory},
+			Description:       `(CF.MEXISTS key item [item ...]) Checks whether each of the items may exist in the cuckoo filter.`,
+			Sync:              false,
+			KeyExtractionFunc: cfMExistsKeyFunc,
+			HandlerFunc:       handleCFMExists,
+		},
+		{
+			Command:    "cf.del",
+			Module:     constants.CuckooModule,
+			Categories: []string{constants.CuckooCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(CF.DEL key item) Deletes one occurrence of the item from the cuckoo filter.
+Returns 1 if the item was deleted and 0 if it was not found.`,
+			Sync:              true,
+			KeyExtractionFunc: cfDelKeyFunc,
+			HandlerFunc:       handleCFDel,
+		},
+		{
+			Command:           "cf.count",
+			Module:            constants.CuckooModule,
+			Categories:        []string{constants.CuckooCategory, constants.ReadCategory, constants.FastCategory},
+			Description:       `(CF.COUNT key item) Returns an estimate of the number of times the item was added to the cuckoo filter.`,
+			Sync:              false,
+			KeyExtractionFunc: cfCountKeyFunc,
+			HandlerFunc:       handleCFCount,
+		},
+		{
+			Command:    "cf.info",
+			Module:     constants.CuckooModule,
+			Categories: []string{constants.CuckooCategory, constants.ReadCategory, constants.FastCategory},
+			Description: `(CF.INFO key) Returns the memory size in bytes, number of buckets, number of sub-filters,
+number of items inserted and deleted, bucket size, expansion rate and max iterations of the cuckoo filter.`,
+			Sync:              false,
+			KeyExtractionFunc: cfInfoKeyFunc,
+			HandlerFunc:       handleCFInfo,
+		},
+	}
+}
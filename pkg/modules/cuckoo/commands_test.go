@@ -0,0 +1,365 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cuckoo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/config"
+	internal_cuckoo "github.com/echovault/echovault/internal/cuckoo"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/echovault"
+	"github.com/tidwall/resp"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+var mockServer *echovault.EchoVault
+
+func init() {
+	mockServer, _ = echovault.NewEchoVault(
+		echovault.WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+}
+
+func readIntegers(t *testing.T, res []byte) []int {
+	rd := resp.NewReader(bytes.NewBuffer(res))
+	rv, _, err := rd.ReadValue()
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	if rv.Type() != resp.Array {
+		return []int{rv.Integer()}
+	}
+	var integers []int
+	for _, v := range rv.Array() {
+		integers = append(integers, v.Integer())
+	}
+	return integers
+}
+
+func Test_HandleCFReserve(t *testing.T) {
+	tests := []struct {
+		name          string
+		preset        bool
+		key           string
+		command       []string
+		expectedError error
+	}{
+		{
+			name:    "1. Reserve a filter with the default options",
+			key:     "CfReserveKey1",
+			command: []string{"CF.RESERVE", "CfReserveKey1", "1000"},
+		},
+		{
+			name: "2. Reserve a filter with all the options",
+			key:  "CfReserveKey2",
+			command: []string{
+				"CF.RESERVE", "CfReserveKey2", "1000", "BUCKETSIZE", "4", "MAXITERATIONS", "50", "EXPANSION", "2",
+			},
+		},
+		{
+			name:          "3. Return error when the key already exists",
+			preset:        true,
+			key:           "CfReserveKey3",
+			command:       []string{"CF.RESERVE", "CfReserveKey3", "1000"},
+			expectedError: errors.New("key CfReserveKey3 already exists"),
+		},
+		{
+			name:          "4. Return error on unknown option",
+			key:           "CfReserveKey4",
+			command:       []string{"CF.RESERVE", "CfReserveKey4", "1000", "FOO", "1"},
+			expectedError: errors.New("unknown option FOO"),
+		},
+		{
+			name:          "5. Return error on invalid capacity",
+			key:           "CfReserveKey5",
+			command:       []string{"CF.RESERVE", "CfReserveKey5", "0"},
+			expectedError: errors.New("capacity must be a positive integer"),
+		},
+		{
+			name:          "6. Command too short",
+			key:           "CfReserveKey6",
+			command:       []string{"CF.RESERVE", "CfReserveKey6"},
+			expectedError: errors.New(constants.WrongArgsResponse),
+		},
+		{
+			name:          "7. Return error when the capacity is above the limit",
+			key:           "CfReserveKey7",
+			command:       []string{"CF.RESERVE", "CfReserveKey7", "2000000000"},
+			expectedError: errors.New("capacity must be at most 1073741824"),
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), "test_name", fmt.Sprintf("CF.RESERVE, %d", i))
+			if test.preset {
+				if _, err := mockServer.CreateKeyAndLock(ctx, test.key); err != nil {
+					t.Error(err)
+				}
+				if err := mockServer.SetValue(ctx, test.key, "value"); err != nil {
+					t.Error(err)
+				}
+				mockServer.KeyUnlock(ctx, test.key)
+			}
+			_, err := handleCFReserve(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err = mockServer.KeyRLock(ctx, test.key); err != nil {
+				t.Error(err)
+			}
+			defer mockServer.KeyRUnlock(ctx, test.key)
+			if _, ok := mockServer.GetValue(ctx, test.key).(*internal_cuckoo.CuckooFilter); !ok {
+				t.Errorf("expected cuckoo filter at key %s", test.key)
+			}
+		})
+	}
+}
+
+func Test_HandleCFCommands(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name             string
+		command          []string
+		expectedResponse []int
+		expectedError    error
+	}{
+		{
+			name:             "1. CF.ADD creates the filter on a non-existent key",
+			command:          []string{"CF.ADD", "CfKey1", "apple"},
+			expectedResponse: []int{1},
+		},
+		{
+			name:             "2. CF.ADD allows adding the same item more than once",
+			command:          []string{"CF.ADD", "CfKey1", "apple"},
+			expectedResponse: []int{1},
+		},
+		{
+			name:             "3. CF.COUNT returns the number of times the item was added",
+			command:          []string{"CF.COUNT", "CfKey1", "apple"},
+			expectedResponse: []int{2},
+		},
+		{
+			name:             "4. CF.ADDNX does not add an item that exists",
+			command:          []string{"CF.ADDNX", "CfKey1", "apple"},
+			expectedResponse: []int{0},
+		},
+		{
+			name:             "5. CF.ADDNX adds an item that does not exist",
+			command:          []string{"CF.ADDNX", "CfKey1", "banana"},
+			expectedResponse: []int{1},
+		},
+		{
+			name:             "6. CF.MEXISTS checks multiple items",
+			command:          []string{"CF.MEXISTS", "CfKey1", "apple", "cherry", "banana"},
+			expectedResponse: []int{1, 0, 1},
+		},
+		{
+			name:             "7. CF.DEL removes one occurrence of the item",
+			command:          []string{"CF.DEL", "CfKey1", "apple"},
+			expectedResponse: []int{1},
+		},
+		{
+			name:             "8. CF.EXISTS returns 1 for the remaining occurrence",
+			command:          []string{"CF.EXISTS", "CfKey1", "apple"},
+			expectedResponse: []int{1},
+		},
+		{
+			name:             "9. CF.DEL removes the last occurrence of the item",
+			command:          []string{"CF.DEL", "CfKey1", "apple"},
+			expectedResponse: []int{1},
+		},
+		{
+			name:             "10. CF.EXISTS returns 0 after the item is deleted",
+			command:          []string{"CF.EXISTS", "CfKey1", "apple"},
+			expectedResponse: []int{0},
+		},
+		{
+			name:             "11. CF.DEL returns 0 when the item does not exist",
+			command:          []string{"CF.DEL", "CfKey1", "apple"},
+			expectedResponse: []int{0},
+		},
+		{
+			name:             "12. CF.EXISTS returns 0 on a non-existent key",
+			command:          []string{"CF.EXISTS", "CfKey2", "apple"},
+			expectedResponse: []int{0},
+		},
+		{
+			name:          "13. CF.DEL returns error on a non-existent key",
+			command:       []string{"CF.DEL", "CfKey2", "apple"},
+			expectedError: errors.New("key CfKey2 does not exist"),
+		},
+		{
+			name:          "14. Return error when the key does not hold a cuckoo filter",
+			command:       []string{"CF.ADD", "CfKey3", "apple"},
+			expectedError: errors.New("value at key CfKey3 is not a cuckoo filter"),
+		},
+	}
+
+	if _, err := mockServer.CreateKeyAndLock(ctx, "CfKey3"); err != nil {
+		t.Error(err)
+	}
+	if err := mockServer.SetValue(ctx, "CfKey3", "value"); err != nil {
+		t.Error(err)
+	}
+	mockServer.KeyUnlock(ctx, "CfKey3")
+
+	handlers := map[string]func(context.Context, []string, *echovault.EchoVault) ([]byte, error){
+		"CF.ADD": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCFAdd(ctx, cmd, server, nil)
+		},
+		"CF.ADDNX": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCFAddNX(ctx, cmd, server, nil)
+		},
+		"CF.EXISTS": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCFExists(ctx, cmd, server, nil)
+		},
+		"CF.MEXISTS": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCFMExists(ctx, cmd, server, nil)
+		},
+		"CF.DEL": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCFDel(ctx, cmd, server, nil)
+		},
+		"CF.COUNT": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleCFCount(ctx, cmd, server, nil)
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := handlers[test.command[0]](ctx, test.command, mockServer)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := readIntegers(t, res); !reflect.DeepEqual(got, test.expectedResponse) {
+				t.Errorf("expected response %v, got %v", test.expectedResponse, got)
+			}
+		})
+	}
+}
+
+func Test_CuckooFilterScalingAndEncoding(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := handleCFReserve(ctx, []string{"CF.RESERVE", "CfScaleKey1", "64", "EXPANSION", "2"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 500; i++ {
+		if _, err := handleCFAdd(ctx, []string{"CF.ADD", "CfScaleKey1", fmt.Sprintf("item-%d", i)}, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if _, err := handleCFDel(ctx, []string{"CF.DEL", "CfScaleKey1", "item-0"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := mockServer.KeyRLock(ctx, "CfScaleKey1"); err != nil {
+		t.Error(err)
+		return
+	}
+	filter := mockServer.GetValue(ctx, "CfScaleKey1").(*internal_cuckoo.CuckooFilter)
+	mockServer.KeyRUnlock(ctx, "CfScaleKey1")
+	if filter.Filters() < 2 {
+		t.Errorf("expected the filter to scale, got %d sub-filters", filter.Filters())
+	}
+
+	// The filter must survive the JSON encoding used by snapshots and the AOF preamble.
+	b, err := json.Marshal(internal.KeyData{Value: filter})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var data internal.KeyData
+	if err = json.Unmarshal(b, &data); err != nil {
+		t.Error(err)
+		return
+	}
+	restored, ok := data.Value.(*internal_cuckoo.CuckooFilter)
+	if !ok {
+		t.Errorf("expected restored value to be a cuckoo filter, got %T", data.Value)
+		return
+	}
+	// An encoding that is truncated, or holds a number of buckets that is not a power of two, is rejected.
+	encoded, err := filter.MarshalBinary()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = (&internal_cuckoo.CuckooFilter{}).UnmarshalBinary(encoded[:len(encoded)-1]); err == nil {
+		t.Error("expected a truncated encoding to be rejected")
+	}
+	badBuckets := slices.Clone(encoded)
+	binary.LittleEndian.PutUint64(badBuckets[42:], 3)
+	if err = (&internal_cuckoo.CuckooFilter{}).UnmarshalBinary(badBuckets); err == nil {
+		t.Error("expected a number of buckets that is not a power of two to be rejected")
+	}
+
+	for i := 1; i < 500; i++ {
+		if !restored.Exists(fmt.Sprintf("item-%d", i)) {
+			t.Errorf("expected restored filter to contain item-%d", i)
+		}
+	}
+	if restored.Inserted() != 499 || restored.Deleted() != 1 {
+		t.Errorf("expected 499 inserted and 1 deleted, got %d and %d", restored.Inserted(), restored.Deleted())
+	}
+
+	res, err := handleCFInfo(ctx, []string{"CF.INFO", "CfScaleKey1"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rv, _, _ := resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+	info := rv.Array()
+	if len(info) != 16 {
+		t.Errorf("expected 16 elements in CF.INFO response, got %d", len(info))
+		return
+	}
+	if info[6].String() != "Number of items inserted" || info[7].Integer() != 499 {
+		t.Errorf("expected 499 items inserted, got %s %d", info[6].String(), info[7].Integer())
+	}
+	if info[0].String() != "Size" || info[1].Integer() <= 0 {
+		t.Errorf("expected positive size, got %d", info[1].Integer())
+	}
+}
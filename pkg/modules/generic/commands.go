@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/snapshot"
 	"github.com/echovault/echovault/pkg/constants"
 	"github.com/echovault/echovault/pkg/types"
 	"log"
@@ -475,6 +476,110 @@ func handleExpireAt(ctx context.Context, cmd []string, server types.EchoVault, _
 	return []byte(":1\r\n"), nil
 }
 
+func handleDump(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := dumpKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	if !server.KeyExists(ctx, key) {
+		return []byte("$-1\r\n"), nil
+	}
+
+	if _, err = server.KeyRLock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	payload, err := dumpKey(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(payload), payload)), nil
+}
+
+func handleRestore(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := restoreKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	params, err := getRestoreCommandParams(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	value, payloadTTL, err := snapshot.DecodeDump([]byte(cmd[3]))
+	if err != nil {
+		return nil, err
+	}
+	expireAt := restoreExpireAt(server.GetClock(), params, payloadTTL)
+
+	exists := server.KeyExists(ctx, key)
+	if exists && !params.replace {
+		return nil, errors.New("BUSYKEY target key name already exists")
+	}
+
+	// A key restored with an expiry time in the past is not created.
+	if expireAt != (time.Time{}) && !expireAt.After(server.GetClock().Now()) {
+		if exists {
+			if err = server.DeleteKey(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+		return []byte(constants.OkResponse), nil
+	}
+
+	if exists {
+		_, err = server.KeyLock(ctx, key)
+	} else {
+		_, err = server.CreateKeyAndLock(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+
+	if err = server.SetValue(ctx, key, value); err != nil {
+		return nil, err
+	}
+
+	if expireAt == (time.Time{}) {
+		server.RemoveExpiry(key)
+	} else {
+		server.SetExpiry(ctx, key, expireAt, false)
+	}
+
+	if params.idleTime != -1 {
+		server.SetKeyIdleTime(key, time.Duration(params.idleTime)*time.Second)
+	}
+	if params.freq != -1 {
+		server.SetKeyFrequency(key, int(params.freq))
+	}
+
+	return []byte(constants.OkResponse), nil
+}
+
+func handleMigrate(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	params, err := getMigrateCommandParams(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated, err := migrateKeys(ctx, server, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(migrated) == 0 {
+		return []byte("+NOKEY\r\n"), nil
+	}
+	return []byte(constants.OkResponse), nil
+}
+
 func Commands() []types.Command {
 	return []types.Command{
 		{
@@ -494,6 +599,7 @@ PXAT - Expire at the exat time in unix milliseconds (positive integer).`,
 			Sync:              true,
 			KeyExtractionFunc: setKeyFunc,
 			HandlerFunc:       handleSet,
+			RewriteFunc:       rewriteSet,
 		},
 		{
 			Command:           "mset",
@@ -598,6 +704,7 @@ LT - Only set the expiry time if the new expiry time is less than the current on
 			Sync:              true,
 			KeyExtractionFunc: expireKeyFunc,
 			HandlerFunc:       handleExpire,
+			RewriteFunc:       rewriteExpire,
 		},
 		{
 			Command:    "pexpire",
@@ -612,6 +719,7 @@ LT - Only set the expiry time if the new expiry time is less than the current on
 			Sync:              true,
 			KeyExtractionFunc: expireKeyFunc,
 			HandlerFunc:       handleExpire,
+			RewriteFunc:       rewriteExpire,
 		},
 		{
 			Command:    "expireat",
@@ -643,5 +751,59 @@ LT - Only set the expiry time if the new expiry time is less than the current on
 			KeyExtractionFunc: expireAtKeyFunc,
 			HandlerFunc:       handleExpireAt,
 		},
+		{
+			Command:    "dump",
+			Module:     constants.GenericModule,
+			Categories: []string{constants.KeyspaceCategory, constants.ReadCategory, constants.SlowCategory},
+			Description: `(DUMP key)
+Serialize the value stored at key, including its remaining time to live, in a versioned and checksummed format.
+The serialized value can be passed to RESTORE on this node or any other EchoVault node.
+Returns nil if the key does not exist.`,
+			Sync:              false,
+			KeyExtractionFunc: dumpKeyFunc,
+			HandlerFunc:       handleDump,
+		},
+		{
+			Command: "restore",
+			Module:  constants.GenericModule,
+			Categories: []string{
+				constants.KeyspaceCategory,
+				constants.WriteCategory,
+				constants.SlowCategory,
+				constants.DangerousCategory,
+			},
+			Description: `(RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds | FREQ frequency])
+Create a key from a value serialized with DUMP.
+ttl is the time to live of the key in milliseconds. When ttl is 0, the time to live stored in the serialized value is used.
+REPLACE - Replace the key if it already exists. Without it, restoring an existing key returns an error.
+ABSTTL - ttl is an absolute unix time in milliseconds. A ttl of 0 creates a key that does not expire.
+IDLETIME - The number of seconds since the key was last accessed, used by the LRU eviction policies.
+FREQ - The access count of the key, from 0 to 255, used by the LFU eviction policies.`,
+			Sync:              true,
+			KeyExtractionFunc: restoreKeyFunc,
+			HandlerFunc:       handleRestore,
+			RewriteFunc:       rewriteRestore,
+		},
+		{
+			Command: "migrate",
+			Module:  constants.GenericModule,
+			// MIGRATE is not a write command. The transfer runs once on the node that receives it,
+			// and the deletion of the migrated keys is written to the AOF and replicated as a DEL command.
+			Categories: []string{constants.KeyspaceCategory, constants.SlowCategory, constants.DangerousCategory},
+			Description: `(MIGRATE host port key | "" destination-db timeout [COPY] [REPLACE] [AUTH password | AUTH2 username password] [KEYS key [key ...]])
+Move keys to another EchoVault node. The keys are restored on the target with RESTORE and are deleted from this node
+once the target has acknowledged all of them. If the target rejects a key, the transfer stops, no key is deleted from
+this node, and the error names the keys that the target restored before. destination-db must be 0.
+timeout is in milliseconds.
+COPY - Do not delete the keys from this node.
+REPLACE - Replace existing keys on the target.
+AUTH - Authenticate with the target using the password.
+AUTH2 - Authenticate with the target using the username and password.
+KEYS - Move several keys. The key argument must be an empty string.
+Returns NOKEY if none of the keys exist.`,
+			Sync:              false,
+			KeyExtractionFunc: migrateKeyFunc,
+			HandlerFunc:       handleMigrate,
+		},
 	}
 }
@@ -21,9 +21,13 @@ import (
 	"fmt"
 	"github.com/echovault/echovault/internal/clock"
 	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/internal/snapshot"
 	"github.com/echovault/echovault/pkg/constants"
 	"github.com/echovault/echovault/pkg/echovault"
 	"github.com/tidwall/resp"
+	"net"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -45,6 +49,7 @@ func init() {
 			DataDir:        "",
 			EvictionPolicy: constants.NoEviction,
 		}),
+		echovault.WithCommands(Commands()),
 	)
 }
 
@@ -1621,3 +1626,543 @@ func Test_HandleEXPIREAT(t *testing.T) {
 		})
 	}
 }
+
+func Test_RewriteSET(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       []string
+		expected      []string
+		expectedError error
+	}{
+		{
+			name:     "1. Rewrite EX into PXAT",
+			command:  []string{"SET", "key", "value", "EX", "100"},
+			expected: []string{"SET", "key", "value", "PXAT", fmt.Sprintf("%d", mockClock.Now().Add(100*time.Second).UnixMilli())},
+		},
+		{
+			name:    "2. Rewrite PX into PXAT and keep the other options",
+			command: []string{"SET", "key", "value", "NX", "PX", "4096", "GET"},
+			expected: []string{"SET", "key", "value", "NX",
+				"PXAT", fmt.Sprintf("%d", mockClock.Now().Add(4096*time.Millisecond).UnixMilli()), "GET"},
+		},
+		{
+			name:     "3. Leave absolute expiry times unchanged",
+			command:  []string{"SET", "key", "value", "EXAT", "1000"},
+			expected: []string{"SET", "key", "value", "EXAT", "1000"},
+		},
+		{
+			name:     "4. Leave SET without expiry unchanged",
+			command:  []string{"SET", "key", "value"},
+			expected: []string{"SET", "key", "value"},
+		},
+		{
+			name:          "5. Return error on invalid expiry",
+			command:       []string{"SET", "key", "value", "EX", "seconds"},
+			expectedError: errors.New("seconds value should be an integer"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := rewriteSet(test.command, mockServer)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected command %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func Test_RewriteEXPIRE(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       []string
+		expected      []string
+		expectedError error
+	}{
+		{
+			name:     "1. Rewrite EXPIRE into PEXPIREAT",
+			command:  []string{"EXPIRE", "key", "100"},
+			expected: []string{"PEXPIREAT", "key", fmt.Sprintf("%d", mockClock.Now().Add(100*time.Second).UnixMilli())},
+		},
+		{
+			name:     "2. Rewrite PEXPIRE into PEXPIREAT and keep the option",
+			command:  []string{"PEXPIRE", "key", "4096", "GT"},
+			expected: []string{"PEXPIREAT", "key", fmt.Sprintf("%d", mockClock.Now().Add(4096*time.Millisecond).UnixMilli()), "GT"},
+		},
+		{
+			name:          "3. Return error when expire time is not an integer",
+			command:       []string{"EXPIRE", "key", "seconds"},
+			expectedError: errors.New("expire time must be integer"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := rewriteExpire(test.command, mockServer)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected command %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func Test_HandleDUMPRESTORE(t *testing.T) {
+	tests := []struct {
+		name           string
+		presetValue    KeyData
+		restoreCommand func(payload string) []string
+		expectedValue  interface{}
+		expectedExpiry time.Time
+	}{
+		{
+			name:        "1. Dump and restore string value with the TTL stored in the payload",
+			presetValue: KeyData{Value: "value1", ExpireAt: mockClock.Now().Add(10 * time.Second)},
+			restoreCommand: func(payload string) []string {
+				return []string{"RESTORE", "RestoreKey1", "0", payload}
+			},
+			expectedValue:  "value1",
+			expectedExpiry: mockClock.Now().Add(10 * time.Second),
+		},
+		{
+			name:        "2. Dump and restore list value with a TTL in milliseconds",
+			presetValue: KeyData{Value: []interface{}{"value1", 2, 3.5}},
+			restoreCommand: func(payload string) []string {
+				return []string{"RESTORE", "RestoreKey2", "5000", payload}
+			},
+			expectedValue:  []interface{}{"value1", 2, 3.5},
+			expectedExpiry: mockClock.Now().Add(5 * time.Second),
+		},
+		{
+			name:        "3. Dump and restore hash value with an absolute TTL",
+			presetValue: KeyData{Value: map[string]interface{}{"field1": "value1", "field2": 2}},
+			restoreCommand: func(payload string) []string {
+				return []string{
+					"RESTORE", "RestoreKey3", fmt.Sprintf("%d", mockClock.Now().Add(time.Hour).UnixMilli()), payload, "ABSTTL",
+				}
+			},
+			expectedValue:  map[string]interface{}{"field1": "value1", "field2": 2},
+			expectedExpiry: mockClock.Now().Add(time.Hour),
+		},
+		{
+			name:        "4. ABSTTL with a TTL of 0 ignores the TTL stored in the payload",
+			presetValue: KeyData{Value: "value4", ExpireAt: mockClock.Now().Add(10 * time.Second)},
+			restoreCommand: func(payload string) []string {
+				return []string{"RESTORE", "RestoreKey4", "0", payload, "ABSTTL", "IDLETIME", "100"}
+			},
+			expectedValue:  "value4",
+			expectedExpiry: time.Time{},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), "test_name", fmt.Sprintf("DUMPRESTORE, %d", i))
+
+			source := fmt.Sprintf("DumpKey%d", i+1)
+			if _, err := mockServer.CreateKeyAndLock(ctx, source); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := mockServer.SetValue(ctx, source, test.presetValue.Value); err != nil {
+				t.Error(err)
+			}
+			mockServer.SetExpiry(ctx, source, test.presetValue.ExpireAt, false)
+			mockServer.KeyUnlock(ctx, source)
+
+			res, err := handleDump(ctx, []string{"DUMP", source}, mockServer, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rv, _, err := resp.NewReader(bytes.NewReader(res)).ReadValue()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			command := test.restoreCommand(rv.String())
+			res, err = handleRestore(ctx, command, mockServer, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(res, []byte(constants.OkResponse)) {
+				t.Errorf("expected response OK, got %s", res)
+			}
+
+			value := mockServer.GetValue(ctx, command[1])
+			if !reflect.DeepEqual(value, test.expectedValue) {
+				t.Errorf("expected value %+v, got %+v", test.expectedValue, value)
+			}
+			if expireAt := mockServer.GetExpiry(ctx, command[1]); !expireAt.Equal(test.expectedExpiry) {
+				t.Errorf("expected expiry %v, got %v", test.expectedExpiry, expireAt)
+			}
+		})
+	}
+
+	t.Run("5. Dump returns nil when the key does not exist", func(t *testing.T) {
+		res, err := handleDump(context.Background(), []string{"DUMP", "DumpKey5"}, mockServer, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !bytes.Equal(res, []byte("$-1\r\n")) {
+			t.Errorf("expected nil response, got %s", res)
+		}
+	})
+
+	t.Run("6. Restore errors and REPLACE", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), "test_name", "DUMPRESTORE, 6")
+
+		if _, err := mockServer.CreateKeyAndLock(ctx, "RestoreKey6"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := mockServer.SetValue(ctx, "RestoreKey6", "old"); err != nil {
+			t.Error(err)
+		}
+		mockServer.KeyUnlock(ctx, "RestoreKey6")
+
+		payload, err := snapshot.EncodeDump("new", 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		errorTests := []struct {
+			command     []string
+			expectedErr string
+		}{
+			{
+				command:     []string{"RESTORE", "RestoreKey6", "0", string(payload)},
+				expectedErr: "BUSYKEY target key name already exists",
+			},
+			{
+				command:     []string{"RESTORE", "RestoreKey6", "-1", string(payload), "REPLACE"},
+				expectedErr: "invalid TTL value, must be >= 0",
+			},
+			{
+				command:     []string{"RESTORE", "RestoreKey6", "0", string(payload[:len(payload)-1]) + "x", "REPLACE"},
+				expectedErr: snapshot.ErrDumpPayload.Error(),
+			},
+			{
+				command:     []string{"RESTORE", "RestoreKey6", "0", string(payload), "IDLETIME", "10", "FREQ", "5"},
+				expectedErr: "IDLETIME and FREQ cannot be used together",
+			},
+			{
+				command:     []string{"RESTORE", "RestoreKey6", "0", string(payload), "FREQ", "256"},
+				expectedErr: "invalid FREQ value, must be >= 0 and <= 255",
+			},
+			{
+				command:     []string{"RESTORE", "RestoreKey6", "0", string(payload), "FRESH"},
+				expectedErr: "unknown option FRESH for restore command",
+			},
+			{
+				command:     []string{"RESTORE", "RestoreKey6", "0"},
+				expectedErr: constants.WrongArgsResponse,
+			},
+		}
+		for _, errorTest := range errorTests {
+			if _, err = handleRestore(ctx, errorTest.command, mockServer, nil); err == nil || err.Error() != errorTest.expectedErr {
+				t.Errorf("expected error \"%s\", got \"%v\"", errorTest.expectedErr, err)
+			}
+		}
+
+		if _, err = handleRestore(ctx, []string{"RESTORE", "RestoreKey6", "0", string(payload), "REPLACE"}, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+		if value := mockServer.GetValue(ctx, "RestoreKey6"); value != "new" {
+			t.Errorf("expected value new, got %+v", value)
+		}
+
+		// Restoring with an expiry time in the past deletes the existing key.
+		past := fmt.Sprintf("%d", mockClock.Now().Add(-time.Second).UnixMilli())
+		command := []string{"RESTORE", "RestoreKey6", past, string(payload), "REPLACE", "ABSTTL"}
+		if _, err = handleRestore(ctx, command, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+		if mockServer.KeyExists(ctx, "RestoreKey6") {
+			t.Error("expected RestoreKey6 to be deleted")
+		}
+	})
+}
+
+func Test_RewriteRESTORE(t *testing.T) {
+	withTTL, _ := snapshot.EncodeDump("value", 30*time.Second)
+	withoutTTL, _ := snapshot.EncodeDump("value", 0)
+
+	tests := []struct {
+		name          string
+		command       []string
+		expected      []string
+		expectedError error
+	}{
+		{
+			name:    "1. Rewrite relative TTL into ABSTTL",
+			command: []string{"RESTORE", "key", "100", string(withoutTTL), "REPLACE"},
+			expected: []string{
+				"RESTORE", "key", fmt.Sprintf("%d", mockClock.Now().Add(100*time.Millisecond).UnixMilli()),
+				string(withoutTTL), "REPLACE", "ABSTTL",
+			},
+		},
+		{
+			name:    "2. Rewrite the TTL stored in the payload into ABSTTL",
+			command: []string{"RESTORE", "key", "0", string(withTTL)},
+			expected: []string{
+				"RESTORE", "key", fmt.Sprintf("%d", mockClock.Now().Add(30*time.Second).UnixMilli()), string(withTTL), "ABSTTL",
+			},
+		},
+		{
+			name:     "3. Rewrite key without expiry into ABSTTL 0",
+			command:  []string{"RESTORE", "key", "0", string(withoutTTL)},
+			expected: []string{"RESTORE", "key", "0", string(withoutTTL), "ABSTTL"},
+		},
+		{
+			name:     "4. Leave ABSTTL command unchanged",
+			command:  []string{"RESTORE", "key", "1000", string(withTTL), "ABSTTL"},
+			expected: []string{"RESTORE", "key", "1000", string(withTTL), "ABSTTL"},
+		},
+		{
+			name:          "5. Return error when the payload is corrupted",
+			command:       []string{"RESTORE", "key", "0", "payload"},
+			expectedError: snapshot.ErrDumpPayload,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := rewriteRestore(test.command, mockServer)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected command %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+// startMigrateTarget starts a TCP server that records the commands it receives.
+// It replies with an error to RESTORE commands for the rejected key and with OK to everything else.
+func startMigrateTarget(t *testing.T, rejectedKey string) (string, string, <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	commands := make(chan []string, 16)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		rd := resp.NewReader(conn)
+		for {
+			v, _, err := rd.ReadValue()
+			if err != nil {
+				close(commands)
+				return
+			}
+			var command []string
+			for _, arg := range v.Array() {
+				command = append(command, arg.String())
+			}
+			commands <- command
+			reply := constants.OkResponse
+			if strings.EqualFold(command[0], "restore") && command[1] == rejectedKey {
+				reply = "-Error BUSYKEY target key name already exists\r\n"
+			}
+			if _, err = conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, commands
+}
+
+func Test_HandleMIGRATE(t *testing.T) {
+	tests := []struct {
+		name             string
+		command          func(host, port string) []string
+		rejectedKey      string
+		presetValues     map[string]interface{}
+		expectedResponse string
+		expectedErr      string
+		expectedCommands [][]string // Commands received by the target, with the payload replaced by its value
+		expectToExist    map[string]bool
+	}{
+		{
+			name: "1. Migrate single key",
+			command: func(host, port string) []string {
+				return []string{"MIGRATE", host, port, "MigrateKey1", "0", "1000"}
+			},
+			presetValues:     map[string]interface{}{"MigrateKey1": "value1"},
+			expectedResponse: "OK",
+			expectedCommands: [][]string{{"RESTORE", "MigrateKey1", "0", "value1"}},
+			expectToExist:    map[string]bool{"MigrateKey1": false},
+		},
+		{
+			name: "2. Copy multiple keys with authentication and replace",
+			command: func(host, port string) []string {
+				return []string{
+					"MIGRATE", host, port, "", "0", "1000", "COPY", "REPLACE", "AUTH2", "user", "password",
+					"KEYS", "MigrateKey2", "MigrateKey3", "MigrateKey4",
+				}
+			},
+			presetValues:     map[string]interface{}{"MigrateKey2": "value2", "MigrateKey3": 3},
+			expectedResponse: "OK",
+			expectedCommands: [][]string{
+				{"AUTH", "user", "password"},
+				{"RESTORE", "MigrateKey2", "0", "value2", "REPLACE"},
+				{"RESTORE", "MigrateKey3", "0", "3", "REPLACE"},
+			},
+			expectToExist: map[string]bool{"MigrateKey2": true, "MigrateKey3": true},
+		},
+		{
+			name: "3. Keep every key when the target rejects one of them",
+			command: func(host, port string) []string {
+				return []string{"MIGRATE", host, port, "", "0", "1000", "KEYS", "MigrateKey5", "MigrateKey6", "MigrateKey11"}
+			},
+			rejectedKey: "MigrateKey6",
+			presetValues: map[string]interface{}{
+				"MigrateKey5": "value5", "MigrateKey6": "value6", "MigrateKey11": "value11",
+			},
+			expectedErr: "target could not restore key MigrateKey6 after restoring keys MigrateKey5: " +
+				"Error BUSYKEY target key name already exists",
+			expectedCommands: [][]string{
+				{"RESTORE", "MigrateKey5", "0", "value5"},
+				{"RESTORE", "MigrateKey6", "0", "value6"},
+			},
+			expectToExist: map[string]bool{"MigrateKey5": true, "MigrateKey6": true, "MigrateKey11": true},
+		},
+		{
+			name: "4. Return NOKEY when no keys exist",
+			command: func(host, port string) []string {
+				return []string{"MIGRATE", host, port, "MigrateKey7", "0", "1000"}
+			},
+			expectedResponse: "NOKEY",
+		},
+		{
+			name: "5. Return error when destination database is not 0",
+			command: func(host, port string) []string {
+				return []string{"MIGRATE", host, port, "MigrateKey8", "1", "1000"}
+			},
+			expectedErr: "destination database must be 0",
+		},
+		{
+			name: "6. Return error when KEYS is used with a key",
+			command: func(host, port string) []string {
+				return []string{"MIGRATE", host, port, "MigrateKey9", "0", "1000", "KEYS", "MigrateKey10"}
+			},
+			expectedErr: "key must be an empty string when KEYS is specified",
+		},
+		{
+			name: "7. Migrate a key that is given twice once",
+			command: func(host, port string) []string {
+				return []string{"MIGRATE", host, port, "", "0", "1000", "KEYS", "MigrateKey12", "MigrateKey13", "MigrateKey12"}
+			},
+			presetValues:     map[string]interface{}{"MigrateKey12": "value12", "MigrateKey13": "value13"},
+			expectedResponse: "OK",
+			expectedCommands: [][]string{
+				{"RESTORE", "MigrateKey12", "0", "value12"},
+				{"RESTORE", "MigrateKey13", "0", "value13"},
+			},
+			expectToExist: map[string]bool{"MigrateKey12": false, "MigrateKey13": false},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), "test_name", fmt.Sprintf("MIGRATE, %d", i))
+
+			for k, v := range test.presetValues {
+				if _, err := mockServer.CreateKeyAndLock(ctx, k); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := mockServer.SetValue(ctx, k, v); err != nil {
+					t.Error(err)
+				}
+				mockServer.KeyUnlock(ctx, k)
+			}
+
+			host, port, commands := startMigrateTarget(t, test.rejectedKey)
+
+			res, err := handleMigrate(ctx, test.command(host, port), mockServer, nil)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Errorf("expected error \"%s\", got \"%v\"", test.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Error(err)
+				return
+			} else {
+				rv, _, err := resp.NewReader(bytes.NewReader(res)).ReadValue()
+				if err != nil {
+					t.Error(err)
+				}
+				if rv.String() != test.expectedResponse {
+					t.Errorf("expected response %s, got %s", test.expectedResponse, rv.String())
+				}
+			}
+
+			for _, expected := range test.expectedCommands {
+				command := <-commands
+				if strings.EqualFold(command[0], "restore") {
+					value, _, err := snapshot.DecodeDump([]byte(command[3]))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					command[3] = fmt.Sprintf("%v", value)
+				}
+				if !reflect.DeepEqual(command, expected) {
+					t.Errorf("expected target to receive %v, got %v", expected, command)
+				}
+			}
+
+			for k, expected := range test.expectToExist {
+				if exists := mockServer.KeyExists(ctx, k); exists != expected {
+					t.Errorf("expected key %s exists status to be %+v, got %+v", k, expected, exists)
+				}
+			}
+		})
+	}
+}
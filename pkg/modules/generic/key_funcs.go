@@ -135,3 +135,45 @@ func expireAtKeyFunc(cmd []string) (types.AccessKeys, error) {
 		WriteKeys: cmd[1:2],
 	}, nil
 }
+
+func dumpKeyFunc(cmd []string) (types.AccessKeys, error) {
+	if len(cmd) != 2 {
+		return types.AccessKeys{}, errors.New(constants.WrongArgsResponse)
+	}
+	return types.AccessKeys{
+		Channels:  make([]string, 0),
+		ReadKeys:  cmd[1:],
+		WriteKeys: make([]string, 0),
+	}, nil
+}
+
+func restoreKeyFunc(cmd []string) (types.AccessKeys, error) {
+	if len(cmd) < 4 || len(cmd) > 9 {
+		return types.AccessKeys{}, errors.New(constants.WrongArgsResponse)
+	}
+	return types.AccessKeys{
+		Channels:  make([]string, 0),
+		ReadKeys:  make([]string, 0),
+		WriteKeys: cmd[1:2],
+	}, nil
+}
+
+func migrateKeyFunc(cmd []string) (types.AccessKeys, error) {
+	params, err := getMigrateCommandParams(cmd)
+	if err != nil {
+		return types.AccessKeys{}, err
+	}
+	// COPY leaves the keys in place, so only read access is required.
+	if params.copy {
+		return types.AccessKeys{
+			Channels:  make([]string, 0),
+			ReadKeys:  params.keys,
+			WriteKeys: make([]string, 0),
+		}, nil
+	}
+	return types.AccessKeys{
+		Channels:  make([]string, 0),
+		ReadKeys:  make([]string, 0),
+		WriteKeys: params.keys,
+	}, nil
+}
@@ -15,9 +15,17 @@
 package generic
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/echovault/echovault/internal"
 	"github.com/echovault/echovault/internal/clock"
+	"github.com/echovault/echovault/internal/snapshot"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"github.com/tidwall/resp"
+	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -116,3 +124,314 @@ func getSetCommandParams(clock clock.Clock, cmd []string, params SetParams) (Set
 		return SetParams{}, fmt.Errorf("unknown option %s for set command", strings.ToUpper(cmd[0]))
 	}
 }
+
+// rewriteSet replaces the relative EX and PX options of SET with the absolute PXAT option,
+// so that replicas and AOF replays set the same expiry time regardless of when they apply the command.
+func rewriteSet(cmd []string, server types.EchoVault) ([]string, error) {
+	if _, err := setKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	params, err := getSetCommandParams(server.GetClock(), cmd[3:], SetParams{})
+	if err != nil {
+		return nil, err
+	}
+	rewritten := slices.Clone(cmd)
+	for i := 3; i < len(rewritten); i++ {
+		switch strings.ToLower(rewritten[i]) {
+		case "ex", "px":
+			rewritten[i] = "PXAT"
+			rewritten[i+1] = strconv.FormatInt(params.expireAt.(time.Time).UnixMilli(), 10)
+			i += 1
+		case "exat", "pxat":
+			i += 1
+		}
+	}
+	return rewritten, nil
+}
+
+// rewriteExpire rewrites EXPIRE and PEXPIRE into PEXPIREAT with the absolute expiry time,
+// so that replicas and AOF replays set the same expiry time regardless of when they apply the command.
+func rewriteExpire(cmd []string, server types.EchoVault) ([]string, error) {
+	if _, err := expireKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(cmd[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("expire time must be integer")
+	}
+	expireAt := server.GetClock().Now().Add(time.Duration(n) * time.Second)
+	if strings.ToLower(cmd[0]) == "pexpire" {
+		expireAt = server.GetClock().Now().Add(time.Duration(n) * time.Millisecond)
+	}
+	return append([]string{"PEXPIREAT", cmd[1], strconv.FormatInt(expireAt.UnixMilli(), 10)}, cmd[3:]...), nil
+}
+
+type RestoreParams struct {
+	ttl      int64 // TTL in milliseconds, or the exact expiry time in unix milliseconds when absTTL is set
+	replace  bool
+	absTTL   bool
+	idleTime int64 // In seconds. -1 if IDLETIME is not set.
+	freq     int64 // -1 if FREQ is not set.
+}
+
+func getRestoreCommandParams(cmd []string) (RestoreParams, error) {
+	ttl, err := strconv.ParseInt(cmd[2], 10, 64)
+	if err != nil || ttl < 0 {
+		return RestoreParams{}, errors.New("invalid TTL value, must be >= 0")
+	}
+	params := RestoreParams{ttl: ttl, idleTime: -1, freq: -1}
+	for i := 4; i < len(cmd); i++ {
+		switch strings.ToLower(cmd[i]) {
+		case "replace":
+			params.replace = true
+		case "absttl":
+			params.absTTL = true
+		case "idletime":
+			if params.freq != -1 {
+				return RestoreParams{}, errors.New("IDLETIME and FREQ cannot be used together")
+			}
+			if i+1 >= len(cmd) {
+				return RestoreParams{}, errors.New("seconds value required after IDLETIME")
+			}
+			idleTime, err := strconv.ParseInt(cmd[i+1], 10, 64)
+			if err != nil || idleTime < 0 {
+				return RestoreParams{}, errors.New("invalid IDLETIME value, must be >= 0")
+			}
+			params.idleTime = idleTime
+			i += 1
+		case "freq":
+			if params.idleTime != -1 {
+				return RestoreParams{}, errors.New("IDLETIME and FREQ cannot be used together")
+			}
+			if i+1 >= len(cmd) {
+				return RestoreParams{}, errors.New("frequency value required after FREQ")
+			}
+			freq, err := strconv.ParseInt(cmd[i+1], 10, 64)
+			if err != nil || freq < 0 || freq > 255 {
+				return RestoreParams{}, errors.New("invalid FREQ value, must be >= 0 and <= 255")
+			}
+			params.freq = freq
+			i += 1
+		default:
+			return RestoreParams{}, fmt.Errorf("unknown option %s for restore command", strings.ToUpper(cmd[i]))
+		}
+	}
+	return params, nil
+}
+
+// restoreExpireAt returns the expiry time of a restored key.
+// Without ABSTTL, a TTL of 0 keeps the TTL stored in the payload.
+// With ABSTTL, a TTL of 0 means the key does not expire.
+func restoreExpireAt(clock clock.Clock, params RestoreParams, payloadTTL time.Duration) time.Time {
+	switch {
+	case params.absTTL && params.ttl > 0:
+		return time.UnixMilli(params.ttl)
+	case params.absTTL:
+		return time.Time{}
+	case params.ttl > 0:
+		return clock.Now().Add(time.Duration(params.ttl) * time.Millisecond)
+	case payloadTTL > 0:
+		return clock.Now().Add(payloadTTL)
+	default:
+		return time.Time{}
+	}
+}
+
+// rewriteRestore replaces the relative TTL of RESTORE with the absolute expiry time and the ABSTTL option,
+// so that replicas and AOF replays set the same expiry time regardless of when they apply the command.
+func rewriteRestore(cmd []string, server types.EchoVault) ([]string, error) {
+	if _, err := restoreKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	params, err := getRestoreCommandParams(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if params.absTTL {
+		return cmd, nil
+	}
+	_, payloadTTL, err := snapshot.DecodeDump([]byte(cmd[3]))
+	if err != nil {
+		return nil, err
+	}
+	var expireAt int64
+	if t := restoreExpireAt(server.GetClock(), params, payloadTTL); t != (time.Time{}) {
+		expireAt = t.UnixMilli()
+	}
+	rewritten := slices.Clone(cmd)
+	rewritten[2] = strconv.FormatInt(expireAt, 10)
+	return append(rewritten, "ABSTTL"), nil
+}
+
+type MigrateParams struct {
+	address string
+	keys    []string
+	timeout time.Duration
+	copy    bool
+	replace bool
+	auth    []string // The arguments of the AUTH command sent to the target, if any
+}
+
+func getMigrateCommandParams(cmd []string) (MigrateParams, error) {
+	if len(cmd) < 6 {
+		return MigrateParams{}, errors.New(constants.WrongArgsResponse)
+	}
+	if cmd[4] != "0" {
+		return MigrateParams{}, errors.New("destination database must be 0")
+	}
+	timeout, err := strconv.ParseInt(cmd[5], 10, 64)
+	if err != nil {
+		return MigrateParams{}, errors.New("timeout must be an integer")
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	params := MigrateParams{
+		address: net.JoinHostPort(cmd[1], cmd[2]),
+		timeout: time.Duration(timeout) * time.Millisecond,
+	}
+	if cmd[3] != "" {
+		params.keys = []string{cmd[3]}
+	}
+
+	for i := 6; i < len(cmd); i++ {
+		switch strings.ToLower(cmd[i]) {
+		case "copy":
+			params.copy = true
+		case "replace":
+			params.replace = true
+		case "auth":
+			if i+1 >= len(cmd) {
+				return MigrateParams{}, errors.New("password required after AUTH")
+			}
+			params.auth = []string{"AUTH", cmd[i+1]}
+			i += 1
+		case "auth2":
+			if i+2 >= len(cmd) {
+				return MigrateParams{}, errors.New("username and password required after AUTH2")
+			}
+			params.auth = []string{"AUTH", cmd[i+1], cmd[i+2]}
+			i += 2
+		case "keys":
+			if cmd[3] != "" {
+				return MigrateParams{}, errors.New("key must be an empty string when KEYS is specified")
+			}
+			if i+1 >= len(cmd) {
+				return MigrateParams{}, errors.New("at least one key required after KEYS")
+			}
+			// A key that is given twice is only moved once, as it can only be locked once.
+			for _, key := range cmd[i+1:] {
+				if !slices.Contains(params.keys, key) {
+					params.keys = append(params.keys, key)
+				}
+			}
+			i = len(cmd)
+		default:
+			return MigrateParams{}, fmt.Errorf("unknown option %s for migrate command", strings.ToUpper(cmd[i]))
+		}
+	}
+
+	if len(params.keys) == 0 {
+		return MigrateParams{}, errors.New("no keys specified")
+	}
+	return params, nil
+}
+
+// dumpKey serializes the value and remaining TTL of a key. The caller must hold the key's lock.
+func dumpKey(ctx context.Context, server types.EchoVault, key string) ([]byte, error) {
+	var ttl time.Duration
+	if expireAt := server.GetExpiry(ctx, key); expireAt != (time.Time{}) {
+		// Keep at least 1ms so that a key that is about to expire is not restored as a persistent key.
+		ttl = max(expireAt.Sub(server.GetClock().Now()), time.Millisecond)
+	}
+	return snapshot.EncodeDump(server.GetValue(ctx, key), ttl)
+}
+
+// migrateKeys sends the keys to the target with RESTORE commands and returns the keys that the target accepted.
+// Unless params.copy is set, the keys are then deleted from this node.
+// The keys are locked until the transfer ends, or until they are deleted, so that they cannot change while they
+// are being moved. The transfer stops at the first key that the target rejects, and the error names the keys that
+// the target restored before it. No key is deleted then, so either all the keys move or none of them do.
+func migrateKeys(ctx context.Context, server types.EchoVault, params MigrateParams) ([]string, error) {
+	lock, unlock := server.KeyLock, server.KeyUnlock
+	if params.copy {
+		lock, unlock = server.KeyRLock, server.KeyRUnlock
+	}
+
+	var keys []string
+	var payloads [][]byte
+	for _, key := range params.keys {
+		if !server.KeyExists(ctx, key) {
+			continue
+		}
+		if _, err := lock(ctx, key); err != nil {
+			return nil, err
+		}
+		defer unlock(ctx, key)
+		payload, err := dumpKey(ctx, server, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not dump key %s: %w", key, err)
+		}
+		keys = append(keys, key)
+		payloads = append(payloads, payload)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", params.address, params.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to target: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// EchoVault reads one command at a time from a connection, so the commands are not pipelined.
+	reader := resp.NewReader(conn)
+	send := func(command []string) error {
+		if err := conn.SetDeadline(time.Now().Add(params.timeout)); err != nil {
+			return err
+		}
+		if _, err := conn.Write(internal.EncodeCommand(command)); err != nil {
+			return err
+		}
+		reply, _, err := reader.ReadValue()
+		if err != nil {
+			return err
+		}
+		if reply.Type() == resp.Error {
+			return errors.New(reply.String())
+		}
+		return nil
+	}
+
+	if params.auth != nil {
+		if err = send(params.auth); err != nil {
+			return nil, fmt.Errorf("target rejected AUTH: %w", err)
+		}
+	}
+
+	for i, key := range keys {
+		command := []string{"RESTORE", key, "0", string(payloads[i])}
+		if params.replace {
+			command = append(command, "REPLACE")
+		}
+		if err = send(command); err != nil {
+			if i > 0 {
+				return nil, fmt.Errorf("target could not restore key %s after restoring keys %s: %w",
+					key, strings.Join(keys[:i], ", "), err)
+			}
+			return nil, fmt.Errorf("target could not restore key %s: %w", key, err)
+		}
+	}
+
+	// The keys are deleted before they are unlocked, so that no write between the transfer and the deletion
+	// is lost. Every key exists on at least one of the nodes.
+	if !params.copy {
+		if _, err = server.ApplyCommand(ctx, append([]string{"DEL"}, keys...), keys); err != nil {
+			return nil, fmt.Errorf("keys were restored on the target but could not be deleted: %w", err)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	internal_ratelimit "github.com/echovault/echovault/internal/ratelimit"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type throttleParams struct {
+	limit    internal_ratelimit.Limit
+	quantity int64
+}
+
+func parseThrottleParams(cmd []string) (throttleParams, error) {
+	params := throttleParams{quantity: 1}
+
+	maxBurst, err := strconv.ParseInt(cmd[2], 10, 64)
+	if err != nil || maxBurst < 0 {
+		return throttleParams{}, errors.New("max burst must be a non-negative integer")
+	}
+	count, err := strconv.ParseInt(cmd[3], 10, 64)
+	if err != nil || count <= 0 {
+		return throttleParams{}, errors.New("count must be a positive integer")
+	}
+	period, err := strconv.ParseInt(cmd[4], 10, 64)
+	if err != nil || period <= 0 || period > int64(time.Duration(1<<62)/time.Second) {
+		return throttleParams{}, errors.New("period must be a positive integer")
+	}
+	params.limit = internal_ratelimit.Limit{
+		MaxBurst: maxBurst,
+		Count:    count,
+		Period:   time.Duration(period) * time.Second,
+	}
+	if params.limit.Period/time.Duration(count) == 0 {
+		return throttleParams{}, errors.New("count per period is too high")
+	}
+
+	if len(cmd) == 6 {
+		if params.quantity, err = strconv.ParseInt(cmd[5], 10, 64); err != nil || params.quantity < 0 {
+			return throttleParams{}, errors.New("quantity must be a non-negative integer")
+		}
+	}
+	return params, nil
+}
+
+// throttleLocks serialize the decisions on a key between reading the limiter state and writing the new state.
+// The key lock cannot be used, as the state is written by a separate SET command.
+var throttleLocks [64]sync.Mutex
+
+func throttleLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &throttleLocks[h.Sum32()%uint32(len(throttleLocks))]
+}
+
+// ceilSeconds rounds the duration up to whole seconds. Negative durations are returned as -1.
+func ceilSeconds(d time.Duration) int64 {
+	if d < 0 {
+		return -1
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+func handleCLThrottle(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := clThrottleKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	params, err := parseThrottleParams(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := throttleLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := server.GetClock().Now().UnixNano()
+
+	// The limiter state is the theoretical arrival time of the next request in unix nanoseconds.
+	var tat int64
+	if server.KeyExists(ctx, key) {
+		if _, err = server.KeyRLock(ctx, key); err != nil {
+			return nil, err
+		}
+		value := server.GetValue(ctx, key)
+		server.KeyRUnlock(ctx, key)
+		switch value := value.(type) {
+		case int:
+			tat = int64(value)
+		default:
+			return nil, fmt.Errorf("value at key %s is not a rate limiter", key)
+		}
+	}
+
+	result := internal_ratelimit.Throttle(params.limit, tat, now, params.quantity)
+
+	// The decision is made once, on this node. The AOF and the replicas receive the resulting state,
+	// which expires once the theoretical arrival time has passed, as the state is meaningless afterwards.
+	if result.TAT != tat && result.TAT > now {
+		expireAt := (result.TAT + int64(time.Millisecond) - 1) / int64(time.Millisecond)
+		if _, err = server.ApplyCommand(ctx, []string{
+			"SET", key, strconv.FormatInt(result.TAT, 10), "PXAT", strconv.FormatInt(expireAt, 10),
+		}, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	limited := 0
+	if result.Limited {
+		limited = 1
+	}
+	return []byte(fmt.Sprintf("*5\r\n:%d\r\n:%d\r\n:%d\r\n:%d\r\n:%d\r\n",
+		limited,
+		result.Limit,
+		result.Remaining,
+		ceilSeconds(result.RetryAfter),
+		ceilSeconds(result.ResetAfter),
+	)), nil
+}
+
+func Commands() []types.Command {
+	return []types.Command{
+		{
+			Command: "cl.throttle",
+			Module:  constants.RateLimitModule,
+			// CL.THROTTLE is not a write command. The decision is made once on the node that receives it,
+			// and the resulting state is written to the AOF and replicated as a SET command.
+			Categories: []string{constants.RateLimitCategory, constants.FastCategory},
+			Description: `(CL.THROTTLE key max_burst count period [quantity])
+Rate limits the key with the generic cell rate algorithm. count requests are allowed every period seconds,
+with bursts of up to max_burst requests in excess of the rate. quantity is the cost of the request and defaults to 1.
+Returns an array of 5 integers: 0 if the request is allowed or 1 if it is limited, the total limit (max_burst + 1),
+the remaining number of requests, the number of seconds until the request would be allowed (-1 if it is allowed),
+and the number of seconds until the limit resets. The decision is made at the server time when the command is received.
+The key holds the theoretical arrival time of the next request in unix nanoseconds, and expires at that time.
+In a cluster, the command must be sent to the leader, as the decision is made with the state of the receiving node.`,
+			Sync:              false,
+			KeyExtractionFunc: clThrottleKeyFunc,
+			HandlerFunc:       handleCLThrottle,
+		},
+	}
+}
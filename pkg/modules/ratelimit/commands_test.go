@@ -0,0 +1,175 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/config"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/echovault"
+	"github.com/echovault/echovault/pkg/modules/generic"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var mockServer *echovault.EchoVault
+
+func init() {
+	mockServer, _ = echovault.NewEchoVault(
+		// The state is written with SET.
+		echovault.WithCommands(append(generic.Commands(), Commands()...)),
+		echovault.WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+}
+
+func Test_HandleCLThrottle(t *testing.T) {
+	ctx := context.Background()
+	now := mockServer.GetClock().Now()
+
+	if _, err := mockServer.CreateKeyAndLock(ctx, "ThrottleKey2"); err != nil {
+		t.Error(err)
+	}
+	if err := mockServer.SetValue(ctx, "ThrottleKey2", "value"); err != nil {
+		t.Error(err)
+	}
+	mockServer.KeyUnlock(ctx, "ThrottleKey2")
+
+	// 1 request per second with a burst of 2, i.e. up to 3 requests at once.
+	tests := []struct {
+		name             string
+		presetTAT        time.Duration // The state of the key before the command, relative to now. 0 to keep the key.
+		command          []string
+		expectedResponse []int
+		expectedTAT      time.Duration // The state of the key after the command, relative to now. 0 if it does not exist.
+		expectedError    error
+	}{
+		{
+			name:             "1. Allow the first request",
+			command:          []string{"CL.THROTTLE", "ThrottleKey1", "2", "1", "1", "1"},
+			expectedResponse: []int{0, 3, 2, -1, 1},
+			expectedTAT:      time.Second,
+		},
+		{
+			name:             "2. Allow requests up to the burst",
+			command:          []string{"CL.THROTTLE", "ThrottleKey1", "2", "1", "1", "2"},
+			expectedResponse: []int{0, 3, 0, -1, 3},
+			expectedTAT:      3 * time.Second,
+		},
+		{
+			name:             "3. Limit the request when the burst is used up without changing the state",
+			command:          []string{"CL.THROTTLE", "ThrottleKey1", "2", "1", "1"},
+			expectedResponse: []int{1, 3, 0, 1, 3},
+			expectedTAT:      3 * time.Second,
+		},
+		{
+			name:             "4. Limit the request until the emission interval has passed",
+			presetTAT:        2500 * time.Millisecond,
+			command:          []string{"CL.THROTTLE", "ThrottleKey3", "2", "1", "1"},
+			expectedResponse: []int{1, 3, 0, 1, 3},
+			expectedTAT:      2500 * time.Millisecond,
+		},
+		{
+			name:             "5. Allow the request once the emission interval has passed",
+			presetTAT:        2 * time.Second,
+			command:          []string{"CL.THROTTLE", "ThrottleKey3", "2", "1", "1"},
+			expectedResponse: []int{0, 3, 0, -1, 3},
+			expectedTAT:      3 * time.Second,
+		},
+		{
+			name:             "6. Quantity 0 does not store a state",
+			command:          []string{"CL.THROTTLE", "ThrottleKey4", "2", "1", "1", "0"},
+			expectedResponse: []int{0, 3, 3, -1, 0},
+		},
+		{
+			name:          "7. Return error when the key does not hold a rate limiter",
+			command:       []string{"CL.THROTTLE", "ThrottleKey2", "2", "1", "1"},
+			expectedError: errors.New("value at key ThrottleKey2 is not a rate limiter"),
+		},
+		{
+			name:          "8. Return error on invalid count",
+			command:       []string{"CL.THROTTLE", "ThrottleKey5", "2", "0", "1"},
+			expectedError: errors.New("count must be a positive integer"),
+		},
+		{
+			name:          "9. Return error on invalid quantity",
+			command:       []string{"CL.THROTTLE", "ThrottleKey5", "2", "1", "1", "-1"},
+			expectedError: errors.New("quantity must be a non-negative integer"),
+		},
+		{
+			name:          "10. Command too short",
+			command:       []string{"CL.THROTTLE", "ThrottleKey5", "2", "1"},
+			expectedError: errors.New(constants.WrongArgsResponse),
+		},
+		{
+			name:          "11. Command too long",
+			command:       []string{"CL.THROTTLE", "ThrottleKey5", "2", "1", "1", "1", "1"},
+			expectedError: errors.New(constants.WrongArgsResponse),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := test.command[1]
+			if test.presetTAT != 0 {
+				tat := now.Add(test.presetTAT)
+				if _, err := mockServer.SET(key, strconv.FormatInt(tat.UnixNano(), 10),
+					echovault.SETOptions{PXAT: int(tat.UnixMilli())}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			res, err := handleCLThrottle(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			got, err := internal.ParseIntegerArrayResponse(res)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !reflect.DeepEqual(got, test.expectedResponse) {
+				t.Errorf("expected response %v, got %v", test.expectedResponse, got)
+			}
+
+			if test.expectedTAT == 0 {
+				if mockServer.KeyExists(ctx, key) {
+					t.Errorf("expected key %s not to exist", key)
+				}
+				return
+			}
+			tat := now.Add(test.expectedTAT)
+			if value := mockServer.GetValue(ctx, key); value != int(tat.UnixNano()) {
+				t.Errorf("expected state %d, got %v", tat.UnixNano(), value)
+			}
+			if expireAt := mockServer.GetExpiry(ctx, key); !expireAt.Equal(tat) {
+				t.Errorf("expected expiry %v, got %v", tat, expireAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,604 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	internal_acl "github.com/echovault/echovault/internal/acl"
+	internal_search "github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"log"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func getRegistry(server types.EchoVault) (*internal_search.Registry, error) {
+	registry, ok := server.GetSearchIndexes().(*internal_search.Registry)
+	if !ok {
+		return nil, errors.New("could not load search module")
+	}
+	return registry, nil
+}
+
+// authorizeDocuments checks the documents that a command reads or deletes against the key patterns of the
+// connection's user, as they are only known once the index is queried. Embedded calls have no connection.
+func authorizeDocuments(server types.EchoVault, conn *net.Conn, readKeys []string, writeKeys []string) error {
+	acl, ok := server.GetACL().(*internal_acl.ACL)
+	if !ok || acl == nil || conn == nil {
+		return nil
+	}
+	return acl.AuthorizeKeys(conn, readKeys, writeKeys)
+}
+
+// getHash returns a string representation of the hash at key, or false if the key is not a hash.
+func getHash(ctx context.Context, server types.EchoVault, key string) (map[string]string, bool) {
+	if !server.KeyExists(ctx, key) {
+		return nil, false
+	}
+	if _, err := server.KeyRLock(ctx, key); err != nil {
+		return nil, false
+	}
+	defer server.KeyRUnlock(ctx, key)
+	hash, ok := server.GetValue(ctx, key).(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	res := make(map[string]string, len(hash))
+	for field, value := range hash {
+		switch v := value.(type) {
+		case string:
+			res[field] = v
+		case int:
+			res[field] = strconv.Itoa(v)
+		case float64:
+			res[field] = formatFloat(v)
+		default:
+			res[field] = fmt.Sprintf("%v", v)
+		}
+	}
+	return res, true
+}
+
+func handleFTCreate(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if _, err := ftCreateKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	registry, err := getRegistry(server)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := internal_search.ParseSchema(cmd[1:])
+	if err != nil {
+		return nil, err
+	}
+	if err = registry.Create(schema); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleFTSearch(ctx context.Context, cmd []string, server types.EchoVault, conn *net.Conn) ([]byte, error) {
+	if _, err := ftSearchKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	registry, err := getRegistry(server)
+	if err != nil {
+		return nil, err
+	}
+	index, err := registry.Get(cmd[1])
+	if err != nil {
+		return nil, err
+	}
+	params, err := getSearchParams(cmd[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	query, knn, err := internal_search.ParseKNN(cmd[2], params.params)
+	if err != nil {
+		return nil, err
+	}
+	if len(params.filters) > 0 {
+		query = fmt.Sprintf("(%s) %s", query, strings.Join(params.filters, " "))
+	}
+
+	var keys []string
+	scores := make(map[string]string)
+	if knn == nil {
+		if keys, err = index.Query(query); err != nil {
+			return nil, err
+		}
+	} else {
+		neighbours, err := index.KNN(query, *knn)
+		if err != nil {
+			return nil, err
+		}
+		keys = make([]string, len(neighbours))
+		for i, neighbour := range neighbours {
+			keys[i] = neighbour.Key
+			scores[neighbour.Key] = strconv.FormatFloat(float64(neighbour.Distance), 'f', -1, 32)
+		}
+	}
+	if err = authorizeDocuments(server, conn, keys, nil); err != nil {
+		return nil, err
+	}
+
+	value := func(key string, field string) string {
+		if knn != nil && field == knn.ScoreField {
+			return scores[key]
+		}
+		v, _ := index.Value(key, field)
+		return v
+	}
+	if params.sortBy != nil {
+		if _, ok := index.Schema().GetField(params.sortBy.field); !ok &&
+			(knn == nil || params.sortBy.field != knn.ScoreField) {
+			return nil, fmt.Errorf("unknown sort field %s", params.sortBy.field)
+		}
+	}
+	// KNN results are already ordered by distance.
+	if knn == nil || params.sortBy != nil {
+		slices.SortStableFunc(keys, func(a, b string) int {
+			if params.sortBy != nil {
+				if c := compareValues(value(a, params.sortBy.field), value(b, params.sortBy.field)); c != 0 {
+					if params.sortBy.descending {
+						return -c
+					}
+					return c
+				}
+			}
+			if knn != nil {
+				return 0
+			}
+			return strings.Compare(a, b)
+		})
+	}
+
+	total := len(keys)
+	keys = paginate(keys, params.offset, params.limit)
+
+	var res strings.Builder
+	if params.noContent {
+		res.WriteString(fmt.Sprintf("*%d\r\n:%d\r\n", len(keys)+1, total))
+		for _, key := range keys {
+			res.WriteString(bulkString(key))
+		}
+		return []byte(res.String()), nil
+	}
+
+	res.WriteString(fmt.Sprintf("*%d\r\n:%d\r\n", (len(keys)*2)+1, total))
+	for _, key := range keys {
+		res.WriteString(bulkString(key))
+		hash, ok := getHash(ctx, server, key)
+		if !ok {
+			res.WriteString("*0\r\n")
+			continue
+		}
+		if knn != nil {
+			hash[knn.ScoreField] = scores[key]
+		}
+		fields := params.returns
+		if len(fields) == 0 {
+			fields = make([]string, 0, len(hash))
+			for field := range hash {
+				fields = append(fields, field)
+			}
+			slices.Sort(fields)
+		}
+		var entry strings.Builder
+		count := 0
+		for _, field := range fields {
+			value, ok := hash[field]
+			if !ok {
+				continue
+			}
+			entry.WriteString(bulkString(field))
+			entry.WriteString(bulkString(value))
+			count += 2
+		}
+		res.WriteString(fmt.Sprintf("*%d\r\n%s", count, entry.String()))
+	}
+
+	return []byte(res.String()), nil
+}
+
+func handleFTAggregate(ctx context.Context, cmd []string, server types.EchoVault, conn *net.Conn) ([]byte, error) {
+	if _, err := ftAggregateKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	registry, err := getRegistry(server)
+	if err != nil {
+		return nil, err
+	}
+	index, err := registry.Get(cmd[1])
+	if err != nil {
+		return nil, err
+	}
+	params, err := getAggregateParams(cmd[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := index.Query(cmd[2])
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeDocuments(server, conn, keys, nil); err != nil {
+		return nil, err
+	}
+	slices.Sort(keys)
+
+	// Load the rows. Indexed fields are read from the index, other fields are loaded from the hash.
+	type row struct {
+		fields []string
+		values map[string]string
+	}
+	loadFields := append(slices.Clone(params.load), params.groupBy...)
+	for _, r := range params.reduce {
+		if r.field != "" {
+			loadFields = append(loadFields, r.field)
+		}
+	}
+	rows := make([]row, 0, len(keys))
+	for _, key := range keys {
+		r := row{values: make(map[string]string)}
+		var hash map[string]string
+		for _, field := range loadFields {
+			if _, ok := r.values[field]; ok {
+				continue
+			}
+			if v, ok := index.Value(key, field); ok {
+				r.values[field] = v
+				r.fields = append(r.fields, field)
+				continue
+			}
+			if hash == nil {
+				hash, _ = getHash(ctx, server, key)
+			}
+			if v, ok := hash[field]; ok {
+				r.values[field] = v
+				r.fields = append(r.fields, field)
+			}
+		}
+		rows = append(rows, r)
+	}
+
+	if params.groupBy != nil {
+		groups := make(map[string][]row)
+		var order []string
+		for _, r := range rows {
+			groupKey := make([]string, len(params.groupBy))
+			for i, field := range params.groupBy {
+				groupKey[i] = r.values[field]
+			}
+			k := strings.Join(groupKey, "\x00")
+			if _, ok := groups[k]; !ok {
+				order = append(order, k)
+			}
+			groups[k] = append(groups[k], r)
+		}
+		grouped := make([]row, 0, len(groups))
+		for _, k := range order {
+			members := groups[k]
+			r := row{values: make(map[string]string)}
+			for _, field := range params.groupBy {
+				r.fields = append(r.fields, field)
+				r.values[field] = members[0].values[field]
+			}
+			for _, reducer := range params.reduce {
+				r.fields = append(r.fields, reducer.as)
+				r.values[reducer.as] = reduce(reducer, func(yield func(string, bool)) {
+					for _, m := range members {
+						v, ok := m.values[reducer.field]
+						yield(v, ok)
+					}
+				})
+			}
+			grouped = append(grouped, r)
+		}
+		rows = grouped
+	}
+
+	if len(params.sortBy) > 0 {
+		slices.SortStableFunc(rows, func(a, b row) int {
+			for _, sp := range params.sortBy {
+				if c := compareValues(a.values[sp.field], b.values[sp.field]); c != 0 {
+					if sp.descending {
+						return -c
+					}
+					return c
+				}
+			}
+			return 0
+		})
+	}
+
+	total := len(rows)
+	start := min(params.offset, len(rows))
+	end := min(start+params.limit, len(rows))
+	rows = rows[start:end]
+
+	var res strings.Builder
+	res.WriteString(fmt.Sprintf("*%d\r\n:%d\r\n", len(rows)+1, total))
+	for _, r := range rows {
+		res.WriteString(fmt.Sprintf("*%d\r\n", len(r.fields)*2))
+		for _, field := range r.fields {
+			res.WriteString(bulkString(field))
+			res.WriteString(bulkString(r.values[field]))
+		}
+	}
+	return []byte(res.String()), nil
+}
+
+// reduce applies the reducer to the values produced by the iterator.
+func reduce(r reducer, values func(yield func(string, bool))) string {
+	count := 0
+	sum := 0.0
+	minValue, maxValue := math.Inf(1), math.Inf(-1)
+	distinct := make(map[string]struct{})
+	values(func(v string, ok bool) {
+		if r.function == "COUNT" {
+			count++
+			return
+		}
+		if !ok {
+			return
+		}
+		distinct[v] = struct{}{}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return
+		}
+		count++
+		sum += f
+		minValue = math.Min(minValue, f)
+		maxValue = math.Max(maxValue, f)
+	})
+	switch r.function {
+	case "COUNT":
+		return strconv.Itoa(count)
+	case "COUNT_DISTINCT":
+		return strconv.Itoa(len(distinct))
+	case "SUM":
+		return formatFloat(sum)
+	case "AVG":
+		if count == 0 {
+			return "0"
+		}
+		return formatFloat(sum / float64(count))
+	case "MIN":
+		if count == 0 {
+			return "inf"
+		}
+		return formatFloat(minValue)
+	case "MAX":
+		if count == 0 {
+			return "-inf"
+		}
+		return formatFloat(maxValue)
+	}
+	return ""
+}
+
+func handleFTInfo(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if _, err := ftInfoKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	registry, err := getRegistry(server)
+	if err != nil {
+		return nil, err
+	}
+	index, err := registry.Get(cmd[1])
+	if err != nil {
+		return nil, err
+	}
+	schema := index.Schema()
+
+	var res strings.Builder
+	res.WriteString("*8\r\n")
+	res.WriteString(bulkString("index_name"))
+	res.WriteString(bulkString(schema.Name))
+	res.WriteString(bulkString("prefixes"))
+	res.WriteString(fmt.Sprintf("*%d\r\n", len(schema.Prefixes)))
+	for _, prefix := range schema.Prefixes {
+		res.WriteString(bulkString(prefix))
+	}
+	res.WriteString(bulkString("attributes"))
+	res.WriteString(fmt.Sprintf("*%d\r\n", len(schema.Fields)))
+	for _, field := range schema.Fields {
+		attributes := []string{"identifier", field.Name, "type", field.Type}
+		if field.Type == internal_search.TagField {
+			attributes = append(attributes, "SEPARATOR", field.Separator)
+		}
+		if field.Type == internal_search.VectorField {
+			attributes = append(attributes,
+				"algorithm", field.Vector.Algorithm,
+				"dim", strconv.Itoa(field.Vector.Dim),
+				"distance_metric", field.Vector.Metric,
+			)
+			if field.Vector.Algorithm == internal_search.HNSWAlgorithm {
+				attributes = append(attributes,
+					"M", strconv.Itoa(field.Vector.M),
+					"ef_construction", strconv.Itoa(field.Vector.EFConstruction),
+					"ef_runtime", strconv.Itoa(field.Vector.EFRuntime),
+				)
+			}
+		}
+		if field.Sortable {
+			attributes = append(attributes, "SORTABLE")
+		}
+		res.WriteString(fmt.Sprintf("*%d\r\n", len(attributes)))
+		for _, attribute := range attributes {
+			res.WriteString(bulkString(attribute))
+		}
+	}
+	res.WriteString(bulkString("num_docs"))
+	res.WriteString(fmt.Sprintf(":%d\r\n", index.Len()))
+
+	return []byte(res.String()), nil
+}
+
+func handleFTDropIndex(ctx context.Context, cmd []string, server types.EchoVault, conn *net.Conn) ([]byte, error) {
+	if _, err := ftDropIndexKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	deleteDocs := false
+	if len(cmd) == 3 {
+		if !strings.EqualFold(cmd[2], "dd") {
+			return nil, fmt.Errorf("unknown option %s", strings.ToUpper(cmd[2]))
+		}
+		deleteDocs = true
+	}
+	registry, err := getRegistry(server)
+	if err != nil {
+		return nil, err
+	}
+	if deleteDocs {
+		// The index is only dropped if the user may delete every document in it.
+		index, err := registry.Get(cmd[1])
+		if err != nil {
+			return nil, err
+		}
+		keys, err := index.Query("*")
+		if err != nil {
+			return nil, err
+		}
+		if err = authorizeDocuments(server, conn, nil, keys); err != nil {
+			return nil, err
+		}
+	}
+	keys, err := registry.Drop(cmd[1])
+	if err != nil {
+		return nil, err
+	}
+	if deleteDocs {
+		for _, key := range keys {
+			if !server.KeyExists(ctx, key) {
+				continue
+			}
+			// A document may have been added to the index after the check.
+			if err = authorizeDocuments(server, conn, nil, []string{key}); err != nil {
+				log.Printf("ft.dropindex: %+v\n", err)
+				continue
+			}
+			if err = server.DeleteKey(ctx, key); err != nil {
+				log.Printf("ft.dropindex: %+v\n", err)
+			}
+		}
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleFTList(_ context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if _, err := ftListKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	registry, err := getRegistry(server)
+	if err != nil {
+		return nil, err
+	}
+	names := registry.List()
+	res := fmt.Sprintf("*%d\r\n", len(names))
+	for _, name := range names {
+		res += bulkString(name)
+	}
+	return []byte(res), nil
+}
+
+func paginate(keys []string, offset int, limit int) []string {
+	start := min(offset, len(keys))
+	end := min(start+limit, len(keys))
+	return keys[start:end]
+}
+
+func Commands() []types.Command {
+	return []types.Command{
+		{
+			Command:    "ft.create",
+			Module:     constants.SearchModule,
+			Categories: []string{constants.SearchCategory, constants.WriteCategory, constants.SlowCategory},
+			Description: `(FT.CREATE index [ON HASH] [PREFIX count prefix [prefix ...]] SCHEMA field TEXT|TAG|NUMERIC [SORTABLE] [SEPARATOR sep]
+| field VECTOR FLAT|HNSW nargs TYPE FLOAT32 DIM dim DISTANCE_METRIC COSINE|L2|IP [M m] [EF_CONSTRUCTION n] [EF_RUNTIME n] ...)
+Create a secondary index over the hashes whose keys match the given prefixes.`,
+			Sync:              true,
+			Exclusive:         true, // The index is backfilled from the keyspace, which must not change meanwhile.
+			KeyExtractionFunc: ftCreateKeyFunc,
+			HandlerFunc:       handleFTCreate,
+		},
+		{
+			Command:    "ft.search",
+			Module:     constants.SearchModule,
+			Categories: []string{constants.SearchCategory, constants.ReadCategory, constants.SlowCategory},
+			Description: `(FT.SEARCH index query [NOCONTENT] [FILTER field min max] [RETURN count field [field ...]]
+[SORTBY field [ASC|DESC]] [LIMIT offset num] [PARAMS nargs name value [name value ...]] [DIALECT version])
+Search the index and return the matching documents. A query of the form "filter=>[KNN k @field $param]" returns
+the k documents nearest to the vector passed in PARAMS, among the documents matching the filter.
+The user must be allowed to read every matching document.`,
+			Sync:              false,
+			KeyExtractionFunc: ftSearchKeyFunc,
+			HandlerFunc:       handleFTSearch,
+		},
+		{
+			Command:    "ft.aggregate",
+			Module:     constants.SearchModule,
+			Categories: []string{constants.SearchCategory, constants.ReadCategory, constants.SlowCategory},
+			Description: `(FT.AGGREGATE index query [LOAD count field [field ...]] [GROUPBY count field [field ...]
+[REDUCE COUNT|SUM|AVG|MIN|MAX|COUNT_DISTINCT nargs [arg] [AS name] ...]] [SORTBY nargs field [ASC|DESC] ...]
+[LIMIT offset num]) Run an aggregation over the documents matching the query.
+The user must be allowed to read every matching document.`,
+			Sync:              false,
+			KeyExtractionFunc: ftAggregateKeyFunc,
+			HandlerFunc:       handleFTAggregate,
+		},
+		{
+			Command:           "ft.info",
+			Module:            constants.SearchModule,
+			Categories:        []string{constants.SearchCategory, constants.ReadCategory, constants.SlowCategory},
+			Description:       `(FT.INFO index) Returns information about the index.`,
+			Sync:              false,
+			KeyExtractionFunc: ftInfoKeyFunc,
+			HandlerFunc:       handleFTInfo,
+		},
+		{
+			Command: "ft.dropindex",
+			Module:  constants.SearchModule,
+			Categories: []string{
+				constants.SearchCategory, constants.WriteCategory, constants.SlowCategory, constants.DangerousCategory,
+			},
+			Description: `(FT.DROPINDEX index [DD]) Delete the index.
+When DD is provided, the documents held by the index are also deleted. On a standalone node, the user must be
+allowed to write every document. On a cluster node, the command is applied through raft without the connection,
+so the documents are not checked.`,
+			Sync:              true,
+			KeyExtractionFunc: ftDropIndexKeyFunc,
+			HandlerFunc:       handleFTDropIndex,
+		},
+		{
+			Command:           "ft._list",
+			Module:            constants.SearchModule,
+			Categories:        []string{constants.SearchCategory, constants.ReadCategory, constants.SlowCategory},
+			Description:       `(FT._LIST) Returns the names of all the indexes.`,
+			Sync:              false,
+			KeyExtractionFunc: ftListKeyFunc,
+			HandlerFunc:       handleFTList,
+		},
+	}
+}
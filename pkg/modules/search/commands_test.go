@@ -0,0 +1,527 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal/config"
+	internal_search "github.com/echovault/echovault/internal/search"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/echovault"
+	"github.com/tidwall/resp"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var mockServer *echovault.EchoVault
+
+func init() {
+	mockServer, _ = echovault.NewEchoVault(
+		echovault.WithCommands(Commands()),
+		echovault.WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+}
+
+func presetHash(ctx context.Context, t *testing.T, key string, hash map[string]interface{}) {
+	if _, err := mockServer.CreateKeyAndLock(ctx, key); err != nil {
+		t.Error(err)
+	}
+	if err := mockServer.SetValue(ctx, key, hash); err != nil {
+		t.Error(err)
+	}
+	mockServer.KeyUnlock(ctx, key)
+}
+
+func Test_HandleFTCreate(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       []string
+		expectedError error
+	}{
+		{
+			name: "1. Create index with prefix and all field types",
+			command: []string{"FT.CREATE", "CreateIdx1", "ON", "HASH", "PREFIX", "1", "create1:",
+				"SCHEMA", "title", "TEXT", "tags", "TAG", "SEPARATOR", ";", "price", "NUMERIC", "SORTABLE"},
+			expectedError: nil,
+		},
+		{
+			name:          "2. Return error when the index already exists",
+			command:       []string{"FT.CREATE", "CreateIdx1", "SCHEMA", "title", "TEXT"},
+			expectedError: errors.New("index CreateIdx1 already exists"),
+		},
+		{
+			name:          "3. Return error on unsupported field type",
+			command:       []string{"FT.CREATE", "CreateIdx3", "SCHEMA", "title", "BLOB"},
+			expectedError: errors.New("unsupported field type BLOB"),
+		},
+		{
+			name:          "4. Return error when SCHEMA is missing",
+			command:       []string{"FT.CREATE", "CreateIdx4", "PREFIX", "1", "create4:"},
+			expectedError: errors.New("SCHEMA is required"),
+		},
+		{
+			name:          "5. Command too short",
+			command:       []string{"FT.CREATE", "CreateIdx5", "SCHEMA"},
+			expectedError: errors.New(constants.WrongArgsResponse),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := handleFTCreate(context.Background(), test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if string(res) != constants.OkResponse {
+				t.Errorf("expected OK response, got %s", string(res))
+			}
+		})
+	}
+}
+
+func Test_HandleFTSearch(t *testing.T) {
+	ctx := context.Background()
+
+	// Documents that exist before the index is created should be backfilled.
+	presetHash(ctx, t, "product:1", map[string]interface{}{
+		"title": "Red running shoes", "tags": "shoes,red", "price": 80, "brand": "acme",
+	})
+	if _, err := handleFTCreate(ctx, []string{"FT.CREATE", "SearchIdx", "PREFIX", "1", "product:",
+		"SCHEMA", "title", "TEXT", "tags", "TAG", "price", "NUMERIC", "SORTABLE"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	presetHash(ctx, t, "product:2", map[string]interface{}{
+		"title": "Blue running jacket", "tags": "jacket,blue", "price": 120.5, "brand": "acme",
+	})
+	presetHash(ctx, t, "product:3", map[string]interface{}{
+		"title": "Red rain jacket", "tags": "jacket,red", "price": 60, "brand": "other",
+	})
+	// Keys that don't match the prefix are not indexed.
+	presetHash(ctx, t, "other:1", map[string]interface{}{"title": "Red running shoes"})
+
+	tests := []struct {
+		name          string
+		command       []string
+		expectedTotal int
+		expectedKeys  []string
+		expectedDocs  map[string][]string
+		expectedError error
+	}{
+		{
+			name:          "1. Match all documents",
+			command:       []string{"FT.SEARCH", "SearchIdx", "*", "NOCONTENT", "SORTBY", "price"},
+			expectedTotal: 3,
+			expectedKeys:  []string{"product:3", "product:1", "product:2"},
+		},
+		{
+			name:          "2. Full text term match across text fields",
+			command:       []string{"FT.SEARCH", "SearchIdx", "running", "NOCONTENT"},
+			expectedTotal: 2,
+			expectedKeys:  []string{"product:1", "product:2"},
+		},
+		{
+			name:          "3. Tag filter with numeric range and descending sort",
+			command:       []string{"FT.SEARCH", "SearchIdx", "@tags:{red} @price:[50 100]", "NOCONTENT", "SORTBY", "price", "DESC"},
+			expectedTotal: 2,
+			expectedKeys:  []string{"product:1", "product:3"},
+		},
+		{
+			name:          "4. Negation and prefix match",
+			command:       []string{"FT.SEARCH", "SearchIdx", "jack* -@tags:{blue}", "NOCONTENT"},
+			expectedTotal: 1,
+			expectedKeys:  []string{"product:3"},
+		},
+		{
+			name:          "5. RETURN limits the fields in the response",
+			command:       []string{"FT.SEARCH", "SearchIdx", "@title:(blue jacket)", "RETURN", "2", "title", "brand"},
+			expectedTotal: 1,
+			expectedKeys:  []string{"product:2"},
+			expectedDocs: map[string][]string{
+				"product:2": {"title", "Blue running jacket", "brand", "acme"},
+			},
+		},
+		{
+			name:          "6. LIMIT paginates the results but keeps the total",
+			command:       []string{"FT.SEARCH", "SearchIdx", "*", "NOCONTENT", "SORTBY", "price", "LIMIT", "1", "1"},
+			expectedTotal: 3,
+			expectedKeys:  []string{"product:1"},
+		},
+		{
+			name:          "7. FILTER adds a numeric constraint",
+			command:       []string{"FT.SEARCH", "SearchIdx", "jacket", "NOCONTENT", "FILTER", "price", "(60", "+inf"},
+			expectedTotal: 1,
+			expectedKeys:  []string{"product:2"},
+		},
+		{
+			name:          "8. Return error on unknown index",
+			command:       []string{"FT.SEARCH", "NonExistentIdx", "*"},
+			expectedError: errors.New("unknown index NonExistentIdx"),
+		},
+		{
+			name:          "9. Return error on unknown field",
+			command:       []string{"FT.SEARCH", "SearchIdx", "@colour:{red}"},
+			expectedError: errors.New("unknown field colour"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := handleFTSearch(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rd := resp.NewReader(bytes.NewReader(res))
+			rv, _, err := rd.ReadValue()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			arr := rv.Array()
+			if arr[0].Integer() != test.expectedTotal {
+				t.Errorf("expected total %d, got %d", test.expectedTotal, arr[0].Integer())
+			}
+			var keys []string
+			step := 1
+			if test.expectedDocs != nil {
+				step = 2
+			}
+			for i := 1; i < len(arr); i += step {
+				keys = append(keys, arr[i].String())
+				if test.expectedDocs == nil {
+					continue
+				}
+				var doc []string
+				for _, v := range arr[i+1].Array() {
+					doc = append(doc, v.String())
+				}
+				if !reflect.DeepEqual(doc, test.expectedDocs[arr[i].String()]) {
+					t.Errorf("expected document %v, got %v", test.expectedDocs[arr[i].String()], doc)
+				}
+			}
+			if !reflect.DeepEqual(keys, test.expectedKeys) {
+				t.Errorf("expected keys %v, got %v", test.expectedKeys, keys)
+			}
+		})
+	}
+
+	// Updating a hash re-indexes it and deleting a key removes it from the index.
+	presetHash(ctx, t, "product:1", map[string]interface{}{"title": "Green shoes", "tags": "shoes", "price": 80})
+	if err := mockServer.DeleteKey(ctx, "product:3"); err != nil {
+		t.Error(err)
+	}
+	res, err := handleFTSearch(ctx, []string{"FT.SEARCH", "SearchIdx", "@tags:{red}", "NOCONTENT"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	rd := resp.NewReader(bytes.NewReader(res))
+	rv, _, _ := rd.ReadValue()
+	if rv.Array()[0].Integer() != 0 {
+		t.Errorf("expected no documents tagged red after update and delete, got %d", rv.Array()[0].Integer())
+	}
+}
+
+func Test_HandleFTSearchKNN(t *testing.T) {
+	ctx := context.Background()
+
+	for _, algorithm := range []string{"FLAT", "HNSW"} {
+		idx := "KNNIdx" + algorithm
+		prefix := "knn" + strings.ToLower(algorithm) + ":"
+		if _, err := handleFTCreate(ctx, []string{"FT.CREATE", idx, "PREFIX", "1", prefix, "SCHEMA",
+			"genre", "TAG", "year", "NUMERIC",
+			"embedding", "VECTOR", algorithm, "6", "TYPE", "FLOAT32", "DIM", "2", "DISTANCE_METRIC", "L2",
+		}, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+		presetHash(ctx, t, prefix+"1", map[string]interface{}{
+			"genre": "drama", "year": 1990, "embedding": internal_search.EncodeVector([]float32{0, 0}),
+		})
+		presetHash(ctx, t, prefix+"2", map[string]interface{}{
+			"genre": "comedy", "year": 2000, "embedding": internal_search.EncodeVector([]float32{1, 0}),
+		})
+		presetHash(ctx, t, prefix+"3", map[string]interface{}{
+			"genre": "drama", "year": 2010, "embedding": "3,0",
+		})
+		// Vectors of the wrong dimension are not indexed.
+		presetHash(ctx, t, prefix+"4", map[string]interface{}{
+			"genre": "drama", "year": 2020, "embedding": "1,2,3",
+		})
+
+		tests := []struct {
+			name           string
+			command        []string
+			expectedKeys   []string
+			expectedScores []string
+			expectedError  error
+		}{
+			{
+				name: "1. KNN over the whole index",
+				command: []string{"FT.SEARCH", idx, "*=>[KNN 2 @embedding $vec]",
+					"PARAMS", "2", "vec", internal_search.EncodeVector([]float32{0.75, 0}), "RETURN", "1", "__embedding_score"},
+				expectedKeys:   []string{prefix + "2", prefix + "1"},
+				expectedScores: []string{"0.0625", "0.5625"},
+			},
+			{
+				name: "2. KNN with tag and numeric pre-filters",
+				command: []string{"FT.SEARCH", idx, "(@genre:{drama} @year:[2000 +inf])=>[KNN $k @embedding $vec AS dist]",
+					"PARAMS", "4", "k", "5", "vec", "0.75,0", "RETURN", "1", "dist", "DIALECT", "2"},
+				expectedKeys:   []string{prefix + "3"},
+				expectedScores: []string{"5.0625"},
+			},
+			{
+				name: "3. SORTBY overrides the distance order",
+				command: []string{"FT.SEARCH", idx, "*=>[KNN 3 @embedding $vec]",
+					"PARAMS", "2", "vec", "0,0", "NOCONTENT", "SORTBY", "year", "DESC"},
+				expectedKeys: []string{prefix + "3", prefix + "2", prefix + "1"},
+			},
+			{
+				name:          "4. Return error on missing parameter",
+				command:       []string{"FT.SEARCH", idx, "*=>[KNN 2 @embedding $vec]"},
+				expectedError: errors.New("no such parameter vec"),
+			},
+			{
+				name:          "5. Return error on query vector with the wrong dimension",
+				command:       []string{"FT.SEARCH", idx, "*=>[KNN 2 @embedding $vec]", "PARAMS", "2", "vec", "1,2,3"},
+				expectedError: errors.New("expected vector of dimension 2"),
+			},
+			{
+				name:          "6. Return error on KNN over a non-vector field",
+				command:       []string{"FT.SEARCH", idx, "*=>[KNN 2 @year $vec]", "PARAMS", "2", "vec", "1,2"},
+				expectedError: errors.New("field year is not a VECTOR field"),
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(algorithm+" "+test.name, func(t *testing.T) {
+				res, err := handleFTSearch(ctx, test.command, mockServer, nil)
+				if test.expectedError != nil {
+					if err == nil || err.Error() != test.expectedError.Error() {
+						t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				rd := resp.NewReader(bytes.NewReader(res))
+				rv, _, err := rd.ReadValue()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				arr := rv.Array()
+				if arr[0].Integer() != len(test.expectedKeys) {
+					t.Errorf("expected total %d, got %d", len(test.expectedKeys), arr[0].Integer())
+				}
+				var keys, scores []string
+				step := 1
+				if test.expectedScores != nil {
+					step = 2
+				}
+				for i := 1; i < len(arr); i += step {
+					keys = append(keys, arr[i].String())
+					if test.expectedScores != nil {
+						scores = append(scores, arr[i+1].Array()[1].String())
+					}
+				}
+				if !reflect.DeepEqual(keys, test.expectedKeys) {
+					t.Errorf("expected keys %v, got %v", test.expectedKeys, keys)
+				}
+				if !reflect.DeepEqual(scores, test.expectedScores) {
+					t.Errorf("expected scores %v, got %v", test.expectedScores, scores)
+				}
+			})
+		}
+	}
+
+	// A list that is dim*4 bytes long is parsed as a list, not as a blob.
+	if _, err := handleFTCreate(ctx, []string{"FT.CREATE", "KNNIdxDim1", "PREFIX", "1", "knndim1:", "SCHEMA",
+		"embedding", "VECTOR", "FLAT", "6", "TYPE", "FLOAT32", "DIM", "1", "DISTANCE_METRIC", "L2",
+	}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	presetHash(ctx, t, "knndim1:1", map[string]interface{}{"embedding": "0.50"})
+	presetHash(ctx, t, "knndim1:2", map[string]interface{}{"embedding": internal_search.EncodeVector([]float32{2})})
+	res, err := handleFTSearch(ctx, []string{"FT.SEARCH", "KNNIdxDim1", "*=>[KNN 2 @embedding $vec]",
+		"PARAMS", "2", "vec", "0.25", "RETURN", "1", "__embedding_score"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "*5\r\n:2\r\n" +
+		"$9\r\nknndim1:1\r\n*2\r\n$17\r\n__embedding_score\r\n$6\r\n0.0625\r\n" +
+		"$9\r\nknndim1:2\r\n*2\r\n$17\r\n__embedding_score\r\n$6\r\n3.0625\r\n"
+	if string(res) != expected {
+		t.Errorf("expected response %q, got %q", expected, string(res))
+	}
+
+	// HNSW should find the exact nearest neighbour of a vector held in a larger index.
+	if _, err := handleFTCreate(ctx, []string{"FT.CREATE", "KNNIdxLarge", "PREFIX", "1", "knnlarge:", "SCHEMA",
+		"embedding", "VECTOR", "HNSW", "10", "TYPE", "FLOAT32", "DIM", "8", "DISTANCE_METRIC", "COSINE",
+		"M", "8", "EF_CONSTRUCTION", "100"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	r := rand.New(rand.NewSource(42))
+	vectors := make([][]float32, 500)
+	for i := range vectors {
+		vectors[i] = make([]float32, 8)
+		for j := range vectors[i] {
+			vectors[i][j] = r.Float32()*2 - 1
+		}
+		presetHash(ctx, t, fmt.Sprintf("knnlarge:%d", i), map[string]interface{}{
+			"embedding": internal_search.EncodeVector(vectors[i]),
+		})
+	}
+	for _, i := range []int{0, 123, 499} {
+		res, err := handleFTSearch(ctx, []string{"FT.SEARCH", "KNNIdxLarge", "*=>[KNN 1 @embedding $vec EF_RUNTIME 50]",
+			"PARAMS", "2", "vec", internal_search.EncodeVector(vectors[i]), "NOCONTENT"}, mockServer, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rv, _, _ := resp.NewReader(bytes.NewReader(res)).ReadValue()
+		if got := rv.Array()[1].String(); got != fmt.Sprintf("knnlarge:%d", i) {
+			t.Errorf("expected nearest neighbour knnlarge:%d, got %s", i, got)
+		}
+	}
+}
+
+func Test_HandleFTAggregate(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := handleFTCreate(ctx, []string{"FT.CREATE", "AggIdx", "PREFIX", "1", "order:",
+		"SCHEMA", "region", "TAG", "amount", "NUMERIC"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	presetHash(ctx, t, "order:1", map[string]interface{}{"region": "eu", "amount": 10})
+	presetHash(ctx, t, "order:2", map[string]interface{}{"region": "eu", "amount": 30})
+	presetHash(ctx, t, "order:3", map[string]interface{}{"region": "us", "amount": 5})
+
+	res, err := handleFTAggregate(ctx, []string{"FT.AGGREGATE", "AggIdx", "*",
+		"GROUPBY", "1", "@region",
+		"REDUCE", "COUNT", "0", "AS", "orders",
+		"REDUCE", "SUM", "1", "@amount", "AS", "total",
+		"REDUCE", "AVG", "1", "@amount", "AS", "average",
+		"SORTBY", "2", "@total", "DESC"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rd := resp.NewReader(bytes.NewReader(res))
+	rv, _, err := rd.ReadValue()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var rows [][]string
+	for _, r := range rv.Array()[1:] {
+		var row []string
+		for _, v := range r.Array() {
+			row = append(row, v.String())
+		}
+		rows = append(rows, row)
+	}
+	expected := [][]string{
+		{"region", "eu", "orders", "2", "total", "40", "average", "20"},
+		{"region", "us", "orders", "1", "total", "5", "average", "5"},
+	}
+	if rv.Array()[0].Integer() != 2 {
+		t.Errorf("expected 2 groups, got %d", rv.Array()[0].Integer())
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected rows %v, got %v", expected, rows)
+	}
+}
+
+func Test_HandleFTInfoAndDropIndex(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := handleFTCreate(ctx, []string{"FT.CREATE", "DropIdx", "PREFIX", "1", "drop:",
+		"SCHEMA", "name", "TEXT"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	presetHash(ctx, t, "drop:1", map[string]interface{}{"name": "one"})
+	presetHash(ctx, t, "drop:2", map[string]interface{}{"name": "two"})
+
+	res, err := handleFTInfo(ctx, []string{"FT.INFO", "DropIdx"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rd := resp.NewReader(bytes.NewReader(res))
+	rv, _, _ := rd.ReadValue()
+	info := rv.Array()
+	if info[1].String() != "DropIdx" {
+		t.Errorf("expected index name DropIdx, got %s", info[1].String())
+	}
+	if info[7].Integer() != 2 {
+		t.Errorf("expected 2 documents, got %d", info[7].Integer())
+	}
+
+	res, err = handleFTList(ctx, []string{"FT._LIST"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	rd = resp.NewReader(bytes.NewReader(res))
+	rv, _, _ = rd.ReadValue()
+	found := false
+	for _, name := range rv.Array() {
+		if name.String() == "DropIdx" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected DropIdx to be listed by FT._LIST")
+	}
+
+	if _, err = handleFTDropIndex(ctx, []string{"FT.DROPINDEX", "DropIdx", "DD"}, mockServer, nil); err != nil {
+		t.Error(err)
+	}
+	if mockServer.KeyExists(ctx, "drop:1") || mockServer.KeyExists(ctx, "drop:2") {
+		t.Error("expected documents to be deleted with DD option")
+	}
+	if _, err = handleFTInfo(ctx, []string{"FT.INFO", "DropIdx"}, mockServer, nil); err == nil {
+		t.Error("expected error after index was dropped")
+	}
+}
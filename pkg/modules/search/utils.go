@@ -0,0 +1,262 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type sortParams struct {
+	field      string
+	descending bool
+}
+
+type searchParams struct {
+	noContent bool
+	returns   []string          // Fields to return. All the hash fields are returned when empty.
+	filters   []string          // Extra numeric range clauses appended to the query with AND semantics.
+	params    map[string]string // Values referenced as $name in the query.
+	sortBy    *sortParams
+	offset    int
+	limit     int
+}
+
+func getSearchParams(cmd []string) (searchParams, error) {
+	params := searchParams{offset: 0, limit: 10, params: make(map[string]string)}
+	for i := 0; i < len(cmd); {
+		switch strings.ToLower(cmd[i]) {
+		case "nocontent":
+			params.noContent = true
+			i += 1
+		case "return":
+			if i+1 >= len(cmd) {
+				return searchParams{}, errors.New("field count required after RETURN")
+			}
+			count, err := strconv.Atoi(cmd[i+1])
+			if err != nil || count < 0 || i+2+count > len(cmd) {
+				return searchParams{}, errors.New("invalid RETURN count")
+			}
+			params.returns = cmd[i+2 : i+2+count]
+			i += 2 + count
+		case "filter":
+			if i+3 >= len(cmd) {
+				return searchParams{}, errors.New("FILTER requires a field, min and max")
+			}
+			params.filters = append(params.filters,
+				fmt.Sprintf("@%s:[%s %s]", strings.TrimPrefix(cmd[i+1], "@"), cmd[i+2], cmd[i+3]))
+			i += 4
+		case "sortby":
+			if i+1 >= len(cmd) {
+				return searchParams{}, errors.New("field required after SORTBY")
+			}
+			params.sortBy = &sortParams{field: strings.TrimPrefix(cmd[i+1], "@")}
+			i += 2
+			if i < len(cmd) && strings.EqualFold(cmd[i], "desc") {
+				params.sortBy.descending = true
+				i += 1
+			} else if i < len(cmd) && strings.EqualFold(cmd[i], "asc") {
+				i += 1
+			}
+		case "limit":
+			offset, limit, err := parseLimit(cmd[i+1:])
+			if err != nil {
+				return searchParams{}, err
+			}
+			params.offset, params.limit = offset, limit
+			i += 3
+		case "params":
+			if i+1 >= len(cmd) {
+				return searchParams{}, errors.New("argument count required after PARAMS")
+			}
+			count, err := strconv.Atoi(cmd[i+1])
+			if err != nil || count < 0 || count%2 != 0 || i+2+count > len(cmd) {
+				return searchParams{}, errors.New("invalid PARAMS count")
+			}
+			for j := i + 2; j < i+2+count; j += 2 {
+				params.params[cmd[j]] = cmd[j+1]
+			}
+			i += 2 + count
+		case "dialect":
+			// The dialect does not change how queries are parsed, it is accepted for compatibility.
+			if i+1 >= len(cmd) {
+				return searchParams{}, errors.New("version required after DIALECT")
+			}
+			i += 2
+		default:
+			return searchParams{}, fmt.Errorf("unknown option %s", strings.ToUpper(cmd[i]))
+		}
+	}
+	return params, nil
+}
+
+type reducer struct {
+	function string // COUNT, SUM, AVG, MIN, MAX or COUNT_DISTINCT
+	field    string
+	as       string
+}
+
+type aggregateParams struct {
+	load    []string
+	groupBy []string
+	reduce  []reducer
+	sortBy  []sortParams
+	offset  int
+	limit   int
+}
+
+func getAggregateParams(cmd []string) (aggregateParams, error) {
+	params := aggregateParams{offset: 0, limit: 10}
+	for i := 0; i < len(cmd); {
+		switch strings.ToLower(cmd[i]) {
+		case "load":
+			fields, n, err := parseFieldList(cmd[i+1:], "LOAD")
+			if err != nil {
+				return aggregateParams{}, err
+			}
+			params.load = fields
+			i += 1 + n
+		case "groupby":
+			fields, n, err := parseFieldList(cmd[i+1:], "GROUPBY")
+			if err != nil {
+				return aggregateParams{}, err
+			}
+			params.groupBy = fields
+			i += 1 + n
+		case "reduce":
+			if params.groupBy == nil {
+				return aggregateParams{}, errors.New("REDUCE must follow GROUPBY")
+			}
+			if i+2 >= len(cmd) {
+				return aggregateParams{}, errors.New("REDUCE requires a function and argument count")
+			}
+			r := reducer{function: strings.ToUpper(cmd[i+1])}
+			nargs, err := strconv.Atoi(cmd[i+2])
+			if err != nil || nargs < 0 || i+3+nargs > len(cmd) {
+				return aggregateParams{}, errors.New("invalid REDUCE argument count")
+			}
+			switch r.function {
+			case "COUNT":
+				if nargs != 0 {
+					return aggregateParams{}, errors.New("COUNT takes no arguments")
+				}
+				r.as = "count"
+			case "SUM", "AVG", "MIN", "MAX", "COUNT_DISTINCT":
+				if nargs != 1 {
+					return aggregateParams{}, fmt.Errorf("%s takes exactly 1 argument", r.function)
+				}
+				r.field = strings.TrimPrefix(cmd[i+3], "@")
+				r.as = fmt.Sprintf("__generated_alias%s%s", strings.ToLower(r.function), r.field)
+			default:
+				return aggregateParams{}, fmt.Errorf("unsupported reducer %s", r.function)
+			}
+			i += 3 + nargs
+			if i+1 < len(cmd) && strings.EqualFold(cmd[i], "as") {
+				r.as = cmd[i+1]
+				i += 2
+			}
+			params.reduce = append(params.reduce, r)
+		case "sortby":
+			if i+1 >= len(cmd) {
+				return aggregateParams{}, errors.New("argument count required after SORTBY")
+			}
+			nargs, err := strconv.Atoi(cmd[i+1])
+			if err != nil || nargs < 0 || i+2+nargs > len(cmd) {
+				return aggregateParams{}, errors.New("invalid SORTBY argument count")
+			}
+			args := cmd[i+2 : i+2+nargs]
+			for j := 0; j < len(args); j++ {
+				sp := sortParams{field: strings.TrimPrefix(args[j], "@")}
+				if j+1 < len(args) && strings.EqualFold(args[j+1], "desc") {
+					sp.descending = true
+					j += 1
+				} else if j+1 < len(args) && strings.EqualFold(args[j+1], "asc") {
+					j += 1
+				}
+				params.sortBy = append(params.sortBy, sp)
+			}
+			i += 2 + nargs
+		case "limit":
+			offset, limit, err := parseLimit(cmd[i+1:])
+			if err != nil {
+				return aggregateParams{}, err
+			}
+			params.offset, params.limit = offset, limit
+			i += 3
+		default:
+			return aggregateParams{}, fmt.Errorf("unknown option %s", strings.ToUpper(cmd[i]))
+		}
+	}
+	return params, nil
+}
+
+func parseLimit(args []string) (int, int, error) {
+	if len(args) < 2 {
+		return 0, 0, errors.New("LIMIT requires an offset and a count")
+	}
+	offset, err := strconv.Atoi(args[0])
+	if err != nil || offset < 0 {
+		return 0, 0, errors.New("LIMIT offset must be a positive integer")
+	}
+	limit, err := strconv.Atoi(args[1])
+	if err != nil || limit < 0 {
+		return 0, 0, errors.New("LIMIT count must be a positive integer")
+	}
+	return offset, limit, nil
+}
+
+// parseFieldList parses a "count @field [@field ...]" list and returns the fields (without the "@")
+// and the number of arguments consumed.
+func parseFieldList(args []string, option string) ([]string, int, error) {
+	if len(args) < 1 {
+		return nil, 0, fmt.Errorf("field count required after %s", option)
+	}
+	count, err := strconv.Atoi(args[0])
+	if err != nil || count < 0 || 1+count > len(args) {
+		return nil, 0, fmt.Errorf("invalid %s count", option)
+	}
+	fields := make([]string, count)
+	for i, f := range args[1 : 1+count] {
+		fields[i] = strings.TrimPrefix(f, "@")
+	}
+	return fields, 1 + count, nil
+}
+
+// compareValues compares two field values numerically when both are numbers, and lexicographically otherwise.
+func compareValues(a, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func bulkString(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
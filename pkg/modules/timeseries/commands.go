@@ -0,0 +1,498 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timeseries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	internal_timeseries "github.com/echovault/echovault/internal/timeseries"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"net"
+	"strings"
+	"sync"
+)
+
+// rulesMut serializes compaction rule changes. Rule changes lock two series one after the other,
+// so two concurrent changes on the same pair of keys in opposite directions could otherwise deadlock.
+var rulesMut sync.Mutex
+
+func handleTSCreate(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := tsCreateKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	options, err := parseSeriesOptions(cmd[2:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	if server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s already exists", key)
+	}
+	if _, err = server.CreateKeyAndLock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+	series := internal_timeseries.NewTimeSeries(options.retention, options.labels, options.duplicatePolicy)
+	if err = server.SetValue(ctx, key, series); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+// addSample adds the sample to the series at key and writes the resulting compactions.
+// If options is not nil, the series is created with the options when the key does not exist.
+func addSample(ctx context.Context, server types.EchoVault, key string, timestamp int64, value float64,
+	options *seriesOptions) error {
+	var series *internal_timeseries.TimeSeries
+	var err error
+	onDuplicate := ""
+
+	if options != nil {
+		onDuplicate = options.onDuplicate
+		if _, err = server.CreateKeyAndLock(ctx, key); err != nil {
+			return err
+		}
+		switch value := server.GetValue(ctx, key).(type) {
+		case nil:
+			series = internal_timeseries.NewTimeSeries(options.retention, options.labels, options.duplicatePolicy)
+			if err = server.SetValue(ctx, key, series); err != nil {
+				server.KeyUnlock(ctx, key)
+				return err
+			}
+		case *internal_timeseries.TimeSeries:
+			series = value
+		default:
+			server.KeyUnlock(ctx, key)
+			return fmt.Errorf("value at key %s is not a time series", key)
+		}
+	} else if series, err = lockSeries(ctx, server, key); err != nil {
+		return err
+	}
+	defer server.KeyUnlock(ctx, key)
+
+	compactions, err := series.Add(timestamp, value, onDuplicate)
+	if err != nil {
+		return err
+	}
+	writeCompactions(ctx, server, compactions)
+	return nil
+}
+
+func handleTSAdd(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := tsAddKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	timestamp, err := parseTimestamp(server, cmd[2])
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseValue(cmd[3])
+	if err != nil {
+		return nil, err
+	}
+	options, err := parseSeriesOptions(cmd[4:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = addSample(ctx, server, key, timestamp, value, &options); err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", timestamp)), nil
+}
+
+func handleTSMAdd(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	if _, err := tsMAddKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+
+	res := fmt.Sprintf("*%d\r\n", (len(cmd)-1)/3)
+	for i := 1; i < len(cmd); i += 3 {
+		timestamp, err := parseTimestamp(server, cmd[i+1])
+		if err == nil {
+			var value float64
+			if value, err = parseValue(cmd[i+2]); err == nil {
+				err = addSample(ctx, server, cmd[i], timestamp, value, nil)
+			}
+		}
+		if err != nil {
+			res += fmt.Sprintf("-%s\r\n", err.Error())
+			continue
+		}
+		res += fmt.Sprintf(":%d\r\n", timestamp)
+	}
+	return []byte(res), nil
+}
+
+func handleTSGet(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := tsGetKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	series, err := rLockSeries(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	sample, ok := series.Latest()
+	if !ok {
+		return []byte("*0\r\n"), nil
+	}
+	return []byte(formatSample(sample)), nil
+}
+
+func rangeSamples(series *internal_timeseries.TimeSeries, options rangeOptions, reverse bool) []internal_timeseries.Sample {
+	if !reverse {
+		return series.Range(options.from, options.to, options.aggregation, options.bucketDuration, options.count)
+	}
+	samples := series.Range(options.from, options.to, options.aggregation, options.bucketDuration, 0)
+	for i, j := 0, len(samples)-1; i < j; i, j = i+1, j-1 {
+		samples[i], samples[j] = samples[j], samples[i]
+	}
+	if options.count > 0 && len(samples) > options.count {
+		samples = samples[:options.count]
+	}
+	return samples
+}
+
+func handleRange(reverse bool) types.HandlerFunc {
+	return func(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+		keys, err := tsRangeKeyFunc(cmd)
+		if err != nil {
+			return nil, err
+		}
+		key := keys.ReadKeys[0]
+
+		options, err := parseRangeOptions(cmd[2:], false)
+		if err != nil {
+			return nil, err
+		}
+
+		series, err := rLockSeries(ctx, server, key)
+		if err != nil {
+			return nil, err
+		}
+		defer server.KeyRUnlock(ctx, key)
+
+		return []byte(formatSamples(rangeSamples(series, options, reverse))), nil
+	}
+}
+
+func handleMRange(reverse bool) types.HandlerFunc {
+	return func(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+		if _, err := tsMRangeKeyFunc(cmd); err != nil {
+			return nil, err
+		}
+
+		options, err := parseRangeOptions(cmd[1:], true)
+		if err != nil {
+			return nil, err
+		}
+
+		index, ok := server.GetTimeSeriesIndex().(*internal_timeseries.Index)
+		if !ok {
+			return nil, errors.New("could not load time series index")
+		}
+		keys, err := index.Match(options.filters)
+		if err != nil {
+			return nil, err
+		}
+
+		var entries []string
+		for _, key := range keys {
+			series, err := rLockSeries(ctx, server, key)
+			if err != nil {
+				// The key was deleted or overwritten after it was matched.
+				continue
+			}
+			labels := make(map[string]string)
+			if options.withLabels {
+				labels = series.Labels()
+			}
+			samples := rangeSamples(series, options, reverse)
+			server.KeyRUnlock(ctx, key)
+			entries = append(entries, fmt.Sprintf("*3\r\n$%d\r\n%s\r\n%s%s",
+				len(key), key, formatLabels(labels), formatSamples(samples)))
+		}
+
+		return []byte(fmt.Sprintf("*%d\r\n%s", len(entries), strings.Join(entries, ""))), nil
+	}
+}
+
+func handleTSCreateRule(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := tsCreateRuleKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	source, destination := keys.WriteKeys[0], keys.WriteKeys[1]
+
+	if !strings.EqualFold(cmd[3], "aggregation") {
+		return nil, errors.New("AGGREGATION is required")
+	}
+	aggregation, err := internal_timeseries.ParseAggregation(cmd[4])
+	if err != nil {
+		return nil, err
+	}
+	bucketDuration, err := parseBucketDuration(cmd[5])
+	if err != nil {
+		return nil, err
+	}
+	if source == destination {
+		return nil, errors.New("the source and destination keys must be different")
+	}
+
+	rulesMut.Lock()
+	defer rulesMut.Unlock()
+
+	sourceSeries, err := lockSeries(ctx, server, source)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, source)
+	// Check the source before locking the destination. TS.ADD holds the lock on a source
+	// while it locks its destinations, so a destination must not wait here for its own source.
+	if sourceSeries.SourceKey() != "" {
+		return nil, fmt.Errorf("key %s is already the destination of a compaction rule", source)
+	}
+
+	destinationSeries, err := lockSeries(ctx, server, destination)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, destination)
+	if destinationSeries.SourceKey() != "" {
+		return nil, fmt.Errorf("key %s is already the destination of a compaction rule", destination)
+	}
+	if len(destinationSeries.Rules()) > 0 {
+		return nil, fmt.Errorf("key %s is the source of a compaction rule", destination)
+	}
+
+	if err = sourceSeries.AddRule(destination, aggregation, bucketDuration); err != nil {
+		return nil, err
+	}
+	destinationSeries.SetSourceKey(source)
+	return []byte(constants.OkResponse), nil
+}
+
+func handleTSDeleteRule(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := tsDeleteRuleKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	source, destination := keys.WriteKeys[0], keys.WriteKeys[1]
+
+	rulesMut.Lock()
+	defer rulesMut.Unlock()
+
+	sourceSeries, err := lockSeries(ctx, server, source)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, source)
+	if !sourceSeries.DeleteRule(destination) {
+		return nil, fmt.Errorf("compaction rule from %s to %s does not exist", source, destination)
+	}
+
+	// The destination may have been deleted since the rule was created.
+	if destinationSeries, err := lockSeries(ctx, server, destination); err == nil {
+		if destinationSeries.SourceKey() == source {
+			destinationSeries.SetSourceKey("")
+		}
+		server.KeyUnlock(ctx, destination)
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleTSInfo(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := tsInfoKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	series, err := rLockSeries(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	first, _ := series.First()
+	latest, _ := series.Latest()
+	sourceKey := "$-1\r\n"
+	if series.SourceKey() != "" {
+		sourceKey = fmt.Sprintf("$%d\r\n%s\r\n", len(series.SourceKey()), series.SourceKey())
+	}
+	rules := fmt.Sprintf("*%d\r\n", len(series.Rules()))
+	for _, rule := range series.Rules() {
+		rules += fmt.Sprintf("*3\r\n$%d\r\n%s\r\n:%d\r\n$%d\r\n%s\r\n",
+			len(rule.DestKey), rule.DestKey, rule.BucketDuration, len(rule.Aggregation), rule.Aggregation)
+	}
+
+	info := []struct {
+		name  string
+		value string
+	}{
+		{name: "totalSamples", value: fmt.Sprintf(":%d\r\n", series.Len())},
+		{name: "memoryUsage", value: fmt.Sprintf(":%d\r\n", series.Size())},
+		{name: "firstTimestamp", value: fmt.Sprintf(":%d\r\n", first.Timestamp)},
+		{name: "lastTimestamp", value: fmt.Sprintf(":%d\r\n", latest.Timestamp)},
+		{name: "retentionTime", value: fmt.Sprintf(":%d\r\n", series.Retention())},
+		{name: "duplicatePolicy", value: fmt.Sprintf("$%d\r\n%s\r\n", len(series.DuplicatePolicy()), series.DuplicatePolicy())},
+		{name: "labels", value: formatLabels(series.Labels())},
+		{name: "sourceKey", value: sourceKey},
+		{name: "rules", value: rules},
+	}
+	res := fmt.Sprintf("*%d\r\n", len(info)*2)
+	for _, entry := range info {
+		res += fmt.Sprintf("$%d\r\n%s\r\n%s", len(entry.name), entry.name, entry.value)
+	}
+	return []byte(res), nil
+}
+
+func Commands() []types.Command {
+	return []types.Command{
+		{
+			Command:    "ts.create",
+			Module:     constants.TimeSeriesModule,
+			Categories: []string{constants.TimeSeriesCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(TS.CREATE key [RETENTION retentionPeriod] [DUPLICATE_POLICY policy] [LABELS label value ...])
+Creates a time series. RETENTION is the maximum age of samples in milliseconds relative to the latest sample, 0 keeps
+all samples. DUPLICATE_POLICY is one of BLOCK, FIRST, LAST, MIN, MAX and SUM and defaults to BLOCK.`,
+			Sync:              true,
+			KeyExtractionFunc: tsCreateKeyFunc,
+			HandlerFunc:       handleTSCreate,
+		},
+		{
+			Command:    "ts.add",
+			Module:     constants.TimeSeriesModule,
+			Categories: []string{constants.TimeSeriesCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(TS.ADD key timestamp value [RETENTION retentionPeriod] [DUPLICATE_POLICY policy]
+[ON_DUPLICATE policy] [LABELS label value ...]) Adds a sample to the time series, creating the series with the given
+options if it does not exist. The timestamp is in milliseconds, or * for the current server time.
+ON_DUPLICATE overrides the duplicate policy of the series for this sample. Returns the timestamp of the sample.`,
+			Sync:              true,
+			KeyExtractionFunc: tsAddKeyFunc,
+			HandlerFunc:       handleTSAdd,
+			RewriteFunc:       rewriteTSAdd,
+		},
+		{
+			Command:    "ts.madd",
+			Module:     constants.TimeSeriesModule,
+			Categories: []string{constants.TimeSeriesCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(TS.MADD key timestamp value [key timestamp value ...]) Adds samples to existing time series.
+Returns the timestamp of each sample, or an error for each sample that could not be added.`,
+			Sync:              true,
+			KeyExtractionFunc: tsMAddKeyFunc,
+			HandlerFunc:       handleTSMAdd,
+			RewriteFunc:       rewriteTSMAdd,
+		},
+		{
+			Command:           "ts.get",
+			Module:            constants.TimeSeriesModule,
+			Categories:        []string{constants.TimeSeriesCategory, constants.ReadCategory, constants.FastCategory},
+			Description:       `(TS.GET key) Returns the latest sample of the time series.`,
+			Sync:              false,
+			KeyExtractionFunc: tsGetKeyFunc,
+			HandlerFunc:       handleTSGet,
+		},
+		{
+			Command:    "ts.range",
+			Module:     constants.TimeSeriesModule,
+			Categories: []string{constants.TimeSeriesCategory, constants.ReadCategory, constants.SlowCategory},
+			Description: `(TS.RANGE key fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration])
+Returns the samples between the timestamps in ascending order. - and + are the earliest and latest timestamps.
+AGGREGATION groups the samples into buckets of bucketDuration milliseconds reduced with AVG, MIN, MAX, SUM or COUNT.`,
+			Sync:              false,
+			KeyExtractionFunc: tsRangeKeyFunc,
+			HandlerFunc:       handleRange(false),
+		},
+		{
+			Command:    "ts.revrange",
+			Module:     constants.TimeSeriesModule,
+			Categories: []string{constants.TimeSeriesCategory, constants.ReadCategory, constants.SlowCategory},
+			Description: `(TS.REVRANGE key fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration])
+Returns the samples between the timestamps in descending order.`,
+			Sync:              false,
+			KeyExtractionFunc: tsRangeKeyFunc,
+			HandlerFunc:       handleRange(true),
+		},
+		{
+			Command:    "ts.mrange",
+			Module:     constants.TimeSeriesModule,
+			Categories: []string{constants.TimeSeriesCategory, constants.ReadCategory, constants.SlowCategory},
+			Description: `(TS.MRANGE fromTimestamp toTimestamp [WITHLABELS] [COUNT count]
+[AGGREGATION aggregator bucketDuration] FILTER filter [filter ...]) Returns the samples between the timestamps of
+every time series whose labels match all the filters. Filters have the forms label=value, label!=value,
+label= (label is absent), label!= (label is present), label=(value,...) and label!=(value,...).
+At least one filter must be of the form label=value.`,
+			Sync:              false,
+			KeyExtractionFunc: tsMRangeKeyFunc,
+			HandlerFunc:       handleMRange(false),
+		},
+		{
+			Command:    "ts.mrevrange",
+			Module:     constants.TimeSeriesModule,
+			Categories: []string{constants.TimeSeriesCategory, constants.ReadCategory, constants.SlowCategory},
+			Description: `(TS.MREVRANGE fromTimestamp toTimestamp [WITHLABELS] [COUNT count]
+[AGGREGATION aggregator bucketDuration] FILTER filter [filter ...]) Like TS.MRANGE but returns the samples of each
+time series in descending order.`,
+			Sync:              false,
+			KeyExtractionFunc: tsMRangeKeyFunc,
+			HandlerFunc:       handleMRange(true),
+		},
+		{
+			Command:    "ts.createrule",
+			Module:     constants.TimeSeriesModule,
+			Categories: []string{constants.TimeSeriesCategory, constants.WriteCategory, constants.SlowCategory},
+			Description: `(TS.CREATERULE sourceKey destKey AGGREGATION aggregator bucketDuration) Creates a compaction rule
+that downsamples the source series into the destination series. When a sample for a new bucket is added to the
+source, the previous bucket is aggregated and written to the destination. Both series must exist, and the destination
+cannot be the source or destination of another rule.`,
+			Sync:              true,
+			KeyExtractionFunc: tsCreateRuleKeyFunc,
+			HandlerFunc:       handleTSCreateRule,
+		},
+		{
+			Command:           "ts.deleterule",
+			Module:            constants.TimeSeriesModule,
+			Categories:        []string{constants.TimeSeriesCategory, constants.WriteCategory, constants.FastCategory},
+			Description:       `(TS.DELETERULE sourceKey destKey) Deletes the compaction rule from the source to the destination.`,
+			Sync:              true,
+			KeyExtractionFunc: tsDeleteRuleKeyFunc,
+			HandlerFunc:       handleTSDeleteRule,
+		},
+		{
+			Command:    "ts.info",
+			Module:     constants.TimeSeriesModule,
+			Categories: []string{constants.TimeSeriesCategory, constants.ReadCategory, constants.FastCategory},
+			Description: `(TS.INFO key) Returns the number of samples, memory usage in bytes, first and last timestamps,
+retention, duplicate policy, labels, source key and compaction rules of the time series.`,
+			Sync:              false,
+			KeyExtractionFunc: tsInfoKeyFunc,
+			HandlerFunc:       handleTSInfo,
+		},
+	}
+}
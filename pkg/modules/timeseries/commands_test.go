@@ -0,0 +1,726 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timeseries
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/config"
+	internal_timeseries "github.com/echovault/echovault/internal/timeseries"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/echovault"
+	"github.com/tidwall/resp"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+var mockServer *echovault.EchoVault
+
+func init() {
+	mockServer, _ = echovault.NewEchoVault(
+		echovault.WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+}
+
+// readSamples reads a range response into "timestamp:value" strings.
+func readSamples(t *testing.T, value resp.Value) []string {
+	samples := make([]string, 0)
+	for _, sample := range value.Array() {
+		samples = append(samples, fmt.Sprintf("%d:%s", sample.Array()[0].Integer(), sample.Array()[1].String()))
+	}
+	return samples
+}
+
+func getSeries(t *testing.T, ctx context.Context, key string) *internal_timeseries.TimeSeries {
+	if _, err := mockServer.KeyRLock(ctx, key); err != nil {
+		t.Error(err)
+		return nil
+	}
+	defer mockServer.KeyRUnlock(ctx, key)
+	series, ok := mockServer.GetValue(ctx, key).(*internal_timeseries.TimeSeries)
+	if !ok {
+		t.Errorf("expected time series at key %s", key)
+	}
+	return series
+}
+
+func Test_HandleTSCreate(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name              string
+		command           []string
+		expectedLabels    map[string]string
+		expectedPolicy    string
+		expectedRetention int64
+		expectedError     error
+	}{
+		{
+			name:           "1. Create a time series with the default options",
+			command:        []string{"TS.CREATE", "TsCreateKey1"},
+			expectedLabels: map[string]string{},
+			expectedPolicy: internal_timeseries.DuplicateBlock,
+		},
+		{
+			name: "2. Create a time series with retention, duplicate policy and labels",
+			command: []string{
+				"TS.CREATE", "TsCreateKey2", "RETENTION", "60000", "DUPLICATE_POLICY", "sum",
+				"LABELS", "sensor", "1", "room", "kitchen",
+			},
+			expectedLabels:    map[string]string{"sensor": "1", "room": "kitchen"},
+			expectedPolicy:    internal_timeseries.DuplicateSum,
+			expectedRetention: 60000,
+		},
+		{
+			name:          "3. Return error when the key already exists",
+			command:       []string{"TS.CREATE", "TsCreateKey1"},
+			expectedError: errors.New("key TsCreateKey1 already exists"),
+		},
+		{
+			name:          "4. Return error on unknown duplicate policy",
+			command:       []string{"TS.CREATE", "TsCreateKey3", "DUPLICATE_POLICY", "foo"},
+			expectedError: errors.New("unknown duplicate policy FOO"),
+		},
+		{
+			name:          "5. Return error on odd number of label arguments",
+			command:       []string{"TS.CREATE", "TsCreateKey4", "LABELS", "sensor"},
+			expectedError: errors.New("LABELS must be followed by label value pairs"),
+		},
+		{
+			name:          "6. Command too short",
+			command:       []string{"TS.CREATE"},
+			expectedError: errors.New(constants.WrongArgsResponse),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := handleTSCreate(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			series := getSeries(t, ctx, test.command[1])
+			if series == nil {
+				return
+			}
+			if !reflect.DeepEqual(series.Labels(), test.expectedLabels) {
+				t.Errorf("expected labels %v, got %v", test.expectedLabels, series.Labels())
+			}
+			if series.DuplicatePolicy() != test.expectedPolicy {
+				t.Errorf("expected duplicate policy %s, got %s", test.expectedPolicy, series.DuplicatePolicy())
+			}
+			if series.Retention() != test.expectedRetention {
+				t.Errorf("expected retention %d, got %d", test.expectedRetention, series.Retention())
+			}
+		})
+	}
+}
+
+func Test_HandleTSAdd(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name            string
+		command         []string
+		expectedRes     int
+		expectedSamples []string
+		expectedError   error
+	}{
+		{
+			name:            "1. TS.ADD creates the series on a non-existent key",
+			command:         []string{"TS.ADD", "TsAddKey1", "1000", "1.5"},
+			expectedRes:     1000,
+			expectedSamples: []string{"1000:1.5"},
+		},
+		{
+			name:            "2. TS.ADD inserts out of order samples in timestamp order",
+			command:         []string{"TS.ADD", "TsAddKey1", "500", "3"},
+			expectedRes:     500,
+			expectedSamples: []string{"500:3", "1000:1.5"},
+		},
+		{
+			name:          "3. TS.ADD blocks duplicate timestamps by default",
+			command:       []string{"TS.ADD", "TsAddKey1", "1000", "2"},
+			expectedError: internal_timeseries.ErrDuplicate,
+		},
+		{
+			name:            "4. ON_DUPLICATE overrides the duplicate policy",
+			command:         []string{"TS.ADD", "TsAddKey1", "1000", "2", "ON_DUPLICATE", "SUM"},
+			expectedRes:     1000,
+			expectedSamples: []string{"500:3", "1000:3.5"},
+		},
+		{
+			name:            "5. TS.ADD applies the duplicate policy of the series",
+			command:         []string{"TS.ADD", "TsAddKey2", "10", "5", "DUPLICATE_POLICY", "MAX"},
+			expectedRes:     10,
+			expectedSamples: []string{"10:5"},
+		},
+		{
+			name:            "6. MAX duplicate policy keeps the higher value",
+			command:         []string{"TS.ADD", "TsAddKey2", "10", "4"},
+			expectedRes:     10,
+			expectedSamples: []string{"10:5"},
+		},
+		{
+			name:            "7. Samples older than the retention period are trimmed",
+			command:         []string{"TS.ADD", "TsAddKey3", "100", "1", "RETENTION", "50"},
+			expectedRes:     100,
+			expectedSamples: []string{"100:1"},
+		},
+		{
+			name:            "8. Adding a later sample trims the samples outside the retention period",
+			command:         []string{"TS.ADD", "TsAddKey3", "200", "2"},
+			expectedRes:     200,
+			expectedSamples: []string{"200:2"},
+		},
+		{
+			name:          "9. Return error when the sample is older than the retention period",
+			command:       []string{"TS.ADD", "TsAddKey3", "100", "1"},
+			expectedError: internal_timeseries.ErrTooOld,
+		},
+		{
+			name:          "10. Return error on invalid value",
+			command:       []string{"TS.ADD", "TsAddKey1", "2000", "NaN"},
+			expectedError: errors.New("value must be a finite number"),
+		},
+		{
+			name:          "11. Return error when the key does not hold a time series",
+			command:       []string{"TS.ADD", "TsAddKey4", "1000", "1"},
+			expectedError: errors.New("value at key TsAddKey4 is not a time series"),
+		},
+	}
+
+	if _, err := mockServer.CreateKeyAndLock(ctx, "TsAddKey4"); err != nil {
+		t.Error(err)
+	}
+	if err := mockServer.SetValue(ctx, "TsAddKey4", "value"); err != nil {
+		t.Error(err)
+	}
+	mockServer.KeyUnlock(ctx, "TsAddKey4")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := handleTSAdd(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rv, _, _ := resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+			if rv.Integer() != test.expectedRes {
+				t.Errorf("expected response %d, got %d", test.expectedRes, rv.Integer())
+			}
+			res, err = handleRange(false)(ctx, []string{"TS.RANGE", test.command[1], "-", "+"}, mockServer, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rv, _, _ = resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+			if got := readSamples(t, rv); !reflect.DeepEqual(got, test.expectedSamples) {
+				t.Errorf("expected samples %v, got %v", test.expectedSamples, got)
+			}
+		})
+	}
+}
+
+func Test_HandleTSMAdd(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := handleTSCreate(ctx, []string{"TS.CREATE", "TsMAddKey1"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := handleTSMAdd(ctx, []string{
+		"TS.MADD", "TsMAddKey1", "1", "10", "TsMAddKey2", "1", "10", "TsMAddKey1", "2", "20",
+	}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rv, _, _ := resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+	arr := rv.Array()
+	if len(arr) != 3 {
+		t.Errorf("expected 3 results, got %d", len(arr))
+		return
+	}
+	if arr[0].Integer() != 1 || arr[2].Integer() != 2 {
+		t.Errorf("expected timestamps 1 and 2, got %d and %d", arr[0].Integer(), arr[2].Integer())
+	}
+	if arr[1].Type() != resp.Error || arr[1].Error().Error() != "key TsMAddKey2 does not exist" {
+		t.Errorf("expected error for non-existent key, got %v", arr[1])
+	}
+	if _, err = handleTSMAdd(ctx, []string{"TS.MADD", "TsMAddKey1", "3"}, mockServer, nil); err == nil ||
+		err.Error() != constants.WrongArgsResponse {
+		t.Errorf("expected error \"%s\", got \"%v\"", constants.WrongArgsResponse, err)
+	}
+}
+
+func Test_HandleTSRange(t *testing.T) {
+	ctx := context.Background()
+
+	for i, value := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		if _, err := handleTSAdd(ctx, []string{"TS.ADD", "TsRangeKey1", fmt.Sprintf("%d", i*5), value}, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	tests := []struct {
+		name          string
+		command       []string
+		expected      []string
+		expectedError error
+	}{
+		{
+			name:     "1. Return the samples between the timestamps",
+			command:  []string{"TS.RANGE", "TsRangeKey1", "5", "15"},
+			expected: []string{"5:2", "10:3", "15:4"},
+		},
+		{
+			name:     "2. Return the samples in reverse order with COUNT",
+			command:  []string{"TS.REVRANGE", "TsRangeKey1", "-", "+", "COUNT", "2"},
+			expected: []string{"30:7", "25:6"},
+		},
+		{
+			name:     "3. Aggregate the samples with AVG",
+			command:  []string{"TS.RANGE", "TsRangeKey1", "-", "+", "AGGREGATION", "avg", "10"},
+			expected: []string{"0:1.5", "10:3.5", "20:5.5", "30:7"},
+		},
+		{
+			name:     "4. Aggregate the samples with MIN",
+			command:  []string{"TS.RANGE", "TsRangeKey1", "-", "+", "AGGREGATION", "min", "15"},
+			expected: []string{"0:1", "15:4", "30:7"},
+		},
+		{
+			name:     "5. Aggregate the samples with MAX",
+			command:  []string{"TS.RANGE", "TsRangeKey1", "-", "+", "AGGREGATION", "max", "15"},
+			expected: []string{"0:3", "15:6", "30:7"},
+		},
+		{
+			name:     "6. Aggregate the samples with SUM and COUNT in reverse",
+			command:  []string{"TS.REVRANGE", "TsRangeKey1", "0", "29", "AGGREGATION", "sum", "15", "COUNT", "1"},
+			expected: []string{"15:15"},
+		},
+		{
+			name:     "7. Aggregate the samples with COUNT",
+			command:  []string{"TS.RANGE", "TsRangeKey1", "-", "+", "AGGREGATION", "count", "20"},
+			expected: []string{"0:4", "20:3"},
+		},
+		{
+			name:     "8. Return an empty array when there are no samples in the range",
+			command:  []string{"TS.RANGE", "TsRangeKey1", "100", "+"},
+			expected: []string{},
+		},
+		{
+			name:          "9. Return error on unknown aggregation",
+			command:       []string{"TS.RANGE", "TsRangeKey1", "-", "+", "AGGREGATION", "median", "10"},
+			expectedError: errors.New("unknown aggregation type MEDIAN"),
+		},
+		{
+			name:          "10. Return error on a non-existent key",
+			command:       []string{"TS.RANGE", "TsRangeKey2", "-", "+"},
+			expectedError: errors.New("key TsRangeKey2 does not exist"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := handleRange(test.command[0] == "TS.REVRANGE")(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rv, _, _ := resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+			if got := readSamples(t, rv); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected samples %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func Test_HandleTSMRange(t *testing.T) {
+	ctx := context.Background()
+
+	for _, cmd := range [][]string{
+		{"TS.CREATE", "TsMRangeKey1", "LABELS", "metric", "temperature", "room", "kitchen"},
+		{"TS.CREATE", "TsMRangeKey2", "LABELS", "metric", "temperature", "room", "bedroom"},
+		{"TS.CREATE", "TsMRangeKey3", "LABELS", "metric", "humidity", "room", "kitchen"},
+		{"TS.CREATE", "TsMRangeKey4", "LABELS", "metric", "temperature"},
+	} {
+		if _, err := handleTSCreate(ctx, cmd, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if _, err := handleTSMAdd(ctx, []string{
+		"TS.MADD", "TsMRangeKey1", "10", "20", "TsMRangeKey1", "20", "22",
+		"TsMRangeKey2", "10", "18", "TsMRangeKey3", "10", "40", "TsMRangeKey4", "10", "5",
+	}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		name           string
+		command        []string
+		expectedKeys   []string
+		expectedLabels [][]string
+		expected       [][]string
+		expectedError  error
+	}{
+		{
+			name:         "1. Select series with a label=value filter",
+			command:      []string{"TS.MRANGE", "-", "+", "FILTER", "metric=temperature", "room=kitchen"},
+			expectedKeys: []string{"TsMRangeKey1"},
+			expected:     [][]string{{"10:20", "20:22"}},
+		},
+		{
+			name:         "2. Select series with a list of values and a negated filter",
+			command:      []string{"TS.MREVRANGE", "-", "+", "COUNT", "1", "FILTER", "room=(kitchen,bedroom)", "metric!=humidity"},
+			expectedKeys: []string{"TsMRangeKey1", "TsMRangeKey2"},
+			expected:     [][]string{{"20:22"}, {"10:18"}},
+		},
+		{
+			name:           "3. Select series without a label and include the labels",
+			command:        []string{"TS.MRANGE", "-", "+", "WITHLABELS", "FILTER", "metric=temperature", "room="},
+			expectedKeys:   []string{"TsMRangeKey4"},
+			expectedLabels: [][]string{{"metric", "temperature"}},
+			expected:       [][]string{{"10:5"}},
+		},
+		{
+			name:         "4. Aggregate the samples of each series",
+			command:      []string{"TS.MRANGE", "-", "+", "AGGREGATION", "max", "100", "FILTER", "metric=temperature", "room!="},
+			expectedKeys: []string{"TsMRangeKey1", "TsMRangeKey2"},
+			expected:     [][]string{{"0:22"}, {"0:18"}},
+		},
+		{
+			name:          "5. Return error when there is no label=value filter",
+			command:       []string{"TS.MRANGE", "-", "+", "FILTER", "metric!=humidity"},
+			expectedError: errors.New("at least one filter of the form label=value is required"),
+		},
+		{
+			name:          "6. Return error when FILTER is missing",
+			command:       []string{"TS.MRANGE", "-", "+", "COUNT", "1"},
+			expectedError: errors.New("FILTER is required"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := handleMRange(test.command[0] == "TS.MREVRANGE")(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rv, _, _ := resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+			var keys []string
+			var labels [][]string
+			var samples [][]string
+			for _, entry := range rv.Array() {
+				keys = append(keys, entry.Array()[0].String())
+				for _, label := range entry.Array()[1].Array() {
+					labels = append(labels, []string{label.Array()[0].String(), label.Array()[1].String()})
+				}
+				samples = append(samples, readSamples(t, entry.Array()[2]))
+			}
+			if !reflect.DeepEqual(keys, test.expectedKeys) {
+				t.Errorf("expected keys %v, got %v", test.expectedKeys, keys)
+			}
+			if !reflect.DeepEqual(labels, test.expectedLabels) {
+				t.Errorf("expected labels %v, got %v", test.expectedLabels, labels)
+			}
+			if !reflect.DeepEqual(samples, test.expected) {
+				t.Errorf("expected samples %v, got %v", test.expected, samples)
+			}
+		})
+	}
+}
+
+func Test_HandleTSCompactionRules(t *testing.T) {
+	ctx := context.Background()
+
+	for _, cmd := range [][]string{
+		{"TS.CREATE", "TsRuleSource1"},
+		{"TS.CREATE", "TsRuleAvg1"},
+		{"TS.CREATE", "TsRuleCount1"},
+		{"TS.CREATE", "TsRuleOther1"},
+	} {
+		if _, err := handleTSCreate(ctx, cmd, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	ruleTests := []struct {
+		name          string
+		command       []string
+		expectedError error
+	}{
+		{
+			name:    "1. Create an AVG rule",
+			command: []string{"TS.CREATERULE", "TsRuleSource1", "TsRuleAvg1", "AGGREGATION", "avg", "10"},
+		},
+		{
+			name:    "2. Create a COUNT rule",
+			command: []string{"TS.CREATERULE", "TsRuleSource1", "TsRuleCount1", "AGGREGATION", "count", "20"},
+		},
+		{
+			name:          "3. Return error when the destination already has a source",
+			command:       []string{"TS.CREATERULE", "TsRuleOther1", "TsRuleAvg1", "AGGREGATION", "avg", "10"},
+			expectedError: errors.New("key TsRuleAvg1 is already the destination of a compaction rule"),
+		},
+		{
+			name:          "4. Return error when the source is a destination",
+			command:       []string{"TS.CREATERULE", "TsRuleAvg1", "TsRuleOther1", "AGGREGATION", "avg", "10"},
+			expectedError: errors.New("key TsRuleAvg1 is already the destination of a compaction rule"),
+		},
+		{
+			name:          "5. Return error when the destination is a source",
+			command:       []string{"TS.CREATERULE", "TsRuleOther1", "TsRuleSource1", "AGGREGATION", "avg", "10"},
+			expectedError: errors.New("key TsRuleSource1 is the source of a compaction rule"),
+		},
+		{
+			name:          "6. Return error when the destination does not exist",
+			command:       []string{"TS.CREATERULE", "TsRuleSource1", "TsRuleMissing1", "AGGREGATION", "avg", "10"},
+			expectedError: errors.New("key TsRuleMissing1 does not exist"),
+		},
+	}
+
+	for _, test := range ruleTests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := handleTSCreateRule(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	// Samples 0-9 fill the first AVG bucket, which is compacted when the sample at 12 arrives.
+	for _, sample := range [][]string{{"1", "2"}, {"5", "4"}, {"12", "10"}, {"25", "20"}, {"3", "6"}} {
+		if _, err := handleTSAdd(ctx, []string{"TS.ADD", "TsRuleSource1", sample[0], sample[1]}, mockServer, nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	for key, expected := range map[string][]string{
+		// The late sample at 3 recompacts the first bucket. The bucket at 20 is still open.
+		"TsRuleAvg1":   {"0:4", "10:10"},
+		"TsRuleCount1": {"0:4"},
+	} {
+		res, err := handleRange(false)(ctx, []string{"TS.RANGE", key, "-", "+"}, mockServer, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rv, _, _ := resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+		if got := readSamples(t, rv); !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected compacted samples %v in %s, got %v", expected, key, got)
+		}
+	}
+
+	if _, err := handleTSDeleteRule(ctx, []string{"TS.DELETERULE", "TsRuleSource1", "TsRuleAvg1"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := handleTSDeleteRule(ctx, []string{"TS.DELETERULE", "TsRuleSource1", "TsRuleAvg1"}, mockServer, nil); err == nil ||
+		err.Error() != "compaction rule from TsRuleSource1 to TsRuleAvg1 does not exist" {
+		t.Errorf("expected error deleting a non-existent rule, got %v", err)
+	}
+	if series := getSeries(t, ctx, "TsRuleAvg1"); series != nil && series.SourceKey() != "" {
+		t.Errorf("expected the destination to be released, got source key %s", series.SourceKey())
+	}
+	if series := getSeries(t, ctx, "TsRuleSource1"); series != nil && len(series.Rules()) != 1 {
+		t.Errorf("expected 1 remaining rule, got %d", len(series.Rules()))
+	}
+}
+
+func Test_TimeSeriesInfoAndEncoding(t *testing.T) {
+	ctx := context.Background()
+
+	for _, cmd := range [][]string{
+		{"TS.CREATE", "TsInfoKey1", "RETENTION", "1000", "DUPLICATE_POLICY", "LAST", "LABELS", "a", "1"},
+		{"TS.CREATE", "TsInfoKey2"},
+		{"TS.CREATERULE", "TsInfoKey1", "TsInfoKey2", "AGGREGATION", "sum", "100"},
+		{"TS.ADD", "TsInfoKey1", "100", "1.25"},
+		{"TS.ADD", "TsInfoKey1", "250", "2.5"},
+	} {
+		handler := map[string]func(context.Context, []string, *echovault.EchoVault) ([]byte, error){
+			"TS.CREATE": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+				return handleTSCreate(ctx, cmd, server, nil)
+			},
+			"TS.CREATERULE": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+				return handleTSCreateRule(ctx, cmd, server, nil)
+			},
+			"TS.ADD": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+				return handleTSAdd(ctx, cmd, server, nil)
+			},
+		}[cmd[0]]
+		if _, err := handler(ctx, cmd, mockServer); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	res, err := handleTSInfo(ctx, []string{"TS.INFO", "TsInfoKey1"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rv, _, _ := resp.NewReader(bytes.NewBuffer(res)).ReadValue()
+	info := make(map[string]resp.Value)
+	for i := 0; i < len(rv.Array()); i += 2 {
+		info[rv.Array()[i].String()] = rv.Array()[i+1]
+	}
+	for name, expected := range map[string]int{
+		"totalSamples": 2, "firstTimestamp": 100, "lastTimestamp": 250, "retentionTime": 1000,
+	} {
+		if info[name].Integer() != expected {
+			t.Errorf("expected %s to be %d, got %d", name, expected, info[name].Integer())
+		}
+	}
+	if info["memoryUsage"].Integer() <= 0 {
+		t.Errorf("expected positive memory usage, got %d", info["memoryUsage"].Integer())
+	}
+	if info["duplicatePolicy"].String() != "LAST" || !info["sourceKey"].IsNull() {
+		t.Errorf("expected duplicate policy LAST and no source key, got %s and %s",
+			info["duplicatePolicy"].String(), info["sourceKey"].String())
+	}
+	if rules := info["rules"].Array(); len(rules) != 1 || rules[0].Array()[0].String() != "TsInfoKey2" {
+		t.Errorf("expected a rule into TsInfoKey2, got %v", rules)
+	}
+
+	// The series must survive the JSON encoding used by snapshots and the AOF preamble.
+	series := getSeries(t, ctx, "TsInfoKey1")
+	b, err := json.Marshal(internal.KeyData{Value: series})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var data internal.KeyData
+	if err = json.Unmarshal(b, &data); err != nil {
+		t.Error(err)
+		return
+	}
+	restored, ok := data.Value.(*internal_timeseries.TimeSeries)
+	if !ok {
+		t.Errorf("expected restored value to be a time series, got %T", data.Value)
+		return
+	}
+	if !reflect.DeepEqual(restored.Range(0, 1000, "", 0, 0), series.Range(0, 1000, "", 0, 0)) {
+		t.Errorf("expected restored samples %v, got %v", series.Range(0, 1000, "", 0, 0), restored.Range(0, 1000, "", 0, 0))
+	}
+	if !reflect.DeepEqual(restored.Rules(), series.Rules()) || !reflect.DeepEqual(restored.Labels(), series.Labels()) {
+		t.Errorf("expected restored rules and labels to match")
+	}
+	// The restored rule continues compacting from the open bucket.
+	if compactions, _ := restored.Add(300, 1, ""); len(compactions) != 1 || compactions[0].Sample.Value != 2.5 {
+		t.Errorf("expected compaction of the bucket at 200 with value 2.5, got %v", compactions)
+	}
+}
+
+func Test_RewriteTSAdd(t *testing.T) {
+	now := strconv.FormatInt(mockServer.GetClock().Now().UnixMilli(), 10)
+
+	tests := []struct {
+		name          string
+		command       []string
+		expected      []string
+		expectedError error
+	}{
+		{
+			name:     "1. Replace TS.ADD * timestamp with the current time",
+			command:  []string{"TS.ADD", "key", "*", "1.5", "LABELS", "a", "*"},
+			expected: []string{"TS.ADD", "key", now, "1.5", "LABELS", "a", "*"},
+		},
+		{
+			name:     "2. Leave explicit TS.ADD timestamps unchanged",
+			command:  []string{"TS.ADD", "key", "1000", "1.5"},
+			expected: []string{"TS.ADD", "key", "1000", "1.5"},
+		},
+		{
+			name:     "3. Replace every TS.MADD * timestamp with the current time",
+			command:  []string{"TS.MADD", "key1", "*", "1", "key2", "1000", "2", "key3", "*", "3"},
+			expected: []string{"TS.MADD", "key1", now, "1", "key2", "1000", "2", "key3", now, "3"},
+		},
+		{
+			name:          "4. Return error on wrong number of TS.MADD arguments",
+			command:       []string{"TS.MADD", "key1", "*", "1", "key2"},
+			expectedError: errors.New(constants.WrongArgsResponse),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rewrite := rewriteTSAdd
+			if test.command[0] == "TS.MADD" {
+				rewrite = rewriteTSMAdd
+			}
+			got, err := rewrite(test.command, mockServer)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected command %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
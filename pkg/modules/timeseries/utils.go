@@ -0,0 +1,285 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timeseries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	internal_timeseries "github.com/echovault/echovault/internal/timeseries"
+	"github.com/echovault/echovault/pkg/types"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type seriesOptions struct {
+	retention       int64
+	labels          map[string]string
+	duplicatePolicy string
+	onDuplicate     string // Overrides the duplicate policy for a single TS.ADD.
+}
+
+// parseSeriesOptions parses the RETENTION, DUPLICATE_POLICY and LABELS options of TS.CREATE.
+// If allowOnDuplicate is true, the ON_DUPLICATE option of TS.ADD is also accepted.
+// LABELS consumes the rest of the arguments.
+func parseSeriesOptions(args []string, allowOnDuplicate bool) (seriesOptions, error) {
+	options := seriesOptions{labels: make(map[string]string)}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		if option == "labels" {
+			if len(args[i+1:]) == 0 || len(args[i+1:])%2 != 0 {
+				return seriesOptions{}, errors.New("LABELS must be followed by label value pairs")
+			}
+			for j := i + 1; j < len(args); j += 2 {
+				options.labels[args[j]] = args[j+1]
+			}
+			break
+		}
+		if i+1 >= len(args) {
+			return seriesOptions{}, fmt.Errorf("value required after %s", strings.ToUpper(args[i]))
+		}
+		var err error
+		switch {
+		case option == "retention":
+			if options.retention, err = strconv.ParseInt(args[i+1], 10, 64); err != nil || options.retention < 0 {
+				return seriesOptions{}, errors.New("retention must be a non-negative integer")
+			}
+		case option == "duplicate_policy":
+			if options.duplicatePolicy, err = internal_timeseries.ParseDuplicatePolicy(args[i+1]); err != nil {
+				return seriesOptions{}, err
+			}
+		case option == "on_duplicate" && allowOnDuplicate:
+			if options.onDuplicate, err = internal_timeseries.ParseDuplicatePolicy(args[i+1]); err != nil {
+				return seriesOptions{}, err
+			}
+		default:
+			return seriesOptions{}, fmt.Errorf("unknown option %s", strings.ToUpper(args[i]))
+		}
+		i += 1
+	}
+	return options, nil
+}
+
+type rangeOptions struct {
+	from           int64
+	to             int64
+	count          int
+	aggregation    string
+	bucketDuration int64
+	withLabels     bool
+	filters        []internal_timeseries.Filter
+}
+
+// parseRangeOptions parses the from and to timestamps followed by the COUNT and AGGREGATION options.
+// If multi is true, the WITHLABELS and FILTER options of TS.MRANGE are also accepted.
+// FILTER consumes the rest of the arguments.
+func parseRangeOptions(args []string, multi bool) (rangeOptions, error) {
+	var options rangeOptions
+	var err error
+	if options.from, err = parseRangeBound(args[0]); err != nil {
+		return rangeOptions{}, err
+	}
+	if options.to, err = parseRangeBound(args[1]); err != nil {
+		return rangeOptions{}, err
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); {
+		case option == "count":
+			if i+1 >= len(args) {
+				return rangeOptions{}, errors.New("value required after COUNT")
+			}
+			if options.count, err = strconv.Atoi(args[i+1]); err != nil || options.count <= 0 {
+				return rangeOptions{}, errors.New("count must be a positive integer")
+			}
+			i += 1
+		case option == "aggregation":
+			if i+2 >= len(args) {
+				return rangeOptions{}, errors.New("AGGREGATION must be followed by the aggregation type and bucket duration")
+			}
+			if options.aggregation, err = internal_timeseries.ParseAggregation(args[i+1]); err != nil {
+				return rangeOptions{}, err
+			}
+			if options.bucketDuration, err = parseBucketDuration(args[i+2]); err != nil {
+				return rangeOptions{}, err
+			}
+			i += 2
+		case option == "withlabels" && multi:
+			options.withLabels = true
+		case option == "filter" && multi:
+			if i+1 >= len(args) {
+				return rangeOptions{}, errors.New("FILTER must be followed by at least one filter")
+			}
+			for _, filter := range args[i+1:] {
+				f, err := internal_timeseries.ParseFilter(filter)
+				if err != nil {
+					return rangeOptions{}, err
+				}
+				options.filters = append(options.filters, f)
+			}
+			i = len(args)
+		default:
+			return rangeOptions{}, fmt.Errorf("unknown option %s", strings.ToUpper(args[i]))
+		}
+	}
+
+	if multi && len(options.filters) == 0 {
+		return rangeOptions{}, errors.New("FILTER is required")
+	}
+	return options, nil
+}
+
+// parseTimestamp parses a sample timestamp in milliseconds. "*" is the current server time.
+func parseTimestamp(server types.EchoVault, timestamp string) (int64, error) {
+	if timestamp == "*" {
+		return server.GetClock().Now().UnixMilli(), nil
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || t < 0 {
+		return 0, errors.New("timestamp must be a non-negative integer or *")
+	}
+	return t, nil
+}
+
+// rewriteTSAdd replaces the "*" timestamp of TS.ADD with the current server time,
+// so that replicas and AOF replays add the sample at the same timestamp.
+func rewriteTSAdd(cmd []string, server types.EchoVault) ([]string, error) {
+	if _, err := tsAddKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	rewritten := slices.Clone(cmd)
+	if rewritten[2] == "*" {
+		rewritten[2] = strconv.FormatInt(server.GetClock().Now().UnixMilli(), 10)
+	}
+	return rewritten, nil
+}
+
+// rewriteTSMAdd replaces every "*" timestamp of TS.MADD with the current server time.
+func rewriteTSMAdd(cmd []string, server types.EchoVault) ([]string, error) {
+	if _, err := tsMAddKeyFunc(cmd); err != nil {
+		return nil, err
+	}
+	now := strconv.FormatInt(server.GetClock().Now().UnixMilli(), 10)
+	rewritten := slices.Clone(cmd)
+	for i := 2; i < len(rewritten); i += 3 {
+		if rewritten[i] == "*" {
+			rewritten[i] = now
+		}
+	}
+	return rewritten, nil
+}
+
+// parseRangeBound parses a range timestamp. "-" is the earliest and "+" is the latest possible timestamp.
+func parseRangeBound(bound string) (int64, error) {
+	switch bound {
+	case "-":
+		return math.MinInt64, nil
+	case "+":
+		return math.MaxInt64, nil
+	}
+	t, err := strconv.ParseInt(bound, 10, 64)
+	if err != nil {
+		return 0, errors.New("range timestamps must be integers, - or +")
+	}
+	return t, nil
+}
+
+func parseValue(value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, errors.New("value must be a finite number")
+	}
+	return v, nil
+}
+
+func parseBucketDuration(duration string) (int64, error) {
+	d, err := strconv.ParseInt(duration, 10, 64)
+	if err != nil || d <= 0 {
+		return 0, errors.New("bucket duration must be a positive integer")
+	}
+	return d, nil
+}
+
+func lockSeries(ctx context.Context, server types.EchoVault, key string) (*internal_timeseries.TimeSeries, error) {
+	if !server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	if _, err := server.KeyLock(ctx, key); err != nil {
+		return nil, err
+	}
+	series, ok := server.GetValue(ctx, key).(*internal_timeseries.TimeSeries)
+	if !ok {
+		server.KeyUnlock(ctx, key)
+		return nil, fmt.Errorf("value at key %s is not a time series", key)
+	}
+	return series, nil
+}
+
+func rLockSeries(ctx context.Context, server types.EchoVault, key string) (*internal_timeseries.TimeSeries, error) {
+	if !server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	if _, err := server.KeyRLock(ctx, key); err != nil {
+		return nil, err
+	}
+	series, ok := server.GetValue(ctx, key).(*internal_timeseries.TimeSeries)
+	if !ok {
+		server.KeyRUnlock(ctx, key)
+		return nil, fmt.Errorf("value at key %s is not a time series", key)
+	}
+	return series, nil
+}
+
+// writeCompactions writes the compacted samples to the destinations of the compaction rules.
+// Destinations that no longer exist are skipped. The destination keys are locked while the source is
+// still locked, so rule changes must never wait for a source while holding the lock on its destination.
+func writeCompactions(ctx context.Context, server types.EchoVault, compactions []internal_timeseries.Compaction) {
+	for _, compaction := range compactions {
+		destination, err := lockSeries(ctx, server, compaction.DestKey)
+		if err != nil {
+			continue
+		}
+		_, _ = destination.Add(compaction.Sample.Timestamp, compaction.Sample.Value, internal_timeseries.DuplicateLast)
+		server.KeyUnlock(ctx, compaction.DestKey)
+	}
+}
+
+func formatSample(sample internal_timeseries.Sample) string {
+	return fmt.Sprintf("*2\r\n:%d\r\n+%s\r\n", sample.Timestamp, strconv.FormatFloat(sample.Value, 'f', -1, 64))
+}
+
+func formatSamples(samples []internal_timeseries.Sample) string {
+	res := fmt.Sprintf("*%d\r\n", len(samples))
+	for _, sample := range samples {
+		res += formatSample(sample)
+	}
+	return res
+}
+
+// formatLabels returns the labels as an array of label value pairs in lexicographical order.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	res := fmt.Sprintf("*%d\r\n", len(names))
+	for _, name := range names {
+		res += fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(name), name, len(labels[name]), labels[name])
+	}
+	return res
+}
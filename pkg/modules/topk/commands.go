@@ -0,0 +1,300 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	internal_topk "github.com/echovault/echovault/internal/topk"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/types"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+func lockTopK(ctx context.Context, server types.EchoVault, key string) (*internal_topk.TopK, error) {
+	if !server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	if _, err := server.KeyLock(ctx, key); err != nil {
+		return nil, err
+	}
+	topK, ok := server.GetValue(ctx, key).(*internal_topk.TopK)
+	if !ok {
+		server.KeyUnlock(ctx, key)
+		return nil, fmt.Errorf("value at key %s is not a top-k", key)
+	}
+	return topK, nil
+}
+
+func rLockTopK(ctx context.Context, server types.EchoVault, key string) (*internal_topk.TopK, error) {
+	if !server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s does not exist", key)
+	}
+	if _, err := server.KeyRLock(ctx, key); err != nil {
+		return nil, err
+	}
+	topK, ok := server.GetValue(ctx, key).(*internal_topk.TopK)
+	if !ok {
+		server.KeyRUnlock(ctx, key)
+		return nil, fmt.Errorf("value at key %s is not a top-k", key)
+	}
+	return topK, nil
+}
+
+func handleTopKReserve(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := topkReserveKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	k, err := strconv.ParseUint(cmd[2], 10, 32)
+	if err != nil || k == 0 {
+		return nil, errors.New("topk must be a positive integer")
+	}
+	width, depth, decay := uint64(internal_topk.DefaultWidth), uint64(internal_topk.DefaultDepth), internal_topk.DefaultDecay
+	if len(cmd) == 6 {
+		if width, err = strconv.ParseUint(cmd[3], 10, 32); err != nil || width == 0 {
+			return nil, errors.New("width must be a positive integer")
+		}
+		if depth, err = strconv.ParseUint(cmd[4], 10, 32); err != nil || depth == 0 {
+			return nil, errors.New("depth must be a positive integer")
+		}
+		if decay, err = strconv.ParseFloat(cmd[5], 64); err != nil || decay <= 0 || decay > 1 {
+			return nil, errors.New("decay must be a number between 0 and 1")
+		}
+	}
+
+	if server.KeyExists(ctx, key) {
+		return nil, fmt.Errorf("key %s already exists", key)
+	}
+	if _, err = server.CreateKeyAndLock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+	if err = server.SetValue(ctx, key, internal_topk.NewTopK(uint32(k), uint32(width), uint32(depth), decay)); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func incrBy(topK *internal_topk.TopK, items []string, increments []uint32) []byte {
+	res := fmt.Sprintf("*%d\r\n", len(items))
+	for i, item := range items {
+		if expelled, ok := topK.IncrBy(item, increments[i]); ok {
+			res += fmt.Sprintf("$%d\r\n%s\r\n", len(expelled), expelled)
+		} else {
+			res += "$-1\r\n"
+		}
+	}
+	return []byte(res)
+}
+
+func handleTopKAdd(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := topkAddKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	topK, err := lockTopK(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+
+	increments := make([]uint32, len(cmd[2:]))
+	for i := range increments {
+		increments[i] = 1
+	}
+	return incrBy(topK, cmd[2:], increments), nil
+}
+
+func handleTopKIncrBy(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := topkIncrByKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.WriteKeys[0]
+
+	var items []string
+	var increments []uint32
+	for i := 2; i < len(cmd); i += 2 {
+		increment, err := strconv.ParseUint(cmd[i+1], 10, 32)
+		if err != nil || increment == 0 || increment > math.MaxUint16 {
+			return nil, errors.New("increment must be an integer between 1 and 65535")
+		}
+		items = append(items, cmd[i])
+		increments = append(increments, uint32(increment))
+	}
+
+	topK, err := lockTopK(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyUnlock(ctx, key)
+
+	return incrBy(topK, items, increments), nil
+}
+
+func handleTopKQuery(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := topkQueryKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	topK, err := rLockTopK(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	res := fmt.Sprintf("*%d\r\n", len(cmd[2:]))
+	for _, item := range cmd[2:] {
+		if topK.Query(item) {
+			res += ":1\r\n"
+		} else {
+			res += ":0\r\n"
+		}
+	}
+	return []byte(res), nil
+}
+
+func handleTopKList(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := topkListKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	withCount := false
+	if len(cmd) == 3 {
+		if !strings.EqualFold(cmd[2], "withcount") {
+			return nil, fmt.Errorf("unknown option %s", strings.ToUpper(cmd[2]))
+		}
+		withCount = true
+	}
+
+	topK, err := rLockTopK(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	items := topK.List()
+	length := len(items)
+	if withCount {
+		length *= 2
+	}
+	res := fmt.Sprintf("*%d\r\n", length)
+	for _, item := range items {
+		res += fmt.Sprintf("$%d\r\n%s\r\n", len(item.Item), item.Item)
+		if withCount {
+			res += fmt.Sprintf(":%d\r\n", item.Count)
+		}
+	}
+	return []byte(res), nil
+}
+
+func handleTopKInfo(ctx context.Context, cmd []string, server types.EchoVault, _ *net.Conn) ([]byte, error) {
+	keys, err := topkInfoKeyFunc(cmd)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.ReadKeys[0]
+
+	topK, err := rLockTopK(ctx, server, key)
+	if err != nil {
+		return nil, err
+	}
+	defer server.KeyRUnlock(ctx, key)
+
+	decay := strconv.FormatFloat(topK.Decay(), 'f', -1, 64)
+	res := "*10\r\n"
+	res += fmt.Sprintf("$1\r\nk\r\n:%d\r\n", topK.K())
+	res += fmt.Sprintf("$5\r\nwidth\r\n:%d\r\n", topK.Width())
+	res += fmt.Sprintf("$5\r\ndepth\r\n:%d\r\n", topK.Depth())
+	res += fmt.Sprintf("$5\r\ndecay\r\n$%d\r\n%s\r\n", len(decay), decay)
+	res += fmt.Sprintf("$4\r\nsize\r\n:%d\r\n", topK.Size())
+	return []byte(res), nil
+}
+
+func Commands() []types.Command {
+	return []types.Command{
+		{
+			Command:    "topk.reserve",
+			Module:     constants.TopKModule,
+			Categories: []string{constants.TopKCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(TOPK.RESERVE key topk [width depth decay]) Creates a structure that tracks the topk most frequent items.
+The defaults are a width of 8, a depth of 7 and a decay of 0.9.`,
+			Sync:              true,
+			KeyExtractionFunc: topkReserveKeyFunc,
+			HandlerFunc:       handleTopKReserve,
+		},
+		{
+			Command:    "topk.add",
+			Module:     constants.TopKModule,
+			Categories: []string{constants.TopKCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(TOPK.ADD key item [item ...]) Adds the items to the top-k structure.
+For each item, returns the item that was expelled from the top-k list when the item was added, or nil.`,
+			Sync:              true,
+			KeyExtractionFunc: topkAddKeyFunc,
+			HandlerFunc:       handleTopKAdd,
+		},
+		{
+			Command:    "topk.incrby",
+			Module:     constants.TopKModule,
+			Categories: []string{constants.TopKCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(TOPK.INCRBY key item increment [item increment ...]) Increases the score of the items.
+For each item, returns the item that was expelled from the top-k list, or nil.`,
+			Sync:              true,
+			KeyExtractionFunc: topkIncrByKeyFunc,
+			HandlerFunc:       handleTopKIncrBy,
+		},
+		{
+			Command:           "topk.query",
+			Module:            constants.TopKModule,
+			Categories:        []string{constants.TopKCategory, constants.ReadCategory, constants.FastCategory},
+			Description:       `(TOPK.QUERY key item [item ...]) Returns 1 for each item that is in the top-k list and 0 otherwise.`,
+			Sync:              false,
+			KeyExtractionFunc: topkQueryKeyFunc,
+			HandlerFunc:       handleTopKQuery,
+		},
+		{
+			Command:    "topk.list",
+			Module:     constants.TopKModule,
+			Categories: []string{constants.TopKCategory, constants.ReadCategory, constants.FastCategory},
+			Description: `(TOPK.LIST key [WITHCOUNT]) Returns the items in the top-k list ordered by descending count.
+WITHCOUNT includes the estimated count of each item.`,
+			Sync:              false,
+			KeyExtractionFunc: topkListKeyFunc,
+			HandlerFunc:       handleTopKList,
+		},
+		{
+			Command:           "topk.info",
+			Module:            constants.TopKModule,
+			Categories:        []string{constants.TopKCategory, constants.ReadCategory, constants.FastCategory},
+			Description:       `(TOPK.INFO key) Returns the k, width, depth, decay and memory size in bytes of the top-k structure.`,
+			Sync:              false,
+			KeyExtractionFunc: topkInfoKeyFunc,
+			HandlerFunc:       handleTopKInfo,
+		},
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/echovault/echovault/internal"
+	"github.com/echovault/echovault/internal/config"
+	internal_topk "github.com/echovault/echovault/internal/topk"
+	"github.com/echovault/echovault/pkg/constants"
+	"github.com/echovault/echovault/pkg/echovault"
+	"github.com/tidwall/resp"
+	"reflect"
+	"testing"
+)
+
+var mockServer *echovault.EchoVault
+
+func init() {
+	mockServer, _ = echovault.NewEchoVault(
+		echovault.WithConfig(config.Config{
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+}
+
+// readStrings reads an array response, representing nil elements as "(nil)".
+func readStrings(t *testing.T, res []byte) []string {
+	rd := resp.NewReader(bytes.NewBuffer(res))
+	rv, _, err := rd.ReadValue()
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	var strs []string
+	for _, v := range rv.Array() {
+		if v.IsNull() {
+			strs = append(strs, "(nil)")
+			continue
+		}
+		strs = append(strs, v.String())
+	}
+	return strs
+}
+
+func Test_HandleTopKReserve(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		command       []string
+		expectedError error
+	}{
+		{
+			name:    "1. Reserve a top-k with the default options",
+			command: []string{"TOPK.RESERVE", "TopKReserveKey1", "10"},
+		},
+		{
+			name:    "2. Reserve a top-k with width, depth and decay",
+			command: []string{"TOPK.RESERVE", "TopKReserveKey2", "10", "50", "4", "0.8"},
+		},
+		{
+			name:          "3. Return error when the key already exists",
+			command:       []string{"TOPK.RESERVE", "TopKReserveKey1", "10"},
+			expectedError: errors.New("key TopKReserveKey1 already exists"),
+		},
+		{
+			name:          "4. Return error on invalid decay",
+			command:       []string{"TOPK.RESERVE", "TopKReserveKey3", "10", "50", "4", "1.5"},
+			expectedError: errors.New("decay must be a number between 0 and 1"),
+		},
+		{
+			name:          "5. Return error when only some of the optional arguments are provided",
+			command:       []string{"TOPK.RESERVE", "TopKReserveKey4", "10", "50"},
+			expectedError: errors.New(constants.WrongArgsResponse),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := handleTopKReserve(ctx, test.command, mockServer, nil)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err = mockServer.KeyRLock(ctx, test.command[1]); err != nil {
+				t.Error(err)
+			}
+			defer mockServer.KeyRUnlock(ctx, test.command[1])
+			if _, ok := mockServer.GetValue(ctx, test.command[1]).(*internal_topk.TopK); !ok {
+				t.Errorf("expected top-k at key %s", test.command[1])
+			}
+		})
+	}
+}
+
+func Test_HandleTopKCommands(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := handleTopKReserve(ctx, []string{"TOPK.RESERVE", "TopKKey1", "2", "50", "5", "0.9"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := mockServer.CreateKeyAndLock(ctx, "TopKKey3"); err != nil {
+		t.Error(err)
+	}
+	if err := mockServer.SetValue(ctx, "TopKKey3", "value"); err != nil {
+		t.Error(err)
+	}
+	mockServer.KeyUnlock(ctx, "TopKKey3")
+
+	tests := []struct {
+		name             string
+		command          []string
+		expectedResponse []string
+		expectedError    error
+	}{
+		{
+			name:             "1. TOPK.INCRBY fills the top-k list without expelling items",
+			command:          []string{"TOPK.INCRBY", "TopKKey1", "apple", "10", "banana", "5"},
+			expectedResponse: []string{"(nil)", "(nil)"},
+		},
+		{
+			name:             "2. TOPK.ADD an item that does not enter the top-k list",
+			command:          []string{"TOPK.ADD", "TopKKey1", "cherry"},
+			expectedResponse: []string{"(nil)"},
+		},
+		{
+			name:             "3. TOPK.INCRBY expels the item with the lowest count",
+			command:          []string{"TOPK.INCRBY", "TopKKey1", "cherry", "20"},
+			expectedResponse: []string{"banana"},
+		},
+		{
+			name:             "4. TOPK.QUERY checks whether the items are in the top-k list",
+			command:          []string{"TOPK.QUERY", "TopKKey1", "apple", "banana", "cherry"},
+			expectedResponse: []string{"1", "0", "1"},
+		},
+		{
+			name:             "5. TOPK.LIST returns the items ordered by descending count",
+			command:          []string{"TOPK.LIST", "TopKKey1"},
+			expectedResponse: []string{"cherry", "apple"},
+		},
+		{
+			name:             "6. TOPK.LIST WITHCOUNT includes the counts",
+			command:          []string{"TOPK.LIST", "TopKKey1", "WITHCOUNT"},
+			expectedResponse: []string{"cherry", "21", "apple", "10"},
+		},
+		{
+			name:          "7. TOPK.LIST returns error on unknown option",
+			command:       []string{"TOPK.LIST", "TopKKey1", "FOO"},
+			expectedError: errors.New("unknown option FOO"),
+		},
+		{
+			name:          "8. TOPK.INCRBY returns error on an increment that is out of range",
+			command:       []string{"TOPK.INCRBY", "TopKKey1", "apple", "70000"},
+			expectedError: errors.New("increment must be an integer between 1 and 65535"),
+		},
+		{
+			name:          "9. TOPK.ADD returns error on a non-existent key",
+			command:       []string{"TOPK.ADD", "TopKKey2", "apple"},
+			expectedError: errors.New("key TopKKey2 does not exist"),
+		},
+		{
+			name:          "10. Return error when the key does not hold a top-k",
+			command:       []string{"TOPK.QUERY", "TopKKey3", "apple"},
+			expectedError: errors.New("value at key TopKKey3 is not a top-k"),
+		},
+	}
+
+	handlers := map[string]func(context.Context, []string, *echovault.EchoVault) ([]byte, error){
+		"TOPK.ADD": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleTopKAdd(ctx, cmd, server, nil)
+		},
+		"TOPK.INCRBY": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleTopKIncrBy(ctx, cmd, server, nil)
+		},
+		"TOPK.QUERY": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleTopKQuery(ctx, cmd, server, nil)
+		},
+		"TOPK.LIST": func(ctx context.Context, cmd []string, server *echovault.EchoVault) ([]byte, error) {
+			return handleTopKList(ctx, cmd, server, nil)
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := handlers[test.command[0]](ctx, test.command, mockServer)
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Errorf("expected error \"%v\", got \"%v\"", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := readStrings(t, res); !reflect.DeepEqual(got, test.expectedResponse) {
+				t.Errorf("expected response %v, got %v", test.expectedResponse, got)
+			}
+		})
+	}
+}
+
+func Test_TopKInfoAndEncoding(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := handleTopKReserve(ctx, []string{"TOPK.RESERVE", "TopKInfoKey1", "3", "20", "4", "0.5"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := handleTopKIncrBy(ctx, []string{"TOPK.INCRBY", "TopKInfoKey1", "apple", "9", "banana", "4"}, mockServer, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := handleTopKInfo(ctx, []string{"TOPK.INFO", "TopKInfoKey1"}, mockServer, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := readStrings(t, res)[:8], []string{"k", "3", "width", "20", "depth", "4", "decay", "0.5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected info %v, got %v", want, got)
+	}
+
+	if _, err = mockServer.KeyRLock(ctx, "TopKInfoKey1"); err != nil {
+		t.Error(err)
+		return
+	}
+	topK := mockServer.GetValue(ctx, "TopKInfoKey1").(*internal_topk.TopK)
+	mockServer.KeyRUnlock(ctx, "TopKInfoKey1")
+
+	// The structure must survive the JSON encoding used by snapshots and the AOF preamble.
+	b, err := json.Marshal(internal.KeyData{Value: topK})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var data internal.KeyData
+	if err = json.Unmarshal(b, &data); err != nil {
+		t.Error(err)
+		return
+	}
+	restored, ok := data.Value.(*internal_topk.TopK)
+	if !ok {
+		t.Errorf("expected restored value to be a top-k, got %T", data.Value)
+		return
+	}
+	if !reflect.DeepEqual(restored.List(), topK.List()) {
+		t.Errorf("expected restored list %v, got %v", topK.List(), restored.List())
+	}
+	if restored.K() != 3 || restored.Decay() != 0.5 {
+		t.Errorf("expected k 3 and decay 0.5, got %d and %v", restored.K(), restored.Decay())
+	}
+}
@@ -33,14 +33,168 @@ type EchoVault interface {
 	GetExpiry(ctx context.Context, key string) time.Time
 	SetExpiry(ctx context.Context, key string, expire time.Time, touch bool)
 	RemoveExpiry(key string)
+	SetKeyIdleTime(key string, idleTime time.Duration)
+	SetKeyFrequency(key string, frequency int)
 	DeleteKey(ctx context.Context, key string) error
+	ApplyCommand(ctx context.Context, command []string, lockedKeys []string) ([]byte, error)
 	GetClock() clock.Clock
 	GetAllCommands() []Command
 	GetACL() interface{}
 	GetPubSub() interface{}
+	GetSearchIndexes() interface{}
+	GetTimeSeriesIndex() interface{}
 	TakeSnapshot() error
+	BackgroundSnapshot(schedule bool) (bool, error)
 	RewriteAOF() error
 	GetLatestSnapshotTime() int64
+	SaveRDB(path string) error
+	RestoreRDB(path string) error
+	ListSnapshots() ([]SnapshotInfo, error)
+	RestoreSnapshot(id int64) error
+	GetAOFStatus() (AOFStatus, error)
+	GetPersistenceStatus() PersistenceStatus
+	GetConfigParameters(patterns ...string) map[string]string
+	SetConfigParameters(parameters map[string]string) error
+	CreateBackup(backupType string) (BackupInfo, error)
+	ListBackups() ([]BackupInfo, error)
+	VerifyBackups(id int64) ([]BackupCheck, error)
+	RestoreBackup(id int64) error
+	GetReplicationStatus() ReplicationStatus
+	Replicate(cmd []string) ([]byte, error)
+	PromoteReplica() error
+	GetClusterMembers() ([]ClusterMember, error)
+	PromoteClusterMember(id string) error
+	DemoteClusterMember(id string) error
+	GetClusterInfo() (ClusterInfo, error)
+	GetClusterNodes() ([]ClusterNode, error)
+	ForgetClusterMember(id string) error
+	FailoverCluster(id string) error
+	LeaveCluster() error
+	GetGossipKeys() ([]GossipKey, error)
+	ReloadGossipKeys() error
+}
+
+// SnapshotInfo describes a snapshot taken by the snapshot engine.
+type SnapshotInfo struct {
+	ID   int64  // Unix time in milliseconds when the snapshot was taken
+	Size int64  // Size of the snapshot file in bytes
+	Hash string // Hex encoded digest of the snapshot contents. Empty if the digest was not recorded.
+}
+
+// BackupInfo describes a backup in the backup directory.
+type BackupInfo struct {
+	ID          int64  // Sequence number of the backup, starting at 1
+	Type        string // full, incremental or differential
+	Parent      int64  // ID of the backup that the changes in the backup are relative to. 0 for a full backup.
+	Time        int64  // Unix time in milliseconds when the backup was taken
+	Size        int64  // Size of the backup file in bytes
+	Keys        int64  // Number of keys written to the backup
+	DeletedKeys int64  // Number of deleted keys written to the backup. 0 for a full backup.
+	Checksum    string // Hex encoded SHA-256 digest of the backup file
+}
+
+// BackupCheck is the result of verifying a backup.
+type BackupCheck struct {
+	ID    int64
+	Error string // Empty if the backup is intact
+}
+
+// AOFStatus describes the AOF of a node and its rewrites.
+type AOFStatus struct {
+	RewriteInProgress     bool
+	RewriteStartTime      int64  // Unix time in milliseconds when the rewrite in progress started. 0 if there is none.
+	Rewrites              int64  // Number of rewrites completed since startup
+	AutoRewrites          int64  // Number of completed rewrites that were triggered by the growth of the AOF
+	FailedRewrites        int64  // Number of rewrites that failed since startup
+	LastRewriteTime       int64  // Unix time in milliseconds when the last rewrite finished. 0 if there was none.
+	LastRewriteDuration   int64  // Duration of the last rewrite in milliseconds
+	LastRewriteError      string // Empty if the last rewrite succeeded
+	Size                  int64  // Current size of the AOF files in bytes
+	BaseSize              int64  // Size of the AOF files after the last rewrite, or on startup
+	AutoRewritePercentage uint64 // Growth over the base size that triggers a rewrite. 0 if automatic rewrites are disabled.
+	AutoRewriteMinSize    uint64 // Size below which the AOF is not rewritten automatically
+}
+
+// SnapshotStatus describes the progress and the results of the snapshots of a node.
+// On a cluster node, it covers both the raft snapshots and the exported snapshots.
+type SnapshotStatus struct {
+	InProgress           bool
+	Scheduled            bool   // True if a background snapshot waits for the AOF rewrite in progress to finish
+	StartTime            int64  // Unix time in milliseconds when the snapshot in progress started. 0 if there is none.
+	Saves                int64  // Number of snapshots completed since startup
+	FailedSaves          int64  // Number of snapshots that failed since startup
+	LastSaveTime         int64  // Unix time in milliseconds of the latest snapshot, as returned by LASTSAVE
+	LastSuccessTime      int64  // Unix time in milliseconds when the last successful snapshot finished. 0 if there was none.
+	LastFailureTime      int64  // Unix time in milliseconds when the last failed snapshot finished. 0 if there was none.
+	LastDuration         int64  // Duration of the last snapshot in milliseconds
+	LastSize             int64  // Number of bytes written by the last snapshot
+	LastError            string // Empty if the last snapshot succeeded
+	ChangesSinceLastSave uint64 // Number of keys set or deleted since the start of the last successful snapshot
+}
+
+// PersistenceStatus describes the snapshots and the AOF of a node, as reported by the persistence section of INFO.
+type PersistenceStatus struct {
+	Snapshot   SnapshotStatus
+	AOFEnabled bool
+	AOF        AOFStatus // Empty if the AOF is not enabled
+}
+
+// ReplicationStatus describes the replication from a primary cluster to a replica cluster, as reported by
+// REPLICATION STATUS and the replication section of INFO. The indexes are indexes of the raft log of the primary.
+type ReplicationStatus struct {
+	Role         string // primary, replica or none. A promoted replica is a primary if it has replication targets.
+	Connected    bool   // True while the primary ships entries to the replica
+	Target       string // Address of the replica that the primary ships entries to. Empty on a replica.
+	Checkpoint   uint64 // Index of the last entry applied by the replica
+	PrimaryIndex uint64 // Index of the last entry applied by the primary, as last seen by the node
+	LagEntries   uint64 // Number of entries up to PrimaryIndex that the replica has not applied
+	Lag          int64  // Milliseconds since the replica fell behind the primary. 0 if it is up to date.
+	LastContact  int64  // Unix time in milliseconds of the last exchange between the primary and the replica
+	FullSyncs    int64  // Number of full syncs since startup
+	Syncing      bool   // True while a full sync is in progress
+	LastError    string // Empty if the last exchange succeeded
+}
+
+// ClusterMember describes a server in the raft configuration of the cluster, as reported by CLUSTER MEMBERS.
+type ClusterMember struct {
+	ID          string
+	Address     string // Raft address of the server
+	Suffrage    string // voter, learner or staging. A learner receives the log but does not vote.
+	Leader      bool
+	LastContact int64 // Unix time in milliseconds of the last contact with the server, as seen by the node. 0 if unknown.
+}
+
+// ClusterInfo describes the raft state of a cluster node, as reported by CLUSTER INFO.
+type ClusterInfo struct {
+	State             string // leader, follower, candidate or shutdown
+	Term              uint64
+	LeaderID          string // Empty if the node does not know the leader
+	LeaderAddress     string // Raft address of the leader
+	CommitIndex       uint64
+	AppliedIndex      uint64
+	LastLogIndex      uint64
+	LastSnapshotIndex uint64 // Index of the last log entry in the latest raft snapshot. 0 if there is none.
+	LastSnapshotTerm  uint64
+	LastSnapshotTime  int64 // Unix time in milliseconds of the latest snapshot. 0 if there was none.
+	Voters            int
+	Learners          int
+}
+
+// ClusterNode describes a node in the raft configuration or in the memberlist, as reported by CLUSTER NODES.
+type ClusterNode struct {
+	ID                string
+	RaftAddress       string
+	MemberlistAddress string // Empty if the node is not in the memberlist
+	Suffrage          string // voter, learner or staging. none if the node is not in the raft configuration.
+	Leader            bool
+	Health            string // alive or suspect according to the memberlist. failed if the memberlist lost the node.
+	LastContact       int64  // Unix time in milliseconds of the last raft contact with the node, as seen by the node. 0 if unknown.
+}
+
+// GossipKey describes a key of the keyring that encrypts the memberlist gossip, as reported by CLUSTER KEYRING.
+type GossipKey struct {
+	ID      string // Hex encoded first 8 bytes of the SHA-256 digest of the key
+	Primary bool   // True for the key that encrypts the gossip. Every key decrypts it.
 }
 
 type AccessKeys struct {
@@ -52,6 +206,12 @@ type KeyExtractionFunc func(cmd []string) (AccessKeys, error)
 
 type HandlerFunc func(ctx context.Context, cmd []string, echovault EchoVault, conn *net.Conn) ([]byte, error)
 
+// RewriteFunc returns the form of the command that is executed, replicated and appended to the AOF.
+// It replaces the arguments that depend on the node executing the command, such as the current time,
+// with the values seen by the node that received the command, so that every replica and every AOF
+// replay computes the same result. Rewriting an already rewritten command must not change it.
+type RewriteFunc func(cmd []string, echovault EchoVault) ([]string, error)
+
 type SubCommand struct {
 	Command     string
 	Module      string
@@ -60,6 +220,7 @@ type SubCommand struct {
 	Sync        bool // Specifies if sub-command should be synced across cluster
 	KeyExtractionFunc
 	HandlerFunc
+	RewriteFunc // Optional
 }
 
 type Command struct {
@@ -69,8 +230,10 @@ type Command struct {
 	Description string
 	SubCommands []SubCommand
 	Sync        bool // Specifies if command should be synced across cluster
+	Exclusive   bool // Specifies if the write command must run while no other write command is running
 	KeyExtractionFunc
 	HandlerFunc
+	RewriteFunc // Optional
 }
 
 type ACL interface {